@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	util_log "github.com/cortexproject/cortex/pkg/util/log"
+
+	"objectstorage/pkg/tools/bench"
+	"objectstorage/pkg/tools/blocks"
+	"objectstorage/pkg/tools/dlq"
+	"objectstorage/pkg/tools/report"
+	"objectstorage/pkg/tools/smoketest"
+)
+
+// command is a tool run instead of the service, as `<group> <name> [flags] [args]`, or as
+// `<name> [flags] [args]` for the commands registered alone in their group, with an empty name.
+type command interface {
+	RegisterFlags(f *flag.FlagSet)
+	Run(ctx context.Context, args []string, out io.Writer) error
+}
+
+// commands are the tools by group and name. A new command is made for each run, so that
+// its flags are registered on a fresh flag set.
+var commands = map[string]map[string]func() command{
+	"bench": {
+		"push": func() command { return &bench.PushCommand{} },
+	},
+	"blocks": {
+		"inspect":   func() command { return &blocks.InspectCommand{} },
+		"dump":      func() command { return &blocks.DumpCommand{Logger: util_log.Logger} },
+		"compact":   func() command { return &blocks.CompactCommand{Logger: util_log.Logger} },
+		"anonymize": func() command { return &blocks.AnonymizeCommand{Logger: util_log.Logger} },
+	},
+	"dlq": {
+		"replay": func() command { return &dlq.ReplayCommand{Logger: util_log.Logger} },
+	},
+	"report": {
+		"usage": func() command { return &report.UsageCommand{Logger: util_log.Logger} },
+	},
+	"smoke-test": {
+		"": func() command { return &smoketest.SmokeTestCommand{Logger: util_log.Logger} },
+	},
+}
+
+// runCommand runs the command named by the first arguments, if any, and returns the exit
+// code of the process. ok is false if the arguments don't name a command group.
+func runCommand(args []string) (code int, ok bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	group, ok := commands[args[0]]
+	if !ok {
+		return 0, false
+	}
+
+	name, newCmd, flags := args[0], group[""], args[1:]
+	if newCmd == nil {
+		if len(args) < 2 || group[args[1]] == nil {
+			fmt.Fprintf(os.Stderr, "Usage: %s %s <command> [flags] [args]\nCommands: %v\n", os.Args[0], args[0], commandNames(group))
+			return 2, true
+		}
+		name, newCmd, flags = args[0]+" "+args[1], group[args[1]], args[2:]
+	}
+
+	cmd := newCmd()
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	cmd.RegisterFlags(fs)
+	if err := fs.Parse(flags); err != nil {
+		return 2, true
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := cmd.Run(ctx, fs.Args(), os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 1, true
+	}
+	return 0, true
+}
+
+func commandNames(group map[string]func() command) []string {
+	names := make([]string, 0, len(group))
+	for name := range group {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/cortexproject/cortex/pkg/cortex"
+	"github.com/cortexproject/cortex/pkg/ingester"
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+
+	"objectstorage/pkg/dryrun"
+)
+
+// runDryRun builds the storage and KV clients from the loaded config, simulates the ring
+// join and runs the preflight checks, printing to out the actions it would take without
+// writing to the bucket or the KV store. It fails if any check fails.
+func runDryRun(ctx context.Context, cfg *cortex.Config, out io.Writer, logger log.Logger) error {
+	rec := dryrun.NewRecorder(out)
+	var failed []string
+	check := func(name string, err error) {
+		if err != nil {
+			failed = append(failed, name)
+			fmt.Fprintf(out, "[dry-run] check %s: FAILED: %v\n", name, err)
+			return
+		}
+		fmt.Fprintf(out, "[dry-run] check %s: OK\n", name)
+	}
+
+	fmt.Fprintf(out, "[dry-run] config loaded, targets: %s\n", strings.Join(cfg.Target, ","))
+
+	bkt, err := bucket.NewClient(ctx, cfg.BlocksStorage.Bucket, "dry-run", logger, nil)
+	check("bucket client", err)
+	if err == nil {
+		check("bucket access", dryrun.CheckBucket(ctx, dryrun.Bucket(bkt, rec)))
+	}
+	check("tsdb dir", dryrun.CheckDir(cfg.BlocksStorage.TSDB.Dir))
+
+	lc := cfg.Ingester.LifecyclerConfig
+	addr, err := ring.GetInstanceAddr(lc.Addr, lc.InfNames, logger)
+	check("instance address", err)
+	if err == nil {
+		addr = fmt.Sprintf("%s:%d", addr, ring.GetInstancePort(lc.Port, cfg.Server.GRPCListenPort))
+		check("ring join", simulateRingJoin(ctx, cfg, rec, addr, out, logger))
+	}
+
+	fmt.Fprintf(out, "[dry-run] %d actions skipped\n", rec.Actions())
+	if len(failed) > 0 {
+		return errors.Errorf("failed checks: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func simulateRingJoin(ctx context.Context, cfg *cortex.Config, rec *dryrun.Recorder, addr string, out io.Writer, logger log.Logger) error {
+	lc := cfg.Ingester.LifecyclerConfig
+	kvCfg := lc.RingConfig.KVStore
+	// The memberlist client gossips its state as soon as it joins the cluster, which the
+	// dry-run must not do.
+	if kvCfg.Store == "memberlist" {
+		rec.Record("ring", "join the memberlist cluster %v as %s", cfg.MemberlistKV.JoinMembers, lc.ID)
+		return nil
+	}
+
+	client, err := kv.NewClient(kvCfg, ring.GetCodec(), nil, logger)
+	if err != nil {
+		return errors.Wrap(err, "create KV client")
+	}
+	join, err := dryrun.SimulateRingJoin(ctx, dryrun.KV(client, rec), ingester.RingKey, lc.ID, addr, lc.Zone, lc.NumTokens)
+	if err != nil {
+		return err
+	}
+	if join.Registered {
+		fmt.Fprintf(out, "[dry-run] ring: %s is already registered as %s among %d instances, taking %d new tokens\n", lc.ID, join.State, join.Instances, join.NewTokens)
+	} else {
+		fmt.Fprintf(out, "[dry-run] ring: %s would join %d instances at %s as %s with %d tokens\n", lc.ID, join.Instances, addr, join.State, join.NewTokens)
+	}
+	return nil
+}
@@ -24,6 +24,9 @@ import (
 
 	"github.com/cortexproject/cortex/pkg/cortex"
 	"github.com/cortexproject/cortex/pkg/util/flagext"
+
+	"objectstorage/pkg/util/logging"
+	"objectstorage/pkg/util/redact"
 )
 
 const (
@@ -52,8 +55,18 @@ func main() {
 		blockProfileRate     int
 		printVersion         bool
 		printModules         bool
+		dryRun               bool
+		logFile              logging.FileConfig
 	)
 
+	// Errors are logged with the bootstrap logger until the log flags are parsed.
+	util_log.Logger = logging.NewBootstrapLogger()
+
+	// The tools are run instead of the service, with their own flags.
+	if code, ok := runCommand(os.Args[1:]); ok {
+		os.Exit(code)
+	}
+
 	configFile, expandENV := parseConfigFileParameter(os.Args[1:])
 
 	// This sets default values from flags to the config.
@@ -62,7 +75,7 @@ func main() {
 
 	if configFile != "" {
 		if err := LoadConfig(configFile, expandENV, &cfg); err != nil {
-			fmt.Fprintf(os.Stderr, "error loading config from %s: %v\n", configFile, err)
+			level.Error(util_log.Logger).Log("msg", "error loading config", "file", configFile, "err", err)
 			if testMode {
 				return
 			}
@@ -79,6 +92,8 @@ func main() {
 	flag.IntVar(&blockProfileRate, "debug.block-profile-rate", 0, "Fraction of goroutine blocking events that are reported in the blocking profile. 1 to include every blocking event in the profile, 0 to disable.")
 	flag.BoolVar(&printVersion, "version", false, "Print Cortex version and exit.")
 	flag.BoolVar(&printModules, "modules", false, "List available values that can be used as target.")
+	flag.BoolVar(&dryRun, "dry-run", false, "Load the config, build the storage clients, simulate the ring join and run the preflight checks, printing the actions that would be taken, then exit. Nothing is written to the bucket or the KV store.")
+	logFile.RegisterFlags(flag.CommandLine)
 
 	usage := flag.CommandLine.Usage
 	flag.CommandLine.Usage = func() { /* don't do anything by default, we will print usage ourselves, but only when requested. */ }
@@ -104,15 +119,39 @@ func main() {
 		return
 	}
 
+	// The -log.level, -log.format and -log.file.* flags are now known.
+	logCloser, err := logging.InitLogger(&cfg.Server, logFile)
+	if err != nil {
+		level.Error(util_log.Logger).Log("msg", "error initializing the logger", "err", err)
+		if !testMode {
+			os.Exit(1)
+		}
+	} else {
+		defer logCloser.Close() // nolint:errcheck
+
+		// SIGUSR1 toggles the debug level, to capture debug logs without a restart.
+		stopSignals := make(chan struct{})
+		defer close(stopSignals)
+		go logging.Level().HandleSignals(stopSignals)
+	}
+
 	// Validate the config once both the config file has been loaded
 	// and CLI flags parsed.
 	err = cfg.Validate(util_log.Logger)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error validating config: %v\n", err)
+		level.Error(util_log.Logger).Log("msg", "error validating config", "err", err)
 		if !testMode {
 			os.Exit(1)
 		}
 	}
+	if dryRun {
+		if err := runDryRun(context.Background(), &cfg, os.Stdout, util_log.Logger); err != nil {
+			level.Error(util_log.Logger).Log("msg", "dry-run failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Continue on if -modules flag is given. Code handling the
 	// -modules flag will not start cortex.
 	if testMode && !printModules {
@@ -127,8 +166,6 @@ func main() {
 		runtime.SetBlockProfileRate(blockProfileRate)
 	}
 
-	util_log.InitLogger(&cfg.Server)
-
 	// Allocate a block of memory to alter GC behaviour. See https://github.com/golang/go/issues/23044
 	ballast := make([]byte, ballastBytes)
 
@@ -247,9 +284,9 @@ func expandEnv(config []byte) []byte {
 }
 
 func DumpYaml(cfg *cortex.Config) {
-	out, err := yaml.Marshal(cfg)
+	out, err := redact.YAML(cfg)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		level.Error(util_log.Logger).Log("msg", "error dumping config", "err", err)
 	} else {
 		fmt.Printf("%s\n", out)
 	}
@@ -0,0 +1,165 @@
+package admin
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"flag"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/felixge/fgprof"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/audit"
+	"objectstorage/pkg/util/flagext"
+)
+
+var errMissingCredentials = errors.New("the admin listener requires a username and a password")
+
+// Config holds the configuration of the admin listener, serving the profiling endpoints.
+type Config struct {
+	ListenAddress   string         `yaml:"listen_address"`
+	Username        string         `yaml:"username"`
+	Password        flagext.Secret `yaml:"password"`
+	ShutdownTimeout time.Duration  `yaml:"shutdown_timeout"`
+}
+
+// RegisterFlags registers the admin listener flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.ListenAddress, "admin.listen-address", "", "Address of the admin listener serving the pprof and fgprof profiling endpoints, for example 127.0.0.1:9180. If set, the profiling endpoints are not served on the main HTTP listener anymore. Empty to disable.")
+	f.StringVar(&cfg.Username, "admin.username", "", "Basic auth username required by the admin listener.")
+	f.Var(&cfg.Password, "admin.password", "Basic auth password required by the admin listener.")
+	f.DurationVar(&cfg.ShutdownTimeout, "admin.shutdown-timeout", 5*time.Second, "Time to wait for the in-flight profiles to complete on shutdown.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if cfg.Enabled() && (cfg.Username == "" || cfg.Password.Value == "") {
+		return errMissingCredentials
+	}
+	return nil
+}
+
+// Enabled returns whether the admin listener is enabled.
+func (cfg *Config) Enabled() bool {
+	return cfg.ListenAddress != ""
+}
+
+// Server serves the profiling endpoints on the admin listener, behind basic auth, so that
+// CPU, heap and goroutine profiles can be captured in production without exposing them on
+// the public listener.
+type Server struct {
+	services.Service
+
+	cfg    Config
+	logger log.Logger
+
+	mux      *http.ServeMux
+	listener net.Listener
+	server   *http.Server
+
+	authFailures prometheus.Counter
+}
+
+// NewServer makes a new Server.
+func NewServer(cfg Config, logger log.Logger, reg prometheus.Registerer) *Server {
+	s := &Server{
+		cfg:    cfg,
+		logger: logger,
+		mux:    http.NewServeMux(),
+		authFailures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_admin_auth_failures_total",
+			Help: "Total number of requests to the admin listener refused because of invalid credentials.",
+		}),
+	}
+	s.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	s.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	s.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	s.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	s.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	s.mux.Handle("/debug/fgprof", fgprof.Handler())
+
+	// The write timeout is not set, since CPU profiles and traces last for the requested duration.
+	s.server = &http.Server{Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	s.Service = services.NewBasicService(s.starting, s.running, s.stopping)
+	return s
+}
+
+// Handle registers an additional endpoint on the admin listener, behind the same basic auth
+// as the profiling endpoints. It must be called before the server is started.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Handler returns the handler of the admin endpoints, requiring basic auth.
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authenticate(r) {
+			s.authFailures.Inc()
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		// The audit records the admin user as the actor of the requests.
+		username, _, _ := r.BasicAuth()
+		s.mux.ServeHTTP(w, r.WithContext(audit.InjectActor(r.Context(), username)))
+	})
+}
+
+func (s *Server) authenticate(r *http.Request) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	// The digests are compared, so that the comparison time doesn't depend on the length.
+	usernameOK := subtle.ConstantTimeCompare(digest(username), digest(s.cfg.Username)) == 1
+	passwordOK := subtle.ConstantTimeCompare(digest(password), digest(s.cfg.Password.Value)) == 1
+	return usernameOK && passwordOK
+}
+
+func (s *Server) starting(_ context.Context) error {
+	var err error
+	s.listener, err = net.Listen("tcp", s.cfg.ListenAddress)
+	if err != nil {
+		return errors.Wrap(err, "listen on the admin address")
+	}
+
+	level.Info(s.logger).Log("msg", "admin listener started", "addr", s.listener.Addr().String())
+	return nil
+}
+
+func (s *Server) running(ctx context.Context) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.server.Serve(s.listener)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errs:
+		return errors.Wrap(err, "admin listener")
+	}
+}
+
+func (s *Server) stopping(_ error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+
+	return s.server.Shutdown(ctx)
+}
+
+func digest(s string) []byte {
+	sum := sha256.Sum256([]byte(s))
+	return sum[:]
+}
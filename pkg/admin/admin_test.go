@@ -0,0 +1,96 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	"objectstorage/pkg/util/flagext"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		cfg      Config
+		expected error
+	}{
+		"disabled": {
+			cfg:      Config{},
+			expected: nil,
+		},
+		"enabled with credentials": {
+			cfg:      Config{ListenAddress: "127.0.0.1:9180", Username: "admin", Password: flagext.Secret{Value: "secret"}},
+			expected: nil,
+		},
+		"enabled without password": {
+			cfg:      Config{ListenAddress: "127.0.0.1:9180", Username: "admin"},
+			expected: errMissingCredentials,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, tc.cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+func TestServer_Handler(t *testing.T) {
+	s := NewServer(Config{ListenAddress: "127.0.0.1:0", Username: "admin", Password: flagext.Secret{Value: "secret"}}, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+
+	tests := map[string]struct {
+		username     string
+		password     string
+		noAuth       bool
+		expectedCode int
+	}{
+		"valid credentials": {
+			username:     "admin",
+			password:     "secret",
+			expectedCode: http.StatusOK,
+		},
+		"invalid password": {
+			username:     "admin",
+			password:     "wrong",
+			expectedCode: http.StatusUnauthorized,
+		},
+		"no credentials": {
+			noAuth:       true,
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/debug/pprof/goroutine?debug=1", nil)
+			if !tc.noAuth {
+				req.SetBasicAuth(tc.username, tc.password)
+			}
+
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, req)
+			assert.Equal(t, tc.expectedCode, rec.Code)
+		})
+	}
+}
+
+func TestServer_Handle(t *testing.T) {
+	s := NewServer(Config{ListenAddress: "127.0.0.1:0", Username: "admin", Password: flagext.Secret{Value: "secret"}}, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	s.Handle("/custom", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	// The additional endpoints require the same credentials.
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/custom", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/custom", nil)
+	req.SetBasicAuth("admin", "secret")
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+}
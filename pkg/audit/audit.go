@@ -0,0 +1,285 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/user"
+)
+
+// Outcomes of the audited operations.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+var errBrokenChain = errors.New("audit log hash chain is broken")
+
+// Config holds the configuration of the audit log.
+type Config struct {
+	Enabled              bool          `yaml:"enabled"`
+	File                 string        `yaml:"file"`
+	OverridesCheckPeriod time.Duration `yaml:"overrides_check_period"`
+}
+
+// RegisterFlags registers the audit log flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "audit.enabled", false, "True to log an audit record for every administrative operation, like ring changes, tenant deletions, read-only mode changes and overrides reloads.")
+	f.StringVar(&cfg.File, "audit.file", "", "File the audit records are appended to, one JSON record per line. Empty to write the audit records to the process log.")
+	f.DurationVar(&cfg.OverridesCheckPeriod, "audit.overrides-check-period", 10*time.Second, "How frequently to check the reloaded per-tenant overrides for changes to audit.")
+}
+
+// Record is an audit record. Each record includes the hash of the previous one, so that
+// removing or changing a record breaks the chain of the following ones.
+type Record struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	Actor      string    `json:"actor"`
+	Tenant     string    `json:"tenant,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Target     string    `json:"target,omitempty"`
+	Result     string    `json:"result"`
+	Status     int       `json:"status,omitempty"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+// computeHash returns the hash of the record, covering all fields but the hash itself.
+func (r Record) computeHash() string {
+	r.Hash = ""
+	data, _ := json.Marshal(r)
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Logger logs the audit records, either to a dedicated file or to the process log.
+type Logger struct {
+	logger log.Logger
+
+	mtx      sync.Mutex
+	out      io.WriteCloser
+	lastHash string
+
+	records *prometheus.CounterVec
+	failed  prometheus.Counter
+}
+
+// NewLogger makes a new Logger. When writing to a file, the hash chain continues from the
+// last record already in the file.
+func NewLogger(cfg Config, logger log.Logger, reg prometheus.Registerer) (*Logger, error) {
+	l := &Logger{
+		logger: logger,
+		records: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_audit_records_total",
+			Help: "Total number of audit records logged, by action and result.",
+		}, []string{"action", "result"}),
+		failed: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_audit_records_failed_total",
+			Help: "Total number of audit records which failed to be written.",
+		}),
+	}
+
+	if cfg.File != "" {
+		lastHash, err := lastRecordHash(cfg.File)
+		if err != nil {
+			return nil, errors.Wrap(err, "read audit log")
+		}
+
+		out, err := os.OpenFile(cfg.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, errors.Wrap(err, "open audit log")
+		}
+
+		l.out = out
+		l.lastHash = lastHash
+	}
+
+	return l, nil
+}
+
+// Log logs the audit record, filling its time and hashes.
+func (l *Logger) Log(rec Record) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if rec.Time.IsZero() {
+		rec.Time = time.Now().UTC()
+	}
+	rec.PrevHash = l.lastHash
+	rec.Hash = rec.computeHash()
+	l.records.WithLabelValues(rec.Action, rec.Result).Inc()
+
+	if l.out == nil {
+		l.lastHash = rec.Hash
+		level.Info(l.logger).Log("msg", "audit", "action", rec.Action, "actor", rec.Actor, "tenant", rec.Tenant,
+			"remote_addr", rec.RemoteAddr, "target", rec.Target, "result", rec.Result, "status", rec.Status,
+			"prev_hash", rec.PrevHash, "hash", rec.Hash)
+		return
+	}
+
+	data, err := json.Marshal(rec)
+	if err == nil {
+		_, err = l.out.Write(append(data, '\n'))
+	}
+	if err != nil {
+		// Keep the chain anchored to the last record actually written.
+		l.failed.Inc()
+		level.Error(l.logger).Log("msg", "failed to write audit record", "action", rec.Action, "err", err)
+		return
+	}
+	l.lastHash = rec.Hash
+}
+
+// Close closes the audit log file, if any.
+func (l *Logger) Close() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.out == nil {
+		return nil
+	}
+	return l.out.Close()
+}
+
+// Wrap returns a handler logging an audit record for each request changing the state via
+// the input handler. Read-only requests are not audited. The handler must be wrapped by
+// the authentication middleware, for the actor to be known, else the requests are logged
+// as unauthenticated.
+func (l *Logger) Wrap(action string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		result := ResultSuccess
+		if rec.status >= 400 {
+			result = ResultFailure
+		}
+
+		// The tenant is only known once authenticated, never from the raw header.
+		tenantID, _ := user.ExtractOrgID(r.Context())
+
+		l.Log(Record{
+			Action:     action,
+			Actor:      actor(r),
+			Tenant:     tenantID,
+			RemoteAddr: r.RemoteAddr,
+			Target:     fmt.Sprintf("%s %s", r.Method, r.URL.RequestURI()),
+			Result:     result,
+			Status:     rec.status,
+		})
+	})
+}
+
+type contextKey int
+
+const actorContextKey contextKey = 0
+
+// InjectActor returns a context carrying the actor authenticated by other means than the
+// tenant, like the admin listener credentials.
+func InjectActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorContextKey, actor)
+}
+
+// actor returns who sent the request: the actor injected in the context, the subject of
+// the verified client certificate or the authenticated tenant, in this order. The
+// credentials and headers of the request are never trusted as is.
+func actor(r *http.Request) string {
+	if name, ok := r.Context().Value(actorContextKey).(string); ok && name != "" {
+		return name
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		return r.TLS.VerifiedChains[0][0].Subject.String()
+	}
+	if tenantID, err := user.ExtractOrgID(r.Context()); err == nil {
+		return tenantID
+	}
+	return "unauthenticated"
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Verify checks the hash chain of the audit records read from r, as written to the audit
+// log file, and returns the number of verified records.
+func Verify(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	count := 0
+	lastHash := ""
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		rec := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return count, errors.Wrapf(err, "decode audit record %d", count+1)
+		}
+		if rec.PrevHash != lastHash || rec.Hash != rec.computeHash() {
+			return count, errors.Wrapf(errBrokenChain, "at record %d", count+1)
+		}
+
+		lastHash = rec.Hash
+		count++
+	}
+	return count, scanner.Err()
+}
+
+// lastRecordHash returns the hash of the last record in the audit log file, or an empty
+// string if the file doesn't exist or is empty.
+func lastRecordHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	lastHash := ""
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		rec := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return "", err
+		}
+		lastHash = rec.Hash
+	}
+	return lastHash, scanner.Err()
+}
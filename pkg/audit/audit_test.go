@@ -0,0 +1,169 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+)
+
+func TestLogger_ShouldWriteAVerifiableHashChain(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+
+	l, err := NewLogger(Config{File: file}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	l.Log(Record{Action: "ring_forget", Actor: "admin", Result: ResultSuccess})
+	l.Log(Record{Action: "tenant_delete", Actor: "admin", Tenant: "user-1", Result: ResultFailure})
+	require.NoError(t, l.Close())
+
+	// The chain continues across restarts.
+	l, err = NewLogger(Config{File: file}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	l.Log(Record{Action: "read_only", Actor: "admin", Result: ResultSuccess})
+	require.NoError(t, l.Close())
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+
+	count, err := Verify(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// Tampering with a record breaks the chain.
+	tampered := strings.Replace(string(data), `"tenant":"user-1"`, `"tenant":"user-2"`, 1)
+	count, err = Verify(strings.NewReader(tampered))
+	assert.ErrorIs(t, err, errBrokenChain)
+	assert.Equal(t, 1, count)
+
+	// Removing a record breaks the chain too.
+	lines := strings.SplitAfter(string(data), "\n")
+	count, err = Verify(strings.NewReader(lines[0] + lines[2]))
+	assert.ErrorIs(t, err, errBrokenChain)
+	assert.Equal(t, 1, count)
+}
+
+func TestLogger_Wrap(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	l, err := NewLogger(Config{File: file}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	handler := l.Wrap("tenant_delete", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			http.Error(w, "failed", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	for _, target := range []string{"/delete", "/delete?fail=true"} {
+		req := httptest.NewRequest(http.MethodPost, target, nil)
+		req.SetBasicAuth("admin", "secret")
+		req = req.WithContext(InjectActor(user.InjectOrgID(req.Context(), "user-1"), "admin"))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	// Read-only requests are not audited.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/delete", nil))
+	require.NoError(t, l.Close())
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	count, err := Verify(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	assert.Contains(t, string(data), `"action":"tenant_delete","actor":"admin","tenant":"user-1"`)
+	assert.Contains(t, string(data), `"result":"success","status":202`)
+	assert.Contains(t, string(data), `"result":"failure","status":500`)
+	assert.NotContains(t, string(data), "secret")
+}
+
+func TestActor(t *testing.T) {
+	verified := &x509.Certificate{Subject: pkix.Name{CommonName: "operator"}}
+	unverified := &x509.Certificate{Subject: pkix.Name{CommonName: "impostor"}}
+
+	for name, tc := range map[string]struct {
+		setup    func(r *http.Request) *http.Request
+		expected string
+	}{
+		"injected actor": {
+			setup: func(r *http.Request) *http.Request {
+				return r.WithContext(InjectActor(user.InjectOrgID(r.Context(), "user-1"), "admin"))
+			},
+			expected: "admin",
+		},
+		"verified client certificate": {
+			setup: func(r *http.Request) *http.Request {
+				r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{verified}, VerifiedChains: [][]*x509.Certificate{{verified}}}
+				return r
+			},
+			expected: "CN=operator",
+		},
+		"authenticated tenant": {
+			setup: func(r *http.Request) *http.Request {
+				return r.WithContext(user.InjectOrgID(r.Context(), "user-1"))
+			},
+			expected: "user-1",
+		},
+		"unverified credentials": {
+			setup: func(r *http.Request) *http.Request {
+				r.SetBasicAuth("admin", "secret")
+				r.Header.Set(user.OrgIDHeaderName, "user-1")
+				r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{unverified}}
+				return r
+			},
+			expected: "unauthenticated",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, actor(tc.setup(httptest.NewRequest(http.MethodPost, "/", nil))))
+		})
+	}
+}
+
+func TestOverridesWatcher(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	l, err := NewLogger(Config{File: file}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	overrides := map[string]interface{}{
+		"user-1": map[string]int{"ingestion_rate": 10},
+		"user-2": map[string]int{"ingestion_rate": 10},
+	}
+	w := NewOverridesWatcher(time.Minute, func() map[string]interface{} { return overrides }, l)
+	require.NoError(t, w.starting(context.Background()))
+	require.NoError(t, w.check(context.Background()))
+
+	overrides = map[string]interface{}{
+		"user-1": map[string]int{"ingestion_rate": 20},
+		"user-3": map[string]int{"ingestion_rate": 10},
+	}
+	require.NoError(t, w.check(context.Background()))
+	require.NoError(t, l.Close())
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	count, err := Verify(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	for _, expected := range []string{
+		`"tenant":"user-1","target":"changed"`,
+		`"tenant":"user-2","target":"removed"`,
+		`"tenant":"user-3","target":"added"`,
+	} {
+		assert.Contains(t, string(data), expected)
+	}
+}
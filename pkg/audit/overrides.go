@@ -0,0 +1,96 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+// Actions of the audited overrides changes.
+const (
+	ActionOverridesChange = "overrides_change"
+
+	overridesActor = "runtime-config"
+)
+
+// OverridesSnapshot returns the current per-tenant overrides, by tenant.
+type OverridesSnapshot func() map[string]interface{}
+
+// OverridesWatcher logs an audit record for each tenant whose overrides are added, changed
+// or removed by a runtime config reload. The reloads don't carry who made the change, which
+// must be tracked in the runtime config source itself.
+type OverridesWatcher struct {
+	services.Service
+
+	logger   *Logger
+	snapshot OverridesSnapshot
+	hashes   map[string]string
+}
+
+// NewOverridesWatcher makes a new OverridesWatcher.
+func NewOverridesWatcher(period time.Duration, snapshot OverridesSnapshot, logger *Logger) *OverridesWatcher {
+	w := &OverridesWatcher{
+		logger:   logger,
+		snapshot: snapshot,
+	}
+
+	w.Service = services.NewTimerService(period, w.starting, w.check, nil)
+	return w
+}
+
+func (w *OverridesWatcher) starting(_ context.Context) error {
+	// The overrides loaded at startup are the baseline, not a change.
+	w.hashes = hashOverrides(w.snapshot())
+	return nil
+}
+
+func (w *OverridesWatcher) check(_ context.Context) error {
+	hashes := hashOverrides(w.snapshot())
+
+	for userID, hash := range hashes {
+		prev, ok := w.hashes[userID]
+		switch {
+		case !ok:
+			w.log(userID, "added")
+		case prev != hash:
+			w.log(userID, "changed")
+		}
+	}
+	for userID := range w.hashes {
+		if _, ok := hashes[userID]; !ok {
+			w.log(userID, "removed")
+		}
+	}
+
+	w.hashes = hashes
+	return nil
+}
+
+func (w *OverridesWatcher) log(userID, change string) {
+	w.logger.Log(Record{
+		Action: ActionOverridesChange,
+		Actor:  overridesActor,
+		Tenant: userID,
+		Target: change,
+		Result: ResultSuccess,
+	})
+}
+
+func hashOverrides(overrides map[string]interface{}) map[string]string {
+	hashes := make(map[string]string, len(overrides))
+	for userID, o := range overrides {
+		data, err := yaml.Marshal(o)
+		if err != nil {
+			continue
+		}
+
+		sum := sha256.Sum256(data)
+		hashes[userID] = hex.EncodeToString(sum[:])
+	}
+	return hashes
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/user"
+)
+
+var (
+	// ErrNoCredentials is returned by an Authenticator when the request doesn't carry
+	// credentials it supports.
+	ErrNoCredentials = errors.New("missing credentials")
+
+	errTenantMismatch = errors.New("the tenant in the X-Scope-OrgID header doesn't match the request credentials")
+)
+
+// Authenticator authenticates HTTP requests, resolving their tenant from the credentials.
+type Authenticator interface {
+	// Name identifies the authenticator in metrics.
+	Name() string
+
+	// Authenticate returns the tenant of the request credentials, or ErrNoCredentials if
+	// the request doesn't carry credentials supported by the authenticator.
+	Authenticate(r *http.Request) (string, error)
+}
+
+// Middleware authenticates HTTP requests with the first authenticator supporting their
+// credentials. Requests without any supported credentials are rejected.
+type Middleware struct {
+	authenticators []Authenticator
+
+	rejected *prometheus.CounterVec
+}
+
+// NewMiddleware makes a new Middleware.
+func NewMiddleware(authenticators []Authenticator, reg prometheus.Registerer) *Middleware {
+	return &Middleware{
+		authenticators: authenticators,
+		rejected: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_auth_rejected_requests_total",
+			Help: "Total number of requests rejected by the built-in authentication, by authenticator.",
+		}, []string{"authenticator"}),
+	}
+}
+
+// Wrap implements middleware.Interface.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, a := range m.authenticators {
+			tenantID, err := a.Authenticate(r)
+			if errors.Is(err, ErrNoCredentials) {
+				continue
+			}
+			if err != nil {
+				m.rejected.WithLabelValues(a.Name()).Inc()
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			if err := setTenant(r, tenantID); err != nil {
+				m.rejected.WithLabelValues(a.Name()).Inc()
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		m.rejected.WithLabelValues("none").Inc()
+		http.Error(w, ErrNoCredentials.Error(), http.StatusUnauthorized)
+	})
+}
+
+// setTenant sets the tenant resolved from the request credentials in the X-Scope-OrgID
+// header, so that the tenant is then resolved as for any other request. Requests already
+// carrying a different tenant in the header are rejected.
+func setTenant(r *http.Request, tenantID string) error {
+	if header := r.Header.Get(user.OrgIDHeaderName); header != "" && header != tenantID {
+		return errTenantMismatch
+	}
+
+	r.Header.Set(user.OrgIDHeaderName, tenantID)
+	return nil
+}
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/server"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+// The certificate attributes the tenant ID can be read from.
+const (
+	AttributeCommonName         = "common-name"
+	AttributeOrganization       = "organization"
+	AttributeOrganizationalUnit = "organizational-unit"
+	AttributeDNSSAN             = "dns-san"
+)
+
+var (
+	errClientCertServerTLS  = errors.New("client certificate authentication requires the HTTP server TLS with client auth type RequireAndVerifyClientCert or VerifyClientCertIfGiven and a client CA")
+	errInvalidCertAttribute = fmt.Errorf("invalid client certificate tenant attribute, supported values: %s", strings.Join([]string{AttributeCommonName, AttributeOrganization, AttributeOrganizationalUnit, AttributeDNSSAN}, ", "))
+	errInvalidSANPattern    = errors.New("invalid client certificate SAN pattern")
+	errMissingClientCert    = errors.New("a verified client certificate is required")
+	errClientCertNotAllowed = errors.New("the client certificate is not allowed")
+	errClientCertNoTenant   = errors.New("the client certificate has no attribute to read the tenant ID from")
+)
+
+// ClientCertConfig holds the configuration of the client certificate authentication.
+type ClientCertConfig struct {
+	Enabled         bool                   `yaml:"enabled"`
+	AllowedSANs     flagext.StringSliceCSV `yaml:"allowed_sans"`
+	AllowedOUs      flagext.StringSliceCSV `yaml:"allowed_ous"`
+	TenantAttribute string                 `yaml:"tenant_attribute"`
+}
+
+// RegisterFlags registers the client certificate authentication flags.
+func (cfg *ClientCertConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "auth.client-cert.enabled", false, "True to require a client certificate, verified by the HTTP server against its client CA, on the push endpoints.")
+	f.Var(&cfg.AllowedSANs, "auth.client-cert.allowed-sans", "Comma-separated list of glob patterns. If set, the client certificate must have a DNS, URI, email or IP SAN matching one of them.")
+	f.Var(&cfg.AllowedOUs, "auth.client-cert.allowed-ous", "Comma-separated list of organizational units. If set, the client certificate subject must have one of them.")
+	f.StringVar(&cfg.TenantAttribute, "auth.client-cert.tenant-attribute", "", fmt.Sprintf("Client certificate attribute the tenant ID is read from, instead of the X-Scope-OrgID header. Supported values: %s. Empty to keep reading the tenant from the header.", strings.Join([]string{AttributeCommonName, AttributeOrganization, AttributeOrganizationalUnit, AttributeDNSSAN}, ", ")))
+}
+
+// Validate the config against the server config.
+func (cfg *ClientCertConfig) Validate(serverCfg server.Config) error {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	tlsCfg := serverCfg.HTTPTLSConfig
+	if tlsCfg.ClientCAs == "" || (tlsCfg.ClientAuth != "RequireAndVerifyClientCert" && tlsCfg.ClientAuth != "VerifyClientCertIfGiven") {
+		return errClientCertServerTLS
+	}
+
+	switch cfg.TenantAttribute {
+	case "", AttributeCommonName, AttributeOrganization, AttributeOrganizationalUnit, AttributeDNSSAN:
+	default:
+		return errInvalidCertAttribute
+	}
+
+	for _, pattern := range cfg.AllowedSANs {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrap(errInvalidSANPattern, pattern)
+		}
+	}
+
+	return nil
+}
+
+// ClientCertAuthenticator authenticates HTTP requests by their client certificate. The
+// certificate chain is verified by the server during the TLS handshake; the authenticator
+// requires it to be present and checks it against the allowed SANs and OUs.
+type ClientCertAuthenticator struct {
+	cfg    ClientCertConfig
+	logger log.Logger
+
+	rejected *prometheus.CounterVec
+}
+
+// NewClientCertAuthenticator makes a new ClientCertAuthenticator.
+func NewClientCertAuthenticator(cfg ClientCertConfig, logger log.Logger, reg prometheus.Registerer) *ClientCertAuthenticator {
+	return &ClientCertAuthenticator{
+		cfg:    cfg,
+		logger: logger,
+		rejected: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_auth_client_cert_rejected_requests_total",
+			Help: "Total number of requests rejected by the client certificate authentication, by reason.",
+		}, []string{"reason"}),
+	}
+}
+
+// Wrap implements middleware.Interface. When the tenant is read from the certificate, it's
+// set in the X-Scope-OrgID header, so that the tenant is resolved as for any other request.
+func (a *ClientCertAuthenticator) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			a.reject(w, r, "missing", errMissingClientCert, http.StatusUnauthorized)
+			return
+		}
+
+		cert := r.TLS.VerifiedChains[0][0]
+		if !a.isAllowed(cert) {
+			a.reject(w, r, "not_allowed", errClientCertNotAllowed, http.StatusForbidden)
+			return
+		}
+
+		if a.cfg.TenantAttribute != "" {
+			tenantID := certAttribute(cert, a.cfg.TenantAttribute)
+			if tenantID == "" {
+				a.reject(w, r, "no_tenant", errClientCertNoTenant, http.StatusForbidden)
+				return
+			}
+
+			if err := setTenant(r, tenantID); err != nil {
+				a.reject(w, r, "tenant_mismatch", err, http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *ClientCertAuthenticator) isAllowed(cert *x509.Certificate) bool {
+	if len(a.cfg.AllowedOUs) > 0 && !containsAny(cert.Subject.OrganizationalUnit, a.cfg.AllowedOUs) {
+		return false
+	}
+	if len(a.cfg.AllowedSANs) > 0 && !matchesAny(certSANs(cert), a.cfg.AllowedSANs) {
+		return false
+	}
+	return true
+}
+
+func (a *ClientCertAuthenticator) reject(w http.ResponseWriter, r *http.Request, reason string, err error, code int) {
+	a.rejected.WithLabelValues(reason).Inc()
+	level.Warn(a.logger).Log("msg", "request rejected by client certificate authentication", "remote_addr", r.RemoteAddr, "reason", reason)
+	http.Error(w, err.Error(), code)
+}
+
+// certAttribute returns the first value of the input certificate attribute.
+func certAttribute(cert *x509.Certificate, attribute string) string {
+	var values []string
+	switch attribute {
+	case AttributeCommonName:
+		values = []string{cert.Subject.CommonName}
+	case AttributeOrganization:
+		values = cert.Subject.Organization
+	case AttributeOrganizationalUnit:
+		values = cert.Subject.OrganizationalUnit
+	case AttributeDNSSAN:
+		values = cert.DNSNames
+	}
+
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func certSANs(cert *x509.Certificate) []string {
+	sans := append([]string(nil), cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return sans
+}
+
+func containsAny(values, allowed []string) bool {
+	for _, v := range values {
+		for _, a := range allowed {
+			if v == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesAny(values, patterns []string) bool {
+	for _, v := range values {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, v); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/weaveworks/common/server"
+	"github.com/weaveworks/common/user"
+)
+
+func TestClientCertConfig_Validate(t *testing.T) {
+	mtls := server.Config{HTTPTLSConfig: server.TLSConfig{ClientAuth: "RequireAndVerifyClientCert", ClientCAs: "ca.pem"}}
+
+	tests := map[string]struct {
+		cfg       ClientCertConfig
+		serverCfg server.Config
+		expected  error
+	}{
+		"disabled": {},
+		"enabled without server client auth": {
+			cfg:      ClientCertConfig{Enabled: true},
+			expected: errClientCertServerTLS,
+		},
+		"enabled with server client auth": {
+			cfg:       ClientCertConfig{Enabled: true, AllowedSANs: []string{"*.agents.example.com"}, TenantAttribute: AttributeOrganizationalUnit},
+			serverCfg: mtls,
+		},
+		"invalid tenant attribute": {
+			cfg:       ClientCertConfig{Enabled: true, TenantAttribute: "serial"},
+			serverCfg: mtls,
+			expected:  errInvalidCertAttribute,
+		},
+		"invalid SAN pattern": {
+			cfg:       ClientCertConfig{Enabled: true, AllowedSANs: []string{"[a-"}},
+			serverCfg: mtls,
+			expected:  errInvalidSANPattern,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, tc.cfg.Validate(tc.serverCfg), tc.expected)
+		})
+	}
+}
+
+func TestClientCertAuthenticator(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.com/agent")
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "agent-1", OrganizationalUnit: []string{"team-a"}},
+		DNSNames: []string{"agent-1.agents.example.com"},
+		URIs:     []*url.URL{spiffe},
+	}
+
+	tests := map[string]struct {
+		cfg            ClientCertConfig
+		cert           *x509.Certificate
+		orgID          string
+		expectedStatus int
+		expectedTenant string
+	}{
+		"missing certificate": {
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"no restrictions": {
+			cert:           cert,
+			orgID:          "tenant-a",
+			expectedStatus: http.StatusOK,
+			expectedTenant: "tenant-a",
+		},
+		"allowed DNS SAN": {
+			cfg:            ClientCertConfig{AllowedSANs: []string{"*.agents.example.com"}},
+			cert:           cert,
+			orgID:          "tenant-a",
+			expectedStatus: http.StatusOK,
+			expectedTenant: "tenant-a",
+		},
+		"allowed URI SAN": {
+			cfg:            ClientCertConfig{AllowedSANs: []string{"spiffe://example.com/*"}},
+			cert:           cert,
+			orgID:          "tenant-a",
+			expectedStatus: http.StatusOK,
+			expectedTenant: "tenant-a",
+		},
+		"SAN not allowed": {
+			cfg:            ClientCertConfig{AllowedSANs: []string{"*.other.example.com"}},
+			cert:           cert,
+			expectedStatus: http.StatusForbidden,
+		},
+		"OU not allowed": {
+			cfg:            ClientCertConfig{AllowedOUs: []string{"team-b"}},
+			cert:           cert,
+			expectedStatus: http.StatusForbidden,
+		},
+		"tenant from the certificate": {
+			cfg:            ClientCertConfig{AllowedOUs: []string{"team-a"}, TenantAttribute: AttributeOrganizationalUnit},
+			cert:           cert,
+			expectedStatus: http.StatusOK,
+			expectedTenant: "team-a",
+		},
+		"tenant from the certificate matching the header": {
+			cfg:            ClientCertConfig{TenantAttribute: AttributeCommonName},
+			cert:           cert,
+			orgID:          "agent-1",
+			expectedStatus: http.StatusOK,
+			expectedTenant: "agent-1",
+		},
+		"tenant from the certificate not matching the header": {
+			cfg:            ClientCertConfig{TenantAttribute: AttributeCommonName},
+			cert:           cert,
+			orgID:          "tenant-a",
+			expectedStatus: http.StatusForbidden,
+		},
+		"certificate without the tenant attribute": {
+			cfg:            ClientCertConfig{TenantAttribute: AttributeOrganization},
+			cert:           cert,
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var actualTenant string
+			a := NewClientCertAuthenticator(tc.cfg, log.NewNopLogger(), nil)
+			handler := a.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				actualTenant = r.Header.Get(user.OrgIDHeaderName)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/push", nil)
+			if tc.cert != nil {
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tc.cert}}}
+			}
+			if tc.orgID != "" {
+				req.Header.Set(user.OrgIDHeaderName, tc.orgID)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			assert.Equal(t, tc.expectedTenant, actualTenant)
+		})
+	}
+}
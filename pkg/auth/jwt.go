@@ -0,0 +1,383 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/tenant"
+)
+
+// minKeysRefreshInterval is the minimum interval between two JWKS fetches triggered by
+// tokens signed with an unknown key, so that forged tokens can't flood the issuer.
+const minKeysRefreshInterval = time.Minute
+
+var (
+	errInvalidJWKSRefresh = errors.New("the JWKS refresh period must be greater than 0")
+	errMissingTenantClaim = errors.New("the tenant claim name is required")
+	errInvalidToken       = errors.New("invalid token")
+	errUnknownSigningKey  = errors.New("unknown token signing key")
+
+	signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+)
+
+// JWTConfig holds the configuration of the OIDC/JWT bearer token authentication.
+type JWTConfig struct {
+	IssuerURL         string                 `yaml:"issuer_url"`
+	JWKSURL           string                 `yaml:"jwks_url"`
+	Audience          string                 `yaml:"audience"`
+	RequiredScopes    flagext.StringSliceCSV `yaml:"required_scopes"`
+	TenantClaim       string                 `yaml:"tenant_claim"`
+	JWKSRefreshPeriod time.Duration          `yaml:"jwks_refresh_period"`
+}
+
+// RegisterFlags registers the JWT authentication flags.
+func (cfg *JWTConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.IssuerURL, "auth.jwt.issuer-url", "", "URL of the OIDC issuer. If set, requests can authenticate with a bearer token issued by it.")
+	f.StringVar(&cfg.JWKSURL, "auth.jwt.jwks-url", "", "URL of the issuer signing keys. Empty to discover it from the issuer OpenID configuration.")
+	f.StringVar(&cfg.Audience, "auth.jwt.audience", "", "Audience the tokens must be issued for. Empty to not check the audience.")
+	f.Var(&cfg.RequiredScopes, "auth.jwt.required-scopes", "Comma-separated list of scopes the tokens must all have, read from the scope or scp claim.")
+	f.StringVar(&cfg.TenantClaim, "auth.jwt.tenant-claim", "tenant_id", "Name of the token claim holding the tenant ID.")
+	f.DurationVar(&cfg.JWKSRefreshPeriod, "auth.jwt.jwks-refresh-period", time.Hour, "How frequently the issuer signing keys are refreshed. Keys are also refreshed when a token is signed with an unknown key.")
+}
+
+// Validate the config.
+func (cfg *JWTConfig) Validate() error {
+	if !cfg.Enabled() {
+		return nil
+	}
+	if cfg.JWKSRefreshPeriod <= 0 {
+		return errInvalidJWKSRefresh
+	}
+	if cfg.TenantClaim == "" {
+		return errMissingTenantClaim
+	}
+	return nil
+}
+
+// Enabled returns whether the JWT authentication is enabled.
+func (cfg *JWTConfig) Enabled() bool {
+	return cfg.IssuerURL != ""
+}
+
+// JWTAuthenticator authenticates HTTP requests with bearer tokens signed by an OIDC issuer,
+// reading the tenant from a claim.
+type JWTAuthenticator struct {
+	services.Service
+
+	cfg    JWTConfig
+	client *http.Client
+	logger log.Logger
+
+	mtx         sync.RWMutex
+	jwksURL     string
+	keys        map[string]crypto.PublicKey
+	lastRefresh time.Time
+
+	refreshes *prometheus.CounterVec
+}
+
+// NewJWTAuthenticator makes a new JWTAuthenticator.
+func NewJWTAuthenticator(cfg JWTConfig, logger log.Logger, reg prometheus.Registerer) *JWTAuthenticator {
+	a := &JWTAuthenticator{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		logger:  logger,
+		jwksURL: cfg.JWKSURL,
+		keys:    map[string]crypto.PublicKey{},
+		refreshes: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_auth_jwt_keys_refreshes_total",
+			Help: "Total number of issuer signing keys refreshes, by outcome.",
+		}, []string{"outcome"}),
+	}
+
+	a.Service = services.NewTimerService(cfg.JWKSRefreshPeriod, a.starting, a.iteration, nil)
+	return a
+}
+
+func (a *JWTAuthenticator) starting(ctx context.Context) error {
+	return errors.Wrap(a.refreshKeys(ctx), "fetch the issuer signing keys")
+}
+
+func (a *JWTAuthenticator) iteration(ctx context.Context) error {
+	if err := a.refreshKeys(ctx); err != nil {
+		// Keep the cached keys, the issuer may be temporarily unavailable.
+		level.Warn(a.logger).Log("msg", "failed to refresh the issuer signing keys", "err", err)
+	}
+	return nil
+}
+
+// Name implements Authenticator.
+func (a *JWTAuthenticator) Name() string {
+	return "jwt"
+}
+
+// Authenticate implements Authenticator.
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", ErrNoCredentials
+	}
+
+	token, err := jwt.Parse(strings.TrimSpace(header[7:]), a.keyFunc(r.Context()), jwt.WithValidMethods(signingMethods))
+	if err != nil {
+		return "", errors.Wrap(errInvalidToken, err.Error())
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return "", errInvalidToken
+	}
+	if _, ok := claims["exp"]; !ok {
+		return "", errors.Wrap(errInvalidToken, "missing expiration")
+	}
+	if !claims.VerifyIssuer(a.cfg.IssuerURL, true) {
+		return "", errors.Wrap(errInvalidToken, "unexpected issuer")
+	}
+	if a.cfg.Audience != "" && !claims.VerifyAudience(a.cfg.Audience, true) {
+		return "", errors.Wrap(errInvalidToken, "unexpected audience")
+	}
+	if missing := missingScopes(claims, a.cfg.RequiredScopes); len(missing) > 0 {
+		return "", errors.Wrapf(errInvalidToken, "missing scopes %s", strings.Join(missing, ", "))
+	}
+
+	tenantID, _ := claims[a.cfg.TenantClaim].(string)
+	if err := tenant.ValidTenantID(tenantID); err != nil {
+		return "", errors.Wrapf(errInvalidToken, "invalid tenant in claim %s: %s", a.cfg.TenantClaim, err)
+	}
+
+	return tenantID, nil
+}
+
+func (a *JWTAuthenticator) keyFunc(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		if key, ok := a.key(kid); ok {
+			return key, nil
+		}
+
+		// The issuer may have rotated its keys.
+		a.mtx.RLock()
+		canRefresh := time.Since(a.lastRefresh) >= minKeysRefreshInterval
+		a.mtx.RUnlock()
+
+		if canRefresh {
+			if err := a.refreshKeys(ctx); err != nil {
+				level.Warn(a.logger).Log("msg", "failed to refresh the issuer signing keys", "err", err)
+			}
+			if key, ok := a.key(kid); ok {
+				return key, nil
+			}
+		}
+
+		return nil, errUnknownSigningKey
+	}
+}
+
+func (a *JWTAuthenticator) key(kid string) (crypto.PublicKey, bool) {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+
+	// Tokens without a key ID are accepted when the issuer has a single key.
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, true
+		}
+	}
+
+	key, ok := a.keys[kid]
+	return key, ok
+}
+
+func (a *JWTAuthenticator) refreshKeys(ctx context.Context) error {
+	a.mtx.Lock()
+	a.lastRefresh = time.Now()
+	jwksURL := a.jwksURL
+	a.mtx.Unlock()
+
+	keys, jwksURL, err := a.fetchKeys(ctx, jwksURL)
+	if err != nil {
+		a.refreshes.WithLabelValues("failed").Inc()
+		return err
+	}
+
+	a.refreshes.WithLabelValues("success").Inc()
+
+	a.mtx.Lock()
+	a.jwksURL = jwksURL
+	a.keys = keys
+	a.mtx.Unlock()
+	return nil
+}
+
+func (a *JWTAuthenticator) fetchKeys(ctx context.Context, jwksURL string) (map[string]crypto.PublicKey, string, error) {
+	if jwksURL == "" {
+		discovery := struct {
+			JWKSURI string `json:"jwks_uri"`
+		}{}
+		if err := a.getJSON(ctx, strings.TrimSuffix(a.cfg.IssuerURL, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, "", errors.Wrap(err, "discover the issuer configuration")
+		}
+		if discovery.JWKSURI == "" {
+			return nil, "", errors.New("the issuer configuration has no jwks_uri")
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	jwks := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := a.getJSON(ctx, jwksURL, &jwks); err != nil {
+		return nil, "", errors.Wrap(err, "fetch the issuer keys")
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			level.Warn(a.logger).Log("msg", "skipping invalid issuer key", "kid", k.Kid, "err", err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, jwksURL, nil
+}
+
+func (a *JWTAuthenticator) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status %d from %s", resp.StatusCode, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// jsonWebKey is a public key of a JSON Web Key Set, as defined in RFC 7517.
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+
+	// RSA keys.
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC keys.
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("the point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// missingScopes returns the required scopes not granted by the token, read either from
+// the space-separated scope claim or the scp array claim.
+func missingScopes(claims jwt.MapClaims, required []string) []string {
+	granted := map[string]struct{}{}
+	if scope, ok := claims["scope"].(string); ok {
+		for _, s := range strings.Fields(scope) {
+			granted[s] = struct{}{}
+		}
+	}
+	if scp, ok := claims["scp"].([]interface{}); ok {
+		for _, s := range scp {
+			if s, ok := s.(string); ok {
+				granted[s] = struct{}{}
+			}
+		}
+	}
+
+	var missing []string
+	for _, s := range required {
+		if _, ok := granted[s]; !ok {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
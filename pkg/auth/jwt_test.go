@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testIssuer struct {
+	*httptest.Server
+
+	keys map[string]*rsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	issuer := &testIssuer{keys: map[string]*rsa.PrivateKey{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": issuer.URL, "jwks_uri": issuer.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		var keys []jsonWebKey
+		for kid, key := range issuer.keys {
+			keys = append(keys, jsonWebKey{
+				Kid: kid,
+				Kty: "RSA",
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+func (i *testIssuer) addKey(t *testing.T, kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	i.keys[kid] = key
+}
+
+func (i *testIssuer) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(i.keys[kid])
+	require.NoError(t, err)
+	return signed
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.addKey(t, "key-1")
+
+	cfg := JWTConfig{
+		IssuerURL:         issuer.URL,
+		Audience:          "blockstorage-ingester",
+		RequiredScopes:    []string{"metrics:write"},
+		TenantClaim:       "tenant_id",
+		JWKSRefreshPeriod: time.Hour,
+	}
+	a := NewJWTAuthenticator(cfg, log.NewNopLogger(), nil)
+	require.NoError(t, a.starting(context.Background()))
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":       issuer.URL,
+			"aud":       "blockstorage-ingester",
+			"exp":       time.Now().Add(time.Hour).Unix(),
+			"scope":     "openid metrics:write",
+			"tenant_id": "tenant-a",
+		}
+	}
+
+	tests := map[string]struct {
+		header         func() string
+		expectedTenant string
+		expectedErr    error
+	}{
+		"no bearer token": {
+			header:      func() string { return "Basic dXNlcjpwYXNz" },
+			expectedErr: ErrNoCredentials,
+		},
+		"valid token": {
+			header:         func() string { return "Bearer " + issuer.sign(t, "key-1", validClaims()) },
+			expectedTenant: "tenant-a",
+		},
+		"valid token with scp claim": {
+			header: func() string {
+				claims := validClaims()
+				delete(claims, "scope")
+				claims["scp"] = []string{"metrics:write"}
+				return "Bearer " + issuer.sign(t, "key-1", claims)
+			},
+			expectedTenant: "tenant-a",
+		},
+		"expired token": {
+			header: func() string {
+				claims := validClaims()
+				claims["exp"] = time.Now().Add(-time.Minute).Unix()
+				return "Bearer " + issuer.sign(t, "key-1", claims)
+			},
+			expectedErr: errInvalidToken,
+		},
+		"token without expiration": {
+			header: func() string {
+				claims := validClaims()
+				delete(claims, "exp")
+				return "Bearer " + issuer.sign(t, "key-1", claims)
+			},
+			expectedErr: errInvalidToken,
+		},
+		"wrong audience": {
+			header: func() string {
+				claims := validClaims()
+				claims["aud"] = "other"
+				return "Bearer " + issuer.sign(t, "key-1", claims)
+			},
+			expectedErr: errInvalidToken,
+		},
+		"wrong issuer": {
+			header: func() string {
+				claims := validClaims()
+				claims["iss"] = "https://other.example.com"
+				return "Bearer " + issuer.sign(t, "key-1", claims)
+			},
+			expectedErr: errInvalidToken,
+		},
+		"missing scope": {
+			header: func() string {
+				claims := validClaims()
+				claims["scope"] = "openid"
+				return "Bearer " + issuer.sign(t, "key-1", claims)
+			},
+			expectedErr: errInvalidToken,
+		},
+		"invalid tenant": {
+			header: func() string {
+				claims := validClaims()
+				claims["tenant_id"] = "../tenant"
+				return "Bearer " + issuer.sign(t, "key-1", claims)
+			},
+			expectedErr: errInvalidToken,
+		},
+		"malformed token": {
+			header:      func() string { return "Bearer not-a-token" },
+			expectedErr: errInvalidToken,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/push", nil)
+			req.Header.Set("Authorization", tc.header())
+
+			tenantID, err := a.Authenticate(req)
+			assert.ErrorIs(t, err, tc.expectedErr)
+			assert.Equal(t, tc.expectedTenant, tenantID)
+		})
+	}
+}
+
+func TestJWTAuthenticator_ShouldRefreshKeysOnUnknownKeyID(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.addKey(t, "key-1")
+
+	a := NewJWTAuthenticator(JWTConfig{IssuerURL: issuer.URL, TenantClaim: "tenant_id", JWKSRefreshPeriod: time.Hour}, log.NewNopLogger(), nil)
+	require.NoError(t, a.starting(context.Background()))
+
+	// The issuer rotates its keys.
+	issuer.addKey(t, "key-2")
+	token := issuer.sign(t, "key-2", jwt.MapClaims{"iss": issuer.URL, "exp": time.Now().Add(time.Hour).Unix(), "tenant_id": "tenant-a"})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/push", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	// The keys have just been fetched, so they're not refreshed yet.
+	_, err := a.Authenticate(req)
+	assert.ErrorIs(t, err, errInvalidToken)
+
+	a.mtx.Lock()
+	a.lastRefresh = time.Now().Add(-minKeysRefreshInterval)
+	a.mtx.Unlock()
+
+	tenantID, err := a.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "tenant-a", tenantID)
+}
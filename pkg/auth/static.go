@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"flag"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/tenant"
+)
+
+var (
+	errInvalidCredentials  = errors.New("invalid credentials")
+	errInvalidReloadPeriod = errors.New("the static credentials reload period must be greater than 0")
+	errUnsupportedHash     = errors.New("unsupported password hash, only bcrypt is supported")
+	errMalformedEntry      = errors.New("malformed entry, expected <name>:<secret>")
+)
+
+// StaticConfig holds the configuration of the built-in static credentials authentication.
+type StaticConfig struct {
+	HTPasswdFile string        `yaml:"htpasswd_file"`
+	APIKeysFile  string        `yaml:"api_keys_file"`
+	APIKeyHeader string        `yaml:"api_key_header"`
+	ReloadPeriod time.Duration `yaml:"reload_period"`
+}
+
+// RegisterFlags registers the static credentials authentication flags.
+func (cfg *StaticConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.HTPasswdFile, "auth.static.htpasswd-file", "", "Path to an htpasswd file with bcrypt-hashed passwords. If set, requests can authenticate via basic auth, the username being the tenant ID.")
+	f.StringVar(&cfg.APIKeysFile, "auth.static.api-keys-file", "", "Path to a file with one <tenant>:<api key> entry per line, typically mounted from a secret store. If set, requests can authenticate via an API key.")
+	f.StringVar(&cfg.APIKeyHeader, "auth.static.api-key-header", "X-API-Key", "HTTP header carrying the API key.")
+	f.DurationVar(&cfg.ReloadPeriod, "auth.static.reload-period", time.Minute, "How frequently the htpasswd and API keys files are reloaded, so that credentials can be rotated without a restart.")
+}
+
+// Validate the config.
+func (cfg *StaticConfig) Validate() error {
+	if cfg.Enabled() && cfg.ReloadPeriod <= 0 {
+		return errInvalidReloadPeriod
+	}
+	return nil
+}
+
+// Enabled returns whether any static credentials are configured.
+func (cfg *StaticConfig) Enabled() bool {
+	return cfg.HTPasswdFile != "" || cfg.APIKeysFile != ""
+}
+
+type credentials struct {
+	// The bcrypt password hash by tenant.
+	passwords map[string][]byte
+
+	// The tenant by SHA-256 digest of the API key.
+	apiKeys map[[sha256.Size]byte]string
+}
+
+// StaticAuthenticator authenticates HTTP requests via basic auth or API key, mapping
+// the credentials to a tenant.
+type StaticAuthenticator struct {
+	services.Service
+
+	cfg    StaticConfig
+	logger log.Logger
+
+	mtx         sync.RWMutex
+	credentials credentials
+
+	// The successfully verified passwords digests, so that bcrypt only runs once per
+	// password and not on every request. Reset on reload.
+	verifiedMtx sync.Mutex
+	verified    map[string][sha256.Size]byte
+
+	reloads *prometheus.CounterVec
+}
+
+// NewStaticAuthenticator makes a new StaticAuthenticator. The credentials files are
+// loaded immediately, so that invalid files are reported at startup.
+func NewStaticAuthenticator(cfg StaticConfig, logger log.Logger, reg prometheus.Registerer) (*StaticAuthenticator, error) {
+	a := &StaticAuthenticator{
+		cfg:    cfg,
+		logger: logger,
+		reloads: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_auth_static_reloads_total",
+			Help: "Total number of static credentials reloads, by outcome.",
+		}, []string{"outcome"}),
+	}
+
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+
+	a.Service = services.NewTimerService(cfg.ReloadPeriod, nil, a.iteration, nil)
+	return a, nil
+}
+
+func (a *StaticAuthenticator) iteration(_ context.Context) error {
+	if err := a.reload(); err != nil {
+		// Keep the previous credentials, so that a bad rotation doesn't lock out all tenants.
+		a.reloads.WithLabelValues("failed").Inc()
+		level.Error(a.logger).Log("msg", "failed to reload static credentials, keeping the previous ones", "err", err)
+		return nil
+	}
+
+	a.reloads.WithLabelValues("success").Inc()
+	return nil
+}
+
+func (a *StaticAuthenticator) reload() error {
+	creds := credentials{
+		passwords: map[string][]byte{},
+		apiKeys:   map[[sha256.Size]byte]string{},
+	}
+
+	if a.cfg.HTPasswdFile != "" {
+		err := readEntries(a.cfg.HTPasswdFile, func(tenantID, hash string) error {
+			if !strings.HasPrefix(hash, "$2a$") && !strings.HasPrefix(hash, "$2b$") && !strings.HasPrefix(hash, "$2y$") {
+				return errUnsupportedHash
+			}
+			creds.passwords[tenantID] = []byte(hash)
+			return nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "load htpasswd file")
+		}
+	}
+
+	if a.cfg.APIKeysFile != "" {
+		err := readEntries(a.cfg.APIKeysFile, func(tenantID, key string) error {
+			creds.apiKeys[sha256.Sum256([]byte(key))] = tenantID
+			return nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "load API keys file")
+		}
+	}
+
+	a.mtx.Lock()
+	a.credentials = creds
+	a.mtx.Unlock()
+
+	a.verifiedMtx.Lock()
+	a.verified = map[string][sha256.Size]byte{}
+	a.verifiedMtx.Unlock()
+
+	return nil
+}
+
+// readEntries calls fn for each <tenant>:<secret> entry of the input file, skipping
+// empty lines and comments.
+func readEntries(path string, fn func(tenantID, secret string) error) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		tenantID, secret, ok := strings.Cut(entry, ":")
+		if !ok || secret == "" {
+			return errors.Wrapf(errMalformedEntry, "line %d", line)
+		}
+		if err := tenant.ValidTenantID(tenantID); err != nil {
+			return errors.Wrapf(err, "line %d", line)
+		}
+		if err := fn(tenantID, secret); err != nil {
+			return errors.Wrapf(err, "line %d", line)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Name implements Authenticator.
+func (a *StaticAuthenticator) Name() string {
+	return "static"
+}
+
+// Authenticate implements Authenticator.
+func (a *StaticAuthenticator) Authenticate(r *http.Request) (string, error) {
+	a.mtx.RLock()
+	creds := a.credentials
+	a.mtx.RUnlock()
+
+	if key := r.Header.Get(a.cfg.APIKeyHeader); key != "" && len(creds.apiKeys) > 0 {
+		// Looking up the digest doesn't leak the key through timing.
+		if tenantID, ok := creds.apiKeys[sha256.Sum256([]byte(key))]; ok {
+			return tenantID, nil
+		}
+		return "", errInvalidCredentials
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok || len(creds.passwords) == 0 {
+		return "", ErrNoCredentials
+	}
+
+	hash, ok := creds.passwords[username]
+	if !ok || !a.verifyPassword(username, password, hash) {
+		return "", errInvalidCredentials
+	}
+	return username, nil
+}
+
+func (a *StaticAuthenticator) verifyPassword(username, password string, hash []byte) bool {
+	digest := sha256.Sum256([]byte(password))
+
+	a.verifiedMtx.Lock()
+	verified, ok := a.verified[username]
+	a.verifiedMtx.Unlock()
+
+	if ok && subtle.ConstantTimeCompare(verified[:], digest[:]) == 1 {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+
+	a.verifiedMtx.Lock()
+	a.verified[username] = digest
+	a.verifiedMtx.Unlock()
+	return true
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestStaticAuthenticator(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	cfg := StaticConfig{
+		HTPasswdFile: filepath.Join(dir, "htpasswd"),
+		APIKeysFile:  filepath.Join(dir, "api-keys"),
+		APIKeyHeader: "X-API-Key",
+		ReloadPeriod: time.Minute,
+	}
+	require.NoError(t, os.WriteFile(cfg.HTPasswdFile, []byte("# comment\ntenant-a:"+string(hash)+"\n"), 0o600))
+	require.NoError(t, os.WriteFile(cfg.APIKeysFile, []byte("tenant-b:key-b\n\ntenant-c:key-c\n"), 0o600))
+
+	a, err := NewStaticAuthenticator(cfg, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		setup          func(r *http.Request)
+		expectedStatus int
+		expectedTenant string
+	}{
+		"no credentials": {
+			setup:          func(*http.Request) {},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"valid basic auth": {
+			setup:          func(r *http.Request) { r.SetBasicAuth("tenant-a", "secret") },
+			expectedStatus: http.StatusOK,
+			expectedTenant: "tenant-a",
+		},
+		"invalid password": {
+			setup:          func(r *http.Request) { r.SetBasicAuth("tenant-a", "wrong") },
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"unknown user": {
+			setup:          func(r *http.Request) { r.SetBasicAuth("tenant-x", "secret") },
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"valid API key": {
+			setup:          func(r *http.Request) { r.Header.Set("X-API-Key", "key-c") },
+			expectedStatus: http.StatusOK,
+			expectedTenant: "tenant-c",
+		},
+		"invalid API key": {
+			setup:          func(r *http.Request) { r.Header.Set("X-API-Key", "key-x") },
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"API key not matching the tenant header": {
+			setup: func(r *http.Request) {
+				r.Header.Set("X-API-Key", "key-b")
+				r.Header.Set(user.OrgIDHeaderName, "tenant-c")
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var actualTenant string
+			handler := NewMiddleware([]Authenticator{a}, nil).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				actualTenant = r.Header.Get(user.OrgIDHeaderName)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/cardinality/label_names", nil)
+			tc.setup(req)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			assert.Equal(t, tc.expectedTenant, actualTenant)
+		})
+	}
+}
+
+func TestStaticAuthenticator_ShouldKeepPreviousCredentialsOnInvalidReload(t *testing.T) {
+	cfg := StaticConfig{APIKeysFile: filepath.Join(t.TempDir(), "api-keys"), APIKeyHeader: "X-API-Key", ReloadPeriod: time.Minute}
+	require.NoError(t, os.WriteFile(cfg.APIKeysFile, []byte("tenant-a:key-a\n"), 0o600))
+
+	a, err := NewStaticAuthenticator(cfg, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	authenticate := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		NewMiddleware([]Authenticator{a}, nil).Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Rotate the key.
+	require.NoError(t, os.WriteFile(cfg.APIKeysFile, []byte("tenant-a:key-b\n"), 0o600))
+	require.NoError(t, a.iteration(context.Background()))
+	assert.Equal(t, http.StatusUnauthorized, authenticate("key-a"))
+	assert.Equal(t, http.StatusOK, authenticate("key-b"))
+
+	// An invalid file is ignored.
+	require.NoError(t, os.WriteFile(cfg.APIKeysFile, []byte("../tenant:key-c\n"), 0o600))
+	require.NoError(t, a.iteration(context.Background()))
+	assert.Equal(t, http.StatusOK, authenticate("key-b"))
+}
+
+func TestNewStaticAuthenticator_ShouldFailOnInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"missing secret":   "tenant-a\n",
+		"invalid tenant":   "../tenant:$2y$10$abc\n",
+		"unsupported hash": "tenant-a:{SHA}abc\n",
+		"empty secret":     "tenant-a:\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := StaticConfig{HTPasswdFile: filepath.Join(dir, "htpasswd"), ReloadPeriod: time.Minute}
+			require.NoError(t, os.WriteFile(cfg.HTPasswdFile, []byte(content), 0o600))
+
+			_, err := NewStaticAuthenticator(cfg, log.NewNopLogger(), nil)
+			assert.Error(t, err)
+		})
+	}
+}
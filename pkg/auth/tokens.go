@@ -0,0 +1,476 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/atomic"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/tenant"
+)
+
+// The tenant tokens management endpoints.
+const (
+	TokensPath       = "/api/v1/admin/tokens"
+	TokensRotatePath = "/api/v1/admin/tokens/rotate"
+
+	tokenPrefix = "bsi_"
+	tokensKey   = "tokens"
+)
+
+var (
+	errTokenNotFound      = errors.New("token not found")
+	errTooManyTokens      = errors.New("the tenant reached the max number of tokens")
+	errMissingTokenID     = errors.New("missing token id")
+	errInvalidMaxTokens   = errors.New("the max tokens per tenant must be greater than 0")
+	errMissingTokenHeader = errors.New("the tokens header must not be empty")
+)
+
+// TokensConfig holds the configuration of the per-tenant tokens, managed by the tenants
+// themselves via the tokens endpoints.
+type TokensConfig struct {
+	Enabled            bool      `yaml:"enabled"`
+	KVStore            kv.Config `yaml:"kvstore" doc:"description=The key-value store holding the hashed tokens."`
+	Header             string    `yaml:"header"`
+	MaxTokensPerTenant int       `yaml:"max_tokens_per_tenant"`
+}
+
+// RegisterFlags registers the tenant tokens flags.
+func (cfg *TokensConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "auth.tokens.enabled", false, "True to enable the per-tenant tokens: the operators create, rotate and revoke the tokens of the tenant of the X-Scope-OrgID header via the "+TokensPath+" endpoints of the admin listener, which must be enabled, and the tenants authenticate requests with them.")
+	cfg.KVStore.RegisterFlagsWithPrefix("auth.tokens.", "auth-tokens/", f)
+	f.StringVar(&cfg.Header, "auth.tokens.header", "X-Ingest-Token", "HTTP header carrying the tenant token.")
+	f.IntVar(&cfg.MaxTokensPerTenant, "auth.tokens.max-tokens-per-tenant", 10, "Max number of tokens each tenant can create.")
+}
+
+// Validate the config.
+func (cfg *TokensConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Header == "" {
+		return errMissingTokenHeader
+	}
+	if cfg.MaxTokensPerTenant <= 0 {
+		return errInvalidMaxTokens
+	}
+	return nil
+}
+
+// StoredToken is a tenant token as stored in the KV store. Only the SHA-256 digest of the
+// token secret is stored: the secret is returned once, when the token is created or rotated.
+type StoredToken struct {
+	ID        string    `json:"id"`
+	Tenant    string    `json:"tenant"`
+	Name      string    `json:"name,omitempty"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+	RotatedAt time.Time `json:"rotated_at,omitempty"`
+}
+
+// TokenStore holds all tenant tokens, by token ID.
+type TokenStore struct {
+	Tokens map[string]*StoredToken `json:"tokens"`
+}
+
+// clone returns a deep copy of the store, to be modified in a CAS.
+func (s *TokenStore) clone() *TokenStore {
+	out := &TokenStore{Tokens: map[string]*StoredToken{}}
+	if s == nil {
+		return out
+	}
+	for id, t := range s.Tokens {
+		copied := *t
+		out.Tokens[id] = &copied
+	}
+	return out
+}
+
+// tokensCodec encodes the TokenStore as JSON.
+type tokensCodec struct{}
+
+// CodecID implements codec.Codec.
+func (tokensCodec) CodecID() string { return "tenantTokens" }
+
+// Decode implements codec.Codec.
+func (tokensCodec) Decode(data []byte) (interface{}, error) {
+	s := &TokenStore{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Encode implements codec.Codec.
+func (tokensCodec) Encode(v interface{}) ([]byte, error) {
+	s, ok := v.(*TokenStore)
+	if !ok {
+		return nil, errors.Errorf("unsupported tokens value type %T", v)
+	}
+	return json.Marshal(s)
+}
+
+// DecodeMultiKey implements codec.Codec. The tokens are stored under a single key.
+func (tokensCodec) DecodeMultiKey(map[string][]byte) (interface{}, error) {
+	return nil, errors.New("the tokens codec doesn't support multi-key values")
+}
+
+// EncodeMultiKey implements codec.Codec.
+func (tokensCodec) EncodeMultiKey(interface{}) (map[string][]byte, error) {
+	return nil, errors.New("the tokens codec doesn't support multi-key values")
+}
+
+// TokenInfo is a tenant token as returned by the tokens endpoints. The token itself is only
+// set when created or rotated.
+type TokenInfo struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	RotatedAt time.Time `json:"rotated_at,omitempty"`
+	Token     string    `json:"token,omitempty"`
+}
+
+// TokenAuthenticator authenticates HTTP requests with the tenant tokens stored in the KV
+// store, and serves the endpoints managing them. The tokens are watched, so that a revoked
+// token is rejected by all replicas shortly after.
+type TokenAuthenticator struct {
+	services.Service
+
+	cfg    TokensConfig
+	client kv.Client
+	logger log.Logger
+
+	store atomic.Value
+
+	operations *prometheus.CounterVec
+}
+
+// NewTokenAuthenticator makes a new TokenAuthenticator.
+func NewTokenAuthenticator(cfg TokensConfig, logger log.Logger, reg prometheus.Registerer) (*TokenAuthenticator, error) {
+	client, err := kv.NewClient(cfg.KVStore, tokensCodec{}, kv.RegistererWithKVName(reg, "auth-tokens"), logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "create tenant tokens KV client")
+	}
+
+	return newTokenAuthenticator(cfg, client, logger, reg), nil
+}
+
+func newTokenAuthenticator(cfg TokensConfig, client kv.Client, logger log.Logger, reg prometheus.Registerer) *TokenAuthenticator {
+	a := &TokenAuthenticator{
+		cfg:    cfg,
+		client: client,
+		logger: logger,
+		operations: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_auth_tokens_operations_total",
+			Help: "Total number of tenant tokens operations, by operation and outcome.",
+		}, []string{"operation", "outcome"}),
+	}
+	a.store.Store(&TokenStore{})
+
+	a.Service = services.NewBasicService(a.starting, a.running, nil)
+	return a
+}
+
+func (a *TokenAuthenticator) starting(ctx context.Context) error {
+	val, err := a.client.Get(ctx, tokensKey)
+	if err != nil {
+		return errors.Wrap(err, "load tenant tokens from KV store")
+	}
+
+	a.set(val)
+	return nil
+}
+
+func (a *TokenAuthenticator) running(ctx context.Context) error {
+	a.client.WatchKey(ctx, tokensKey, func(val interface{}) bool {
+		a.set(val)
+		return true
+	})
+	return nil
+}
+
+func (a *TokenAuthenticator) set(val interface{}) {
+	// A missing key means no tokens.
+	if s, ok := val.(*TokenStore); ok && s != nil {
+		a.store.Store(s)
+	}
+}
+
+func (a *TokenAuthenticator) tokens() *TokenStore {
+	return a.store.Load().(*TokenStore)
+}
+
+// Name implements Authenticator.
+func (a *TokenAuthenticator) Name() string {
+	return "tokens"
+}
+
+// Authenticate implements Authenticator.
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (string, error) {
+	token := r.Header.Get(a.cfg.Header)
+	if token == "" {
+		return "", ErrNoCredentials
+	}
+
+	id, secret, ok := parseToken(token)
+	if !ok {
+		return "", errInvalidCredentials
+	}
+
+	stored, ok := a.tokens().Tokens[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(stored.Hash), []byte(hashSecret(secret))) != 1 {
+		return "", errInvalidCredentials
+	}
+	return stored.Tenant, nil
+}
+
+// List returns the tokens of the tenant, sorted by creation time.
+func (a *TokenAuthenticator) List(userID string) []TokenInfo {
+	var out []TokenInfo
+	for _, t := range a.tokens().Tokens {
+		if t.Tenant == userID {
+			out = append(out, tokenInfo(t, ""))
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out
+}
+
+// Create creates a new token for the tenant.
+func (a *TokenAuthenticator) Create(ctx context.Context, userID, name string) (TokenInfo, error) {
+	id, secret, err := newTokenSecret()
+	if err != nil {
+		return TokenInfo{}, err
+	}
+
+	created := &StoredToken{
+		ID:        id,
+		Tenant:    userID,
+		Name:      name,
+		Hash:      hashSecret(secret),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	err = a.update(ctx, "create", func(s *TokenStore) error {
+		count := 0
+		for _, t := range s.Tokens {
+			if t.Tenant == userID {
+				count++
+			}
+		}
+		if count >= a.cfg.MaxTokensPerTenant {
+			return errTooManyTokens
+		}
+
+		s.Tokens[id] = created
+		return nil
+	})
+	if err != nil {
+		return TokenInfo{}, err
+	}
+	return tokenInfo(created, formatToken(id, secret)), nil
+}
+
+// Rotate replaces the secret of the tenant token. The previous secret is rejected as soon as
+// the replicas see the change.
+func (a *TokenAuthenticator) Rotate(ctx context.Context, userID, id string) (TokenInfo, error) {
+	_, secret, err := newTokenSecret()
+	if err != nil {
+		return TokenInfo{}, err
+	}
+
+	var rotated StoredToken
+	err = a.update(ctx, "rotate", func(s *TokenStore) error {
+		t, ok := s.Tokens[id]
+		if !ok || t.Tenant != userID {
+			return errTokenNotFound
+		}
+
+		t.Hash = hashSecret(secret)
+		t.RotatedAt = time.Now().UTC()
+		rotated = *t
+		return nil
+	})
+	if err != nil {
+		return TokenInfo{}, err
+	}
+	return tokenInfo(&rotated, formatToken(id, secret)), nil
+}
+
+// Revoke deletes the tenant token.
+func (a *TokenAuthenticator) Revoke(ctx context.Context, userID, id string) error {
+	return a.update(ctx, "revoke", func(s *TokenStore) error {
+		t, ok := s.Tokens[id]
+		if !ok || t.Tenant != userID {
+			return errTokenNotFound
+		}
+
+		delete(s.Tokens, id)
+		return nil
+	})
+}
+
+// update applies the change to the tokens in the KV store, and to the local copy so that
+// the change is visible to the following requests to this replica.
+func (a *TokenAuthenticator) update(ctx context.Context, operation string, change func(*TokenStore) error) error {
+	var updated *TokenStore
+	err := a.client.CAS(ctx, tokensKey, func(in interface{}) (interface{}, bool, error) {
+		current, _ := in.(*TokenStore)
+		updated = current.clone()
+		if err := change(updated); err != nil {
+			return nil, false, err
+		}
+		return updated, true, nil
+	})
+	if err != nil {
+		a.operations.WithLabelValues(operation, "failed").Inc()
+		return err
+	}
+
+	a.store.Store(updated)
+	a.operations.WithLabelValues(operation, "success").Inc()
+	return nil
+}
+
+// ServeHTTP implements http.Handler for the TokensPath endpoint. GET lists the tokens of the
+// tenant of the request, POST creates a new token and DELETE revokes the token with the
+// input id.
+func (a *TokenAuthenticator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userID, err := tenant.TenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		a.writeJSON(w, http.StatusOK, a.List(userID))
+
+	case http.MethodPost:
+		info, err := a.Create(r.Context(), userID, r.URL.Query().Get("name"))
+		if err != nil {
+			a.writeError(w, "create", err)
+			return
+		}
+		a.writeJSON(w, http.StatusCreated, info)
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, errMissingTokenID.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := a.Revoke(r.Context(), userID, id); err != nil {
+			a.writeError(w, "revoke", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// RotateHandler rotates the token with the input id, of the tenant of the request.
+func (a *TokenAuthenticator) RotateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := tenant.TenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, errMissingTokenID.Error(), http.StatusBadRequest)
+		return
+	}
+
+	info, err := a.Rotate(r.Context(), userID, id)
+	if err != nil {
+		a.writeError(w, "rotate", err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, info)
+}
+
+func (a *TokenAuthenticator) writeError(w http.ResponseWriter, operation string, err error) {
+	switch {
+	case errors.Is(err, errTokenNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errTooManyTokens):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		level.Error(a.logger).Log("msg", "failed to update tenant tokens", "operation", operation, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (a *TokenAuthenticator) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		level.Error(a.logger).Log("msg", "failed to encode tenant tokens response", "err", err)
+	}
+}
+
+func tokenInfo(t *StoredToken, token string) TokenInfo {
+	return TokenInfo{
+		ID:        t.ID,
+		Name:      t.Name,
+		CreatedAt: t.CreatedAt,
+		RotatedAt: t.RotatedAt,
+		Token:     token,
+	}
+}
+
+// newTokenSecret returns a new random token ID and secret.
+func newTokenSecret() (string, string, error) {
+	buf := make([]byte, 40)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", errors.Wrap(err, "generate token")
+	}
+	return hex.EncodeToString(buf[:8]), hex.EncodeToString(buf[8:]), nil
+}
+
+func formatToken(id, secret string) string {
+	return tokenPrefix + id + "_" + secret
+}
+
+func parseToken(token string) (id, secret string, ok bool) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return "", "", false
+	}
+
+	id, secret, ok = strings.Cut(strings.TrimPrefix(token, tokenPrefix), "_")
+	return id, secret, ok && id != "" && secret != ""
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+)
+
+func newTestTokenAuthenticator(t *testing.T, maxTokens int) *TokenAuthenticator {
+	client, closer := consul.NewInMemoryClient(tokensCodec{}, log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	cfg := TokensConfig{Enabled: true, Header: "X-Ingest-Token", MaxTokensPerTenant: maxTokens}
+	a := newTokenAuthenticator(cfg, client, log.NewNopLogger(), nil)
+	require.NoError(t, a.starting(context.Background()))
+	return a
+}
+
+func authenticateToken(a *TokenAuthenticator, token string) (string, error) {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/push", nil)
+	r.Header.Set("X-Ingest-Token", token)
+	return a.Authenticate(r)
+}
+
+func TestTokenAuthenticator_Lifecycle(t *testing.T) {
+	a := newTestTokenAuthenticator(t, 2)
+	ctx := context.Background()
+
+	_, err := a.Authenticate(httptest.NewRequest(http.MethodPost, "/api/v1/push", nil))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	created, err := a.Create(ctx, "tenant-a", "prometheus")
+	require.NoError(t, err)
+	require.NotEmpty(t, created.Token)
+
+	userID, err := authenticateToken(a, created.Token)
+	require.NoError(t, err)
+	assert.Equal(t, "tenant-a", userID)
+
+	// Only the hash of the secret is stored.
+	stored, err := a.client.Get(ctx, tokensKey)
+	require.NoError(t, err)
+	data, err := tokensCodec{}.Encode(stored)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), created.Token[len(tokenPrefix)+len(created.ID)+1:])
+
+	// Other tenants can't see or change the token.
+	assert.Empty(t, a.List("tenant-b"))
+	_, err = a.Rotate(ctx, "tenant-b", created.ID)
+	assert.ErrorIs(t, err, errTokenNotFound)
+	assert.ErrorIs(t, a.Revoke(ctx, "tenant-b", created.ID), errTokenNotFound)
+
+	rotated, err := a.Rotate(ctx, "tenant-a", created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, rotated.ID)
+
+	_, err = authenticateToken(a, created.Token)
+	assert.ErrorIs(t, err, errInvalidCredentials)
+	_, err = authenticateToken(a, rotated.Token)
+	assert.NoError(t, err)
+
+	require.NoError(t, a.Revoke(ctx, "tenant-a", created.ID))
+	_, err = authenticateToken(a, rotated.Token)
+	assert.ErrorIs(t, err, errInvalidCredentials)
+	assert.Empty(t, a.List("tenant-a"))
+}
+
+func TestTokenAuthenticator_ShouldLimitTokensPerTenant(t *testing.T) {
+	a := newTestTokenAuthenticator(t, 1)
+
+	_, err := a.Create(context.Background(), "tenant-a", "")
+	require.NoError(t, err)
+	_, err = a.Create(context.Background(), "tenant-a", "")
+	assert.ErrorIs(t, err, errTooManyTokens)
+	_, err = a.Create(context.Background(), "tenant-b", "")
+	assert.NoError(t, err)
+}
+
+func TestTokenAuthenticator_ServeHTTP(t *testing.T) {
+	a := newTestTokenAuthenticator(t, 10)
+
+	do := func(method, target string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		r = r.WithContext(user.InjectOrgID(r.Context(), "tenant-a"))
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		return rec
+	}
+
+	rec := do(http.MethodPost, TokensPath+"?name=agent", a.ServeHTTP)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	created := TokenInfo{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Equal(t, "agent", created.Name)
+
+	rec = do(http.MethodGet, TokensPath, a.ServeHTTP)
+	require.Equal(t, http.StatusOK, rec.Code)
+	listed := []TokenInfo{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+	require.Len(t, listed, 1)
+	assert.Empty(t, listed[0].Token)
+
+	rec = do(http.MethodPost, TokensRotatePath+"?id="+created.ID, a.RotateHandler)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = do(http.MethodPost, TokensRotatePath+"?id=unknown", a.RotateHandler)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = do(http.MethodDelete, TokensPath, a.ServeHTTP)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = do(http.MethodDelete, TokensPath+"?id="+created.ID, a.ServeHTTP)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+}
@@ -0,0 +1,226 @@
+package autoscaling
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/atomic"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/push"
+	"objectstorage/pkg/ratelimit"
+)
+
+// Path is the endpoint returning the autoscaling signals.
+const Path = "/ingester/autoscaling"
+
+const (
+	resourceSeries        = "series"
+	resourceIngestionRate = "ingestion_rate"
+)
+
+var (
+	errInvalidTargetUtilization = errors.New("the autoscaling target utilization must be within (0, 1]")
+	errInvalidUpdateInterval    = errors.New("the autoscaling update interval must be greater than 0")
+)
+
+// Config holds the configuration of the autoscaling signals.
+type Config struct {
+	Enabled           bool          `yaml:"enabled"`
+	TargetUtilization float64       `yaml:"target_utilization"`
+	UpdateInterval    time.Duration `yaml:"update_interval"`
+}
+
+// RegisterFlags registers the autoscaling flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "autoscaling.enabled", false, "True to export the signals to scale the ingesters on, as metrics and on the "+Path+" endpoint, for KEDA or the HPA.")
+	f.Float64Var(&cfg.TargetUtilization, "autoscaling.target-utilization", 0.7, "Utilization of the instance limits, on the max series and the max ingestion rate, the desired replicas are computed to reach.")
+	f.DurationVar(&cfg.UpdateInterval, "autoscaling.update-interval", 15*time.Second, "How frequently the signals are updated. The ingestion rate is averaged over this interval.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.TargetUtilization <= 0 || cfg.TargetUtilization > 1 {
+		return errInvalidTargetUtilization
+	}
+	if cfg.UpdateInterval <= 0 {
+		return errInvalidUpdateInterval
+	}
+	return nil
+}
+
+// ActiveSeries returns the number of series recently pushed by each tenant. It's implemented
+// by the limits.Enforcer.
+type ActiveSeries interface {
+	ActiveSeriesByUser() map[string]int
+}
+
+// RingCount returns the number of ingesters. It's implemented by the limits.RingCount.
+type RingCount interface {
+	HealthyInstancesCount() int
+}
+
+// Signals are the signals of the instance to scale the ingesters on.
+type Signals struct {
+	ActiveSeries  int     `json:"active_series"`
+	IngestionRate float64 `json:"ingestion_rate"`
+	// The utilization of each instance limit, 0 if the limit is disabled, and the highest one.
+	SeriesUtilization        float64 `json:"series_utilization"`
+	IngestionRateUtilization float64 `json:"ingestion_rate_utilization"`
+	Utilization              float64 `json:"utilization"`
+	Replicas                 int     `json:"replicas"`
+	DesiredReplicas          int     `json:"desired_replicas"`
+}
+
+// Exporter computes the signals to scale the ingesters on, from the active series and the
+// ingestion rate relative to the instance limits. The desired replicas are the replicas
+// needed for the utilization of this instance to reach the target, assuming the load is
+// spread evenly: the autoscaler is expected to take the highest hint across the ingesters.
+// If no instance limit is set, the desired replicas are the current ones.
+type Exporter struct {
+	services.Service
+
+	cfg            Config
+	activeSeries   ActiveSeries
+	ring           RingCount
+	instanceLimits ratelimit.InstanceLimitsFn
+	logger         log.Logger
+
+	samples    atomic.Int64
+	lastUpdate time.Time
+
+	mtx     sync.RWMutex
+	signals Signals
+
+	activeSeriesGauge    prometheus.Gauge
+	ingestionRateGauge   prometheus.Gauge
+	utilizationGauge     *prometheus.GaugeVec
+	desiredReplicasGauge prometheus.Gauge
+}
+
+// NewExporter makes a new Exporter.
+func NewExporter(cfg Config, activeSeries ActiveSeries, ring RingCount, instanceLimits ratelimit.InstanceLimitsFn, logger log.Logger, reg prometheus.Registerer) *Exporter {
+	e := &Exporter{
+		cfg:            cfg,
+		activeSeries:   activeSeries,
+		ring:           ring,
+		instanceLimits: instanceLimits,
+		logger:         logger,
+		activeSeriesGauge: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_autoscaling_active_series",
+			Help: "Number of series recently pushed to the instance, across all the tenants.",
+		}),
+		ingestionRateGauge: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_autoscaling_ingestion_rate_samples_per_second",
+			Help: "Rate of the samples received by the instance, averaged over the update interval.",
+		}),
+		utilizationGauge: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_autoscaling_utilization",
+			Help: "Utilization of the instance limit on the resource, 0 if the limit is disabled.",
+		}, []string{"resource"}),
+		desiredReplicasGauge: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_autoscaling_desired_replicas",
+			Help: "Number of ingesters needed for the utilization of this instance to reach the target utilization.",
+		}),
+	}
+
+	e.Service = services.NewTimerService(cfg.UpdateInterval, e.starting, e.update, nil)
+	return e
+}
+
+func (e *Exporter) starting(context.Context) error {
+	e.lastUpdate = time.Now()
+	return nil
+}
+
+// PushMiddleware returns the push.Middleware counting the samples received.
+func (e *Exporter) PushMiddleware() push.Middleware {
+	return func(next push.Func) push.Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			samples := 0
+			for _, ts := range req.Timeseries {
+				samples += len(ts.Samples)
+			}
+			e.samples.Add(int64(samples))
+			return next(ctx, req)
+		}
+	}
+}
+
+func (e *Exporter) update(context.Context) error {
+	now := time.Now()
+	elapsed := now.Sub(e.lastUpdate).Seconds()
+	e.lastUpdate = now
+
+	var s Signals
+	for _, n := range e.activeSeries.ActiveSeriesByUser() {
+		s.ActiveSeries += n
+	}
+	if elapsed > 0 {
+		s.IngestionRate = float64(e.samples.Swap(0)) / elapsed
+	}
+
+	limits := e.instanceLimits()
+	if limits.MaxInMemorySeries > 0 {
+		s.SeriesUtilization = float64(s.ActiveSeries) / float64(limits.MaxInMemorySeries)
+	}
+	if limits.MaxIngestionRate > 0 {
+		s.IngestionRateUtilization = s.IngestionRate / limits.MaxIngestionRate
+	}
+	s.Utilization = math.Max(s.SeriesUtilization, s.IngestionRateUtilization)
+
+	// The ring doesn't list this instance until it has joined.
+	s.Replicas = e.ring.HealthyInstancesCount()
+	if s.Replicas < 1 {
+		s.Replicas = 1
+	}
+	s.DesiredReplicas = s.Replicas
+	if limits.MaxInMemorySeries > 0 || limits.MaxIngestionRate > 0 {
+		s.DesiredReplicas = int(math.Ceil(float64(s.Replicas) * s.Utilization / e.cfg.TargetUtilization))
+		if s.DesiredReplicas < 1 {
+			s.DesiredReplicas = 1
+		}
+	}
+
+	e.activeSeriesGauge.Set(float64(s.ActiveSeries))
+	e.ingestionRateGauge.Set(s.IngestionRate)
+	e.utilizationGauge.WithLabelValues(resourceSeries).Set(s.SeriesUtilization)
+	e.utilizationGauge.WithLabelValues(resourceIngestionRate).Set(s.IngestionRateUtilization)
+	e.desiredReplicasGauge.Set(float64(s.DesiredReplicas))
+
+	e.mtx.Lock()
+	e.signals = s
+	e.mtx.Unlock()
+	return nil
+}
+
+// Signals returns the signals as of the last update.
+func (e *Exporter) Signals() Signals {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	return e.signals
+}
+
+// ServeHTTP returns the signals as of the last update, for the autoscalers polling JSON
+// endpoints, such as the metrics API scaler of KEDA.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(e.Signals()); err != nil {
+		level.Error(e.logger).Log("msg", "failed to encode the autoscaling signals", "err", err)
+	}
+}
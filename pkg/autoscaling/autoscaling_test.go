@@ -0,0 +1,134 @@
+package autoscaling
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+
+	"objectstorage/pkg/ratelimit"
+)
+
+type activeSeriesMock map[string]int
+
+func (m activeSeriesMock) ActiveSeriesByUser() map[string]int { return m }
+
+type ringCountMock int
+
+func (m ringCountMock) HealthyInstancesCount() int { return int(m) }
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		cfg      Config
+		expected error
+	}{
+		"disabled": {
+			cfg: Config{},
+		},
+		"enabled": {
+			cfg: Config{Enabled: true, TargetUtilization: 0.7, UpdateInterval: time.Second},
+		},
+		"invalid target utilization": {
+			cfg:      Config{Enabled: true, TargetUtilization: 1.5, UpdateInterval: time.Second},
+			expected: errInvalidTargetUtilization,
+		},
+		"invalid update interval": {
+			cfg:      Config{Enabled: true, TargetUtilization: 0.7},
+			expected: errInvalidUpdateInterval,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.cfg.Validate())
+		})
+	}
+}
+
+func TestExporter(t *testing.T) {
+	tests := map[string]struct {
+		limits   ratelimit.InstanceLimits
+		replicas int
+		expected Signals
+	}{
+		"series bound": {
+			limits:   ratelimit.InstanceLimits{MaxInMemorySeries: 1000, MaxIngestionRate: 1000000},
+			replicas: 3,
+			// 800 series out of 1000, for a target of 50%: 3*0.8/0.5 = 4.8 replicas.
+			expected: Signals{ActiveSeries: 800, SeriesUtilization: 0.8, Utilization: 0.8, Replicas: 3, DesiredReplicas: 5},
+		},
+		"scale down": {
+			limits:   ratelimit.InstanceLimits{MaxInMemorySeries: 8000},
+			replicas: 10,
+			expected: Signals{ActiveSeries: 800, SeriesUtilization: 0.1, Utilization: 0.1, Replicas: 10, DesiredReplicas: 2},
+		},
+		"no limit": {
+			replicas: 3,
+			expected: Signals{ActiveSeries: 800, Replicas: 3, DesiredReplicas: 3},
+		},
+		"not in the ring yet": {
+			limits:   ratelimit.InstanceLimits{MaxInMemorySeries: 1000},
+			expected: Signals{ActiveSeries: 800, SeriesUtilization: 0.8, Utilization: 0.8, Replicas: 1, DesiredReplicas: 2},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			limits := func() ratelimit.InstanceLimits { return tc.limits }
+			e := NewExporter(Config{Enabled: true, TargetUtilization: 0.5, UpdateInterval: time.Minute}, activeSeriesMock{"user-1": 500, "user-2": 300}, ringCountMock(tc.replicas), limits, log.NewNopLogger(), nil)
+			require.NoError(t, e.starting(context.Background()))
+			require.NoError(t, e.update(context.Background()))
+			assert.Equal(t, tc.expected, e.Signals())
+		})
+	}
+}
+
+func TestExporter_IngestionRate(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	limits := func() ratelimit.InstanceLimits { return ratelimit.InstanceLimits{MaxIngestionRate: 100} }
+	e := NewExporter(Config{Enabled: true, TargetUtilization: 0.5, UpdateInterval: time.Minute}, activeSeriesMock{}, ringCountMock(2), limits, log.NewNopLogger(), reg)
+
+	push := e.PushMiddleware()(func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		return &cortexpb.WriteResponse{}, nil
+	})
+	_, err := push(context.Background(), &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{
+		{TimeSeries: &cortexpb.TimeSeries{Samples: make([]cortexpb.Sample, 60)}},
+		{TimeSeries: &cortexpb.TimeSeries{Samples: make([]cortexpb.Sample, 60)}},
+	}})
+	require.NoError(t, err)
+
+	// 120 samples over 2 seconds.
+	e.lastUpdate = time.Now().Add(-2 * time.Second)
+	require.NoError(t, e.update(context.Background()))
+	s := e.Signals()
+	assert.InDelta(t, 60, s.IngestionRate, 1)
+	assert.InDelta(t, 0.6, s.Utilization, 0.01)
+	assert.Equal(t, 3, s.DesiredReplicas)
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_autoscaling_desired_replicas Number of ingesters needed for the utilization of this instance to reach the target utilization.
+		# TYPE cortex_autoscaling_desired_replicas gauge
+		cortex_autoscaling_desired_replicas 3
+	`), "cortex_autoscaling_desired_replicas"))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path, nil))
+	var signals Signals
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&signals))
+	assert.Equal(t, s, signals)
+
+	// The samples are counted again from the update.
+	require.NoError(t, e.update(context.Background()))
+	assert.Zero(t, e.Signals().IngestionRate)
+}
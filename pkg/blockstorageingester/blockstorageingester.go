@@ -38,6 +38,7 @@ import (
 	"github.com/cortexproject/cortex/pkg/querier/tripperware/queryrange"
 	querier_worker "github.com/cortexproject/cortex/pkg/querier/worker"
 	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/ring/kv"
 	"github.com/cortexproject/cortex/pkg/ring/kv/memberlist"
 	"github.com/cortexproject/cortex/pkg/ruler"
 	"github.com/cortexproject/cortex/pkg/ruler/rulestore"
@@ -55,11 +56,82 @@ import (
 	"github.com/pkg/errors"
 	prom_storage "github.com/prometheus/prometheus/storage"
 	v1 "github.com/prometheus/prometheus/web/api/v1"
+	"github.com/thanos-io/objstore"
+	"github.com/weaveworks/common/middleware"
 	"github.com/weaveworks/common/server"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/grpc"
+
+	"objectstorage/pkg/admin"
+	"objectstorage/pkg/audit"
+	"objectstorage/pkg/auth"
+	"objectstorage/pkg/autoscaling"
+	"objectstorage/pkg/bucketindexer"
+	"objectstorage/pkg/cardinality"
+	"objectstorage/pkg/costattribution"
+	"objectstorage/pkg/deadletter"
+	"objectstorage/pkg/events"
+	"objectstorage/pkg/faultinjection"
+	"objectstorage/pkg/forwarder"
+	"objectstorage/pkg/hatracker"
+	"objectstorage/pkg/ingest"
+	"objectstorage/pkg/ingester/consistency"
+	"objectstorage/pkg/ingester/handover"
+	"objectstorage/pkg/ingester/head"
+	ingester_metrics "objectstorage/pkg/ingester/metrics"
+	"objectstorage/pkg/ingester/prepareshutdown"
+	"objectstorage/pkg/ingester/readonly"
+	"objectstorage/pkg/ingester/shipper"
+	"objectstorage/pkg/ipfilter"
+	"objectstorage/pkg/limits"
+	"objectstorage/pkg/metricfilter"
+	"objectstorage/pkg/overrides"
+	"objectstorage/pkg/profiling"
+	"objectstorage/pkg/push"
+	local_querier "objectstorage/pkg/querier"
+	"objectstorage/pkg/ratelimit"
+	"objectstorage/pkg/readiness"
+	"objectstorage/pkg/realip"
+	"objectstorage/pkg/relabeling"
+	"objectstorage/pkg/replication"
+	"objectstorage/pkg/routetimeout"
+	"objectstorage/pkg/scraper"
+	"objectstorage/pkg/seriesdeletion"
+	"objectstorage/pkg/seriesvalidation"
+	"objectstorage/pkg/shadow"
+	"objectstorage/pkg/singleport"
+	bucket_tsdb "objectstorage/pkg/storage/tsdb"
+	"objectstorage/pkg/tee"
+	"objectstorage/pkg/tenant"
+	"objectstorage/pkg/tenantdeletion"
+	"objectstorage/pkg/tiering"
+	"objectstorage/pkg/unixsocket"
+	"objectstorage/pkg/usagestats"
+	"objectstorage/pkg/util/dnsaddr"
+	"objectstorage/pkg/util/fips"
+	"objectstorage/pkg/util/histogram"
+	"objectstorage/pkg/util/httpcompress"
+	"objectstorage/pkg/util/intern"
+	"objectstorage/pkg/util/leaderelection"
+	"objectstorage/pkg/util/logging"
+	"objectstorage/pkg/util/memlimit"
+	"objectstorage/pkg/util/servertls"
+	util_tracing "objectstorage/pkg/util/tracing"
+	"objectstorage/pkg/util/utf8names"
+	"objectstorage/pkg/util/zonedetect"
+	"objectstorage/pkg/webhook"
 )
 
 var (
 	errInvalidHTTPPrefix = errors.New("HTTP prefix should be empty or start with /")
+	errMemberlistTLS     = errors.New("memberlist TLS is required but -memberlist.tls-enabled is false")
+	errShipperConflict   = errors.New("the shipper requires the ingester shipping to be disabled with -blocks-storage.tsdb.ship-interval=0")
+	errFaultInjection    = errors.New("the fault injection requires the admin listener, serving its API, to be enabled")
+	errForwarderConflict = errors.New("the forwarder, never building blocks, can't be enabled along with the ingest storage or the shipper")
+	errTieringIndex      = errors.New("the tiering, looking up the blocks in the bucket index, requires the bucket index to be enabled")
+	errSeriesDeletion    = errors.New("the series deletion, tracking the tombstones in the bucket index, requires the bucket index to be enabled")
+	errTenantTokens      = errors.New("the tenant tokens require the admin listener, serving their management API, to be enabled")
+	errHandoverAuth      = errors.New("the ingester handover, sending the TSDBs on behalf of their tenant without credentials, can't be enabled along with the static, JWT or tenant tokens authentication")
 )
 
 // The design pattern for Cortex is a series of config objects, which are
@@ -81,10 +153,11 @@ var (
 
 // Config is the root config for Cortex.
 type Config struct {
-	Target      flagext.StringSliceCSV `yaml:"target"`
-	AuthEnabled bool                   `yaml:"auth_enabled"`
-	PrintConfig bool                   `yaml:"-"`
-	HTTPPrefix  string                 `yaml:"http_prefix"`
+	Target       flagext.StringSliceCSV `yaml:"target"`
+	AuthEnabled  bool                   `yaml:"auth_enabled"`
+	NoAuthTenant string                 `yaml:"no_auth_tenant"`
+	PrintConfig  bool                   `yaml:"-"`
+	HTTPPrefix   string                 `yaml:"http_prefix"`
 
 	ExternalQueryable prom_storage.Queryable `yaml:"-"`
 	ExternalPusher    ruler.Pusher           `yaml:"-"`
@@ -107,16 +180,72 @@ type Config struct {
 	StoreGateway     storegateway.Config             `yaml:"store_gateway"`
 	TenantFederation tenantfederation.Config         `yaml:"tenant_federation"`
 
-	Ruler               ruler.Config                               `yaml:"ruler"`
-	RulerStorage        rulestore.Config                           `yaml:"ruler_storage"`
-	Configs             configs.Config                             `yaml:"configs"`
-	Alertmanager        alertmanager.MultitenantAlertmanagerConfig `yaml:"alertmanager"`
-	AlertmanagerStorage alertstore.Config                          `yaml:"alertmanager_storage"`
-	RuntimeConfig       runtimeconfig.Config                       `yaml:"runtime_config"`
-	MemberlistKV        memberlist.KVConfig                        `yaml:"memberlist"`
-	QueryScheduler      scheduler.Config                           `yaml:"query_scheduler"`
+	Ruler                ruler.Config                               `yaml:"ruler"`
+	RulerStorage         rulestore.Config                           `yaml:"ruler_storage"`
+	Configs              configs.Config                             `yaml:"configs"`
+	Alertmanager         alertmanager.MultitenantAlertmanagerConfig `yaml:"alertmanager"`
+	AlertmanagerStorage  alertstore.Config                          `yaml:"alertmanager_storage"`
+	RuntimeConfig        runtimeconfig.Config                       `yaml:"runtime_config"`
+	MemberlistKV         memberlist.KVConfig                        `yaml:"memberlist"`
+	MemberlistRequireTLS bool                                       `yaml:"memberlist_require_tls"`
+	QueryScheduler       scheduler.Config                           `yaml:"query_scheduler"`
 
 	Tracing tracing.Config `yaml:"tracing"`
+
+	IngesterHandover    handover.Config                 `yaml:"ingester_handover"`
+	IngesterReadOnly    readonly.Config                 `yaml:"ingester_read_only"`
+	IngestStorage       ingest.Config                   `yaml:"ingest_storage"`
+	LeaderElection      leaderelection.Config           `yaml:"leader_election"`
+	WriteFederation     push.FederationConfig           `yaml:"tenant_federation_write"`
+	IngestionLimits     limits.Config                   `yaml:"ingestion_limits"`
+	Overrides           overrides.Config                `yaml:"overrides"`
+	TenantDeletion      tenantdeletion.Config           `yaml:"tenant_deletion"`
+	HATracker           hatracker.Config                `yaml:"ha_tracker"`
+	PushRateLimits      ratelimit.Config                `yaml:"push_rate_limits"`
+	CostAttribution     costattribution.Config          `yaml:"cost_attribution"`
+	Cardinality         cardinality.Config              `yaml:"cardinality"`
+	ServerTLS           servertls.Config                `yaml:"server_tls"`
+	ClientCertAuth      auth.ClientCertConfig           `yaml:"client_cert_auth"`
+	StaticAuth          auth.StaticConfig               `yaml:"static_auth"`
+	JWTAuth             auth.JWTConfig                  `yaml:"jwt_auth"`
+	IPFilter            ipfilter.Config                 `yaml:"ip_filter"`
+	RealIP              realip.Config                   `yaml:"real_ip"`
+	UnixSocket          unixsocket.Config               `yaml:"unix_socket"`
+	SinglePort          singleport.Config               `yaml:"single_port"`
+	RouteTimeout        routetimeout.Config             `yaml:"route_timeout"`
+	ResponseCompression httpcompress.Config             `yaml:"response_compression"`
+	DeadLetter          deadletter.Config               `yaml:"dead_letter"`
+	Audit               audit.Config                    `yaml:"audit"`
+	Crypto              fips.Config                     `yaml:"crypto"`
+	TenantTokens        auth.TokensConfig               `yaml:"tenant_tokens"`
+	RequestLog          logging.RequestsConfig          `yaml:"request_log"`
+	IngestionMetrics    ingester_metrics.Config         `yaml:"ingestion_metrics"`
+	Admin               admin.Config                    `yaml:"admin"`
+	UsageStats          usagestats.Config               `yaml:"usage_stats"`
+	Histograms          histogram.Config                `yaml:"histograms"`
+	Profiling           profiling.Config                `yaml:"profiling"`
+	LocalQuerier        local_querier.Config            `yaml:"local_querier"`
+	HeadAPI             head.Config                     `yaml:"head_api"`
+	PushHandler         push.HandlerConfig              `yaml:"push_handler"`
+	Shipper             shipper.Config                  `yaml:"shipper"`
+	MemoryLimit         memlimit.Config                 `yaml:"memory_limit"`
+	LabelInterning      intern.Config                   `yaml:"label_interning"`
+	BucketIndex         bucketindexer.Config            `yaml:"bucket_index"`
+	FaultInjection      faultinjection.Config           `yaml:"fault_injection"`
+	ConsistencyCheck    consistency.Config              `yaml:"consistency_check"`
+	Readiness           readiness.Config                `yaml:"readiness"`
+	ZoneDetection       zonedetect.Config               `yaml:"zone_detection"`
+	Autoscaling         autoscaling.Config              `yaml:"autoscaling"`
+	Webhook             webhook.Config                  `yaml:"webhook"`
+	Events              events.Config                   `yaml:"events"`
+	Scraper             scraper.Config                  `yaml:"scraper"`
+	Forwarder           forwarder.Config                `yaml:"forwarder"`
+	Tee                 tee.Config                      `yaml:"tee"`
+	Shadow              shadow.Config                   `yaml:"shadow"`
+	Replication         replication.Config              `yaml:"replication"`
+	Tiering             tiering.Config                  `yaml:"tiering"`
+	SeriesDeletion      seriesdeletion.Config           `yaml:"series_deletion"`
+	MetadataCache       bucket_tsdb.MetadataCacheConfig `yaml:"metadata_cache"`
 }
 
 // RegisterFlags registers flag.
@@ -132,6 +261,7 @@ func (c *Config) RegisterFlags(f *flag.FlagSet) {
 		"Use '-modules' command line flag to get a list of available modules, and to see which modules are included in 'all'.")
 
 	f.BoolVar(&c.AuthEnabled, "auth.enabled", true, "Set to false to disable auth.")
+	f.StringVar(&c.NoAuthTenant, "auth.no-auth-tenant", tenant.DefaultNoAuthTenant, "Tenant ID to use for all requests when -auth.enabled=false.")
 	f.BoolVar(&c.PrintConfig, "print.config", false, "Print the config and exit.")
 	f.StringVar(&c.HTTPPrefix, "http.prefix", "/api/prom", "HTTP path prefix for Cortex API.")
 
@@ -148,7 +278,7 @@ func (c *Config) RegisterFlags(f *flag.FlagSet) {
 	c.Worker.RegisterFlags(f)
 	c.Frontend.RegisterFlags(f)
 	c.QueryRange.RegisterFlags(f)
-	c.BlocksStorage.RegisterFlags(f)
+	c.registerBlocksStorageFlagsWithChangedDefaultValues(f)
 	c.Compactor.RegisterFlags(f)
 	c.StoreGateway.RegisterFlags(f)
 	c.TenantFederation.RegisterFlags(f)
@@ -160,8 +290,64 @@ func (c *Config) RegisterFlags(f *flag.FlagSet) {
 	c.AlertmanagerStorage.RegisterFlags(f)
 	c.RuntimeConfig.RegisterFlags(f)
 	c.MemberlistKV.RegisterFlags(f)
+	f.BoolVar(&c.MemberlistRequireTLS, "memberlist.require-tls", false, "True to refuse to start if memberlist is used by any ring or KV store and the gossip transport doesn't have TLS enabled.")
 	c.QueryScheduler.RegisterFlags(f)
 	c.Tracing.RegisterFlags(f)
+
+	c.IngesterHandover.RegisterFlags(f)
+	c.IngesterReadOnly.RegisterFlags(f)
+	c.IngestStorage.RegisterFlags(f)
+	c.LeaderElection.RegisterFlags(f)
+	c.WriteFederation.RegisterFlags(f)
+	c.IngestionLimits.RegisterFlags(f)
+	c.Overrides.RegisterFlags(f)
+	c.TenantDeletion.RegisterFlags(f)
+	c.HATracker.RegisterFlags(f)
+	c.PushRateLimits.RegisterFlags(f)
+	c.CostAttribution.RegisterFlags(f)
+	c.Cardinality.RegisterFlags(f)
+	c.ServerTLS.RegisterFlags(f)
+	c.ClientCertAuth.RegisterFlags(f)
+	c.StaticAuth.RegisterFlags(f)
+	c.JWTAuth.RegisterFlags(f)
+	c.IPFilter.RegisterFlags(f)
+	c.RealIP.RegisterFlags(f)
+	c.UnixSocket.RegisterFlags(f)
+	c.SinglePort.RegisterFlags(f)
+	c.RouteTimeout.RegisterFlags(f)
+	c.ResponseCompression.RegisterFlags(f)
+	c.DeadLetter.RegisterFlags(f)
+	c.Audit.RegisterFlags(f)
+	c.Crypto.RegisterFlags(f)
+	c.TenantTokens.RegisterFlags(f)
+	c.RequestLog.RegisterFlags(f)
+	c.IngestionMetrics.RegisterFlags(f)
+	c.Admin.RegisterFlags(f)
+	c.UsageStats.RegisterFlags(f)
+	c.Histograms.RegisterFlags(f)
+	c.Profiling.RegisterFlags(f)
+	c.LocalQuerier.RegisterFlags(f)
+	c.HeadAPI.RegisterFlags(f)
+	c.PushHandler.RegisterFlags(f)
+	c.Shipper.RegisterFlags(f)
+	c.MemoryLimit.RegisterFlags(f)
+	c.LabelInterning.RegisterFlags(f)
+	c.BucketIndex.RegisterFlags(f)
+	c.FaultInjection.RegisterFlags(f)
+	c.ConsistencyCheck.RegisterFlags(f)
+	c.Readiness.RegisterFlags(f)
+	c.ZoneDetection.RegisterFlags(f)
+	c.Autoscaling.RegisterFlags(f)
+	c.Webhook.RegisterFlags(f)
+	c.Events.RegisterFlags(f)
+	c.Scraper.RegisterFlags(f)
+	c.Forwarder.RegisterFlags(f)
+	c.Tee.RegisterFlags(f)
+	c.Shadow.RegisterFlags(f)
+	c.Replication.RegisterFlags(f)
+	c.Tiering.RegisterFlags(f)
+	c.SeriesDeletion.RegisterFlags(f)
+	c.MetadataCache.RegisterFlagsWithPrefix(f, "metadata-cache.")
 }
 
 // Validate the cortex config and returns an error if the validation
@@ -175,6 +361,12 @@ func (c *Config) Validate(log log.Logger) error {
 		return errInvalidHTTPPrefix
 	}
 
+	if !c.AuthEnabled {
+		if err := tenant.ValidTenantID(c.NoAuthTenant); err != nil {
+			return errors.Wrap(err, "invalid no-auth tenant")
+		}
+	}
+
 	if err := c.Storage.Validate(); err != nil {
 		return errors.Wrap(err, "invalid storage config")
 	}
@@ -218,9 +410,175 @@ func (c *Config) Validate(log log.Logger) error {
 		return errors.Wrap(err, "invalid alertmanager config")
 	}
 
+	if c.MemberlistRequireTLS && c.isMemberlistUsed() && !c.MemberlistKV.TCPTransport.TLSEnabled {
+		return errMemberlistTLS
+	}
+
+	// The OTLP exporter settings can also be set via the OpenTelemetry and Jaeger client
+	// environment variables, which must be applied before validating them.
+	util_tracing.ApplyEnv(&c.Tracing)
 	if err := c.Tracing.Validate(); err != nil {
 		return errors.Wrap(err, "invalid tracing config")
 	}
+	if err := c.IngesterHandover.Validate(); err != nil {
+		return errors.Wrap(err, "invalid ingester_handover config")
+	}
+	if c.IngesterHandover.Enabled && (c.StaticAuth.Enabled() || c.JWTAuth.Enabled() || c.TenantTokens.Enabled) {
+		return errHandoverAuth
+	}
+	if err := c.IngestStorage.Validate(); err != nil {
+		return errors.Wrap(err, "invalid ingest_storage config")
+	}
+	if err := c.LeaderElection.Validate(); err != nil {
+		return errors.Wrap(err, "invalid leader_election config")
+	}
+	if err := c.IngestionLimits.Validate(); err != nil {
+		return errors.Wrap(err, "invalid ingestion_limits config")
+	}
+	if err := c.Overrides.Validate(); err != nil {
+		return errors.Wrap(err, "invalid overrides config")
+	}
+	if err := c.HATracker.Validate(); err != nil {
+		return errors.Wrap(err, "invalid ha_tracker config")
+	}
+	if err := c.PushRateLimits.Validate(); err != nil {
+		return errors.Wrap(err, "invalid push_rate_limits config")
+	}
+	if err := c.Cardinality.Validate(); err != nil {
+		return errors.Wrap(err, "invalid cardinality config")
+	}
+	if err := c.ServerTLS.Validate(c.Server); err != nil {
+		return errors.Wrap(err, "invalid server_tls config")
+	}
+	if err := c.ClientCertAuth.Validate(c.Server); err != nil {
+		return errors.Wrap(err, "invalid client_cert_auth config")
+	}
+	if err := c.StaticAuth.Validate(); err != nil {
+		return errors.Wrap(err, "invalid static_auth config")
+	}
+	if err := c.JWTAuth.Validate(); err != nil {
+		return errors.Wrap(err, "invalid jwt_auth config")
+	}
+	if err := c.IPFilter.Validate(); err != nil {
+		return errors.Wrap(err, "invalid ip_filter config")
+	}
+	if err := c.RealIP.Validate(); err != nil {
+		return errors.Wrap(err, "invalid real_ip config")
+	}
+	if err := c.UnixSocket.Validate(); err != nil {
+		return errors.Wrap(err, "invalid unix_socket config")
+	}
+	if err := c.RouteTimeout.Validate(); err != nil {
+		return errors.Wrap(err, "invalid route_timeout config")
+	}
+	if err := c.ResponseCompression.Validate(); err != nil {
+		return errors.Wrap(err, "invalid response_compression config")
+	}
+	if err := c.DeadLetter.Validate(); err != nil {
+		return errors.Wrap(err, "invalid dead_letter config")
+	}
+	if err := c.Crypto.Validate(c.fipsSettings()); err != nil {
+		return errors.Wrap(err, "invalid crypto config")
+	}
+	if err := c.TenantTokens.Validate(); err != nil {
+		return errors.Wrap(err, "invalid tenant_tokens config")
+	}
+	if c.TenantTokens.Enabled && !c.Admin.Enabled() {
+		return errTenantTokens
+	}
+	if err := c.RequestLog.Validate(); err != nil {
+		return errors.Wrap(err, "invalid request_log config")
+	}
+	if err := c.IngestionMetrics.Validate(); err != nil {
+		return errors.Wrap(err, "invalid ingestion_metrics config")
+	}
+	if err := c.Admin.Validate(); err != nil {
+		return errors.Wrap(err, "invalid admin config")
+	}
+	if err := c.UsageStats.Validate(); err != nil {
+		return errors.Wrap(err, "invalid usage_stats config")
+	}
+	if err := c.Histograms.Validate(); err != nil {
+		return errors.Wrap(err, "invalid histograms config")
+	}
+	if err := c.Profiling.Validate(); err != nil {
+		return errors.Wrap(err, "invalid profiling config")
+	}
+	if err := c.LocalQuerier.Validate(); err != nil {
+		return errors.Wrap(err, "invalid local_querier config")
+	}
+	if err := c.HeadAPI.Validate(); err != nil {
+		return errors.Wrap(err, "invalid head_api config")
+	}
+	if err := c.Shipper.Validate(); err != nil {
+		return errors.Wrap(err, "invalid shipper config")
+	}
+	if c.Shipper.Enabled && c.BlocksStorage.TSDB.ShipInterval > 0 {
+		return errShipperConflict
+	}
+	if err := c.MemoryLimit.Validate(); err != nil {
+		return errors.Wrap(err, "invalid memory_limit config")
+	}
+	if err := c.LabelInterning.Validate(); err != nil {
+		return errors.Wrap(err, "invalid label_interning config")
+	}
+	if err := c.BucketIndex.Validate(); err != nil {
+		return errors.Wrap(err, "invalid bucket_index config")
+	}
+	if c.FaultInjection.Enabled && !c.Admin.Enabled() {
+		return errFaultInjection
+	}
+	if err := c.ConsistencyCheck.Validate(); err != nil {
+		return errors.Wrap(err, "invalid consistency_check config")
+	}
+	if err := c.Readiness.Validate(); err != nil {
+		return errors.Wrap(err, "invalid readiness config")
+	}
+	if err := c.ZoneDetection.Validate(); err != nil {
+		return errors.Wrap(err, "invalid zone_detection config")
+	}
+	if err := c.Autoscaling.Validate(); err != nil {
+		return errors.Wrap(err, "invalid autoscaling config")
+	}
+	if err := c.Webhook.Validate(); err != nil {
+		return errors.Wrap(err, "invalid webhook config")
+	}
+	if err := c.Events.Validate(); err != nil {
+		return errors.Wrap(err, "invalid events config")
+	}
+	if err := c.Scraper.Validate(); err != nil {
+		return errors.Wrap(err, "invalid scraper config")
+	}
+	if err := c.Forwarder.Validate(); err != nil {
+		return errors.Wrap(err, "invalid forwarder config")
+	}
+	if c.Forwarder.Enabled && (c.IngestStorage.Enabled || c.Shipper.Enabled) {
+		return errForwarderConflict
+	}
+	if err := c.Tee.Validate(); err != nil {
+		return errors.Wrap(err, "invalid tee config")
+	}
+	if err := c.Shadow.Validate(); err != nil {
+		return errors.Wrap(err, "invalid shadow config")
+	}
+	if err := c.Replication.Validate(); err != nil {
+		return errors.Wrap(err, "invalid replication config")
+	}
+	if err := c.Tiering.Validate(); err != nil {
+		return errors.Wrap(err, "invalid tiering config")
+	}
+	if c.Tiering.Enabled && !c.BucketIndex.Enabled {
+		return errTieringIndex
+	}
+	if err := c.SeriesDeletion.Validate(); err != nil {
+		return errors.Wrap(err, "invalid series deletion config")
+	}
+	if c.SeriesDeletion.Enabled && !c.BucketIndex.Enabled {
+		return errSeriesDeletion
+	}
+	if err := c.MetadataCache.Validate(); err != nil {
+		return errors.Wrap(err, "invalid metadata cache config")
+	}
 
 	return nil
 }
@@ -229,6 +587,36 @@ func (c *Config) isModuleEnabled(m string) bool {
 	return util.StringsContain(c.Target, m)
 }
 
+// fipsSettings returns the settings relying on cryptography checked in FIPS-only mode.
+func (c *Config) fipsSettings() fips.Settings {
+	return fips.Settings{
+		Server:             c.Server,
+		HTPasswdFile:       c.StaticAuth.HTPasswdFile,
+		S3SignatureVersion: c.BlocksStorage.Bucket.S3.SignatureVersion,
+		InsecureSkipVerify: map[string]bool{
+			"memberlist":      c.MemberlistKV.TCPTransport.TLSEnabled && c.MemberlistKV.TCPTransport.TLS.InsecureSkipVerify,
+			"ingester client": c.IngesterClient.GRPCClientConfig.TLSEnabled && c.IngesterClient.GRPCClientConfig.TLS.InsecureSkipVerify,
+			"S3 client":       c.BlocksStorage.Bucket.S3.HTTP.InsecureSkipVerify,
+		},
+	}
+}
+
+// isMemberlistUsed returns whether memberlist is configured as the KV store, or as
+// part of a multi KV store, of any ring.
+func (c *Config) isMemberlistUsed() bool {
+	for _, kvCfg := range []kv.Config{
+		c.Ingester.LifecyclerConfig.RingConfig.KVStore,
+		c.StoreGateway.ShardingRing.KVStore,
+		c.Compactor.ShardingRing.KVStore,
+	} {
+		if kvCfg.Store == "memberlist" || (kvCfg.Store == "multi" &&
+			(kvCfg.Multi.Primary == "memberlist" || kvCfg.Multi.Secondary == "memberlist")) {
+			return true
+		}
+	}
+	return false
+}
+
 // validateYAMLEmptyNodes ensure that no empty node has been specified in the YAML config file.
 // When an empty node is defined in YAML, the YAML parser sets the whole struct to its zero value
 // and so we loose all default values. It's very difficult to detect this case for the user, so we
@@ -265,12 +653,44 @@ func (c *Config) registerServerFlagsWithChangedDefaultValues(fs *flag.FlagSet) {
 
 	throwaway.VisitAll(func(f *flag.Flag) {
 		// Ignore errors when setting new values. We have a test to verify that it works.
+		// The max connection age and idle time keep their infinite defaults, since the
+		// connections of the agents are meant to be long-lived.
 		switch f.Name {
 		case "server.grpc.keepalive.min-time-between-pings":
 			_ = f.Value.Set("10s")
 
 		case "server.grpc.keepalive.ping-without-stream-allowed":
 			_ = f.Value.Set("true")
+
+		// The remote-write batches of the agents are up to 50MB, and the defaults of 4MB
+		// refuse them.
+		case "server.grpc-max-recv-msg-size-bytes", "server.grpc-max-send-msg-size-bytes":
+			_ = f.Value.Set("104857600")
+
+		// The agents keep a stream open per shard on long-lived connections.
+		case "server.grpc-max-concurrent-streams":
+			_ = f.Value.Set("1000")
+
+		// Closes the connections of the agents gone without closing them, such as behind
+		// a NAT dropping the idle flows, instead of keeping them forever.
+		case "server.grpc.keepalive.time":
+			_ = f.Value.Set("1m")
+		}
+
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+}
+
+func (c *Config) registerBlocksStorageFlagsWithChangedDefaultValues(fs *flag.FlagSet) {
+	throwaway := flag.NewFlagSet("throwaway", flag.PanicOnError)
+	c.BlocksStorage.RegisterFlags(throwaway)
+
+	throwaway.VisitAll(func(f *flag.Flag) {
+		switch f.Name {
+		// The store-gateways mmap the index-header of their blocks on first query, and release
+		// the ones not queried for the idle timeout, instead of keeping all of them mmapped.
+		case "blocks-storage.bucket-store.index-header-lazy-loading-enabled":
+			_ = f.Value.Set("true")
 		}
 
 		fs.Var(f.Value, f.Name, f.Usage)
@@ -310,11 +730,323 @@ type BlockstorageIngester struct {
 	StoreGateway *storegateway.StoreGateway
 	MemberlistKV *memberlist.KVInitService
 
+	// The ring lifecycler of the local ingester, if running.
+	IngesterLifecycler *ring.Lifecycler
+
+	HandoverReceiver *handover.Receiver
+	HandoverSender   *handover.Sender
+	ReadOnly         *readonly.Manager
+	PrepareShutdown  *prepareshutdown.Manager
+	IngestWriter     *ingest.Writer
+	PartitionReader  *ingest.PartitionReader
+	LeaderElectionKV kv.Client
+	WriteFederation  *push.Federation
+	IngestionLimits  *limits.Enforcer
+	IngestionMetrics *ingester_metrics.Metrics
+	Admin            *admin.Server
+	UsageStats       *usagestats.Reporter
+	Profiler         *profiling.Profiler
+	LocalQuerier     *local_querier.Querier
+	HeadAPI          *head.API
+	Shipper          *shipper.Shipper
+	BucketIndexer    *bucketindexer.Indexer
+	Replicator       *replication.Replicator
+	Tierer           *tiering.Tierer
+	SeriesDeleter    *seriesdeletion.Deleter
+	FaultInjector    *faultinjection.Injector
+	ConsistencyCheck *consistency.Checker
+	StorageProbe     *readiness.StorageProbe
+	Autoscaling      *autoscaling.Exporter
+	Webhook          *webhook.Notifier
+	Events           *events.Bus
+	Scraper          *scraper.Scraper
+	Forwarder        *forwarder.Forwarder
+	Tee              *tee.Tee
+	Shadow           *shadow.Mirror
+
+	// The per-tenant settings not covered by the limits, and where the runtime config is
+	// loaded from when the overrides are KV-backed.
+	TenantSettings  overrides.TenantSettings
+	TenantOverrides *overrides.Overrides
+	RuntimeConfigKV *overrides.KVProvider
+
+	// The bucket client shared by the modules accessing the blocks storage.
+	Bucket objstore.Bucket
+	// The bucket client caching the bucket index and the blocks metadata, shared by the
+	// read path and the tooling only, since the cache isn't invalidated on upload.
+	CachingBucket  objstore.Bucket
+	TenantDeletion *tenantdeletion.Deleter
+	HATracker      *hatracker.Tracker
+	RateLimiter    *ratelimit.Limiter
+	CostTracker    *costattribution.Tracker
+	Relabeler      *relabeling.Relabeler
+	MetricFilter   *metricfilter.Filter
+	Validator      *seriesvalidation.Validator
+	Cardinality    *cardinality.API
+	DeadLetter     *deadletter.Writer
+	TLSWatcher     *servertls.Watcher
+	StaticAuth     *auth.StaticAuthenticator
+	JWTAuth        *auth.JWTAuthenticator
+	TokenAuth      *auth.TokenAuthenticator
+
+	// Authenticates the HTTP requests with the enabled built-in authenticators, if any.
+	HTTPAuth *auth.Middleware
+
+	// Filter the requests by source address, per listener and per tenant.
+	ListenerIPFilter *ipfilter.ListenerFilter
+	TenantIPFilter   *ipfilter.TenantFilter
+
+	// Reads the PROXY protocol header of the connections from the load balancers, if enabled.
+	ProxyProtocol *realip.ProxyProtocol
+
+	// Logs the administrative operations, if enabled.
+	AuditLog *audit.Logger
+
+	// Applies the server-side timeouts to the push, admin and query routes.
+	RouteTimeouts *routetimeout.Timeouts
+
+	// Compresses the responses of the HTTP routes, if enabled.
+	Compressor *httpcompress.Compressor
+
+	// The push path, wrapping the ingester (or the ingest storage writer) with the
+	// configured middlewares.
+	PushFunc push.Func
+
 	// Queryables that the querier should use to query the long
 	// term storage. It depends on the storage engine used.
 	StoreQueryables []querier.QueryableWithFilter
 }
 
+// New makes a new BlockstorageIngester.
+func New(cfg Config) (*BlockstorageIngester, error) {
+	t := &BlockstorageIngester{
+		Cfg: cfg,
+	}
+
+	// Set before anything is allocated, to keep the heap below the container limit during
+	// the memory spikes of the head compactions.
+	if _, err := memlimit.Apply(t.Cfg.MemoryLimit, util_log.Logger, prometheus.DefaultRegisterer); err != nil {
+		return nil, err
+	}
+	intern.Init(t.Cfg.LabelInterning, prometheus.DefaultRegisterer)
+	// The runtime overrides of the instance limits are applied on top of the flags.
+	ratelimit.SetDefaultInstanceLimits(t.Cfg.defaultInstanceLimits())
+
+	// The zone is detected before the modules joining the ring are set up.
+	if t.Cfg.ZoneDetection.Enabled && t.Cfg.Ingester.LifecyclerConfig.Zone == "" {
+		zone, err := zonedetect.Detect(context.Background(), t.Cfg.ZoneDetection, util_log.Logger)
+		if err != nil {
+			return nil, errors.Wrap(err, "detect the availability zone")
+		}
+		t.Cfg.Ingester.LifecyclerConfig.Zone = zone
+	}
+
+	if err := t.resolveKVAddresses(); err != nil {
+		return nil, err
+	}
+
+	if err := t.Cfg.Crypto.VerifyBackend(); err != nil {
+		return nil, err
+	}
+	level.Info(util_log.Logger).Log("msg", "crypto backend", "backend", fips.Backend(), "fips_only", t.Cfg.Crypto.Only)
+
+	if err := t.setupRealIP(); err != nil {
+		return nil, err
+	}
+	if err := t.setupIPFilter(); err != nil {
+		return nil, err
+	}
+	t.setupTracePropagation()
+	t.setupRequestLogging()
+	if err := t.setupAuthMiddleware(); err != nil {
+		return nil, err
+	}
+	t.setupGRPCHeaderForwarding()
+	t.RouteTimeouts = routetimeout.NewTimeouts(t.Cfg.RouteTimeout, prometheus.DefaultRegisterer)
+	if t.Cfg.ResponseCompression.Enabled {
+		t.Compressor = httpcompress.NewCompressor(t.Cfg.ResponseCompression, prometheus.DefaultRegisterer)
+	}
+
+	if t.Cfg.Audit.Enabled {
+		var err error
+		if t.AuditLog, err = audit.NewLogger(t.Cfg.Audit, util_log.Logger, prometheus.DefaultRegisterer); err != nil {
+			return nil, errors.Wrap(err, "initialize audit log")
+		}
+	}
+
+	if err := t.setupModuleManager(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// resolveKVAddresses resolves the Consul and etcd addresses prefixed with dns+, dnssrv+ or
+// dnssrvnoa+, before the KV clients are created.
+func (t *BlockstorageIngester) resolveKVAddresses() error {
+	for name, cfg := range map[string]*kv.Config{
+		"ring":            &t.Cfg.Ingester.LifecyclerConfig.RingConfig.KVStore,
+		"leader election": &t.Cfg.LeaderElection.KVStore,
+		"overrides":       &t.Cfg.Overrides.KVStore,
+		"HA tracker":      &t.Cfg.HATracker.KVStore,
+		"tenant tokens":   &t.Cfg.TenantTokens.KVStore,
+	} {
+		if err := dnsaddr.ResolveKV(context.Background(), cfg, util_log.Logger); err != nil {
+			return errors.Wrapf(err, "%s KV store", name)
+		}
+	}
+	return nil
+}
+
+// setupRealIP sets up the resolution of the address of the clients behind the load balancers
+// and proxies. It runs before the source address filters, so that they check the client.
+func (t *BlockstorageIngester) setupRealIP() error {
+	if t.Cfg.RealIP.ProxyProtocolEnabled {
+		var err error
+		if t.ProxyProtocol, err = realip.NewProxyProtocol(t.Cfg.RealIP, prometheus.DefaultRegisterer); err != nil {
+			return err
+		}
+	}
+
+	if len(t.Cfg.RealIP.TrustedForwarderCIDRs) > 0 {
+		trusted, err := ipfilter.ParseCIDRs(t.Cfg.RealIP.TrustedForwarderCIDRs)
+		if err != nil {
+			return err
+		}
+		t.Cfg.Server.HTTPMiddleware = append(t.Cfg.Server.HTTPMiddleware, realip.ForwardedMiddleware(trusted))
+	}
+	return nil
+}
+
+// setupIPFilter sets up the filtering of the HTTP and gRPC requests by source address,
+// before any request is parsed.
+func (t *BlockstorageIngester) setupIPFilter() (err error) {
+	t.ListenerIPFilter, err = ipfilter.NewListenerFilter(t.Cfg.IPFilter, prometheus.DefaultRegisterer)
+	if err != nil {
+		return err
+	}
+
+	if len(t.Cfg.IPFilter.HTTPAllowedCIDRs) > 0 {
+		t.Cfg.Server.HTTPMiddleware = append(t.Cfg.Server.HTTPMiddleware, t.ListenerIPFilter.HTTPMiddleware())
+	}
+	if len(t.Cfg.IPFilter.GRPCAllowedCIDRs) > 0 {
+		t.Cfg.Server.GRPCOptions = append(t.Cfg.Server.GRPCOptions, grpc.InTapHandle(t.ListenerIPFilter.GRPCTapHandle))
+	}
+	return nil
+}
+
+// setupTracePropagation sets up the continuation of the incoming W3C and B3 traces, and the
+// propagation of the trace context in the outgoing requests.
+func (t *BlockstorageIngester) setupTracePropagation() {
+	otel.SetTextMapPropagator(util_tracing.Propagator())
+	t.Cfg.Server.HTTPMiddleware = append(t.Cfg.Server.HTTPMiddleware, util_tracing.HTTPMiddleware())
+}
+
+// setupRequestLogging appends the HTTP and gRPC middlewares logging the requests. They run
+// before the authentication, so that rejected requests are logged too.
+func (t *BlockstorageIngester) setupRequestLogging() {
+	if !t.Cfg.RequestLog.Enabled && t.Cfg.RequestLog.SlowRequestThreshold == 0 {
+		return
+	}
+
+	l := logging.NewRequestLogger(t.Cfg.RequestLog, util_log.Logger)
+	t.Cfg.Server.HTTPMiddleware = append(t.Cfg.Server.HTTPMiddleware, l.HTTPMiddleware())
+	t.Cfg.Server.GRPCMiddleware = append(t.Cfg.Server.GRPCMiddleware, l.UnaryServerInterceptor)
+}
+
+// setupAuthMiddleware appends the gRPC middlewares resolving the tenant of each request,
+// and sets up the built-in HTTP authenticators. HTTP routes opt in to authentication and
+// tenant resolution when registered via registerRoute().
+func (t *BlockstorageIngester) setupAuthMiddleware() error {
+	noGRPCAuthOn := []string{
+		"/grpc.health.v1.Health/Check",
+		// HTTP requests sent over gRPC are authenticated by the HTTP route itself.
+		"/httpgrpc.HTTP/Handle",
+	}
+
+	t.Cfg.Server.GRPCMiddleware = append(t.Cfg.Server.GRPCMiddleware, tenant.UnaryServerInterceptor(t.Cfg.AuthEnabled, t.Cfg.NoAuthTenant, noGRPCAuthOn...))
+	t.Cfg.Server.GRPCStreamMiddleware = append(t.Cfg.Server.GRPCStreamMiddleware, tenant.StreamServerInterceptor(t.Cfg.AuthEnabled, t.Cfg.NoAuthTenant, noGRPCAuthOn...))
+
+	var authenticators []auth.Authenticator
+	if t.Cfg.StaticAuth.Enabled() {
+		var err error
+		t.StaticAuth, err = auth.NewStaticAuthenticator(t.Cfg.StaticAuth, util_log.Logger, prometheus.DefaultRegisterer)
+		if err != nil {
+			return errors.Wrap(err, "initialize static credentials authentication")
+		}
+		authenticators = append(authenticators, t.StaticAuth)
+	}
+	if t.Cfg.JWTAuth.Enabled() {
+		t.JWTAuth = auth.NewJWTAuthenticator(t.Cfg.JWTAuth, util_log.Logger, prometheus.DefaultRegisterer)
+		authenticators = append(authenticators, t.JWTAuth)
+	}
+	if t.Cfg.TenantTokens.Enabled {
+		var err error
+		t.TokenAuth, err = auth.NewTokenAuthenticator(t.Cfg.TenantTokens, util_log.Logger, prometheus.DefaultRegisterer)
+		if err != nil {
+			return errors.Wrap(err, "initialize tenant tokens authentication")
+		}
+		authenticators = append(authenticators, t.TokenAuth)
+	}
+
+	if len(authenticators) > 0 {
+		t.HTTPAuth = auth.NewMiddleware(authenticators, prometheus.DefaultRegisterer)
+	}
+	return nil
+}
+
+// httpAuthMiddleware returns the middleware authenticating HTTP requests with the enabled
+// built-in authenticators, and then resolving their tenant.
+func (t *BlockstorageIngester) httpAuthMiddleware() middleware.Interface {
+	var mws []middleware.Interface
+	if t.HTTPAuth != nil {
+		mws = append(mws, t.HTTPAuth)
+	}
+
+	mws = append(mws, tenant.HTTPMiddleware(t.Cfg.AuthEnabled, t.Cfg.NoAuthTenant))
+
+	// The tenant allowlists are only known once the overrides are initialized.
+	if t.TenantIPFilter != nil {
+		mws = append(mws, t.TenantIPFilter.HTTPMiddleware())
+	}
+	return middleware.Merge(mws...)
+}
+
+// registerRoute registers an HTTP route on the server. If auth is true, the request is
+// authenticated and its tenant resolved and injected into the request context. The
+// responses are compressed if enabled.
+func (t *BlockstorageIngester) registerRoute(path string, handler http.Handler, auth bool, methods ...string) {
+	if auth {
+		handler = t.httpAuthMiddleware().Wrap(handler)
+	}
+	if t.Compressor != nil {
+		handler = t.Compressor.Wrap(handler)
+	}
+
+	route := t.Server.HTTP.Path(path)
+	if len(methods) > 0 {
+		route = route.Methods(methods...)
+	}
+	route.Handler(handler)
+}
+
+// audited returns the handler of an administrative operation, served with the admin route
+// timeout and logging an audit record for each request changing the state if the audit log
+// is enabled. The audit records include the requests which timed out.
+func (t *BlockstorageIngester) audited(action string, handler http.Handler) http.Handler {
+	handler = t.RouteTimeouts.Wrap(routetimeout.ClassAdmin, handler)
+	if t.AuditLog == nil {
+		return handler
+	}
+	return t.AuditLog.Wrap(action, handler)
+}
+
+// query returns the handler of a query route, served with the query route timeout. The names
+// are translated for the clients of the tenants with UTF-8 names.
+func (t *BlockstorageIngester) query(handler http.HandlerFunc) http.Handler {
+	return t.RouteTimeouts.Wrap(routetimeout.ClassQuery, utf8names.Middleware(t.TenantOverrides.UTF8NamesEnabled, handler))
+}
+
 // setupGRPCHeaderForwarding appends a gRPC middleware used to enable the propagation of
 // HTTP Headers through child gRPC calls
 func (t *BlockstorageIngester) setupGRPCHeaderForwarding() {
@@ -345,7 +1077,7 @@ func (t *BlockstorageIngester) Run() error {
 		return err
 	}
 
-	//t.API.RegisterServiceMapHandler(http.HandlerFunc(t.serviceHandler))
+	t.registerRoute("/services", http.HandlerFunc(t.servicesHandler), false, "GET")
 
 	// get all services, create service manager and tell it to start
 	servs := []services.Service(nil)
@@ -420,39 +1152,42 @@ func (t *BlockstorageIngester) Run() error {
 	return err
 }
 
-func (t *BlockstorageIngester) readyHandler(sm *services.Manager) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if !sm.IsHealthy() {
-			msg := bytes.Buffer{}
-			msg.WriteString("Some services are not Running:\n")
-
-			byState := sm.ServicesByState()
-			for st, ls := range byState {
-				msg.WriteString(fmt.Sprintf("%v: %d\n", st, len(ls)))
-			}
-
-			http.Error(w, msg.String(), http.StatusServiceUnavailable)
-			return
+// readyHandler composes the readiness of the instance from the running modules and the
+// components they started, the checks not required being only reported.
+func (t *BlockstorageIngester) readyHandler(sm *services.Manager) http.Handler {
+	h := readiness.NewHandler(t.Cfg.Readiness)
+	h.Register(readiness.CheckServices, func(context.Context) error {
+		if sm.IsHealthy() {
+			return nil
+		}
+		msg := bytes.Buffer{}
+		msg.WriteString("some services are not Running:")
+		for st, ls := range sm.ServicesByState() {
+			msg.WriteString(fmt.Sprintf(" %v: %d", st, len(ls)))
 		}
+		return errors.New(msg.String())
+	})
 
-		//// Ingester has a special check that makes sure that it was able to register into the ring,
-		//// and that all other ring entries are OK too.
-		//if t.Ingester != nil {
-		//	if err := t.Ingester.CheckReady(r.Context()); err != nil {
-		//		http.Error(w, "Ingester not ready: "+err.Error(), http.StatusServiceUnavailable)
-		//		return
-		//	}
-		//}
-
-		// Query Frontend has a special check that makes sure that a querier is attached before it signals
-		// itself as ready
-		//if t.Frontend != nil {
-		//	if err := t.Frontend.CheckReady(r.Context()); err != nil {
-		//		http.Error(w, "Query Frontend not ready: "+err.Error(), http.StatusServiceUnavailable)
-		//		return
-		//	}
-		//}
-
-		util.WriteTextResponse(w, "ready")
+	// The ingester must have joined the ring, and the other ring entries be healthy.
+	if t.IngesterLifecycler != nil {
+		h.Register(readiness.CheckRing, func(ctx context.Context) error {
+			if state := t.IngesterLifecycler.GetState(); state != ring.ACTIVE {
+				return fmt.Errorf("ingester is %s in the ring", state)
+			}
+			return t.IngesterLifecycler.CheckReady(ctx)
+		})
+	}
+	// The ingester is running once the WAL of all the tenants is replayed.
+	if t.Ingester != nil {
+		h.Register(readiness.CheckWALReplay, func(context.Context) error {
+			if state := t.Ingester.State(); state != services.Running {
+				return fmt.Errorf("ingester is %s, replaying the WAL", state)
+			}
+			return nil
+		})
+	}
+	if t.StorageProbe != nil {
+		h.Register(readiness.CheckStorage, t.StorageProbe.Check)
 	}
+	return h
 }
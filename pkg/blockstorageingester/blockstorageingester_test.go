@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestConfig_RegisterFlags_ShouldChangeTheServerDefaultValues(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.PanicOnError)
+	(&Config{}).RegisterFlags(fs)
+
+	for name, expected := range map[string]string{
+		"server.grpc.keepalive.min-time-between-pings":      "10s",
+		"server.grpc.keepalive.ping-without-stream-allowed": "true",
+		"server.grpc-max-recv-msg-size-bytes":               "104857600",
+		"server.grpc-max-send-msg-size-bytes":               "104857600",
+		"server.grpc-max-concurrent-streams":                "1000",
+		"server.grpc.keepalive.time":                        "1m0s",
+		// The push requests received over HTTP are limited to the gRPC max message size.
+		"push.max-request-body-size-bytes":      "0",
+		"push.max-decompressed-body-size-bytes": "0",
+	} {
+		f := fs.Lookup(name)
+		require.NotNil(t, f, name)
+		assert.Equal(t, expected, f.DefValue, name)
+	}
+}
+
+func TestConfig_Validate_ShouldRequireMemberlistTLSForEveryRing(t *testing.T) {
+	for name, setStore := range map[string]func(*Config){
+		"ingester":      func(cfg *Config) { cfg.Ingester.LifecyclerConfig.RingConfig.KVStore.Store = "memberlist" },
+		"store-gateway": func(cfg *Config) { cfg.StoreGateway.ShardingRing.KVStore.Store = "memberlist" },
+		"compactor": func(cfg *Config) {
+			cfg.Compactor.ShardingRing.KVStore.Store = "multi"
+			cfg.Compactor.ShardingRing.KVStore.Multi.Secondary = "memberlist"
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			cfg.MemberlistRequireTLS = true
+			require.NoError(t, cfg.Validate(log.NewNopLogger()))
+
+			setStore(&cfg)
+			assert.ErrorIs(t, cfg.Validate(log.NewNopLogger()), errMemberlistTLS)
+		})
+	}
+}
+
+func TestConfig_Validate_ShouldRejectTheHandoverWithCredentialsAuth(t *testing.T) {
+	for name, setAuth := range map[string]func(*Config){
+		"static":        func(cfg *Config) { cfg.StaticAuth.APIKeysFile = "keys.yaml" },
+		"jwt":           func(cfg *Config) { cfg.JWTAuth.IssuerURL = "https://issuer.example.com" },
+		"tenant tokens": func(cfg *Config) { cfg.TenantTokens.Enabled = true },
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			cfg.IngesterHandover.Enabled = true
+			require.NoError(t, cfg.Validate(log.NewNopLogger()))
+
+			setAuth(&cfg)
+			assert.ErrorIs(t, cfg.Validate(log.NewNopLogger()), errHandoverAuth)
+		})
+	}
+}
+
+func TestConfig_Validate_ShouldRequireTheAdminListenerForTenantTokens(t *testing.T) {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.TenantTokens.Enabled = true
+	assert.ErrorIs(t, cfg.Validate(log.NewNopLogger()), errTenantTokens)
+
+	cfg.Admin.ListenAddress, cfg.Admin.Username, cfg.Admin.Password.Value = "127.0.0.1:0", "admin", "secret"
+	assert.NoError(t, cfg.Validate(log.NewNopLogger()))
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/cortexproject/cortex/pkg/ingester"
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/ingester/handover"
+)
+
+// ingesterService runs the ingester along with the lifecycler registering the instance in
+// the ingesters ring. The lifecycler of the Cortex ingester can't be driven, so it runs
+// on a ring private to the process, while this one is driven by the read-only mode and
+// the prepare shutdown:
+//   - on startup, the instance is registered as PENDING and joins the ring once the
+//     ingester has opened the TSDBs. If the handover is enabled and there is no local
+//     TSDB, the TSDBs of a leaving ingester are waited for first.
+//   - on shutdown, the instance leaves the ring, handing its TSDBs over or flushing the
+//     blocks if requested while the TSDBs are still open, and then the ingester is stopped.
+type ingesterService struct {
+	services.Service
+
+	ingester   *ingester.Ingester
+	lifecycler *ring.Lifecycler
+	logger     log.Logger
+
+	// receiver is nil if the handover is disabled.
+	receiver        *handover.Receiver
+	handoverTimeout time.Duration
+	tsdbDir         string
+
+	// privateRing closes the KV store of the ring the Cortex ingester lifecycler runs on.
+	privateRing io.Closer
+
+	watcher *services.FailureWatcher
+}
+
+func newIngesterService(i *ingester.Ingester, lifecycler *ring.Lifecycler, receiver *handover.Receiver, handoverTimeout time.Duration, tsdbDir string, privateRing io.Closer, logger log.Logger) *ingesterService {
+	s := &ingesterService{
+		ingester:        i,
+		lifecycler:      lifecycler,
+		logger:          logger,
+		receiver:        receiver,
+		handoverTimeout: handoverTimeout,
+		tsdbDir:         tsdbDir,
+		privateRing:     privateRing,
+		watcher:         services.NewFailureWatcher(),
+	}
+	s.watcher.WatchService(i)
+	s.watcher.WatchService(lifecycler)
+
+	s.Service = services.NewBasicService(s.starting, s.running, s.stopping)
+	return s
+}
+
+func (s *ingesterService) starting(ctx context.Context) error {
+	if err := services.StartAndAwaitRunning(ctx, s.lifecycler); err != nil {
+		return errors.Wrap(err, "start the ingester lifecycler")
+	}
+
+	// The instance is PENDING in the ring, so it can be found by a leaving ingester.
+	s.waitForHandover(ctx)
+
+	if err := services.StartAndAwaitRunning(ctx, s.ingester); err != nil {
+		// The instance leaves the ring, since it never joined it.
+		_ = services.StopAndAwaitTerminated(context.Background(), s.lifecycler)
+		return errors.Wrap(err, "start the ingester")
+	}
+
+	s.lifecycler.Join()
+	return nil
+}
+
+// waitForHandover waits for the TSDBs of a leaving ingester, unless there are local ones
+// already. The ingester starts from scratch if no handover completes in time.
+func (s *ingesterService) waitForHandover(ctx context.Context) {
+	if s.receiver == nil {
+		return
+	}
+
+	if found, err := handover.HasLocalUsers(s.tsdbDir); err != nil || found {
+		if err != nil {
+			level.Warn(s.logger).Log("msg", "skipped waiting for a handover because the TSDB directory can't be read", "err", err)
+		}
+		return
+	}
+
+	level.Info(s.logger).Log("msg", "waiting for a handover from a leaving ingester", "timeout", s.handoverTimeout)
+	if !s.receiver.WaitForHandover(ctx, s.handoverTimeout) {
+		level.Info(s.logger).Log("msg", "no handover completed, starting without the TSDBs of a leaving ingester")
+	}
+}
+
+func (s *ingesterService) running(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-s.watcher.Chan():
+		return errors.Wrap(err, "ingester subservice failed")
+	}
+}
+
+func (s *ingesterService) stopping(_ error) error {
+	// The instance leaves the ring first, so that it doesn't receive writes anymore, handing
+	// the TSDBs over or flushing the blocks while the ingester is still running.
+	if err := services.StopAndAwaitTerminated(context.Background(), s.lifecycler); err != nil {
+		level.Warn(s.logger).Log("msg", "failed to stop the ingester lifecycler", "err", err)
+	}
+	if err := services.StopAndAwaitTerminated(context.Background(), s.ingester); err != nil {
+		level.Warn(s.logger).Log("msg", "failed to stop the ingester", "err", err)
+	}
+	return s.privateRing.Close()
+}
+
+// flushTransferer is the ring.FlushTransferer run by the lifecycler when the instance
+// leaves the ring. The TSDBs are handed over to a joining ingester if the handover is
+// enabled, falling back to flushing the blocks if requested.
+type flushTransferer struct {
+	ingester        *ingester.Ingester
+	sender          *handover.Sender // nil if the handover is disabled
+	flushOnShutdown bool
+	logger          log.Logger
+}
+
+// Flush implements ring.FlushTransferer.
+func (f flushTransferer) Flush() {
+	if f.sender != nil {
+		// The failure is logged by the sender.
+		if err := f.sender.TransferOut(context.Background()); err == nil || !f.flushOnShutdown {
+			return
+		}
+		level.Info(f.logger).Log("msg", "flushing the blocks since the handover failed")
+	}
+
+	f.ingester.Flush()
+}
+
+// TransferOut implements ring.FlushTransferer.
+func (f flushTransferer) TransferOut(ctx context.Context) error {
+	if f.sender == nil {
+		return ring.ErrTransferDisabled
+	}
+	return f.sender.TransferOut(ctx)
+}
+
+// errReadOnlyRejoin is returned when leaving the read-only mode, since the lifecycler never
+// lets a LEAVING instance become ACTIVE again.
+var errReadOnlyRejoin = errors.New("the instance can't rejoin the ingesters ring once read-only, the ingester must be restarted")
+
+// readOnlyStateChanger is the readonly.StateChanger of the lifecycler. The lifecycler only
+// lets an ACTIVE instance leave the ring, so the read-only mode is entered once the instance
+// has joined it.
+type readOnlyStateChanger struct {
+	lifecycler *ring.Lifecycler
+}
+
+// ChangeState implements readonly.StateChanger.
+func (c readOnlyStateChanger) ChangeState(ctx context.Context, state ring.InstanceState) error {
+	for {
+		switch current := c.lifecycler.GetState(); {
+		case current == state:
+			return nil
+		case current == ring.LEAVING:
+			return errReadOnlyRejoin
+		case current == ring.PENDING || current == ring.JOINING:
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(100 * time.Millisecond):
+			}
+		default:
+			return c.lifecycler.ChangeState(ctx, state)
+		}
+	}
+}
@@ -0,0 +1,1181 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/discovery/dns"
+	"github.com/weaveworks/common/server"
+
+	"github.com/cortexproject/cortex/pkg/compactor"
+	"github.com/cortexproject/cortex/pkg/cortex"
+	"github.com/cortexproject/cortex/pkg/ingester"
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/cortexproject/cortex/pkg/ring/kv/codec"
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+	"github.com/cortexproject/cortex/pkg/ring/kv/memberlist"
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	"github.com/cortexproject/cortex/pkg/storegateway"
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+	util_log "github.com/cortexproject/cortex/pkg/util/log"
+	"github.com/cortexproject/cortex/pkg/util/modules"
+	"github.com/cortexproject/cortex/pkg/util/runtimeconfig"
+	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+
+	"objectstorage/pkg/admin"
+	"objectstorage/pkg/audit"
+	"objectstorage/pkg/auth"
+	"objectstorage/pkg/autoscaling"
+	"objectstorage/pkg/bucketindexer"
+	"objectstorage/pkg/cardinality"
+	"objectstorage/pkg/costattribution"
+	"objectstorage/pkg/deadletter"
+	"objectstorage/pkg/events"
+	"objectstorage/pkg/faultinjection"
+	"objectstorage/pkg/forwarder"
+	"objectstorage/pkg/hatracker"
+	"objectstorage/pkg/ingest"
+	"objectstorage/pkg/ingester/consistency"
+	"objectstorage/pkg/ingester/handover"
+	"objectstorage/pkg/ingester/head"
+	ingester_metrics "objectstorage/pkg/ingester/metrics"
+	"objectstorage/pkg/ingester/prepareshutdown"
+	"objectstorage/pkg/ingester/readonly"
+	"objectstorage/pkg/ingester/shipper"
+	"objectstorage/pkg/ipfilter"
+	"objectstorage/pkg/limits"
+	"objectstorage/pkg/metricfilter"
+	"objectstorage/pkg/overrides"
+	"objectstorage/pkg/profiling"
+	"objectstorage/pkg/push"
+	local_querier "objectstorage/pkg/querier"
+	"objectstorage/pkg/ratelimit"
+	"objectstorage/pkg/readiness"
+	"objectstorage/pkg/relabeling"
+	"objectstorage/pkg/replication"
+	"objectstorage/pkg/routetimeout"
+	"objectstorage/pkg/scraper"
+	"objectstorage/pkg/seriesdeletion"
+	"objectstorage/pkg/seriesvalidation"
+	"objectstorage/pkg/shadow"
+	"objectstorage/pkg/singleport"
+	local_bucket "objectstorage/pkg/storage/bucket"
+	bucket_tsdb "objectstorage/pkg/storage/tsdb"
+	"objectstorage/pkg/tee"
+	"objectstorage/pkg/tenant"
+	"objectstorage/pkg/tenantdeletion"
+	"objectstorage/pkg/tiering"
+	"objectstorage/pkg/unixsocket"
+	"objectstorage/pkg/usagestats"
+	"objectstorage/pkg/util/leaderelection"
+	"objectstorage/pkg/util/logging"
+	"objectstorage/pkg/util/redact"
+	"objectstorage/pkg/util/servertls"
+	"objectstorage/pkg/webhook"
+)
+
+// The various modules that make up the block storage ingester.
+const (
+	Server           string = "server"
+	Ingester         string = "ingester"
+	IngesterHandover string = "ingester-handover"
+	IngesterReadOnly string = "ingester-read-only"
+	PrepareShutdown  string = "ingester-prepare-shutdown"
+	IngestWriter     string = "ingest-writer"
+	PartitionReader  string = "partition-reader"
+	LeaderElectionKV string = "leader-election-kv"
+	MemberlistKV     string = "memberlist-kv"
+	Push             string = "push"
+	RuntimeConfig    string = "runtime-config"
+	Overrides        string = "overrides"
+	IngestionLimits  string = "ingestion-limits"
+	DeadLetter       string = "dead-letter"
+	IngestionMetrics string = "ingestion-metrics"
+	Ring             string = "ring"
+	BucketClient     string = "bucket-client"
+	TenantDeletion   string = "tenant-deletion"
+	HATracker        string = "ha-tracker"
+	CostAttribution  string = "cost-attribution"
+	Cardinality      string = "cardinality"
+	ServerTLS        string = "server-tls"
+	UnixSockets      string = "unix-sockets"
+	ProxyProtocol    string = "proxy-protocol"
+	StaticAuth       string = "static-auth"
+	JWTAuth          string = "jwt-auth"
+	AuditLog         string = "audit-log"
+	TenantTokens     string = "tenant-tokens"
+	AdminServer      string = "admin-server"
+	UsageStats       string = "usage-stats"
+	Profiling        string = "profiling"
+	Querier          string = "querier"
+	HeadAPI          string = "head-api"
+	StoreGateway     string = "store-gateway"
+	Compactor        string = "compactor"
+	Shipper          string = "shipper"
+	BucketIndexer    string = "bucket-indexer"
+	Replication      string = "replication"
+	Tiering          string = "tiering"
+	SeriesDeletion   string = "series-deletion"
+	FaultInjection   string = "fault-injection"
+	ConsistencyCheck string = "consistency-check"
+	StorageProbe     string = "storage-probe"
+	Autoscaling      string = "autoscaling"
+	Webhook          string = "webhook"
+	Events           string = "events"
+	Scraper          string = "scraper"
+	Forwarder        string = "forwarder"
+	Tee              string = "tee"
+	Shadow           string = "shadow"
+	All              string = "all"
+)
+
+func (t *BlockstorageIngester) initServer() (services.Service, error) {
+	// The profiling endpoints are only served by the admin listener when enabled, so the
+	// instrumentation routes are registered here without them.
+	if t.Cfg.Admin.Enabled() {
+		t.Cfg.Server.RegisterInstrumentation = false
+	}
+
+	serv, err := server.New(t.Cfg.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Server = serv
+	// The gRPC requests on the HTTP listener don't go through the tap handles of the gRPC
+	// server, so they're filtered by source address on their own.
+	if t.Cfg.SinglePort.Enabled {
+		var grpcHandler http.Handler = t.Server.GRPC
+		if len(t.Cfg.IPFilter.GRPCAllowedCIDRs) > 0 {
+			grpcHandler = t.ListenerIPFilter.GRPCHTTPMiddleware().Wrap(grpcHandler)
+		}
+		t.Server.HTTPServer.Handler = singleport.Handler(t.Server.HTTPServer.Handler, grpcHandler)
+	}
+	if t.Cfg.Admin.Enabled() {
+		t.Server.HTTP.Handle("/metrics", promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	}
+
+	// The config is served with the secrets masked.
+	t.registerRoute("/config", redact.ConfigHandler(&t.Cfg), false, "GET")
+
+	// The log level can be changed at runtime if the logger supports it.
+	if sw := logging.Level(); sw != nil {
+		t.registerRoute("/log_level", t.audited("log_level", sw), false, "GET", "POST", "DELETE")
+	}
+
+	servicesToWaitFor := func() []services.Service {
+		svs := []services.Service(nil)
+		for m, s := range t.ServiceMap {
+			// Server should not wait for itself.
+			if m != Server {
+				svs = append(svs, s)
+			}
+		}
+		return svs
+	}
+
+	return cortex.NewServerService(t.Server, servicesToWaitFor), nil
+}
+
+func (t *BlockstorageIngester) initUnixSockets() (services.Service, error) {
+	if !t.Cfg.UnixSocket.Enabled() {
+		return nil, nil
+	}
+	return unixsocket.NewListeners(t.Cfg.UnixSocket, t.Server.HTTPServer, t.Server.GRPC, util_log.Logger), nil
+}
+
+func (t *BlockstorageIngester) initProxyProtocol() (services.Service, error) {
+	if t.ProxyProtocol == nil {
+		return nil, nil
+	}
+	return t.ProxyProtocol.NewListeners(t.Cfg.Server, t.Server.HTTPServer, t.Server.GRPC, util_log.Logger), nil
+}
+
+func (t *BlockstorageIngester) initAdminServer() (services.Service, error) {
+	if !t.Cfg.Admin.Enabled() {
+		return nil, nil
+	}
+
+	t.Admin = admin.NewServer(t.Cfg.Admin, util_log.Logger, prometheus.DefaultRegisterer)
+	return t.Admin, nil
+}
+
+func (t *BlockstorageIngester) initFaultInjection() (services.Service, error) {
+	if !t.Cfg.FaultInjection.Enabled {
+		return nil, nil
+	}
+
+	t.FaultInjector = faultinjection.NewInjector(util_log.Logger, prometheus.DefaultRegisterer)
+	t.Admin.Handle("/fault-injection", t.audited("fault_injection", t.FaultInjector))
+	level.Warn(util_log.Logger).Log("msg", "fault injection enabled")
+	return nil, nil
+}
+
+// withFaults returns the KV client with the configured faults injected, if enabled.
+func (t *BlockstorageIngester) withFaults(client kv.Client) kv.Client {
+	if t.FaultInjector == nil {
+		return client
+	}
+	return t.FaultInjector.KV(client)
+}
+
+func (t *BlockstorageIngester) initServerTLS() (services.Service, error) {
+	t.TLSWatcher = servertls.NewWatcher(t.Cfg.ServerTLS, t.Cfg.Server, util_log.Logger, prometheus.DefaultRegisterer)
+	return t.TLSWatcher, nil
+}
+
+func (t *BlockstorageIngester) initStaticAuth() (services.Service, error) {
+	// The authenticator is set up along with the other auth middlewares, this module only
+	// runs the periodic reload of the credentials.
+	if t.StaticAuth == nil {
+		return nil, nil
+	}
+	return t.StaticAuth, nil
+}
+
+func (t *BlockstorageIngester) initJWTAuth() (services.Service, error) {
+	// The authenticator is set up along with the other auth middlewares, this module only
+	// runs the periodic refresh of the issuer signing keys.
+	if t.JWTAuth == nil {
+		return nil, nil
+	}
+	return t.JWTAuth, nil
+}
+
+func (t *BlockstorageIngester) initTenantTokens() (services.Service, error) {
+	// The authenticator is set up along with the other auth middlewares, this module
+	// serves the tokens management endpoints and watches the tokens in the KV store.
+	if t.TokenAuth == nil {
+		return nil, nil
+	}
+
+	// The tokens are managed by the operators on the admin listener, so that a token never
+	// grants the management of the tokens of its tenant, and the first one can be created.
+	// The admin credentials are checked first, so the tenant header is trusted.
+	tenantMiddleware := tenant.HTTPMiddleware(true, "")
+	t.Admin.Handle(auth.TokensPath, tenantMiddleware.Wrap(t.audited("tenant_token", t.TokenAuth)))
+	t.Admin.Handle(auth.TokensRotatePath, tenantMiddleware.Wrap(t.audited("tenant_token_rotate", http.HandlerFunc(t.TokenAuth.RotateHandler))))
+	return t.TokenAuth, nil
+}
+
+func (t *BlockstorageIngester) initMemberlistKV() (services.Service, error) {
+	reg := prometheus.DefaultRegisterer
+	t.Cfg.MemberlistKV.MetricsRegisterer = reg
+	t.Cfg.MemberlistKV.Codecs = []codec.Codec{
+		ring.GetCodec(),
+	}
+
+	dnsProviderReg := prometheus.WrapRegistererWithPrefix(
+		"cortex_",
+		prometheus.WrapRegistererWith(
+			prometheus.Labels{"name": "memberlist"},
+			reg,
+		),
+	)
+	dnsProvider := dns.NewProvider(util_log.Logger, dnsProviderReg, dns.GolangResolverType)
+
+	t.MemberlistKV = memberlist.NewKVInitService(&t.Cfg.MemberlistKV, util_log.Logger, dnsProvider, reg)
+
+	// Update the config so that the ingesters, store-gateways and compactors rings gossip via
+	// memberlist, if configured to do so.
+	t.Cfg.Ingester.LifecyclerConfig.RingConfig.KVStore.MemberlistKV = t.MemberlistKV.GetMemberlistKV
+	t.Cfg.StoreGateway.ShardingRing.KVStore.MemberlistKV = t.MemberlistKV.GetMemberlistKV
+	t.Cfg.Compactor.ShardingRing.KVStore.MemberlistKV = t.MemberlistKV.GetMemberlistKV
+
+	// The admin page shows the cluster members and the content of the KV store, as seen
+	// by this instance.
+	t.registerRoute("/memberlist", t.MemberlistKV, false, "GET")
+
+	return t.MemberlistKV, nil
+}
+
+func (t *BlockstorageIngester) initIngester() (serv services.Service, err error) {
+	// In agent mode, the series are only written to the forwarder WAL.
+	if t.Cfg.Forwarder.Enabled {
+		return nil, nil
+	}
+
+	t.Cfg.Ingester.LifecyclerConfig.ListenPort = t.Cfg.Server.GRPCListenPort
+	t.Cfg.Ingester.BlocksStorageConfig = t.Cfg.BlocksStorage
+
+	// The lifecycler of the Cortex ingester runs on a ring private to the process, the
+	// instance being registered in the ingesters ring by the lifecycler below instead. The
+	// blocks are flushed on shutdown by the latter only, if requested.
+	privateRing, closer := consul.NewInMemoryClient(ring.GetCodec(), util_log.Logger, nil)
+	ingesterCfg := t.Cfg.Ingester
+	ingesterCfg.LifecyclerConfig.RingConfig.KVStore.Mock = privateRing
+	ingesterCfg.LifecyclerConfig.TokensFilePath = ""
+	ingesterCfg.LifecyclerConfig.FinalSleep = 0
+	ingesterCfg.BlocksStorageConfig.TSDB.FlushBlocksOnShutdown = false
+
+	t.Ingester, err = ingester.New(ingesterCfg, t.Overrides, prometheus.DefaultRegisterer, util_log.Logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// The TSDBs are handed over when the instance leaves the ring, if enabled, which the
+	// lifecycler only does when flushing on shutdown.
+	ft := flushTransferer{
+		ingester:        t.Ingester,
+		sender:          t.HandoverSender,
+		flushOnShutdown: t.Cfg.BlocksStorage.TSDB.FlushBlocksOnShutdown,
+		logger:          util_log.Logger,
+	}
+
+	// The ring name differs from the one of the Cortex ingester lifecycler, for their metrics
+	// not to clash.
+	t.IngesterLifecycler, err = ring.NewLifecycler(t.Cfg.Ingester.LifecyclerConfig, ft, "ingesters", ingester.RingKey, false, ft.flushOnShutdown || ft.sender != nil, util_log.Logger, prometheus.WrapRegistererWithPrefix("cortex_", prometheus.DefaultRegisterer))
+	if err != nil {
+		return nil, err
+	}
+
+	return newIngesterService(t.Ingester, t.IngesterLifecycler, t.HandoverReceiver, t.Cfg.IngesterHandover.Timeout, t.Cfg.BlocksStorage.TSDB.Dir, closer, util_log.Logger), nil
+}
+
+func (t *BlockstorageIngester) initIngesterHandover() (services.Service, error) {
+	if !t.Cfg.IngesterHandover.Enabled {
+		return nil, nil
+	}
+
+	t.HandoverReceiver = handover.NewReceiver(t.Cfg.BlocksStorage.TSDB.Dir, util_log.Logger, prometheus.DefaultRegisterer)
+	t.registerRoute(handover.FilePath, t.HandoverReceiver, true, "POST")
+	t.registerRoute(handover.CompletePath, t.HandoverReceiver, true, "POST")
+
+	ringCfg := t.Cfg.Ingester.LifecyclerConfig
+	ringKV, err := kv.NewClient(ringCfg.RingConfig.KVStore, ring.GetCodec(), kv.RegistererWithKVName(prometheus.DefaultRegisterer, "ingester-handover"), util_log.Logger)
+	if err != nil {
+		return nil, err
+	}
+
+	t.HandoverSender, err = handover.NewSender(t.Cfg.IngesterHandover, t.Cfg.BlocksStorage.TSDB.Dir, ringCfg.ID, t.withFaults(ringKV), ingester.RingKey, util_log.Logger, prometheus.DefaultRegisterer)
+	if err != nil {
+		return nil, err
+	}
+	level.Info(util_log.Logger).Log("msg", "ingester handover enabled", "tsdb_dir", t.Cfg.BlocksStorage.TSDB.Dir)
+	return t.HandoverSender, nil
+}
+
+func (t *BlockstorageIngester) initIngesterReadOnly() (services.Service, error) {
+	var lifecycler readonly.StateChanger
+	if t.IngesterLifecycler != nil {
+		lifecycler = readOnlyStateChanger{lifecycler: t.IngesterLifecycler}
+	}
+
+	// The state changes are published to the events bus, if enabled.
+	var publisher readonly.Publisher
+	if t.Events != nil {
+		publisher = t.Events
+	}
+
+	t.ReadOnly = readonly.NewManager(t.Cfg.IngesterReadOnly, t.Cfg.BlocksStorage.TSDB.Dir, t.Cfg.BlocksStorage.TSDB.Retention, lifecycler, publisher, util_log.Logger, prometheus.DefaultRegisterer)
+	t.registerRoute("/ingester/read-only", t.audited("ingester_read_only", t.ReadOnly), true, "GET", "POST", "DELETE")
+	return t.ReadOnly, nil
+}
+
+func (t *BlockstorageIngester) initPrepareShutdown() (services.Service, error) {
+	var lifecycler prepareshutdown.Lifecycler
+	if t.IngesterLifecycler != nil {
+		lifecycler = t.IngesterLifecycler
+	}
+
+	t.PrepareShutdown = prepareshutdown.NewManager(t.Cfg.BlocksStorage.TSDB.Dir, lifecycler, t.ReadOnly, util_log.Logger, prometheus.DefaultRegisterer)
+	t.registerRoute(prepareshutdown.ShutdownPath, t.audited("ingester_prepare_shutdown", t.PrepareShutdown.ShutdownHandler()), false, "GET", "POST", "DELETE")
+	t.registerRoute(prepareshutdown.DownscalePath, t.audited("ingester_prepare_downscale", t.PrepareShutdown.DownscaleHandler()), false, "GET", "POST", "DELETE")
+	return t.PrepareShutdown, nil
+}
+
+func (t *BlockstorageIngester) initIngestWriter() (services.Service, error) {
+	if !t.Cfg.IngestStorage.Enabled {
+		return nil, nil
+	}
+
+	t.IngestWriter = ingest.NewWriter(t.Cfg.IngestStorage.Kafka, util_log.Logger, prometheus.DefaultRegisterer)
+	return t.IngestWriter, nil
+}
+
+func (t *BlockstorageIngester) initPartitionReader() (services.Service, error) {
+	if !t.Cfg.IngestStorage.Enabled {
+		return nil, nil
+	}
+	if t.Ingester == nil {
+		return nil, errors.New("the ingest storage partition reader requires the ingester to be running")
+	}
+
+	partitionKV, err := kv.NewClient(t.Cfg.Ingester.LifecyclerConfig.RingConfig.KVStore, ring.GetCodec(), kv.RegistererWithKVName(prometheus.DefaultRegisterer, "ingest-partitions"), util_log.Logger)
+	if err != nil {
+		return nil, err
+	}
+
+	partitionID, err := ingest.AssignPartition(context.Background(), t.withFaults(partitionKV), t.Cfg.Ingester.LifecyclerConfig.ID, t.Cfg.IngestStorage.Kafka.PartitionsCount)
+	if err != nil {
+		return nil, err
+	}
+	level.Info(util_log.Logger).Log("msg", "assigned the ingest storage partition", "partition", partitionID)
+
+	offsetsDir := t.Cfg.IngestStorage.Kafka.OffsetsDirectory
+	if offsetsDir == "" {
+		offsetsDir = t.Cfg.BlocksStorage.TSDB.Dir
+	}
+
+	t.PartitionReader = ingest.NewPartitionReader(t.Cfg.IngestStorage.Kafka, partitionID, offsetsDir, t.Ingester.Push, util_log.Logger, prometheus.DefaultRegisterer)
+	return t.PartitionReader, nil
+}
+
+func (t *BlockstorageIngester) initLeaderElectionKV() (services.Service, error) {
+	client, err := leaderelection.NewKVClient(t.Cfg.LeaderElection, util_log.Logger, prometheus.DefaultRegisterer)
+	if err != nil {
+		return nil, err
+	}
+
+	t.LeaderElectionKV = t.withFaults(client)
+	return nil, nil
+}
+
+func (t *BlockstorageIngester) initRuntimeConfig() (services.Service, error) {
+	// The default settings override the default limits they share, before the tenant limits
+	// are unmarshalled on top of them.
+	applySettings(&t.Cfg.LimitsConfig, &t.Cfg.Overrides.Defaults)
+
+	// make sure to set default limits before we start loading configuration into memory
+	validation.SetDefaultLimitsForYAMLUnmarshalling(t.Cfg.LimitsConfig)
+	overrides.SetDefaultSettingsForYAMLUnmarshalling(t.Cfg.Overrides.Defaults)
+
+	if t.Cfg.Overrides.Backend == overrides.BackendKV {
+		provider, err := overrides.NewKVProvider(t.Cfg.Overrides, loadRuntimeConfig, util_log.Logger, prometheus.DefaultRegisterer)
+		if err != nil {
+			return nil, err
+		}
+
+		t.RuntimeConfigKV = provider
+		t.TenantLimits = newTenantLimits(provider)
+		t.TenantSettings = newTenantSettings(provider)
+		return provider, nil
+	}
+
+	if t.Cfg.RuntimeConfig.LoadPath == "" {
+		// no need to initialize module if load path is empty
+		return nil, nil
+	}
+
+	t.Cfg.RuntimeConfig.Loader = loadRuntimeConfig
+
+	serv, err := runtimeconfig.New(t.Cfg.RuntimeConfig, prometheus.WrapRegistererWithPrefix("cortex_", prometheus.DefaultRegisterer), util_log.Logger)
+	if err != nil {
+		return nil, err
+	}
+
+	t.RuntimeConfig = serv
+	t.TenantLimits = newTenantLimits(serv)
+	t.TenantSettings = newTenantSettings(serv)
+	return serv, nil
+}
+
+func (t *BlockstorageIngester) initOverrides() (serv services.Service, err error) {
+	t.Overrides, err = validation.NewOverrides(t.Cfg.LimitsConfig, t.TenantLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	t.TenantOverrides = overrides.NewOverrides(t.Cfg.Overrides.Defaults, t.TenantSettings)
+	t.TenantIPFilter = ipfilter.NewTenantFilter(t.TenantOverrides, util_log.Logger, prometheus.DefaultRegisterer)
+	prometheus.MustRegister(overrides.NewExporter(t.Overrides, t.TenantLimits, t.TenantOverrides, t.TenantSettings))
+	t.registerRoute("/runtime_config", http.HandlerFunc(t.runtimeConfigHandler), false, "GET")
+
+	// overrides don't have operational state, nor do they need to do anything more in starting/stopping phase,
+	// so there is no need to return any service.
+	return nil, nil
+}
+
+func (t *BlockstorageIngester) initRing() (serv services.Service, err error) {
+	t.Ring, err = ring.New(t.Cfg.Ingester.LifecyclerConfig.RingConfig, "ingester", ingester.RingKey, util_log.Logger, prometheus.WrapRegistererWithPrefix("cortex_", prometheus.DefaultRegisterer))
+	if err != nil {
+		return nil, err
+	}
+
+	t.registerRoute("/ingester/ring", t.audited("ring_forget", t.Ring), false, "GET", "POST")
+	return t.Ring, nil
+}
+
+func (t *BlockstorageIngester) initDeadLetter() (services.Service, error) {
+	if !t.Cfg.DeadLetter.Enabled() {
+		return nil, nil
+	}
+
+	// The bucket client is only created for the bucket backend, so that the other backends
+	// don't depend on the blocks storage.
+	var bkt objstore.Bucket
+	if t.Cfg.DeadLetter.Backend == deadletter.BackendBucket {
+		var err error
+		if bkt, err = bucket.NewClient(context.Background(), t.Cfg.BlocksStorage.Bucket, "dead-letter", util_log.Logger, prometheus.DefaultRegisterer); err != nil {
+			return nil, err
+		}
+	}
+
+	t.DeadLetter = deadletter.NewWriter(t.Cfg.DeadLetter, bkt, util_log.Logger, prometheus.DefaultRegisterer)
+	return t.DeadLetter, nil
+}
+
+func (t *BlockstorageIngester) initIngestionLimits() (services.Service, error) {
+	// The rejected series are written to the dead-letter sink, if enabled.
+	var rejected limits.Rejected
+	if t.DeadLetter != nil {
+		rejected = t.DeadLetter
+	}
+
+	// The tenants created and the limits breached are published to the events bus, if enabled.
+	var publisher limits.Publisher
+	if t.Events != nil {
+		publisher = t.Events
+	}
+
+	// The global series limits are divided across the healthy ingesters in the ring.
+	ringCount := limits.NewReadRingCount(t.Ring)
+	t.IngestionLimits = limits.NewEnforcer(t.Cfg.IngestionLimits, t.Overrides, ringCount, t.Cfg.Ingester.LifecyclerConfig.RingConfig.ReplicationFactor, t.instanceLimits, rejected, publisher, prometheus.DefaultRegisterer)
+	return t.IngestionLimits, nil
+}
+
+func (t *BlockstorageIngester) initAutoscaling() (services.Service, error) {
+	if !t.Cfg.Autoscaling.Enabled {
+		return nil, nil
+	}
+
+	t.Autoscaling = autoscaling.NewExporter(t.Cfg.Autoscaling, t.IngestionLimits, limits.NewReadRingCount(t.Ring), t.instanceLimits, util_log.Logger, prometheus.DefaultRegisterer)
+	t.registerRoute(autoscaling.Path, t.Autoscaling, false, "GET")
+	return t.Autoscaling, nil
+}
+
+func (t *BlockstorageIngester) initIngestionMetrics() (services.Service, error) {
+	// The in-memory series are only known when the TSDB heads are in this process.
+	var userStats ingester_metrics.UserStats
+	if t.Ingester != nil {
+		userStats = t.Ingester
+	}
+
+	t.IngestionMetrics = ingester_metrics.NewMetrics(t.Cfg.IngestionMetrics, t.Cfg.Histograms, t.IngestionLimits, userStats, util_log.Logger, prometheus.DefaultRegisterer)
+	return t.IngestionMetrics, nil
+}
+
+func (t *BlockstorageIngester) initBucketClient() (serv services.Service, err error) {
+	t.Bucket, err = bucket.NewClient(context.Background(), t.Cfg.BlocksStorage.Bucket, "blockstorage-ingester", util_log.Logger, prometheus.DefaultRegisterer)
+	if err != nil {
+		return nil, err
+	}
+
+	// The faults are injected beneath the instrumentation, so that the injected latency and
+	// errors show up in the metrics.
+	if t.FaultInjector != nil {
+		t.Bucket = t.FaultInjector.Bucket(t.Bucket)
+	}
+
+	// The WAL fsync latency is already exposed by the TSDB, as
+	// prometheus_tsdb_wal_fsync_duration_seconds.
+	t.Bucket = local_bucket.NewUploadDurationBucketClient(t.Bucket, t.Cfg.Histograms, prometheus.DefaultRegisterer)
+
+	// The caching bucket is t.Bucket itself if no metadata cache is configured.
+	t.CachingBucket, err = bucket_tsdb.CreateCachingBucket(bucket_tsdb.ChunksCacheConfig{}, t.Cfg.MetadataCache, t.Bucket, util_log.Logger, prometheus.DefaultRegisterer)
+	if err != nil {
+		return nil, errors.Wrap(err, "create metadata caching bucket")
+	}
+	return nil, nil
+}
+
+func (t *BlockstorageIngester) initTenantDeletion() (services.Service, error) {
+	// The tenants deleted are published to the events bus, if enabled.
+	var publisher tenantdeletion.Publisher
+	if t.Events != nil {
+		publisher = t.Events
+	}
+
+	t.TenantDeletion = tenantdeletion.NewDeleter(t.Cfg.TenantDeletion, t.Bucket, t.Cfg.BlocksStorage.TSDB.Dir, t.newLeaderElector("tenant-deletion"), publisher, util_log.Logger, prometheus.DefaultRegisterer)
+	t.registerRoute(tenantdeletion.DeletePath, t.audited("tenant_delete", http.HandlerFunc(t.TenantDeletion.DeleteHandler)), true, "POST")
+	t.registerRoute(tenantdeletion.StatusPath, http.HandlerFunc(t.TenantDeletion.StatusHandler), true, "GET")
+	return t.TenantDeletion, nil
+}
+
+func (t *BlockstorageIngester) initHATracker() (services.Service, error) {
+	if !t.Cfg.HATracker.Enabled {
+		return nil, nil
+	}
+
+	client, err := hatracker.NewKVClient(t.Cfg.HATracker, util_log.Logger, prometheus.DefaultRegisterer)
+	if err != nil {
+		return nil, err
+	}
+
+	t.HATracker = hatracker.NewTracker(t.Cfg.HATracker, t.Overrides, t.withFaults(client), util_log.Logger, prometheus.DefaultRegisterer)
+	return t.HATracker, nil
+}
+
+func (t *BlockstorageIngester) initCostAttribution() (services.Service, error) {
+	if !t.Cfg.CostAttribution.Enabled {
+		return nil, nil
+	}
+
+	t.CostTracker = costattribution.NewTracker(t.Cfg.CostAttribution, t.Cfg.BlocksStorage.TSDB.Dir, t.TenantOverrides, t.IngestionLimits, util_log.Logger, prometheus.DefaultRegisterer)
+	return t.CostTracker, nil
+}
+
+func (t *BlockstorageIngester) initCardinality() (services.Service, error) {
+	// In agent mode, there is no TSDB head to analyze.
+	if t.Cfg.Forwarder.Enabled {
+		return nil, nil
+	}
+
+	t.Cardinality = cardinality.NewAPI(t.Cfg.Cardinality, t.Ingester, util_log.Logger)
+	t.registerRoute(cardinality.LabelNamesPath, t.query(t.Cardinality.LabelNamesHandler), true, "GET")
+	t.registerRoute(cardinality.MetricNamesPath, t.query(t.Cardinality.MetricNamesHandler), true, "GET")
+	return nil, nil
+}
+
+func (t *BlockstorageIngester) initStoreGateway() (serv services.Service, err error) {
+	t.Cfg.StoreGateway.ShardingRing.ListenPort = t.Cfg.Server.GRPCListenPort
+
+	// The blocks are sharded across the store-gateways of the ring, each loading the
+	// index-headers of its blocks from the bucket.
+	t.StoreGateway, err = storegateway.NewStoreGateway(t.Cfg.StoreGateway, t.Cfg.BlocksStorage, t.Overrides, t.Cfg.Server.LogLevel, util_log.Logger, prometheus.DefaultRegisterer)
+	if err != nil {
+		return nil, err
+	}
+
+	// The samples deleted by the tombstones are masked in the series streamed to the queriers.
+	var srv storegatewaypb.StoreGatewayServer = t.StoreGateway
+	if tombstonesOf := t.tombstonesOf(); tombstonesOf != nil {
+		srv = seriesdeletion.StoreGatewayServer(srv, tombstonesOf)
+	}
+	storegatewaypb.RegisterStoreGatewayServer(t.Server.GRPC, srv)
+	t.registerRoute("/store-gateway/ring", t.audited("store_gateway_ring_forget", http.HandlerFunc(t.StoreGateway.RingHandler)), false, "GET", "POST")
+	return t.StoreGateway, nil
+}
+
+func (t *BlockstorageIngester) initCompactor() (serv services.Service, err error) {
+	t.Cfg.Compactor.ShardingRing.ListenPort = t.Cfg.Server.GRPCListenPort
+
+	// The tenants are sharded across the compactors of the ring if sharding is enabled, each
+	// compacting the blocks of its tenants and deleting the source blocks once their deletion
+	// delay has passed. The compactor updates the bucket index of its tenants as well, without
+	// the tiering marks and tombstones, which are kept in the index extension written by the
+	// bucket indexer only.
+	t.Compactor, err = compactor.NewCompactor(t.Cfg.Compactor, t.Cfg.BlocksStorage, t.Overrides, util_log.Logger, prometheus.DefaultRegisterer, t.Overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	t.registerRoute("/compactor/ring", t.audited("compactor_ring_forget", http.HandlerFunc(t.Compactor.RingHandler)), false, "GET", "POST")
+	return t.Compactor, nil
+}
+
+func (t *BlockstorageIngester) initHeadAPI() (services.Service, error) {
+	// In agent mode, there is no TSDB head to query.
+	if t.Cfg.Forwarder.Enabled {
+		return nil, nil
+	}
+
+	t.HeadAPI = head.NewAPI(t.Cfg.HeadAPI, t.Ingester, t.tombstonesOf(), util_log.Logger)
+	t.registerRoute(head.LabelNamesPath, t.query(t.HeadAPI.LabelNamesHandler), true, "GET", "POST")
+	t.registerRoute(head.LabelValuesPath, t.query(t.HeadAPI.LabelValuesHandler), true, "GET")
+	t.registerRoute(head.SeriesPath, t.query(t.HeadAPI.SeriesHandler), true, "GET", "POST")
+	t.registerRoute(head.RemoteReadPath, t.query(t.HeadAPI.RemoteReadHandler), true, "POST")
+	t.registerRoute(head.ExemplarsPath, t.query(t.HeadAPI.ExemplarsHandler), true, "GET", "POST")
+	t.registerRoute(head.MetadataPath, t.query(t.HeadAPI.MetadataHandler), true, "GET")
+	return nil, nil
+}
+
+func (t *BlockstorageIngester) initPush() (services.Service, error) {
+	var target push.Func
+	switch {
+	case t.Forwarder != nil:
+		// In agent mode, the series are only written to the forwarder WAL.
+		target = push.TracedFunc("forwarder", t.IngestionMetrics.Wrap(t.Forwarder.Push))
+	case t.IngestWriter != nil:
+		target = push.TracedFunc("ingest_storage", t.IngestionMetrics.Wrap(t.IngestWriter.PushFunc()))
+	case t.Ingester != nil:
+		// The ingester appends the series to the TSDB head and its WAL.
+		ingesterPush := push.Func(t.Ingester.Push)
+		if t.FaultInjector != nil {
+			ingesterPush = t.FaultInjector.WALMiddleware()(ingesterPush)
+		}
+		target = push.TracedFunc("ingester", t.IngestionMetrics.Wrap(ingesterPush))
+	default:
+		return nil, errors.New("the push path requires either the forwarder, the ingest storage writer or the ingester to be running")
+	}
+
+	t.WriteFederation = push.NewFederation(t.Cfg.WriteFederation, prometheus.DefaultRegisterer)
+	t.RateLimiter = ratelimit.NewLimiter(t.Cfg.PushRateLimits, t.TenantOverrides, t.instanceLimits, prometheus.DefaultRegisterer)
+	t.Relabeler = relabeling.NewRelabeler(t.Overrides, prometheus.DefaultRegisterer)
+	t.MetricFilter = metricfilter.NewFilter(t.TenantOverrides, util_log.Logger, prometheus.DefaultRegisterer)
+
+	// The invalid series are written to the dead-letter sink, if enabled.
+	var rejected seriesvalidation.Rejected
+	if t.DeadLetter != nil {
+		rejected = t.DeadLetter
+	}
+	t.Validator = seriesvalidation.NewValidator(t.Overrides, t.TenantOverrides, rejected, prometheus.DefaultRegisterer)
+
+	// The received samples are counted first, so that the samples dropped by any stage are
+	// accounted. The read-only check runs next, so that rejected requests don't pay for any
+	// further processing. Each stage is traced, to see where a slow push spends its time.
+	middlewares := []push.Middleware{t.IngestionMetrics.PushMiddleware()}
+	// The autoscaling signals are based on the load received, rejected or not.
+	if t.Autoscaling != nil {
+		middlewares = append(middlewares, t.Autoscaling.PushMiddleware())
+	}
+	// The requests are mirrored as received, so that the shadow deployment is compared
+	// against the responses returned to the clients, rejections included.
+	if t.Shadow != nil {
+		middlewares = append(middlewares, push.Traced("shadow", t.Shadow.PushMiddleware()))
+	}
+	middlewares = append(middlewares,
+		push.Traced("read_only", t.ReadOnly.PushMiddleware()),
+		push.Traced("federation", t.WriteFederation.Middleware()),
+		push.Traced("rate_limit", t.RateLimiter.PushMiddleware()),
+		push.Traced("tenant_deletion", t.TenantDeletion.PushMiddleware()))
+
+	// Samples of non-elected HA replicas are dropped before counting them against the limits.
+	if t.HATracker != nil {
+		middlewares = append(middlewares, push.Traced("ha_tracker", t.HATracker.PushMiddleware()))
+	}
+
+	// Series are filtered and relabeled before the validation and the limits, so that dropped
+	// series and labels don't count against them, and the relabeling can fix invalid series.
+	middlewares = append(middlewares,
+		push.Traced("metric_filter", t.MetricFilter.PushMiddleware()),
+		push.Traced("relabeling", t.Relabeler.PushMiddleware()),
+		push.Traced("validation", t.Validator.PushMiddleware()),
+		push.Traced("limits", t.IngestionLimits.PushMiddleware()))
+
+	// Cost attribution runs last, to account only the samples actually ingested.
+	if t.CostTracker != nil {
+		middlewares = append(middlewares, push.Traced("cost_attribution", t.CostTracker.PushMiddleware()))
+	}
+
+	// The tee runs last too, so that only the series actually ingested are forwarded.
+	if t.Tee != nil {
+		middlewares = append(middlewares, push.Traced("tee", t.Tee.PushMiddleware()))
+	}
+
+	// The samples of all the stages are labeled with the tenant in the continuous profiles.
+	if t.Profiler != nil {
+		middlewares = append([]push.Middleware{profiling.Middleware("push")}, middlewares...)
+	}
+
+	// Slow requests are logged with the time spent in each stage, so the logger wraps them all.
+	if threshold := t.Cfg.RequestLog.SlowRequestThreshold; threshold > 0 {
+		middlewares = append([]push.Middleware{push.SlowRequestLogger(threshold, util_log.Logger)}, middlewares...)
+	}
+
+	// The push duration covers all the stages, including the slow request logging.
+	middlewares = append([]push.Middleware{push.DurationMiddleware(t.Cfg.Histograms, prometheus.DefaultRegisterer)}, middlewares...)
+
+	t.PushFunc = push.Chain(target, middlewares...)
+
+	handler := t.RouteTimeouts.Wrap(routetimeout.ClassPush, push.Handler(t.Cfg.PushHandler, t.Cfg.Server.GPRCServerMaxRecvMsgSize, nil, t.PushFunc))
+	if t.Cfg.ClientCertAuth.Enabled {
+		// The client certificate is checked before the tenant is resolved, since the
+		// tenant may be read from the certificate.
+		handler = t.httpAuthMiddleware().Wrap(handler)
+		handler = auth.NewClientCertAuthenticator(t.Cfg.ClientCertAuth, util_log.Logger, prometheus.DefaultRegisterer).Wrap(handler)
+		t.registerRoute("/api/v1/push", handler, false, "POST")
+	} else {
+		t.registerRoute("/api/v1/push", handler, true, "POST")
+	}
+	return nil, nil
+}
+
+func (t *BlockstorageIngester) initAuditLog() (services.Service, error) {
+	if t.AuditLog == nil {
+		return nil, nil
+	}
+
+	// The audit log is opened when the BlockstorageIngester is created, so that any module can
+	// audit its routes. This module closes it, and audits the per-tenant overrides changes.
+	watcher := audit.NewOverridesWatcher(t.Cfg.Audit.OverridesCheckPeriod, t.tenantOverridesSnapshot, t.AuditLog)
+	watcher.AddListener(services.NewListener(nil, nil, nil, func(services.State) {
+		_ = t.AuditLog.Close()
+	}, func(services.State, error) {
+		_ = t.AuditLog.Close()
+	}))
+	return watcher, nil
+}
+
+func (t *BlockstorageIngester) initUsageStats() (services.Service, error) {
+	// Nothing is collected nor sent unless explicitly enabled.
+	if !t.Cfg.UsageStats.Enabled {
+		return nil, nil
+	}
+
+	modules := func() []string {
+		out := make([]string, 0, len(t.ServiceMap))
+		for m := range t.ServiceMap {
+			out = append(out, m)
+		}
+		return out
+	}
+
+	t.UsageStats = usagestats.NewReporter(t.Cfg.UsageStats, t.Cfg.BlocksStorage.TSDB.Dir, t.Cfg.Target, modules, t.IngestionLimits, util_log.Logger, prometheus.DefaultRegisterer)
+	return t.UsageStats, nil
+}
+
+func (t *BlockstorageIngester) initQuerier() (services.Service, error) {
+	// The head is only queried if the ingester runs in this process.
+	var source head.QuerySource
+	if t.Ingester != nil {
+		source = t.Ingester
+	} else {
+		level.Warn(util_log.Logger).Log("msg", "the ingester is not running, the querier only queries the bucket blocks")
+	}
+
+	engineOpts := promql.EngineOpts{
+		Logger:               util_log.Logger,
+		Reg:                  prometheus.DefaultRegisterer,
+		MaxSamples:           t.Cfg.Querier.MaxSamples,
+		Timeout:              t.Cfg.Querier.Timeout,
+		LookbackDelta:        t.Cfg.Querier.LookbackDelta,
+		EnableAtModifier:     true,
+		EnableNegativeOffset: true,
+	}
+
+	// The chunks are cached by the querier only, the metrics being prefixed not to clash with
+	// the ones of the metadata cache.
+	bkt, err := bucket_tsdb.CreateCachingBucket(t.Cfg.LocalQuerier.ChunksCache, bucket_tsdb.MetadataCacheConfig{}, t.CachingBucket, util_log.Logger, prometheus.WrapRegistererWithPrefix("cortex_querier_", prometheus.DefaultRegisterer))
+	if err != nil {
+		return nil, errors.Wrap(err, "create chunks caching bucket")
+	}
+
+	t.LocalQuerier = local_querier.NewQuerier(t.Cfg.LocalQuerier, engineOpts, source, bkt, t.Overrides, util_log.Logger, prometheus.DefaultRegisterer)
+	t.registerRoute(local_querier.QueryPath, t.query(t.LocalQuerier.QueryHandler), true, "GET", "POST")
+	t.registerRoute(local_querier.QueryRangePath, t.query(t.LocalQuerier.QueryRangeHandler), true, "GET", "POST")
+	return t.LocalQuerier, nil
+}
+
+func (t *BlockstorageIngester) initShipper() (services.Service, error) {
+	if !t.Cfg.Shipper.Enabled {
+		return nil, nil
+	}
+
+	// The blocks cut and shipped are posted to the webhooks, if enabled.
+	var notifier shipper.Notifier
+	if t.Webhook != nil {
+		notifier = t.Webhook
+	}
+
+	// The failed uploads are published to the events bus, if enabled.
+	var publisher shipper.Publisher
+	if t.Events != nil {
+		publisher = t.Events
+	}
+
+	t.Shipper = shipper.NewShipper(t.Cfg.Shipper, t.Cfg.BlocksStorage.TSDB.Dir, t.Cfg.Ingester.LifecyclerConfig.ID, t.Bucket, t.Overrides, notifier, publisher, util_log.Logger, prometheus.DefaultRegisterer)
+	return t.Shipper, nil
+}
+
+func (t *BlockstorageIngester) initWebhook() (services.Service, error) {
+	if !t.Cfg.Webhook.Enabled() {
+		return nil, nil
+	}
+
+	t.Webhook = webhook.NewNotifier(t.Cfg.Webhook, util_log.Logger, prometheus.DefaultRegisterer)
+	return t.Webhook, nil
+}
+
+func (t *BlockstorageIngester) initForwarder() (services.Service, error) {
+	if !t.Cfg.Forwarder.Enabled {
+		return nil, nil
+	}
+
+	walDir := t.Cfg.Forwarder.WALDir
+	if walDir == "" {
+		walDir = filepath.Join(t.Cfg.BlocksStorage.TSDB.Dir, "forwarder-wal")
+	}
+
+	t.Forwarder = forwarder.NewForwarder(t.Cfg.Forwarder, walDir, util_log.Logger, prometheus.DefaultRegisterer)
+	return t.Forwarder, nil
+}
+
+func (t *BlockstorageIngester) initTee() (services.Service, error) {
+	if !t.Cfg.Tee.Enabled() {
+		return nil, nil
+	}
+
+	t.Tee = tee.NewTee(t.Cfg.Tee, t.TenantOverrides, util_log.Logger, prometheus.DefaultRegisterer)
+	return t.Tee, nil
+}
+
+func (t *BlockstorageIngester) initShadow() (services.Service, error) {
+	if !t.Cfg.Shadow.Enabled {
+		return nil, nil
+	}
+
+	t.Shadow = shadow.NewMirror(t.Cfg.Shadow, util_log.Logger, prometheus.DefaultRegisterer)
+	return t.Shadow, nil
+}
+
+func (t *BlockstorageIngester) initScraper() (services.Service, error) {
+	if !t.Cfg.Scraper.Enabled() {
+		return nil, nil
+	}
+
+	// The scraped samples go through the same push path as the remote write requests.
+	t.Scraper = scraper.NewScraper(t.Cfg.Scraper, t.PushFunc, util_log.Logger, prometheus.DefaultRegisterer)
+	return t.Scraper, nil
+}
+
+func (t *BlockstorageIngester) initEvents() (services.Service, error) {
+	if !t.Cfg.Events.Enabled() {
+		return nil, nil
+	}
+
+	t.Events = events.NewBus(t.Cfg.Events, t.Cfg.Ingester.LifecyclerConfig.ID, util_log.Logger, prometheus.DefaultRegisterer)
+	return t.Events, nil
+}
+
+func (t *BlockstorageIngester) initConsistencyCheck() (services.Service, error) {
+	if !t.Cfg.ConsistencyCheck.Enabled {
+		return nil, nil
+	}
+
+	t.ConsistencyCheck = consistency.NewChecker(t.Cfg.ConsistencyCheck, t.Cfg.BlocksStorage.TSDB.Dir, t.Bucket, t.Overrides, util_log.Logger, prometheus.DefaultRegisterer)
+	t.registerRoute("/ingester/consistency-check", t.ConsistencyCheck, false, "GET")
+	return t.ConsistencyCheck, nil
+}
+
+func (t *BlockstorageIngester) initStorageProbe() (services.Service, error) {
+	t.StorageProbe = readiness.NewStorageProbe(t.Cfg.Readiness, t.Bucket, util_log.Logger)
+	return t.StorageProbe, nil
+}
+
+func (t *BlockstorageIngester) initBucketIndexer() (services.Service, error) {
+	if !t.Cfg.BucketIndex.Enabled {
+		return nil, nil
+	}
+
+	// The blocks marked for deletion are posted to the webhooks, if enabled.
+	var notifier bucketindexer.Notifier
+	if t.Webhook != nil {
+		notifier = t.Webhook
+	}
+
+	t.BucketIndexer = bucketindexer.NewIndexer(t.Cfg.BucketIndex, t.Bucket, t.Overrides, t.newLeaderElector("bucket-index"), notifier, util_log.Logger, prometheus.DefaultRegisterer)
+	return t.BucketIndexer, nil
+}
+
+func (t *BlockstorageIngester) initReplication() (services.Service, error) {
+	if !t.Cfg.Replication.Enabled {
+		return nil, nil
+	}
+
+	replica, err := local_bucket.NewClient(context.Background(), t.Cfg.Replication.Bucket, "replication", util_log.Logger, prometheus.DefaultRegisterer)
+	if err != nil {
+		return nil, errors.Wrap(err, "create replica bucket client")
+	}
+
+	t.Replicator = replication.NewReplicator(t.Cfg.Replication, t.CachingBucket, replica, t.Overrides, t.newLeaderElector("replication"), util_log.Logger, prometheus.DefaultRegisterer)
+	return t.Replicator, nil
+}
+
+func (t *BlockstorageIngester) initTiering() (services.Service, error) {
+	if !t.Cfg.Tiering.Enabled {
+		return nil, nil
+	}
+
+	var rewriter tiering.Rewriter
+	if t.Cfg.Tiering.Mode == tiering.ModeRewrite {
+		var err error
+		if rewriter, err = tiering.NewRewriter(context.Background(), t.Cfg.BlocksStorage.Bucket); err != nil {
+			return nil, errors.Wrap(err, "create tiering rewriter")
+		}
+	}
+
+	t.Tierer = tiering.NewTierer(t.Cfg.Tiering, t.CachingBucket, t.Overrides, t.TenantOverrides, rewriter, t.newLeaderElector("tiering"), util_log.Logger, prometheus.DefaultRegisterer)
+	return t.Tierer, nil
+}
+
+func (t *BlockstorageIngester) initSeriesDeletion() (services.Service, error) {
+	if !t.Cfg.SeriesDeletion.Enabled {
+		return nil, nil
+	}
+
+	// The tombstones are recorded by any target serving the API, while the blocks are rewritten
+	// by the compactor target only.
+	rewrite := t.Cfg.isModuleEnabled(Compactor)
+	t.SeriesDeleter = seriesdeletion.NewDeleter(t.Cfg.SeriesDeletion, t.Bucket, t.Overrides, rewrite, t.newLeaderElector("series-deletion"), util_log.Logger, prometheus.DefaultRegisterer)
+	t.registerRoute(seriesdeletion.DeletePath, t.audited("delete_series", http.HandlerFunc(t.SeriesDeleter.DeleteHandler)), true, "POST", "PUT")
+	return t.SeriesDeleter, nil
+}
+
+// tombstonesOf returns the tombstones of the tenants from their bucket index, or nil if the
+// series deletion is disabled.
+func (t *BlockstorageIngester) tombstonesOf() seriesdeletion.TombstonesFunc {
+	if !t.Cfg.SeriesDeletion.Enabled {
+		return nil
+	}
+	return seriesdeletion.NewTombstonesLoader(t.CachingBucket, t.Overrides, t.Cfg.SeriesDeletion.TombstonesTTL, util_log.Logger).Tombstones
+}
+
+func (t *BlockstorageIngester) initProfiling() (services.Service, error) {
+	if !t.Cfg.Profiling.Enabled() {
+		return nil, nil
+	}
+
+	labels := map[string]string{"target": strings.Join(t.Cfg.Target, ",")}
+	t.Profiler = profiling.NewProfiler(t.Cfg.Profiling, labels, util_log.Logger, prometheus.DefaultRegisterer)
+	return t.Profiler, nil
+}
+
+// tenantOverridesSnapshot returns the limits and settings overridden for each tenant.
+func (t *BlockstorageIngester) tenantOverridesSnapshot() map[string]interface{} {
+	type tenantOverrides struct {
+		Limits   *validation.Limits  `yaml:"limits,omitempty"`
+		Settings *overrides.Settings `yaml:"settings,omitempty"`
+	}
+
+	snapshot := map[string]*tenantOverrides{}
+	get := func(userID string) *tenantOverrides {
+		if snapshot[userID] == nil {
+			snapshot[userID] = &tenantOverrides{}
+		}
+		return snapshot[userID]
+	}
+
+	if t.TenantLimits != nil {
+		for userID, l := range t.TenantLimits.AllByUserID() {
+			get(userID).Limits = l
+		}
+	}
+	if t.TenantSettings != nil {
+		for userID, s := range t.TenantSettings.AllByUserID() {
+			get(userID).Settings = s
+		}
+	}
+
+	out := make(map[string]interface{}, len(snapshot))
+	for userID, o := range snapshot {
+		out[userID] = o
+	}
+	return out
+}
+
+// newLeaderElector returns an elector for the input singleton job, to be started along
+// with the module running the job.
+func (t *BlockstorageIngester) newLeaderElector(job string) *leaderelection.Elector {
+	return leaderelection.NewElector(t.Cfg.LeaderElection, t.LeaderElectionKV, job, t.Cfg.Ingester.LifecyclerConfig.ID, util_log.Logger, prometheus.DefaultRegisterer)
+}
+
+func (t *BlockstorageIngester) setupModuleManager() error {
+	mm := modules.NewManager(util_log.Logger)
+
+	// Register all modules here.
+	// RegisterModule(name string, initFn func()(services.Service, error), options...)
+	mm.RegisterModule(Server, t.initServer, modules.UserInvisibleModule)
+	mm.RegisterModule(ServerTLS, t.initServerTLS, modules.UserInvisibleModule)
+	mm.RegisterModule(UnixSockets, t.initUnixSockets, modules.UserInvisibleModule)
+	mm.RegisterModule(ProxyProtocol, t.initProxyProtocol, modules.UserInvisibleModule)
+	mm.RegisterModule(FaultInjection, t.initFaultInjection, modules.UserInvisibleModule)
+	mm.RegisterModule(AdminServer, t.initAdminServer, modules.UserInvisibleModule)
+	mm.RegisterModule(StaticAuth, t.initStaticAuth, modules.UserInvisibleModule)
+	mm.RegisterModule(JWTAuth, t.initJWTAuth, modules.UserInvisibleModule)
+	mm.RegisterModule(TenantTokens, t.initTenantTokens, modules.UserInvisibleModule)
+	mm.RegisterModule(MemberlistKV, t.initMemberlistKV, modules.UserInvisibleModule)
+	mm.RegisterModule(Ingester, t.initIngester, modules.UserInvisibleModule)
+	mm.RegisterModule(IngesterHandover, t.initIngesterHandover, modules.UserInvisibleModule)
+	mm.RegisterModule(IngesterReadOnly, t.initIngesterReadOnly, modules.UserInvisibleModule)
+	mm.RegisterModule(PrepareShutdown, t.initPrepareShutdown, modules.UserInvisibleModule)
+	mm.RegisterModule(IngestWriter, t.initIngestWriter, modules.UserInvisibleModule)
+	mm.RegisterModule(PartitionReader, t.initPartitionReader, modules.UserInvisibleModule)
+	mm.RegisterModule(LeaderElectionKV, t.initLeaderElectionKV, modules.UserInvisibleModule)
+	mm.RegisterModule(RuntimeConfig, t.initRuntimeConfig, modules.UserInvisibleModule)
+	mm.RegisterModule(Overrides, t.initOverrides, modules.UserInvisibleModule)
+	mm.RegisterModule(Ring, t.initRing, modules.UserInvisibleModule)
+	mm.RegisterModule(DeadLetter, t.initDeadLetter, modules.UserInvisibleModule)
+	mm.RegisterModule(IngestionLimits, t.initIngestionLimits, modules.UserInvisibleModule)
+	mm.RegisterModule(IngestionMetrics, t.initIngestionMetrics, modules.UserInvisibleModule)
+	mm.RegisterModule(BucketClient, t.initBucketClient, modules.UserInvisibleModule)
+	mm.RegisterModule(TenantDeletion, t.initTenantDeletion, modules.UserInvisibleModule)
+	mm.RegisterModule(HATracker, t.initHATracker, modules.UserInvisibleModule)
+	mm.RegisterModule(CostAttribution, t.initCostAttribution, modules.UserInvisibleModule)
+	mm.RegisterModule(Cardinality, t.initCardinality, modules.UserInvisibleModule)
+	mm.RegisterModule(Push, t.initPush, modules.UserInvisibleModule)
+	mm.RegisterModule(AuditLog, t.initAuditLog, modules.UserInvisibleModule)
+	mm.RegisterModule(UsageStats, t.initUsageStats, modules.UserInvisibleModule)
+	mm.RegisterModule(Profiling, t.initProfiling, modules.UserInvisibleModule)
+	mm.RegisterModule(Querier, t.initQuerier, modules.UserInvisibleModule)
+	mm.RegisterModule(HeadAPI, t.initHeadAPI, modules.UserInvisibleModule)
+	mm.RegisterModule(StoreGateway, t.initStoreGateway, modules.UserInvisibleModule)
+	mm.RegisterModule(Compactor, t.initCompactor)
+	mm.RegisterModule(Shipper, t.initShipper, modules.UserInvisibleModule)
+	mm.RegisterModule(BucketIndexer, t.initBucketIndexer, modules.UserInvisibleModule)
+	mm.RegisterModule(Replication, t.initReplication, modules.UserInvisibleModule)
+	mm.RegisterModule(Tiering, t.initTiering, modules.UserInvisibleModule)
+	mm.RegisterModule(SeriesDeletion, t.initSeriesDeletion, modules.UserInvisibleModule)
+	mm.RegisterModule(ConsistencyCheck, t.initConsistencyCheck, modules.UserInvisibleModule)
+	mm.RegisterModule(StorageProbe, t.initStorageProbe, modules.UserInvisibleModule)
+	mm.RegisterModule(Autoscaling, t.initAutoscaling, modules.UserInvisibleModule)
+	mm.RegisterModule(Webhook, t.initWebhook, modules.UserInvisibleModule)
+	mm.RegisterModule(Events, t.initEvents, modules.UserInvisibleModule)
+	mm.RegisterModule(Forwarder, t.initForwarder, modules.UserInvisibleModule)
+	mm.RegisterModule(Tee, t.initTee, modules.UserInvisibleModule)
+	mm.RegisterModule(Shadow, t.initShadow, modules.UserInvisibleModule)
+	mm.RegisterModule(Scraper, t.initScraper)
+	mm.RegisterModule(All, nil)
+
+	// Add dependencies
+	deps := map[string][]string{
+		MemberlistKV:     {Server},
+		Ingester:         {Server, Overrides, MemberlistKV, IngesterHandover},
+		IngesterHandover: {Server, MemberlistKV, FaultInjection},
+		IngesterReadOnly: {Server, Ingester, Events},
+		PrepareShutdown:  {Server, Ingester, IngesterReadOnly},
+		ServerTLS:        {Server},
+		UnixSockets:      {Server},
+		ProxyProtocol:    {Server},
+		All:              {Push, HeadAPI, Cardinality, IngesterHandover, IngesterReadOnly, PrepareShutdown, ServerTLS, UnixSockets, ProxyProtocol, StaticAuth, JWTAuth, TenantTokens, AuditLog, AdminServer, UsageStats, Profiling, Shipper, BucketIndexer, Replication, Tiering, SeriesDeletion, ConsistencyCheck, StorageProbe},
+		PartitionReader:  {Server, Ingester},
+		Overrides:        {Server, RuntimeConfig},
+		Ring:             {Server, MemberlistKV},
+		IngestionLimits:  {Overrides, Ring, Ingester, DeadLetter, Events},
+		TenantDeletion:   {Server, Overrides, BucketClient, LeaderElectionKV, Events},
+		HATracker:        {Overrides, FaultInjection},
+		IngestionMetrics: {IngestionLimits, Ingester},
+		CostAttribution:  {IngestionLimits},
+		Cardinality:      {Server, Overrides, Ingester},
+		AuditLog:         {Overrides},
+		TenantTokens:     {Server, Overrides, AdminServer},
+		UsageStats:       {IngestionLimits},
+		Querier:          {Server, Overrides, BucketClient},
+		HeadAPI:          {Server, Overrides, BucketClient, Ingester},
+		StoreGateway:     {Server, Overrides, MemberlistKV, BucketClient},
+		Compactor:        {Server, Overrides, MemberlistKV, SeriesDeletion},
+		Shipper:          {Overrides, BucketClient, Webhook, Events},
+		BucketIndexer:    {Overrides, BucketClient, LeaderElectionKV, Webhook},
+		Replication:      {Overrides, BucketClient, LeaderElectionKV},
+		Tiering:          {Overrides, BucketClient, LeaderElectionKV},
+		SeriesDeletion:   {Server, Overrides, BucketClient, LeaderElectionKV},
+		ConsistencyCheck: {Server, Overrides, BucketClient},
+		StorageProbe:     {BucketClient},
+		Autoscaling:      {Server, IngestionLimits, Ring},
+		Scraper:          {Push},
+		Tee:              {Overrides},
+		Push:             {Server, Ingester, IngesterReadOnly, IngestWriter, IngestionLimits, IngestionMetrics, TenantDeletion, HATracker, CostAttribution, Profiling, FaultInjection, Autoscaling, Forwarder, Tee, Shadow},
+		FaultInjection:   {AdminServer, AuditLog},
+		BucketClient:     {FaultInjection},
+		LeaderElectionKV: {FaultInjection},
+	}
+
+	for mod, targets := range deps {
+		if err := mm.AddDependency(mod, targets...); err != nil {
+			return err
+		}
+	}
+
+	t.ModuleManager = mm
+	return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/auth"
+	"objectstorage/pkg/ingester/head"
+	"objectstorage/pkg/limits"
+)
+
+func TestStoreGateway_ShouldStartAndServeTheRing(t *testing.T) {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Target = []string{StoreGateway}
+	cfg.Server.HTTPListenAddress, cfg.Server.HTTPListenPort = "127.0.0.1", 0
+	cfg.Server.GRPCListenAddress, cfg.Server.GRPCListenPort = "127.0.0.1", 0
+	cfg.BlocksStorage.Bucket.Backend = "filesystem"
+	cfg.BlocksStorage.Bucket.Filesystem.Directory = t.TempDir()
+	cfg.BlocksStorage.BucketStore.SyncDir = t.TempDir()
+	cfg.BlocksStorage.TSDB.Dir = t.TempDir()
+	cfg.StoreGateway.ShardingEnabled = true
+	cfg.StoreGateway.ShardingRing.KVStore.Store = "inmemory"
+	cfg.StoreGateway.ShardingRing.InstanceAddr = "127.0.0.1"
+	cfg.StoreGateway.ShardingRing.WaitStabilityMinDuration = 0
+	require.NoError(t, cfg.Validate(log.NewNopLogger()))
+	// The index-headers are mmapped on first query only.
+	assert.True(t, cfg.BlocksStorage.BucketStore.IndexHeaderLazyLoadingEnabled)
+
+	b := startModules(t, cfg)
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/store-gateway/ring", b.Server.HTTPListenAddr()))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestAll_ShouldRunTheIngesterAndServeThePushAPI(t *testing.T) {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Server.HTTPListenAddress, cfg.Server.HTTPListenPort = "127.0.0.1", 0
+	cfg.Server.GRPCListenAddress, cfg.Server.GRPCListenPort = "127.0.0.1", 0
+	cfg.BlocksStorage.Bucket.Backend = "filesystem"
+	cfg.BlocksStorage.Bucket.Filesystem.Directory = t.TempDir()
+	cfg.BlocksStorage.BucketStore.SyncDir = t.TempDir()
+	cfg.BlocksStorage.TSDB.Dir = t.TempDir()
+	cfg.Ingester.LifecyclerConfig.RingConfig.KVStore.Store = "inmemory"
+	cfg.Ingester.LifecyclerConfig.Addr = "127.0.0.1"
+	cfg.Ingester.LifecyclerConfig.JoinAfter = 0
+	cfg.Ingester.LifecyclerConfig.FinalSleep = 0
+	cfg.Target = []string{All}
+	require.NoError(t, cfg.Validate(log.NewNopLogger()))
+
+	b := startModules(t, cfg)
+	require.NotNil(t, b.Ingester)
+	assert.Eventually(t, func() bool {
+		return b.IngesterLifecycler.GetState() == ring.ACTIVE
+	}, 10*time.Second, 10*time.Millisecond)
+	// The global limits are divided across the healthy ingesters of the ring.
+	assert.Eventually(t, func() bool {
+		return limits.NewReadRingCount(b.Ring).HealthyInstancesCount() == 1
+	}, 10*time.Second, 10*time.Millisecond)
+
+	req := &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+		Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: "up"}},
+		Samples: []cortexpb.Sample{{Value: 1, TimestampMs: time.Now().UnixMilli()}},
+	}}}}
+	body, err := req.Marshal()
+	require.NoError(t, err)
+
+	httpReq, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/api/v1/push", b.Server.HTTPListenAddr()), bytes.NewReader(snappy.Encode(nil, body)))
+	require.NoError(t, err)
+	httpReq.Header.Set("X-Scope-OrgID", "user-1")
+	resp, err := http.DefaultClient.Do(httpReq)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// The pushed series are queried from the ingester head.
+	httpReq, err = http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s%s", b.Server.HTTPListenAddr(), head.LabelNamesPath), nil)
+	require.NoError(t, err)
+	httpReq.Header.Set("X-Scope-OrgID", "user-1")
+	resp, err = http.DefaultClient.Do(httpReq)
+	require.NoError(t, err)
+	labelNames, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(labelNames), "__name__")
+}
+
+func TestIngesterReadOnly_ShouldLeaveTheIngestersRing(t *testing.T) {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Target = []string{IngesterReadOnly}
+	cfg.Server.HTTPListenAddress, cfg.Server.HTTPListenPort = "127.0.0.1", 0
+	cfg.Server.GRPCListenAddress, cfg.Server.GRPCListenPort = "127.0.0.1", 0
+	cfg.BlocksStorage.Bucket.Backend = "filesystem"
+	cfg.BlocksStorage.Bucket.Filesystem.Directory = t.TempDir()
+	cfg.BlocksStorage.TSDB.Dir = t.TempDir()
+	cfg.Ingester.LifecyclerConfig.RingConfig.KVStore.Store = "inmemory"
+	cfg.Ingester.LifecyclerConfig.Addr = "127.0.0.1"
+	cfg.Ingester.LifecyclerConfig.JoinAfter = 0
+	cfg.Ingester.LifecyclerConfig.FinalSleep = 0
+	cfg.IngesterReadOnly.Enabled = true
+	require.NoError(t, cfg.Validate(log.NewNopLogger()))
+
+	b := startModules(t, cfg)
+	assert.Equal(t, ring.LEAVING, b.IngesterLifecycler.GetState())
+
+	for orgID, expected := range map[string]int{"": http.StatusUnauthorized, "user-1": http.StatusInternalServerError} {
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://%s/ingester/read-only", b.Server.HTTPListenAddr()), nil)
+		require.NoError(t, err)
+		if orgID != "" {
+			req.Header.Set("X-Scope-OrgID", orgID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, expected, resp.StatusCode, orgID)
+	}
+
+	// The instance can't rejoin the ring.
+	assert.True(t, b.ReadOnly.IsReadOnly())
+	assert.Equal(t, ring.LEAVING, b.IngesterLifecycler.GetState())
+}
+
+func TestIngesterHandover_ShouldAuthenticateTheHandoverRoutes(t *testing.T) {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Target = []string{IngesterHandover}
+	cfg.Server.HTTPListenAddress, cfg.Server.HTTPListenPort = "127.0.0.1", 0
+	cfg.Server.GRPCListenAddress, cfg.Server.GRPCListenPort = "127.0.0.1", 0
+	cfg.BlocksStorage.TSDB.Dir = t.TempDir()
+	cfg.Ingester.LifecyclerConfig.RingConfig.KVStore.Store = "inmemory"
+	cfg.IngesterHandover.Enabled = true
+	require.NoError(t, cfg.Validate(log.NewNopLogger()))
+
+	b := startModules(t, cfg)
+
+	for orgID, expected := range map[string]int{"": http.StatusUnauthorized, "user-1": http.StatusNoContent} {
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/ingester/handover/complete", b.Server.HTTPListenAddr()), nil)
+		require.NoError(t, err)
+		if orgID != "" {
+			req.Header.Set("X-Scope-OrgID", orgID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, expected, resp.StatusCode, orgID)
+	}
+}
+
+func TestTenantTokens_ShouldBeManagedOnTheAdminListener(t *testing.T) {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Target = []string{TenantTokens}
+	cfg.Server.HTTPListenAddress, cfg.Server.HTTPListenPort = "127.0.0.1", 0
+	cfg.Server.GRPCListenAddress, cfg.Server.GRPCListenPort = "127.0.0.1", 0
+	cfg.Admin.ListenAddress, cfg.Admin.Username, cfg.Admin.Password.Value = "127.0.0.1:0", "admin", "secret"
+	cfg.TenantTokens.Enabled = true
+	cfg.TenantTokens.KVStore.Store = "inmemory"
+	require.NoError(t, cfg.Validate(log.NewNopLogger()))
+
+	b := startModules(t, cfg)
+
+	// The tokens are listed with the admin credentials, for the tenant of the header.
+	req := httptest.NewRequest(http.MethodGet, auth.TokensPath, nil)
+	req.SetBasicAuth("admin", "secret")
+	req.Header.Set("X-Scope-OrgID", "user-1")
+	rec := httptest.NewRecorder()
+	b.Admin.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// A tenant token doesn't grant the management of the tokens.
+	req = httptest.NewRequest(http.MethodGet, auth.TokensPath, nil)
+	req.Header.Set(cfg.TenantTokens.Header, "bsi_0123456789abcdef_secret")
+	req.Header.Set("X-Scope-OrgID", "user-1")
+	rec = httptest.NewRecorder()
+	b.Admin.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// The tokens are not managed on the main listener anymore.
+	resp, err := http.Get(fmt.Sprintf("http://%s%s", b.Server.HTTPListenAddr(), auth.TokensPath))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// startModules runs the modules of the target, stopping them at the end of the test.
+func startModules(t *testing.T, cfg Config) *BlockstorageIngester {
+	// The modules register their metrics in the default registerer.
+	reg := prometheus.NewRegistry()
+	defaultRegisterer, defaultGatherer := prometheus.DefaultRegisterer, prometheus.DefaultGatherer
+	prometheus.DefaultRegisterer, prometheus.DefaultGatherer = reg, reg
+	t.Cleanup(func() {
+		prometheus.DefaultRegisterer, prometheus.DefaultGatherer = defaultRegisterer, defaultGatherer
+	})
+
+	b, err := New(cfg)
+	require.NoError(t, err)
+	b.ServiceMap, err = b.ModuleManager.InitModuleServices(cfg.Target...)
+	require.NoError(t, err)
+
+	servs := make([]services.Service, 0, len(b.ServiceMap))
+	for _, s := range b.ServiceMap {
+		servs = append(servs, s)
+	}
+	sm, err := services.NewManager(servs...)
+	require.NoError(t, err)
+
+	// The services are stopped once their start context is canceled.
+	require.NoError(t, sm.StartAsync(context.Background()))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	require.NoError(t, sm.AwaitHealthy(ctx))
+	t.Cleanup(func() {
+		sm.StopAsync()
+		require.NoError(t, sm.AwaitStopped(context.Background()))
+	})
+	return b
+}
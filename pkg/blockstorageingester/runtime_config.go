@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+
+	"objectstorage/pkg/overrides"
+	"objectstorage/pkg/ratelimit"
+	"objectstorage/pkg/tenant"
+)
+
+var (
+	errMultipleDocuments = errors.New("the provided runtime configuration contains multiple documents")
+)
+
+// runtimeConfigValues are values that can be reloaded from configuration file while the
+// block storage ingester is running. Reloading is done by runtimeconfig.Manager, which also
+// keeps the currently loaded config. These values are then pushed to the components that
+// are interested in them.
+type runtimeConfigValues struct {
+	TenantLimits   map[string]*validation.Limits  `yaml:"overrides"`
+	TenantSettings map[string]*overrides.Settings `yaml:"tenant_settings"`
+
+	// IngesterLimits override the instance limits, on top of the ones set by the flags.
+	IngesterLimits *ratelimit.InstanceLimits `yaml:"ingester_limits"`
+}
+
+func loadRuntimeConfig(r io.Reader) (interface{}, error) {
+	var overrides = &runtimeConfigValues{}
+
+	decoder := yaml.NewDecoder(r)
+	decoder.SetStrict(true)
+
+	// Decode the first document. An empty document (EOF) is OK.
+	if err := decoder.Decode(&overrides); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	// Ensure the provided YAML config is not composed of multiple documents,
+	if err := decoder.Decode(&runtimeConfigValues{}); !errors.Is(err, io.EOF) {
+		return nil, errMultipleDocuments
+	}
+
+	// The limits enforced by the Cortex compactor and ingester are overridden by the tenant
+	// settings, so the tenants with settings only get limits too.
+	for userID, settings := range overrides.TenantSettings {
+		if settings == nil {
+			continue
+		}
+
+		limits := overrides.TenantLimits[userID]
+		if limits == nil {
+			// Like the tenant overrides, the limits start from the default ones.
+			limits = &validation.Limits{}
+			if err := yaml.Unmarshal([]byte("{}"), limits); err != nil {
+				return nil, err
+			}
+			if overrides.TenantLimits == nil {
+				overrides.TenantLimits = map[string]*validation.Limits{}
+			}
+			overrides.TenantLimits[userID] = limits
+		}
+		applySettings(limits, settings)
+	}
+
+	return overrides, nil
+}
+
+// applySettings sets the blocks retention period enforced by the compactor and the
+// out-of-order time window of the TSDB to the ones of the settings, if set.
+func applySettings(limits *validation.Limits, settings *overrides.Settings) {
+	if settings.RetentionPeriod > 0 {
+		limits.CompactorBlocksRetentionPeriod = settings.RetentionPeriod
+	}
+	if settings.OutOfOrderTimeWindow > 0 {
+		limits.OutOfOrderTimeWindow = settings.OutOfOrderTimeWindow
+	}
+}
+
+// runtimeConfigProvider returns the last loaded runtime config. It's implemented by both the
+// runtimeconfig.Manager and the overrides.KVProvider.
+type runtimeConfigProvider interface {
+	GetConfig() interface{}
+}
+
+// runtimeConfigTenantLimits implements validation.TenantLimits on top of the runtime config.
+type runtimeConfigTenantLimits struct {
+	manager runtimeConfigProvider
+}
+
+func newTenantLimits(manager runtimeConfigProvider) validation.TenantLimits {
+	return &runtimeConfigTenantLimits{
+		manager: manager,
+	}
+}
+
+func (l *runtimeConfigTenantLimits) ByUserID(userID string) *validation.Limits {
+	return l.AllByUserID()[userID]
+}
+
+func (l *runtimeConfigTenantLimits) AllByUserID() map[string]*validation.Limits {
+	cfg, ok := l.manager.GetConfig().(*runtimeConfigValues)
+	if cfg != nil && ok {
+		return cfg.TenantLimits
+	}
+
+	return nil
+}
+
+// runtimeConfigTenantSettings implements overrides.TenantSettings on top of the runtime config.
+type runtimeConfigTenantSettings struct {
+	manager runtimeConfigProvider
+}
+
+func newTenantSettings(manager runtimeConfigProvider) overrides.TenantSettings {
+	return &runtimeConfigTenantSettings{
+		manager: manager,
+	}
+}
+
+func (s *runtimeConfigTenantSettings) ByUserID(userID string) *overrides.Settings {
+	return s.AllByUserID()[userID]
+}
+
+func (s *runtimeConfigTenantSettings) AllByUserID() map[string]*overrides.Settings {
+	cfg, ok := s.manager.GetConfig().(*runtimeConfigValues)
+	if cfg != nil && ok {
+		return cfg.TenantSettings
+	}
+
+	return nil
+}
+
+// defaultInstanceLimits returns the instance limits set by the flags. The inflight requests
+// limit is the lowest of the ingester one and the push one.
+func (c *Config) defaultInstanceLimits() ratelimit.InstanceLimits {
+	return ratelimit.InstanceLimits{
+		MaxInMemorySeries:       c.Ingester.DefaultLimits.MaxInMemorySeries,
+		MaxInflightPushRequests: ratelimit.MinNonZero(c.Ingester.DefaultLimits.MaxInflightPushRequests, int64(c.PushRateLimits.MaxInflightRequests)),
+		MaxInflightPushBytes:    c.PushRateLimits.MaxInflightBytes,
+		MaxIngestionRate:        c.Ingester.DefaultLimits.MaxIngestionRate,
+	}
+}
+
+// instanceLimits returns the instance limits in effect: the ingester_limits of the runtime
+// config if set, else the ones set by the flags.
+func (t *BlockstorageIngester) instanceLimits() ratelimit.InstanceLimits {
+	if current := t.currentRuntimeConfig(); current != nil && current.IngesterLimits != nil {
+		return *current.IngesterLimits
+	}
+	return t.Cfg.defaultInstanceLimits()
+}
+
+// currentRuntimeConfig returns the last loaded runtime config, or nil if none.
+func (t *BlockstorageIngester) currentRuntimeConfig() *runtimeConfigValues {
+	var current *runtimeConfigValues
+	switch {
+	case t.RuntimeConfigKV != nil:
+		current, _ = t.RuntimeConfigKV.GetConfig().(*runtimeConfigValues)
+	case t.RuntimeConfig != nil:
+		current, _ = t.RuntimeConfig.GetConfig().(*runtimeConfigValues)
+	}
+	return current
+}
+
+// tenantRuntimeConfig is the runtime config resolved for a tenant.
+type tenantRuntimeConfig struct {
+	Tenant     string             `yaml:"tenant"`
+	Overridden bool               `yaml:"overridden"`
+	Limits     validation.Limits  `yaml:"limits"`
+	Settings   overrides.Settings `yaml:"settings"`
+}
+
+// runtimeConfigHandler serves the runtime config currently applied by this instance, to
+// confirm an overrides change has been reloaded. With the tenant parameter, it serves the
+// limits and settings in effect for the tenant: its overrides if any, else the defaults.
+func (t *BlockstorageIngester) runtimeConfigHandler(w http.ResponseWriter, r *http.Request) {
+	current := t.currentRuntimeConfig()
+	if current == nil {
+		current = &runtimeConfigValues{}
+	}
+
+	tenantID := r.URL.Query().Get("tenant")
+	if tenantID == "" {
+		util.WriteYAMLResponse(w, current)
+		return
+	}
+	if err := tenant.ValidTenantID(tenantID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resolved := tenantRuntimeConfig{
+		Tenant:   tenantID,
+		Limits:   t.Cfg.LimitsConfig,
+		Settings: t.TenantOverrides.Settings(tenantID),
+	}
+	if l := current.TenantLimits[tenantID]; l != nil {
+		resolved.Limits = *l
+		resolved.Overridden = true
+	}
+	if current.TenantSettings[tenantID] != nil {
+		resolved.Overridden = true
+	}
+
+	util.WriteYAMLResponse(w, resolved)
+}
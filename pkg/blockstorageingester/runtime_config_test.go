@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+
+	"objectstorage/pkg/overrides"
+)
+
+func TestLoadRuntimeConfig_ShouldApplyTheTenantSettingsToTheLimits(t *testing.T) {
+	limits := validation.Limits{}
+	flagext.DefaultValues(&limits)
+	settings := overrides.Settings{}
+	flagext.DefaultValues(&settings)
+	settings.RetentionPeriod = model.Duration(24 * time.Hour)
+	applySettings(&limits, &settings)
+
+	validation.SetDefaultLimitsForYAMLUnmarshalling(limits)
+	overrides.SetDefaultSettingsForYAMLUnmarshalling(settings)
+	t.Cleanup(func() {
+		validation.SetDefaultLimitsForYAMLUnmarshalling(validation.Limits{})
+		overrides.SetDefaultSettingsForYAMLUnmarshalling(overrides.Settings{})
+	})
+
+	loaded, err := loadRuntimeConfig(strings.NewReader(`
+overrides:
+  user-1:
+    max_series_per_user: 10
+tenant_settings:
+  user-1:
+    out_of_order_time_window: 10m
+  user-2:
+    retention_period: 48h
+`))
+	require.NoError(t, err)
+	values := loaded.(*runtimeConfigValues)
+
+	o, err := validation.NewOverrides(limits, newTenantLimits(staticProvider{values}))
+	require.NoError(t, err)
+
+	assert.Equal(t, 10, o.MaxLocalSeriesPerUser("user-1"))
+	assert.Equal(t, model.Duration(10*time.Minute), o.OutOfOrderTimeWindow("user-1"))
+	assert.Equal(t, 24*time.Hour, o.CompactorBlocksRetentionPeriod("user-1"))
+
+	// The tenants with settings only get the default limits.
+	assert.Equal(t, limits.MaxLocalSeriesPerUser, o.MaxLocalSeriesPerUser("user-2"))
+	assert.Equal(t, 48*time.Hour, o.CompactorBlocksRetentionPeriod("user-2"))
+	assert.Equal(t, model.Duration(0), o.OutOfOrderTimeWindow("user-2"))
+
+	assert.Equal(t, 24*time.Hour, o.CompactorBlocksRetentionPeriod("user-3"))
+}
+
+type staticProvider struct {
+	values *runtimeConfigValues
+}
+
+func (p staticProvider) GetConfig() interface{} { return p.values }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+const servicesPageTemplate = `
+<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="UTF-8">
+		<title>Block Storage Ingester Services Status</title>
+	</head>
+	<body>
+		<h1>Block Storage Ingester Services Status</h1>
+		<p>Current time: {{ .Now }}</p>
+		<table border="1">
+			<thead>
+				<tr>
+					<th>Service</th>
+					<th>Status</th>
+					<th>Failure</th>
+				</tr>
+			</thead>
+			<tbody>
+				{{ range .Services }}
+				<tr>
+					<td>{{ .Name }}</td>
+					<td>{{ .Status }}</td>
+					<td>{{ .Failure }}</td>
+				</tr>
+				{{ end }}
+			</tbody>
+		</table>
+	</body>
+</html>`
+
+var servicesPage = template.Must(template.New("services").Parse(servicesPageTemplate))
+
+type renderService struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Failure string `json:"failure,omitempty"`
+}
+
+// servicesHandler serves the state of the service of each module, and the failure reason of
+// the failed ones, as HTML or as JSON if requested by the Accept header.
+func (t *BlockstorageIngester) servicesHandler(w http.ResponseWriter, r *http.Request) {
+	svcs := make([]renderService, 0, len(t.ServiceMap))
+	for mod, s := range t.ServiceMap {
+		svc := renderService{
+			Name:   mod,
+			Status: s.State().String(),
+		}
+		if s.State() == services.Failed && s.FailureCase() != nil {
+			svc.Failure = s.FailureCase().Error()
+		}
+		svcs = append(svcs, svc)
+	}
+	sort.Slice(svcs, func(i, j int) bool {
+		return svcs[i].Name < svcs[j].Name
+	})
+
+	util.RenderHTTPResponse(w, struct {
+		Now      time.Time       `json:"now"`
+		Services []renderService `json:"services"`
+	}{
+		Now:      time.Now(),
+		Services: svcs,
+	}, servicesPage, r)
+}
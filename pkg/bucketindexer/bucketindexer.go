@@ -0,0 +1,312 @@
+package bucketindexer
+
+import (
+	"context"
+	"flag"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+
+	"github.com/cortexproject/cortex/pkg/util/concurrency"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/storage/bucket"
+	bucket_tsdb "objectstorage/pkg/storage/tsdb"
+	"objectstorage/pkg/storage/tsdb/bucketindex"
+	"objectstorage/pkg/webhook"
+)
+
+var (
+	errInvalidUpdateInterval = errors.New("the bucket index update interval must be greater than 0")
+	errInvalidConcurrency    = errors.New("the bucket index update concurrency must be greater than 0")
+)
+
+// Config holds the configuration of the bucket index maintenance.
+type Config struct {
+	Enabled        bool          `yaml:"enabled"`
+	UpdateInterval time.Duration `yaml:"update_interval"`
+	Concurrency    int           `yaml:"update_concurrency"`
+}
+
+// RegisterFlags registers the bucket index flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "bucket-index.enabled", false, "True to periodically write the bucket index of each tenant, listing its blocks and deletion marks, so that the blocks can be discovered without listing the bucket.")
+	f.DurationVar(&cfg.UpdateInterval, "bucket-index.update-interval", 15*time.Minute, "How frequently the bucket index of each tenant is updated.")
+	f.IntVar(&cfg.Concurrency, "bucket-index.update-concurrency", 10, "Number of tenants whose bucket index is updated concurrently.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.UpdateInterval <= 0 {
+		return errInvalidUpdateInterval
+	}
+	if cfg.Concurrency <= 0 {
+		return errInvalidConcurrency
+	}
+	return nil
+}
+
+// LeaderRunner runs a job only on the elected leader. It's implemented by leaderelection.Elector.
+type LeaderRunner interface {
+	services.Service
+	RunIfLeader(f func(ctx context.Context) error) func(ctx context.Context) error
+}
+
+// Notifier is notified of the blocks newly marked for deletion. It's implemented by
+// webhook.Notifier.
+type Notifier interface {
+	Notify(ev webhook.Event)
+}
+
+// Indexer periodically updates the bucket index of each tenant, incrementally from the
+// previous one, and deletes the index of the tenants marked for deletion. The updates run
+// only on the leader.
+type Indexer struct {
+	services.Service
+
+	cfg         Config
+	bkt         objstore.Bucket
+	cfgProvider bucket.TenantConfigProvider
+	leader      LeaderRunner
+	notifier    Notifier
+	logger      log.Logger
+
+	// tenants are the tenants indexed by the last run, whose metrics are removed once they're
+	// not in the bucket anymore.
+	mtx     sync.Mutex
+	tenants map[string]struct{}
+
+	runs              *prometheus.CounterVec
+	updatesFailed     prometheus.Counter
+	lastSuccessfulRun prometheus.Gauge
+	lastUpdate        *prometheus.GaugeVec
+	blocks            *prometheus.GaugeVec
+	markedForDeletion *prometheus.GaugeVec
+	partialBlocks     *prometheus.GaugeVec
+}
+
+// NewIndexer makes a new Indexer. The leader may be nil, in which case the updates run on
+// every instance. The notifier may be nil.
+func NewIndexer(cfg Config, bkt objstore.Bucket, cfgProvider bucket.TenantConfigProvider, leader LeaderRunner, notifier Notifier, logger log.Logger, reg prometheus.Registerer) *Indexer {
+	i := &Indexer{
+		cfg:         cfg,
+		bkt:         bkt,
+		cfgProvider: cfgProvider,
+		leader:      leader,
+		notifier:    notifier,
+		logger:      logger,
+		tenants:     map[string]struct{}{},
+		runs: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_bucket_index_update_runs_total",
+			Help: "Total number of runs updating the bucket index of all the tenants, by outcome.",
+		}, []string{"outcome"}),
+		updatesFailed: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_bucket_index_updates_failed_total",
+			Help: "Total number of updates of the bucket index of a tenant failed.",
+		}),
+		lastSuccessfulRun: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_bucket_index_last_successful_update_run_timestamp_seconds",
+			Help: "Unix timestamp of the last run having updated the bucket index of all the tenants.",
+		}),
+		lastUpdate: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_bucket_index_last_successful_update_timestamp_seconds",
+			Help: "Unix timestamp of the last successful update of the bucket index of the tenant.",
+		}, []string{"user"}),
+		blocks: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_bucket_blocks_count",
+			Help: "Number of blocks of the tenant in the bucket, including the ones marked for deletion.",
+		}, []string{"user"}),
+		markedForDeletion: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_bucket_blocks_marked_for_deletion_count",
+			Help: "Number of blocks of the tenant marked for deletion in the bucket.",
+		}, []string{"user"}),
+		partialBlocks: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_bucket_blocks_partials_count",
+			Help: "Number of partial blocks of the tenant in the bucket, whose meta is missing or corrupted.",
+		}, []string{"user"}),
+	}
+	i.Service = services.NewTimerService(cfg.UpdateInterval, i.starting, i.iteration, i.stopping)
+	return i
+}
+
+func (i *Indexer) starting(ctx context.Context) error {
+	if i.leader != nil {
+		if err := services.StartAndAwaitRunning(ctx, i.leader); err != nil {
+			return errors.Wrap(err, "start bucket index leader election")
+		}
+	}
+	return nil
+}
+
+func (i *Indexer) stopping(_ error) error {
+	if i.leader != nil {
+		return services.StopAndAwaitTerminated(context.Background(), i.leader)
+	}
+	return nil
+}
+
+func (i *Indexer) iteration(ctx context.Context) error {
+	update := i.updateAll
+	if i.leader != nil {
+		update = i.leader.RunIfLeader(i.updateAll)
+	}
+	if err := update(ctx); err != nil {
+		i.runs.WithLabelValues("failed").Inc()
+		level.Warn(i.logger).Log("msg", "failed to update the bucket indexes", "err", err)
+		return nil
+	}
+	i.runs.WithLabelValues("success").Inc()
+	i.lastSuccessfulRun.SetToCurrentTime()
+	return nil
+}
+
+// updateAll updates the index of all the tenants in the bucket. It fails if the index of any
+// tenant failed to be updated, the others being updated anyway.
+func (i *Indexer) updateAll(ctx context.Context) error {
+	users, markedForDeletion, err := bucket_tsdb.NewUsersScanner(i.bkt, bucket_tsdb.AllUsers, i.logger).ScanUsers(ctx)
+	if err != nil {
+		return errors.Wrap(err, "scan users")
+	}
+
+	for _, userID := range markedForDeletion {
+		if err := bucketindex.DeleteIndex(ctx, i.bkt, userID, i.cfgProvider); err != nil {
+			level.Warn(i.logger).Log("msg", "failed to delete the bucket index of the tenant marked for deletion", "user", userID, "err", err)
+		}
+	}
+	i.removeDeletedTenants(users)
+
+	return concurrency.ForEachUser(ctx, users, i.cfg.Concurrency, i.updateTenant)
+}
+
+// updateTenant updates the index of the tenant from the previous one, rebuilding it from
+// scratch if missing or corrupted.
+func (i *Indexer) updateTenant(ctx context.Context, userID string) error {
+	old, err := bucketindex.ReadIndex(ctx, i.bkt, userID, i.cfgProvider, i.logger)
+	switch {
+	case errors.Is(err, bucketindex.ErrIndexNotFound):
+		level.Info(i.logger).Log("msg", "bucket index not found, building it", "user", userID)
+	case errors.Is(err, bucketindex.ErrIndexCorrupted):
+		level.Warn(i.logger).Log("msg", "bucket index corrupted, rebuilding it", "user", userID)
+	case err != nil:
+		i.updatesFailed.Inc()
+		return errors.Wrapf(err, "read bucket index of tenant %s", userID)
+	}
+
+	// The index only looks up the deletion marks in the global markers location, where the
+	// marks written before the index was first built may be missing.
+	if old == nil {
+		if err := bucketindex.MigrateBlockDeletionMarksToGlobalLocation(ctx, i.bkt, userID, i.cfgProvider); err != nil {
+			i.updatesFailed.Inc()
+			return errors.Wrapf(err, "migrate block deletion marks of tenant %s", userID)
+		}
+	}
+
+	idx, partials, _, err := bucketindex.NewUpdater(i.bkt, userID, i.cfgProvider, i.logger).UpdateIndex(ctx, old)
+	if err != nil {
+		i.updatesFailed.Inc()
+		return errors.Wrapf(err, "update bucket index of tenant %s", userID)
+	}
+	if err := bucketindex.WriteIndex(ctx, i.bkt, userID, i.cfgProvider, idx); err != nil {
+		i.updatesFailed.Inc()
+		return errors.Wrapf(err, "write bucket index of tenant %s", userID)
+	}
+
+	i.lastUpdate.WithLabelValues(userID).SetToCurrentTime()
+	i.blocks.WithLabelValues(userID).Set(float64(len(idx.Blocks)))
+	i.markedForDeletion.WithLabelValues(userID).Set(float64(len(idx.BlockDeletionMarks)))
+	i.partialBlocks.WithLabelValues(userID).Set(float64(len(partials)))
+
+	if i.notifier != nil && old != nil {
+		i.notifyMarkedForDeletion(ctx, userID, old, idx)
+	}
+	return nil
+}
+
+// notifyMarkedForDeletion notifies the blocks marked for deletion since the previous index.
+// The marks found while the index is first built aren't notified, since they can't be told
+// apart from the old ones.
+func (i *Indexer) notifyMarkedForDeletion(ctx context.Context, userID string, old, idx *bucketindex.Index) {
+	marked := make(map[ulid.ULID]struct{}, len(old.BlockDeletionMarks))
+	for _, m := range old.BlockDeletionMarks {
+		marked[m.ID] = struct{}{}
+	}
+
+	userBkt := bucket.NewUserBucketClient(userID, i.bkt, i.cfgProvider)
+	for _, m := range idx.BlockDeletionMarks {
+		if _, ok := marked[m.ID]; ok {
+			continue
+		}
+
+		ev := webhook.Event{
+			Event:  webhook.EventBlockMarkedForDeletion,
+			Time:   m.GetDeletionTime(),
+			Tenant: userID,
+			ULID:   m.ID,
+		}
+		for _, b := range idx.Blocks {
+			if b.ID == m.ID {
+				ev.MinTime, ev.MaxTime = b.MinTime, b.MaxTime
+				break
+			}
+		}
+
+		// The size is only known from the files listed in the meta of the block.
+		size, err := blockSize(ctx, userBkt, m.ID)
+		if err != nil {
+			level.Warn(i.logger).Log("msg", "failed to read the size of the block marked for deletion", "user", userID, "block", m.ID.String(), "err", err)
+		}
+		ev.SizeBytes = size
+		i.notifier.Notify(ev)
+	}
+}
+
+// blockSize returns the size of the files listed in the meta of the block.
+func blockSize(ctx context.Context, bkt objstore.Bucket, id ulid.ULID) (int64, error) {
+	r, err := bkt.Get(ctx, path.Join(id.String(), metadata.MetaFilename))
+	if err != nil {
+		return 0, err
+	}
+	meta, err := metadata.Read(r)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, f := range meta.Thanos.Files {
+		size += f.SizeBytes
+	}
+	return size, nil
+}
+
+// removeDeletedTenants removes the metrics of the tenants not in the bucket anymore.
+func (i *Indexer) removeDeletedTenants(users []string) {
+	current := make(map[string]struct{}, len(users))
+	for _, userID := range users {
+		current[userID] = struct{}{}
+	}
+
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	for userID := range i.tenants {
+		if _, ok := current[userID]; ok {
+			continue
+		}
+		i.lastUpdate.DeleteLabelValues(userID)
+		i.blocks.DeleteLabelValues(userID)
+		i.markedForDeletion.DeleteLabelValues(userID)
+		i.partialBlocks.DeleteLabelValues(userID)
+	}
+	i.tenants = current
+}
@@ -0,0 +1,163 @@
+package bucketindexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	cortex_testutil "github.com/cortexproject/cortex/pkg/storage/tsdb/testutil"
+
+	bucket_tsdb "objectstorage/pkg/storage/tsdb"
+	"objectstorage/pkg/storage/tsdb/bucketindex"
+	"objectstorage/pkg/webhook"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		cfg      Config
+		expected error
+	}{
+		"disabled": {
+			cfg:      Config{},
+			expected: nil,
+		},
+		"enabled": {
+			cfg:      Config{Enabled: true, UpdateInterval: time.Minute, Concurrency: 1},
+			expected: nil,
+		},
+		"zero update interval": {
+			cfg:      Config{Enabled: true, Concurrency: 1},
+			expected: errInvalidUpdateInterval,
+		},
+		"zero concurrency": {
+			cfg:      Config{Enabled: true, UpdateInterval: time.Minute},
+			expected: errInvalidConcurrency,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.cfg.Validate())
+		})
+	}
+}
+
+func TestIndexer_UpdatesTheIndexOfEachTenant(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+
+	block1 := cortex_testutil.MockStorageBlock(t, bkt, "user-1", 10, 20)
+	block2 := cortex_testutil.MockStorageBlock(t, bkt, "user-1", 20, 30)
+	cortex_testutil.MockStorageDeletionMark(t, bkt, "user-1", block2)
+	cortex_testutil.MockStorageBlock(t, bkt, "user-2", 10, 20)
+	// Partial block, without meta.
+	require.NoError(t, bkt.Upload(ctx, path.Join("user-2", ulid.MustNew(1, nil).String(), "index"), bytes.NewReader(nil)))
+
+	reg := prometheus.NewPedanticRegistry()
+	i := NewIndexer(Config{Enabled: true, UpdateInterval: time.Minute, Concurrency: 2}, bkt, nil, nil, nil, log.NewNopLogger(), reg)
+	require.NoError(t, i.iteration(ctx))
+
+	idx, err := bucketindex.ReadIndex(ctx, bkt, "user-1", nil, log.NewNopLogger())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []ulid.ULID{block1.ULID, block2.ULID}, idx.Blocks.GetULIDs())
+	assert.Equal(t, []ulid.ULID{block2.ULID}, idx.BlockDeletionMarks.GetULIDs())
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(i.blocks.WithLabelValues("user-1")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(i.markedForDeletion.WithLabelValues("user-1")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(i.blocks.WithLabelValues("user-2")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(i.partialBlocks.WithLabelValues("user-2")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(i.runs.WithLabelValues("success")))
+
+	// The next update is incremental, and picks up the new blocks.
+	block3 := cortex_testutil.MockStorageBlock(t, bkt, "user-1", 30, 40)
+	require.NoError(t, i.iteration(ctx))
+
+	idx, err = bucketindex.ReadIndex(ctx, bkt, "user-1", nil, log.NewNopLogger())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []ulid.ULID{block1.ULID, block2.ULID, block3.ULID}, idx.Blocks.GetULIDs())
+}
+
+func TestIndexer_DeletesTheIndexOfDeletedTenants(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	cortex_testutil.MockStorageBlock(t, bkt, "user-1", 10, 20)
+
+	i := NewIndexer(Config{Enabled: true, UpdateInterval: time.Minute, Concurrency: 1}, bkt, nil, nil, nil, log.NewNopLogger(), nil)
+	require.NoError(t, i.iteration(ctx))
+	_, err := bucketindex.ReadIndex(ctx, bkt, "user-1", nil, log.NewNopLogger())
+	require.NoError(t, err)
+
+	require.NoError(t, bucket_tsdb.WriteTenantDeletionMark(ctx, bkt, "user-1", nil, bucket_tsdb.NewTenantDeletionMark(time.Now())))
+	require.NoError(t, i.iteration(ctx))
+	_, err = bucketindex.ReadIndex(ctx, bkt, "user-1", nil, log.NewNopLogger())
+	assert.ErrorIs(t, err, bucketindex.ErrIndexNotFound)
+
+	// The metrics of the deleted tenant are removed.
+	assert.Equal(t, 0, testutil.CollectAndCount(i.blocks))
+}
+
+func TestIndexer_RebuildsACorruptedIndex(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	block := cortex_testutil.MockStorageBlock(t, bkt, "user-1", 10, 20)
+	require.NoError(t, bkt.Upload(ctx, path.Join("user-1", bucketindex.IndexCompressedFilename), bytes.NewReader([]byte("corrupted"))))
+
+	i := NewIndexer(Config{Enabled: true, UpdateInterval: time.Minute, Concurrency: 1}, bkt, nil, nil, nil, log.NewNopLogger(), nil)
+	require.NoError(t, i.updateTenant(ctx, "user-1"))
+
+	idx, err := bucketindex.ReadIndex(ctx, bkt, "user-1", nil, log.NewNopLogger())
+	require.NoError(t, err)
+	assert.Equal(t, []ulid.ULID{block.ULID}, idx.Blocks.GetULIDs())
+}
+
+type notifierMock struct {
+	events []webhook.Event
+}
+
+func (m *notifierMock) Notify(ev webhook.Event) {
+	m.events = append(m.events, ev)
+}
+
+func TestIndexer_NotifiesTheBlocksMarkedForDeletion(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+
+	block1 := cortex_testutil.MockStorageBlock(t, bkt, "user-1", 10, 20)
+	block2 := cortex_testutil.MockStorageBlock(t, bkt, "user-1", 20, 30)
+	cortex_testutil.MockStorageDeletionMark(t, bkt, "user-1", block2)
+
+	notifier := &notifierMock{}
+	i := NewIndexer(Config{Enabled: true, UpdateInterval: time.Minute, Concurrency: 1}, bkt, nil, nil, notifier, log.NewNopLogger(), nil)
+
+	// The marks found while the index is first built aren't notified.
+	require.NoError(t, i.iteration(ctx))
+	assert.Empty(t, notifier.events)
+
+	// The compactor writes the mark in both the block and the global markers locations.
+	mark := cortex_testutil.MockStorageDeletionMark(t, bkt, "user-1", block1)
+	data, err := json.Marshal(mark)
+	require.NoError(t, err)
+	require.NoError(t, bkt.Upload(ctx, path.Join("user-1", bucketindex.BlockDeletionMarkFilepath(block1.ULID)), bytes.NewReader(data)))
+
+	require.NoError(t, i.iteration(ctx))
+	require.NoError(t, i.iteration(ctx))
+	assert.Equal(t, []webhook.Event{{
+		Event:   webhook.EventBlockMarkedForDeletion,
+		Time:    time.Unix(mark.DeletionTime, 0),
+		Tenant:  "user-1",
+		ULID:    block1.ULID,
+		MinTime: 10,
+		MaxTime: 20,
+	}}, notifier.events)
+}
@@ -0,0 +1,219 @@
+package cardinality
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+)
+
+// Paths of the cardinality API.
+const (
+	LabelNamesPath  = "/api/v1/cardinality/label_names"
+	MetricNamesPath = "/api/v1/cardinality/metric_names"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 500
+)
+
+var (
+	errInvalidLimit    = errors.Errorf("limit must be a number between 1 and %d", maxLimit)
+	errInvalidLookback = errors.New("the cardinality lookback must be greater than 0")
+)
+
+// Config holds the configuration of the cardinality API.
+type Config struct {
+	Lookback time.Duration `yaml:"lookback"`
+}
+
+// RegisterFlags registers the cardinality API flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.DurationVar(&cfg.Lookback, "cardinality.lookback", 2*time.Hour, "How far back series are taken into account by the cardinality API. Should match the TSDB head, so that only in-memory data is analysed.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if cfg.Lookback <= 0 {
+		return errInvalidLookback
+	}
+	return nil
+}
+
+// Source returns the series of the tenant in the context. It's implemented by the ingester.
+type Source interface {
+	LabelNames(ctx context.Context, req *client.LabelNamesRequest) (*client.LabelNamesResponse, error)
+	LabelValues(ctx context.Context, req *client.LabelValuesRequest) (*client.LabelValuesResponse, error)
+	MetricsForLabelMatchers(ctx context.Context, req *client.MetricsForLabelMatchersRequest) (*client.MetricsForLabelMatchersResponse, error)
+}
+
+// LabelNameCardinality is the number of values of a label name.
+type LabelNameCardinality struct {
+	LabelName        string `json:"label_name"`
+	LabelValuesCount int    `json:"label_values_count"`
+}
+
+// LabelNamesResponse is the response of the label names cardinality API.
+type LabelNamesResponse struct {
+	LabelNamesCount int                    `json:"label_names_count"`
+	LabelNames      []LabelNameCardinality `json:"label_names"`
+}
+
+// MetricNameCardinality is the number of series of a metric name.
+type MetricNameCardinality struct {
+	MetricName  string `json:"metric_name"`
+	SeriesCount int    `json:"series_count"`
+}
+
+// MetricNamesResponse is the response of the metric names cardinality API.
+type MetricNamesResponse struct {
+	MetricNamesCount int                     `json:"metric_names_count"`
+	MetricNames      []MetricNameCardinality `json:"metric_names"`
+}
+
+// API serves the cardinality of the tenant series, so that users can find the labels and
+// metrics responsible for high cardinality.
+type API struct {
+	cfg    Config
+	source Source
+	logger log.Logger
+}
+
+// NewAPI makes a new API.
+func NewAPI(cfg Config, source Source, logger log.Logger) *API {
+	return &API{cfg: cfg, source: source, logger: logger}
+}
+
+// LabelNames returns the label names with the highest number of values.
+func (a *API) LabelNames(ctx context.Context, limit int) (*LabelNamesResponse, error) {
+	start, end := a.timeRange()
+
+	names, err := a.source.LabelNames(ctx, &client.LabelNamesRequest{StartTimestampMs: start, EndTimestampMs: end})
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch label names")
+	}
+
+	resp := &LabelNamesResponse{LabelNamesCount: len(names.LabelNames)}
+	for _, name := range names.LabelNames {
+		values, err := a.source.LabelValues(ctx, &client.LabelValuesRequest{LabelName: name, StartTimestampMs: start, EndTimestampMs: end})
+		if err != nil {
+			return nil, errors.Wrapf(err, "fetch values of label %s", name)
+		}
+		resp.LabelNames = append(resp.LabelNames, LabelNameCardinality{LabelName: name, LabelValuesCount: len(values.LabelValues)})
+	}
+
+	sort.Slice(resp.LabelNames, func(i, j int) bool {
+		if resp.LabelNames[i].LabelValuesCount != resp.LabelNames[j].LabelValuesCount {
+			return resp.LabelNames[i].LabelValuesCount > resp.LabelNames[j].LabelValuesCount
+		}
+		return resp.LabelNames[i].LabelName < resp.LabelNames[j].LabelName
+	})
+	if len(resp.LabelNames) > limit {
+		resp.LabelNames = resp.LabelNames[:limit]
+	}
+
+	return resp, nil
+}
+
+// MetricNames returns the metric names with the highest number of series.
+func (a *API) MetricNames(ctx context.Context, limit int) (*MetricNamesResponse, error) {
+	start, end := a.timeRange()
+
+	names, err := a.source.LabelValues(ctx, &client.LabelValuesRequest{LabelName: labels.MetricName, StartTimestampMs: start, EndTimestampMs: end})
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch metric names")
+	}
+
+	resp := &MetricNamesResponse{MetricNamesCount: len(names.LabelValues)}
+	for _, name := range names.LabelValues {
+		series, err := a.source.MetricsForLabelMatchers(ctx, &client.MetricsForLabelMatchersRequest{
+			StartTimestampMs: start,
+			EndTimestampMs:   end,
+			MatchersSet: []*client.LabelMatchers{{Matchers: []*client.LabelMatcher{
+				{Type: client.EQUAL, Name: labels.MetricName, Value: name},
+			}}},
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "fetch series of metric %s", name)
+		}
+		resp.MetricNames = append(resp.MetricNames, MetricNameCardinality{MetricName: name, SeriesCount: len(series.Metric)})
+	}
+
+	sort.Slice(resp.MetricNames, func(i, j int) bool {
+		if resp.MetricNames[i].SeriesCount != resp.MetricNames[j].SeriesCount {
+			return resp.MetricNames[i].SeriesCount > resp.MetricNames[j].SeriesCount
+		}
+		return resp.MetricNames[i].MetricName < resp.MetricNames[j].MetricName
+	})
+	if len(resp.MetricNames) > limit {
+		resp.MetricNames = resp.MetricNames[:limit]
+	}
+
+	return resp, nil
+}
+
+func (a *API) timeRange() (int64, int64) {
+	now := time.Now()
+	return now.Add(-a.cfg.Lookback).UnixMilli(), now.UnixMilli()
+}
+
+// LabelNamesHandler serves the label names cardinality.
+func (a *API) LabelNamesHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseLimit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := a.LabelNames(r.Context(), limit)
+	a.writeResponse(w, resp, err)
+}
+
+// MetricNamesHandler serves the metric names cardinality.
+func (a *API) MetricNamesHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseLimit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := a.MetricNames(r.Context(), limit)
+	a.writeResponse(w, resp, err)
+}
+
+func (a *API) writeResponse(w http.ResponseWriter, resp interface{}, err error) {
+	if err != nil {
+		level.Error(a.logger).Log("msg", "failed to compute cardinality", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		level.Error(a.logger).Log("msg", "failed to encode cardinality response", "err", err)
+	}
+}
+
+func parseLimit(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxLimit {
+		return 0, errInvalidLimit
+	}
+	return limit, nil
+}
@@ -0,0 +1,143 @@
+package cardinality
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+)
+
+type sourceMock struct {
+	series []labels.Labels
+}
+
+func (m *sourceMock) LabelNames(context.Context, *client.LabelNamesRequest) (*client.LabelNamesResponse, error) {
+	names := map[string]struct{}{}
+	for _, s := range m.series {
+		for _, l := range s {
+			names[l.Name] = struct{}{}
+		}
+	}
+
+	resp := &client.LabelNamesResponse{}
+	for name := range names {
+		resp.LabelNames = append(resp.LabelNames, name)
+	}
+	return resp, nil
+}
+
+func (m *sourceMock) LabelValues(_ context.Context, req *client.LabelValuesRequest) (*client.LabelValuesResponse, error) {
+	values := map[string]struct{}{}
+	for _, s := range m.series {
+		if v := s.Get(req.LabelName); v != "" {
+			values[v] = struct{}{}
+		}
+	}
+
+	resp := &client.LabelValuesResponse{}
+	for value := range values {
+		resp.LabelValues = append(resp.LabelValues, value)
+	}
+	return resp, nil
+}
+
+func (m *sourceMock) MetricsForLabelMatchers(_ context.Context, req *client.MetricsForLabelMatchersRequest) (*client.MetricsForLabelMatchersResponse, error) {
+	matcher := req.MatchersSet[0].Matchers[0]
+
+	resp := &client.MetricsForLabelMatchersResponse{}
+	for _, s := range m.series {
+		if s.Get(matcher.Name) == matcher.Value {
+			resp.Metric = append(resp.Metric, &cortexpb.Metric{Labels: cortexpb.FromLabelsToLabelAdapters(s)})
+		}
+	}
+	return resp, nil
+}
+
+func newTestAPI() *API {
+	return NewAPI(Config{Lookback: time.Hour}, &sourceMock{series: []labels.Labels{
+		labels.FromStrings(labels.MetricName, "up", "job", "a", "instance", "1"),
+		labels.FromStrings(labels.MetricName, "up", "job", "a", "instance", "2"),
+		labels.FromStrings(labels.MetricName, "up", "job", "b", "instance", "3"),
+		labels.FromStrings(labels.MetricName, "requests_total", "job", "a", "instance", "1"),
+	}}, log.NewNopLogger())
+}
+
+func TestAPI_LabelNames(t *testing.T) {
+	resp, err := newTestAPI().LabelNames(context.Background(), 2)
+	require.NoError(t, err)
+
+	assert.Equal(t, &LabelNamesResponse{
+		LabelNamesCount: 3,
+		LabelNames: []LabelNameCardinality{
+			{LabelName: "instance", LabelValuesCount: 3},
+			{LabelName: labels.MetricName, LabelValuesCount: 2},
+		},
+	}, resp)
+}
+
+func TestAPI_MetricNames(t *testing.T) {
+	resp, err := newTestAPI().MetricNames(context.Background(), 10)
+	require.NoError(t, err)
+
+	assert.Equal(t, &MetricNamesResponse{
+		MetricNamesCount: 2,
+		MetricNames: []MetricNameCardinality{
+			{MetricName: "up", SeriesCount: 3},
+			{MetricName: "requests_total", SeriesCount: 1},
+		},
+	}, resp)
+}
+
+func TestAPI_Handlers(t *testing.T) {
+	a := newTestAPI()
+
+	tests := map[string]struct {
+		handler      http.HandlerFunc
+		url          string
+		expectedCode int
+	}{
+		"label names with default limit": {
+			handler:      a.LabelNamesHandler,
+			url:          LabelNamesPath,
+			expectedCode: http.StatusOK,
+		},
+		"metric names with limit": {
+			handler:      a.MetricNamesHandler,
+			url:          MetricNamesPath + "?limit=1",
+			expectedCode: http.StatusOK,
+		},
+		"invalid limit": {
+			handler:      a.MetricNamesHandler,
+			url:          MetricNamesPath + "?limit=0",
+			expectedCode: http.StatusBadRequest,
+		},
+		"limit too high": {
+			handler:      a.LabelNamesHandler,
+			url:          LabelNamesPath + "?limit=100000",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tc.handler(rec, httptest.NewRequest(http.MethodGet, tc.url, nil))
+			require.Equal(t, tc.expectedCode, rec.Code)
+
+			if tc.expectedCode == http.StatusOK {
+				assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+				assert.True(t, json.Valid(rec.Body.Bytes()))
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package costattribution
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/thanos/pkg/shipper"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/push"
+	"objectstorage/pkg/tenant"
+)
+
+// Config holds the configuration of the cost attribution.
+type Config struct {
+	Enabled             bool          `yaml:"enabled"`
+	ShipperScanInterval time.Duration `yaml:"shipper_scan_interval"`
+}
+
+// RegisterFlags registers the cost attribution flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "cost-attribution.enabled", false, "True to export per-tenant usage metrics for chargeback: ingested samples and bytes, active series and shipped block bytes. Metrics are labeled with the tenant cost_center override.")
+	f.DurationVar(&cfg.ShipperScanInterval, "cost-attribution.shipper-scan-interval", time.Minute, "How frequently the shipper meta files are scanned to account the bytes of newly shipped blocks.")
+}
+
+// Limits is the subset of the per-tenant overrides used by the cost attribution. It's
+// implemented by overrides.Overrides.
+type Limits interface {
+	CostCenter(userID string) string
+}
+
+// ActiveSeries returns the number of active series of a tenant.
+type ActiveSeries interface {
+	ActiveSeries(userID string) int
+}
+
+// Tracker accounts the usage of each tenant.
+type Tracker struct {
+	services.Service
+
+	cfg          Config
+	tsdbDir      string
+	limits       Limits
+	activeSeries ActiveSeries
+	logger       log.Logger
+
+	mtx       sync.Mutex
+	users     map[string]struct{}
+	accounted map[ulid.ULID]struct{}
+
+	ingestedSamples   *prometheus.CounterVec
+	ingestedBytes     *prometheus.CounterVec
+	shippedBlockBytes *prometheus.CounterVec
+	activeSeriesDesc  *prometheus.Desc
+}
+
+// NewTracker makes a new Tracker. The activeSeries may be nil, in which case the active
+// series are not exported.
+func NewTracker(cfg Config, tsdbDir string, limits Limits, activeSeries ActiveSeries, logger log.Logger, reg prometheus.Registerer) *Tracker {
+	t := &Tracker{
+		cfg:          cfg,
+		tsdbDir:      tsdbDir,
+		limits:       limits,
+		activeSeries: activeSeries,
+		logger:       logger,
+		users:        map[string]struct{}{},
+		accounted:    map[ulid.ULID]struct{}{},
+		ingestedSamples: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_cost_attribution_ingested_samples_total",
+			Help: "Total number of samples ingested per tenant.",
+		}, []string{"user", "cost_center"}),
+		ingestedBytes: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_cost_attribution_ingested_bytes_total",
+			Help: "Total number of uncompressed protobuf bytes ingested per tenant.",
+		}, []string{"user", "cost_center"}),
+		shippedBlockBytes: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_cost_attribution_shipped_block_bytes_total",
+			Help: "Total number of block bytes shipped to the storage per tenant.",
+		}, []string{"user", "cost_center"}),
+		activeSeriesDesc: prometheus.NewDesc(
+			"cortex_cost_attribution_active_series",
+			"Number of active series per tenant.",
+			[]string{"user", "cost_center"}, nil),
+	}
+
+	if reg != nil && activeSeries != nil {
+		reg.MustRegister(t)
+	}
+
+	t.Service = services.NewTimerService(cfg.ShipperScanInterval, t.starting, t.scan, nil)
+	return t
+}
+
+func (t *Tracker) starting(_ context.Context) error {
+	// Blocks shipped before the startup have already been accounted by the previous process.
+	for userID, blocks := range t.shippedBlocks() {
+		t.addUser(userID)
+		for _, id := range blocks {
+			t.accounted[id] = struct{}{}
+		}
+	}
+	return nil
+}
+
+func (t *Tracker) scan(_ context.Context) error {
+	for userID, blocks := range t.shippedBlocks() {
+		t.addUser(userID)
+
+		for _, id := range blocks {
+			if _, ok := t.accounted[id]; ok {
+				continue
+			}
+
+			size, err := dirSize(filepath.Join(t.tsdbDir, userID, id.String()))
+			if err != nil {
+				level.Warn(t.logger).Log("msg", "unable to compute the size of a shipped block", "tenant", userID, "block", id.String(), "err", err)
+				continue
+			}
+
+			t.accounted[id] = struct{}{}
+			t.shippedBlockBytes.WithLabelValues(userID, t.limits.CostCenter(userID)).Add(float64(size))
+		}
+	}
+
+	return nil
+}
+
+// shippedBlocks returns the blocks listed in the shipper meta file of each tenant.
+func (t *Tracker) shippedBlocks() map[string][]ulid.ULID {
+	entries, err := os.ReadDir(t.tsdbDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			level.Warn(t.logger).Log("msg", "unable to list tenants TSDB", "err", err)
+		}
+		return nil
+	}
+
+	out := map[string][]ulid.ULID{}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		meta, err := shipper.ReadMetaFile(filepath.Join(t.tsdbDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		out[entry.Name()] = meta.Uploaded
+	}
+	return out
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+func (t *Tracker) addUser(userID string) {
+	t.mtx.Lock()
+	t.users[userID] = struct{}{}
+	t.mtx.Unlock()
+}
+
+// PushMiddleware returns a push.Middleware accounting the ingested samples and bytes. It
+// should be the innermost middleware, to account only what is actually ingested. The request
+// is measured before being pushed, since the ingester returns its series to the pools once
+// appended.
+func (t *Tracker) PushMiddleware() push.Middleware {
+	return func(next push.Func) push.Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			samples := 0
+			for _, ts := range req.Timeseries {
+				samples += len(ts.Samples)
+			}
+			size := req.Size()
+
+			resp, err := next(ctx, req)
+			if err != nil {
+				return resp, err
+			}
+
+			userID, err := tenant.TenantID(ctx)
+			if err != nil {
+				return resp, nil
+			}
+
+			costCenter := t.limits.CostCenter(userID)
+			t.ingestedSamples.WithLabelValues(userID, costCenter).Add(float64(samples))
+			t.ingestedBytes.WithLabelValues(userID, costCenter).Add(float64(size))
+			t.addUser(userID)
+			return resp, nil
+		}
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (t *Tracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.activeSeriesDesc
+}
+
+// Collect implements prometheus.Collector.
+func (t *Tracker) Collect(ch chan<- prometheus.Metric) {
+	t.mtx.Lock()
+	users := make([]string, 0, len(t.users))
+	for userID := range t.users {
+		users = append(users, userID)
+	}
+	t.mtx.Unlock()
+
+	for _, userID := range users {
+		ch <- prometheus.MustNewConstMetric(t.activeSeriesDesc, prometheus.GaugeValue, float64(t.activeSeries.ActiveSeries(userID)), userID, t.limits.CostCenter(userID))
+	}
+}
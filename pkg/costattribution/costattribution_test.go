@@ -0,0 +1,97 @@
+package costattribution
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/shipper"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+type limitsMock map[string]string
+
+func (m limitsMock) CostCenter(userID string) string { return m[userID] }
+
+type activeSeriesMock map[string]int
+
+func (m activeSeriesMock) ActiveSeries(userID string) int { return m[userID] }
+
+func TestTracker(t *testing.T) {
+	tsdbDir := t.TempDir()
+	reg := prometheus.NewPedanticRegistry()
+	limits := limitsMock{"user-1": "team-a"}
+
+	oldBlock := ulid.MustNew(1, nil)
+	newBlock := ulid.MustNew(2, nil)
+	writeFile(t, filepath.Join(tsdbDir, "user-1", oldBlock.String(), "index"), "old")
+	writeFile(t, filepath.Join(tsdbDir, "user-1", newBlock.String(), "index"), "0123456789")
+	writeFile(t, filepath.Join(tsdbDir, "user-1", newBlock.String(), "chunks", "000001"), "01234")
+	writeShipperMeta(t, filepath.Join(tsdbDir, "user-1"), oldBlock)
+
+	tracker := NewTracker(Config{Enabled: true, ShipperScanInterval: time.Minute}, tsdbDir, limits, activeSeriesMock{"user-1": 3}, log.NewNopLogger(), reg)
+	require.NoError(t, tracker.starting(context.Background()))
+
+	// Only the blocks shipped after the startup are accounted.
+	writeShipperMeta(t, filepath.Join(tsdbDir, "user-1"), oldBlock, newBlock)
+	require.NoError(t, tracker.scan(context.Background()))
+	require.NoError(t, tracker.scan(context.Background()))
+
+	req := &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+		Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: "up"}},
+		Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}, {Value: 2, TimestampMs: 2}},
+	}}}}
+	size := req.Size()
+	f := tracker.PushMiddleware()(func(_ context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		// The ingester returns the series to the pools once appended.
+		cortexpb.ReuseSlice(req.Timeseries)
+		return &cortexpb.WriteResponse{}, nil
+	})
+	_, err := f(user.InjectOrgID(context.Background(), "user-2"), req)
+	require.NoError(t, err)
+	require.Equal(t, float64(size), testutil.ToFloat64(tracker.ingestedBytes.WithLabelValues("user-2", "")))
+
+	// The failed pushes aren't accounted.
+	failing := tracker.PushMiddleware()(func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		return nil, errors.New("failed")
+	})
+	_, err = failing(user.InjectOrgID(context.Background(), "user-2"), &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+		Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}},
+	}}}})
+	require.Error(t, err)
+
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_cost_attribution_active_series Number of active series per tenant.
+		# TYPE cortex_cost_attribution_active_series gauge
+		cortex_cost_attribution_active_series{cost_center="team-a",user="user-1"} 3
+		cortex_cost_attribution_active_series{cost_center="",user="user-2"} 0
+
+		# HELP cortex_cost_attribution_ingested_samples_total Total number of samples ingested per tenant.
+		# TYPE cortex_cost_attribution_ingested_samples_total counter
+		cortex_cost_attribution_ingested_samples_total{cost_center="",user="user-2"} 2
+
+		# HELP cortex_cost_attribution_shipped_block_bytes_total Total number of block bytes shipped to the storage per tenant.
+		# TYPE cortex_cost_attribution_shipped_block_bytes_total counter
+		cortex_cost_attribution_shipped_block_bytes_total{cost_center="team-a",user="user-1"} 15
+	`), "cortex_cost_attribution_active_series", "cortex_cost_attribution_ingested_samples_total", "cortex_cost_attribution_shipped_block_bytes_total"))
+}
+
+func writeShipperMeta(t *testing.T, dir string, uploaded ...ulid.ULID) {
+	require.NoError(t, shipper.WriteMetaFile(log.NewNopLogger(), dir, &shipper.Meta{Version: shipper.MetaVersion1, Uploaded: uploaded}))
+}
+
+func writeFile(t *testing.T, p, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(p), os.ModePerm))
+	require.NoError(t, os.WriteFile(p, []byte(content), 0o666))
+}
@@ -0,0 +1,295 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+// Backends of the dead-letter sink.
+const (
+	BackendFile   = "file"
+	BackendKafka  = "kafka"
+	BackendBucket = "bucket"
+)
+
+var (
+	supportedBackends = []string{BackendFile, BackendKafka, BackendBucket}
+
+	errUnsupportedBackend  = errors.New("unsupported dead-letter backend")
+	errMissingFile         = errors.New("the dead-letter file has not been configured")
+	errMissingKafkaAddress = errors.New("the dead-letter Kafka address has not been configured")
+	errMissingKafkaTopic   = errors.New("the dead-letter Kafka topic has not been configured")
+	errMissingBucketPrefix = errors.New("the dead-letter bucket prefix has not been configured")
+	errInvalidQueueSize    = errors.New("the dead-letter queue size must be greater than 0")
+	errInvalidFlushPeriod  = errors.New("the dead-letter flush period must be greater than 0")
+)
+
+// Config holds the configuration of the dead-letter sink.
+type Config struct {
+	Backend      string        `yaml:"backend"`
+	File         string        `yaml:"file"`
+	KafkaAddress string        `yaml:"kafka_address"`
+	KafkaTopic   string        `yaml:"kafka_topic"`
+	BucketPrefix string        `yaml:"bucket_prefix"`
+	QueueSize    int           `yaml:"queue_size"`
+	FlushPeriod  time.Duration `yaml:"flush_period"`
+}
+
+// RegisterFlags registers the dead-letter flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.Backend, "dead-letter.backend", "", fmt.Sprintf("Backend the series rejected by the validation and the ingestion limits are written to, with the rejection reason, so that they can be inspected and replayed. Supported values: %s. Empty to disable. The requests rejected by the rate limits aren't written, since the clients retry them.", strings.Join(supportedBackends, ", ")))
+	f.StringVar(&cfg.File, "dead-letter.file", "", "File the dead-letter records are appended to, one JSON record per line, with the file backend.")
+	f.StringVar(&cfg.KafkaAddress, "dead-letter.kafka.address", "", "Comma-separated list of Kafka seed brokers, in host:port format, with the kafka backend.")
+	f.StringVar(&cfg.KafkaTopic, "dead-letter.kafka.topic", "", "Kafka topic the dead-letter records are written to, keyed by tenant, with the kafka backend.")
+	f.StringVar(&cfg.BucketPrefix, "dead-letter.bucket-prefix", "dead-letter", "Prefix of the objects the dead-letter records are written to in the blocks storage bucket, with the bucket backend. The records of each tenant are written under <prefix>/<tenant>/, one object per flush.")
+	f.IntVar(&cfg.QueueSize, "dead-letter.queue-size", 10000, "Maximum number of records waiting to be written. The records rejected while the queue is full are dropped, so that a slow backend doesn't slow down the push path.")
+	f.DurationVar(&cfg.FlushPeriod, "dead-letter.flush-period", 10*time.Second, "How frequently the queued records are written to the backend.")
+}
+
+// Enabled returns true if the dead-letter sink is enabled.
+func (cfg *Config) Enabled() bool {
+	return cfg.Backend != ""
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	switch cfg.Backend {
+	case "":
+		return nil
+	case BackendFile:
+		if cfg.File == "" {
+			return errMissingFile
+		}
+	case BackendKafka:
+		if cfg.KafkaAddress == "" {
+			return errMissingKafkaAddress
+		}
+		if cfg.KafkaTopic == "" {
+			return errMissingKafkaTopic
+		}
+	case BackendBucket:
+		if cfg.BucketPrefix == "" {
+			return errMissingBucketPrefix
+		}
+	default:
+		return errors.Wrap(errUnsupportedBackend, cfg.Backend)
+	}
+
+	if cfg.QueueSize <= 0 {
+		return errInvalidQueueSize
+	}
+	if cfg.FlushPeriod <= 0 {
+		return errInvalidFlushPeriod
+	}
+	return nil
+}
+
+// Record is a dead-letter record: a series rejected on the push path, with the reason.
+type Record struct {
+	Time    time.Time     `json:"time"`
+	Tenant  string        `json:"tenant"`
+	Reason  string        `json:"reason"`
+	Error   string        `json:"error"`
+	Labels  labels.Labels `json:"labels"`
+	Samples []Sample      `json:"samples"`
+}
+
+// Sample is a rejected sample. The value is formatted as in the Prometheus HTTP API, so
+// that the special float values survive the JSON encoding.
+type Sample struct {
+	TimestampMs int64  `json:"timestamp_ms"`
+	Value       string `json:"value"`
+}
+
+// Timeseries returns the series of the record, to push it again.
+func (r Record) Timeseries() (cortexpb.PreallocTimeseries, error) {
+	samples := make([]cortexpb.Sample, 0, len(r.Samples))
+	for _, s := range r.Samples {
+		v, err := strconv.ParseFloat(s.Value, 64)
+		if err != nil {
+			return cortexpb.PreallocTimeseries{}, errors.Wrapf(err, "parse sample value %q", s.Value)
+		}
+		samples = append(samples, cortexpb.Sample{TimestampMs: s.TimestampMs, Value: v})
+	}
+
+	return cortexpb.PreallocTimeseries{TimeSeries: &cortexpb.TimeSeries{
+		Labels:  cortexpb.FromLabelsToLabelAdapters(r.Labels),
+		Samples: samples,
+	}}, nil
+}
+
+// ReadRecords decodes the records of r, one JSON record per line as written by the file and
+// bucket backends, and calls f for each of them.
+func ReadRecords(r io.Reader, f func(Record) error) error {
+	dec := json.NewDecoder(r)
+	for {
+		var rec Record
+		if err := dec.Decode(&rec); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "decode dead-letter record")
+		}
+		if err := f(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// sink writes the dead-letter records to a backend.
+type sink interface {
+	write(ctx context.Context, records []Record) error
+	close() error
+}
+
+// Writer queues the rejected series and writes them to the dead-letter backend in background.
+type Writer struct {
+	services.Service
+
+	cfg    Config
+	bkt    objstore.Bucket
+	logger log.Logger
+	sink   sink
+
+	mtx   sync.Mutex
+	queue []Record
+
+	records      *prometheus.CounterVec
+	dropped      prometheus.Counter
+	failedWrites prometheus.Counter
+	writes       prometheus.Counter
+}
+
+// NewWriter makes a new Writer. The bucket is only used by the bucket backend, and may be
+// nil otherwise.
+func NewWriter(cfg Config, bkt objstore.Bucket, logger log.Logger, reg prometheus.Registerer) *Writer {
+	w := &Writer{
+		cfg:    cfg,
+		bkt:    bkt,
+		logger: logger,
+		records: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_dead_letter_records_total",
+			Help: "Total number of series rejected on the push path and queued to the dead-letter backend, by reason.",
+		}, []string{"reason", "user"}),
+		dropped: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_dead_letter_dropped_records_total",
+			Help: "Total number of dead-letter records dropped because the queue was full or the write to the backend failed.",
+		}),
+		failedWrites: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_dead_letter_failed_writes_total",
+			Help: "Total number of writes to the dead-letter backend which failed.",
+		}),
+		writes: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_dead_letter_writes_total",
+			Help: "Total number of writes to the dead-letter backend.",
+		}),
+	}
+
+	w.Service = services.NewTimerService(cfg.FlushPeriod, w.starting, w.iteration, w.stopping)
+	return w
+}
+
+func (w *Writer) starting(_ context.Context) error {
+	switch w.cfg.Backend {
+	case BackendFile:
+		s, err := newFileSink(w.cfg.File)
+		if err != nil {
+			return err
+		}
+		w.sink = s
+	case BackendKafka:
+		s, err := newKafkaSink(w.cfg.KafkaAddress, w.cfg.KafkaTopic)
+		if err != nil {
+			return err
+		}
+		w.sink = s
+	case BackendBucket:
+		w.sink = newBucketSink(w.bkt, w.cfg.BucketPrefix)
+	default:
+		return errors.Wrap(errUnsupportedBackend, w.cfg.Backend)
+	}
+	return nil
+}
+
+func (w *Writer) iteration(ctx context.Context) error {
+	w.flush(ctx)
+	return nil
+}
+
+func (w *Writer) stopping(_ error) error {
+	// The queued records are written before stopping, with a fresh context since the
+	// service one is canceled.
+	ctx, cancel := context.WithTimeout(context.Background(), w.cfg.FlushPeriod)
+	defer cancel()
+	w.flush(ctx)
+
+	if w.sink != nil {
+		return w.sink.close()
+	}
+	return nil
+}
+
+// Add queues a series rejected for the reason. The labels and samples are copied, since they
+// may reference the request body. The record is dropped if the queue is full.
+func (w *Writer) Add(userID, reason string, cause error, lbls []cortexpb.LabelAdapter, samples []cortexpb.Sample) {
+	w.records.WithLabelValues(reason, userID).Inc()
+
+	rec := Record{
+		Time:    time.Now(),
+		Tenant:  userID,
+		Reason:  reason,
+		Labels:  cortexpb.FromLabelAdaptersToLabelsWithCopy(lbls),
+		Samples: make([]Sample, 0, len(samples)),
+	}
+	if cause != nil {
+		rec.Error = cause.Error()
+	}
+	for _, s := range samples {
+		rec.Samples = append(rec.Samples, Sample{TimestampMs: s.TimestampMs, Value: strconv.FormatFloat(s.Value, 'f', -1, 64)})
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if len(w.queue) >= w.cfg.QueueSize {
+		w.dropped.Inc()
+		return
+	}
+	w.queue = append(w.queue, rec)
+}
+
+// flush writes the queued records to the backend. The records failed to be written are
+// dropped, not to grow the queue while the backend is unavailable.
+func (w *Writer) flush(ctx context.Context) {
+	w.mtx.Lock()
+	records := w.queue
+	w.queue = nil
+	w.mtx.Unlock()
+
+	if len(records) == 0 || w.sink == nil {
+		return
+	}
+
+	w.writes.Inc()
+	if err := w.sink.write(ctx, records); err != nil {
+		w.failedWrites.Inc()
+		w.dropped.Add(float64(len(records)))
+		level.Warn(w.logger).Log("msg", "failed to write the dead-letter records", "backend", w.cfg.Backend, "records", len(records), "err", err)
+	}
+}
@@ -0,0 +1,142 @@
+package deadletter
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	valid := func(cfg Config) Config {
+		cfg.QueueSize, cfg.FlushPeriod = 10, time.Second
+		return cfg
+	}
+
+	tests := map[string]struct {
+		cfg      Config
+		expected error
+	}{
+		"disabled":              {},
+		"file":                  {cfg: valid(Config{Backend: BackendFile, File: "/tmp/dlq.jsonl"})},
+		"file without path":     {cfg: valid(Config{Backend: BackendFile}), expected: errMissingFile},
+		"kafka":                 {cfg: valid(Config{Backend: BackendKafka, KafkaAddress: "kafka:9092", KafkaTopic: "dlq"})},
+		"kafka without topic":   {cfg: valid(Config{Backend: BackendKafka, KafkaAddress: "kafka:9092"}), expected: errMissingKafkaTopic},
+		"bucket":                {cfg: valid(Config{Backend: BackendBucket, BucketPrefix: "dead-letter"})},
+		"unsupported backend":   {cfg: valid(Config{Backend: "s3"}), expected: errUnsupportedBackend},
+		"invalid queue size":    {cfg: Config{Backend: BackendBucket, BucketPrefix: "dead-letter", FlushPeriod: time.Second}, expected: errInvalidQueueSize},
+		"invalid flush period":  {cfg: Config{Backend: BackendBucket, BucketPrefix: "dead-letter", QueueSize: 10}, expected: errInvalidFlushPeriod},
+		"bucket without prefix": {cfg: valid(Config{Backend: BackendBucket}), expected: errMissingBucketPrefix},
+		"kafka without address": {cfg: valid(Config{Backend: BackendKafka, KafkaTopic: "dlq"}), expected: errMissingKafkaAddress},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, tc.cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+func TestWriter_File(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	w := NewWriter(Config{Backend: BackendFile, File: file, QueueSize: 10, FlushPeriod: time.Hour}, nil, log.NewNopLogger(), nil)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), w))
+
+	lbls := []cortexpb.LabelAdapter{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node"}}
+	samples := []cortexpb.Sample{{TimestampMs: 1, Value: 1}, {TimestampMs: 2, Value: math.NaN()}}
+	w.Add("tenant-a", "label_value_too_long", errors.New("label job value is too long"), lbls, samples)
+
+	// The queued records are written on stop.
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), w))
+
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var records []Record
+	require.NoError(t, ReadRecords(f, func(rec Record) error {
+		records = append(records, rec)
+		return nil
+	}))
+	require.Len(t, records, 1)
+
+	rec := records[0]
+	assert.Equal(t, "tenant-a", rec.Tenant)
+	assert.Equal(t, "label_value_too_long", rec.Reason)
+	assert.Equal(t, "label job value is too long", rec.Error)
+	assert.Equal(t, []Sample{{TimestampMs: 1, Value: "1"}, {TimestampMs: 2, Value: "NaN"}}, rec.Samples)
+
+	ts, err := rec.Timeseries()
+	require.NoError(t, err)
+	assert.Equal(t, lbls, ts.Labels)
+	require.Len(t, ts.Samples, 2)
+	assert.Equal(t, samples[0], ts.Samples[0])
+	assert.True(t, math.IsNaN(ts.Samples[1].Value))
+}
+
+func TestWriter_Bucket(t *testing.T) {
+	bkt := objstore.NewInMemBucket()
+	w := NewWriter(Config{Backend: BackendBucket, BucketPrefix: "dead-letter", QueueSize: 10, FlushPeriod: time.Hour}, bkt, log.NewNopLogger(), nil)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), w))
+
+	lbls := []cortexpb.LabelAdapter{{Name: "__name__", Value: "up"}}
+	w.Add("tenant-a", "per_user_series_limit", nil, lbls, []cortexpb.Sample{{TimestampMs: 1, Value: 1}})
+	w.Add("tenant-b", "per_user_series_limit", nil, lbls, []cortexpb.Sample{{TimestampMs: 1, Value: 1}})
+	w.Add("tenant-a", "per_metric_series_limit", nil, lbls, []cortexpb.Sample{{TimestampMs: 2, Value: 2}})
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), w))
+
+	for tenantID, expected := range map[string]int{"tenant-a": 2, "tenant-b": 1} {
+		var names []string
+		require.NoError(t, bkt.Iter(context.Background(), "dead-letter/"+tenantID+"/", func(name string) error {
+			names = append(names, name)
+			return nil
+		}))
+		require.Len(t, names, 1, tenantID)
+		assert.True(t, strings.HasSuffix(names[0], ObjectSuffix))
+
+		r, err := bkt.Get(context.Background(), names[0])
+		require.NoError(t, err)
+		lines := 0
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines++
+		}
+		require.NoError(t, r.Close())
+		assert.Equal(t, expected, lines, tenantID)
+	}
+}
+
+func TestWriter_QueueFull(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	w := NewWriter(Config{Backend: BackendBucket, BucketPrefix: "dead-letter", QueueSize: 2, FlushPeriod: time.Hour}, objstore.NewInMemBucket(), log.NewNopLogger(), reg)
+
+	lbls := []cortexpb.LabelAdapter{{Name: "__name__", Value: "up"}}
+	for i := 0; i < 3; i++ {
+		w.Add("tenant-a", "per_user_series_limit", nil, lbls, nil)
+	}
+
+	assert.Len(t, w.queue, 2)
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_dead_letter_dropped_records_total Total number of dead-letter records dropped because the queue was full or the write to the backend failed.
+		# TYPE cortex_dead_letter_dropped_records_total counter
+		cortex_dead_letter_dropped_records_total 1
+		# HELP cortex_dead_letter_records_total Total number of series rejected on the push path and queued to the dead-letter backend, by reason.
+		# TYPE cortex_dead_letter_records_total counter
+		cortex_dead_letter_records_total{reason="per_user_series_limit",user="tenant-a"} 3
+	`), "cortex_dead_letter_dropped_records_total", "cortex_dead_letter_records_total"))
+}
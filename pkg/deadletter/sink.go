@@ -0,0 +1,121 @@
+package deadletter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// ObjectSuffix is the suffix of the objects written by the bucket backend.
+const ObjectSuffix = ".jsonl"
+
+// fileSink appends the records to a local file, one JSON record per line.
+type fileSink struct {
+	f *os.File
+}
+
+func newFileSink(file string) (*fileSink, error) {
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, errors.Wrap(err, "open dead-letter file")
+	}
+	return &fileSink{f: f}, nil
+}
+
+func (s *fileSink) write(_ context.Context, records []Record) error {
+	buf := bufio.NewWriter(s.f)
+	enc := json.NewEncoder(buf)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
+
+func (s *fileSink) close() error {
+	return s.f.Close()
+}
+
+// kafkaSink produces the records to a Kafka topic, keyed by tenant, so that the records of
+// a tenant are kept in order in a single partition.
+type kafkaSink struct {
+	client *kgo.Client
+}
+
+func newKafkaSink(address, topic string) (*kafkaSink, error) {
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(strings.Split(address, ",")...),
+		kgo.DefaultProduceTopic(topic),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "create Kafka client")
+	}
+	return &kafkaSink{client: client}, nil
+}
+
+func (s *kafkaSink) write(ctx context.Context, records []Record) error {
+	krs := make([]*kgo.Record, 0, len(records))
+	for _, rec := range records {
+		value, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		krs = append(krs, &kgo.Record{Key: []byte(rec.Tenant), Value: value})
+	}
+	return s.client.ProduceSync(ctx, krs...).FirstErr()
+}
+
+func (s *kafkaSink) close() error {
+	s.client.Close()
+	return nil
+}
+
+// bucketSink uploads the records of each tenant to an object under the tenant prefix, one
+// JSON record per line. The objects are named by ULID, so that they're listed in the order
+// they've been written.
+type bucketSink struct {
+	bkt    objstore.Bucket
+	prefix string
+}
+
+func newBucketSink(bkt objstore.Bucket, prefix string) *bucketSink {
+	return &bucketSink{bkt: bkt, prefix: prefix}
+}
+
+func (s *bucketSink) write(ctx context.Context, records []Record) error {
+	byTenant := map[string]*bytes.Buffer{}
+	for _, rec := range records {
+		buf, ok := byTenant[rec.Tenant]
+		if !ok {
+			buf = &bytes.Buffer{}
+			byTenant[rec.Tenant] = buf
+		}
+		if err := json.NewEncoder(buf).Encode(rec); err != nil {
+			return err
+		}
+	}
+
+	for tenantID, buf := range byTenant {
+		name := path.Join(s.prefix, tenantID, ulid.MustNew(ulid.Now(), rand.Reader).String()+ObjectSuffix)
+		if err := s.bkt.Upload(ctx, name, buf); err != nil {
+			return errors.Wrapf(err, "upload %s", name)
+		}
+	}
+	return nil
+}
+
+func (s *bucketSink) close() error {
+	return nil
+}
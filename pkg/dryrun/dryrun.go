@@ -0,0 +1,128 @@
+package dryrun
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+	"golang.org/x/sys/unix"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+)
+
+// Recorder prints the actions skipped in dry-run mode.
+type Recorder struct {
+	mtx     sync.Mutex
+	out     io.Writer
+	actions int
+}
+
+// NewRecorder makes a new Recorder printing the actions to out.
+func NewRecorder(out io.Writer) *Recorder {
+	return &Recorder{out: out}
+}
+
+// Record prints an action the component would have taken.
+func (r *Recorder) Record(component, format string, args ...interface{}) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.actions++
+	fmt.Fprintf(r.out, "[dry-run] %s: would %s\n", component, fmt.Sprintf(format, args...))
+}
+
+// Actions returns the number of actions recorded.
+func (r *Recorder) Actions() int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.actions
+}
+
+// Bucket wraps the bucket so that the uploads and deletions are recorded instead of being
+// written. The reads go through.
+func Bucket(bkt objstore.Bucket, rec *Recorder) objstore.Bucket {
+	return &dryRunBucket{Bucket: bkt, rec: rec}
+}
+
+type dryRunBucket struct {
+	objstore.Bucket
+	rec *Recorder
+}
+
+func (b *dryRunBucket) Upload(_ context.Context, name string, r io.Reader) error {
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return err
+	}
+	b.rec.Record("bucket", "upload %s (%d bytes)", name, n)
+	return nil
+}
+
+func (b *dryRunBucket) Delete(_ context.Context, name string) error {
+	b.rec.Record("bucket", "delete %s", name)
+	return nil
+}
+
+// KV wraps the KV client so that the CAS and deletions are recorded instead of being
+// written. The CAS function is still run against the current value, so that its outcome
+// can be inspected. The reads and watches go through.
+func KV(client kv.Client, rec *Recorder) kv.Client {
+	return &dryRunKV{Client: client, rec: rec}
+}
+
+type dryRunKV struct {
+	kv.Client
+	rec *Recorder
+}
+
+func (c *dryRunKV) CAS(ctx context.Context, key string, f func(in interface{}) (out interface{}, retry bool, err error)) error {
+	in, err := c.Client.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	out, _, err := f(in)
+	if err != nil {
+		return err
+	}
+	if out != nil {
+		c.rec.Record("kv", "update key %s", key)
+	}
+	return nil
+}
+
+func (c *dryRunKV) Delete(_ context.Context, key string) error {
+	c.rec.Record("kv", "delete key %s", key)
+	return nil
+}
+
+// CheckBucket checks that the bucket can be listed.
+func CheckBucket(ctx context.Context, bkt objstore.BucketReader) error {
+	errFound := errors.New("found")
+	err := bkt.Iter(ctx, "", func(string) error { return errFound })
+	if err != nil && !errors.Is(err, errFound) {
+		return errors.Wrap(err, "list bucket")
+	}
+	return nil
+}
+
+// CheckDir checks that the directory can be written, or created if missing, without
+// creating it.
+func CheckDir(dir string) error {
+	for path := filepath.Clean(dir); ; path = filepath.Dir(path) {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) && path != filepath.Dir(path) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return errors.Errorf("%s is not a directory", path)
+		}
+		return errors.Wrapf(unix.Access(path, unix.W_OK), "%s is not writable", path)
+	}
+}
@@ -0,0 +1,98 @@
+package dryrun
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+)
+
+func TestBucket(t *testing.T) {
+	ctx := context.Background()
+	inmem := objstore.NewInMemBucket()
+	require.NoError(t, inmem.Upload(ctx, "existing", bytes.NewReader([]byte("data"))))
+
+	out := &bytes.Buffer{}
+	rec := NewRecorder(out)
+	bkt := Bucket(inmem, rec)
+
+	require.NoError(t, bkt.Upload(ctx, "new", bytes.NewReader([]byte("abc"))))
+	require.NoError(t, bkt.Delete(ctx, "existing"))
+
+	// The reads go through, the writes are only recorded.
+	exists, err := bkt.Exists(ctx, "existing")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = bkt.Exists(ctx, "new")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	assert.Equal(t, 2, rec.Actions())
+	assert.Equal(t, "[dry-run] bucket: would upload new (3 bytes)\n[dry-run] bucket: would delete existing\n", out.String())
+}
+
+func TestSimulateRingJoin(t *testing.T) {
+	ctx := context.Background()
+	store, closer := consul.NewInMemoryClient(ring.GetCodec(), log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	// Another instance is already in the ring.
+	require.NoError(t, store.CAS(ctx, "ring", func(interface{}) (interface{}, bool, error) {
+		desc := ring.NewDesc()
+		desc.AddIngester("ingester-1", "1.1.1.1:9095", "", []uint32{1, 2}, ring.ACTIVE, time.Time{})
+		return desc, true, nil
+	}))
+
+	out := &bytes.Buffer{}
+	rec := NewRecorder(out)
+	client := KV(store, rec)
+
+	join, err := SimulateRingJoin(ctx, client, "ring", "ingester-2", "2.2.2.2:9095", "zone-a", 128)
+	require.NoError(t, err)
+	assert.Equal(t, RingJoin{Instances: 1, State: ring.ACTIVE, NewTokens: 128}, join)
+	assert.Equal(t, "[dry-run] kv: would update key ring\n", out.String())
+
+	// The ring is left untouched.
+	value, err := store.Get(ctx, "ring")
+	require.NoError(t, err)
+	assert.Len(t, value.(*ring.Desc).Ingesters, 1)
+
+	// An instance already in the ring keeps its state and tokens.
+	join, err = SimulateRingJoin(ctx, client, "ring", "ingester-1", "1.1.1.1:9095", "", 2)
+	require.NoError(t, err)
+	assert.Equal(t, RingJoin{Instances: 1, Registered: true, State: ring.ACTIVE}, join)
+
+	require.NoError(t, client.Delete(ctx, "ring"))
+	value, err = store.Get(ctx, "ring")
+	require.NoError(t, err)
+	assert.NotNil(t, value)
+}
+
+func TestCheckBucket(t *testing.T) {
+	bkt := objstore.NewInMemBucket()
+	assert.NoError(t, CheckBucket(context.Background(), bkt))
+	require.NoError(t, bkt.Upload(context.Background(), "user-1/object", bytes.NewReader(nil)))
+	assert.NoError(t, CheckBucket(context.Background(), bkt))
+}
+
+func TestCheckDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+
+	assert.NoError(t, CheckDir(dir))
+	// A missing directory can be created in its parent, and isn't.
+	assert.NoError(t, CheckDir(filepath.Join(dir, "missing", "tsdb")))
+	assert.NoDirExists(t, filepath.Join(dir, "missing"))
+	assert.Error(t, CheckDir(filepath.Join(file, "tsdb")))
+}
@@ -0,0 +1,56 @@
+package dryrun
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+)
+
+// RingJoin is the outcome of a simulated ring join.
+type RingJoin struct {
+	// Instances is the number of instances in the ring before the join.
+	Instances int
+	// Registered is true if the instance is already in the ring, in which case it keeps its
+	// state and tokens.
+	Registered bool
+	State      ring.InstanceState
+	// NewTokens is the number of tokens the instance would take.
+	NewTokens int
+}
+
+// SimulateRingJoin simulates the join of the instance into the ring stored at the key, as
+// the lifecycler would do, through the client which is expected to be wrapped by KV.
+func SimulateRingJoin(ctx context.Context, client kv.Client, key, id, addr, zone string, numTokens int) (RingJoin, error) {
+	var join RingJoin
+	err := client.CAS(ctx, key, func(in interface{}) (interface{}, bool, error) {
+		desc, ok := in.(*ring.Desc)
+		if in != nil && !ok {
+			return nil, false, errors.Errorf("unexpected ring value %T", in)
+		}
+		if desc == nil {
+			desc = ring.NewDesc()
+		}
+		join = RingJoin{Instances: len(desc.Ingesters), State: ring.ACTIVE}
+
+		var tokens []uint32
+		registeredAt := time.Now()
+		if instance, ok := desc.Ingesters[id]; ok {
+			join.Registered = true
+			join.State = instance.State
+			tokens = instance.Tokens
+			registeredAt = instance.GetRegisteredAt()
+		}
+		if missing := numTokens - len(tokens); missing > 0 {
+			tokens = append(tokens, ring.GenerateTokens(missing, desc.GetTokens())...)
+			join.NewTokens = missing
+		}
+
+		desc.AddIngester(id, addr, zone, tokens, join.State, registeredAt)
+		return desc, true, nil
+	})
+	return join, errors.Wrap(err, "simulate ring join")
+}
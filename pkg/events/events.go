@@ -0,0 +1,250 @@
+// Package events implements the bus of the ingester lifecycle events, written to pluggable
+// sinks so that the platform automation can react to them.
+package events
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	cortex_flagext "github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/util/flagext"
+)
+
+// Types of the events.
+const (
+	TypeIngesterStateChanged = "ingester_state_changed"
+	TypeTenantCreated        = "tenant_created"
+	TypeTenantDeleted        = "tenant_deleted"
+	TypeLimitsBreached       = "limits_breached"
+	TypeUploadFailed         = "upload_failed"
+)
+
+// Sinks of the events.
+const (
+	SinkLog     = "log"
+	SinkKafka   = "kafka"
+	SinkWebhook = "webhook"
+)
+
+var (
+	supportedSinks = []string{SinkLog, SinkKafka, SinkWebhook}
+
+	errUnsupportedSink     = errors.New("unsupported events sink")
+	errMissingKafkaAddress = errors.New("the events Kafka address has not been configured")
+	errMissingKafkaTopic   = errors.New("the events Kafka topic has not been configured")
+	errMissingWebhookURLs  = errors.New("the events webhook URLs have not been configured")
+	errMissingSecret       = errors.New("the events webhook secret is required to sign the events")
+	errInvalidQueueSize    = errors.New("the events queue size must be greater than 0")
+	errInvalidFlushPeriod  = errors.New("the events flush period must be greater than 0")
+)
+
+// Config holds the configuration of the events bus.
+type Config struct {
+	Sinks         cortex_flagext.StringSliceCSV `yaml:"sinks"`
+	KafkaAddress  string                        `yaml:"kafka_address"`
+	KafkaTopic    string                        `yaml:"kafka_topic"`
+	WebhookURLs   cortex_flagext.StringSliceCSV `yaml:"webhook_urls"`
+	WebhookSecret flagext.Secret                `yaml:"webhook_secret"`
+	QueueSize     int                           `yaml:"queue_size"`
+	FlushPeriod   time.Duration                 `yaml:"flush_period"`
+}
+
+// RegisterFlags registers the events flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.Var(&cfg.Sinks, "events.sinks", fmt.Sprintf("Comma-separated list of sinks the ingester events are written to: the ingester state changes, the tenants created and deleted, the limits breached and the uploads failed. Supported values: %s. Empty to disable.", strings.Join(supportedSinks, ", ")))
+	f.StringVar(&cfg.KafkaAddress, "events.kafka.address", "", "Comma-separated list of Kafka seed brokers, in host:port format, with the kafka sink.")
+	f.StringVar(&cfg.KafkaTopic, "events.kafka.topic", "", "Kafka topic the events are written to, keyed by tenant, with the kafka sink.")
+	f.Var(&cfg.WebhookURLs, "events.webhook.urls", "Comma-separated list of URLs the events are posted to, as a JSON array per flush, with the webhook sink.")
+	f.Var(&cfg.WebhookSecret, "events.webhook.secret", "Secret the events posted are signed with, as the hex HMAC-SHA256 of the body in the X-Webhook-Signature header, prefixed by sha256=.")
+	f.IntVar(&cfg.QueueSize, "events.queue-size", 10000, "Maximum number of events waiting to be written. The events published while the queue is full are dropped.")
+	f.DurationVar(&cfg.FlushPeriod, "events.flush-period", 5*time.Second, "How frequently the queued events are written to the sinks.")
+}
+
+// Enabled returns true if the events bus is enabled.
+func (cfg *Config) Enabled() bool {
+	return len(cfg.Sinks) > 0
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled() {
+		return nil
+	}
+
+	for _, name := range cfg.Sinks {
+		switch name {
+		case SinkLog:
+		case SinkKafka:
+			if cfg.KafkaAddress == "" {
+				return errMissingKafkaAddress
+			}
+			if cfg.KafkaTopic == "" {
+				return errMissingKafkaTopic
+			}
+		case SinkWebhook:
+			if len(cfg.WebhookURLs) == 0 {
+				return errMissingWebhookURLs
+			}
+			if cfg.WebhookSecret.Value == "" {
+				return errMissingSecret
+			}
+		default:
+			return errors.Wrap(errUnsupportedSink, name)
+		}
+	}
+
+	if cfg.QueueSize <= 0 {
+		return errInvalidQueueSize
+	}
+	if cfg.FlushPeriod <= 0 {
+		return errInvalidFlushPeriod
+	}
+	return nil
+}
+
+// Event is an ingester event. The details depend on the type.
+type Event struct {
+	Type     string            `json:"type"`
+	Time     time.Time         `json:"time"`
+	Instance string            `json:"instance"`
+	Tenant   string            `json:"tenant,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+}
+
+// sink writes the events to a backend.
+type sink interface {
+	write(ctx context.Context, events []Event) error
+	close() error
+}
+
+// Bus queues the published events and writes them to every sink in background.
+type Bus struct {
+	services.Service
+
+	cfg      Config
+	instance string
+	logger   log.Logger
+	sinks    map[string]sink
+
+	mtx   sync.Mutex
+	queue []Event
+
+	published    *prometheus.CounterVec
+	dropped      prometheus.Counter
+	failedWrites *prometheus.CounterVec
+}
+
+// NewBus makes a new Bus. The events are published on behalf of the instance.
+func NewBus(cfg Config, instance string, logger log.Logger, reg prometheus.Registerer) *Bus {
+	b := &Bus{
+		cfg:      cfg,
+		instance: instance,
+		logger:   logger,
+		sinks:    map[string]sink{},
+		published: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_events_published_total",
+			Help: "Total number of ingester events published, by type.",
+		}, []string{"type"}),
+		dropped: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_events_dropped_total",
+			Help: "Total number of ingester events dropped because the queue was full.",
+		}),
+		failedWrites: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_events_failed_writes_total",
+			Help: "Total number of writes of the ingester events to a sink which failed, by sink.",
+		}, []string{"sink"}),
+	}
+
+	b.Service = services.NewTimerService(cfg.FlushPeriod, b.starting, b.iteration, b.stopping)
+	return b
+}
+
+func (b *Bus) starting(_ context.Context) error {
+	for _, name := range b.cfg.Sinks {
+		switch name {
+		case SinkLog:
+			b.sinks[name] = newLogSink(b.logger)
+		case SinkKafka:
+			s, err := newKafkaSink(b.cfg.KafkaAddress, b.cfg.KafkaTopic)
+			if err != nil {
+				return err
+			}
+			b.sinks[name] = s
+		case SinkWebhook:
+			b.sinks[name] = newWebhookSink(b.cfg.WebhookURLs, b.cfg.WebhookSecret.Value, b.cfg.FlushPeriod)
+		default:
+			return errors.Wrap(errUnsupportedSink, name)
+		}
+	}
+	return nil
+}
+
+func (b *Bus) iteration(ctx context.Context) error {
+	b.flush(ctx)
+	return nil
+}
+
+func (b *Bus) stopping(_ error) error {
+	// The queued events are written before stopping, with a fresh context since the
+	// service one is canceled.
+	ctx, cancel := context.WithTimeout(context.Background(), b.cfg.FlushPeriod)
+	defer cancel()
+	b.flush(ctx)
+
+	for name, s := range b.sinks {
+		if err := s.close(); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to close the events sink", "sink", name, "err", err)
+		}
+	}
+	return nil
+}
+
+// Publish queues the event, setting its time, unless set, and instance. The event is dropped
+// if the queue is full.
+func (b *Bus) Publish(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	ev.Instance = b.instance
+	b.published.WithLabelValues(ev.Type).Inc()
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if len(b.queue) >= b.cfg.QueueSize {
+		b.dropped.Inc()
+		return
+	}
+	b.queue = append(b.queue, ev)
+}
+
+// flush writes the queued events to every sink. The events failed to be written to a sink
+// aren't retried, not to grow the queue while a sink is unavailable.
+func (b *Bus) flush(ctx context.Context) {
+	b.mtx.Lock()
+	events := b.queue
+	b.queue = nil
+	b.mtx.Unlock()
+
+	if len(events) == 0 {
+		return
+	}
+
+	for name, s := range b.sinks {
+		if err := s.write(ctx, events); err != nil {
+			b.failedWrites.WithLabelValues(name).Inc()
+			level.Warn(b.logger).Log("msg", "failed to write the events", "sink", name, "events", len(events), "err", err)
+		}
+	}
+}
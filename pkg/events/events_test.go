@@ -0,0 +1,211 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/webhook"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *Config)
+		expected error
+	}{
+		"disabled": {
+			setup:    func(*Config) {},
+			expected: nil,
+		},
+		"log sink": {
+			setup:    func(cfg *Config) { cfg.Sinks = []string{SinkLog} },
+			expected: nil,
+		},
+		"unsupported sink": {
+			setup:    func(cfg *Config) { cfg.Sinks = []string{"unknown"} },
+			expected: errUnsupportedSink,
+		},
+		"kafka sink": {
+			setup: func(cfg *Config) {
+				cfg.Sinks = []string{SinkKafka}
+				cfg.KafkaAddress = "kafka:9092"
+				cfg.KafkaTopic = "events"
+			},
+			expected: nil,
+		},
+		"kafka sink without address": {
+			setup: func(cfg *Config) {
+				cfg.Sinks = []string{SinkKafka}
+				cfg.KafkaTopic = "events"
+			},
+			expected: errMissingKafkaAddress,
+		},
+		"kafka sink without topic": {
+			setup: func(cfg *Config) {
+				cfg.Sinks = []string{SinkKafka}
+				cfg.KafkaAddress = "kafka:9092"
+			},
+			expected: errMissingKafkaTopic,
+		},
+		"webhook sink without URLs": {
+			setup: func(cfg *Config) {
+				cfg.Sinks = []string{SinkWebhook}
+				cfg.WebhookSecret.Value = "secret"
+			},
+			expected: errMissingWebhookURLs,
+		},
+		"webhook sink without secret": {
+			setup: func(cfg *Config) {
+				cfg.Sinks = []string{SinkWebhook}
+				cfg.WebhookURLs = []string{"http://automation/events"}
+			},
+			expected: errMissingSecret,
+		},
+		"invalid queue size": {
+			setup: func(cfg *Config) {
+				cfg.Sinks = []string{SinkLog}
+				cfg.QueueSize = 0
+			},
+			expected: errInvalidQueueSize,
+		},
+		"invalid flush period": {
+			setup: func(cfg *Config) {
+				cfg.Sinks = []string{SinkLog}
+				cfg.FlushPeriod = 0
+			},
+			expected: errInvalidFlushPeriod,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+			assert.ErrorIs(t, cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+// webhookMock records the events received, checking their signature.
+type webhookMock struct {
+	mtx    sync.Mutex
+	events []Event
+}
+
+func (m *webhookMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	if r.Header.Get(webhook.SignatureHeader) != webhook.Sign("secret", body) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var events []Event
+	if err := json.Unmarshal(body, &events); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	m.events = append(m.events, events...)
+}
+
+func (m *webhookMock) received() []Event {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return append([]Event(nil), m.events...)
+}
+
+func TestBus(t *testing.T) {
+	mock := &webhookMock{}
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Sinks = []string{SinkLog, SinkWebhook}
+	cfg.WebhookURLs = []string{srv.URL}
+	cfg.WebhookSecret.Value = "secret"
+	cfg.FlushPeriod = 100 * time.Millisecond
+
+	b := NewBus(cfg, "ingester-1", log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), b))
+
+	b.Publish(Event{Type: TypeTenantCreated, Tenant: "user-1"})
+	b.Publish(Event{Type: TypeLimitsBreached, Tenant: "user-1", Details: map[string]string{"reason": "rate_limited"}})
+
+	require.Eventually(t, func() bool { return len(mock.received()) == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	// The events queued while stopping are written before the bus is terminated.
+	b.Publish(Event{Type: TypeIngesterStateChanged, Details: map[string]string{"read_only": "true"}})
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), b))
+
+	events := mock.received()
+	require.Len(t, events, 3)
+	assert.Equal(t, TypeTenantCreated, events[0].Type)
+	assert.Equal(t, "user-1", events[0].Tenant)
+	assert.Equal(t, "ingester-1", events[0].Instance)
+	assert.False(t, events[0].Time.IsZero())
+	assert.Equal(t, TypeLimitsBreached, events[1].Type)
+	assert.Equal(t, map[string]string{"reason": "rate_limited"}, events[1].Details)
+	assert.Equal(t, TypeIngesterStateChanged, events[2].Type)
+	assert.Empty(t, events[2].Tenant)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(b.published.WithLabelValues(TypeTenantCreated)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(b.failedWrites.WithLabelValues(SinkWebhook)))
+}
+
+func TestBus_CountsTheFailedWrites(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Sinks = []string{SinkLog, SinkWebhook}
+	cfg.WebhookURLs = []string{srv.URL}
+	cfg.WebhookSecret.Value = "secret"
+
+	b := NewBus(cfg, "ingester-1", log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, b.starting(context.Background()))
+
+	b.Publish(Event{Type: TypeUploadFailed, Tenant: "user-1"})
+	b.flush(context.Background())
+
+	// The failed events aren't retried.
+	assert.Equal(t, float64(1), testutil.ToFloat64(b.failedWrites.WithLabelValues(SinkWebhook)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(b.failedWrites.WithLabelValues(SinkLog)))
+	assert.Empty(t, b.queue)
+}
+
+func TestBus_DropsTheEventsWhenTheQueueIsFull(t *testing.T) {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Sinks = []string{SinkLog}
+	cfg.QueueSize = 2
+
+	b := NewBus(cfg, "ingester-1", log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	for i := 0; i < 5; i++ {
+		b.Publish(Event{Type: TypeTenantCreated})
+	}
+
+	assert.Len(t, b.queue, 2)
+	assert.Equal(t, float64(5), testutil.ToFloat64(b.published.WithLabelValues(TypeTenantCreated)))
+	assert.Equal(t, float64(3), testutil.ToFloat64(b.dropped))
+}
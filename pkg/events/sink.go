@@ -0,0 +1,139 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"objectstorage/pkg/webhook"
+)
+
+// logSink logs the events to the process log.
+type logSink struct {
+	logger log.Logger
+}
+
+func newLogSink(logger log.Logger) *logSink {
+	return &logSink{logger: logger}
+}
+
+func (s *logSink) write(_ context.Context, events []Event) error {
+	for _, ev := range events {
+		keyvals := []interface{}{"msg", "ingester event", "type", ev.Type, "time", ev.Time, "instance", ev.Instance}
+		if ev.Tenant != "" {
+			keyvals = append(keyvals, "tenant", ev.Tenant)
+		}
+
+		// The details are logged sorted, so that the lines are stable.
+		keys := make([]string, 0, len(ev.Details))
+		for k := range ev.Details {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			keyvals = append(keyvals, k, ev.Details[k])
+		}
+		level.Info(s.logger).Log(keyvals...)
+	}
+	return nil
+}
+
+func (s *logSink) close() error {
+	return nil
+}
+
+// kafkaSink produces the events to a Kafka topic, keyed by tenant, so that the events of a
+// tenant are kept in order in a single partition.
+type kafkaSink struct {
+	client *kgo.Client
+}
+
+func newKafkaSink(address, topic string) (*kafkaSink, error) {
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(strings.Split(address, ",")...),
+		kgo.DefaultProduceTopic(topic),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "create Kafka client")
+	}
+	return &kafkaSink{client: client}, nil
+}
+
+func (s *kafkaSink) write(ctx context.Context, events []Event) error {
+	krs := make([]*kgo.Record, 0, len(events))
+	for _, ev := range events {
+		value, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		krs = append(krs, &kgo.Record{Key: []byte(ev.Tenant), Value: value})
+	}
+	return s.client.ProduceSync(ctx, krs...).FirstErr()
+}
+
+func (s *kafkaSink) close() error {
+	s.client.Close()
+	return nil
+}
+
+// webhookSink posts the events to the webhooks, as a JSON array, signed as the block
+// lifecycle notifications.
+type webhookSink struct {
+	urls   []string
+	secret string
+	client *http.Client
+}
+
+func newWebhookSink(urls []string, secret string, timeout time.Duration) *webhookSink {
+	return &webhookSink{urls: urls, secret: secret, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *webhookSink) write(ctx context.Context, events []Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+
+	// Every webhook is posted to, even if a previous one failed.
+	var firstErr error
+	for _, url := range s.urls {
+		if err := s.post(ctx, url, body); err != nil && firstErr == nil {
+			firstErr = errors.Wrap(err, url)
+		}
+	}
+	return firstErr
+}
+
+func (s *webhookSink) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(s.secret, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *webhookSink) close() error {
+	return nil
+}
@@ -0,0 +1,217 @@
+package faultinjection
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+)
+
+// The targets faults can be injected into.
+const (
+	TargetBucket = "bucket"
+	TargetKV     = "kv"
+	TargetWAL    = "wal"
+)
+
+var (
+	// ErrInjected is the error returned by the operations failed on purpose.
+	ErrInjected = errors.New("injected fault")
+
+	errUnknownTarget    = errors.New("unknown fault injection target, expected one of bucket, kv, wal")
+	errInvalidErrorRate = errors.New("the fault error rate must be between 0 and 1")
+	errInvalidLatency   = errors.New("the fault latency must not be negative")
+)
+
+var targets = []string{TargetBucket, TargetKV, TargetWAL}
+
+// Config holds the configuration of the fault injection.
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// RegisterFlags registers the fault injection flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "fault-injection.enabled", false, "True to allow injecting latency and errors into the bucket, KV store and WAL operations at runtime, through the admin listener. Meant for resilience testing only: no fault is injected until configured.")
+}
+
+// Fault is the latency and the errors injected into the operations of a target.
+type Fault struct {
+	// Latency is added to each operation.
+	Latency model.Duration `json:"latency"`
+	// ErrorRate is the fraction of the operations failed with ErrInjected, after the latency.
+	ErrorRate float64 `json:"error_rate"`
+	// Operations restricts the fault to the named operations, for example upload or cas.
+	// Empty to inject into all the operations.
+	Operations []string `json:"operations,omitempty"`
+}
+
+func (f Fault) validate() error {
+	if f.Latency < 0 {
+		return errInvalidLatency
+	}
+	if f.ErrorRate < 0 || f.ErrorRate > 1 {
+		return errInvalidErrorRate
+	}
+	return nil
+}
+
+func (f Fault) applies(op string) bool {
+	if len(f.Operations) == 0 {
+		return true
+	}
+	for _, o := range f.Operations {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Injector injects the configured faults into the operations of the wrapped bucket, KV
+// clients and push path. The faults are changed at runtime through its HTTP handler.
+type Injector struct {
+	logger log.Logger
+
+	mtx    sync.RWMutex
+	faults map[string]Fault
+	rnd    *rand.Rand
+
+	injected *prometheus.CounterVec
+}
+
+// NewInjector makes a new Injector, injecting no fault until configured.
+func NewInjector(logger log.Logger, reg prometheus.Registerer) *Injector {
+	return &Injector{
+		logger: logger,
+		faults: map[string]Fault{},
+		rnd:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		injected: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_fault_injection_injected_total",
+			Help: "Total number of faults injected, by target and kind of fault.",
+		}, []string{"target", "kind"}),
+	}
+}
+
+// SetFault sets the fault injected into the target, replacing the previous one.
+func (i *Injector) SetFault(target string, f Fault) error {
+	if !validTarget(target) {
+		return errUnknownTarget
+	}
+	if err := f.validate(); err != nil {
+		return err
+	}
+
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	i.faults[target] = f
+	return nil
+}
+
+// ClearFault stops injecting faults into the target.
+func (i *Injector) ClearFault(target string) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	delete(i.faults, target)
+}
+
+// Faults returns the faults injected by target.
+func (i *Injector) Faults() map[string]Fault {
+	i.mtx.RLock()
+	defer i.mtx.RUnlock()
+	faults := make(map[string]Fault, len(i.faults))
+	for target, f := range i.faults {
+		faults[target] = f
+	}
+	return faults
+}
+
+// inject waits for the latency of the fault of the target, and returns ErrInjected if the
+// operation must fail.
+func (i *Injector) inject(ctx context.Context, target, op string) error {
+	i.mtx.RLock()
+	f, ok := i.faults[target]
+	i.mtx.RUnlock()
+	if !ok || !f.applies(op) {
+		return nil
+	}
+
+	if f.Latency > 0 {
+		i.injected.WithLabelValues(target, "latency").Inc()
+		select {
+		case <-time.After(time.Duration(f.Latency)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	i.mtx.Lock()
+	fail := i.rnd.Float64() < f.ErrorRate
+	i.mtx.Unlock()
+	if fail {
+		i.injected.WithLabelValues(target, "error").Inc()
+		return errors.Wrapf(ErrInjected, "%s %s", target, op)
+	}
+	return nil
+}
+
+// faultRequest is the body of the requests setting a fault.
+type faultRequest struct {
+	Target string `json:"target"`
+	Fault
+}
+
+// ServeHTTP lists the faults on GET, sets the fault of a target on POST, and clears the fault
+// of the target given by the target query parameter on DELETE, or all of them if missing.
+func (i *Injector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req faultRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := i.SetFault(req.Target, req.Fault); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		level.Warn(i.logger).Log("msg", "fault injection configured", "target", req.Target, "latency", req.Latency, "error_rate", req.ErrorRate, "operations", len(req.Operations))
+	case http.MethodDelete:
+		cleared := targets
+		if target := r.URL.Query().Get("target"); target != "" {
+			cleared = []string{target}
+		}
+		for _, target := range cleared {
+			i.ClearFault(target)
+		}
+		level.Info(i.logger).Log("msg", "fault injection cleared", "targets", len(cleared))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(i.Faults()); err != nil {
+		level.Error(i.logger).Log("msg", "failed to encode the injected faults", "err", err)
+	}
+}
+
+func validTarget(target string) bool {
+	for _, t := range targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package faultinjection
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+)
+
+func TestInjector_SetFault(t *testing.T) {
+	i := NewInjector(log.NewNopLogger(), nil)
+
+	assert.Equal(t, errUnknownTarget, i.SetFault("disk", Fault{}))
+	assert.Equal(t, errInvalidErrorRate, i.SetFault(TargetBucket, Fault{ErrorRate: 1.5}))
+	assert.Equal(t, errInvalidLatency, i.SetFault(TargetBucket, Fault{Latency: -1}))
+
+	require.NoError(t, i.SetFault(TargetBucket, Fault{ErrorRate: 1}))
+	assert.Equal(t, map[string]Fault{TargetBucket: {ErrorRate: 1}}, i.Faults())
+	i.ClearFault(TargetBucket)
+	assert.Empty(t, i.Faults())
+}
+
+func TestInjector_Bucket(t *testing.T) {
+	ctx := context.Background()
+	reg := prometheus.NewPedanticRegistry()
+	i := NewInjector(log.NewNopLogger(), reg)
+	bkt := i.Bucket(objstore.NewInMemBucket())
+
+	// No fault is injected until configured.
+	require.NoError(t, bkt.Upload(ctx, "object", bytes.NewReader([]byte("data"))))
+
+	// The uploads fail, the reads still succeed.
+	require.NoError(t, i.SetFault(TargetBucket, Fault{ErrorRate: 1, Operations: []string{"upload"}}))
+	assert.ErrorIs(t, bkt.Upload(ctx, "other", bytes.NewReader(nil)), ErrInjected)
+	exists, err := bkt.Exists(ctx, "object")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// The latency is added to all the operations.
+	require.NoError(t, i.SetFault(TargetBucket, Fault{Latency: model.Duration(50 * time.Millisecond)}))
+	start := time.Now()
+	_, err = bkt.Exists(ctx, "object")
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// The latency is interrupted by the context.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, bkt.Delete(canceled, "object"), context.Canceled)
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_fault_injection_injected_total Total number of faults injected, by target and kind of fault.
+		# TYPE cortex_fault_injection_injected_total counter
+		cortex_fault_injection_injected_total{kind="error",target="bucket"} 1
+		cortex_fault_injection_injected_total{kind="latency",target="bucket"} 2
+	`)))
+}
+
+func TestInjector_KV(t *testing.T) {
+	ctx := context.Background()
+	store, closer := consul.NewInMemoryClient(ring.GetCodec(), log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	i := NewInjector(log.NewNopLogger(), nil)
+	client := i.KV(store)
+	require.NoError(t, i.SetFault(TargetKV, Fault{ErrorRate: 1, Operations: []string{"cas"}}))
+
+	err := client.CAS(ctx, "key", func(interface{}) (interface{}, bool, error) { return ring.NewDesc(), false, nil })
+	assert.ErrorIs(t, err, ErrInjected)
+	value, err := client.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+}
+
+func TestInjector_WALMiddleware(t *testing.T) {
+	i := NewInjector(log.NewNopLogger(), nil)
+	pushed := 0
+	push := i.WALMiddleware()(func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		pushed++
+		return &cortexpb.WriteResponse{}, nil
+	})
+
+	_, err := push(context.Background(), &cortexpb.WriteRequest{})
+	require.NoError(t, err)
+	require.NoError(t, i.SetFault(TargetWAL, Fault{ErrorRate: 1}))
+	_, err = push(context.Background(), &cortexpb.WriteRequest{})
+	assert.ErrorIs(t, err, ErrInjected)
+	assert.Equal(t, 1, pushed)
+}
+
+func TestInjector_ServeHTTP(t *testing.T) {
+	i := NewInjector(log.NewNopLogger(), nil)
+
+	tests := []struct {
+		method         string
+		url            string
+		body           string
+		expectedCode   int
+		expectedFaults map[string]Fault
+	}{
+		{
+			method:         http.MethodPost,
+			url:            "/fault-injection",
+			body:           `{"target": "bucket", "latency": "1s", "error_rate": 0.5}`,
+			expectedCode:   http.StatusOK,
+			expectedFaults: map[string]Fault{TargetBucket: {Latency: model.Duration(time.Second), ErrorRate: 0.5}},
+		},
+		{
+			method:       http.MethodPost,
+			url:          "/fault-injection",
+			body:         `{"target": "disk"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			method:       http.MethodPost,
+			url:          "/fault-injection",
+			body:         `{"target": "kv", "error_rate": 1, "operations": ["cas"]}`,
+			expectedCode: http.StatusOK,
+			expectedFaults: map[string]Fault{
+				TargetBucket: {Latency: model.Duration(time.Second), ErrorRate: 0.5},
+				TargetKV:     {ErrorRate: 1, Operations: []string{"cas"}},
+			},
+		},
+		{
+			method:         http.MethodDelete,
+			url:            "/fault-injection?target=bucket",
+			expectedCode:   http.StatusOK,
+			expectedFaults: map[string]Fault{TargetKV: {ErrorRate: 1, Operations: []string{"cas"}}},
+		},
+		{
+			method:         http.MethodDelete,
+			url:            "/fault-injection",
+			expectedCode:   http.StatusOK,
+			expectedFaults: map[string]Fault{},
+		},
+	}
+
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		i.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body)))
+		require.Equal(t, tc.expectedCode, rec.Code, rec.Body.String())
+		if tc.expectedCode != http.StatusOK {
+			continue
+		}
+
+		var faults map[string]Fault
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&faults))
+		assert.Equal(t, tc.expectedFaults, faults)
+	}
+}
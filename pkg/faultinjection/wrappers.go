@@ -0,0 +1,126 @@
+package faultinjection
+
+import (
+	"context"
+	"io"
+
+	"github.com/thanos-io/objstore"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+
+	"objectstorage/pkg/push"
+)
+
+// Bucket wraps the bucket so that the faults of the bucket target are injected into its
+// operations, named after the objstore methods: upload, delete, get, get_range, iter,
+// exists and attributes.
+func (i *Injector) Bucket(bkt objstore.Bucket) objstore.Bucket {
+	return &faultyBucket{Bucket: bkt, injector: i}
+}
+
+type faultyBucket struct {
+	objstore.Bucket
+	injector *Injector
+}
+
+func (b *faultyBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if err := b.injector.inject(ctx, TargetBucket, "upload"); err != nil {
+		return err
+	}
+	return b.Bucket.Upload(ctx, name, r)
+}
+
+func (b *faultyBucket) Delete(ctx context.Context, name string) error {
+	if err := b.injector.inject(ctx, TargetBucket, "delete"); err != nil {
+		return err
+	}
+	return b.Bucket.Delete(ctx, name)
+}
+
+func (b *faultyBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := b.injector.inject(ctx, TargetBucket, "get"); err != nil {
+		return nil, err
+	}
+	return b.Bucket.Get(ctx, name)
+}
+
+func (b *faultyBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	if err := b.injector.inject(ctx, TargetBucket, "get_range"); err != nil {
+		return nil, err
+	}
+	return b.Bucket.GetRange(ctx, name, off, length)
+}
+
+func (b *faultyBucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	if err := b.injector.inject(ctx, TargetBucket, "iter"); err != nil {
+		return err
+	}
+	return b.Bucket.Iter(ctx, dir, f, options...)
+}
+
+func (b *faultyBucket) Exists(ctx context.Context, name string) (bool, error) {
+	if err := b.injector.inject(ctx, TargetBucket, "exists"); err != nil {
+		return false, err
+	}
+	return b.Bucket.Exists(ctx, name)
+}
+
+func (b *faultyBucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	if err := b.injector.inject(ctx, TargetBucket, "attributes"); err != nil {
+		return objstore.ObjectAttributes{}, err
+	}
+	return b.Bucket.Attributes(ctx, name)
+}
+
+// KV wraps the KV client so that the faults of the kv target are injected into its
+// operations: get, list, cas and delete. The watches are left untouched.
+func (i *Injector) KV(client kv.Client) kv.Client {
+	return &faultyKV{Client: client, injector: i}
+}
+
+type faultyKV struct {
+	kv.Client
+	injector *Injector
+}
+
+func (c *faultyKV) Get(ctx context.Context, key string) (interface{}, error) {
+	if err := c.injector.inject(ctx, TargetKV, "get"); err != nil {
+		return nil, err
+	}
+	return c.Client.Get(ctx, key)
+}
+
+func (c *faultyKV) List(ctx context.Context, prefix string) ([]string, error) {
+	if err := c.injector.inject(ctx, TargetKV, "list"); err != nil {
+		return nil, err
+	}
+	return c.Client.List(ctx, prefix)
+}
+
+func (c *faultyKV) CAS(ctx context.Context, key string, f func(in interface{}) (out interface{}, retry bool, err error)) error {
+	if err := c.injector.inject(ctx, TargetKV, "cas"); err != nil {
+		return err
+	}
+	return c.Client.CAS(ctx, key, f)
+}
+
+func (c *faultyKV) Delete(ctx context.Context, key string) error {
+	if err := c.injector.inject(ctx, TargetKV, "delete"); err != nil {
+		return err
+	}
+	return c.Client.Delete(ctx, key)
+}
+
+// WALMiddleware injects the faults of the wal target, under the append operation, into the
+// pushes to the ingester, which appends the series to the TSDB head and its WAL.
+func (i *Injector) WALMiddleware() push.Middleware {
+	return func(next push.Func) push.Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			if err := i.inject(ctx, TargetWAL, "append"); err != nil {
+				return nil, err
+			}
+			return next(ctx, req)
+		}
+	}
+}
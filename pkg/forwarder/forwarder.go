@@ -0,0 +1,363 @@
+// Package forwarder implements the agent mode: the pushed series are only written to a local
+// WAL, and forwarded from it to a remote ingester or distributor, so that a resource
+// constrained node durably buffers the series without ever building blocks.
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+	"go.uber.org/atomic"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/backoff"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+// segmentSize is the size of the WAL segments. The segments are deleted once forwarded, so
+// they're kept small to release the disk space early.
+const segmentSize = 8 * 1024 * 1024
+
+var (
+	errMissingURL     = errors.New("the forwarder URL has not been configured")
+	errInvalidTimeout = errors.New("the forwarder timeout must be greater than 0")
+	errInvalidBackoff = errors.New("the forwarder min backoff must be greater than 0 and lower than the max backoff")
+	errInvalidWALSize = errors.New("the forwarder max WAL size must be 0 or at least the size of a WAL segment")
+	errWALFull        = errors.New("the forwarder WAL is full, the series are forwarded slower than they are pushed")
+)
+
+// Config holds the configuration of the forwarder.
+type Config struct {
+	Enabled         bool          `yaml:"enabled"`
+	URL             string        `yaml:"url"`
+	WALDir          string        `yaml:"wal_dir"`
+	MaxWALSizeBytes int64         `yaml:"max_wal_size_bytes"`
+	Timeout         time.Duration `yaml:"timeout"`
+	MinBackoff      time.Duration `yaml:"min_backoff"`
+	MaxBackoff      time.Duration `yaml:"max_backoff"`
+}
+
+// RegisterFlags registers the forwarder flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "forwarder.enabled", false, "True to run in agent mode: the pushed series are only written to a local WAL and forwarded to the remote write endpoint, no block being built locally.")
+	f.StringVar(&cfg.URL, "forwarder.url", "", "Remote write endpoint of the ingester or distributor the series are forwarded to, like http://distributor/api/v1/push. The series are forwarded with their tenant in the X-Scope-OrgID header.")
+	f.StringVar(&cfg.WALDir, "forwarder.wal-dir", "", "Directory of the WAL the pushed series are written to until forwarded. Defaults to the forwarder-wal directory in the TSDB directory.")
+	f.Int64Var(&cfg.MaxWALSizeBytes, "forwarder.max-wal-size-bytes", 1<<30, "Max size in bytes of the series not forwarded yet. The pushes are rejected while the WAL is full, so that the clients retry them later. 0 to disable.")
+	f.DurationVar(&cfg.Timeout, "forwarder.timeout", 30*time.Second, "Timeout of each forward request.")
+	f.DurationVar(&cfg.MinBackoff, "forwarder.min-backoff", 100*time.Millisecond, "Minimum backoff of the retries of a failed forward request. The requests failed because of the network, a server error or a rate limit are retried until they succeed, while the other rejected requests are dropped.")
+	f.DurationVar(&cfg.MaxBackoff, "forwarder.max-backoff", 30*time.Second, "Maximum backoff of the retries of a failed forward request.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.URL == "" {
+		return errMissingURL
+	}
+	if cfg.Timeout <= 0 {
+		return errInvalidTimeout
+	}
+	if cfg.MinBackoff <= 0 || cfg.MinBackoff > cfg.MaxBackoff {
+		return errInvalidBackoff
+	}
+	if cfg.MaxWALSizeBytes < 0 || (cfg.MaxWALSizeBytes > 0 && cfg.MaxWALSizeBytes < segmentSize) {
+		return errInvalidWALSize
+	}
+	return nil
+}
+
+// Forwarder writes the pushed series to the WAL, and forwards them in background, in order,
+// one request per push. The series are forwarded at least once: the requests forwarded
+// right before a crash are forwarded again on restart.
+type Forwarder struct {
+	services.Service
+
+	cfg    Config
+	dir    string
+	client *http.Client
+	logger log.Logger
+	reg    prometheus.Registerer
+
+	wal           *wlog.WL
+	readerMetrics *wlog.LiveReaderMetrics
+	pos           position
+
+	// written is notified when a record is written, to wake up the forwarding.
+	written chan struct{}
+
+	// pendingSegments is the number of segments not fully forwarded yet.
+	pendingSegments *atomic.Int64
+
+	backoff backoff.Config
+
+	writtenRecords    prometheus.Counter
+	rejectedPushes    prometheus.Counter
+	forwardedRequests prometheus.Counter
+	droppedRequests   prometheus.Counter
+	failedRequests    prometheus.Counter
+	pendingGauge      prometheus.Gauge
+}
+
+// NewForwarder makes a new Forwarder, writing its WAL in dir.
+func NewForwarder(cfg Config, dir string, logger log.Logger, reg prometheus.Registerer) *Forwarder {
+	f := &Forwarder{
+		cfg:             cfg,
+		dir:             dir,
+		client:          &http.Client{Timeout: cfg.Timeout},
+		logger:          logger,
+		reg:             reg,
+		written:         make(chan struct{}, 1),
+		pendingSegments: atomic.NewInt64(0),
+		backoff:         backoff.Config{MinBackoff: cfg.MinBackoff, MaxBackoff: cfg.MaxBackoff},
+		writtenRecords: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_forwarder_wal_records_written_total",
+			Help: "Total number of push requests written to the forwarder WAL.",
+		}),
+		rejectedPushes: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_forwarder_wal_full_rejected_pushes_total",
+			Help: "Total number of push requests rejected because the forwarder WAL was full.",
+		}),
+		forwardedRequests: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_forwarder_forwarded_requests_total",
+			Help: "Total number of requests forwarded to the remote write endpoint.",
+		}),
+		droppedRequests: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_forwarder_dropped_requests_total",
+			Help: "Total number of requests rejected by the remote write endpoint with a client error, which are dropped.",
+		}),
+		failedRequests: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_forwarder_failed_requests_total",
+			Help: "Total number of forward requests which failed and have been retried.",
+		}),
+		pendingGauge: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_forwarder_wal_pending_segments",
+			Help: "Number of WAL segments not fully forwarded yet.",
+		}),
+	}
+
+	f.Service = services.NewBasicService(f.starting, f.running, f.stopping)
+	return f
+}
+
+func (f *Forwarder) starting(_ context.Context) error {
+	// The WAL metrics are prefixed, not to clash with the ones of the TSDB.
+	walReg := prometheus.WrapRegistererWithPrefix("cortex_forwarder_", f.reg)
+
+	wal, err := wlog.NewSize(log.With(f.logger, "component", "forwarder-wal"), walReg, f.dir, segmentSize, true)
+	if err != nil {
+		return errors.Wrap(err, "open the forwarder WAL")
+	}
+	f.wal = wal
+	f.readerMetrics = wlog.NewLiveReaderMetrics(walReg)
+
+	first, last, err := wlog.Segments(f.dir)
+	if err != nil {
+		return errors.Wrap(err, "list the forwarder WAL segments")
+	}
+
+	// The forwarding resumes from the last persisted position, unless its segment has been
+	// deleted.
+	f.pos = position{segment: first}
+	pos, err := readPositionFile(f.dir)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		level.Warn(f.logger).Log("msg", "failed to read the forwarded position, forwarding the WAL from the start", "err", err)
+	case pos.segment >= first && pos.segment <= last:
+		f.pos = pos
+		level.Info(f.logger).Log("msg", "resuming forwarding from the last forwarded position", "segment", pos.segment, "records", pos.records)
+	}
+	f.setPendingSegments(last)
+	return nil
+}
+
+func (f *Forwarder) stopping(_ error) error {
+	if f.wal == nil {
+		return nil
+	}
+	if err := writePositionFile(f.dir, f.pos); err != nil {
+		level.Warn(f.logger).Log("msg", "failed to persist the forwarded position", "err", err)
+	}
+	return f.wal.Close()
+}
+
+// Push writes the request to the WAL. The request is rejected if the WAL is full.
+func (f *Forwarder) Push(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+	userID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxSegments := f.cfg.MaxWALSizeBytes / segmentSize; maxSegments > 0 && f.pendingSegments.Load() > maxSegments {
+		f.rejectedPushes.Inc()
+		return nil, httpgrpc.Errorf(http.StatusServiceUnavailable, errWALFull.Error())
+	}
+
+	buf, err := req.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if err := f.wal.Log(encodeRecord(userID, buf)); err != nil {
+		return nil, errors.Wrap(err, "write the forwarder WAL")
+	}
+	f.writtenRecords.Inc()
+
+	select {
+	case f.written <- struct{}{}:
+	default:
+	}
+	return &cortexpb.WriteResponse{}, nil
+}
+
+func (f *Forwarder) running(ctx context.Context) error {
+	for ctx.Err() == nil {
+		if err := f.forwardSegment(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forwardSegment forwards the records of the current segment, tailing it until the WAL moves
+// to the next segment. The forwarded segment is then deleted.
+func (f *Forwarder) forwardSegment(ctx context.Context) error {
+	segment, err := wlog.OpenReadSegment(wlog.SegmentName(f.dir, f.pos.segment))
+	if err != nil {
+		return errors.Wrap(err, "open the forwarder WAL segment")
+	}
+	defer segment.Close()
+
+	reader := wlog.NewLiveReader(f.logger, f.readerMetrics, segment)
+	read := 0
+
+	for {
+		// The segment is complete once the WAL moved to the next one. It's checked before
+		// reading, so that the records written before the move are read.
+		_, last, err := wlog.Segments(f.dir)
+		if err != nil {
+			return errors.Wrap(err, "list the forwarder WAL segments")
+		}
+		f.setPendingSegments(last)
+		complete := last > f.pos.segment
+
+		for reader.Next() {
+			read++
+			// The records already forwarded before a restart are skipped.
+			if read <= f.pos.records {
+				continue
+			}
+			if !f.forward(ctx, reader.Record()) {
+				return nil
+			}
+			f.pos.records = read
+		}
+		if err := reader.Err(); err != nil && err != io.EOF {
+			// A segment torn by a crash is complete, since the WAL moves to a new segment
+			// when reopened, so its remaining records are skipped.
+			if !complete {
+				return errors.Wrapf(err, "read the forwarder WAL segment %d", f.pos.segment)
+			}
+			level.Warn(f.logger).Log("msg", "skipping the rest of the corrupted WAL segment", "segment", f.pos.segment, "err", err)
+		}
+
+		if complete {
+			f.pos = position{segment: f.pos.segment + 1}
+			if err := f.wal.Truncate(f.pos.segment); err != nil {
+				level.Warn(f.logger).Log("msg", "failed to delete the forwarded WAL segments", "err", err)
+			}
+		}
+
+		// The position is persisted once caught up, so that a restart forwards again
+		// the least records.
+		if err := writePositionFile(f.dir, f.pos); err != nil {
+			level.Warn(f.logger).Log("msg", "failed to persist the forwarded position", "err", err)
+		}
+		if complete {
+			return nil
+		}
+
+		select {
+		case <-f.written:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (f *Forwarder) setPendingSegments(last int) {
+	pending := int64(last - f.pos.segment + 1)
+	f.pendingSegments.Store(pending)
+	f.pendingGauge.Set(float64(pending))
+}
+
+// forward forwards the WAL record, retrying with backoff the requests failed because of the
+// network, a server error or a rate limit. The requests rejected with another client error
+// would never succeed, so they're dropped. It returns false if the context is canceled.
+func (f *Forwarder) forward(ctx context.Context, rec []byte) bool {
+	userID, req, err := decodeRecord(rec)
+	if err != nil {
+		f.droppedRequests.Inc()
+		level.Warn(f.logger).Log("msg", "dropping the WAL record which can't be decoded", "segment", f.pos.segment, "err", err)
+		return true
+	}
+	body := snappy.Encode(nil, req)
+
+	retries := backoff.New(ctx, f.backoff)
+	for retries.Ongoing() {
+		code, err := f.send(ctx, userID, body)
+		switch {
+		case err == nil:
+			f.forwardedRequests.Inc()
+			return true
+		case code/100 == 4 && code != http.StatusTooManyRequests:
+			f.droppedRequests.Inc()
+			level.Warn(f.logger).Log("msg", "dropping the request rejected by the remote write endpoint", "tenant", userID, "err", err)
+			return true
+		}
+
+		f.failedRequests.Inc()
+		level.Warn(f.logger).Log("msg", "failed to forward the request, retrying", "tenant", userID, "err", err)
+		retries.Wait()
+	}
+	return false
+}
+
+// send posts the snappy-encoded write request, returning the response status code if any.
+func (f *Forwarder) send(ctx context.Context, userID string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("X-Scope-OrgID", userID)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp.StatusCode, errors.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp.StatusCode, nil
+}
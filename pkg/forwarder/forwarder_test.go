@@ -0,0 +1,266 @@
+package forwarder
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *Config)
+		expected error
+	}{
+		"disabled": {
+			setup:    func(*Config) {},
+			expected: nil,
+		},
+		"enabled": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.URL = "http://distributor/api/v1/push"
+			},
+			expected: nil,
+		},
+		"missing URL": {
+			setup:    func(cfg *Config) { cfg.Enabled = true },
+			expected: errMissingURL,
+		},
+		"invalid timeout": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.URL = "http://distributor/api/v1/push"
+				cfg.Timeout = 0
+			},
+			expected: errInvalidTimeout,
+		},
+		"min backoff greater than the max backoff": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.URL = "http://distributor/api/v1/push"
+				cfg.MinBackoff = time.Minute
+			},
+			expected: errInvalidBackoff,
+		},
+		"unlimited WAL size": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.URL = "http://distributor/api/v1/push"
+				cfg.MaxWALSizeBytes = 0
+			},
+			expected: nil,
+		},
+		"max WAL size lower than a segment": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.URL = "http://distributor/api/v1/push"
+				cfg.MaxWALSizeBytes = 1024
+			},
+			expected: errInvalidWALSize,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+			assert.ErrorIs(t, cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+// remoteMock records the series received, by tenant, failing the first requests with the
+// configured status code.
+type remoteMock struct {
+	mtx      sync.Mutex
+	failures int
+	status   int
+	requests int
+	series   map[string][]string
+}
+
+func (m *remoteMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.requests++
+	if m.failures > 0 {
+		m.failures--
+		w.WriteHeader(m.status)
+		return
+	}
+
+	compressed, _ := io.ReadAll(r.Body)
+	buf, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req := cortexpb.WriteRequest{}
+	if err := req.Unmarshal(buf); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if m.series == nil {
+		m.series = map[string][]string{}
+	}
+	userID := r.Header.Get("X-Scope-OrgID")
+	for _, ts := range req.Timeseries {
+		m.series[userID] = append(m.series[userID], cortexpb.FromLabelAdaptersToLabels(ts.Labels).String())
+	}
+}
+
+func (m *remoteMock) received(userID string) []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return append([]string(nil), m.series[userID]...)
+}
+
+func newTestForwarder(t *testing.T, url, dir string) *Forwarder {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Enabled = true
+	cfg.URL = url
+	cfg.MinBackoff = time.Millisecond
+	cfg.MaxBackoff = time.Millisecond
+	require.NoError(t, cfg.Validate())
+
+	return NewForwarder(cfg, dir, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+}
+
+func push(t *testing.T, f *Forwarder, userID, metric string) {
+	req := &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+		Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: metric}},
+		Samples: []cortexpb.Sample{{TimestampMs: 1, Value: 1}},
+	}}}}
+	_, err := f.Push(user.InjectOrgID(context.Background(), userID), req)
+	require.NoError(t, err)
+}
+
+func TestForwarder(t *testing.T) {
+	remote := &remoteMock{failures: 2, status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(remote)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	f := newTestForwarder(t, srv.URL, dir)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), f))
+
+	push(t, f, "user-1", "series_1")
+	push(t, f, "user-2", "series_2")
+	push(t, f, "user-1", "series_3")
+
+	// The series are forwarded in order, the server errors being retried.
+	require.Eventually(t, func() bool { return len(remote.received("user-1")) == 2 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{`{__name__="series_1"}`, `{__name__="series_3"}`}, remote.received("user-1"))
+	assert.Equal(t, []string{`{__name__="series_2"}`}, remote.received("user-2"))
+	assert.Equal(t, float64(3), testutil.ToFloat64(f.forwardedRequests))
+	assert.Equal(t, float64(2), testutil.ToFloat64(f.failedRequests))
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), f))
+
+	// The forwarded series aren't forwarded again on restart, and the forwarded segments
+	// are deleted.
+	f = newTestForwarder(t, srv.URL, dir)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), f))
+	push(t, f, "user-1", "series_4")
+
+	require.Eventually(t, func() bool { return len(remote.received("user-1")) == 3 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, `{__name__="series_4"}`, remote.received("user-1")[2])
+	require.Eventually(t, func() bool {
+		first, _, err := wlog.Segments(dir)
+		return err == nil && first > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), f))
+
+	// The 2 failed requests, the 3 forwarded before the restart and the one after.
+	assert.Equal(t, 6, remote.requests)
+}
+
+func TestForwarder_ResumesFromTheLastForwardedPosition(t *testing.T) {
+	remote := &remoteMock{}
+	srv := httptest.NewServer(remote)
+	defer srv.Close()
+
+	// The series are written while the remote is unreachable.
+	dir := t.TempDir()
+	f := newTestForwarder(t, "http://127.0.0.1:0/api/v1/push", dir)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), f))
+	push(t, f, "user-1", "series_1")
+	push(t, f, "user-1", "series_2")
+	require.Eventually(t, func() bool { return testutil.ToFloat64(f.failedRequests) > 0 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), f))
+
+	f = newTestForwarder(t, srv.URL, dir)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), f))
+	defer func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), f))
+	}()
+
+	require.Eventually(t, func() bool { return len(remote.received("user-1")) == 2 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{`{__name__="series_1"}`, `{__name__="series_2"}`}, remote.received("user-1"))
+}
+
+func TestForwarder_DropsTheRejectedRequests(t *testing.T) {
+	remote := &remoteMock{failures: 1, status: http.StatusBadRequest}
+	srv := httptest.NewServer(remote)
+	defer srv.Close()
+
+	f := newTestForwarder(t, srv.URL, t.TempDir())
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), f))
+	defer func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), f))
+	}()
+
+	push(t, f, "user-1", "series_1")
+	push(t, f, "user-1", "series_2")
+
+	require.Eventually(t, func() bool { return len(remote.received("user-1")) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{`{__name__="series_2"}`}, remote.received("user-1"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(f.droppedRequests))
+}
+
+func TestForwarder_RejectsThePushesWhenTheWALIsFull(t *testing.T) {
+	f := newTestForwarder(t, "http://127.0.0.1:0/api/v1/push", t.TempDir())
+	f.cfg.MaxWALSizeBytes = segmentSize
+	require.NoError(t, f.starting(context.Background()))
+	defer f.wal.Close()
+
+	push(t, f, "user-1", "series_1")
+
+	f.pendingSegments.Store(2)
+	_, err := f.Push(user.InjectOrgID(context.Background(), "user-1"), &cortexpb.WriteRequest{})
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	require.True(t, ok)
+	assert.Equal(t, int32(http.StatusServiceUnavailable), resp.Code)
+	assert.Equal(t, float64(1), testutil.ToFloat64(f.rejectedPushes))
+}
+
+func TestRecord(t *testing.T) {
+	userID, req, err := decodeRecord(encodeRecord("user-1", []byte("request")))
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", userID)
+	assert.Equal(t, []byte("request"), req)
+
+	_, _, err = decodeRecord([]byte{10, 'a'})
+	assert.ErrorIs(t, err, errInvalidRecord)
+}
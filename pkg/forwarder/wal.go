@@ -0,0 +1,62 @@
+package forwarder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const positionFilename = "forwarded"
+
+var errInvalidRecord = errors.New("invalid WAL record")
+
+// encodeRecord encodes the write request of the tenant as a WAL record: the tenant, prefixed
+// by its length, followed by the marshalled request.
+func encodeRecord(userID string, req []byte) []byte {
+	rec := make([]byte, 0, binary.MaxVarintLen64+len(userID)+len(req))
+	rec = binary.AppendUvarint(rec, uint64(len(userID)))
+	rec = append(rec, userID...)
+	return append(rec, req...)
+}
+
+// decodeRecord returns the tenant and the marshalled write request of the WAL record.
+func decodeRecord(rec []byte) (string, []byte, error) {
+	size, n := binary.Uvarint(rec)
+	if n <= 0 || uint64(len(rec)-n) < size {
+		return "", nil, errInvalidRecord
+	}
+	return string(rec[n : n+int(size)]), rec[n+int(size):], nil
+}
+
+// position is the position of the next record to forward: the segment, and the number of
+// records of the segment already forwarded.
+type position struct {
+	segment int
+	records int
+}
+
+func readPositionFile(dir string) (position, error) {
+	data, err := os.ReadFile(filepath.Join(dir, positionFilename))
+	if err != nil {
+		return position{}, err
+	}
+
+	var pos position
+	if _, err := fmt.Sscanf(string(data), "%d %d", &pos.segment, &pos.records); err != nil {
+		return position{}, errors.Wrap(err, "parse the forwarded position")
+	}
+	return pos, nil
+}
+
+// writePositionFile atomically persists the input position.
+func writePositionFile(dir string, pos position) error {
+	path := filepath.Join(dir, positionFilename)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(fmt.Sprintf("%d %d", pos.segment, pos.records)), 0o666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
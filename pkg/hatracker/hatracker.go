@@ -0,0 +1,367 @@
+package hatracker
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/push"
+	"objectstorage/pkg/tenant"
+)
+
+var (
+	errInvalidFailoverTimeout = errors.New("the HA tracker failover timeout must be greater than the update timeout plus 1s")
+	errUnsupportedKVStore     = errors.New("the HA tracker requires a KV store supporting CAS (consul, etcd or inmemory), memberlist is not supported")
+)
+
+// Config holds the configuration of the HA tracker.
+type Config struct {
+	Enabled         bool          `yaml:"enabled"`
+	KVStore         kv.Config     `yaml:"kvstore" doc:"description=The key-value store storing the elected replica of each HA cluster."`
+	UpdateTimeout   time.Duration `yaml:"update_timeout"`
+	FailoverTimeout time.Duration `yaml:"failover_timeout"`
+}
+
+// RegisterFlags registers the HA tracker flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "ha-tracker.enabled", false, "Enable the HA tracker, to deduplicate samples written by pairs of Prometheus replicas scraping the same targets. Tenants must also opt in via the accept_ha_samples limit.")
+	cfg.KVStore.RegisterFlagsWithPrefix("ha-tracker.", "ha-tracker/", f)
+	f.DurationVar(&cfg.UpdateTimeout, "ha-tracker.update-timeout", 15*time.Second, "How frequently the timestamp of the elected replica is updated in the KV store.")
+	f.DurationVar(&cfg.FailoverTimeout, "ha-tracker.failover-timeout", 30*time.Second, "How long to wait without receiving samples from the elected replica before electing another replica of the same cluster.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.KVStore.Store == "memberlist" {
+		return errUnsupportedKVStore
+	}
+	if cfg.FailoverTimeout < cfg.UpdateTimeout+time.Second {
+		return errInvalidFailoverTimeout
+	}
+	return nil
+}
+
+// Limits is the subset of the per-tenant overrides used by the HA tracker. It's implemented
+// by validation.Overrides.
+type Limits interface {
+	AcceptHASamples(userID string) bool
+	HAClusterLabel(userID string) string
+	HAReplicaLabel(userID string) string
+	MaxHAClusters(userID string) int
+}
+
+// ReplicaDesc is the value stored in the KV store for each HA cluster.
+type ReplicaDesc struct {
+	Replica    string `json:"replica"`
+	ReceivedAt int64  `json:"received_at"`
+}
+
+// ReplicaDescCodec is the codec used to store the elected replicas in the KV store.
+type ReplicaDescCodec struct{}
+
+// CodecID implements codec.Codec.
+func (ReplicaDescCodec) CodecID() string { return "haTrackerReplicaDesc" }
+
+// Decode implements codec.Codec.
+func (ReplicaDescCodec) Decode(data []byte) (interface{}, error) {
+	desc := &ReplicaDesc{}
+	if err := json.Unmarshal(data, desc); err != nil {
+		return nil, err
+	}
+	return desc, nil
+}
+
+// Encode implements codec.Codec.
+func (ReplicaDescCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// DecodeMultiKey implements codec.Codec. The replicas are stored under a single key.
+func (ReplicaDescCodec) DecodeMultiKey(map[string][]byte) (interface{}, error) {
+	return nil, errors.New("the HA tracker replica codec doesn't support multi-key values")
+}
+
+// EncodeMultiKey implements codec.Codec.
+func (ReplicaDescCodec) EncodeMultiKey(interface{}) (map[string][]byte, error) {
+	return nil, errors.New("the HA tracker replica codec doesn't support multi-key values")
+}
+
+// NewKVClient makes the KV client used by the HA tracker.
+func NewKVClient(cfg Config, logger log.Logger, reg prometheus.Registerer) (kv.Client, error) {
+	return kv.NewClient(cfg.KVStore, ReplicaDescCodec{}, kv.RegistererWithKVName(reg, "ha-tracker"), logger)
+}
+
+// replicasNotMatchError is returned when the samples come from a replica which is not the
+// elected one, and must be dropped.
+type replicasNotMatchError struct {
+	replica, elected string
+}
+
+func (e replicasNotMatchError) Error() string {
+	return fmt.Sprintf("replicas did not match, rejecting sample: replica=%s, elected=%s", e.replica, e.elected)
+}
+
+// tooManyClustersError is returned when a tenant exceeds the max number of HA clusters.
+type tooManyClustersError struct {
+	limit int
+}
+
+func (e tooManyClustersError) Error() string {
+	return fmt.Sprintf("too many HA clusters (limit: %d)", e.limit)
+}
+
+// Tracker elects, for each HA cluster of each tenant, the replica whose samples are accepted.
+// The elected replica is stored in the KV store, so that all instances agree on it, and
+// changes once it hasn't sent samples for the failover timeout.
+type Tracker struct {
+	services.Service
+
+	cfg    Config
+	limits Limits
+	client kv.Client
+	logger log.Logger
+
+	mtx      sync.RWMutex
+	elected  map[string]ReplicaDesc
+	clusters map[string]map[string]struct{}
+
+	electedReplicaChanges   *prometheus.CounterVec
+	electedReplicaTimestamp *prometheus.GaugeVec
+	kvCASCalls              *prometheus.CounterVec
+	dedupedSamples          *prometheus.CounterVec
+}
+
+// NewTracker makes a new Tracker.
+func NewTracker(cfg Config, limits Limits, client kv.Client, logger log.Logger, reg prometheus.Registerer) *Tracker {
+	t := &Tracker{
+		cfg:      cfg,
+		limits:   limits,
+		client:   client,
+		logger:   logger,
+		elected:  map[string]ReplicaDesc{},
+		clusters: map[string]map[string]struct{}{},
+		electedReplicaChanges: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_ha_tracker_elected_replica_changes_total",
+			Help: "The total number of times the elected replica has changed for a user ID/cluster.",
+		}, []string{"user", "cluster"}),
+		electedReplicaTimestamp: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_ha_tracker_elected_replica_timestamp_seconds",
+			Help: "The timestamp stored for the currently elected replica, from the KV store.",
+		}, []string{"user", "cluster"}),
+		kvCASCalls: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_ha_tracker_kv_store_cas_total",
+			Help: "The total number of CAS calls to the KV store for a user ID/cluster.",
+		}, []string{"user", "cluster"}),
+		dedupedSamples: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_ha_tracker_deduped_samples_total",
+			Help: "The total number of deduplicated samples, sent by non-elected replicas.",
+		}, []string{"user", "cluster"}),
+	}
+
+	t.Service = services.NewBasicService(nil, t.running, nil)
+	return t
+}
+
+// running keeps the local cache of the elected replicas up to date with the KV store.
+func (t *Tracker) running(ctx context.Context) error {
+	t.client.WatchPrefix(ctx, "", func(key string, value interface{}) bool {
+		desc, ok := value.(*ReplicaDesc)
+		if !ok || desc == nil {
+			return true
+		}
+
+		userID, cluster, ok := parseKey(key)
+		if !ok {
+			return true
+		}
+
+		t.updateCache(userID, cluster, *desc)
+		return true
+	})
+	return nil
+}
+
+// CheckReplica returns nil if the samples of the input replica must be accepted, or a
+// replicasNotMatchError if another replica of the cluster is elected.
+func (t *Tracker) CheckReplica(ctx context.Context, userID, cluster, replica string, now time.Time) error {
+	key := makeKey(userID, cluster)
+
+	t.mtx.RLock()
+	entry, ok := t.elected[key]
+	numClusters := len(t.clusters[userID])
+	t.mtx.RUnlock()
+
+	if ok {
+		// Fast path: the replica is the elected one and its timestamp is recent enough.
+		if entry.Replica == replica && now.Sub(time.UnixMilli(entry.ReceivedAt)) < t.cfg.UpdateTimeout {
+			return nil
+		}
+	} else if limit := t.limits.MaxHAClusters(userID); limit > 0 && numClusters >= limit {
+		return tooManyClustersError{limit: limit}
+	}
+
+	return t.checkKVStore(ctx, userID, cluster, replica, now)
+}
+
+func (t *Tracker) checkKVStore(ctx context.Context, userID, cluster, replica string, now time.Time) error {
+	var (
+		key     = makeKey(userID, cluster)
+		elected ReplicaDesc
+		changed bool
+	)
+
+	t.kvCASCalls.WithLabelValues(userID, cluster).Inc()
+	err := t.client.CAS(ctx, key, func(in interface{}) (out interface{}, retry bool, err error) {
+		desc, _ := in.(*ReplicaDesc)
+		changed = false
+
+		if desc != nil {
+			receivedAt := time.UnixMilli(desc.ReceivedAt)
+
+			// The replica is the elected one: just refresh the timestamp, if needed.
+			if desc.Replica == replica {
+				elected = *desc
+				if now.Sub(receivedAt) < t.cfg.UpdateTimeout {
+					return nil, false, nil
+				}
+				elected.ReceivedAt = now.UnixMilli()
+				return &elected, true, nil
+			}
+
+			// Another replica is elected and still sending samples.
+			if now.Sub(receivedAt) < t.cfg.FailoverTimeout {
+				elected = *desc
+				return nil, false, nil
+			}
+		}
+
+		changed = desc != nil
+		elected = ReplicaDesc{Replica: replica, ReceivedAt: now.UnixMilli()}
+		return &elected, true, nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "update HA tracker KV store")
+	}
+
+	if changed {
+		t.electedReplicaChanges.WithLabelValues(userID, cluster).Inc()
+		level.Info(t.logger).Log("msg", "elected new HA replica", "tenant", userID, "cluster", cluster, "replica", replica)
+	}
+	t.updateCache(userID, cluster, elected)
+
+	if elected.Replica != replica {
+		return replicasNotMatchError{replica: replica, elected: elected.Replica}
+	}
+	return nil
+}
+
+func (t *Tracker) updateCache(userID, cluster string, desc ReplicaDesc) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.elected[makeKey(userID, cluster)] = desc
+	if t.clusters[userID] == nil {
+		t.clusters[userID] = map[string]struct{}{}
+	}
+	t.clusters[userID][cluster] = struct{}{}
+
+	t.electedReplicaTimestamp.WithLabelValues(userID, cluster).Set(float64(desc.ReceivedAt) / 1000)
+}
+
+// PushMiddleware returns a push.Middleware dropping the samples of non-elected replicas,
+// and removing the replica label from the accepted ones.
+func (t *Tracker) PushMiddleware() push.Middleware {
+	return func(next push.Func) push.Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			userID, err := tenant.TenantID(ctx)
+			if err != nil {
+				return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+			}
+
+			if !t.limits.AcceptHASamples(userID) || len(req.Timeseries) == 0 {
+				return next(ctx, req)
+			}
+
+			clusterLabel, replicaLabel := t.limits.HAClusterLabel(userID), t.limits.HAReplicaLabel(userID)
+			cluster, replica := findHALabels(clusterLabel, replicaLabel, req.Timeseries[0].Labels)
+			if cluster == "" || replica == "" {
+				return next(ctx, req)
+			}
+
+			err = t.CheckReplica(ctx, userID, cluster, replica, time.Now())
+			switch err.(type) {
+			case nil:
+			case replicasNotMatchError:
+				samples := 0
+				for _, ts := range req.Timeseries {
+					samples += len(ts.Samples)
+				}
+				t.dedupedSamples.WithLabelValues(userID, cluster).Add(float64(samples))
+
+				// Return a 2xx, so that the non-elected replica doesn't retry.
+				return nil, httpgrpc.Errorf(http.StatusAccepted, err.Error())
+			case tooManyClustersError:
+				return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+			default:
+				return nil, err
+			}
+
+			for i := range req.Timeseries {
+				req.Timeseries[i].Labels = removeLabel(replicaLabel, req.Timeseries[i].Labels)
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+func findHALabels(clusterLabel, replicaLabel string, lbls []cortexpb.LabelAdapter) (cluster, replica string) {
+	for _, l := range lbls {
+		switch l.Name {
+		case clusterLabel:
+			cluster = l.Value
+		case replicaLabel:
+			replica = l.Value
+		}
+	}
+	return
+}
+
+func removeLabel(name string, lbls []cortexpb.LabelAdapter) []cortexpb.LabelAdapter {
+	for i, l := range lbls {
+		if l.Name == name {
+			return append(lbls[:i:i], lbls[i+1:]...)
+		}
+	}
+	return lbls
+}
+
+func makeKey(userID, cluster string) string {
+	return userID + "/" + cluster
+}
+
+func parseKey(key string) (userID, cluster string, ok bool) {
+	idx := strings.Index(key, "/")
+	if idx < 0 {
+		return "", "", false
+	}
+	return key[:idx], key[idx+1:], true
+}
@@ -0,0 +1,137 @@
+package hatracker
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+)
+
+type limitsMock struct {
+	maxClusters int
+}
+
+func (limitsMock) AcceptHASamples(string) bool  { return true }
+func (limitsMock) HAClusterLabel(string) string { return "cluster" }
+func (limitsMock) HAReplicaLabel(string) string { return "__replica__" }
+func (m limitsMock) MaxHAClusters(string) int   { return m.maxClusters }
+
+func newTracker(t *testing.T, limits Limits) *Tracker {
+	client, closer := consul.NewInMemoryClient(ReplicaDescCodec{}, log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	cfg := Config{Enabled: true, UpdateTimeout: time.Second, FailoverTimeout: 10 * time.Second}
+	return NewTracker(cfg, limits, client, log.NewNopLogger(), nil)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		cfg      Config
+		expected error
+	}{
+		"disabled": {
+			cfg:      Config{},
+			expected: nil,
+		},
+		"valid": {
+			cfg:      Config{Enabled: true, UpdateTimeout: time.Second, FailoverTimeout: 5 * time.Second},
+			expected: nil,
+		},
+		"failover timeout too low": {
+			cfg:      Config{Enabled: true, UpdateTimeout: 5 * time.Second, FailoverTimeout: 5 * time.Second},
+			expected: errInvalidFailoverTimeout,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.cfg.Validate())
+		})
+	}
+}
+
+func TestTracker_CheckReplica(t *testing.T) {
+	ctx := context.Background()
+	tracker := newTracker(t, limitsMock{})
+	now := time.Now()
+
+	// The first replica is elected.
+	require.NoError(t, tracker.CheckReplica(ctx, "user-1", "cluster-1", "replica-1", now))
+	assert.IsType(t, replicasNotMatchError{}, tracker.CheckReplica(ctx, "user-1", "cluster-1", "replica-2", now))
+
+	// Other clusters and tenants are tracked separately.
+	require.NoError(t, tracker.CheckReplica(ctx, "user-1", "cluster-2", "replica-2", now))
+	require.NoError(t, tracker.CheckReplica(ctx, "user-2", "cluster-1", "replica-2", now))
+
+	// The elected replica keeps sending samples, so no failover happens.
+	now = now.Add(5 * time.Second)
+	require.NoError(t, tracker.CheckReplica(ctx, "user-1", "cluster-1", "replica-1", now))
+	now = now.Add(5 * time.Second)
+	assert.IsType(t, replicasNotMatchError{}, tracker.CheckReplica(ctx, "user-1", "cluster-1", "replica-2", now))
+
+	// The elected replica stops sending samples, so the other one takes over.
+	now = now.Add(11 * time.Second)
+	require.NoError(t, tracker.CheckReplica(ctx, "user-1", "cluster-1", "replica-2", now))
+	assert.IsType(t, replicasNotMatchError{}, tracker.CheckReplica(ctx, "user-1", "cluster-1", "replica-1", now))
+}
+
+func TestTracker_MaxClusters(t *testing.T) {
+	ctx := context.Background()
+	tracker := newTracker(t, limitsMock{maxClusters: 1})
+	now := time.Now()
+
+	require.NoError(t, tracker.CheckReplica(ctx, "user-1", "cluster-1", "replica-1", now))
+	assert.IsType(t, tooManyClustersError{}, tracker.CheckReplica(ctx, "user-1", "cluster-2", "replica-1", now))
+	require.NoError(t, tracker.CheckReplica(ctx, "user-2", "cluster-2", "replica-1", now))
+}
+
+func TestTracker_PushMiddleware(t *testing.T) {
+	tracker := newTracker(t, limitsMock{})
+	ctx := user.InjectOrgID(context.Background(), "user-1")
+
+	var pushed []*cortexpb.WriteRequest
+	f := tracker.PushMiddleware()(func(_ context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		pushed = append(pushed, req)
+		return &cortexpb.WriteResponse{}, nil
+	})
+
+	makeRequest := func(replica string) *cortexpb.WriteRequest {
+		return &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+			Labels: []cortexpb.LabelAdapter{
+				{Name: "__name__", Value: "up"},
+				{Name: "__replica__", Value: replica},
+				{Name: "cluster", Value: "cluster-1"},
+			},
+			Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}},
+		}}}}
+	}
+
+	_, err := f(ctx, makeRequest("replica-1"))
+	require.NoError(t, err)
+
+	_, err = f(ctx, makeRequest("replica-2"))
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	require.True(t, ok)
+	assert.Equal(t, int32(http.StatusAccepted), resp.Code)
+
+	// Series without the HA labels are accepted as is.
+	_, err = f(ctx, &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+		Labels: []cortexpb.LabelAdapter{{Name: "__name__", Value: "up"}},
+	}}}})
+	require.NoError(t, err)
+
+	require.Len(t, pushed, 2)
+	assert.Equal(t, []cortexpb.LabelAdapter{
+		{Name: "__name__", Value: "up"},
+		{Name: "cluster", Value: "cluster-1"},
+	}, pushed[0].Timeseries[0].Labels)
+}
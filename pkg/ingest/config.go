@@ -0,0 +1,121 @@
+package ingest
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cortexproject/cortex/pkg/util"
+
+	"objectstorage/pkg/util/dnsaddr"
+)
+
+const (
+	// ConsumeFromLastOffset resumes consuming from the last offset persisted locally.
+	ConsumeFromLastOffset = "last-offset"
+
+	// ConsumeFromStart consumes the partition from the earliest available offset.
+	ConsumeFromStart = "start"
+
+	// ConsumeFromEnd consumes only records produced after the reader started.
+	ConsumeFromEnd = "end"
+)
+
+var (
+	supportedConsumeFromPositions = []string{ConsumeFromLastOffset, ConsumeFromStart, ConsumeFromEnd}
+
+	errMissingKafkaAddress        = errors.New("the Kafka address has not been configured")
+	errMissingKafkaTopic          = errors.New("the Kafka topic has not been configured")
+	errInvalidPartitionsCount     = errors.New("the number of Kafka partitions must be greater than 0")
+	errInvalidConsumeFromPosition = errors.New("unsupported consume from position")
+	errInvalidConsumeBatchBytes   = errors.New("the consume batch size must be 0 or greater")
+	errInvalidDNSRefreshInterval  = errors.New("the DNS refresh interval must be greater than 0 when the Kafka address is resolved through DNS")
+)
+
+// Config holds the configuration of the Kafka-backed ingest storage.
+type Config struct {
+	Enabled bool        `yaml:"enabled"`
+	Kafka   KafkaConfig `yaml:"kafka"`
+}
+
+// RegisterFlags registers the ingest storage flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "ingest-storage.enabled", false, "[EXPERIMENTAL] True to write series to partitioned Kafka topics first and let ingesters consume their assigned partition, instead of writing to ingesters directly.")
+
+	cfg.Kafka.RegisterFlagsWithPrefix("ingest-storage.kafka.", f)
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	return cfg.Kafka.Validate()
+}
+
+// KafkaConfig holds the Kafka client configuration.
+type KafkaConfig struct {
+	Address          string        `yaml:"address"`
+	Topic            string        `yaml:"topic"`
+	ClientID         string        `yaml:"client_id"`
+	PartitionsCount  int           `yaml:"partitions_count"`
+	DialTimeout      time.Duration `yaml:"dial_timeout"`
+	WriteTimeout     time.Duration `yaml:"write_timeout"`
+	ConsumeFrom      string        `yaml:"consume_from_position"`
+	OffsetsDirectory string        `yaml:"offsets_directory"`
+	ConsumeBatchSize int           `yaml:"consume_batch_size_bytes"`
+
+	DNSRefreshInterval time.Duration `yaml:"dns_refresh_interval"`
+}
+
+// RegisterFlagsWithPrefix registers the Kafka client flags with the provided prefix.
+func (cfg *KafkaConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.StringVar(&cfg.Address, prefix+"address", "", "Comma-separated list of Kafka seed brokers, in host:port format. The addresses prefixed with dns+, dnssrv+ or dnssrvnoa+ are resolved through the respective DNS lookup, and re-resolved periodically.")
+	f.StringVar(&cfg.Topic, prefix+"topic", "", "The Kafka topic where series are written to and consumed from.")
+	f.StringVar(&cfg.ClientID, prefix+"client-id", "", "The client ID used when connecting to Kafka.")
+	f.IntVar(&cfg.PartitionsCount, prefix+"partitions-count", 1, "Number of partitions of the Kafka topic. Each ingester consumes the partition it's assigned in the partition ring, stored in the KV store of the ingesters ring, so there can't be more ingesters than partitions.")
+	f.DurationVar(&cfg.DialTimeout, prefix+"dial-timeout", 2*time.Second, "The maximum time allowed to open a connection to a Kafka broker.")
+	f.DurationVar(&cfg.WriteTimeout, prefix+"write-timeout", 10*time.Second, "How long to wait for an incoming write request to be successfully committed to the Kafka topic.")
+	f.StringVar(&cfg.ConsumeFrom, prefix+"consume-from-position", ConsumeFromLastOffset, fmt.Sprintf("From which position to start consuming the partition at startup. Supported values: %s.", strings.Join(supportedConsumeFromPositions, ", ")))
+	f.StringVar(&cfg.OffsetsDirectory, prefix+"offsets-directory", "", "Directory where the last consumed partition offset is persisted. Defaults to the TSDB directory when empty.")
+	f.IntVar(&cfg.ConsumeBatchSize, prefix+"consume-batch-size-bytes", 4<<20, "Maximum size in bytes of the consecutive records of a tenant pushed to the head at once. 0 to push each record on its own.")
+	f.DurationVar(&cfg.DNSRefreshInterval, prefix+"dns-refresh-interval", 30*time.Second, "How frequently the seed brokers prefixed with dns+, dnssrv+ or dnssrvnoa+ are re-resolved.")
+}
+
+// Validate the config.
+func (cfg *KafkaConfig) Validate() error {
+	if cfg.Address == "" {
+		return errMissingKafkaAddress
+	}
+	if cfg.Topic == "" {
+		return errMissingKafkaTopic
+	}
+	if cfg.PartitionsCount <= 0 {
+		return errInvalidPartitionsCount
+	}
+	if !util.StringsContain(supportedConsumeFromPositions, cfg.ConsumeFrom) {
+		return errInvalidConsumeFromPosition
+	}
+	if cfg.ConsumeBatchSize < 0 {
+		return errInvalidConsumeBatchBytes
+	}
+	if dnsaddr.IsDynamic(cfg.SeedBrokers()) && cfg.DNSRefreshInterval <= 0 {
+		return errInvalidDNSRefreshInterval
+	}
+	return nil
+}
+
+// SeedBrokers returns the list of Kafka seed brokers.
+func (cfg *KafkaConfig) SeedBrokers() []string {
+	var brokers []string
+	for _, addr := range strings.Split(cfg.Address, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			brokers = append(brokers, addr)
+		}
+	}
+	return brokers
+}
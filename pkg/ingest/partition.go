@@ -0,0 +1,102 @@
+package ingest
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+)
+
+// PartitionRingKey is the key of the partition ring in the KV store of the ingesters ring.
+const PartitionRingKey = "ingest-partitions"
+
+var errNoFreePartition = errors.New("all the partitions of the Kafka topic are owned by other ingesters")
+
+// AssignPartition returns the partition consumed by the input ingester, in [0, partitions).
+// The partitions are assigned in the partition ring, a ring.Desc where each ingester owns a
+// single token: its partition. An ingester keeps its partition across restarts, unless it's
+// beyond the number of partitions, otherwise it's assigned the lowest partition not owned
+// by any other ingester.
+func AssignPartition(ctx context.Context, kvClient kv.Client, ingesterID string, partitions int) (int32, error) {
+	var partitionID int32
+
+	err := kvClient.CAS(ctx, PartitionRingKey, func(in interface{}) (out interface{}, retry bool, err error) {
+		desc, _ := in.(*ring.Desc)
+		if desc == nil {
+			desc = ring.NewDesc()
+		}
+
+		if owned, ok := desc.Ingesters[ingesterID]; ok && len(owned.Tokens) == 1 && int(owned.Tokens[0]) < partitions {
+			partitionID = int32(owned.Tokens[0])
+			return nil, false, nil
+		}
+
+		taken := map[uint32]bool{}
+		for id, owner := range desc.Ingesters {
+			if id == ingesterID {
+				continue
+			}
+			for _, token := range owner.Tokens {
+				taken[token] = true
+			}
+		}
+
+		for partition := uint32(0); partition < uint32(partitions); partition++ {
+			if !taken[partition] {
+				partitionID = int32(partition)
+				desc.AddIngester(ingesterID, "", "", []uint32{partition}, ring.ACTIVE, time.Now())
+				return desc, true, nil
+			}
+		}
+
+		return nil, false, errNoFreePartition
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "assign a partition to ingester %s", ingesterID)
+	}
+
+	return partitionID, nil
+}
+
+// PartitionForSeries returns the partition a series of the given tenant must be written to.
+// All samples of a series always land in the same partition.
+func PartitionForSeries(userID string, labels []cortexpb.LabelAdapter, partitions int) int32 {
+	h := client.HashNew32()
+	h = client.HashAdd32(h, userID)
+	for _, l := range labels {
+		h = client.HashAdd32(h, l.Name)
+		h = client.HashAdd32(h, l.Value)
+	}
+	return int32(h % uint32(partitions))
+}
+
+// splitRequestByPartition splits the input request into one request per partition. Metadata
+// are written to partition 0 to keep them in a single place.
+func splitRequestByPartition(userID string, req *cortexpb.WriteRequest, partitions int) map[int32]*cortexpb.WriteRequest {
+	out := map[int32]*cortexpb.WriteRequest{}
+	get := func(partition int32) *cortexpb.WriteRequest {
+		r, ok := out[partition]
+		if !ok {
+			r = &cortexpb.WriteRequest{Source: req.Source, SkipLabelNameValidation: req.SkipLabelNameValidation}
+			out[partition] = r
+		}
+		return r
+	}
+
+	for _, ts := range req.Timeseries {
+		r := get(PartitionForSeries(userID, ts.Labels, partitions))
+		r.Timeseries = append(r.Timeseries, ts)
+	}
+
+	if len(req.Metadata) > 0 {
+		r := get(0)
+		r.Metadata = append(r.Metadata, req.Metadata...)
+	}
+
+	return out
+}
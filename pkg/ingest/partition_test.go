@@ -0,0 +1,87 @@
+package ingest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+)
+
+func TestAssignPartition(t *testing.T) {
+	ctx := context.Background()
+	kvClient, closer := consul.NewInMemoryClient(ring.GetCodec(), log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	// The ingesters are assigned the lowest free partitions.
+	for expected, ingesterID := range []string{"ingester-a", "ingester-b"} {
+		actual, err := AssignPartition(ctx, kvClient, ingesterID, 3)
+		require.NoError(t, err)
+		assert.Equal(t, int32(expected), actual, ingesterID)
+	}
+
+	// An ingester keeps its partition across restarts.
+	actual, err := AssignPartition(ctx, kvClient, "ingester-b", 3)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), actual)
+
+	actual, err = AssignPartition(ctx, kvClient, "ingester-c", 3)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), actual)
+
+	// There is no partition left.
+	_, err = AssignPartition(ctx, kvClient, "ingester-d", 3)
+	assert.ErrorIs(t, err, errNoFreePartition)
+
+	// An ingester owning a partition beyond the number of partitions is assigned another one.
+	_, err = AssignPartition(ctx, kvClient, "ingester-c", 2)
+	assert.ErrorIs(t, err, errNoFreePartition)
+	actual, err = AssignPartition(ctx, kvClient, "ingester-c", 4)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), actual)
+}
+
+func TestSplitRequestByPartition(t *testing.T) {
+	const partitions = 4
+
+	req := &cortexpb.WriteRequest{
+		Source:   cortexpb.API,
+		Metadata: []*cortexpb.MetricMetadata{{MetricFamilyName: "series_1"}},
+	}
+	for _, name := range []string{"series_1", "series_2", "series_3", "series_4", "series_5"} {
+		req.Timeseries = append(req.Timeseries, cortexpb.PreallocTimeseries{TimeSeries: &cortexpb.TimeSeries{
+			Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: name}},
+			Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}},
+		}})
+	}
+
+	actual := splitRequestByPartition("user-1", req, partitions)
+
+	series := 0
+	for partition, partitionReq := range actual {
+		assert.Less(t, partition, int32(partitions))
+		assert.Equal(t, cortexpb.API, partitionReq.Source)
+
+		for _, ts := range partitionReq.Timeseries {
+			assert.Equal(t, partition, PartitionForSeries("user-1", ts.Labels, partitions))
+			series++
+		}
+	}
+
+	assert.Equal(t, len(req.Timeseries), series)
+	assert.Equal(t, req.Metadata, actual[0].Metadata)
+}
+
+func TestPartitionForSeries_ShouldBeStable(t *testing.T) {
+	lbls := []cortexpb.LabelAdapter{{Name: "__name__", Value: "up"}, {Name: "job", Value: "test"}}
+
+	expected := PartitionForSeries("user-1", lbls, 100)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, expected, PartitionForSeries("user-1", lbls, 100))
+	}
+}
@@ -0,0 +1,330 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/atomic"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/backoff"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/push"
+	"objectstorage/pkg/util/dnsaddr"
+	"objectstorage/pkg/util/intern"
+	"objectstorage/pkg/util/tracing"
+)
+
+const offsetFilenamePrefix = "kafka-partition-offset-"
+
+var errCorruptedRecord = errors.New("corrupted record")
+
+// PartitionReader consumes a single partition of the Kafka topic and pushes the
+// consumed series through the local push path.
+type PartitionReader struct {
+	services.Service
+
+	cfg         KafkaConfig
+	partitionID int32
+	offsetFile  string
+	pushFn      push.Func
+	logger      log.Logger
+	reg         prometheus.Registerer
+
+	client     *kgo.Client
+	lastOffset *atomic.Int64
+
+	// seeds re-resolves the seed brokers, when resolved through DNS.
+	seeds *dnsaddr.Watcher
+
+	consumedRecords prometheus.Counter
+	failedRecords   prometheus.Counter
+	lastOffsetGauge prometheus.Gauge
+}
+
+// NewPartitionReader makes a new PartitionReader. The last consumed offset is persisted
+// in offsetsDir, so that a restarted ingester resumes where it left off.
+func NewPartitionReader(cfg KafkaConfig, partitionID int32, offsetsDir string, pushFn push.Func, logger log.Logger, reg prometheus.Registerer) *PartitionReader {
+	reg = prometheus.WrapRegistererWith(prometheus.Labels{"partition": partitionLabel(partitionID)}, reg)
+
+	r := &PartitionReader{
+		cfg:         cfg,
+		partitionID: partitionID,
+		offsetFile:  filepath.Join(offsetsDir, offsetFilenamePrefix+partitionLabel(partitionID)),
+		pushFn:      pushFn,
+		logger:      log.With(logger, "partition", partitionID),
+		reg:         reg,
+		lastOffset:  atomic.NewInt64(-1),
+		consumedRecords: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_ingest_storage_reader_records_total",
+			Help: "Total number of records consumed from the Kafka partition.",
+		}),
+		failedRecords: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_ingest_storage_reader_records_failed_total",
+			Help: "Total number of consumed records, or batches of records of a tenant, rejected by the push path or which can't be decoded, and skipped.",
+		}),
+		lastOffsetGauge: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_ingest_storage_reader_last_consumed_offset",
+			Help: "The last offset successfully consumed from the Kafka partition.",
+		}),
+	}
+
+	r.Service = services.NewBasicService(r.starting, r.running, r.stopping)
+	return r
+}
+
+func (r *PartitionReader) starting(ctx context.Context) error {
+	offset, err := r.startOffset()
+	if err != nil {
+		return err
+	}
+
+	seeds := r.cfg.SeedBrokers()
+	if dnsaddr.IsDynamic(seeds) {
+		r.seeds = dnsaddr.NewWatcher("ingest-storage-reader", seeds, r.cfg.DNSRefreshInterval, r.updateSeedBrokers, r.logger, r.reg)
+		if seeds, err = r.seeds.Resolve(ctx); err != nil {
+			return errors.Wrap(err, "resolve Kafka seed brokers")
+		}
+	}
+
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(seeds...),
+		kgo.ClientID(r.cfg.ClientID),
+		kgo.DialTimeout(r.cfg.DialTimeout),
+		kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{
+			r.cfg.Topic: {r.partitionID: offset},
+		}),
+	)
+	if err != nil {
+		return errors.Wrap(err, "create Kafka client")
+	}
+
+	r.client = client
+	if r.seeds != nil {
+		return services.StartAndAwaitRunning(ctx, r.seeds)
+	}
+	return nil
+}
+
+func (r *PartitionReader) updateSeedBrokers(seeds []string) {
+	if err := r.client.UpdateSeedBrokers(seeds...); err != nil {
+		level.Warn(r.logger).Log("msg", "failed to update the Kafka seed brokers", "err", err)
+	}
+}
+
+func (r *PartitionReader) startOffset() (kgo.Offset, error) {
+	switch r.cfg.ConsumeFrom {
+	case ConsumeFromStart:
+		return kgo.NewOffset().AtStart(), nil
+	case ConsumeFromEnd:
+		return kgo.NewOffset().AtEnd(), nil
+	}
+
+	last, err := readOffsetFile(r.offsetFile)
+	if os.IsNotExist(err) {
+		level.Info(r.logger).Log("msg", "no last consumed offset found, consuming the partition from the start")
+		return kgo.NewOffset().AtStart(), nil
+	}
+	if err != nil {
+		return kgo.Offset{}, errors.Wrap(err, "read last consumed offset")
+	}
+
+	level.Info(r.logger).Log("msg", "resuming consumption from the last consumed offset", "offset", last)
+	r.lastOffset.Store(last)
+	return kgo.NewOffset().At(last + 1), nil
+}
+
+func (r *PartitionReader) running(ctx context.Context) error {
+	var records []*kgo.Record
+	for ctx.Err() == nil {
+		fetches := r.client.PollFetches(ctx)
+		if fetches.IsClientClosed() || ctx.Err() != nil {
+			return nil
+		}
+
+		fetches.EachError(func(_ string, _ int32, err error) {
+			level.Warn(r.logger).Log("msg", "failed to fetch records", "err", err)
+		})
+
+		records = records[:0]
+		fetches.EachRecord(func(rec *kgo.Record) {
+			records = append(records, rec)
+		})
+		if err := r.consumeRecords(ctx, records); err != nil {
+			level.Info(r.logger).Log("msg", "stopped consuming the fetched records, they're consumed again on restart", "err", err)
+		}
+
+		if err := writeOffsetFile(r.offsetFile, r.lastOffset.Load()); err != nil {
+			level.Warn(r.logger).Log("msg", "failed to persist last consumed offset", "err", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *PartitionReader) stopping(_ error) error {
+	if r.seeds != nil {
+		_ = services.StopAndAwaitTerminated(context.Background(), r.seeds)
+	}
+	if r.client != nil {
+		r.client.Close()
+	}
+
+	if offset := r.lastOffset.Load(); offset >= 0 {
+		return writeOffsetFile(r.offsetFile, offset)
+	}
+	return nil
+}
+
+// consumeRecords pushes the series in the input records, batching the consecutive records of
+// the same tenant up to the configured size, so that the head appends them at once. It stops
+// at the first batch not pushed because the context is done, the records from this batch on
+// being left unconsumed.
+func (r *PartitionReader) consumeRecords(ctx context.Context, recs []*kgo.Record) error {
+	for len(recs) > 0 {
+		n, size := 1, len(recs[0].Value)
+		for n < len(recs) && bytes.Equal(recs[n].Key, recs[0].Key) && size+len(recs[n].Value) <= r.cfg.ConsumeBatchSize {
+			size += len(recs[n].Value)
+			n++
+		}
+
+		if err := r.consumeBatch(ctx, recs[:n]); err != nil {
+			return err
+		}
+		recs = recs[n:]
+	}
+	return nil
+}
+
+// consumeBatch pushes the series in the input records of a single tenant. If the batch can't
+// be decoded, its records are pushed again one by one, so that only the corrupted ones are
+// skipped. A batch rejected with a client error isn't pushed again, since the push path
+// appends the valid series of the request and only rejects the invalid ones. An error is
+// returned only if the batch wasn't pushed because the context is done.
+func (r *PartitionReader) consumeBatch(ctx context.Context, recs []*kgo.Record) error {
+	err := r.push(ctx, recs)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return err
+	case errors.Is(err, errCorruptedRecord) && len(recs) > 1:
+		level.Debug(r.logger).Log("msg", "batch can't be decoded, pushing its records one by one", "records", len(recs), "err", err)
+		for i := range recs {
+			if err := r.consumeBatch(ctx, recs[i:i+1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case err != nil:
+		r.failedRecords.Inc()
+		level.Warn(r.logger).Log("msg", "skipping records which can't be pushed", "offset", recs[0].Offset, "records", len(recs), "tenant", string(recs[0].Key), "err", err)
+	}
+
+	last := recs[len(recs)-1].Offset
+	r.lastOffset.Store(last)
+	r.lastOffsetGauge.Set(float64(last))
+	r.consumedRecords.Add(float64(len(recs)))
+	return nil
+}
+
+// push pushes the series in the input records of a single tenant. Server errors are retried
+// with backoff until the context is done, while the client errors and the records which
+// can't be decoded are returned.
+func (r *PartitionReader) push(ctx context.Context, recs []*kgo.Record) error {
+	tenantID := string(recs[0].Key)
+	ctx = user.InjectOrgID(ctx, tenantID)
+	ctx, span := tracing.StartSpan(ctx, "ingest.consume", attribute.String("tenant", tenantID),
+		attribute.Int("partition", int(recs[0].Partition)), attribute.Int64("offset", recs[0].Offset), attribute.Int("records", len(recs)))
+	defer span.End()
+
+	boff := backoff.New(ctx, backoff.Config{MinBackoff: 100 * time.Millisecond, MaxBackoff: 10 * time.Second})
+
+	for boff.Ongoing() {
+		// The request is decoded on each attempt, since the ingester reuses the series of
+		// the request once done, even if it failed.
+		req, err := decodeRecords(recs)
+		if err != nil {
+			return errors.Wrapf(errCorruptedRecord, "unmarshal record: %v", err)
+		}
+
+		_, err = r.pushFn(ctx, req)
+		if err == nil {
+			return nil
+		}
+
+		if resp, ok := httpgrpc.HTTPResponseFromError(err); ok && resp.Code/100 == 4 {
+			return err
+		}
+
+		level.Warn(r.logger).Log("msg", "failed to push records, retrying", "offset", recs[0].Offset, "records", len(recs), "tenant", tenantID, "err", err)
+		boff.Wait()
+	}
+	return boff.Err()
+}
+
+// decodeRecords merges the write requests in the input records. The series are taken from
+// the cortexpb pools, to which the ingester returns them once appended. The labels are
+// interned if the label interning is enabled.
+func decodeRecords(recs []*kgo.Record) (*cortexpb.WriteRequest, error) {
+	var merged *cortexpb.WriteRequest
+	for _, rec := range recs {
+		req := &cortexpb.PreallocWriteRequest{}
+		if err := req.Unmarshal(rec.Value); err != nil {
+			return nil, err
+		}
+		for _, ts := range req.Timeseries {
+			intern.Global().Labels(ts.Labels)
+		}
+
+		if merged == nil {
+			merged = &req.WriteRequest
+			continue
+		}
+		merged.Timeseries = append(merged.Timeseries, req.Timeseries...)
+		merged.Metadata = append(merged.Metadata, req.Metadata...)
+	}
+	return merged, nil
+}
+
+func readOffsetFile(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// writeOffsetFile atomically persists the input offset.
+func writeOffsetFile(path string, offset int64) error {
+	if offset < 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(offset, 10)), 0o666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func partitionLabel(partition int32) string {
+	return strconv.Itoa(int(partition))
+}
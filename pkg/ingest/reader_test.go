@@ -0,0 +1,171 @@
+package ingest
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+func TestOffsetFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offsets", offsetFilenamePrefix+"1")
+
+	_, err := readOffsetFile(path)
+	assert.True(t, os.IsNotExist(err))
+
+	// A negative offset means nothing has been consumed yet.
+	require.NoError(t, writeOffsetFile(path, -1))
+	_, err = readOffsetFile(path)
+	assert.True(t, os.IsNotExist(err))
+
+	require.NoError(t, writeOffsetFile(path, 12345))
+	actual, err := readOffsetFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(12345), actual)
+}
+
+func TestPartitionReader_ConsumeRecords(t *testing.T) {
+	record := func(tenantID, metric string) *kgo.Record {
+		req := &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+			Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: metric}},
+			Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}},
+		}}}}
+		data, err := req.Marshal()
+		require.NoError(t, err)
+		return &kgo.Record{Key: []byte(tenantID), Value: data}
+	}
+
+	records := []*kgo.Record{
+		record("user-1", "a"), record("user-1", "b"), record("user-2", "c"), record("user-1", "invalid"), record("user-1", "d"),
+	}
+	records = append(records, &kgo.Record{Key: []byte("user-1"), Value: []byte("corrupted")})
+	for i, rec := range records {
+		rec.Offset = int64(i)
+	}
+
+	tests := map[string]struct {
+		batchSize      int
+		expectedPushes []string
+		expectedFailed float64
+	}{
+		"batching disabled": {
+			batchSize:      0,
+			expectedPushes: []string{"user-1:a", "user-1:b", "user-2:c", "user-1:invalid", "user-1:d"},
+			expectedFailed: 2,
+		},
+		"consecutive records of a tenant batched": {
+			batchSize: 1 << 20,
+			// The batch with the corrupted record can't be decoded, so it's pushed again
+			// record by record.
+			expectedPushes: []string{"user-1:a,b", "user-2:c", "user-1:invalid", "user-1:d"},
+			expectedFailed: 2,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var pushes []string
+			pushFn := func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				userID, err := user.ExtractOrgID(ctx)
+				require.NoError(t, err)
+
+				var metrics []string
+				for _, ts := range req.Timeseries {
+					metrics = append(metrics, ts.Labels[0].Value)
+				}
+				pushes = append(pushes, userID+":"+strings.Join(metrics, ","))
+
+				if util.StringsContain(metrics, "invalid") {
+					return nil, httpgrpc.Errorf(http.StatusBadRequest, "invalid series")
+				}
+				return &cortexpb.WriteResponse{}, nil
+			}
+
+			cfg := KafkaConfig{ConsumeBatchSize: tc.batchSize}
+			reg := prometheus.NewPedanticRegistry()
+			r := NewPartitionReader(cfg, 1, t.TempDir(), pushFn, log.NewNopLogger(), reg)
+			require.NoError(t, r.consumeRecords(context.Background(), records))
+
+			assert.Equal(t, tc.expectedPushes, pushes)
+			assert.Equal(t, int64(len(records)-1), r.lastOffset.Load())
+			assert.Equal(t, float64(len(records)), testutil.ToFloat64(r.consumedRecords))
+			assert.Equal(t, tc.expectedFailed, testutil.ToFloat64(r.failedRecords))
+		})
+	}
+}
+
+func TestPartitionReader_ConsumeRecords_ShouldNotPushAgainTheBatchesRejectedWithClientErrors(t *testing.T) {
+	var pushes []string
+	pushFn := func(_ context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		var metrics []string
+		for _, ts := range req.Timeseries {
+			metrics = append(metrics, ts.Labels[0].Value)
+		}
+		pushes = append(pushes, strings.Join(metrics, ","))
+
+		// The push path appends the valid series, and rejects the invalid ones.
+		if util.StringsContain(metrics, "invalid") {
+			return nil, httpgrpc.Errorf(http.StatusBadRequest, "invalid series")
+		}
+		return &cortexpb.WriteResponse{}, nil
+	}
+
+	records := []*kgo.Record{testRecord(t, "user-1", "a", 0), testRecord(t, "user-1", "invalid", 1), testRecord(t, "user-1", "b", 2)}
+	r := NewPartitionReader(KafkaConfig{ConsumeBatchSize: 1 << 20}, 1, t.TempDir(), pushFn, log.NewNopLogger(), nil)
+	require.NoError(t, r.consumeRecords(context.Background(), records))
+
+	assert.Equal(t, []string{"a,invalid,b"}, pushes)
+	assert.Equal(t, int64(2), r.lastOffset.Load())
+	assert.Equal(t, 3.0, testutil.ToFloat64(r.consumedRecords))
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.failedRecords))
+}
+
+func TestPartitionReader_ShouldNotCommitTheRecordsNotPushedOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pushFn := func(_ context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		if req.Timeseries[0].Labels[0].Value == "b" {
+			// The ingester is stopped while the push is retried.
+			cancel()
+			return nil, httpgrpc.Errorf(http.StatusServiceUnavailable, "unavailable")
+		}
+		return &cortexpb.WriteResponse{}, nil
+	}
+
+	dir := t.TempDir()
+	records := []*kgo.Record{testRecord(t, "user-1", "a", 10), testRecord(t, "user-2", "b", 11), testRecord(t, "user-1", "c", 12)}
+	r := NewPartitionReader(KafkaConfig{}, 1, dir, pushFn, log.NewNopLogger(), nil)
+	require.ErrorIs(t, r.consumeRecords(ctx, records), context.Canceled)
+	require.NoError(t, r.stopping(nil))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.consumedRecords))
+	assert.Equal(t, 0.0, testutil.ToFloat64(r.failedRecords))
+	committed, err := readOffsetFile(r.offsetFile)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), committed)
+}
+
+func testRecord(t *testing.T, tenantID, metric string, offset int64) *kgo.Record {
+	req := &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+		Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: metric}},
+		Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}},
+	}}}}
+	data, err := req.Marshal()
+	require.NoError(t, err)
+	return &kgo.Record{Key: []byte(tenantID), Value: data, Offset: offset}
+}
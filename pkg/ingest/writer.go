@@ -0,0 +1,180 @@
+package ingest
+
+import (
+	"context"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/weaveworks/common/user"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/push"
+	"objectstorage/pkg/util/dnsaddr"
+	"objectstorage/pkg/util/slab"
+	"objectstorage/pkg/util/tracing"
+)
+
+// Slab sizes of the records values. The larger ones are left to the GC.
+const (
+	minRecordSlabSize = 4 << 10
+	maxRecordSlabSize = 16 << 20
+)
+
+// Writer writes series to the partitioned Kafka topic.
+type Writer struct {
+	services.Service
+
+	cfg    KafkaConfig
+	logger log.Logger
+	reg    prometheus.Registerer
+	client *kgo.Client
+	slabs  *slab.Pool
+
+	// seeds re-resolves the seed brokers, when resolved through DNS.
+	seeds *dnsaddr.Watcher
+
+	writtenRecords *prometheus.CounterVec
+	writtenBytes   prometheus.Counter
+	failedWrites   prometheus.Counter
+}
+
+// NewWriter makes a new Writer.
+func NewWriter(cfg KafkaConfig, logger log.Logger, reg prometheus.Registerer) *Writer {
+	w := &Writer{
+		cfg:    cfg,
+		logger: logger,
+		reg:    reg,
+		slabs:  slab.NewPool(minRecordSlabSize, maxRecordSlabSize),
+		writtenRecords: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_ingest_storage_writer_records_total",
+			Help: "Total number of records written to the Kafka topic, by partition.",
+		}, []string{"partition"}),
+		writtenBytes: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_ingest_storage_writer_bytes_total",
+			Help: "Total number of bytes written to the Kafka topic.",
+		}),
+		failedWrites: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_ingest_storage_writer_failed_writes_total",
+			Help: "Total number of write requests which failed to be committed to the Kafka topic.",
+		}),
+	}
+
+	w.Service = services.NewIdleService(w.starting, w.stopping)
+	return w
+}
+
+func (w *Writer) starting(ctx context.Context) error {
+	seeds := w.cfg.SeedBrokers()
+	if dnsaddr.IsDynamic(seeds) {
+		w.seeds = dnsaddr.NewWatcher("ingest-storage-writer", seeds, w.cfg.DNSRefreshInterval, w.updateSeedBrokers, w.logger, w.reg)
+
+		var err error
+		if seeds, err = w.seeds.Resolve(ctx); err != nil {
+			return errors.Wrap(err, "resolve Kafka seed brokers")
+		}
+	}
+
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(seeds...),
+		kgo.ClientID(w.cfg.ClientID),
+		kgo.DialTimeout(w.cfg.DialTimeout),
+		kgo.DefaultProduceTopic(w.cfg.Topic),
+		kgo.RecordPartitioner(kgo.ManualPartitioner()),
+		kgo.ProduceRequestTimeout(w.cfg.WriteTimeout),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+	)
+	if err != nil {
+		return errors.Wrap(err, "create Kafka client")
+	}
+
+	w.client = client
+	if w.seeds != nil {
+		return services.StartAndAwaitRunning(ctx, w.seeds)
+	}
+	return nil
+}
+
+func (w *Writer) updateSeedBrokers(seeds []string) {
+	if err := w.client.UpdateSeedBrokers(seeds...); err != nil {
+		level.Warn(w.logger).Log("msg", "failed to update the Kafka seed brokers", "err", err)
+	}
+}
+
+func (w *Writer) stopping(_ error) error {
+	if w.seeds != nil {
+		_ = services.StopAndAwaitTerminated(context.Background(), w.seeds)
+	}
+	if w.client != nil {
+		w.client.Close()
+	}
+	return nil
+}
+
+// WriteSync writes the input request to the Kafka topic, splitting it by partition, and
+// returns once all records have been committed. The record values are marshalled into slabs
+// released once the records are produced, since the client doesn't keep them past that.
+func (w *Writer) WriteSync(ctx context.Context, userID string, req *cortexpb.WriteRequest) (err error) {
+	ctx, span := tracing.StartSpan(ctx, "ingest.write", attribute.String("tenant", userID))
+	defer func() { tracing.EndSpan(span, err) }()
+
+	partitions := splitRequestByPartition(userID, req, w.cfg.PartitionsCount)
+	records := make([]*kgo.Record, 0, len(partitions))
+	defer func() {
+		for _, r := range records {
+			w.slabs.Put(r.Value)
+		}
+	}()
+
+	for partition, partitionReq := range partitions {
+		data := w.slabs.Get(partitionReq.Size())
+		if _, err := partitionReq.MarshalToSizedBuffer(data); err != nil {
+			w.slabs.Put(data)
+			return errors.Wrap(err, "marshal write request")
+		}
+
+		records = append(records, &kgo.Record{
+			Key:       []byte(userID),
+			Value:     data,
+			Topic:     w.cfg.Topic,
+			Partition: partition,
+		})
+	}
+
+	span.SetAttributes(attribute.Int("records", len(records)))
+
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.WriteTimeout)
+	defer cancel()
+
+	if err := w.client.ProduceSync(ctx, records...).FirstErr(); err != nil {
+		w.failedWrites.Inc()
+		return errors.Wrap(err, "write to Kafka")
+	}
+
+	for _, r := range records {
+		w.writtenRecords.WithLabelValues(partitionLabel(r.Partition)).Inc()
+		w.writtenBytes.Add(float64(len(r.Value)))
+	}
+	return nil
+}
+
+// PushFunc returns a push.Func writing requests to the Kafka topic instead of the ingesters.
+func (w *Writer) PushFunc() push.Func {
+	return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		userID, err := user.ExtractOrgID(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := w.WriteSync(ctx, userID, req); err != nil {
+			return nil, err
+		}
+		return &cortexpb.WriteResponse{}, nil
+	}
+}
@@ -0,0 +1,283 @@
+package consistency
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/shipper"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/storage/bucket"
+	cortex_tsdb "objectstorage/pkg/storage/tsdb"
+)
+
+var (
+	errInvalidInterval    = errors.New("the consistency check interval must be greater than 0")
+	errInvalidGracePeriod = errors.New("the consistency check grace period must be greater than 0")
+)
+
+// Config holds the configuration of the consistency check between the local blocks and the
+// bucket.
+type Config struct {
+	Enabled     bool          `yaml:"enabled"`
+	Interval    time.Duration `yaml:"interval"`
+	GracePeriod time.Duration `yaml:"grace_period"`
+}
+
+// RegisterFlags registers the consistency check flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "consistency-check.enabled", false, "True to periodically check that the blocks compacted from the head are in the bucket, and report the ones never shipped.")
+	f.DurationVar(&cfg.Interval, "consistency-check.interval", 10*time.Minute, "How frequently the local blocks are checked against the bucket.")
+	f.DurationVar(&cfg.GracePeriod, "consistency-check.grace-period", time.Hour, "How long after being compacted a block must be in the bucket before being reported as unshipped.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Interval <= 0 {
+		return errInvalidInterval
+	}
+	if cfg.GracePeriod <= 0 {
+		return errInvalidGracePeriod
+	}
+	return nil
+}
+
+// UnshippedBlock is a local block missing from the bucket past the grace period.
+type UnshippedBlock struct {
+	Tenant string    `json:"tenant"`
+	Block  ulid.ULID `json:"block"`
+	Age    string    `json:"age"`
+	// RecordedAsShipped is true if the block is listed in the shipper meta file, in which
+	// case it was deleted from the bucket or the upload was wrongly recorded.
+	RecordedAsShipped bool `json:"recorded_as_shipped"`
+}
+
+// Checker compares the blocks compacted from the head, in the TSDB directory, with the ones in
+// the bucket, and reports the blocks never shipped within the grace period. The blocks found
+// in the bucket are not checked again.
+type Checker struct {
+	services.Service
+
+	cfg         Config
+	tsdbDir     string
+	bkt         objstore.Bucket
+	cfgProvider bucket.TenantConfigProvider
+	logger      log.Logger
+
+	// checkMtx serializes the checks, run periodically or on demand.
+	checkMtx sync.Mutex
+	shipped  map[string]map[ulid.ULID]struct{}
+	tenants  map[string]struct{}
+
+	unshipped   *prometheus.GaugeVec
+	checkErrors prometheus.Counter
+	lastCheck   prometheus.Gauge
+}
+
+// NewChecker makes a new Checker.
+func NewChecker(cfg Config, tsdbDir string, bkt objstore.Bucket, cfgProvider bucket.TenantConfigProvider, logger log.Logger, reg prometheus.Registerer) *Checker {
+	c := &Checker{
+		cfg:         cfg,
+		tsdbDir:     tsdbDir,
+		bkt:         bkt,
+		cfgProvider: cfgProvider,
+		logger:      logger,
+		shipped:     map[string]map[ulid.ULID]struct{}{},
+		tenants:     map[string]struct{}{},
+		unshipped: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_consistency_check_unshipped_blocks",
+			Help: "Number of local blocks of the tenant compacted from the head but missing from the bucket past the grace period, as of the last check.",
+		}, []string{"user"}),
+		checkErrors: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_consistency_check_errors_total",
+			Help: "Total number of errors checking the local blocks of a tenant against the bucket.",
+		}),
+		lastCheck: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_consistency_check_last_run_timestamp_seconds",
+			Help: "Unix timestamp of the last consistency check between the local blocks and the bucket.",
+		}),
+	}
+	c.Service = services.NewTimerService(cfg.Interval, nil, c.iteration, nil)
+	return c
+}
+
+func (c *Checker) iteration(ctx context.Context) error {
+	if _, err := c.Check(ctx); err != nil {
+		// Not fatal, the blocks are checked again at the next iteration.
+		level.Warn(c.logger).Log("msg", "failed to check the local blocks against the bucket", "err", err)
+	}
+	return nil
+}
+
+// Check checks the local blocks of all the tenants against the bucket, and returns the ones
+// missing from the bucket past the grace period.
+func (c *Checker) Check(ctx context.Context) ([]UnshippedBlock, error) {
+	c.checkMtx.Lock()
+	defer c.checkMtx.Unlock()
+
+	users, err := os.ReadDir(c.tsdbDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var out []UnshippedBlock
+	tenants := map[string]struct{}{}
+	for _, user := range users {
+		if !user.IsDir() || strings.HasPrefix(user.Name(), ".") {
+			continue
+		}
+
+		userID := user.Name()
+		tenants[userID] = struct{}{}
+		blocks, err := c.checkTenant(ctx, userID)
+		if err != nil {
+			c.checkErrors.Inc()
+			level.Warn(c.logger).Log("msg", "failed to check the local blocks of the tenant against the bucket", "tenant", userID, "err", err)
+			continue
+		}
+
+		for _, b := range blocks {
+			level.Warn(c.logger).Log("msg", "block compacted but never shipped to the bucket", "tenant", userID, "block", b.Block.String(), "age", b.Age, "recorded_as_shipped", b.RecordedAsShipped)
+		}
+		c.unshipped.WithLabelValues(userID).Set(float64(len(blocks)))
+		out = append(out, blocks...)
+	}
+
+	// The metrics and the state of the tenants gone from the disk are removed.
+	for userID := range c.tenants {
+		if _, ok := tenants[userID]; !ok {
+			c.unshipped.DeleteLabelValues(userID)
+			delete(c.shipped, userID)
+		}
+	}
+	c.tenants = tenants
+	c.lastCheck.SetToCurrentTime()
+	return out, nil
+}
+
+// checkTenant returns the blocks of the tenant missing from the bucket. The blocks of the
+// tenants marked for deletion are never shipped, so they're not checked.
+func (c *Checker) checkTenant(ctx context.Context, userID string) ([]UnshippedBlock, error) {
+	userDir := filepath.Join(c.tsdbDir, userID)
+	recorded := map[ulid.ULID]struct{}{}
+	if meta, err := shipper.ReadMetaFile(userDir); err == nil {
+		for _, id := range meta.Uploaded {
+			recorded[id] = struct{}{}
+		}
+	}
+
+	entries, err := os.ReadDir(userDir)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	local := map[ulid.ULID]struct{}{}
+	var candidates []ulid.ULID
+	for _, entry := range entries {
+		id, err := ulid.Parse(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		local[id] = struct{}{}
+		if _, ok := c.shipped[userID][id]; ok {
+			continue
+		}
+		// The ULID time is when the block was compacted from the head.
+		if now.Sub(ulid.Time(id.Time())) < c.cfg.GracePeriod {
+			continue
+		}
+
+		// As in the shipper, only the blocks compacted from the head are shipped.
+		meta, err := metadata.ReadFromDir(filepath.Join(userDir, entry.Name()))
+		if err != nil {
+			level.Warn(c.logger).Log("msg", "failed to read the block meta, skipping it", "tenant", userID, "block", id.String(), "err", err)
+			continue
+		}
+		if meta.Compaction.Level > 1 {
+			continue
+		}
+		candidates = append(candidates, id)
+	}
+
+	// The blocks not on the disk anymore are forgotten.
+	shipped := map[ulid.ULID]struct{}{}
+	for id := range c.shipped[userID] {
+		if _, ok := local[id]; ok {
+			shipped[id] = struct{}{}
+		}
+	}
+	c.shipped[userID] = shipped
+
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	deleted, err := cortex_tsdb.TenantDeletionMarkExists(ctx, c.bkt, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "check tenant deletion mark")
+	}
+	if deleted {
+		return nil, nil
+	}
+
+	userBkt := bucket.NewUserBucketClient(userID, c.bkt, c.cfgProvider)
+	var out []UnshippedBlock
+	for _, id := range candidates {
+		exists, err := userBkt.Exists(ctx, path.Join(id.String(), metadata.MetaFilename))
+		if err != nil {
+			return nil, errors.Wrapf(err, "check block %s in the bucket", id)
+		}
+		if exists {
+			shipped[id] = struct{}{}
+			continue
+		}
+
+		_, ok := recorded[id]
+		out = append(out, UnshippedBlock{
+			Tenant:            userID,
+			Block:             id,
+			Age:               now.Sub(ulid.Time(id.Time())).Round(time.Second).String(),
+			RecordedAsShipped: ok,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Block.Compare(out[j].Block) < 0 })
+	return out, nil
+}
+
+// ServeHTTP runs a check on demand and returns the unshipped blocks.
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	blocks, err := c.Check(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if blocks == nil {
+		blocks = []UnshippedBlock{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(blocks); err != nil {
+		level.Error(c.logger).Log("msg", "failed to encode the unshipped blocks", "err", err)
+	}
+}
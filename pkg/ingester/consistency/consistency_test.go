@@ -0,0 +1,151 @@
+package consistency
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/shipper"
+
+	cortex_tsdb "objectstorage/pkg/storage/tsdb"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		cfg      Config
+		expected error
+	}{
+		"disabled": {
+			cfg:      Config{},
+			expected: nil,
+		},
+		"enabled": {
+			cfg:      Config{Enabled: true, Interval: time.Minute, GracePeriod: time.Hour},
+			expected: nil,
+		},
+		"invalid interval": {
+			cfg:      Config{Enabled: true, GracePeriod: time.Hour},
+			expected: errInvalidInterval,
+		},
+		"invalid grace period": {
+			cfg:      Config{Enabled: true, Interval: time.Minute},
+			expected: errInvalidGracePeriod,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.cfg.Validate())
+		})
+	}
+}
+
+func TestChecker_Check(t *testing.T) {
+	ctx := context.Background()
+	tsdbDir := t.TempDir()
+	bkt := &countingBucket{Bucket: objstore.NewInMemBucket()}
+	old := time.Now().Add(-2 * time.Hour)
+
+	shipped := createLocalBlock(t, tsdbDir, "user-1", old, 1)
+	uploadMeta(t, bkt, "user-1", shipped)
+	unshipped := createLocalBlock(t, tsdbDir, "user-1", old.Add(time.Second), 1)
+	// Listed in the shipper meta file, but missing from the bucket.
+	lost := createLocalBlock(t, tsdbDir, "user-1", old.Add(2*time.Second), 1)
+	require.NoError(t, shipper.WriteMetaFile(log.NewNopLogger(), filepath.Join(tsdbDir, "user-1"), &shipper.Meta{Version: shipper.MetaVersion1, Uploaded: []ulid.ULID{shipped, lost}}))
+	// Within the grace period.
+	createLocalBlock(t, tsdbDir, "user-1", time.Now(), 1)
+	// Compacted locally, never shipped by the ingester.
+	createLocalBlock(t, tsdbDir, "user-1", old.Add(3*time.Second), 2)
+	// The blocks of the tenants marked for deletion are not shipped.
+	createLocalBlock(t, tsdbDir, "user-2", old, 1)
+	require.NoError(t, cortex_tsdb.WriteTenantDeletionMark(ctx, bkt, "user-2", nil, cortex_tsdb.NewTenantDeletionMark(time.Now())))
+
+	reg := prometheus.NewPedanticRegistry()
+	c := NewChecker(Config{Enabled: true, Interval: time.Minute, GracePeriod: time.Hour}, tsdbDir, bkt, nil, log.NewNopLogger(), reg)
+
+	blocks, err := c.Check(ctx)
+	require.NoError(t, err)
+	require.Len(t, blocks, 2)
+	assert.Equal(t, "user-1", blocks[0].Tenant)
+	assert.Equal(t, unshipped, blocks[0].Block)
+	assert.False(t, blocks[0].RecordedAsShipped)
+	assert.Equal(t, lost, blocks[1].Block)
+	assert.True(t, blocks[1].RecordedAsShipped)
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_consistency_check_unshipped_blocks Number of local blocks of the tenant compacted from the head but missing from the bucket past the grace period, as of the last check.
+		# TYPE cortex_consistency_check_unshipped_blocks gauge
+		cortex_consistency_check_unshipped_blocks{user="user-1"} 2
+		cortex_consistency_check_unshipped_blocks{user="user-2"} 0
+	`), "cortex_consistency_check_unshipped_blocks"))
+
+	// The blocks found in the bucket are not checked again, and the ones shipped meanwhile
+	// are not reported anymore.
+	bkt.exists = 0
+	uploadMeta(t, bkt, "user-1", unshipped)
+	blocks, err = c.Check(ctx)
+	require.NoError(t, err)
+	assert.Len(t, blocks, 1)
+	assert.Equal(t, 2, bkt.exists)
+}
+
+func TestChecker_ServeHTTP(t *testing.T) {
+	tsdbDir := t.TempDir()
+	id := createLocalBlock(t, tsdbDir, "user-1", time.Now().Add(-2*time.Hour), 1)
+	c := NewChecker(Config{Enabled: true, Interval: time.Minute, GracePeriod: time.Hour}, tsdbDir, objstore.NewInMemBucket(), nil, log.NewNopLogger(), nil)
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ingester/consistency-check", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var blocks []UnshippedBlock
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&blocks))
+	require.Len(t, blocks, 1)
+	assert.Equal(t, id, blocks[0].Block)
+}
+
+// countingBucket counts the existence checks.
+type countingBucket struct {
+	objstore.Bucket
+	exists int
+}
+
+func (b *countingBucket) Exists(ctx context.Context, name string) (bool, error) {
+	if strings.HasSuffix(name, metadata.MetaFilename) {
+		b.exists++
+	}
+	return b.Bucket.Exists(ctx, name)
+}
+
+func createLocalBlock(t *testing.T, tsdbDir, userID string, compactedAt time.Time, level int) ulid.ULID {
+	id := ulid.MustNew(ulid.Timestamp(compactedAt), nil)
+	meta := metadata.Meta{
+		BlockMeta: tsdb.BlockMeta{ULID: id, Version: metadata.TSDBVersion1, Compaction: tsdb.BlockMetaCompaction{Level: level}},
+		Thanos:    metadata.Thanos{Version: metadata.ThanosVersion1},
+	}
+	dir := filepath.Join(tsdbDir, userID, id.String())
+	require.NoError(t, os.MkdirAll(dir, 0o750))
+	require.NoError(t, meta.WriteToDir(log.NewNopLogger(), dir))
+	return id
+}
+
+func uploadMeta(t *testing.T, bkt objstore.Bucket, userID string, id ulid.ULID) {
+	require.NoError(t, bkt.Upload(context.Background(), path.Join(userID, id.String(), metadata.MetaFilename), bytes.NewReader([]byte("{}"))))
+}
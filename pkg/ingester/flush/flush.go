@@ -0,0 +1,356 @@
+package flush
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+const (
+	chunksDirname = "chunks"
+	indexFilename = "index"
+
+	// segmentBufferSize is the size of the buffer the chunks are written through.
+	segmentBufferSize = 1 << 20
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// WriteBlock writes the series of the input head, or any other block, within [mint, maxt)
+// as a new block in dir, with the input external labels. The chunks are streamed from the
+// head into the segment files, without being buffered, and the block is renamed into place
+// once complete. It returns the ULID of the block.
+func WriteBlock(ctx context.Context, head tsdb.BlockReader, mint, maxt int64, dir string, extLabels map[string]string, logger log.Logger) (ulid.ULID, error) {
+	id := ulid.MustNew(ulid.Timestamp(time.Now()), rand.Reader)
+	tmp := filepath.Join(dir, id.String()+".tmp")
+	if err := os.MkdirAll(filepath.Join(tmp, chunksDirname), 0o750); err != nil {
+		return id, err
+	}
+	defer os.RemoveAll(tmp)
+
+	meta, err := writeBlock(ctx, id, head, mint, maxt, filepath.Join(tmp, indexFilename), dirSink(tmp))
+	if err != nil {
+		return id, err
+	}
+	meta.Thanos.Labels = extLabels
+	if err := meta.WriteToDir(logger, tmp); err != nil {
+		return id, errors.Wrap(err, "write meta file")
+	}
+	return id, os.Rename(tmp, filepath.Join(dir, id.String()))
+}
+
+// UploadBlock writes the series of the input head, or any other block, within [mint, maxt)
+// as a new block in the bucket, with the input external labels. The chunks are streamed
+// from the head straight into the bucket objects, the multipart upload being up to the
+// bucket client, so that they never hit the local disk. Only the index is built in tmpDir
+// before being uploaded, followed by the meta file. It returns the ULID of the block.
+func UploadBlock(ctx context.Context, head tsdb.BlockReader, mint, maxt int64, bkt objstore.Bucket, tmpDir string, extLabels map[string]string) (ulid.ULID, error) {
+	id := ulid.MustNew(ulid.Timestamp(time.Now()), rand.Reader)
+	tmp := filepath.Join(tmpDir, id.String()+".tmp")
+	if err := os.MkdirAll(tmp, 0o750); err != nil {
+		return id, err
+	}
+	defer os.RemoveAll(tmp)
+
+	sink := bucketSink{ctx: ctx, bkt: bkt, prefix: id.String()}
+	meta, err := writeBlock(ctx, id, head, mint, maxt, filepath.Join(tmp, indexFilename), sink)
+	if err != nil {
+		return id, err
+	}
+
+	if err := objstore.UploadFile(ctx, log.NewNopLogger(), bkt, filepath.Join(tmp, indexFilename), path.Join(id.String(), indexFilename)); err != nil {
+		return id, errors.Wrap(err, "upload index")
+	}
+
+	// The meta file is uploaded last, since it marks the block as complete.
+	meta.Thanos.Labels = extLabels
+	pr, pw := io.Pipe()
+	go func() { pw.CloseWithError(meta.Write(pw)) }()
+	if err := bkt.Upload(ctx, path.Join(id.String(), metadata.MetaFilename), pr); err != nil {
+		pr.CloseWithError(err)
+		return id, errors.Wrap(err, "upload meta file")
+	}
+	return id, nil
+}
+
+// writeBlock writes the index of the block to indexPath, and its chunks as segments
+// created by the sink. It returns the meta of the block.
+func writeBlock(ctx context.Context, id ulid.ULID, head tsdb.BlockReader, mint, maxt int64, indexPath string, sink segmentSink) (*metadata.Meta, error) {
+	ir, err := head.Index()
+	if err != nil {
+		return nil, errors.Wrap(err, "open index reader")
+	}
+	defer ir.Close()
+
+	iw, err := index.NewWriter(ctx, indexPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "open index writer")
+	}
+	defer iw.Close()
+
+	// The symbols must all be added before the series.
+	symbols := ir.Symbols()
+	for symbols.Next() {
+		if err := iw.AddSymbol(symbols.At()); err != nil {
+			return nil, errors.Wrap(err, "add symbol")
+		}
+	}
+	if err := symbols.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterate symbols")
+	}
+
+	// The block querier trims and re-encodes the chunks overlapping the range boundaries.
+	q, err := tsdb.NewBlockChunkQuerier(head, mint, maxt-1)
+	if err != nil {
+		return nil, errors.Wrap(err, "open chunk querier")
+	}
+	defer q.Close()
+
+	sw := &segmentWriter{sink: sink, segmentSize: chunks.DefaultChunkSegmentSize}
+	defer sw.abort()
+
+	meta := &metadata.Meta{
+		BlockMeta: tsdb.BlockMeta{
+			ULID:       id,
+			MinTime:    mint,
+			MaxTime:    maxt,
+			Version:    metadata.TSDBVersion1,
+			Compaction: tsdb.BlockMetaCompaction{Level: 1, Sources: []ulid.ULID{id}},
+		},
+		Thanos: metadata.Thanos{
+			Version: metadata.ThanosVersion1,
+			Source:  metadata.ReceiveSource,
+		},
+	}
+
+	var (
+		ref  storage.SeriesRef
+		chks []chunks.Meta
+		it   chunks.Iterator
+	)
+	// The all postings matcher, so that the series are returned sorted as the index wants.
+	ss := q.Select(true, nil, labels.MustNewMatcher(labels.MatchEqual, "", ""))
+	for ss.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		series := ss.At()
+		chks = chks[:0]
+		it = series.Iterator(it)
+		for it.Next() {
+			chks = append(chks, it.At())
+		}
+		if err := it.Err(); err != nil {
+			return nil, errors.Wrap(err, "iterate chunks")
+		}
+		if len(chks) == 0 {
+			continue
+		}
+
+		for i := range chks {
+			meta.Stats.NumChunks++
+			meta.Stats.NumSamples += uint64(chks[i].Chunk.NumSamples())
+			if err := sw.write(&chks[i]); err != nil {
+				return nil, errors.Wrap(err, "write chunk")
+			}
+		}
+		if err := iw.AddSeries(ref, series.Labels(), chks...); err != nil {
+			return nil, errors.Wrap(err, "add series")
+		}
+		meta.Stats.NumSeries++
+		ref++
+	}
+	if err := ss.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterate series")
+	}
+
+	if err := sw.close(); err != nil {
+		return nil, errors.Wrap(err, "close chunk segment")
+	}
+	if err := iw.Close(); err != nil {
+		return nil, errors.Wrap(err, "close index writer")
+	}
+	return meta, nil
+}
+
+// segmentSink creates the chunk segments of a block.
+type segmentSink interface {
+	// create returns the writer of the segment of the input name. Its Close() returns once
+	// the segment is persisted.
+	create(name string) (segment, error)
+}
+
+type segment interface {
+	io.WriteCloser
+
+	// abort discards the segment.
+	abort(err error)
+}
+
+// dirSink creates the segments as files of the block directory.
+type dirSink string
+
+func (d dirSink) create(name string) (segment, error) {
+	f, err := os.Create(filepath.Join(string(d), name))
+	if err != nil {
+		return nil, err
+	}
+	return fileSegment{f}, nil
+}
+
+type fileSegment struct{ *os.File }
+
+func (f fileSegment) Close() error {
+	if err := f.Sync(); err != nil {
+		f.File.Close()
+		return err
+	}
+	return f.File.Close()
+}
+
+func (f fileSegment) abort(error) { f.File.Close() }
+
+// bucketSink uploads the segments to the bucket as they're written.
+type bucketSink struct {
+	ctx    context.Context
+	bkt    objstore.Bucket
+	prefix string
+}
+
+func (b bucketSink) create(name string) (segment, error) {
+	pr, pw := io.Pipe()
+	s := &uploadSegment{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := b.bkt.Upload(b.ctx, path.Join(b.prefix, name), pr)
+		// Unblock the writes if the upload stopped reading.
+		pr.CloseWithError(errors.Wrap(err, "upload stopped"))
+		s.done <- err
+	}()
+	return s, nil
+}
+
+type uploadSegment struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (s *uploadSegment) Write(p []byte) (int, error) { return s.pw.Write(p) }
+
+func (s *uploadSegment) Close() error {
+	s.pw.Close()
+	return <-s.done
+}
+
+func (s *uploadSegment) abort(err error) {
+	s.pw.CloseWithError(err)
+	<-s.done
+}
+
+// segmentWriter writes the chunks in the segment format of the chunks.Writer, cutting a
+// new segment once the current one is full.
+type segmentWriter struct {
+	sink        segmentSink
+	segmentSize int64
+
+	seq int
+	cur segment
+	buf *bufio.Writer
+	n   int64
+
+	scratch [binary.MaxVarintLen32]byte
+}
+
+// write writes the chunk and sets its reference.
+func (w *segmentWriter) write(chk *chunks.Meta) error {
+	data := chk.Chunk.Bytes()
+	size := int64(chunks.MaxChunkLengthFieldSize + chunks.ChunkEncodingSize + len(data) + crc32.Size)
+	if w.cur == nil || w.n+size > w.segmentSize {
+		if err := w.cut(); err != nil {
+			return err
+		}
+	}
+
+	// The segments are numbered from 1, while the references are indexes from 0.
+	chk.Ref = chunks.ChunkRef(chunks.NewBlockChunkRef(uint64(w.seq-1), uint64(w.n)))
+
+	n := binary.PutUvarint(w.scratch[:], uint64(len(data)))
+	enc := []byte{byte(chk.Chunk.Encoding())}
+	crc := crc32.New(castagnoliTable)
+	crc.Write(enc)  // nolint:errcheck
+	crc.Write(data) // nolint:errcheck
+
+	for _, b := range [][]byte{w.scratch[:n], enc, data, crc.Sum(nil)} {
+		if _, err := w.buf.Write(b); err != nil {
+			return err
+		}
+		w.n += int64(len(b))
+	}
+
+	// The chunk isn't needed anymore once written.
+	chk.Chunk = nil
+	return nil
+}
+
+// cut closes the current segment, if any, and starts the next one.
+func (w *segmentWriter) cut() error {
+	if err := w.close(); err != nil {
+		return err
+	}
+
+	w.seq++
+	s, err := w.sink.create(path.Join(chunksDirname, fmt.Sprintf("%0.6d", w.seq)))
+	if err != nil {
+		return err
+	}
+	w.cur, w.buf = s, bufio.NewWriterSize(s, segmentBufferSize)
+
+	header := make([]byte, chunks.SegmentHeaderSize)
+	binary.BigEndian.PutUint32(header[:chunks.MagicChunksSize], chunks.MagicChunks)
+	header[chunks.MagicChunksSize] = 1 // The chunks format version.
+	_, err = w.buf.Write(header)
+	w.n = int64(len(header))
+	return err
+}
+
+// close flushes and closes the current segment, if any.
+func (w *segmentWriter) close() error {
+	if w.cur == nil {
+		return nil
+	}
+	cur := w.cur
+	w.cur = nil
+
+	if err := w.buf.Flush(); err != nil {
+		cur.abort(err)
+		return err
+	}
+	return cur.Close()
+}
+
+// abort discards the current segment, if any.
+func (w *segmentWriter) abort() {
+	if w.cur != nil {
+		w.cur.abort(errors.New("block write aborted"))
+		w.cur = nil
+	}
+}
@@ -0,0 +1,159 @@
+package flush
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/providers/filesystem"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+func TestWriteBlock(t *testing.T) {
+	head, expected := prepareHead(t)
+	dir := t.TempDir()
+
+	id, err := WriteBlock(context.Background(), head, 1000, 3000, dir, map[string]string{"tenant": "user-1"}, log.NewNopLogger())
+	require.NoError(t, err)
+
+	assertBlock(t, filepath.Join(dir, id.String()), expected)
+	assert.NoDirExists(t, filepath.Join(dir, id.String()+".tmp"))
+}
+
+func TestUploadBlock(t *testing.T) {
+	head, expected := prepareHead(t)
+	bktDir := t.TempDir()
+	bkt, err := filesystem.NewBucket(bktDir)
+	require.NoError(t, err)
+
+	id, err := UploadBlock(context.Background(), head, 1000, 3000, bkt, t.TempDir(), map[string]string{"tenant": "user-1"})
+	require.NoError(t, err)
+
+	ok, err := bkt.Exists(context.Background(), filepath.Join(id.String(), metadata.MetaFilename))
+	require.NoError(t, err)
+	require.True(t, ok)
+	assertBlock(t, filepath.Join(bktDir, id.String()), expected)
+}
+
+func TestUploadBlock_UploadError(t *testing.T) {
+	head, _ := prepareHead(t)
+	bkt := objstore.WithNoopInstr(&failingBucket{Bucket: objstore.NewInMemBucket()})
+
+	_, err := UploadBlock(context.Background(), head, 1000, 3000, bkt, t.TempDir(), nil)
+	require.ErrorIs(t, err, errUpload)
+}
+
+func TestSegmentWriter_Cut(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, chunksDirname), 0o750))
+	head, expected := prepareHead(t)
+
+	// Only one chunk fits in each segment.
+	sw := &segmentWriter{sink: dirSink(dir), segmentSize: 1}
+	q, err := tsdb.NewBlockChunkQuerier(head, 1000, 2999)
+	require.NoError(t, err)
+	defer q.Close()
+
+	chunks := 0
+	ss := q.Select(true, nil, labels.MustNewMatcher(labels.MatchEqual, "", ""))
+	for ss.Next() {
+		it := ss.At().Iterator(nil)
+		for it.Next() {
+			chk := it.At()
+			require.NoError(t, sw.write(&chk))
+			chunks++
+		}
+	}
+	require.NoError(t, ss.Err())
+	require.NoError(t, sw.close())
+
+	assert.Equal(t, len(expected), chunks)
+	segments, err := filepath.Glob(filepath.Join(dir, chunksDirname, "*"))
+	require.NoError(t, err)
+	assert.Len(t, segments, chunks)
+}
+
+// prepareHead returns a head with series spanning [0, 4000), and the samples expected within
+// [1000, 3000) by series.
+func prepareHead(t *testing.T) (*tsdb.RangeHead, map[string][]int64) {
+	opts := tsdb.DefaultOptions()
+	opts.RetentionDuration = 0
+	db, err := tsdb.Open(t.TempDir(), nil, nil, opts, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	expected := map[string][]int64{}
+	app := db.Appender(context.Background())
+	for i := 0; i < 10; i++ {
+		lset := labels.FromStrings("__name__", "series", "i", fmt.Sprint(i))
+		for ts := int64(0); ts < 4000; ts += 100 {
+			_, err := app.Append(0, lset, ts, float64(ts))
+			require.NoError(t, err)
+			if ts >= 1000 && ts < 3000 {
+				expected[lset.String()] = append(expected[lset.String()], ts)
+			}
+		}
+	}
+	require.NoError(t, app.Commit())
+
+	return tsdb.NewRangeHead(db.Head(), 0, 3999), expected
+}
+
+func assertBlock(t *testing.T, dir string, expected map[string][]int64) {
+	meta, err := metadata.ReadFromDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), meta.MinTime)
+	assert.Equal(t, int64(3000), meta.MaxTime)
+	assert.Equal(t, uint64(len(expected)), meta.Stats.NumSeries)
+	assert.Equal(t, uint64(len(expected)*20), meta.Stats.NumSamples)
+	assert.Equal(t, map[string]string{"tenant": "user-1"}, meta.Thanos.Labels)
+
+	block, err := tsdb.OpenBlock(nil, dir, chunkenc.NewPool())
+	require.NoError(t, err)
+	defer block.Close()
+
+	q, err := tsdb.NewBlockQuerier(block, meta.MinTime, meta.MaxTime)
+	require.NoError(t, err)
+	defer q.Close()
+
+	actual := map[string][]int64{}
+	ss := q.Select(true, nil, labels.MustNewMatcher(labels.MatchEqual, "__name__", "series"))
+	for ss.Next() {
+		it := ss.At().Iterator(nil)
+		for it.Next() == chunkenc.ValFloat {
+			ts, v := it.At()
+			assert.Equal(t, float64(ts), v)
+			actual[ss.At().Labels().String()] = append(actual[ss.At().Labels().String()], ts)
+		}
+		require.NoError(t, it.Err())
+	}
+	require.NoError(t, ss.Err())
+	assert.Equal(t, expected, actual)
+}
+
+var errUpload = fmt.Errorf("upload failed")
+
+// failingBucket fails the upload of the chunk segments, after reading part of them.
+type failingBucket struct {
+	objstore.Bucket
+}
+
+func (b *failingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if strings.Contains(name, "/"+chunksDirname+"/") {
+		buf := make([]byte, 8)
+		io.ReadFull(r, buf) // nolint:errcheck
+		return errUpload
+	}
+	return b.Bucket.Upload(ctx, name, r)
+}
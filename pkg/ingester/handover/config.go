@@ -0,0 +1,60 @@
+package handover
+
+import (
+	"flag"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cortexproject/cortex/pkg/util/grpcclient"
+
+	"objectstorage/pkg/util/grpcpool"
+)
+
+var (
+	errInvalidChunkSize = errors.New("invalid handover chunk size")
+	errInvalidTimeout   = errors.New("invalid handover timeout")
+)
+
+// Config holds the configuration for transferring the local TSDB state of a
+// leaving ingester to its joining replacement.
+type Config struct {
+	Enabled          bool              `yaml:"enabled"`
+	Timeout          time.Duration     `yaml:"timeout"`
+	ChunkSizeBytes   int               `yaml:"chunk_size_bytes"`
+	IncludeWAL       bool              `yaml:"include_wal"`
+	GRPCClientConfig grpcclient.Config `yaml:"grpc_client_config"`
+	ClientPool       grpcpool.Config   `yaml:"client_pool"`
+}
+
+// RegisterFlags registers the handover flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "ingester.handover.enabled", false, "True to transfer unshipped blocks (and optionally the WAL) to a PENDING or JOINING ingester when this ingester is LEAVING. Useful when running with replication factor 1.")
+	f.DurationVar(&cfg.Timeout, "ingester.handover.timeout", 5*time.Minute, "Maximum time allowed to complete the handover to the joining ingester. On timeout the ingester falls back to flushing blocks on shutdown, if enabled. A starting ingester without any local TSDB waits up to this time for a handover.")
+	f.IntVar(&cfg.ChunkSizeBytes, "ingester.handover.chunk-size-bytes", 1024*1024, "Size - in bytes - of each file chunk sent to the joining ingester. Must be lower than the gRPC max message size.")
+	f.BoolVar(&cfg.IncludeWAL, "ingester.handover.include-wal", true, "True to transfer the WAL too, so that the joining ingester replays the in-memory head of the leaving one.")
+
+	cfg.GRPCClientConfig.RegisterFlagsWithPrefix("ingester.handover.client", f)
+	cfg.ClientPool.RegisterFlagsWithPrefix("ingester.handover.client", f)
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	if cfg.ChunkSizeBytes <= 0 || cfg.ChunkSizeBytes > cfg.GRPCClientConfig.MaxSendMsgSize {
+		return errInvalidChunkSize
+	}
+
+	if cfg.Timeout <= 0 {
+		return errInvalidTimeout
+	}
+
+	if err := cfg.ClientPool.Validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
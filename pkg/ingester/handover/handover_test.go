@@ -0,0 +1,164 @@
+package handover
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/shipper"
+	"github.com/weaveworks/common/httpgrpc"
+	httpgrpc_server "github.com/weaveworks/common/httpgrpc/server"
+	"google.golang.org/grpc"
+
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+
+	"objectstorage/pkg/tenant"
+)
+
+type serverClient struct {
+	server *httpgrpc_server.Server
+}
+
+func (c serverClient) Handle(ctx context.Context, req *httpgrpc.HTTPRequest, _ ...grpc.CallOption) (*httpgrpc.HTTPResponse, error) {
+	return c.server.Handle(ctx, req)
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+func TestFindPendingInstance(t *testing.T) {
+	desc := &ring.Desc{Ingesters: map[string]ring.InstanceDesc{
+		"ingester-1": {Addr: "1.1.1.1", State: ring.LEAVING},
+		"ingester-2": {Addr: "2.2.2.2", State: ring.ACTIVE},
+	}}
+
+	_, err := findPendingInstance(desc, "ingester-1")
+	assert.Equal(t, errNoPendingIngester, err)
+
+	desc.Ingesters["ingester-3"] = ring.InstanceDesc{Addr: "3.3.3.3", State: ring.PENDING}
+	instance, err := findPendingInstance(desc, "ingester-1")
+	require.NoError(t, err)
+	assert.Equal(t, "3.3.3.3", instance.Addr)
+}
+
+func TestSender_TransferOut_ShouldHandOverUnshippedBlocksAndWAL(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	shipped := ulid.MustNew(1, nil)
+	unshipped := ulid.MustNew(2, nil)
+
+	writeFile(t, filepath.Join(srcDir, "user-1", shipped.String(), "meta.json"), "shipped")
+	writeFile(t, filepath.Join(srcDir, "user-1", unshipped.String(), "meta.json"), "unshipped")
+	writeFile(t, filepath.Join(srcDir, "user-1", unshipped.String(), "chunks", "000001"), "chunks-data")
+	writeFile(t, filepath.Join(srcDir, "user-1", walDirname, "00000000"), "wal-data")
+	writeFile(t, filepath.Join(srcDir, "user-2", walDirname, "00000000"), "")
+	require.NoError(t, shipper.WriteMetaFile(log.NewNopLogger(), filepath.Join(srcDir, "user-1"), &shipper.Meta{
+		Version:  shipper.MetaVersion1,
+		Uploaded: []ulid.ULID{shipped},
+	}))
+
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Enabled = true
+	cfg.ChunkSizeBytes = 4
+
+	ringStore, closer := consul.NewInMemoryClient(ring.GetCodec(), log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+	require.NoError(t, ringStore.CAS(context.Background(), "ring", func(interface{}) (interface{}, bool, error) {
+		return &ring.Desc{Ingesters: map[string]ring.InstanceDesc{
+			"ingester-1": {Addr: "1.1.1.1", State: ring.LEAVING},
+			"ingester-2": {Addr: "2.2.2.2", State: ring.PENDING},
+		}}, true, nil
+	}))
+
+	receiver := NewReceiver(dstDir, log.NewNopLogger(), nil)
+
+	sender, err := NewSender(cfg, srcDir, "ingester-1", ringStore, "ring", log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	sender.newClient = func(addr string) (httpgrpc.HTTPClient, io.Closer, error) {
+		assert.Equal(t, "2.2.2.2", addr)
+		// The handover routes are authenticated.
+		return serverClient{server: httpgrpc_server.NewServer(tenant.HTTPMiddleware(true, "").Wrap(receiver))}, nopCloser{}, nil
+	}
+
+	require.NoError(t, sender.TransferOut(context.Background()))
+	assert.True(t, receiver.WaitForHandover(context.Background(), time.Second))
+	assert.NoDirExists(t, filepath.Join(dstDir, "user-1", shipped.String()))
+	assertFileContent(t, filepath.Join(dstDir, "user-1", unshipped.String(), "meta.json"), "unshipped")
+	assertFileContent(t, filepath.Join(dstDir, "user-1", unshipped.String(), "chunks", "000001"), "chunks-data")
+	assertFileContent(t, filepath.Join(dstDir, "user-1", walDirname, "00000000"), "wal-data")
+	assertFileContent(t, filepath.Join(dstDir, "user-2", walDirname, "00000000"), "")
+	assert.NoDirExists(t, filepath.Join(dstDir, stagingDirname))
+}
+
+func TestSender_TransferOut_ShouldSkipTheHandoverWithNothingToHandOver(t *testing.T) {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Enabled = true
+
+	ringStore, closer := consul.NewInMemoryClient(ring.GetCodec(), log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	sender, err := NewSender(cfg, t.TempDir(), "ingester-1", ringStore, "ring", log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	sender.newClient = func(string) (httpgrpc.HTTPClient, io.Closer, error) {
+		t.Fatal("no joining ingester should be contacted")
+		return nil, nil, nil
+	}
+
+	require.NoError(t, sender.TransferOut(context.Background()))
+}
+
+func TestHasLocalUsers(t *testing.T) {
+	dir := t.TempDir()
+
+	found, err := HasLocalUsers(filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	writeFile(t, filepath.Join(dir, stagingDirname, "user-1", walDirname, "00000000"), "")
+	writeFile(t, filepath.Join(dir, "file"), "")
+	found, err = HasLocalUsers(dir)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "user-1"), os.ModePerm))
+	found, err = HasLocalUsers(dir)
+	require.NoError(t, err)
+	assert.True(t, found)
+}
+
+func TestIsSafeRelativePath(t *testing.T) {
+	for input, expected := range map[string]bool{
+		"":              false,
+		"/etc/passwd":   false,
+		"../user-2/wal": false,
+		"wal/./0000":    false,
+		"wal/00000000":  true,
+		"user-1":        true,
+	} {
+		assert.Equal(t, expected, isSafeRelativePath(input), input)
+	}
+}
+
+func writeFile(t *testing.T, p, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(p), os.ModePerm))
+	require.NoError(t, os.WriteFile(p, []byte(content), 0o666))
+}
+
+func assertFileContent(t *testing.T, p, expected string) {
+	actual, err := os.ReadFile(p)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(actual))
+}
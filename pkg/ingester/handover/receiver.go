@@ -0,0 +1,216 @@
+package handover
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// FilePath is the HTTP path, reachable over httpgrpc, used to receive a file chunk.
+	FilePath = "/ingester/handover/file"
+
+	// CompletePath is the HTTP path, reachable over httpgrpc, used to signal the handover is complete.
+	CompletePath = "/ingester/handover/complete"
+
+	// stagingDirname is the directory, relative to the TSDB directory, where received
+	// files are stored until the handover completes.
+	stagingDirname = ".handover"
+)
+
+var errInvalidFilePath = errors.New("invalid handover file path")
+
+// Receiver accepts the TSDB files streamed by a leaving ingester and, once the handover
+// completes, moves them into the local TSDB directory.
+type Receiver struct {
+	tsdbDir string
+	logger  log.Logger
+
+	mtx       sync.Mutex
+	completed bool
+	done      chan struct{}
+
+	receivedBytes prometheus.Counter
+	completions   prometheus.Counter
+}
+
+// NewReceiver makes a new Receiver storing received files into tsdbDir.
+func NewReceiver(tsdbDir string, logger log.Logger, reg prometheus.Registerer) *Receiver {
+	return &Receiver{
+		tsdbDir: tsdbDir,
+		logger:  logger,
+		done:    make(chan struct{}),
+		receivedBytes: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_ingester_handover_received_bytes_total",
+			Help: "Total number of bytes received from leaving ingesters.",
+		}),
+		completions: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_ingester_handover_completed_total",
+			Help: "Total number of handovers received and completed.",
+		}),
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var err error
+	switch req.URL.Path {
+	case FilePath:
+		err = r.receiveFile(req)
+	case CompletePath:
+		err = r.complete()
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	if err != nil {
+		level.Error(r.logger).Log("msg", "failed to process handover request", "path", req.URL.Path, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WaitForHandover waits up to the input timeout for a handover to complete. Returns
+// true if a handover completed, false otherwise.
+func (r *Receiver) WaitForHandover(ctx context.Context, timeout time.Duration) bool {
+	select {
+	case <-r.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (r *Receiver) receiveFile(req *http.Request) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.completed {
+		return errors.New("handover already completed")
+	}
+
+	userID := req.URL.Query().Get("user")
+	relPath := req.URL.Query().Get("path")
+	if !isSafeRelativePath(userID) || !isSafeRelativePath(relPath) {
+		return errInvalidFilePath
+	}
+
+	offset, err := strconv.ParseInt(req.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return errors.New("invalid handover file offset")
+	}
+
+	dst := filepath.Join(r.tsdbDir, stagingDirname, userID, filepath.FromSlash(relPath))
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return errors.Wrap(err, "create handover staging directory")
+	}
+
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY, 0o666)
+	if err != nil {
+		return errors.Wrap(err, "open handover file")
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "seek handover file")
+	}
+
+	n, err := io.Copy(f, req.Body)
+	r.receivedBytes.Add(float64(n))
+	if err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "write handover file")
+	}
+
+	return errors.Wrap(f.Close(), "close handover file")
+}
+
+// complete moves the staged files into the TSDB directory. Entries already existing
+// locally are never overwritten.
+func (r *Receiver) complete() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.completed {
+		return nil
+	}
+
+	stagingDir := filepath.Join(r.tsdbDir, stagingDirname)
+	users, err := os.ReadDir(stagingDir)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "read handover staging directory")
+	}
+
+	for _, user := range users {
+		if !user.IsDir() {
+			continue
+		}
+
+		userDir := filepath.Join(r.tsdbDir, user.Name())
+		if err := os.MkdirAll(userDir, os.ModePerm); err != nil {
+			return errors.Wrap(err, "create user TSDB directory")
+		}
+
+		entries, err := os.ReadDir(filepath.Join(stagingDir, user.Name()))
+		if err != nil {
+			return errors.Wrap(err, "read handover user directory")
+		}
+
+		for _, entry := range entries {
+			dst := filepath.Join(userDir, entry.Name())
+			if _, err := os.Stat(dst); err == nil {
+				level.Warn(r.logger).Log("msg", "skipped handover entry because it already exists locally", "tenant", user.Name(), "entry", entry.Name())
+				continue
+			}
+
+			if err := os.Rename(filepath.Join(stagingDir, user.Name(), entry.Name()), dst); err != nil {
+				return errors.Wrapf(err, "move handover entry %s for user %s", entry.Name(), user.Name())
+			}
+		}
+	}
+
+	if err := os.RemoveAll(stagingDir); err != nil {
+		return errors.Wrap(err, "remove handover staging directory")
+	}
+
+	level.Info(r.logger).Log("msg", "handover completed", "users", len(users))
+	r.completions.Inc()
+	r.completed = true
+	close(r.done)
+	return nil
+}
+
+func isSafeRelativePath(p string) bool {
+	if p == "" || filepath.IsAbs(p) || strings.HasPrefix(p, "/") {
+		return false
+	}
+
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." || part == "." || part == "" {
+			return false
+		}
+	}
+	return true
+}
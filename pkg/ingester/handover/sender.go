@@ -0,0 +1,343 @@
+package handover
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/thanos/pkg/shipper"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/util/grpcpool"
+)
+
+const (
+	walDirname        = "wal"
+	headChunksDirname = "chunks_head"
+)
+
+var errNoPendingIngester = errors.New("no PENDING ingester found in the ring")
+
+// Sender transfers the unshipped blocks and, optionally, the WAL of a leaving ingester to
+// a PENDING ingester found in the ring. It implements the TransferOut() part of the
+// ring.FlushTransferer interface.
+type Sender struct {
+	services.Service
+
+	cfg        Config
+	tsdbDir    string
+	instanceID string
+	ringKV     kv.Client
+	ringKey    string
+	logger     log.Logger
+	pool       *grpcpool.Pool
+
+	// Allow to mock the client in tests.
+	newClient func(addr string) (httpgrpc.HTTPClient, io.Closer, error)
+
+	sentBytes prometheus.Counter
+	transfers *prometheus.CounterVec
+}
+
+// NewSender makes a new Sender. Its service health checks the pooled connections to the
+// joining ingesters.
+func NewSender(cfg Config, tsdbDir, instanceID string, ringKV kv.Client, ringKey string, logger log.Logger, reg prometheus.Registerer) (*Sender, error) {
+	opts, err := cfg.GRPCClientConfig.DialOption(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sender{
+		cfg:        cfg,
+		tsdbDir:    tsdbDir,
+		instanceID: instanceID,
+		ringKV:     ringKV,
+		ringKey:    ringKey,
+		logger:     logger,
+		sentBytes: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_ingester_handover_sent_bytes_total",
+			Help: "Total number of bytes sent to joining ingesters.",
+		}),
+		transfers: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_ingester_handover_transfers_total",
+			Help: "Total number of handovers attempted by this ingester, by outcome.",
+		}, []string{"outcome"}),
+	}
+
+	s.pool = grpcpool.NewPool("ingester-handover", cfg.ClientPool, opts, logger, reg)
+	s.Service = s.pool
+	s.newClient = s.dial
+	return s, nil
+}
+
+// TransferOut implements ring.FlushTransferer.
+func (s *Sender) TransferOut(ctx context.Context) error {
+	if !s.cfg.Enabled {
+		return ring.ErrTransferDisabled
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+
+	err := s.transferOut(ctx)
+	if err != nil {
+		s.transfers.WithLabelValues("failed").Inc()
+		level.Error(s.logger).Log("msg", "handover to joining ingester failed", "err", err)
+		return err
+	}
+
+	s.transfers.WithLabelValues("success").Inc()
+	return nil
+}
+
+func (s *Sender) transferOut(ctx context.Context) error {
+	files, err := s.listFiles()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		level.Info(s.logger).Log("msg", "skipped handover because there is nothing to hand over")
+		return nil
+	}
+
+	target, err := s.findTarget(ctx)
+	if err != nil {
+		return err
+	}
+
+	level.Info(s.logger).Log("msg", "starting handover", "to_ingester", target.Addr)
+
+	client, closer, err := s.newClient(target.Addr)
+	if err != nil {
+		return errors.Wrap(err, "connect to joining ingester")
+	}
+	defer closer.Close() //nolint:errcheck
+
+	for _, f := range files {
+		if err := s.sendFile(ctx, client, f.userID, f.relPath); err != nil {
+			return errors.Wrapf(err, "send file %s for user %s", f.relPath, f.userID)
+		}
+	}
+
+	// The handover routes are authenticated, so the completion is sent on behalf of the
+	// tenant of the last file.
+	if err := s.call(ctx, client, files[len(files)-1].userID, CompletePath, nil); err != nil {
+		return errors.Wrap(err, "complete handover")
+	}
+
+	level.Info(s.logger).Log("msg", "handover completed", "to_ingester", target.Addr, "files", len(files))
+	return nil
+}
+
+// findTarget returns the PENDING ingester the local state should be handed over to.
+func (s *Sender) findTarget(ctx context.Context) (ring.InstanceDesc, error) {
+	val, err := s.ringKV.Get(ctx, s.ringKey)
+	if err != nil {
+		return ring.InstanceDesc{}, errors.Wrap(err, "read ring")
+	}
+
+	desc, ok := val.(*ring.Desc)
+	if !ok || desc == nil {
+		return ring.InstanceDesc{}, errNoPendingIngester
+	}
+
+	return findPendingInstance(desc, s.instanceID)
+}
+
+func findPendingInstance(desc *ring.Desc, self string) (ring.InstanceDesc, error) {
+	for id, instance := range desc.Ingesters {
+		if id != self && instance.State == ring.PENDING {
+			return instance, nil
+		}
+	}
+
+	return ring.InstanceDesc{}, errNoPendingIngester
+}
+
+type handoverFile struct {
+	userID  string
+	relPath string
+}
+
+// listFiles returns the files to hand over: blocks not shipped yet and, if enabled,
+// the WAL and head chunks required to replay the in-memory head.
+func (s *Sender) listFiles() ([]handoverFile, error) {
+	userIDs, err := localUsers(s.tsdbDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []handoverFile
+	for _, userID := range userIDs {
+		userDir := filepath.Join(s.tsdbDir, userID)
+
+		uploaded := map[ulid.ULID]struct{}{}
+		if meta, err := shipper.ReadMetaFile(userDir); err == nil {
+			for _, id := range meta.Uploaded {
+				uploaded[id] = struct{}{}
+			}
+		} else if !os.IsNotExist(errors.Cause(err)) {
+			level.Warn(s.logger).Log("msg", "unable to read shipper meta file, all blocks will be handed over", "tenant", userID, "err", err)
+		}
+
+		entries, err := os.ReadDir(userDir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read TSDB directory for user %s", userID)
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+
+			if id, err := ulid.Parse(entry.Name()); err == nil {
+				if _, ok := uploaded[id]; ok {
+					continue
+				}
+			} else if !s.cfg.IncludeWAL || (entry.Name() != walDirname && entry.Name() != headChunksDirname) {
+				continue
+			}
+
+			err := filepath.WalkDir(filepath.Join(userDir, entry.Name()), func(p string, d os.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+
+				rel, err := filepath.Rel(userDir, p)
+				if err != nil {
+					return err
+				}
+
+				files = append(files, handoverFile{userID: userID, relPath: filepath.ToSlash(rel)})
+				return nil
+			})
+			if err != nil {
+				return nil, errors.Wrapf(err, "list files for user %s", userID)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// HasLocalUsers returns whether the TSDB directory already holds the TSDB of any user, in
+// which case there is nothing to wait a handover for.
+func HasLocalUsers(tsdbDir string) (bool, error) {
+	userIDs, err := localUsers(tsdbDir)
+	if os.IsNotExist(errors.Cause(err)) {
+		return false, nil
+	}
+	return len(userIDs) > 0, err
+}
+
+// localUsers returns the users having a TSDB in the directory, skipping the hidden ones
+// like the handover staging directory.
+func localUsers(tsdbDir string) ([]string, error) {
+	entries, err := os.ReadDir(tsdbDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read TSDB directory")
+	}
+
+	var userIDs []string
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			userIDs = append(userIDs, entry.Name())
+		}
+	}
+	return userIDs, nil
+}
+
+func (s *Sender) sendFile(ctx context.Context, client httpgrpc.HTTPClient, userID, relPath string) error {
+	f, err := os.Open(filepath.Join(s.tsdbDir, userID, filepath.FromSlash(relPath)))
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck
+
+	buf := make([]byte, s.cfg.ChunkSizeBytes)
+	offset := int64(0)
+
+	for {
+		n, readErr := io.ReadFull(f, buf)
+		if n > 0 || offset == 0 {
+			params := url.Values{}
+			params.Set("user", userID)
+			params.Set("path", path.Clean(relPath))
+			params.Set("offset", strconv.FormatInt(offset, 10))
+
+			if err := s.call(ctx, client, userID, FilePath+"?"+params.Encode(), buf[:n]); err != nil {
+				return err
+			}
+
+			offset += int64(n)
+			s.sentBytes.Add(float64(n))
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+func (s *Sender) call(ctx context.Context, client httpgrpc.HTTPClient, userID, target string, body []byte) error {
+	resp, err := client.Handle(ctx, &httpgrpc.HTTPRequest{
+		Method: http.MethodPost,
+		Url:    target,
+		Body:   body,
+		Headers: []*httpgrpc.Header{
+			{Key: "Content-Type", Values: []string{"application/octet-stream"}},
+			// The httpgrpc server doesn't canonicalize the header keys.
+			{Key: http.CanonicalHeaderKey(user.OrgIDHeaderName), Values: []string{userID}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if resp.Code/100 != 2 {
+		return fmt.Errorf("unexpected response status %d: %s", resp.Code, string(resp.Body))
+	}
+	return nil
+}
+
+// dial returns a client of the pooled connections to the address, whose closer releases
+// them once the handover is done.
+func (s *Sender) dial(addr string) (httpgrpc.HTTPClient, io.Closer, error) {
+	conn, err := s.pool.Get(addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return httpgrpc.NewHTTPClient(conn), poolCloser{pool: s.pool, addr: addr}, nil
+}
+
+type poolCloser struct {
+	pool *grpcpool.Pool
+	addr string
+}
+
+func (c poolCloser) Close() error {
+	c.pool.Remove(c.addr)
+	return nil
+}
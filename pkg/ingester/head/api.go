@@ -0,0 +1,341 @@
+package head
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+
+	"objectstorage/pkg/seriesdeletion"
+	"objectstorage/pkg/util/promapi"
+	"objectstorage/pkg/util/utf8names"
+)
+
+// Paths of the head API.
+const (
+	LabelNamesPath  = "/api/v1/labels"
+	LabelValuesPath = "/api/v1/label/{name}/values"
+	SeriesPath      = "/api/v1/series"
+)
+
+// The label name is the path segment between the label values path prefix and suffix.
+const (
+	labelValuesPathPrefix = "/api/v1/label/"
+	labelValuesPathSuffix = "/values"
+)
+
+var (
+	errInvalidMaxSeries     = errors.New("the head API max series must be greater than 0")
+	errInvalidSampleLimit   = errors.New("the head API remote read sample limit must be greater than 0")
+	errInvalidMaxBytesFrame = errors.New("the head API remote read max bytes in frame must be greater than 0")
+	errInvalidLimit         = errors.New("limit must be a positive number")
+	errMissingMatchers      = errors.New("no match[] parameter provided")
+)
+
+// Config holds the configuration of the head API.
+type Config struct {
+	MaxSeries                 int `yaml:"max_series"`
+	RemoteReadSampleLimit     int `yaml:"remote_read_sample_limit"`
+	RemoteReadMaxBytesInFrame int `yaml:"remote_read_max_bytes_in_frame"`
+}
+
+// RegisterFlags registers the head API flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.IntVar(&cfg.MaxSeries, "head-api.max-series", 10000, "Maximum number of series returned by a series request. The response is truncated, with a warning, beyond it.")
+	f.IntVar(&cfg.RemoteReadSampleLimit, "head-api.remote-read-sample-limit", 5e7, "Maximum number of samples returned by a sampled remote read request. The request fails beyond it.")
+	f.IntVar(&cfg.RemoteReadMaxBytesInFrame, "head-api.remote-read-max-bytes-in-frame", 1048576, "Maximum size in bytes of each frame of a streamed chunks remote read response.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if cfg.MaxSeries <= 0 {
+		return errInvalidMaxSeries
+	}
+	if cfg.RemoteReadSampleLimit <= 0 {
+		return errInvalidSampleLimit
+	}
+	if cfg.RemoteReadMaxBytesInFrame <= 0 {
+		return errInvalidMaxBytesFrame
+	}
+	return nil
+}
+
+// Source returns the in-memory series of the tenant in the context. It's implemented by the
+// ingester.
+type Source interface {
+	QuerySource
+
+	LabelNames(ctx context.Context, req *client.LabelNamesRequest) (*client.LabelNamesResponse, error)
+	LabelValues(ctx context.Context, req *client.LabelValuesRequest) (*client.LabelValuesResponse, error)
+	MetricsForLabelMatchers(ctx context.Context, req *client.MetricsForLabelMatchersRequest) (*client.MetricsForLabelMatchersResponse, error)
+	QueryExemplars(ctx context.Context, req *client.ExemplarQueryRequest) (*client.ExemplarQueryResponse, error)
+	MetricsMetadata(ctx context.Context, req *client.MetricsMetadataRequest) (*client.MetricsMetadataResponse, error)
+}
+
+// API serves the Prometheus HTTP API over the series of the ingester head, so that the
+// recent data can be read from the ingesters directly, for example by Grafana variable
+// queries.
+type API struct {
+	cfg       Config
+	source    Source
+	queryable storage.Queryable
+	logger    log.Logger
+
+	// marshalPool pools the buffers the streamed remote read frames are marshaled into.
+	marshalPool *sync.Pool
+}
+
+// NewAPI makes a new API. The samples deleted by the tombstones are masked in the remote read
+// responses, unless tombstonesOf is nil.
+func NewAPI(cfg Config, source Source, tombstonesOf seriesdeletion.TombstonesFunc, logger log.Logger) *API {
+	queryable := NewQueryable(source)
+	if tombstonesOf != nil {
+		queryable = seriesdeletion.Queryable(queryable, tombstonesOf)
+	}
+	return &API{cfg: cfg, source: source, queryable: queryable, logger: logger, marshalPool: &sync.Pool{}}
+}
+
+// LabelNames returns the sorted label names of the series within the time range, matching
+// any of the matchers sets if any.
+func (a *API) LabelNames(ctx context.Context, start, end int64, matchersSets [][]*labels.Matcher) ([]string, error) {
+	if len(matchersSets) == 0 {
+		resp, err := a.source.LabelNames(ctx, &client.LabelNamesRequest{StartTimestampMs: start, EndTimestampMs: end})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(resp.LabelNames)
+		return resp.LabelNames, nil
+	}
+
+	// The ingester doesn't filter the label names by matchers, so they're collected from
+	// the matching series.
+	series, err := a.series(ctx, start, end, matchersSets)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]struct{}{}
+	for _, s := range series {
+		for _, l := range s.Labels {
+			names[l.Name] = struct{}{}
+		}
+	}
+	return sortedKeys(names), nil
+}
+
+// LabelValues returns the sorted values of the label name in the series within the time
+// range, matching any of the matchers sets if any.
+func (a *API) LabelValues(ctx context.Context, name string, start, end int64, matchersSets [][]*labels.Matcher) ([]string, error) {
+	if len(matchersSets) == 0 {
+		matchersSets = [][]*labels.Matcher{nil}
+	}
+
+	values := map[string]struct{}{}
+	for _, matchers := range matchersSets {
+		req, err := client.ToLabelValuesRequest(model.LabelName(name), model.Time(start), model.Time(end), matchers)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := a.source.LabelValues(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range resp.LabelValues {
+			values[v] = struct{}{}
+		}
+	}
+	return sortedKeys(values), nil
+}
+
+// Series returns the sorted series within the time range matching any of the matchers sets,
+// at most limit of them. The returned bool is true if the series have been truncated.
+func (a *API) Series(ctx context.Context, start, end int64, matchersSets [][]*labels.Matcher, limit int) ([]labels.Labels, bool, error) {
+	metrics, err := a.series(ctx, start, end, matchersSets)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The series of different matchers sets may overlap.
+	seen := make(map[string]struct{}, len(metrics))
+	out := make([]labels.Labels, 0, len(metrics))
+	for i, m := range metrics {
+		// The response may be large, so the cancellation is checked while building it.
+		if i%1000 == 0 && ctx.Err() != nil {
+			return nil, false, ctx.Err()
+		}
+
+		ls := cortexpb.FromLabelAdaptersToLabels(m.Labels)
+		key := ls.String()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		out = append(out, ls)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return labels.Compare(out[i], out[j]) < 0 })
+	if len(out) > limit {
+		return out[:limit], true, nil
+	}
+	return out, false, nil
+}
+
+// series returns the series within the time range matching any of the matchers sets.
+func (a *API) series(ctx context.Context, start, end int64, matchersSets [][]*labels.Matcher) ([]*cortexpb.Metric, error) {
+	req := &client.MetricsForLabelMatchersRequest{StartTimestampMs: start, EndTimestampMs: end}
+	for _, matchers := range matchersSets {
+		ms, err := client.ToMetricsForLabelMatchersRequest(model.Time(start), model.Time(end), matchers)
+		if err != nil {
+			return nil, err
+		}
+		req.MatchersSet = append(req.MatchersSet, ms.MatchersSet...)
+	}
+
+	resp, err := a.source.MetricsForLabelMatchers(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Metric, nil
+}
+
+// LabelNamesHandler serves the label names.
+func (a *API) LabelNamesHandler(w http.ResponseWriter, r *http.Request) {
+	start, end, matchersSets, ok := a.parseSelection(w, r)
+	if !ok {
+		return
+	}
+
+	names, err := a.LabelNames(r.Context(), start, end, matchersSets)
+	if err != nil {
+		promapi.WriteError(w, errorType(err), err, a.logger)
+		return
+	}
+	if e, ok := utf8names.FromContext(r.Context()); ok {
+		names = utf8names.EscapeNames(names, e)
+	}
+	promapi.WriteSuccess(w, names, nil, a.logger)
+}
+
+// LabelValuesHandler serves the values of the label name in the path. The UTF-8 label names
+// are escaped in the path.
+func (a *API) LabelValuesHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, labelValuesPathPrefix), labelValuesPathSuffix)
+	escaping, utf8Names := utf8names.FromContext(r.Context())
+	if utf8Names {
+		name = utf8names.UnescapeName(name)
+	}
+	if !utf8names.IsValidLabelName(name, utf8Names) {
+		promapi.WriteError(w, promapi.ErrorBadData, errors.Errorf("invalid label name: %q", name), a.logger)
+		return
+	}
+
+	start, end, matchersSets, ok := a.parseSelection(w, r)
+	if !ok {
+		return
+	}
+
+	values, err := a.LabelValues(r.Context(), name, start, end, matchersSets)
+	if err != nil {
+		promapi.WriteError(w, errorType(err), err, a.logger)
+		return
+	}
+	if utf8Names && name == labels.MetricName {
+		values = utf8names.EscapeValues(values, escaping)
+	}
+	promapi.WriteSuccess(w, values, nil, a.logger)
+}
+
+// SeriesHandler serves the series matching the match[] selectors. The number of series is
+// bounded by the limit parameter, if lower than the configured max series.
+func (a *API) SeriesHandler(w http.ResponseWriter, r *http.Request) {
+	limit := a.cfg.MaxSeries
+	if v := r.FormValue("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 {
+			promapi.WriteError(w, promapi.ErrorBadData, errInvalidLimit, a.logger)
+			return
+		}
+		if l < limit {
+			limit = l
+		}
+	}
+
+	start, end, matchersSets, ok := a.parseSelection(w, r)
+	if !ok {
+		return
+	}
+	if len(matchersSets) == 0 {
+		promapi.WriteError(w, promapi.ErrorBadData, errMissingMatchers, a.logger)
+		return
+	}
+
+	series, truncated, err := a.Series(r.Context(), start, end, matchersSets, limit)
+	if err != nil {
+		promapi.WriteError(w, errorType(err), err, a.logger)
+		return
+	}
+
+	// The escaping changes the order of the series, so they're sorted again.
+	if e, ok := utf8names.FromContext(r.Context()); ok && e != utf8names.AllowUTF8 {
+		for i := range series {
+			series[i] = utf8names.EscapeLabels(series[i], e)
+		}
+		sort.Slice(series, func(i, j int) bool { return labels.Compare(series[i], series[j]) < 0 })
+	}
+
+	var warnings []string
+	if truncated {
+		warnings = append(warnings, "results truncated due to limit")
+	}
+	promapi.WriteSuccess(w, series, warnings, a.logger)
+}
+
+// parseSelection parses the time range and the matchers sets of the request, writing the
+// error response if they're invalid.
+func (a *API) parseSelection(w http.ResponseWriter, r *http.Request) (int64, int64, [][]*labels.Matcher, bool) {
+	start, end, err := promapi.ParseTimeRange(r)
+	if err != nil {
+		promapi.WriteError(w, promapi.ErrorBadData, err, a.logger)
+		return 0, 0, nil, false
+	}
+
+	matchersSets, err := promapi.ParseMatchers(r)
+	if err != nil {
+		promapi.WriteError(w, promapi.ErrorBadData, err, a.logger)
+		return 0, 0, nil, false
+	}
+	return timestamp.FromTime(start), timestamp.FromTime(end), matchersSets, true
+}
+
+func errorType(err error) string {
+	if errors.Is(err, context.Canceled) {
+		return promapi.ErrorCanceled
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return promapi.ErrorTimeout
+	}
+	return promapi.ErrorInternal
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,357 @@
+package head
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+
+	"objectstorage/pkg/util/utf8names"
+)
+
+type sourceMock struct {
+	series []labels.Labels
+}
+
+func (m *sourceMock) LabelNames(context.Context, *client.LabelNamesRequest) (*client.LabelNamesResponse, error) {
+	names := map[string]struct{}{}
+	for _, s := range m.series {
+		for _, l := range s {
+			names[l.Name] = struct{}{}
+		}
+	}
+	return &client.LabelNamesResponse{LabelNames: sortedKeys(names)}, nil
+}
+
+func (m *sourceMock) LabelValues(_ context.Context, req *client.LabelValuesRequest) (*client.LabelValuesResponse, error) {
+	var matchers []*labels.Matcher
+	if req.Matchers != nil {
+		var err error
+		if matchers, err = client.FromLabelMatchers(req.Matchers.Matchers); err != nil {
+			return nil, err
+		}
+	}
+
+	values := map[string]struct{}{}
+	for _, s := range m.matching(matchers) {
+		if v := s.Get(req.LabelName); v != "" {
+			values[v] = struct{}{}
+		}
+	}
+	return &client.LabelValuesResponse{LabelValues: sortedKeys(values)}, nil
+}
+
+func (m *sourceMock) MetricsForLabelMatchers(_ context.Context, req *client.MetricsForLabelMatchersRequest) (*client.MetricsForLabelMatchersResponse, error) {
+	resp := &client.MetricsForLabelMatchersResponse{}
+	for _, set := range req.MatchersSet {
+		matchers, err := client.FromLabelMatchers(set.Matchers)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range m.matching(matchers) {
+			resp.Metric = append(resp.Metric, &cortexpb.Metric{Labels: cortexpb.FromLabelsToLabelAdapters(s)})
+		}
+	}
+	return resp, nil
+}
+
+func (m *sourceMock) Query(_ context.Context, req *client.QueryRequest) (*client.QueryResponse, error) {
+	matchers, err := client.FromLabelMatchers(req.Matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &client.QueryResponse{}
+	for _, s := range m.matching(matchers) {
+		resp.Timeseries = append(resp.Timeseries, cortexpb.TimeSeries{
+			Labels:  cortexpb.FromLabelsToLabelAdapters(s),
+			Samples: []cortexpb.Sample{{TimestampMs: req.StartTimestampMs, Value: 1}},
+		})
+	}
+	return resp, nil
+}
+
+func (m *sourceMock) matching(matchers []*labels.Matcher) []labels.Labels {
+	var out []labels.Labels
+	for _, s := range m.series {
+		matches := true
+		for _, matcher := range matchers {
+			matches = matches && matcher.Matches(s.Get(matcher.Name))
+		}
+		if matches {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func newSourceMock() *sourceMock {
+	return &sourceMock{series: []labels.Labels{
+		labels.FromStrings("__name__", "up", "job", "node", "instance", "a"),
+		labels.FromStrings("__name__", "up", "job", "api", "instance", "b"),
+		labels.FromStrings("__name__", "http_requests_total", "job", "api", "code", "200"),
+	}}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *Config)
+		expected error
+	}{
+		"default config": {
+			setup:    func(*Config) {},
+			expected: nil,
+		},
+		"invalid max series": {
+			setup:    func(cfg *Config) { cfg.MaxSeries = 0 },
+			expected: errInvalidMaxSeries,
+		},
+		"invalid remote read sample limit": {
+			setup:    func(cfg *Config) { cfg.RemoteReadSampleLimit = 0 },
+			expected: errInvalidSampleLimit,
+		},
+		"invalid remote read max bytes in frame": {
+			setup:    func(cfg *Config) { cfg.RemoteReadMaxBytesInFrame = 0 },
+			expected: errInvalidMaxBytesFrame,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+			assert.ErrorIs(t, cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+func TestAPI_LabelNamesHandler(t *testing.T) {
+	tests := map[string]struct {
+		path           string
+		expectedStatus int
+		expectedData   []string
+	}{
+		"all label names": {
+			path:           LabelNamesPath,
+			expectedStatus: http.StatusOK,
+			expectedData:   []string{"__name__", "code", "instance", "job"},
+		},
+		"label names of the matching series": {
+			path:           LabelNamesPath + `?match[]=up{job="node"}&start=0&end=3600`,
+			expectedStatus: http.StatusOK,
+			expectedData:   []string{"__name__", "instance", "job"},
+		},
+		"invalid matcher": {
+			path:           LabelNamesPath + "?match[]=up{",
+			expectedStatus: http.StatusBadRequest,
+		},
+		"end before start": {
+			path:           LabelNamesPath + "?start=3600&end=0",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := NewAPI(Config{MaxSeries: 10}, newSourceMock(), nil, log.NewNopLogger())
+			rec := httptest.NewRecorder()
+			a.LabelNamesHandler(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+			if tc.expectedData != nil {
+				assert.Equal(t, tc.expectedData, decodeStrings(t, rec))
+			}
+		})
+	}
+}
+
+func TestAPI_LabelValuesHandler(t *testing.T) {
+	tests := map[string]struct {
+		path           string
+		expectedStatus int
+		expectedData   []string
+	}{
+		"all values": {
+			path:           "/api/v1/label/job/values",
+			expectedStatus: http.StatusOK,
+			expectedData:   []string{"api", "node"},
+		},
+		"values of the series matching any matchers set": {
+			path:           `/api/v1/label/instance/values?match[]=up{job="node"}&match[]={code="200"}`,
+			expectedStatus: http.StatusOK,
+			expectedData:   []string{"a"},
+		},
+		"metric names": {
+			path:           "/api/v1/label/__name__/values",
+			expectedStatus: http.StatusOK,
+			expectedData:   []string{"http_requests_total", "up"},
+		},
+		"invalid label name": {
+			path:           "/api/v1/label/not-valid/values",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := NewAPI(Config{MaxSeries: 10}, newSourceMock(), nil, log.NewNopLogger())
+			rec := httptest.NewRecorder()
+			a.LabelValuesHandler(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+			if tc.expectedData != nil {
+				assert.Equal(t, tc.expectedData, decodeStrings(t, rec))
+			}
+		})
+	}
+}
+
+func TestAPI_SeriesHandler(t *testing.T) {
+	tests := map[string]struct {
+		path             string
+		expectedStatus   int
+		expectedSeries   []map[string]string
+		expectedWarnings []string
+	}{
+		"series matching any matchers set": {
+			path:           `/api/v1/series?match[]=up{job="node"}&match[]={job="api"}`,
+			expectedStatus: http.StatusOK,
+			expectedSeries: []map[string]string{
+				{"__name__": "http_requests_total", "job": "api", "code": "200"},
+				{"__name__": "up", "job": "node", "instance": "a"},
+				{"__name__": "up", "job": "api", "instance": "b"},
+			},
+		},
+		"overlapping matchers sets": {
+			path:           `/api/v1/series?match[]=up&match[]=up{job="node"}`,
+			expectedStatus: http.StatusOK,
+			expectedSeries: []map[string]string{
+				{"__name__": "up", "job": "node", "instance": "a"},
+				{"__name__": "up", "job": "api", "instance": "b"},
+			},
+		},
+		"truncated series": {
+			path:             `/api/v1/series?match[]=up&limit=1`,
+			expectedStatus:   http.StatusOK,
+			expectedSeries:   []map[string]string{{"__name__": "up", "job": "node", "instance": "a"}},
+			expectedWarnings: []string{"results truncated due to limit"},
+		},
+		"missing matchers": {
+			path:           "/api/v1/series",
+			expectedStatus: http.StatusBadRequest,
+		},
+		"invalid limit": {
+			path:           "/api/v1/series?match[]=up&limit=0",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := NewAPI(Config{MaxSeries: 10}, newSourceMock(), nil, log.NewNopLogger())
+			rec := httptest.NewRecorder()
+			a.SeriesHandler(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+			if tc.expectedSeries == nil {
+				return
+			}
+
+			var resp struct {
+				Data     []map[string]string `json:"data"`
+				Warnings []string            `json:"warnings"`
+			}
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(t, tc.expectedSeries, resp.Data)
+			assert.Equal(t, tc.expectedWarnings, resp.Warnings)
+		})
+	}
+}
+
+func TestAPI_Series_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	a := NewAPI(Config{MaxSeries: 10}, newSourceMock(), nil, log.NewNopLogger())
+	_, _, err := a.Series(ctx, 0, 3600000, [][]*labels.Matcher{{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")}}, 10)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func decodeStrings(t *testing.T, rec *httptest.ResponseRecorder) []string {
+	var resp struct {
+		Status string   `json:"status"`
+		Data   []string `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, "success", resp.Status)
+	return resp.Data
+}
+
+func TestAPI_UTF8Names(t *testing.T) {
+	source := &sourceMock{series: []labels.Labels{
+		labels.FromStrings("__name__", "http.server.duration", "k8s.pod", "a"),
+		labels.FromStrings("__name__", "up", "job", "node"),
+	}}
+	a := NewAPI(Config{MaxSeries: 10}, source, nil, log.NewNopLogger())
+
+	request := func(path string, escaping utf8names.Escaping) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		return r.WithContext(utf8names.NewContext(r.Context(), escaping))
+	}
+
+	t.Run("series with quoted names, for a legacy client", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.SeriesHandler(rec, request(`/api/v1/series?match[]={"http.server.duration","k8s.pod"="a"}`, utf8names.Values))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var resp struct {
+			Data []map[string]string `json:"data"`
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(t, []map[string]string{{"__name__": "U__http_2e_server_2e_duration", "U__k8s_2e_pod": "a"}}, resp.Data)
+	})
+
+	t.Run("series with escaped names, for a UTF-8 client", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.SeriesHandler(rec, request(`/api/v1/series?match[]=U__http_2e_server_2e_duration`, utf8names.AllowUTF8))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var resp struct {
+			Data []map[string]string `json:"data"`
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(t, []map[string]string{{"__name__": "http.server.duration", "k8s.pod": "a"}}, resp.Data)
+	})
+
+	t.Run("label names", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.LabelNamesHandler(rec, request("/api/v1/labels", utf8names.Underscores))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, []string{"__name__", "job", "k8s_pod"}, decodeStrings(t, rec))
+	})
+
+	t.Run("label values of an escaped name", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.LabelValuesHandler(rec, request("/api/v1/label/U__k8s_2e_pod/values", utf8names.Values))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, []string{"a"}, decodeStrings(t, rec))
+	})
+
+	t.Run("metric names", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.LabelValuesHandler(rec, request("/api/v1/label/__name__/values", utf8names.Dots))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, []string{"http_dot_server_dot_duration", "up"}, decodeStrings(t, rec))
+	})
+}
@@ -0,0 +1,93 @@
+package head
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+
+	"objectstorage/pkg/util/promapi"
+)
+
+// ExemplarsPath is the path of the Prometheus exemplars query endpoint.
+const ExemplarsPath = "/api/v1/query_exemplars"
+
+// SeriesExemplars are the exemplars of a series, encoded as by Prometheus.
+type SeriesExemplars struct {
+	SeriesLabels labels.Labels `json:"seriesLabels"`
+	Exemplars    []Exemplar    `json:"exemplars"`
+}
+
+// Exemplar is an exemplar, encoded as by Prometheus: the value is a string and the timestamp
+// is in seconds.
+type Exemplar struct {
+	Labels    labels.Labels `json:"labels"`
+	Value     string        `json:"value"`
+	Timestamp float64       `json:"timestamp"`
+}
+
+// Exemplars returns the exemplars within the time range of the series selected by the
+// query. Only the selectors of the query are used, the query is not evaluated.
+func (a *API) Exemplars(ctx context.Context, start, end int64, expr parser.Expr) ([]SeriesExemplars, error) {
+	selectors := parser.ExtractSelectors(expr)
+	if len(selectors) == 0 {
+		return []SeriesExemplars{}, nil
+	}
+
+	req, err := client.ToExemplarQueryRequest(model.Time(start), model.Time(end), selectors...)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := a.source.QueryExemplars(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]SeriesExemplars, 0, len(resp.Timeseries))
+	for _, ts := range resp.Timeseries {
+		series := SeriesExemplars{
+			SeriesLabels: cortexpb.FromLabelAdaptersToLabels(ts.Labels),
+			Exemplars:    make([]Exemplar, 0, len(ts.Exemplars)),
+		}
+		for _, e := range ts.Exemplars {
+			series.Exemplars = append(series.Exemplars, Exemplar{
+				Labels:    cortexpb.FromLabelAdaptersToLabels(e.Labels),
+				Value:     strconv.FormatFloat(e.Value, 'f', -1, 64),
+				Timestamp: float64(e.TimestampMs) / 1000,
+			})
+		}
+		out = append(out, series)
+	}
+	return out, nil
+}
+
+// ExemplarsHandler serves the exemplars of the series selected by the query parameter, so
+// that Grafana can link the recent samples to their traces.
+func (a *API) ExemplarsHandler(w http.ResponseWriter, r *http.Request) {
+	start, end, err := promapi.ParseTimeRange(r)
+	if err != nil {
+		promapi.WriteError(w, promapi.ErrorBadData, err, a.logger)
+		return
+	}
+
+	expr, err := parser.ParseExpr(r.FormValue("query"))
+	if err != nil {
+		promapi.WriteError(w, promapi.ErrorBadData, err, a.logger)
+		return
+	}
+
+	exemplars, err := a.Exemplars(r.Context(), timestamp.FromTime(start), timestamp.FromTime(end), expr)
+	if err != nil {
+		promapi.WriteError(w, errorType(err), err, a.logger)
+		return
+	}
+	promapi.WriteSuccess(w, exemplars, nil, a.logger)
+}
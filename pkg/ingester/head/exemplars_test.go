@@ -0,0 +1,109 @@
+package head
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func (m *sourceMock) QueryExemplars(_ context.Context, req *client.ExemplarQueryRequest) (*client.ExemplarQueryResponse, error) {
+	_, _, matchersSets, err := client.FromExemplarQueryRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &client.ExemplarQueryResponse{}
+	for _, matchers := range matchersSets {
+		for _, s := range m.matching(matchers) {
+			resp.Timeseries = append(resp.Timeseries, cortexpb.TimeSeries{
+				Labels: cortexpb.FromLabelsToLabelAdapters(s),
+				Exemplars: []cortexpb.Exemplar{{
+					Labels:      cortexpb.FromLabelsToLabelAdapters(labels.FromStrings("trace_id", "abc")),
+					Value:       1.5,
+					TimestampMs: req.StartTimestampMs,
+				}},
+			})
+		}
+	}
+	return resp, nil
+}
+
+func TestAPI_ExemplarsHandler(t *testing.T) {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	a := NewAPI(cfg, newSourceMock(), nil, log.NewNopLogger())
+
+	tests := map[string]struct {
+		query          string
+		expectedStatus int
+		expectedSeries []string
+	}{
+		"selector": {
+			query:          `up{job="node"}`,
+			expectedStatus: http.StatusOK,
+			expectedSeries: []string{"node"},
+		},
+		"expression": {
+			query:          `sum by (job) (rate(up[5m]))`,
+			expectedStatus: http.StatusOK,
+			expectedSeries: []string{"node", "api"},
+		},
+		"no selector": {
+			query:          `vector(1)`,
+			expectedStatus: http.StatusOK,
+		},
+		"invalid query": {
+			query:          `sum(`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, ExemplarsPath+"?start=1&end=2", nil)
+			q := req.URL.Query()
+			q.Set("query", tc.query)
+			req.URL.RawQuery = q.Encode()
+
+			rec := httptest.NewRecorder()
+			a.ExemplarsHandler(rec, req)
+			require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Data []struct {
+					SeriesLabels map[string]string `json:"seriesLabels"`
+					Exemplars    []struct {
+						Labels    map[string]string `json:"labels"`
+						Value     string            `json:"value"`
+						Timestamp float64           `json:"timestamp"`
+					} `json:"exemplars"`
+				} `json:"data"`
+			}
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+
+			jobs := []string{}
+			for _, s := range resp.Data {
+				jobs = append(jobs, s.SeriesLabels["job"])
+				require.Len(t, s.Exemplars, 1)
+				assert.Equal(t, map[string]string{"trace_id": "abc"}, s.Exemplars[0].Labels)
+				assert.Equal(t, "1.5", s.Exemplars[0].Value)
+				assert.Equal(t, 1.0, s.Exemplars[0].Timestamp)
+			}
+			assert.ElementsMatch(t, tc.expectedSeries, jobs)
+		})
+	}
+}
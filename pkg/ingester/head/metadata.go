@@ -0,0 +1,118 @@
+package head
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+
+	"objectstorage/pkg/util/promapi"
+)
+
+// MetadataPath is the path of the Prometheus metric metadata endpoint.
+const MetadataPath = "/api/v1/metadata"
+
+var errInvalidMetadataLimit = errors.New("limit must be a number")
+
+// Metadata is the metadata of a metric, encoded as by Prometheus.
+type Metadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+// Metadata returns the distinct metadata of the metrics of the tenant, by metric name. If
+// metric is not empty, only the metadata of this metric is returned. A negative limit
+// doesn't bound the number of metrics, nor the limitPerMetric the number of metadata of
+// each metric. The metrics kept are the first ones in name order, so that the limited
+// responses are stable.
+func (a *API) Metadata(ctx context.Context, metric string, limit, limitPerMetric int) (map[string][]Metadata, error) {
+	resp, err := a.source.MetricsMetadata(ctx, &client.MetricsMetadataRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	all := map[string][]Metadata{}
+	for _, m := range resp.Metadata {
+		if metric != "" && m.MetricFamilyName != metric {
+			continue
+		}
+
+		md := Metadata{
+			Type: string(cortexpb.MetricMetadataMetricTypeToMetricType(m.Type)),
+			Help: m.Help,
+			Unit: m.Unit,
+		}
+		if !containsMetadata(all[m.MetricFamilyName], md) {
+			all[m.MetricFamilyName] = append(all[m.MetricFamilyName], md)
+		}
+	}
+
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if limit >= 0 && len(names) > limit {
+		names = names[:limit]
+	}
+
+	out := make(map[string][]Metadata, len(names))
+	for _, name := range names {
+		mds := all[name]
+		if limitPerMetric >= 0 && len(mds) > limitPerMetric {
+			mds = mds[:limitPerMetric]
+		}
+		out[name] = mds
+	}
+	return out, nil
+}
+
+func containsMetadata(mds []Metadata, md Metadata) bool {
+	for _, m := range mds {
+		if m == md {
+			return true
+		}
+	}
+	return false
+}
+
+// MetadataHandler serves the metric metadata, filtered by the metric parameter and bounded
+// by the limit and limit_per_metric parameters, if any.
+func (a *API) MetadataHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseMetadataLimit(r.FormValue("limit"))
+	if err != nil {
+		promapi.WriteError(w, promapi.ErrorBadData, err, a.logger)
+		return
+	}
+	limitPerMetric, err := parseMetadataLimit(r.FormValue("limit_per_metric"))
+	if err != nil {
+		promapi.WriteError(w, promapi.ErrorBadData, err, a.logger)
+		return
+	}
+
+	metadata, err := a.Metadata(r.Context(), r.FormValue("metric"), limit, limitPerMetric)
+	if err != nil {
+		promapi.WriteError(w, errorType(err), err, a.logger)
+		return
+	}
+	promapi.WriteSuccess(w, metadata, nil, a.logger)
+}
+
+// parseMetadataLimit parses a metadata limit, -1 if not set. As in Prometheus, negative
+// limits are allowed and disable the limit.
+func parseMetadataLimit(v string) (int, error) {
+	if v == "" {
+		return -1, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, errInvalidMetadataLimit
+	}
+	return limit, nil
+}
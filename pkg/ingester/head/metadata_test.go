@@ -0,0 +1,92 @@
+package head
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func (m *sourceMock) MetricsMetadata(context.Context, *client.MetricsMetadataRequest) (*client.MetricsMetadataResponse, error) {
+	return &client.MetricsMetadataResponse{Metadata: []*cortexpb.MetricMetadata{
+		{MetricFamilyName: "up", Type: cortexpb.GAUGE, Help: "Whether the target is up."},
+		// Sent by several targets.
+		{MetricFamilyName: "up", Type: cortexpb.GAUGE, Help: "Whether the target is up."},
+		{MetricFamilyName: "http_requests_total", Type: cortexpb.COUNTER, Help: "Total number of HTTP requests."},
+		{MetricFamilyName: "http_requests_total", Type: cortexpb.COUNTER, Help: "Total HTTP requests."},
+		{MetricFamilyName: "request_duration_seconds", Type: cortexpb.HISTOGRAM, Help: "Duration of the requests.", Unit: "seconds"},
+	}}, nil
+}
+
+func TestAPI_MetadataHandler(t *testing.T) {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	a := NewAPI(cfg, newSourceMock(), nil, log.NewNopLogger())
+
+	tests := map[string]struct {
+		query          string
+		expectedStatus int
+		expected       map[string][]Metadata
+	}{
+		"all metrics": {
+			expectedStatus: http.StatusOK,
+			expected: map[string][]Metadata{
+				"up":                       {{Type: "gauge", Help: "Whether the target is up."}},
+				"http_requests_total":      {{Type: "counter", Help: "Total number of HTTP requests."}, {Type: "counter", Help: "Total HTTP requests."}},
+				"request_duration_seconds": {{Type: "histogram", Help: "Duration of the requests.", Unit: "seconds"}},
+			},
+		},
+		"metric filter": {
+			query:          "?metric=up",
+			expectedStatus: http.StatusOK,
+			expected: map[string][]Metadata{
+				"up": {{Type: "gauge", Help: "Whether the target is up."}},
+			},
+		},
+		"limit": {
+			query:          "?limit=2&limit_per_metric=1",
+			expectedStatus: http.StatusOK,
+			expected: map[string][]Metadata{
+				"http_requests_total":      {{Type: "counter", Help: "Total number of HTTP requests."}},
+				"request_duration_seconds": {{Type: "histogram", Help: "Duration of the requests.", Unit: "seconds"}},
+			},
+		},
+		"negative limit": {
+			query:          "?metric=up&limit=-1",
+			expectedStatus: http.StatusOK,
+			expected: map[string][]Metadata{
+				"up": {{Type: "gauge", Help: "Whether the target is up."}},
+			},
+		},
+		"invalid limit": {
+			query:          "?limit=all",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			a.MetadataHandler(rec, httptest.NewRequest(http.MethodGet, MetadataPath+tc.query, nil))
+			require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Data map[string][]Metadata `json:"data"`
+			}
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(t, tc.expected, resp.Data)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package head
+
+import (
+	"context"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/querier/series"
+)
+
+// QuerySource returns the in-memory samples of the tenant in the context. It's implemented
+// by the ingester.
+type QuerySource interface {
+	Query(ctx context.Context, req *client.QueryRequest) (*client.QueryResponse, error)
+}
+
+// NewQueryable returns the queryable of the samples of the ingester head.
+func NewQueryable(source QuerySource) storage.Queryable {
+	return headQueryable{source: source}
+}
+
+type headQueryable struct {
+	source QuerySource
+}
+
+func (h headQueryable) Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+	return &headQuerier{ctx: ctx, source: h.source, mint: mint, maxt: maxt}, nil
+}
+
+type headQuerier struct {
+	ctx        context.Context
+	source     QuerySource
+	mint, maxt int64
+}
+
+func (h *headQuerier) Select(sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	mint, maxt := h.mint, h.maxt
+	if hints != nil {
+		mint, maxt = hints.Start, hints.End
+	}
+
+	req, err := client.ToQueryRequest(model.Time(mint), model.Time(maxt), matchers)
+	if err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+	resp, err := h.source.Query(h.ctx, req)
+	if err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+	return series.MatrixToSeriesSet(sortSeries, client.FromQueryResponse(resp))
+}
+
+// LabelValues is not used by the PromQL engine.
+func (h *headQuerier) LabelValues(string, ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	return nil, nil, nil
+}
+
+// LabelNames is not used by the PromQL engine.
+func (h *headQuerier) LabelNames(...*labels.Matcher) ([]string, storage.Warnings, error) {
+	return nil, nil, nil
+}
+
+func (h *headQuerier) Close() error {
+	return nil
+}
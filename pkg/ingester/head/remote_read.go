@@ -0,0 +1,124 @@
+package head
+
+import (
+	"net/http"
+
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+// RemoteReadPath is the path of the Prometheus remote read endpoint.
+const RemoteReadPath = "/api/v1/read"
+
+// badRequestError wraps the errors caused by an invalid remote read query.
+type badRequestError struct {
+	error
+}
+
+// RemoteReadHandler serves the Prometheus remote read requests over the samples of the head,
+// either sampled or as streamed chunks, depending on the response types accepted by the
+// client.
+func (a *API) RemoteReadHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := remote.DecodeReadRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	responseType, err := remote.NegotiateResponseType(req.AcceptedResponseTypes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch responseType {
+	case prompb.ReadRequest_STREAMED_XOR_CHUNKS:
+		a.remoteReadStreamedChunks(w, r, req)
+	default:
+		a.remoteReadSamples(w, r, req)
+	}
+}
+
+func (a *API) remoteReadSamples(w http.ResponseWriter, r *http.Request, req *prompb.ReadRequest) {
+	resp := &prompb.ReadResponse{Results: make([]*prompb.QueryResult, len(req.Queries))}
+	for i, query := range req.Queries {
+		result, err := a.remoteReadQuery(r, query, func(q storage.Querier, hints *storage.SelectHints, matchers []*labels.Matcher) (*prompb.QueryResult, error) {
+			result, _, err := remote.ToQueryResult(q.Select(false, hints, matchers...), a.cfg.RemoteReadSampleLimit)
+			return result, err
+		})
+		if err != nil {
+			a.remoteReadError(w, err)
+			return
+		}
+		resp.Results[i] = result
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Header().Set("Content-Encoding", "snappy")
+	if err := remote.EncodeReadResponse(resp, w); err != nil {
+		level.Error(a.logger).Log("msg", "failed to encode the remote read response", "err", err)
+	}
+}
+
+func (a *API) remoteReadStreamedChunks(w http.ResponseWriter, r *http.Request, req *prompb.ReadRequest) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "internal http.ResponseWriter does not implement http.Flusher interface", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse")
+	stream := remote.NewChunkedWriter(w, flusher)
+
+	for i, query := range req.Queries {
+		_, err := a.remoteReadQuery(r, query, func(q storage.Querier, hints *storage.SelectHints, matchers []*labels.Matcher) (*prompb.QueryResult, error) {
+			// The series must be sorted to be streamed.
+			series := storage.NewSeriesSetToChunkSet(q.Select(true, hints, matchers...))
+			_, err := remote.StreamChunkedReadResponses(stream, int64(i), series, nil, a.cfg.RemoteReadMaxBytesInFrame, a.marshalPool)
+			return nil, err
+		})
+		if err != nil {
+			a.remoteReadError(w, err)
+			return
+		}
+	}
+}
+
+// remoteReadQuery runs the read function against the querier of the time range of the
+// query.
+func (a *API) remoteReadQuery(r *http.Request, query *prompb.Query, read func(storage.Querier, *storage.SelectHints, []*labels.Matcher) (*prompb.QueryResult, error)) (*prompb.QueryResult, error) {
+	matchers, err := remote.FromLabelMatchers(query.Matchers)
+	if err != nil {
+		return nil, badRequestError{err}
+	}
+
+	hints := &storage.SelectHints{Start: query.StartTimestampMs, End: query.EndTimestampMs}
+	if h := query.Hints; h != nil {
+		hints = &storage.SelectHints{Start: h.StartMs, End: h.EndMs, Step: h.StepMs, Func: h.Func, Grouping: h.Grouping, By: h.By, Range: h.RangeMs}
+	}
+
+	q, err := a.queryable.Querier(r.Context(), query.StartTimestampMs, query.EndTimestampMs)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	return read(q, hints, matchers)
+}
+
+func (a *API) remoteReadError(w http.ResponseWriter, err error) {
+	if httpErr, ok := err.(remote.HTTPError); ok {
+		http.Error(w, httpErr.Error(), httpErr.Status())
+		return
+	}
+	if errors.As(err, &badRequestError{}) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	level.Error(a.logger).Log("msg", "failed to serve the remote read request", "err", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
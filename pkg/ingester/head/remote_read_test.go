@@ -0,0 +1,117 @@
+package head
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+
+	"objectstorage/pkg/storage/tsdb/bucketindex"
+)
+
+func TestAPI_RemoteReadHandler(t *testing.T) {
+	query, err := remote.ToQuery(1000, 2000, []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")}, nil)
+	require.NoError(t, err)
+
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	a := NewAPI(cfg, newSourceMock(), nil, log.NewNopLogger())
+
+	t.Run("sampled", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.RemoteReadHandler(rec, newRemoteReadRequest(t, &prompb.ReadRequest{Queries: []*prompb.Query{query}}))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		raw, err := snappy.Decode(nil, rec.Body.Bytes())
+		require.NoError(t, err)
+
+		var resp prompb.ReadResponse
+		require.NoError(t, proto.Unmarshal(raw, &resp))
+		require.Len(t, resp.Results, 1)
+		require.Len(t, resp.Results[0].Timeseries, 2)
+		assert.Equal(t, []prompb.Sample{{Timestamp: 1000, Value: 1}}, resp.Results[0].Timeseries[0].Samples)
+	})
+
+	t.Run("streamed chunks", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.RemoteReadHandler(rec, newRemoteReadRequest(t, &prompb.ReadRequest{
+			Queries:               []*prompb.Query{query},
+			AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{prompb.ReadRequest_STREAMED_XOR_CHUNKS},
+		}))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse", rec.Header().Get("Content-Type"))
+
+		reader := remote.NewChunkedReader(rec.Body, remote.DefaultChunkedReadLimit, nil)
+		series := 0
+		for {
+			var resp prompb.ChunkedReadResponse
+			if err := reader.NextProto(&resp); err == io.EOF {
+				break
+			} else {
+				require.NoError(t, err)
+			}
+			series += len(resp.ChunkedSeries)
+		}
+		assert.Equal(t, 2, series)
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.RemoteReadHandler(rec, httptest.NewRequest(http.MethodPost, RemoteReadPath, bytes.NewReader([]byte("invalid"))))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestAPI_RemoteReadHandler_ShouldMaskTheDeletedSamples(t *testing.T) {
+	query, err := remote.ToQuery(1000, 2000, []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")}, nil)
+	require.NoError(t, err)
+
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	a := NewAPI(cfg, newSourceMock(), func(context.Context, string) (bucketindex.Tombstones, error) {
+		return bucketindex.Tombstones{{RequestID: "request-1", Selectors: []string{`{job="node"}`}, StartTime: 0, EndTime: 1500}}, nil
+	}, log.NewNopLogger())
+
+	rec := httptest.NewRecorder()
+	req := newRemoteReadRequest(t, &prompb.ReadRequest{Queries: []*prompb.Query{query}})
+	a.RemoteReadHandler(rec, req.WithContext(user.InjectOrgID(req.Context(), "user-1")))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	raw, err := snappy.Decode(nil, rec.Body.Bytes())
+	require.NoError(t, err)
+
+	var resp prompb.ReadResponse
+	require.NoError(t, proto.Unmarshal(raw, &resp))
+	require.Len(t, resp.Results, 1)
+
+	samples := map[string][]prompb.Sample{}
+	for _, ts := range resp.Results[0].Timeseries {
+		for _, l := range ts.Labels {
+			if l.Name == "job" {
+				samples[l.Value] = ts.Samples
+			}
+		}
+	}
+	assert.Empty(t, samples["node"])
+	assert.Equal(t, []prompb.Sample{{Timestamp: 1000, Value: 1}}, samples["api"])
+}
+
+func newRemoteReadRequest(t *testing.T, req *prompb.ReadRequest) *http.Request {
+	raw, err := proto.Marshal(req)
+	require.NoError(t, err)
+	return httptest.NewRequest(http.MethodPost, RemoteReadPath, bytes.NewReader(snappy.Encode(nil, raw)))
+}
@@ -0,0 +1,233 @@
+package metrics
+
+import (
+	"context"
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/push"
+	"objectstorage/pkg/tenant"
+	"objectstorage/pkg/util/histogram"
+)
+
+// Reasons used to label the samples failed to be appended. The limit reasons match the
+// ones of the ingestion limits discarded samples.
+const (
+	reasonOutOfOrder           = "sample_out_of_order"
+	reasonTooOld               = "sample_too_old"
+	reasonPerUserSeriesLimit   = "per_user_series_limit"
+	reasonPerMetricSeriesLimit = "per_metric_series_limit"
+	reasonOther                = "other"
+)
+
+var errInvalidUpdatePeriod = errors.New("the series update period must be greater than 0")
+
+// Config holds the configuration of the ingestion metrics.
+type Config struct {
+	SeriesUpdatePeriod time.Duration `yaml:"series_update_period"`
+}
+
+// RegisterFlags registers the ingestion metrics flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.DurationVar(&cfg.SeriesUpdatePeriod, "ingestion-metrics.series-update-period", 15*time.Second, "How frequently the per-tenant active and in-memory series metrics are updated.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if cfg.SeriesUpdatePeriod <= 0 {
+		return errInvalidUpdatePeriod
+	}
+	return nil
+}
+
+// ActiveSeries returns the number of series recently pushed by each tenant. It's implemented
+// by the limits.Enforcer.
+type ActiveSeries interface {
+	ActiveSeriesByUser() map[string]int
+}
+
+// UserStats returns the stats of the TSDB head of each tenant. It's implemented by the ingester.
+type UserStats interface {
+	AllUserStats(ctx context.Context, req *client.UserStatsRequest) (*client.UsersStatsResponse, error)
+}
+
+// Metrics tracks the samples on the push path and the series of each tenant. The samples
+// received but neither appended nor failed have been dropped by a push middleware, which
+// tracks them on its own.
+type Metrics struct {
+	services.Service
+
+	activeSeries ActiveSeries
+	userStats    UserStats
+	logger       log.Logger
+
+	// The tenants with series gauges, to delete the gauges of the tenants gone.
+	activeUsers map[string]struct{}
+	memoryUsers map[string]struct{}
+
+	samplesIn        *prometheus.CounterVec
+	samplesAppended  *prometheus.CounterVec
+	samplesFailed    *prometheus.CounterVec
+	appendDuration   *prometheus.HistogramVec
+	activeSeriesVec  *prometheus.GaugeVec
+	memorySeriesVec  *prometheus.GaugeVec
+	seriesUpdateErrs prometheus.Counter
+}
+
+// NewMetrics makes a new Metrics. The userStats is nil if the TSDB heads are not in this
+// process, in which case the in-memory series are not tracked. The append duration buckets
+// are configured by the histograms config.
+func NewMetrics(cfg Config, histograms histogram.Config, activeSeries ActiveSeries, userStats UserStats, logger log.Logger, reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		activeSeries: activeSeries,
+		userStats:    userStats,
+		logger:       logger,
+		activeUsers:  map[string]struct{}{},
+		memoryUsers:  map[string]struct{}{},
+		samplesIn: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_ingestion_samples_in_total",
+			Help: "Total number of samples received on the push path.",
+		}, []string{"user"}),
+		samplesAppended: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_ingestion_samples_appended_total",
+			Help: "Total number of samples successfully appended.",
+		}, []string{"user"}),
+		samplesFailed: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_ingestion_samples_failed_total",
+			Help: "Total number of samples failed to be appended, by reason.",
+		}, []string{"reason", "user"}),
+		appendDuration: promauto.With(reg).NewHistogramVec(histograms.Opts(prometheus.HistogramOpts{
+			Name: "cortex_ingestion_append_duration_seconds",
+			Help: "Time spent appending a write request, by outcome.",
+		}), []string{"status"}),
+		activeSeriesVec: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_ingestion_active_series",
+			Help: "Number of series recently pushed per tenant.",
+		}, []string{"user"}),
+		memorySeriesVec: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_ingestion_memory_series",
+			Help: "Number of series in the TSDB head per tenant.",
+		}, []string{"user"}),
+		seriesUpdateErrs: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_ingestion_series_update_failures_total",
+			Help: "Total number of failures updating the per-tenant series metrics.",
+		}),
+	}
+
+	m.Service = services.NewTimerService(cfg.SeriesUpdatePeriod, nil, m.updateSeries, nil)
+	return m
+}
+
+// PushMiddleware returns the push.Middleware counting the samples received. It should be
+// the first middleware, to see the samples before they're dropped by any other one.
+func (m *Metrics) PushMiddleware() push.Middleware {
+	return func(next push.Func) push.Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			if userID, err := tenant.TenantID(ctx); err == nil {
+				m.samplesIn.WithLabelValues(userID).Add(float64(countSamples(req)))
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+// Wrap returns the append target instrumented with the appended and failed samples and the
+// append latency. The TSDB doesn't report which samples of a rejected request have been
+// appended, so all of them are counted as failed.
+func (m *Metrics) Wrap(target push.Func) push.Func {
+	return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		samples := float64(countSamples(req))
+		userID, _ := tenant.TenantID(ctx)
+
+		start := time.Now()
+		resp, err := target(ctx, req)
+		if err != nil {
+			m.appendDuration.WithLabelValues("failure").Observe(time.Since(start).Seconds())
+			m.samplesFailed.WithLabelValues(failureReason(err), userID).Add(samples)
+			return resp, err
+		}
+
+		m.appendDuration.WithLabelValues("success").Observe(time.Since(start).Seconds())
+		m.samplesAppended.WithLabelValues(userID).Add(samples)
+		return resp, nil
+	}
+}
+
+func (m *Metrics) updateSeries(ctx context.Context) error {
+	if m.activeSeries != nil {
+		m.activeUsers = setGauges(m.activeSeriesVec, m.activeUsers, m.activeSeries.ActiveSeriesByUser())
+	}
+
+	if m.userStats != nil {
+		stats, err := m.userStats.AllUserStats(ctx, &client.UserStatsRequest{})
+		if err != nil {
+			// Not fatal: the gauges are updated on the next tick.
+			m.seriesUpdateErrs.Inc()
+			level.Warn(m.logger).Log("msg", "failed to read the TSDB head stats", "err", err)
+			return nil
+		}
+
+		counts := make(map[string]int, len(stats.Stats))
+		for _, s := range stats.Stats {
+			if s.Data != nil {
+				counts[s.UserId] = int(s.Data.NumSeries)
+			}
+		}
+		m.memoryUsers = setGauges(m.memorySeriesVec, m.memoryUsers, counts)
+	}
+	return nil
+}
+
+// setGauges sets the gauge of each tenant and deletes the gauges of the tenants gone. It
+// returns the tenants with a gauge.
+func setGauges(vec *prometheus.GaugeVec, prev map[string]struct{}, counts map[string]int) map[string]struct{} {
+	curr := make(map[string]struct{}, len(counts))
+	for userID, count := range counts {
+		vec.WithLabelValues(userID).Set(float64(count))
+		curr[userID] = struct{}{}
+	}
+
+	for userID := range prev {
+		if _, ok := curr[userID]; !ok {
+			vec.DeleteLabelValues(userID)
+		}
+	}
+	return curr
+}
+
+// failureReason returns the reason of an append failure, read from the error message since
+// the errors returned by the ingester are not typed once they cross the gRPC boundary.
+func failureReason(err error) string {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "out of order sample"), strings.Contains(msg, "duplicate sample for timestamp"):
+		return reasonOutOfOrder
+	case strings.Contains(msg, "out of bounds"), strings.Contains(msg, "too old sample"):
+		return reasonTooOld
+	case strings.Contains(msg, "per-user series limit"):
+		return reasonPerUserSeriesLimit
+	case strings.Contains(msg, "per-metric series limit"):
+		return reasonPerMetricSeriesLimit
+	default:
+		return reasonOther
+	}
+}
+
+func countSamples(req *cortexpb.WriteRequest) int {
+	samples := 0
+	for _, ts := range req.Timeseries {
+		samples += len(ts.Samples)
+	}
+	return samples
+}
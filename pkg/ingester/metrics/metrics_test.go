@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+
+	"objectstorage/pkg/push"
+	"objectstorage/pkg/util/histogram"
+)
+
+type activeSeriesMock map[string]int
+
+func (m activeSeriesMock) ActiveSeriesByUser() map[string]int { return m }
+
+type userStatsMock map[string]uint64
+
+func (m userStatsMock) AllUserStats(context.Context, *client.UserStatsRequest) (*client.UsersStatsResponse, error) {
+	resp := &client.UsersStatsResponse{}
+	for userID, series := range m {
+		resp.Stats = append(resp.Stats, &client.UserIDStatsResponse{UserId: userID, Data: &client.UserStatsResponse{NumSeries: series}})
+	}
+	return resp, nil
+}
+
+func newRequest(samples int) *cortexpb.WriteRequest {
+	ts := &cortexpb.TimeSeries{Labels: []cortexpb.LabelAdapter{{Name: "__name__", Value: "up"}}}
+	for i := 0; i < samples; i++ {
+		ts.Samples = append(ts.Samples, cortexpb.Sample{Value: 1, TimestampMs: int64(i)})
+	}
+	return &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: ts}}}
+}
+
+func TestMetrics_Push(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	m := NewMetrics(Config{SeriesUpdatePeriod: time.Minute}, histogramConfig(), nil, nil, log.NewNopLogger(), reg)
+
+	var appendErr error
+	target := m.Wrap(func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		return &cortexpb.WriteResponse{}, appendErr
+	})
+	pushFunc := push.Chain(target, m.PushMiddleware())
+
+	ctx := user.InjectOrgID(context.Background(), "user-1")
+	_, err := pushFunc(ctx, newRequest(3))
+	require.NoError(t, err)
+
+	appendErr = errors.New("user=user-1: err: out of order sample. timestamp=1970-01-01T00:00:00Z, series={__name__=\"up\"}")
+	_, err = pushFunc(ctx, newRequest(2))
+	require.Error(t, err)
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_ingestion_samples_in_total Total number of samples received on the push path.
+		# TYPE cortex_ingestion_samples_in_total counter
+		cortex_ingestion_samples_in_total{user="user-1"} 5
+		# HELP cortex_ingestion_samples_appended_total Total number of samples successfully appended.
+		# TYPE cortex_ingestion_samples_appended_total counter
+		cortex_ingestion_samples_appended_total{user="user-1"} 3
+		# HELP cortex_ingestion_samples_failed_total Total number of samples failed to be appended, by reason.
+		# TYPE cortex_ingestion_samples_failed_total counter
+		cortex_ingestion_samples_failed_total{reason="sample_out_of_order",user="user-1"} 2
+	`), "cortex_ingestion_samples_in_total", "cortex_ingestion_samples_appended_total", "cortex_ingestion_samples_failed_total"))
+}
+
+func TestFailureReason(t *testing.T) {
+	tests := map[string]struct {
+		err      error
+		expected string
+	}{
+		"out of order": {
+			err:      errors.New("out of order sample"),
+			expected: reasonOutOfOrder,
+		},
+		"duplicate": {
+			err:      errors.New("duplicate sample for timestamp"),
+			expected: reasonOutOfOrder,
+		},
+		"too old": {
+			err:      errors.New("out of bounds"),
+			expected: reasonTooOld,
+		},
+		"per-user limit": {
+			err:      errors.New("per-user series limit of 10 exceeded"),
+			expected: reasonPerUserSeriesLimit,
+		},
+		"per-metric limit": {
+			err:      errors.New("per-metric series limit of 10 exceeded"),
+			expected: reasonPerMetricSeriesLimit,
+		},
+		"other": {
+			err:      errors.New("context deadline exceeded"),
+			expected: reasonOther,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, failureReason(tc.err))
+		})
+	}
+}
+
+func TestMetrics_UpdateSeries(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	active := activeSeriesMock{"user-1": 10, "user-2": 5}
+	m := NewMetrics(Config{SeriesUpdatePeriod: time.Minute}, histogramConfig(), active, userStatsMock{"user-1": 12}, log.NewNopLogger(), reg)
+
+	require.NoError(t, m.updateSeries(context.Background()))
+	delete(active, "user-2")
+	require.NoError(t, m.updateSeries(context.Background()))
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_ingestion_active_series Number of series recently pushed per tenant.
+		# TYPE cortex_ingestion_active_series gauge
+		cortex_ingestion_active_series{user="user-1"} 10
+		# HELP cortex_ingestion_memory_series Number of series in the TSDB head per tenant.
+		# TYPE cortex_ingestion_memory_series gauge
+		cortex_ingestion_memory_series{user="user-1"} 12
+	`), "cortex_ingestion_active_series", "cortex_ingestion_memory_series"))
+}
+
+func histogramConfig() histogram.Config {
+	cfg := histogram.Config{}
+	flagext.DefaultValues(&cfg)
+	return cfg
+}
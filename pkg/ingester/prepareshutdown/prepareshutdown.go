@@ -0,0 +1,220 @@
+package prepareshutdown
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+const (
+	// The endpoints preparing the ingester to be shut down, or scaled down.
+	ShutdownPath  = "/ingester/prepare-shutdown"
+	DownscalePath = "/ingester/prepare-downscale"
+
+	// markerFilename is the file, in the TSDB directory, persisting the preparation across
+	// restarts until the ingester is shut down for good.
+	markerFilename = "prepare-shutdown.json"
+)
+
+// Lifecycler controls what the ingester does on shutdown. It's implemented by the
+// ring.Lifecycler.
+type Lifecycler interface {
+	FlushOnShutdown() bool
+	SetFlushOnShutdown(bool)
+	ShouldUnregisterOnShutdown() bool
+	SetUnregisterOnShutdown(bool)
+}
+
+// ReadOnlySetter switches the ingester to (or out of) the read-only mode. It's implemented
+// by the readonly.Manager.
+type ReadOnlySetter interface {
+	SetReadOnly(ctx context.Context, readOnly bool) error
+}
+
+// Status is the preparation status returned by the HTTP endpoints.
+type Status struct {
+	Prepared  bool      `json:"prepared"`
+	Downscale bool      `json:"downscale"`
+	Since     time.Time `json:"since,omitempty"`
+}
+
+// Manager prepares the ingester ahead of a scale-down: once prepared, the ingester flushes
+// its head and leaves the ring on shutdown, instead of keeping its tokens to be restarted.
+// A downscale also switches the ingester to read-only, so that it stops receiving writes
+// before being shut down. The preparation is persisted in the TSDB directory, so that it
+// survives a restart of the ingester until it's undone.
+type Manager struct {
+	services.Service
+
+	markerPath string
+	lifecycler Lifecycler
+	readOnly   ReadOnlySetter
+	logger     log.Logger
+
+	// The shutdown behavior configured, restored when the preparation is undone.
+	flushOnShutdown      bool
+	unregisterOnShutdown bool
+
+	mtx    sync.Mutex
+	status Status
+
+	prepared prometheus.Gauge
+}
+
+// NewManager makes a new Manager. The lifecycler and readOnly are optional.
+func NewManager(tsdbDir string, lifecycler Lifecycler, readOnly ReadOnlySetter, logger log.Logger, reg prometheus.Registerer) *Manager {
+	m := &Manager{
+		markerPath: filepath.Join(tsdbDir, markerFilename),
+		lifecycler: lifecycler,
+		readOnly:   readOnly,
+		logger:     logger,
+		prepared: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_ingester_prepare_shutdown_requested",
+			Help: "1 if the ingester has been prepared to be shut down, 2 to be scaled down, 0 otherwise.",
+		}),
+	}
+	if lifecycler != nil {
+		m.flushOnShutdown = lifecycler.FlushOnShutdown()
+		m.unregisterOnShutdown = lifecycler.ShouldUnregisterOnShutdown()
+	}
+
+	m.Service = services.NewIdleService(m.starting, nil)
+	return m
+}
+
+// starting restores the preparation persisted before a restart.
+func (m *Manager) starting(ctx context.Context) error {
+	buf, err := os.ReadFile(m.markerPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "read prepare shutdown marker")
+	}
+
+	var status Status
+	if err := json.Unmarshal(buf, &status); err != nil {
+		return errors.Wrap(err, "decode prepare shutdown marker")
+	}
+	level.Info(m.logger).Log("msg", "restoring the shutdown preparation", "downscale", status.Downscale, "since", status.Since)
+	return m.apply(ctx, status)
+}
+
+// Prepare prepares the ingester to be shut down or, if downscale is true, scaled down.
+func (m *Manager) Prepare(ctx context.Context, downscale bool) error {
+	status := m.Status()
+	if !status.Prepared {
+		status.Since = time.Now()
+	}
+	status.Prepared = true
+	// Preparing a shutdown doesn't undo the preparation of a downscale.
+	status.Downscale = status.Downscale || downscale
+	return m.apply(ctx, status)
+}
+
+// Unprepare restores the configured shutdown behavior and, if the ingester was prepared to
+// be scaled down, switches it out of the read-only mode.
+func (m *Manager) Unprepare(ctx context.Context) error {
+	return m.apply(ctx, Status{})
+}
+
+func (m *Manager) apply(ctx context.Context, status Status) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	// The read-only mode is only changed when switching to or from a downscale, so that
+	// undoing a shutdown preparation doesn't affect a read-only mode enabled on its own.
+	if m.readOnly != nil && status.Downscale != m.status.Downscale {
+		if err := m.readOnly.SetReadOnly(ctx, status.Downscale); err != nil {
+			return err
+		}
+	}
+
+	if status.Prepared {
+		buf, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(m.markerPath, buf, 0o600); err != nil {
+			return errors.Wrap(err, "write prepare shutdown marker")
+		}
+	} else if err := os.Remove(m.markerPath); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "remove prepare shutdown marker")
+	}
+
+	if m.lifecycler != nil {
+		m.lifecycler.SetFlushOnShutdown(status.Prepared || m.flushOnShutdown)
+		m.lifecycler.SetUnregisterOnShutdown(status.Prepared || m.unregisterOnShutdown)
+	}
+
+	switch {
+	case status.Downscale:
+		m.prepared.Set(2)
+	case status.Prepared:
+		m.prepared.Set(1)
+	default:
+		m.prepared.Set(0)
+	}
+	if status.Prepared != m.status.Prepared || status.Downscale != m.status.Downscale {
+		level.Info(m.logger).Log("msg", "ingester shutdown preparation changed", "prepared", status.Prepared, "downscale", status.Downscale)
+	}
+	m.status = status
+	return nil
+}
+
+// Status returns the current preparation status.
+func (m *Manager) Status() Status {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.status
+}
+
+// ShutdownHandler returns the handler of the prepare-shutdown endpoint. GET returns the
+// current status, POST prepares the ingester to be shut down and DELETE undoes it.
+func (m *Manager) ShutdownHandler() http.Handler {
+	return m.handler(false)
+}
+
+// DownscaleHandler returns the handler of the prepare-downscale endpoint. GET returns the
+// current status, POST prepares the ingester to be scaled down and DELETE undoes it.
+func (m *Manager) DownscaleHandler() http.Handler {
+	return m.handler(true)
+}
+
+func (m *Manager) handler(downscale bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			err = m.Prepare(r.Context(), downscale)
+		case http.MethodDelete:
+			err = m.Unprepare(r.Context())
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil {
+			level.Error(m.logger).Log("msg", "failed to change the shutdown preparation", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(m.Status()); err != nil {
+			level.Error(m.logger).Log("msg", "failed to encode the shutdown preparation status", "err", err)
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package prepareshutdown
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+type lifecyclerMock struct {
+	flush, unregister bool
+}
+
+func (m *lifecyclerMock) FlushOnShutdown() bool              { return m.flush }
+func (m *lifecyclerMock) SetFlushOnShutdown(flush bool)      { m.flush = flush }
+func (m *lifecyclerMock) ShouldUnregisterOnShutdown() bool   { return m.unregister }
+func (m *lifecyclerMock) SetUnregisterOnShutdown(unreg bool) { m.unregister = unreg }
+
+type readOnlyMock struct {
+	calls []bool
+}
+
+func (m *readOnlyMock) SetReadOnly(_ context.Context, readOnly bool) error {
+	m.calls = append(m.calls, readOnly)
+	return nil
+}
+
+func TestManager_ServeHTTP(t *testing.T) {
+	lifecycler := &lifecyclerMock{}
+	readOnly := &readOnlyMock{}
+	reg := prometheus.NewPedanticRegistry()
+	m := NewManager(t.TempDir(), lifecycler, readOnly, log.NewNopLogger(), reg)
+
+	call := func(h http.Handler, method string) Status {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "/", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var status Status
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&status))
+		return status
+	}
+
+	status := call(m.ShutdownHandler(), http.MethodPost)
+	assert.True(t, status.Prepared)
+	assert.False(t, status.Downscale)
+	assert.True(t, lifecycler.flush)
+	assert.True(t, lifecycler.unregister)
+	assert.Empty(t, readOnly.calls)
+
+	// A downscale also switches the ingester to read-only, and isn't undone by preparing
+	// a shutdown.
+	status = call(m.DownscaleHandler(), http.MethodPost)
+	assert.True(t, status.Downscale)
+	status = call(m.ShutdownHandler(), http.MethodPost)
+	assert.True(t, status.Downscale)
+	assert.Equal(t, []bool{true}, readOnly.calls)
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_ingester_prepare_shutdown_requested 1 if the ingester has been prepared to be shut down, 2 to be scaled down, 0 otherwise.
+		# TYPE cortex_ingester_prepare_shutdown_requested gauge
+		cortex_ingester_prepare_shutdown_requested 2
+	`)))
+
+	status = call(m.DownscaleHandler(), http.MethodDelete)
+	assert.Equal(t, Status{}, status)
+	assert.False(t, lifecycler.flush)
+	assert.False(t, lifecycler.unregister)
+	assert.Equal(t, []bool{true, false}, readOnly.calls)
+	assert.Equal(t, Status{}, call(m.ShutdownHandler(), http.MethodGet))
+
+	rec := httptest.NewRecorder()
+	m.ShutdownHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestManager_ShouldRestoreTheConfiguredBehavior(t *testing.T) {
+	lifecycler := &lifecyclerMock{flush: true}
+	m := NewManager(t.TempDir(), lifecycler, nil, log.NewNopLogger(), nil)
+
+	require.NoError(t, m.Prepare(context.Background(), true))
+	assert.True(t, lifecycler.unregister)
+	require.NoError(t, m.Unprepare(context.Background()))
+	assert.True(t, lifecycler.flush)
+	assert.False(t, lifecycler.unregister)
+}
+
+func TestManager_ShouldRestoreThePreparationAfterARestart(t *testing.T) {
+	ctx := context.Background()
+	tsdbDir := t.TempDir()
+	m := NewManager(tsdbDir, &lifecyclerMock{}, nil, log.NewNopLogger(), nil)
+	require.NoError(t, m.Prepare(ctx, true))
+	since := m.Status().Since
+	require.FileExists(t, filepath.Join(tsdbDir, markerFilename))
+
+	lifecycler := &lifecyclerMock{}
+	readOnly := &readOnlyMock{}
+	m = NewManager(tsdbDir, lifecycler, readOnly, log.NewNopLogger(), nil)
+	require.NoError(t, services.StartAndAwaitRunning(ctx, m))
+	defer services.StopAndAwaitTerminated(ctx, m) //nolint:errcheck
+
+	assert.True(t, m.Status().Prepared)
+	assert.True(t, m.Status().Since.Equal(since))
+	assert.True(t, lifecycler.flush)
+	assert.True(t, lifecycler.unregister)
+	assert.Equal(t, []bool{true}, readOnly.calls)
+
+	require.NoError(t, m.Unprepare(ctx))
+	_, err := os.Stat(filepath.Join(tsdbDir, markerFilename))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,286 @@
+package readonly
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/thanos/pkg/shipper"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/events"
+	"objectstorage/pkg/push"
+)
+
+var errReadOnly = errors.New("ingester is in read-only mode and doesn't accept writes")
+
+// Config holds the configuration for the read-only mode.
+type Config struct {
+	Enabled       bool          `yaml:"enabled"`
+	CheckInterval time.Duration `yaml:"check_interval"`
+}
+
+// RegisterFlags registers the read-only mode flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "ingester.read-only", false, "True to start the ingester in read-only mode: it's removed from the write path of the ring but keeps serving queries until its blocks are shipped and the TSDB retention passed. The mode can also be enabled at runtime via the /ingester/read-only endpoint. Once the ingester left the ring, it must be restarted to leave the read-only mode.")
+	f.DurationVar(&cfg.CheckInterval, "ingester.read-only.check-interval", time.Minute, "How frequently to check whether a read-only ingester is safe to be removed.")
+}
+
+// StateChanger changes the state of the ingester in the ring. It's implemented by the ring.Lifecycler.
+type StateChanger interface {
+	ChangeState(ctx context.Context, state ring.InstanceState) error
+}
+
+// Publisher publishes the ingester events. It's implemented by events.Bus.
+type Publisher interface {
+	Publish(ev events.Event)
+}
+
+// Status is the read-only mode status returned by the HTTP endpoint.
+type Status struct {
+	ReadOnly        bool      `json:"read_only"`
+	Since           time.Time `json:"since,omitempty"`
+	UnshippedBlocks int       `json:"unshipped_blocks"`
+	SafeToRemove    bool      `json:"safe_to_remove"`
+}
+
+// Manager tracks whether the ingester is in read-only mode. While read-only, the ingester
+// is advertised as LEAVING in the ring, so that distributors stop sending writes to it
+// while queriers keep querying it.
+type Manager struct {
+	services.Service
+
+	cfg        Config
+	tsdbDir    string
+	retention  time.Duration
+	lifecycler StateChanger
+	publisher  Publisher
+	logger     log.Logger
+
+	mtx          sync.RWMutex
+	readOnly     bool
+	since        time.Time
+	unshipped    int
+	safeToRemove bool
+
+	readOnlyGauge     prometheus.Gauge
+	safeToRemoveGauge prometheus.Gauge
+	rejectedPushes    prometheus.Counter
+}
+
+// NewManager makes a new Manager. The tsdbDir and retention are used to detect when
+// all local data has been shipped and is not needed anymore by queriers. The publisher may
+// be nil.
+func NewManager(cfg Config, tsdbDir string, retention time.Duration, lifecycler StateChanger, publisher Publisher, logger log.Logger, reg prometheus.Registerer) *Manager {
+	m := &Manager{
+		cfg:        cfg,
+		tsdbDir:    tsdbDir,
+		retention:  retention,
+		lifecycler: lifecycler,
+		publisher:  publisher,
+		logger:     logger,
+		readOnlyGauge: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_ingester_read_only",
+			Help: "1 if the ingester is in read-only mode, 0 otherwise.",
+		}),
+		safeToRemoveGauge: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_ingester_read_only_safe_to_remove",
+			Help: "1 if the ingester is in read-only mode, all its blocks have been shipped and the retention passed, 0 otherwise.",
+		}),
+		rejectedPushes: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_ingester_read_only_rejected_pushes_total",
+			Help: "Total number of push requests rejected because the ingester is in read-only mode.",
+		}),
+	}
+
+	m.Service = services.NewTimerService(cfg.CheckInterval, m.starting, m.check, nil)
+	return m
+}
+
+func (m *Manager) starting(ctx context.Context) error {
+	if m.cfg.Enabled {
+		return m.SetReadOnly(ctx, true)
+	}
+	return nil
+}
+
+// SetReadOnly switches the ingester to (or out of) the read-only mode.
+func (m *Manager) SetReadOnly(ctx context.Context, readOnly bool) error {
+	state := ring.ACTIVE
+	if readOnly {
+		state = ring.LEAVING
+	}
+
+	if m.lifecycler != nil {
+		if err := m.lifecycler.ChangeState(ctx, state); err != nil {
+			return errors.Wrapf(err, "change ingester state to %s", state.String())
+		}
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.readOnly != readOnly {
+		m.since = time.Now()
+		if m.publisher != nil {
+			m.publisher.Publish(events.Event{
+				Type:    events.TypeIngesterStateChanged,
+				Details: map[string]string{"state": state.String(), "read_only": strconv.FormatBool(readOnly)},
+			})
+		}
+	}
+	m.readOnly = readOnly
+	m.safeToRemove = false
+
+	if readOnly {
+		m.readOnlyGauge.Set(1)
+	} else {
+		m.readOnlyGauge.Set(0)
+		m.safeToRemoveGauge.Set(0)
+	}
+
+	level.Info(m.logger).Log("msg", "ingester read-only mode changed", "read_only", readOnly)
+	return nil
+}
+
+// IsReadOnly returns whether the ingester is in read-only mode.
+func (m *Manager) IsReadOnly() bool {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return m.readOnly
+}
+
+// PushMiddleware returns a push.Middleware rejecting writes while the ingester is read-only.
+// Distributors may still send writes until they see the updated ring.
+func (m *Manager) PushMiddleware() push.Middleware {
+	return func(next push.Func) push.Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			if m.IsReadOnly() {
+				m.rejectedPushes.Inc()
+				return nil, httpgrpc.Errorf(http.StatusServiceUnavailable, errReadOnly.Error())
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+// Status returns the current read-only mode status.
+func (m *Manager) Status() Status {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	return Status{
+		ReadOnly:        m.readOnly,
+		Since:           m.since,
+		UnshippedBlocks: m.unshipped,
+		SafeToRemove:    m.safeToRemove,
+	}
+}
+
+func (m *Manager) check(_ context.Context) error {
+	if !m.IsReadOnly() {
+		return nil
+	}
+
+	unshipped, err := countUnshippedBlocks(m.tsdbDir)
+	if err != nil {
+		level.Warn(m.logger).Log("msg", "unable to count unshipped blocks", "err", err)
+		return nil
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.unshipped = unshipped
+	m.safeToRemove = unshipped == 0 && time.Since(m.since) >= m.retention
+	if m.safeToRemove {
+		m.safeToRemoveGauge.Set(1)
+	} else {
+		m.safeToRemoveGauge.Set(0)
+	}
+
+	return nil
+}
+
+// ServeHTTP implements http.Handler. GET returns the current status, POST enables
+// the read-only mode and DELETE disables it.
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodDelete:
+		if err := m.SetReadOnly(r.Context(), r.Method == http.MethodPost); err != nil {
+			level.Error(m.logger).Log("msg", "failed to change read-only mode", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(m.Status()); err != nil {
+		level.Error(m.logger).Log("msg", "failed to encode read-only status", "err", err)
+	}
+}
+
+// countUnshippedBlocks returns the number of local blocks, across all tenants, not
+// listed in the shipper meta file.
+func countUnshippedBlocks(tsdbDir string) (int, error) {
+	users, err := os.ReadDir(tsdbDir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, user := range users {
+		if !user.IsDir() || strings.HasPrefix(user.Name(), ".") {
+			continue
+		}
+
+		userDir := filepath.Join(tsdbDir, user.Name())
+		uploaded := map[ulid.ULID]struct{}{}
+		if meta, err := shipper.ReadMetaFile(userDir); err == nil {
+			for _, id := range meta.Uploaded {
+				uploaded[id] = struct{}{}
+			}
+		}
+
+		entries, err := os.ReadDir(userDir)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, entry := range entries {
+			id, err := ulid.Parse(entry.Name())
+			if err != nil || !entry.IsDir() {
+				continue
+			}
+			if _, ok := uploaded[id]; !ok {
+				count++
+			}
+		}
+	}
+
+	return count, nil
+}
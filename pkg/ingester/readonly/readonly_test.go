@@ -0,0 +1,114 @@
+package readonly
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/shipper"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ring"
+
+	"objectstorage/pkg/events"
+)
+
+type stateChangerMock struct {
+	states []ring.InstanceState
+}
+
+func (m *stateChangerMock) ChangeState(_ context.Context, state ring.InstanceState) error {
+	m.states = append(m.states, state)
+	return nil
+}
+
+type publisherMock struct {
+	events []events.Event
+}
+
+func (m *publisherMock) Publish(ev events.Event) {
+	m.events = append(m.events, ev)
+}
+
+func TestManager_PushMiddleware(t *testing.T) {
+	lifecycler := &stateChangerMock{}
+	publisher := &publisherMock{}
+	m := NewManager(Config{CheckInterval: time.Minute}, t.TempDir(), time.Hour, lifecycler, publisher, log.NewNopLogger(), nil)
+
+	pushed := 0
+	f := m.PushMiddleware()(func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		pushed++
+		return &cortexpb.WriteResponse{}, nil
+	})
+
+	_, err := f(context.Background(), &cortexpb.WriteRequest{})
+	require.NoError(t, err)
+
+	require.NoError(t, m.SetReadOnly(context.Background(), true))
+	_, err = f(context.Background(), &cortexpb.WriteRequest{})
+	require.Error(t, err)
+
+	require.NoError(t, m.SetReadOnly(context.Background(), false))
+	_, err = f(context.Background(), &cortexpb.WriteRequest{})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, pushed)
+	assert.Equal(t, []ring.InstanceState{ring.LEAVING, ring.ACTIVE}, lifecycler.states)
+	assert.Equal(t, []events.Event{
+		{Type: events.TypeIngesterStateChanged, Details: map[string]string{"state": "LEAVING", "read_only": "true"}},
+		{Type: events.TypeIngesterStateChanged, Details: map[string]string{"state": "ACTIVE", "read_only": "false"}},
+	}, publisher.events)
+}
+
+func TestManager_ShouldBeSafeToRemoveOnceBlocksAreShippedAndRetentionPassed(t *testing.T) {
+	tsdbDir := t.TempDir()
+	block := ulid.MustNew(1, nil)
+	require.NoError(t, os.MkdirAll(filepath.Join(tsdbDir, "user-1", block.String()), os.ModePerm))
+
+	m := NewManager(Config{CheckInterval: time.Minute}, tsdbDir, 0, nil, nil, log.NewNopLogger(), nil)
+	require.NoError(t, m.SetReadOnly(context.Background(), true))
+
+	require.NoError(t, m.check(context.Background()))
+	assert.Equal(t, 1, m.Status().UnshippedBlocks)
+	assert.False(t, m.Status().SafeToRemove)
+
+	require.NoError(t, shipper.WriteMetaFile(log.NewNopLogger(), filepath.Join(tsdbDir, "user-1"), &shipper.Meta{
+		Version:  shipper.MetaVersion1,
+		Uploaded: []ulid.ULID{block},
+	}))
+
+	require.NoError(t, m.check(context.Background()))
+	assert.Equal(t, 0, m.Status().UnshippedBlocks)
+	assert.True(t, m.Status().SafeToRemove)
+}
+
+func TestManager_ServeHTTP(t *testing.T) {
+	m := NewManager(Config{CheckInterval: time.Minute}, t.TempDir(), time.Hour, &stateChangerMock{}, nil, log.NewNopLogger(), nil)
+
+	for _, tc := range []struct {
+		method   string
+		expected bool
+	}{
+		{method: http.MethodGet, expected: false},
+		{method: http.MethodPost, expected: true},
+		{method: http.MethodGet, expected: true},
+		{method: http.MethodDelete, expected: false},
+	} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(tc.method, "/ingester/read-only", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		status := Status{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+		assert.Equal(t, tc.expected, status.ReadOnly, tc.method)
+	}
+}
@@ -0,0 +1,522 @@
+package shipper
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/shipper"
+	"golang.org/x/time/rate"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/events"
+	"objectstorage/pkg/ingester/flush"
+	"objectstorage/pkg/storage/bucket"
+	cortex_tsdb "objectstorage/pkg/storage/tsdb"
+	"objectstorage/pkg/webhook"
+)
+
+// uploadDir is the directory, in the TSDB directory of the tenant, the blocks are prepared
+// for the upload in. It's the one of the Thanos shipper, so that either can clean it up.
+const uploadDir = "thanos/upload"
+
+var (
+	errInvalidInterval    = errors.New("the shipper interval must be greater than 0")
+	errInvalidConcurrency = errors.New("the shipper concurrency must be greater than 0")
+	errInvalidBandwidth   = errors.New("the shipper max bandwidth must be 0 or greater")
+)
+
+// Config holds the configuration of the concurrent blocks shipper.
+type Config struct {
+	Enabled           bool          `yaml:"enabled"`
+	Interval          time.Duration `yaml:"interval"`
+	Concurrency       int           `yaml:"concurrency"`
+	MaxBandwidthBytes int           `yaml:"max_bandwidth_bytes"`
+}
+
+// RegisterFlags registers the shipper flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "shipper.enabled", false, "True to ship the TSDB blocks of all tenants with a global concurrency, oldest blocks first, instead of the ingester shipping. Requires -blocks-storage.tsdb.ship-interval=0.")
+	f.DurationVar(&cfg.Interval, "shipper.interval", time.Minute, "How frequently the TSDB blocks are scanned and the new ones shipped to the storage.")
+	f.IntVar(&cfg.Concurrency, "shipper.concurrency", 4, "Maximum number of blocks uploaded concurrently, across all tenants.")
+	f.IntVar(&cfg.MaxBandwidthBytes, "shipper.max-bandwidth-bytes", 0, "Maximum number of bytes per second uploaded, shared by all the concurrent uploads. 0 to disable the limit.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Interval <= 0 {
+		return errInvalidInterval
+	}
+	if cfg.Concurrency <= 0 {
+		return errInvalidConcurrency
+	}
+	if cfg.MaxBandwidthBytes < 0 {
+		return errInvalidBandwidth
+	}
+	return nil
+}
+
+// Notifier is notified of the blocks cut and shipped. It's implemented by webhook.Notifier.
+type Notifier interface {
+	Notify(ev webhook.Event)
+}
+
+// Publisher publishes the ingester events. It's implemented by events.Bus.
+type Publisher interface {
+	Publish(ev events.Event)
+}
+
+// Shipper uploads the TSDB blocks not shipped yet, across all tenants, to the bucket. The
+// blocks are uploaded concurrently, oldest first, so that an ingester catching up after a
+// long outage ships the blocks the compactor is waiting for first. The uploaded blocks are
+// recorded in the Thanos shipper meta file, so that the ingester retention only deletes the
+// shipped blocks.
+type Shipper struct {
+	services.Service
+
+	cfg         Config
+	tsdbDir     string
+	ingesterID  string
+	bkt         objstore.Bucket
+	cfgProvider bucket.TenantConfigProvider
+	notifier    Notifier
+	publisher   Publisher
+	logger      log.Logger
+
+	// cut are the blocks not shipped yet the notifier has been notified of, so that each
+	// block cut is notified once, as long as the shipper isn't restarted.
+	cutMtx sync.Mutex
+	cut    map[ulid.ULID]struct{}
+
+	// metaMtx serializes the updates of the shipper meta files.
+	metaMtx sync.Mutex
+
+	uploads        prometheus.Counter
+	uploadFailures prometheus.Counter
+	pending        prometheus.Gauge
+}
+
+// NewShipper makes a new Shipper. The blocks are uploaded to the bucket of each tenant, with
+// the tenant and ingester ID external labels, as done by the ingester. The notifier and the
+// publisher may be nil.
+func NewShipper(cfg Config, tsdbDir, ingesterID string, bkt objstore.Bucket, cfgProvider bucket.TenantConfigProvider, notifier Notifier, publisher Publisher, logger log.Logger, reg prometheus.Registerer) *Shipper {
+	if cfg.MaxBandwidthBytes > 0 {
+		bkt = newRateLimitedBucket(bkt, cfg.MaxBandwidthBytes)
+	}
+
+	s := &Shipper{
+		cfg:         cfg,
+		tsdbDir:     tsdbDir,
+		ingesterID:  ingesterID,
+		bkt:         bkt,
+		cfgProvider: cfgProvider,
+		notifier:    notifier,
+		publisher:   publisher,
+		logger:      logger,
+		cut:         map[ulid.ULID]struct{}{},
+		uploads: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_shipper_block_uploads_total",
+			Help: "Total number of blocks uploaded to the storage.",
+		}),
+		uploadFailures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_shipper_block_upload_failures_total",
+			Help: "Total number of blocks failed to be uploaded to the storage.",
+		}),
+		pending: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_shipper_pending_blocks",
+			Help: "Number of blocks not shipped yet, as of the last scan.",
+		}),
+	}
+
+	s.Service = services.NewTimerService(cfg.Interval, nil, s.iteration, nil)
+	return s
+}
+
+// pendingBlock is a block not shipped yet.
+type pendingBlock struct {
+	userID string
+	meta   *metadata.Meta
+}
+
+func (s *Shipper) iteration(ctx context.Context) error {
+	blocks, err := s.pendingBlocks(ctx)
+	if err != nil {
+		// Not fatal, the blocks are scanned again at the next iteration.
+		level.Warn(s.logger).Log("msg", "failed to list the blocks to ship", "err", err)
+		return nil
+	}
+	s.pending.Set(float64(len(blocks)))
+	s.notifyCut(blocks)
+
+	queue := make(chan pendingBlock)
+	wg := sync.WaitGroup{}
+	for i := 0; i < s.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range queue {
+				s.ship(ctx, b)
+			}
+		}()
+	}
+
+	// The blocks are queued oldest first, so that they're uploaded in order as the workers
+	// free up.
+	for _, b := range blocks {
+		select {
+		case queue <- b:
+		case <-ctx.Done():
+		}
+	}
+	close(queue)
+	wg.Wait()
+	return nil
+}
+
+// pendingBlocks returns the blocks not shipped yet across all tenants, oldest first. The
+// blocks of the tenants marked for deletion are never shipped.
+func (s *Shipper) pendingBlocks(ctx context.Context) ([]pendingBlock, error) {
+	users, err := os.ReadDir(s.tsdbDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var out []pendingBlock
+	for _, user := range users {
+		if !user.IsDir() || strings.HasPrefix(user.Name(), ".") {
+			continue
+		}
+
+		userID := user.Name()
+		blocks, err := s.userPendingBlocks(userID)
+		if err != nil {
+			level.Warn(s.logger).Log("msg", "failed to list the blocks to ship of the tenant", "tenant", userID, "err", err)
+			continue
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+
+		deleted, err := cortex_tsdb.TenantDeletionMarkExists(ctx, s.bkt, userID)
+		if err != nil {
+			level.Warn(s.logger).Log("msg", "failed to check the tenant deletion mark, shipping the blocks anyway", "tenant", userID, "err", err)
+		}
+		if deleted {
+			continue
+		}
+		out = append(out, blocks...)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].meta.MinTime != out[j].meta.MinTime {
+			return out[i].meta.MinTime < out[j].meta.MinTime
+		}
+		return out[i].meta.ULID.Compare(out[j].meta.ULID) < 0
+	})
+	return out, nil
+}
+
+// userPendingBlocks returns the blocks of the tenant not listed in its shipper meta file. As
+// in the ingester, only the blocks compacted from the head are shipped: the compactor takes
+// care of the others.
+func (s *Shipper) userPendingBlocks(userID string) ([]pendingBlock, error) {
+	userDir := filepath.Join(s.tsdbDir, userID)
+	uploaded := map[ulid.ULID]struct{}{}
+	if meta, err := shipper.ReadMetaFile(userDir); err == nil {
+		for _, id := range meta.Uploaded {
+			uploaded[id] = struct{}{}
+		}
+	}
+
+	entries, err := os.ReadDir(userDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []pendingBlock
+	for _, entry := range entries {
+		id, err := ulid.Parse(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		if _, ok := uploaded[id]; ok {
+			continue
+		}
+
+		meta, err := metadata.ReadFromDir(filepath.Join(userDir, entry.Name()))
+		if err != nil {
+			level.Warn(s.logger).Log("msg", "failed to read the block meta, skipping it", "tenant", userID, "block", id.String(), "err", err)
+			continue
+		}
+		if meta.Compaction.Level > 1 {
+			continue
+		}
+		out = append(out, pendingBlock{userID: userID, meta: meta})
+	}
+	return out, nil
+}
+
+// ship uploads the block and records it in the shipper meta file of the tenant.
+func (s *Shipper) ship(ctx context.Context, b pendingBlock) {
+	logger := log.With(s.logger, "tenant", b.userID, "block", b.meta.ULID.String())
+
+	start := time.Now()
+	if err := s.upload(ctx, b); err != nil {
+		s.uploadFailures.Inc()
+		s.publishUploadFailed(b.userID, b.meta.ULID, err)
+		level.Warn(logger).Log("msg", "failed to upload the block, it will be retried at the next iteration", "err", err)
+		return
+	}
+
+	if err := s.markUploaded(b.userID, b.meta.ULID); err != nil {
+		// The block is uploaded again at the next iteration, which is harmless.
+		level.Warn(logger).Log("msg", "failed to record the uploaded block in the shipper meta file", "err", err)
+		return
+	}
+
+	s.uploads.Inc()
+	level.Info(logger).Log("msg", "block uploaded", "duration", time.Since(start))
+
+	if s.notifier != nil {
+		size, err := dirSize(filepath.Join(s.tsdbDir, b.userID, b.meta.ULID.String()))
+		if err != nil {
+			level.Warn(logger).Log("msg", "failed to compute the size of the shipped block", "err", err)
+		}
+		s.notifier.Notify(blockEvent(webhook.EventBlockShipped, b.userID, b.meta.BlockMeta, size))
+	}
+}
+
+func (s *Shipper) publishUploadFailed(userID string, id ulid.ULID, err error) {
+	if s.publisher == nil {
+		return
+	}
+	s.publisher.Publish(events.Event{
+		Type:    events.TypeUploadFailed,
+		Tenant:  userID,
+		Details: map[string]string{"block": id.String(), "error": err.Error()},
+	})
+}
+
+// notifyCut notifies the pending blocks not notified yet as cut. The blocks not pending
+// anymore are forgotten.
+func (s *Shipper) notifyCut(blocks []pendingBlock) {
+	if s.notifier == nil {
+		return
+	}
+
+	s.cutMtx.Lock()
+	defer s.cutMtx.Unlock()
+
+	cut := make(map[ulid.ULID]struct{}, len(blocks))
+	for _, b := range blocks {
+		cut[b.meta.ULID] = struct{}{}
+		if _, ok := s.cut[b.meta.ULID]; ok {
+			continue
+		}
+
+		size, err := dirSize(filepath.Join(s.tsdbDir, b.userID, b.meta.ULID.String()))
+		if err != nil {
+			level.Warn(s.logger).Log("msg", "failed to compute the size of the cut block", "tenant", b.userID, "block", b.meta.ULID.String(), "err", err)
+		}
+		s.notifier.Notify(blockEvent(webhook.EventBlockCut, b.userID, b.meta.BlockMeta, size))
+	}
+	s.cut = cut
+}
+
+// upload uploads the block, from hard links, so that the meta file can be updated with the
+// external labels without changing the local block.
+func (s *Shipper) upload(ctx context.Context, b pendingBlock) error {
+	userDir := filepath.Join(s.tsdbDir, b.userID)
+	dir := filepath.Join(userDir, uploadDir, b.meta.ULID.String())
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.Wrap(err, "clean upload directory")
+	}
+	defer os.RemoveAll(dir)
+
+	if err := hardlinkBlock(filepath.Join(userDir, b.meta.ULID.String()), dir); err != nil {
+		return errors.Wrap(err, "hard link block")
+	}
+
+	meta := *b.meta
+	meta.Thanos.Labels = map[string]string{
+		cortex_tsdb.TenantIDExternalLabel:   b.userID,
+		cortex_tsdb.IngesterIDExternalLabel: s.ingesterID,
+	}
+	meta.Thanos.Source = metadata.ReceiveSource
+	if err := meta.WriteToDir(s.logger, dir); err != nil {
+		return errors.Wrap(err, "write meta file")
+	}
+
+	userBkt := bucket.NewUserBucketClient(b.userID, s.bkt, s.cfgProvider)
+	return block.Upload(ctx, s.logger, userBkt, dir, metadata.NoneFunc)
+}
+
+// FlushHead uploads the series of the head of the tenant within [mint, maxt) as a new block,
+// streaming the chunks straight to the bucket instead of compacting the head to the local
+// disk and shipping the block afterwards. The block is not kept locally, so it's meant for
+// the flushes of a shutting down ingester. It returns the ULID of the uploaded block.
+func (s *Shipper) FlushHead(ctx context.Context, userID string, head tsdb.BlockReader, mint, maxt int64) (ulid.ULID, error) {
+	extLabels := map[string]string{
+		cortex_tsdb.TenantIDExternalLabel:   userID,
+		cortex_tsdb.IngesterIDExternalLabel: s.ingesterID,
+	}
+
+	userBkt := bucket.NewUserBucketClient(userID, s.bkt, s.cfgProvider)
+	id, err := flush.UploadBlock(ctx, head, mint, maxt, userBkt, filepath.Join(s.tsdbDir, userID, uploadDir), extLabels)
+	if err != nil {
+		s.uploadFailures.Inc()
+		s.publishUploadFailed(userID, id, err)
+		return id, errors.Wrap(err, "flush head")
+	}
+
+	s.uploads.Inc()
+	level.Info(s.logger).Log("msg", "head flushed", "tenant", userID, "block", id.String())
+
+	// The block is cut and shipped at once.
+	if s.notifier != nil {
+		size, err := bucketBlockSize(ctx, userBkt, id)
+		if err != nil {
+			level.Warn(s.logger).Log("msg", "failed to compute the size of the flushed block", "tenant", userID, "block", id.String(), "err", err)
+		}
+		meta := tsdb.BlockMeta{ULID: id, MinTime: mint, MaxTime: maxt}
+		s.notifier.Notify(blockEvent(webhook.EventBlockCut, userID, meta, size))
+		s.notifier.Notify(blockEvent(webhook.EventBlockShipped, userID, meta, size))
+	}
+	return id, nil
+}
+
+func (s *Shipper) markUploaded(userID string, id ulid.ULID) error {
+	s.metaMtx.Lock()
+	defer s.metaMtx.Unlock()
+
+	userDir := filepath.Join(s.tsdbDir, userID)
+	meta, err := shipper.ReadMetaFile(userDir)
+	if err != nil {
+		if !os.IsNotExist(errors.Cause(err)) {
+			return err
+		}
+		meta = &shipper.Meta{Version: shipper.MetaVersion1}
+	}
+
+	meta.Uploaded = append(meta.Uploaded, id)
+	return shipper.WriteMetaFile(s.logger, userDir, meta)
+}
+
+// hardlinkBlock hard links the files of the block in src to dst.
+func hardlinkBlock(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, 0o750)
+		}
+		return os.Link(path, target)
+	})
+}
+
+// newRateLimitedBucket returns a bucket whose uploads share a bandwidth of bytesPerSec.
+func newRateLimitedBucket(bkt objstore.Bucket, bytesPerSec int) objstore.Bucket {
+	return &rateLimitedBucket{Bucket: bkt, limiter: rate.NewLimiter(rate.Limit(bytesPerSec), bytesPerSec)}
+}
+
+type rateLimitedBucket struct {
+	objstore.Bucket
+
+	limiter *rate.Limiter
+}
+
+func (b *rateLimitedBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	return b.Bucket.Upload(ctx, name, &rateLimitedReader{ctx: ctx, r: r, limiter: b.limiter})
+}
+
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	// The reads can't be larger than the burst, which is the bandwidth.
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+func blockEvent(event, userID string, meta tsdb.BlockMeta, size int64) webhook.Event {
+	return webhook.Event{
+		Event:     event,
+		Tenant:    userID,
+		ULID:      meta.ULID,
+		MinTime:   meta.MinTime,
+		MaxTime:   meta.MaxTime,
+		SizeBytes: size,
+	}
+}
+
+// dirSize returns the size of the files of the local block directory.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// bucketBlockSize returns the size of the objects of the block in the bucket.
+func bucketBlockSize(ctx context.Context, bkt objstore.Bucket, id ulid.ULID) (int64, error) {
+	var size int64
+	err := bkt.Iter(ctx, id.String()+objstore.DirDelim, func(name string) error {
+		attrs, err := bkt.Attributes(ctx, name)
+		if err != nil {
+			return err
+		}
+		size += attrs.Size
+		return nil
+	}, objstore.WithRecursiveIter)
+	return size, err
+}
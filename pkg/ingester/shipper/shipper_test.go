@@ -0,0 +1,241 @@
+package shipper
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/shipper"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+
+	"objectstorage/pkg/events"
+	cortex_tsdb "objectstorage/pkg/storage/tsdb"
+	"objectstorage/pkg/webhook"
+)
+
+// uploadOrderBucket records the order the blocks are uploaded in.
+type uploadOrderBucket struct {
+	objstore.Bucket
+
+	mtx    sync.Mutex
+	blocks []string
+}
+
+func (b *uploadOrderBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	// The meta file is uploaded last.
+	if strings.HasSuffix(name, metadata.MetaFilename) {
+		b.mtx.Lock()
+		b.blocks = append(b.blocks, filepath.Dir(name))
+		b.mtx.Unlock()
+	}
+	return b.Bucket.Upload(ctx, name, r)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *Config)
+		expected error
+	}{
+		"disabled": {
+			setup:    func(*Config) {},
+			expected: nil,
+		},
+		"enabled": {
+			setup:    func(cfg *Config) { cfg.Enabled = true },
+			expected: nil,
+		},
+		"invalid interval": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.Interval = 0
+			},
+			expected: errInvalidInterval,
+		},
+		"invalid concurrency": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.Concurrency = 0
+			},
+			expected: errInvalidConcurrency,
+		},
+		"invalid bandwidth": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.MaxBandwidthBytes = -1
+			},
+			expected: errInvalidBandwidth,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+			assert.ErrorIs(t, cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+func TestShipper_Iteration(t *testing.T) {
+	ctx := context.Background()
+	tsdbDir := t.TempDir()
+
+	recent := createBlock(t, filepath.Join(tsdbDir, "user-1"), 2*time.Hour.Milliseconds())
+	oldest := createBlock(t, filepath.Join(tsdbDir, "user-2"), 0)
+	deleted := createBlock(t, filepath.Join(tsdbDir, "user-3"), 0)
+
+	bkt := &uploadOrderBucket{Bucket: objstore.NewInMemBucket()}
+	require.NoError(t, cortex_tsdb.WriteTenantDeletionMark(ctx, bkt, "user-3", nil, cortex_tsdb.NewTenantDeletionMark(time.Now())))
+
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Enabled = true
+	cfg.Concurrency = 1
+	cfg.MaxBandwidthBytes = 1 << 20
+
+	s := NewShipper(cfg, tsdbDir, "ingester-1", bkt, nil, nil, nil, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, s.iteration(ctx))
+
+	// The oldest block is shipped first, and the blocks of the deleted tenant not at all.
+	assert.Equal(t, []string{"user-2/" + oldest.String(), "user-1/" + recent.String()}, bkt.blocks)
+	assert.Equal(t, float64(2), testutil.ToFloat64(s.uploads))
+	assert.Equal(t, float64(2), testutil.ToFloat64(s.pending))
+
+	r, err := bkt.Get(ctx, "user-1/"+recent.String()+"/"+metadata.MetaFilename)
+	require.NoError(t, err)
+	meta, err := metadata.Read(r)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{cortex_tsdb.TenantIDExternalLabel: "user-1", cortex_tsdb.IngesterIDExternalLabel: "ingester-1"}, meta.Thanos.Labels)
+
+	shipped, err := shipper.ReadMetaFile(filepath.Join(tsdbDir, "user-1"))
+	require.NoError(t, err)
+	assert.Equal(t, []ulid.ULID{recent}, shipped.Uploaded)
+
+	// The local block and the tenant not shipped are left untouched.
+	_, err = os.Stat(filepath.Join(tsdbDir, "user-3", deleted.String()))
+	require.NoError(t, err)
+	localMeta, err := metadata.ReadFromDir(filepath.Join(tsdbDir, "user-1", recent.String()))
+	require.NoError(t, err)
+	assert.Empty(t, localMeta.Thanos.Labels)
+
+	// The shipped blocks are not uploaded again.
+	require.NoError(t, s.iteration(ctx))
+	assert.Len(t, bkt.blocks, 2)
+	assert.Equal(t, float64(0), testutil.ToFloat64(s.pending))
+}
+
+type notifierMock struct {
+	mtx    sync.Mutex
+	events []webhook.Event
+}
+
+func (m *notifierMock) Notify(ev webhook.Event) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.events = append(m.events, ev)
+}
+
+type publisherMock struct {
+	events []events.Event
+}
+
+func (m *publisherMock) Publish(ev events.Event) {
+	m.events = append(m.events, ev)
+}
+
+func TestShipper_NotificationsAndEvents(t *testing.T) {
+	ctx := context.Background()
+	tsdbDir := t.TempDir()
+	id := createBlock(t, filepath.Join(tsdbDir, "user-1"), 0)
+
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Enabled = true
+
+	// The first upload fails, so that the block is only notified as shipped at the next
+	// iteration, and as cut once.
+	bkt := &failingBucket{Bucket: objstore.NewInMemBucket(), failures: 1}
+	notifier := &notifierMock{}
+	publisher := &publisherMock{}
+	s := NewShipper(cfg, tsdbDir, "ingester-1", bkt, nil, notifier, publisher, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, s.iteration(ctx))
+	require.NoError(t, s.iteration(ctx))
+	require.NoError(t, s.iteration(ctx))
+
+	// The failed upload is published.
+	require.Len(t, publisher.events, 1)
+	assert.Equal(t, events.TypeUploadFailed, publisher.events[0].Type)
+	assert.Equal(t, "user-1", publisher.events[0].Tenant)
+	assert.Equal(t, id.String(), publisher.events[0].Details["block"])
+
+	meta, err := metadata.ReadFromDir(filepath.Join(tsdbDir, "user-1", id.String()))
+	require.NoError(t, err)
+
+	require.Len(t, notifier.events, 2)
+	assert.Equal(t, webhook.EventBlockCut, notifier.events[0].Event)
+	assert.Equal(t, webhook.EventBlockShipped, notifier.events[1].Event)
+	for _, ev := range notifier.events {
+		assert.Equal(t, "user-1", ev.Tenant)
+		assert.Equal(t, id, ev.ULID)
+		assert.Equal(t, meta.MinTime, ev.MinTime)
+		assert.Equal(t, meta.MaxTime, ev.MaxTime)
+		assert.Greater(t, ev.SizeBytes, int64(0))
+	}
+}
+
+// failingBucket fails the first uploads.
+type failingBucket struct {
+	objstore.Bucket
+
+	mtx      sync.Mutex
+	failures int
+}
+
+func (b *failingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.failures > 0 {
+		b.failures--
+		return errors.New("upload failed")
+	}
+	return b.Bucket.Upload(ctx, name, r)
+}
+
+// createBlock creates a block with samples starting at mint, compacted from the head of a
+// TSDB in dir.
+func createBlock(t *testing.T, dir string, mint int64) ulid.ULID {
+	db, err := tsdb.Open(dir, log.NewNopLogger(), nil, tsdb.DefaultOptions(), nil)
+	require.NoError(t, err)
+
+	app := db.Appender(context.Background())
+	for ts := mint; ts < mint+time.Hour.Milliseconds(); ts += time.Minute.Milliseconds() {
+		_, err := app.Append(0, labels.FromStrings("__name__", "up"), ts, 1)
+		require.NoError(t, err)
+	}
+	require.NoError(t, app.Commit())
+	require.NoError(t, db.CompactHead(tsdb.NewRangeHead(db.Head(), mint, mint+time.Hour.Milliseconds())))
+
+	blocks := db.Blocks()
+	require.Len(t, blocks, 1)
+	id := blocks[0].Meta().ULID
+	require.NoError(t, db.Close())
+	return id
+}
@@ -0,0 +1,275 @@
+package ipfilter
+
+import (
+	"context"
+	"flag"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/middleware"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/tap"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+
+	"objectstorage/pkg/tenant"
+)
+
+var (
+	errInvalidCIDR      = errors.New("invalid CIDR")
+	errSourceNotAllowed = errors.New("requests from this source address are not allowed")
+)
+
+// Config holds the configuration of the per-listener source address allowlists.
+type Config struct {
+	HTTPAllowedCIDRs flagext.StringSliceCSV `yaml:"http_allowed_cidrs"`
+	GRPCAllowedCIDRs flagext.StringSliceCSV `yaml:"grpc_allowed_cidrs"`
+}
+
+// RegisterFlags registers the source address allowlists flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.Var(&cfg.HTTPAllowedCIDRs, "server.http-allowed-cidrs", "Comma-separated list of CIDRs or IP addresses the HTTP server accepts requests from. The requests over the unix socket are always accepted. Empty to accept requests from any address.")
+	f.Var(&cfg.GRPCAllowedCIDRs, "server.grpc-allowed-cidrs", "Comma-separated list of CIDRs or IP addresses the gRPC server accepts requests from. The requests over the unix socket are always accepted. Empty to accept requests from any address.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	for _, cidr := range append(append([]string(nil), cfg.HTTPAllowedCIDRs...), cfg.GRPCAllowedCIDRs...) {
+		if _, err := parseCIDR(cidr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListenerFilter rejects the requests to the HTTP and gRPC servers coming from source
+// addresses not allowed for the listener. The source address is the address of the peer,
+// so it must be checked by any proxy in front of the servers. The requests over the unix
+// sockets have no source address and are always accepted, the file mode of the sockets
+// restricting who can connect.
+type ListenerFilter struct {
+	http []*net.IPNet
+	grpc []*net.IPNet
+
+	rejected *prometheus.CounterVec
+}
+
+// NewListenerFilter makes a new ListenerFilter. The config must be valid.
+func NewListenerFilter(cfg Config, reg prometheus.Registerer) (*ListenerFilter, error) {
+	f := &ListenerFilter{
+		rejected: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_ip_filter_rejected_requests_total",
+			Help: "Total number of requests rejected because their source address is not allowed by the listener, by listener.",
+		}, []string{"listener"}),
+	}
+
+	var err error
+	if f.http, err = ParseCIDRs(cfg.HTTPAllowedCIDRs); err != nil {
+		return nil, err
+	}
+	if f.grpc, err = ParseCIDRs(cfg.GRPCAllowedCIDRs); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// HTTPMiddleware returns the middleware filtering the HTTP requests, before their body is read.
+func (f *ListenerFilter) HTTPMiddleware() middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(f.http) > 0 && !overUnixSocket(r) && !Contains(f.http, HostIP(r.RemoteAddr)) {
+				f.rejected.WithLabelValues("http").Inc()
+				http.Error(w, errSourceNotAllowed.Error(), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+}
+
+// GRPCTapHandle is a tap.ServerInHandle filtering the gRPC streams before they're created,
+// and so before any message is received.
+func (f *ListenerFilter) GRPCTapHandle(ctx context.Context, _ *tap.Info) (context.Context, error) {
+	if len(f.grpc) == 0 {
+		return ctx, nil
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok || (p.Addr.Network() != "unix" && !Contains(f.grpc, HostIP(p.Addr.String()))) {
+		f.rejected.WithLabelValues("grpc").Inc()
+		return nil, status.Error(codes.PermissionDenied, errSourceNotAllowed.Error())
+	}
+	return ctx, nil
+}
+
+// GRPCHTTPMiddleware returns the middleware filtering the gRPC requests served by the HTTP
+// server, which don't go through the gRPC tap handles.
+func (f *ListenerFilter) GRPCHTTPMiddleware() middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(f.grpc) > 0 && !overUnixSocket(r) && !Contains(f.grpc, HostIP(r.RemoteAddr)) {
+				f.rejected.WithLabelValues("grpc").Inc()
+				http.Error(w, errSourceNotAllowed.Error(), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+}
+
+// overUnixSocket returns whether the request has been received over a unix socket.
+func overUnixSocket(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+// Limits is the subset of the per-tenant overrides used by the TenantFilter. It's
+// implemented by overrides.Overrides.
+type Limits interface {
+	AllowedSourceCIDRs(userID string) []string
+}
+
+// TenantFilter rejects the HTTP requests coming from source addresses not allowed for
+// their tenant.
+type TenantFilter struct {
+	limits Limits
+	logger log.Logger
+
+	// Parsed networks, by CIDR. Invalid CIDRs are stored as nil.
+	networks sync.Map
+
+	rejected *prometheus.CounterVec
+}
+
+// NewTenantFilter makes a new TenantFilter.
+func NewTenantFilter(limits Limits, logger log.Logger, reg prometheus.Registerer) *TenantFilter {
+	return &TenantFilter{
+		limits: limits,
+		logger: logger,
+		rejected: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_ip_filter_tenant_rejected_requests_total",
+			Help: "Total number of requests rejected because their source address is not allowed for the tenant.",
+		}, []string{"user"}),
+	}
+}
+
+// HTTPMiddleware returns the middleware filtering the HTTP requests. It must run after the
+// tenant has been resolved, and runs before the request body is read. Requests targeting
+// multiple tenants must be allowed by all of them.
+func (f *TenantFilter) HTTPMiddleware() middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenantIDs, err := tenant.TenantIDs(r.Context())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			ip := HostIP(r.RemoteAddr)
+			for _, tenantID := range tenantIDs {
+				if !f.isAllowed(tenantID, ip) {
+					f.rejected.WithLabelValues(tenantID).Inc()
+					http.Error(w, errSourceNotAllowed.Error(), http.StatusForbidden)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	})
+}
+
+func (f *TenantFilter) isAllowed(tenantID string, ip net.IP) bool {
+	cidrs := f.limits.AllowedSourceCIDRs(tenantID)
+	if len(cidrs) == 0 {
+		return true
+	}
+
+	for _, cidr := range cidrs {
+		if network := f.network(cidr); network != nil && ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *TenantFilter) network(cidr string) *net.IPNet {
+	if cached, ok := f.networks.Load(cidr); ok {
+		return cached.(*net.IPNet)
+	}
+
+	network, err := parseCIDR(cidr)
+	if err != nil {
+		level.Warn(f.logger).Log("msg", "ignoring invalid tenant source CIDR", "cidr", cidr, "err", err)
+	}
+
+	f.networks.Store(cidr, network)
+	return network
+}
+
+// ParseCIDRs parses the CIDRs, or IP addresses as single address networks.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		network, err := parseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// parseCIDR parses a CIDR, or an IP address as a single address network.
+func parseCIDR(cidr string) (*net.IPNet, error) {
+	cidr = strings.TrimSpace(cidr)
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, errors.Wrap(errInvalidCIDR, cidr)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, errors.Wrap(errInvalidCIDR, cidr)
+	}
+	return network, nil
+}
+
+// HostIP returns the IP of the address, with or without a port. It returns nil if the host
+// isn't an IP.
+func HostIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.ParseIP(host)
+}
+
+// Contains returns true if the IP is in any of the networks.
+func Contains(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
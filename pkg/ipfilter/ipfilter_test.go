@@ -0,0 +1,198 @@
+package ipfilter
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/tap"
+)
+
+type limitsMock map[string][]string
+
+func (m limitsMock) AllowedSourceCIDRs(userID string) []string {
+	return m[userID]
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		cfg      Config
+		expected error
+	}{
+		"empty": {},
+		"valid CIDRs and addresses": {
+			cfg: Config{HTTPAllowedCIDRs: []string{"10.0.0.0/8", "192.168.1.1"}, GRPCAllowedCIDRs: []string{"fd00::/8", "::1"}},
+		},
+		"invalid HTTP CIDR": {
+			cfg:      Config{HTTPAllowedCIDRs: []string{"10.0.0.0/33"}},
+			expected: errInvalidCIDR,
+		},
+		"invalid gRPC address": {
+			cfg:      Config{GRPCAllowedCIDRs: []string{"not-an-ip"}},
+			expected: errInvalidCIDR,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, tc.cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+func TestListenerFilter_HTTPMiddleware(t *testing.T) {
+	f, err := NewListenerFilter(Config{HTTPAllowedCIDRs: []string{"10.0.0.0/8", "192.168.1.1"}}, nil)
+	require.NoError(t, err)
+
+	handler := f.HTTPMiddleware().Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for remoteAddr, expected := range map[string]int{
+		"10.1.2.3:1234":    http.StatusOK,
+		"192.168.1.1:1234": http.StatusOK,
+		"192.168.1.2:1234": http.StatusForbidden,
+		"[::1]:1234":       http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/push", nil)
+		req.RemoteAddr = remoteAddr
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, expected, rec.Code, remoteAddr)
+	}
+}
+
+func TestListenerFilter_GRPCTapHandle(t *testing.T) {
+	f, err := NewListenerFilter(Config{GRPCAllowedCIDRs: []string{"10.0.0.0/8"}}, nil)
+	require.NoError(t, err)
+
+	allowed := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}})
+	_, err = f.GRPCTapHandle(allowed, &tap.Info{})
+	assert.NoError(t, err)
+
+	denied := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 1234}})
+	_, err = f.GRPCTapHandle(denied, &tap.Info{})
+	assert.Error(t, err)
+
+	_, err = f.GRPCTapHandle(context.Background(), &tap.Info{})
+	assert.Error(t, err)
+}
+
+func TestListenerFilter_GRPCHTTPMiddleware(t *testing.T) {
+	f, err := NewListenerFilter(Config{HTTPAllowedCIDRs: []string{"192.168.0.0/16"}, GRPCAllowedCIDRs: []string{"10.0.0.0/8"}}, nil)
+	require.NoError(t, err)
+
+	handler := f.GRPCHTTPMiddleware().Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for remoteAddr, expected := range map[string]int{
+		"10.1.2.3:1234":    http.StatusOK,
+		"192.168.1.1:1234": http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/cortex.Ingester/Push", nil)
+		req.RemoteAddr = remoteAddr
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, expected, rec.Code, remoteAddr)
+	}
+}
+
+func TestListenerFilter_ShouldAcceptTheRequestsOverUnixSockets(t *testing.T) {
+	f, err := NewListenerFilter(Config{HTTPAllowedCIDRs: []string{"10.0.0.0/8"}, GRPCAllowedCIDRs: []string{"10.0.0.0/8"}}, nil)
+	require.NoError(t, err)
+	dir := t.TempDir()
+
+	httpListener, err := net.Listen("unix", filepath.Join(dir, "http.sock"))
+	require.NoError(t, err)
+	httpServer := &http.Server{Handler: f.HTTPMiddleware().Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))}
+	go func() { _ = httpServer.Serve(httpListener) }()
+	defer httpServer.Close()
+
+	grpcListener, err := net.Listen("unix", filepath.Join(dir, "grpc.sock"))
+	require.NoError(t, err)
+	grpcServer := grpc.NewServer(grpc.InTapHandle(f.GRPCTapHandle))
+	grpc_health_v1.RegisterHealthServer(grpcServer, health.NewServer())
+	go func() { _ = grpcServer.Serve(grpcListener) }()
+	defer grpcServer.Stop()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", httpListener.Addr().String())
+		},
+	}}
+	resp, err := client.Post("http://localhost/api/v1/push", "application/x-protobuf", nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	conn, err := grpc.Dial("unix://"+grpcListener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	assert.NoError(t, err)
+}
+
+func TestTenantFilter_HTTPMiddleware(t *testing.T) {
+	f := NewTenantFilter(limitsMock{
+		"tenant-a": {"10.0.0.0/8"},
+		"tenant-b": {"192.168.0.0/16"},
+		"tenant-c": {"invalid", "172.16.0.1"},
+	}, log.NewNopLogger(), nil)
+
+	handler := f.HTTPMiddleware().Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	tests := map[string]struct {
+		orgID      string
+		remoteAddr string
+		expected   int
+	}{
+		"allowed tenant network": {
+			orgID:      "tenant-a",
+			remoteAddr: "10.1.1.1:1234",
+			expected:   http.StatusOK,
+		},
+		"not allowed tenant network": {
+			orgID:      "tenant-a",
+			remoteAddr: "192.168.1.1:1234",
+			expected:   http.StatusForbidden,
+		},
+		"tenant without allowlist": {
+			orgID:      "tenant-x",
+			remoteAddr: "192.168.1.1:1234",
+			expected:   http.StatusOK,
+		},
+		"invalid CIDRs are ignored": {
+			orgID:      "tenant-c",
+			remoteAddr: "172.16.0.1:1234",
+			expected:   http.StatusOK,
+		},
+		"multiple tenants must all allow the source": {
+			orgID:      "tenant-a|tenant-b",
+			remoteAddr: "10.1.1.1:1234",
+			expected:   http.StatusForbidden,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/push", nil)
+			req.RemoteAddr = tc.remoteAddr
+			req = req.WithContext(user.InjectOrgID(req.Context(), tc.orgID))
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tc.expected, rec.Code)
+		})
+	}
+}
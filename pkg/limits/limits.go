@@ -0,0 +1,352 @@
+package limits
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/extract"
+	"github.com/cortexproject/cortex/pkg/util/limiter"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/events"
+	"objectstorage/pkg/push"
+	"objectstorage/pkg/ratelimit"
+	"objectstorage/pkg/tenant"
+)
+
+// Reasons used to label the discarded samples.
+const (
+	reasonRateLimited          = "rate_limited"
+	reasonPerUserSeriesLimit   = "per_user_series_limit"
+	reasonPerMetricSeriesLimit = "per_metric_series_limit"
+	reasonInstanceSeriesLimit  = "instance_series_limit"
+	reasonMissingMetricName    = "missing_metric_name"
+)
+
+// breachEventPeriod is the minimum period between the events published for the limit
+// breaches of a tenant for the same reason, not to publish one per rejected request.
+const breachEventPeriod = time.Minute
+
+var errInvalidIdleTimeout = errors.New("the series idle timeout must be greater than 0")
+
+// Config holds the configuration of the ingestion limits enforcement. The limits
+// themselves are configured per tenant via validation.Limits.
+type Config struct {
+	SeriesIdleTimeout time.Duration `yaml:"series_idle_timeout"`
+	RecheckPeriod     time.Duration `yaml:"rate_limit_recheck_period"`
+}
+
+// RegisterFlags registers the ingestion limits flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.DurationVar(&cfg.SeriesIdleTimeout, "ingestion-limits.series-idle-timeout", 2*time.Hour, "Series not receiving samples for longer than this period don't count against the max series limits anymore. Should match the TSDB block range period.")
+	f.DurationVar(&cfg.RecheckPeriod, "ingestion-limits.rate-limit-recheck-period", 10*time.Second, "How frequently the per-tenant ingestion rate and burst limits are reloaded from the overrides.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if cfg.SeriesIdleTimeout <= 0 {
+		return errInvalidIdleTimeout
+	}
+	return nil
+}
+
+// Limits is the subset of the per-tenant overrides enforced on the push path.
+// It's implemented by validation.Overrides.
+type Limits interface {
+	IngestionRate(userID string) float64
+	IngestionBurstSize(userID string) int
+	MaxLocalSeriesPerUser(userID string) int
+	MaxLocalSeriesPerMetric(userID string) int
+	MaxGlobalSeriesPerUser(userID string) int
+	MaxGlobalSeriesPerMetric(userID string) int
+	IngestionTenantShardSize(userID string) int
+}
+
+// RingCount returns the number of healthy ingesters in the ring.
+type RingCount interface {
+	HealthyInstancesCount() int
+}
+
+// Rejected receives the series rejected by the validation or a limit, with the reason. It's
+// implemented by deadletter.Writer.
+type Rejected interface {
+	Add(userID, reason string, cause error, lbls []cortexpb.LabelAdapter, samples []cortexpb.Sample)
+}
+
+// Publisher publishes the ingester events. It's implemented by events.Bus.
+type Publisher interface {
+	Publish(ev events.Event)
+}
+
+// Enforcer enforces the per-tenant ingestion limits on the push path. Series exceeding
+// a limit are discarded, while the rest of the request is still ingested.
+type Enforcer struct {
+	services.Service
+
+	cfg               Config
+	limits            Limits
+	ring              RingCount
+	replicationFactor int
+	instanceLimits    ratelimit.InstanceLimitsFn
+	rateLimiter       *limiter.RateLimiter
+	series            *seriesTracker
+	rejected          Rejected
+	publisher         Publisher
+
+	// breaches is the last time an event has been published for the limit breaches of each
+	// tenant and reason.
+	breachesMtx sync.Mutex
+	breaches    map[breachKey]time.Time
+
+	discardedSamples *prometheus.CounterVec
+	activeSeries     *prometheus.GaugeVec
+}
+
+// NewEnforcer makes a new Enforcer. The ring is used to convert the global series limits
+// into local ones and may be nil, in which case only the local limits are enforced. The
+// instance limits may be nil too, in which case the instance series aren't limited. The
+// rejected series are passed to rejected, unless nil. The tenants seen for the first time
+// and the limit breaches are published to the publisher, unless nil.
+func NewEnforcer(cfg Config, limits Limits, ring RingCount, replicationFactor int, instanceLimits ratelimit.InstanceLimitsFn, rejected Rejected, publisher Publisher, reg prometheus.Registerer) *Enforcer {
+	if instanceLimits == nil {
+		instanceLimits = func() ratelimit.InstanceLimits { return ratelimit.InstanceLimits{} }
+	}
+
+	e := &Enforcer{
+		cfg:               cfg,
+		limits:            limits,
+		ring:              ring,
+		replicationFactor: replicationFactor,
+		instanceLimits:    instanceLimits,
+		rateLimiter:       limiter.NewRateLimiter(rateLimiterStrategy{limits: limits}, cfg.RecheckPeriod),
+		series:            newSeriesTracker(),
+		rejected:          rejected,
+		publisher:         publisher,
+		breaches:          map[breachKey]time.Time{},
+		discardedSamples: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_ingestion_limits_discarded_samples_total",
+			Help: "Total number of samples discarded on the push path because a per-tenant limit was reached.",
+		}, []string{"reason", "user"}),
+		activeSeries: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_ingestion_limits_tracked_series",
+			Help: "Number of series tracked per tenant to enforce the max series limits.",
+		}, []string{"user"}),
+	}
+
+	if publisher != nil {
+		e.series.created = func(userID string) {
+			publisher.Publish(events.Event{Type: events.TypeTenantCreated, Tenant: userID})
+		}
+	}
+
+	e.Service = services.NewTimerService(cfg.SeriesIdleTimeout/4, nil, e.purge, nil)
+	return e
+}
+
+func (e *Enforcer) purge(_ context.Context) error {
+	e.breachesMtx.Lock()
+	for key, last := range e.breaches {
+		if time.Since(last) >= breachEventPeriod {
+			delete(e.breaches, key)
+		}
+	}
+	e.breachesMtx.Unlock()
+
+	for userID, count := range e.series.purge(time.Now().Add(-e.cfg.SeriesIdleTimeout)) {
+		if count == 0 {
+			e.activeSeries.DeleteLabelValues(userID)
+			continue
+		}
+		e.activeSeries.WithLabelValues(userID).Set(float64(count))
+	}
+	return nil
+}
+
+// ActiveSeries returns the number of series pushed by the tenant within the series idle timeout.
+func (e *Enforcer) ActiveSeries(userID string) int {
+	return e.series.count(userID)
+}
+
+// ActiveSeriesByUser returns the number of series pushed by each tenant within the series idle timeout.
+func (e *Enforcer) ActiveSeriesByUser() map[string]int {
+	return e.series.counts()
+}
+
+// PushMiddleware returns the push.Middleware enforcing the limits.
+func (e *Enforcer) PushMiddleware() push.Middleware {
+	return func(next push.Func) push.Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			userID, err := tenant.TenantID(ctx)
+			if err != nil {
+				return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+			}
+
+			samples := 0
+			for _, ts := range req.Timeseries {
+				samples += len(ts.Samples)
+			}
+
+			if !e.rateLimiter.AllowN(time.Now(), userID, samples) {
+				e.discardedSamples.WithLabelValues(reasonRateLimited, userID).Add(float64(samples))
+				e.publishBreach(userID, reasonRateLimited, time.Now())
+				return nil, ratelimit.NewError(ratelimit.TenantSamplesRate, userID, e.limits.IngestionRate(userID), e.limits.IngestionBurstSize(userID), samples, fmt.Sprintf("ingestion rate limit (%v) exceeded while adding %d samples", e.limits.IngestionRate(userID), samples))
+			}
+
+			firstErr := e.filter(userID, req)
+			if len(req.Timeseries) > 0 || len(req.Metadata) > 0 {
+				if _, err := next(ctx, req); err != nil {
+					return nil, err
+				}
+			}
+
+			if firstErr != nil {
+				return nil, httpgrpc.Errorf(http.StatusBadRequest, firstErr.Error())
+			}
+			return &cortexpb.WriteResponse{}, nil
+		}
+	}
+}
+
+// filter removes from the request the series exceeding a limit and returns the first
+// limit error, if any.
+func (e *Enforcer) filter(userID string, req *cortexpb.WriteRequest) error {
+	var (
+		firstErr error
+		now      = time.Now()
+		kept     = req.Timeseries[:0]
+	)
+
+	for _, ts := range req.Timeseries {
+		reason, err := e.check(userID, ts.Labels, now)
+		if err != nil {
+			e.discardedSamples.WithLabelValues(reason, userID).Add(float64(len(ts.Samples)))
+			e.publishBreach(userID, reason, now)
+			if e.rejected != nil {
+				e.rejected.Add(userID, reason, err, ts.Labels, ts.Samples)
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		kept = append(kept, ts)
+	}
+
+	req.Timeseries = kept
+	e.activeSeries.WithLabelValues(userID).Set(float64(e.series.count(userID)))
+	return firstErr
+}
+
+type breachKey struct {
+	userID, reason string
+}
+
+// publishBreach publishes the limit breach of the tenant, unless one has already been
+// published for the same reason within the breachEventPeriod.
+func (e *Enforcer) publishBreach(userID, reason string, now time.Time) {
+	if e.publisher == nil {
+		return
+	}
+
+	key := breachKey{userID: userID, reason: reason}
+	e.breachesMtx.Lock()
+	if last, ok := e.breaches[key]; ok && now.Sub(last) < breachEventPeriod {
+		e.breachesMtx.Unlock()
+		return
+	}
+	e.breaches[key] = now
+	e.breachesMtx.Unlock()
+
+	e.publisher.Publish(events.Event{Type: events.TypeLimitsBreached, Tenant: userID, Details: map[string]string{"reason": reason}})
+}
+
+// check returns the reason and the error if the series exceeds a limit.
+func (e *Enforcer) check(userID string, lbls []cortexpb.LabelAdapter, now time.Time) (string, error) {
+	// The series are validated before, but the metric name is still checked since the
+	// per-metric limit can't be enforced without it.
+	metricName, err := extract.MetricNameFromLabelAdapters(lbls)
+	if err != nil {
+		return reasonMissingMetricName, fmt.Errorf("series has no metric name: %s", formatLabels(lbls))
+	}
+
+	maxTotal := int(e.instanceLimits().MaxInMemorySeries)
+	maxPerUser := e.maxSeriesPerUser(userID)
+	maxPerMetric := e.maxSeriesPerMetric(userID)
+
+	switch e.series.track(userID, metricName, lbls, now, maxTotal, maxPerUser, maxPerMetric) {
+	case trackInstanceLimited:
+		return reasonInstanceSeriesLimit, fmt.Errorf("instance series limit of %d reached: %s", maxTotal, formatLabels(lbls))
+	case trackUserLimited:
+		return reasonPerUserSeriesLimit, fmt.Errorf("per-user series limit of %d exceeded: %s", maxPerUser, formatLabels(lbls))
+	case trackMetricLimited:
+		return reasonPerMetricSeriesLimit, fmt.Errorf("per-metric series limit of %d exceeded for metric %s: %s", maxPerMetric, metricName, formatLabels(lbls))
+	}
+
+	return "", nil
+}
+
+// maxSeriesPerUser returns the max number of series the tenant can have in this ingester,
+// which is the lowest between the local limit and the global one converted to local.
+func (e *Enforcer) maxSeriesPerUser(userID string) int {
+	return minNonZero(e.limits.MaxLocalSeriesPerUser(userID), e.convertGlobalToLocalLimit(userID, e.limits.MaxGlobalSeriesPerUser(userID)))
+}
+
+// maxSeriesPerMetric returns the max number of series per metric the tenant can have in this
+// ingester, which is the lowest between the local limit and the global one converted to local.
+func (e *Enforcer) maxSeriesPerMetric(userID string) int {
+	return minNonZero(e.limits.MaxLocalSeriesPerMetric(userID), e.convertGlobalToLocalLimit(userID, e.limits.MaxGlobalSeriesPerMetric(userID)))
+}
+
+// convertGlobalToLocalLimit divides the global limit across the healthy ingesters the tenant
+// is sharded to, so that the limit stays constant while scaling the ingesters. Each series is
+// written to replication factor ingesters, so the local limit is multiplied by it.
+func (e *Enforcer) convertGlobalToLocalLimit(userID string, globalLimit int) int {
+	if globalLimit == 0 || e.ring == nil {
+		return 0
+	}
+
+	numIngesters := e.ring.HealthyInstancesCount()
+	if numIngesters <= 0 {
+		return 0
+	}
+
+	if shardSize := e.limits.IngestionTenantShardSize(userID); shardSize > 0 && shardSize < numIngesters {
+		numIngesters = shardSize
+	}
+
+	return int((float64(globalLimit) / float64(numIngesters)) * float64(e.replicationFactor))
+}
+
+func minNonZero(first, second int) int {
+	if first == 0 || (second > 0 && second < first) {
+		return second
+	}
+	return first
+}
+
+func formatLabels(lbls []cortexpb.LabelAdapter) string {
+	return cortexpb.FromLabelAdaptersToLabels(lbls).String()
+}
+
+// rateLimiterStrategy reads the ingestion rate and burst from the per-tenant limits.
+type rateLimiterStrategy struct {
+	limits Limits
+}
+
+func (s rateLimiterStrategy) Limit(userID string) float64 {
+	return s.limits.IngestionRate(userID)
+}
+
+func (s rateLimiterStrategy) Burst(userID string) int {
+	return s.limits.IngestionBurstSize(userID)
+}
@@ -0,0 +1,256 @@
+package limits
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+
+	"objectstorage/pkg/events"
+)
+
+type limitsMock struct {
+	ingestionRate      float64
+	ingestionBurstSize int
+	maxSeriesPerUser   int
+	maxSeriesPerMetric int
+	maxGlobalPerUser   int
+	maxGlobalPerMetric int
+	shardSize          int
+}
+
+func (m limitsMock) IngestionRate(string) float64        { return m.ingestionRate }
+func (m limitsMock) IngestionBurstSize(string) int       { return m.ingestionBurstSize }
+func (m limitsMock) MaxLocalSeriesPerUser(string) int    { return m.maxSeriesPerUser }
+func (m limitsMock) MaxLocalSeriesPerMetric(string) int  { return m.maxSeriesPerMetric }
+func (m limitsMock) MaxGlobalSeriesPerUser(string) int   { return m.maxGlobalPerUser }
+func (m limitsMock) MaxGlobalSeriesPerMetric(string) int { return m.maxGlobalPerMetric }
+func (m limitsMock) IngestionTenantShardSize(string) int { return m.shardSize }
+
+func newSeries(lbls ...string) cortexpb.PreallocTimeseries {
+	ts := &cortexpb.TimeSeries{Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}}}
+	for i := 0; i < len(lbls); i += 2 {
+		ts.Labels = append(ts.Labels, cortexpb.LabelAdapter{Name: lbls[i], Value: lbls[i+1]})
+	}
+	return cortexpb.PreallocTimeseries{TimeSeries: ts}
+}
+
+// rejectedMock counts the rejected series by reason.
+type rejectedMock map[string]int
+
+func (m rejectedMock) Add(_, reason string, _ error, _ []cortexpb.LabelAdapter, _ []cortexpb.Sample) {
+	m[reason]++
+}
+
+func TestEnforcer_PushMiddleware(t *testing.T) {
+	defaults := limitsMock{ingestionRate: 1000, ingestionBurstSize: 1000}
+
+	tests := map[string]struct {
+		limits            limitsMock
+		series            []cortexpb.PreallocTimeseries
+		expectedCode      int32
+		expectedPushed    int
+		expectedDiscarded map[string]float64
+	}{
+		"no limit reached": {
+			limits:         defaults,
+			series:         []cortexpb.PreallocTimeseries{newSeries("__name__", "up"), newSeries("__name__", "down")},
+			expectedPushed: 2,
+		},
+		"rate limited": {
+			limits:            limitsMock{ingestionRate: 1, ingestionBurstSize: 1},
+			series:            []cortexpb.PreallocTimeseries{newSeries("__name__", "up"), newSeries("__name__", "down")},
+			expectedCode:      http.StatusTooManyRequests,
+			expectedDiscarded: map[string]float64{reasonRateLimited: 2},
+		},
+		"max series per user": {
+			limits:            limitsMock{ingestionRate: 1000, ingestionBurstSize: 1000, maxSeriesPerUser: 2},
+			series:            []cortexpb.PreallocTimeseries{newSeries("__name__", "a"), newSeries("__name__", "b"), newSeries("__name__", "c"), newSeries("__name__", "a")},
+			expectedCode:      http.StatusBadRequest,
+			expectedPushed:    3,
+			expectedDiscarded: map[string]float64{reasonPerUserSeriesLimit: 1},
+		},
+		"max series per metric": {
+			limits:            limitsMock{ingestionRate: 1000, ingestionBurstSize: 1000, maxSeriesPerMetric: 1},
+			series:            []cortexpb.PreallocTimeseries{newSeries("__name__", "a", "job", "1"), newSeries("__name__", "a", "job", "2"), newSeries("__name__", "b")},
+			expectedCode:      http.StatusBadRequest,
+			expectedPushed:    2,
+			expectedDiscarded: map[string]float64{reasonPerMetricSeriesLimit: 1},
+		},
+		"missing metric name": {
+			limits:            defaults,
+			series:            []cortexpb.PreallocTimeseries{newSeries("job", "a")},
+			expectedCode:      http.StatusBadRequest,
+			expectedDiscarded: map[string]float64{reasonMissingMetricName: 1},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			reg := prometheus.NewPedanticRegistry()
+			rejected := rejectedMock{}
+			e := NewEnforcer(Config{SeriesIdleTimeout: time.Hour, RecheckPeriod: time.Minute}, tc.limits, nil, 1, nil, rejected, nil, reg)
+
+			pushed := 0
+			f := e.PushMiddleware()(func(_ context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				pushed += len(req.Timeseries)
+				return &cortexpb.WriteResponse{}, nil
+			})
+
+			_, err := f(user.InjectOrgID(context.Background(), "user-1"), &cortexpb.WriteRequest{Timeseries: tc.series})
+			if tc.expectedCode != 0 {
+				resp, ok := httpgrpc.HTTPResponseFromError(err)
+				require.True(t, ok)
+				assert.Equal(t, tc.expectedCode, resp.Code)
+			} else {
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.expectedPushed, pushed)
+			for reason, expected := range tc.expectedDiscarded {
+				assert.Equal(t, expected, testutil.ToFloat64(e.discardedSamples.WithLabelValues(reason, "user-1")), reason)
+
+				// The rate limited requests are retried by the clients, so they aren't rejected series.
+				if reason != reasonRateLimited {
+					assert.Equal(t, int(expected), rejected[reason], reason)
+				}
+			}
+			assert.NotContains(t, rejected, reasonRateLimited)
+		})
+	}
+}
+
+type publisherMock struct {
+	events []events.Event
+}
+
+func (m *publisherMock) Publish(ev events.Event) {
+	m.events = append(m.events, ev)
+}
+
+func TestEnforcer_Events(t *testing.T) {
+	publisher := &publisherMock{}
+	e := NewEnforcer(Config{SeriesIdleTimeout: time.Hour, RecheckPeriod: time.Minute}, limitsMock{ingestionRate: 1000, ingestionBurstSize: 1000, maxSeriesPerUser: 1}, nil, 1, nil, nil, publisher, nil)
+	f := e.PushMiddleware()(func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		return &cortexpb.WriteResponse{}, nil
+	})
+
+	// The breaches of the limit are only published once per period.
+	ctx := user.InjectOrgID(context.Background(), "user-1")
+	for i := 0; i < 2; i++ {
+		_, err := f(ctx, &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{newSeries("__name__", "a"), newSeries("__name__", "b")}})
+		require.Error(t, err)
+	}
+
+	assert.Equal(t, []events.Event{
+		{Type: events.TypeTenantCreated, Tenant: "user-1"},
+		{Type: events.TypeLimitsBreached, Tenant: "user-1", Details: map[string]string{"reason": reasonPerUserSeriesLimit}},
+	}, publisher.events)
+}
+
+type ringCountMock int
+
+func (m ringCountMock) HealthyInstancesCount() int { return int(m) }
+
+func TestEnforcer_MaxSeriesPerUser(t *testing.T) {
+	tests := map[string]struct {
+		limits            limitsMock
+		ring              RingCount
+		replicationFactor int
+		expected          int
+	}{
+		"no limits": {
+			limits:   limitsMock{},
+			ring:     ringCountMock(3),
+			expected: 0,
+		},
+		"local limit only": {
+			limits:   limitsMock{maxSeriesPerUser: 1000},
+			ring:     ringCountMock(3),
+			expected: 1000,
+		},
+		"global limit without ring": {
+			limits:   limitsMock{maxGlobalPerUser: 1000},
+			expected: 0,
+		},
+		"global limit with no healthy ingesters": {
+			limits:            limitsMock{maxGlobalPerUser: 1000},
+			ring:              ringCountMock(0),
+			replicationFactor: 3,
+			expected:          0,
+		},
+		"global limit divided by the healthy ingesters": {
+			limits:            limitsMock{maxGlobalPerUser: 1000},
+			ring:              ringCountMock(10),
+			replicationFactor: 3,
+			expected:          300,
+		},
+		"global limit divided by the shard size": {
+			limits:            limitsMock{maxGlobalPerUser: 1000, shardSize: 5},
+			ring:              ringCountMock(10),
+			replicationFactor: 3,
+			expected:          600,
+		},
+		"shard size larger than the ring": {
+			limits:            limitsMock{maxGlobalPerUser: 1000, shardSize: 50},
+			ring:              ringCountMock(10),
+			replicationFactor: 3,
+			expected:          300,
+		},
+		"local limit lower than the global one": {
+			limits:            limitsMock{maxSeriesPerUser: 100, maxGlobalPerUser: 1000},
+			ring:              ringCountMock(10),
+			replicationFactor: 3,
+			expected:          100,
+		},
+		"global limit lower than the local one": {
+			limits:            limitsMock{maxSeriesPerUser: 1000, maxGlobalPerUser: 1000},
+			ring:              ringCountMock(10),
+			replicationFactor: 3,
+			expected:          300,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			e := NewEnforcer(Config{SeriesIdleTimeout: time.Hour, RecheckPeriod: time.Minute}, tc.limits, tc.ring, tc.replicationFactor, nil, nil, nil, nil)
+			assert.Equal(t, tc.expected, e.maxSeriesPerUser("user-1"))
+		})
+	}
+}
+
+func TestSeriesTracker_Purge(t *testing.T) {
+	tracker := newSeriesTracker()
+	now := time.Now()
+
+	series := newSeries("__name__", "up")
+	assert.Equal(t, trackAccepted, tracker.track("user-1", "up", series.Labels, now.Add(-time.Hour), 0, 1, 0))
+	assert.Equal(t, trackUserLimited, tracker.track("user-1", "down", newSeries("__name__", "down").Labels, now, 0, 1, 0))
+
+	assert.Equal(t, map[string]int{"user-1": 0}, tracker.purge(now.Add(-time.Minute)))
+	assert.Equal(t, 0, tracker.count("user-1"))
+	assert.Equal(t, trackAccepted, tracker.track("user-1", "down", newSeries("__name__", "down").Labels, now, 0, 1, 0))
+}
+
+func TestSeriesTracker_InstanceLimit(t *testing.T) {
+	tracker := newSeriesTracker()
+	now := time.Now()
+
+	assert.Equal(t, trackAccepted, tracker.track("user-1", "up", newSeries("__name__", "up").Labels, now.Add(-time.Hour), 1, 0, 0))
+	assert.Equal(t, trackInstanceLimited, tracker.track("user-2", "up", newSeries("__name__", "up").Labels, now, 1, 0, 0))
+
+	// The existing series are still accepted once the limit is reached.
+	assert.Equal(t, trackAccepted, tracker.track("user-1", "up", newSeries("__name__", "up").Labels, now.Add(-time.Hour), 1, 0, 0))
+
+	tracker.purge(now.Add(-time.Minute))
+	assert.Equal(t, trackAccepted, tracker.track("user-2", "up", newSeries("__name__", "up").Labels, now, 1, 0, 0))
+}
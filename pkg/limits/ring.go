@@ -0,0 +1,24 @@
+package limits
+
+import (
+	"github.com/cortexproject/cortex/pkg/ring"
+)
+
+// readRingCount implements RingCount on top of a ring.ReadRing.
+type readRingCount struct {
+	ring ring.ReadRing
+}
+
+// NewReadRingCount returns a RingCount counting the instances of the input ring which are
+// healthy for writes.
+func NewReadRingCount(r ring.ReadRing) RingCount {
+	return readRingCount{ring: r}
+}
+
+func (r readRingCount) HealthyInstancesCount() int {
+	rs, err := r.ring.GetAllHealthy(ring.Write)
+	if err != nil {
+		return 0
+	}
+	return len(rs.Instances)
+}
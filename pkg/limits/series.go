@@ -0,0 +1,135 @@
+package limits
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+
+	"objectstorage/pkg/util/intern"
+)
+
+type trackResult int
+
+const (
+	trackAccepted trackResult = iota
+	trackUserLimited
+	trackMetricLimited
+	trackInstanceLimited
+)
+
+// seriesTracker keeps track of the series recently pushed by each tenant, to enforce the
+// max series limits on the push path.
+type seriesTracker struct {
+	mtx   sync.Mutex
+	users map[string]*userSeries
+	total int
+
+	// created, if set, is called when a tenant not tracked yet pushes a series.
+	created func(userID string)
+}
+
+type userSeries struct {
+	lastSeen map[uint64]int64
+	metrics  map[uint64]string
+	perName  map[string]int
+}
+
+func newSeriesTracker() *seriesTracker {
+	return &seriesTracker{users: map[string]*userSeries{}}
+}
+
+// track records a sample for the input series and returns whether the series has been
+// accepted. A new series is refused if it would exceed the instance, per-user or per-metric
+// limit. A limit of 0 disables it.
+func (t *seriesTracker) track(userID, metricName string, lbls []cortexpb.LabelAdapter, now time.Time, maxTotal, maxPerUser, maxPerMetric int) trackResult {
+	hash := cortexpb.FromLabelAdaptersToLabels(lbls).Hash()
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	u, ok := t.users[userID]
+	if !ok {
+		u = &userSeries{lastSeen: map[uint64]int64{}, metrics: map[uint64]string{}, perName: map[string]int{}}
+		t.users[userID] = u
+		if t.created != nil {
+			t.created(userID)
+		}
+	}
+
+	if _, ok := u.lastSeen[hash]; !ok {
+		if maxTotal > 0 && t.total >= maxTotal {
+			return trackInstanceLimited
+		}
+		if maxPerUser > 0 && len(u.lastSeen) >= maxPerUser {
+			return trackUserLimited
+		}
+		if maxPerMetric > 0 && u.perName[metricName] >= maxPerMetric {
+			return trackMetricLimited
+		}
+
+		// The metric name is kept after the request, interned so that it doesn't reference
+		// the request body and is shared by the series of all tenants.
+		metricName = intern.Global().Intern(metricName)
+		u.metrics[hash] = metricName
+		u.perName[metricName]++
+		t.total++
+	}
+
+	u.lastSeen[hash] = now.UnixMilli()
+	return trackAccepted
+}
+
+// count returns the number of series tracked for the input tenant.
+func (t *seriesTracker) count(userID string) int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if u, ok := t.users[userID]; ok {
+		return len(u.lastSeen)
+	}
+	return 0
+}
+
+// counts returns the number of series tracked for each tenant.
+func (t *seriesTracker) counts() map[string]int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	out := make(map[string]int, len(t.users))
+	for userID, u := range t.users {
+		out[userID] = len(u.lastSeen)
+	}
+	return out
+}
+
+// purge removes the series not seen since the input deadline and returns the number of
+// series left for each tenant tracked before the purge.
+func (t *seriesTracker) purge(deadline time.Time) map[string]int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	out := make(map[string]int, len(t.users))
+	for userID, u := range t.users {
+		for hash, lastSeen := range u.lastSeen {
+			if lastSeen >= deadline.UnixMilli() {
+				continue
+			}
+
+			metricName := u.metrics[hash]
+			delete(u.lastSeen, hash)
+			delete(u.metrics, hash)
+			t.total--
+			if u.perName[metricName]--; u.perName[metricName] <= 0 {
+				delete(u.perName, metricName)
+			}
+		}
+
+		out[userID] = len(u.lastSeen)
+		if len(u.lastSeen) == 0 {
+			delete(t.users, userID)
+		}
+	}
+
+	return out
+}
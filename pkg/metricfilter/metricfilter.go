@@ -0,0 +1,134 @@
+package metricfilter
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/extract"
+
+	"objectstorage/pkg/push"
+	"objectstorage/pkg/tenant"
+)
+
+// ruleNotAllowed is the rule label of the samples dropped because their metric name
+// doesn't match the allowlist.
+const ruleNotAllowed = "not_allowed"
+
+// Limits is the subset of the per-tenant overrides used by the filter. It's implemented
+// by overrides.Overrides.
+type Limits interface {
+	AllowedMetricNames(userID string) []string
+	BlockedMetricNames(userID string) []string
+}
+
+// Filter drops the series whose metric name is blocked, or not allowed, for the tenant.
+// It's meant for emergencies, when a tenant suddenly sends high cardinality metrics.
+type Filter struct {
+	limits Limits
+	logger log.Logger
+
+	// Compiled patterns, by pattern. Invalid patterns are stored as nil.
+	patterns sync.Map
+
+	discardedSamples *prometheus.CounterVec
+}
+
+// NewFilter makes a new Filter.
+func NewFilter(limits Limits, logger log.Logger, reg prometheus.Registerer) *Filter {
+	return &Filter{
+		limits: limits,
+		logger: logger,
+		discardedSamples: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_metric_filter_discarded_samples_total",
+			Help: "Total number of samples discarded by the per-tenant metric name filters, by rule. The rule is the matching blocklist pattern, or not_allowed for metrics not matching the allowlist.",
+		}, []string{"user", "rule"}),
+	}
+}
+
+// PushMiddleware returns the push.Middleware filtering the series.
+func (f *Filter) PushMiddleware() push.Middleware {
+	return func(next push.Func) push.Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			userID, err := tenant.TenantID(ctx)
+			if err != nil {
+				return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+			}
+
+			allowed, blocked := f.limits.AllowedMetricNames(userID), f.limits.BlockedMetricNames(userID)
+			if len(allowed) == 0 && len(blocked) == 0 {
+				return next(ctx, req)
+			}
+
+			f.filter(userID, req, allowed, blocked)
+			if len(req.Timeseries) == 0 && len(req.Metadata) == 0 {
+				return &cortexpb.WriteResponse{}, nil
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+func (f *Filter) filter(userID string, req *cortexpb.WriteRequest, allowed, blocked []string) {
+	kept := req.Timeseries[:0]
+
+	for _, ts := range req.Timeseries {
+		metricName, err := extract.MetricNameFromLabelAdapters(ts.Labels)
+		if err != nil {
+			// Series without metric name are rejected by the validation later on.
+			kept = append(kept, ts)
+			continue
+		}
+
+		if rule, drop := f.match(metricName, allowed, blocked); drop {
+			f.discardedSamples.WithLabelValues(userID, rule).Add(float64(len(ts.Samples)))
+			continue
+		}
+		kept = append(kept, ts)
+	}
+
+	req.Timeseries = kept
+}
+
+// match returns whether the metric must be dropped and the rule causing it.
+func (f *Filter) match(metricName string, allowed, blocked []string) (string, bool) {
+	for _, pattern := range blocked {
+		if re := f.compile(pattern); re != nil && re.MatchString(metricName) {
+			return pattern, true
+		}
+	}
+
+	if len(allowed) == 0 {
+		return "", false
+	}
+	for _, pattern := range allowed {
+		if re := f.compile(pattern); re != nil && re.MatchString(metricName) {
+			return "", false
+		}
+	}
+	return ruleNotAllowed, true
+}
+
+// compile returns the anchored regular expression of the pattern, or nil if invalid.
+func (f *Filter) compile(pattern string) *regexp.Regexp {
+	if re, ok := f.patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		level.Warn(f.logger).Log("msg", "ignoring invalid metric name pattern", "pattern", pattern, "err", err)
+		re = nil
+	}
+
+	f.patterns.Store(pattern, re)
+	return re
+}
@@ -0,0 +1,88 @@
+package metricfilter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+type limitsMock struct {
+	allowed, blocked []string
+}
+
+func (m limitsMock) AllowedMetricNames(string) []string { return m.allowed }
+func (m limitsMock) BlockedMetricNames(string) []string { return m.blocked }
+
+func TestFilter_PushMiddleware(t *testing.T) {
+	tests := map[string]struct {
+		limits            limitsMock
+		expected          []string
+		expectedDiscarded map[string]float64
+	}{
+		"no rules": {
+			limits:   limitsMock{},
+			expected: []string{"up", "http_requests_total", "noisy_metric"},
+		},
+		"blocklist": {
+			limits:            limitsMock{blocked: []string{"noisy_.*", "up"}},
+			expected:          []string{"http_requests_total"},
+			expectedDiscarded: map[string]float64{"noisy_.*": 1, "up": 1},
+		},
+		"allowlist": {
+			limits:            limitsMock{allowed: []string{"http_.*", "up"}},
+			expected:          []string{"up", "http_requests_total"},
+			expectedDiscarded: map[string]float64{ruleNotAllowed: 1},
+		},
+		"blocklist takes precedence over the allowlist": {
+			limits:            limitsMock{allowed: []string{".*"}, blocked: []string{"http_.*"}},
+			expected:          []string{"up", "noisy_metric"},
+			expectedDiscarded: map[string]float64{"http_.*": 1},
+		},
+		"patterns are anchored": {
+			limits:            limitsMock{blocked: []string{"requests"}},
+			expected:          []string{"up", "http_requests_total", "noisy_metric"},
+			expectedDiscarded: map[string]float64{},
+		},
+		"invalid patterns are ignored": {
+			limits:   limitsMock{blocked: []string{"(invalid"}},
+			expected: []string{"up", "http_requests_total", "noisy_metric"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			filter := NewFilter(tc.limits, log.NewNopLogger(), nil)
+
+			var pushed []string
+			f := filter.PushMiddleware()(func(_ context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				for _, ts := range req.Timeseries {
+					pushed = append(pushed, ts.Labels[0].Value)
+				}
+				return &cortexpb.WriteResponse{}, nil
+			})
+
+			req := &cortexpb.WriteRequest{}
+			for _, name := range []string{"up", "http_requests_total", "noisy_metric"} {
+				req.Timeseries = append(req.Timeseries, cortexpb.PreallocTimeseries{TimeSeries: &cortexpb.TimeSeries{
+					Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: name}},
+					Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}},
+				}})
+			}
+
+			_, err := f(user.InjectOrgID(context.Background(), "user-1"), req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, pushed)
+
+			for rule, expected := range tc.expectedDiscarded {
+				assert.Equal(t, expected, testutil.ToFloat64(filter.discardedSamples.WithLabelValues("user-1", rule)), rule)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package overrides
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+// FeatureUTF8Names enables the UTF-8 metric and label names for the tenant, which are
+// accepted on ingest and escaped for the query clients only supporting the legacy charset.
+const FeatureUTF8Names = "utf8_names"
+
+// Supported backends for the runtime-reloadable overrides.
+const (
+	BackendFile = "file"
+	BackendKV   = "kv"
+)
+
+var (
+	supportedBackends = []string{BackendFile, BackendKV}
+
+	errUnsupportedBackend = fmt.Errorf("unsupported overrides backend, supported values are: %v", supportedBackends)
+	errEmptyKey           = errors.New("the overrides KV key must not be empty")
+	errInvalidBurstSize   = errors.New("the request burst size must be at least 1 when the request rate limit is enabled")
+)
+
+// Config holds the configuration of the runtime-reloadable overrides.
+type Config struct {
+	Backend string    `yaml:"backend"`
+	KVStore kv.Config `yaml:"kvstore" doc:"description=The key-value store holding the overrides when the kv backend is used."`
+	Key     string    `yaml:"key"`
+
+	Defaults Settings `yaml:"defaults"`
+}
+
+// RegisterFlags registers the overrides flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.Backend, "overrides.backend", BackendFile, fmt.Sprintf("Where the per-tenant overrides are loaded from. Supported values are: %v. The file backend reads the file configured via -runtime-config.file, while the kv backend watches a key in the configured KV store.", supportedBackends))
+	cfg.KVStore.RegisterFlagsWithPrefix("overrides.", "overrides/", f)
+	f.StringVar(&cfg.Key, "overrides.kv-key", "runtime-config", "The KV store key holding the overrides, in the same YAML format of the runtime config file.")
+	cfg.Defaults.RegisterFlags(f)
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	switch cfg.Backend {
+	case BackendFile:
+	case BackendKV:
+		if cfg.Key == "" {
+			return errEmptyKey
+		}
+	default:
+		return errUnsupportedBackend
+	}
+	return errors.Wrap(cfg.Defaults.Validate(), "invalid default overrides")
+}
+
+// Settings holds the per-tenant settings not covered by validation.Limits.
+type Settings struct {
+	RetentionPeriod      model.Duration         `yaml:"retention_period"`
+	OutOfOrderTimeWindow model.Duration         `yaml:"out_of_order_time_window"`
+	TieringThreshold     model.Duration         `yaml:"tiering_threshold"`
+	EnabledFeatures      flagext.StringSliceCSV `yaml:"enabled_features"`
+	RequestRate          float64                `yaml:"request_rate"`
+	RequestBurstSize     int                    `yaml:"request_burst_size"`
+
+	// CostCenter is attached to the cost attribution metrics of the tenant, for chargeback.
+	// It has no default because it's specific to each tenant.
+	CostCenter string `yaml:"cost_center"`
+
+	// Metric name patterns, as anchored regular expressions. If the allowlist is not empty,
+	// only matching metrics are ingested. Metrics matching the blocklist are always dropped.
+	AllowedMetricNames []string `yaml:"allowed_metric_names"`
+	BlockedMetricNames []string `yaml:"blocked_metric_names"`
+
+	// AllowedSourceCIDRs are the networks the tenant requests are accepted from. Empty to
+	// accept requests from any network.
+	AllowedSourceCIDRs []string `yaml:"allowed_source_cidrs"`
+
+	// Validation rules of the series labels, on top of the length limits of validation.Limits.
+	ValidateMetricNames       bool `yaml:"validate_metric_names"`
+	ValidateLabelNames        bool `yaml:"validate_label_names"`
+	RejectDuplicateLabelNames bool `yaml:"reject_duplicate_label_names"`
+
+	// ClampSampleTimestamps clamps the timestamps of the samples out of the accepted time
+	// range, set by validation.Limits, to its bounds instead of rejecting the samples.
+	ClampSampleTimestamps bool `yaml:"clamp_sample_timestamps"`
+
+	// Tee are the rules of the series ingested for the tenant which are also forwarded to
+	// the remote write endpoints of the tee.
+	Tee []TeeRule `yaml:"tee"`
+}
+
+// TeeRule forwards the tenant series matching any of the series selectors, like
+// {job="api"}, to the named tee endpoint. All the series are forwarded if no selector is set.
+type TeeRule struct {
+	Endpoint string   `yaml:"endpoint"`
+	Matchers []string `yaml:"matchers"`
+}
+
+// RegisterFlags registers the default per-tenant settings flags.
+func (s *Settings) RegisterFlags(f *flag.FlagSet) {
+	f.Var(&s.RetentionPeriod, "overrides.retention-period", "Default retention period of the tenants blocks, enforced by the compactor. Takes precedence over -compactor.blocks-retention-period if not 0.")
+	f.Var(&s.OutOfOrderTimeWindow, "overrides.out-of-order-time-window", "Default time window within which out-of-order samples are accepted by the TSDB. Takes precedence over -ingester.out-of-order-time-window if not 0.")
+	f.Var(&s.TieringThreshold, "overrides.tiering-threshold", "Default age of the tenants blocks, by max time, after which they're transitioned to the colder storage class of the tiering. 0 to keep the blocks in the default storage class.")
+	f.Var(&s.EnabledFeatures, "overrides.enabled-features", "Comma-separated list of features enabled by default for all tenants.")
+	f.Float64Var(&s.RequestRate, "overrides.request-rate", 0, "Default per-tenant push requests rate limit, in requests per second. 0 to disable.")
+	f.IntVar(&s.RequestBurstSize, "overrides.request-burst-size", 0, "Default per-tenant push requests burst size, in requests. Must be at least 1 if the rate limit is enabled.")
+	f.BoolVar(&s.ValidateMetricNames, "overrides.validate-metric-names", true, "True to reject the series whose metric name doesn't match the Prometheus metric name charset [a-zA-Z_:][a-zA-Z0-9_:]*.")
+	f.BoolVar(&s.ValidateLabelNames, "overrides.validate-label-names", true, "True to reject the series with a label name not matching the Prometheus label name charset [a-zA-Z_][a-zA-Z0-9_]*.")
+	f.BoolVar(&s.RejectDuplicateLabelNames, "overrides.reject-duplicate-label-names", true, "True to reject the series with the same label name more than once.")
+	f.BoolVar(&s.ClampSampleTimestamps, "overrides.clamp-sample-timestamps", false, "True to clamp the timestamps of the samples older than the reject old samples max age, or newer than the creation grace period, to these bounds instead of rejecting the samples.")
+}
+
+// Validate the settings.
+func (s *Settings) Validate() error {
+	// An empty token bucket rejects every request.
+	if s.RequestRate > 0 && s.RequestBurstSize < 1 {
+		return errInvalidBurstSize
+	}
+	return nil
+}
+
+// FeatureEnabled returns whether the input feature is enabled.
+func (s *Settings) FeatureEnabled(feature string) bool {
+	for _, f := range s.EnabledFeatures {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultSettings are the settings a tenant override starts from when unmarshalled,
+// so that an override only needs to list the settings it changes.
+var defaultSettings *Settings
+
+// SetDefaultSettingsForYAMLUnmarshalling sets the settings tenant overrides are
+// unmarshalled on top of.
+func SetDefaultSettingsForYAMLUnmarshalling(defaults Settings) {
+	defaultSettings = &defaults
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *Settings) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if defaultSettings != nil {
+		*s = *defaultSettings
+		// Copy the slice, to not share it with the defaults.
+		s.EnabledFeatures = append(flagext.StringSliceCSV(nil), defaultSettings.EnabledFeatures...)
+	}
+
+	type plain Settings
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	return s.Validate()
+}
+
+// TenantSettings provides the settings overridden for each tenant.
+type TenantSettings interface {
+	// ByUserID returns the settings of the input tenant, or nil if not overridden.
+	ByUserID(userID string) *Settings
+
+	// AllByUserID returns the settings of all overridden tenants.
+	AllByUserID() map[string]*Settings
+}
+
+// Overrides returns the effective per-tenant settings.
+type Overrides struct {
+	defaults Settings
+	tenants  TenantSettings
+}
+
+// NewOverrides makes a new Overrides. The tenants may be nil.
+func NewOverrides(defaults Settings, tenants TenantSettings) *Overrides {
+	return &Overrides{defaults: defaults, tenants: tenants}
+}
+
+// RetentionPeriod returns the retention period of the tenant blocks.
+func (o *Overrides) RetentionPeriod(userID string) time.Duration {
+	return time.Duration(o.settings(userID).RetentionPeriod)
+}
+
+// TieringThreshold returns the age after which the tenant blocks are transitioned to the
+// colder storage class, 0 if they're never transitioned.
+func (o *Overrides) TieringThreshold(userID string) time.Duration {
+	return time.Duration(o.settings(userID).TieringThreshold)
+}
+
+// OutOfOrderTimeWindow returns the time window within which out-of-order samples are accepted.
+func (o *Overrides) OutOfOrderTimeWindow(userID string) time.Duration {
+	return time.Duration(o.settings(userID).OutOfOrderTimeWindow)
+}
+
+// RequestRate returns the max push requests per second of the tenant. 0 means unlimited.
+func (o *Overrides) RequestRate(userID string) float64 {
+	return o.settings(userID).RequestRate
+}
+
+// RequestBurstSize returns the push requests burst size of the tenant.
+func (o *Overrides) RequestBurstSize(userID string) int {
+	return o.settings(userID).RequestBurstSize
+}
+
+// CostCenter returns the cost center the tenant usage is charged to.
+func (o *Overrides) CostCenter(userID string) string {
+	return o.settings(userID).CostCenter
+}
+
+// AllowedMetricNames returns the patterns of the metric names the tenant is allowed to ingest.
+func (o *Overrides) AllowedMetricNames(userID string) []string {
+	return o.settings(userID).AllowedMetricNames
+}
+
+// BlockedMetricNames returns the patterns of the metric names dropped for the tenant.
+func (o *Overrides) BlockedMetricNames(userID string) []string {
+	return o.settings(userID).BlockedMetricNames
+}
+
+// AllowedSourceCIDRs returns the networks the tenant requests are accepted from.
+func (o *Overrides) AllowedSourceCIDRs(userID string) []string {
+	return o.settings(userID).AllowedSourceCIDRs
+}
+
+// ValidateMetricNames returns whether the metric names of the tenant series are validated.
+func (o *Overrides) ValidateMetricNames(userID string) bool {
+	return o.settings(userID).ValidateMetricNames
+}
+
+// ValidateLabelNames returns whether the label names of the tenant series are validated.
+func (o *Overrides) ValidateLabelNames(userID string) bool {
+	return o.settings(userID).ValidateLabelNames
+}
+
+// RejectDuplicateLabelNames returns whether the tenant series with duplicate label names
+// are rejected.
+func (o *Overrides) RejectDuplicateLabelNames(userID string) bool {
+	return o.settings(userID).RejectDuplicateLabelNames
+}
+
+// ClampSampleTimestamps returns whether the timestamps of the tenant samples out of the
+// accepted time range are clamped instead of rejected.
+func (o *Overrides) ClampSampleTimestamps(userID string) bool {
+	return o.settings(userID).ClampSampleTimestamps
+}
+
+// Tee returns the rules of the tenant series forwarded to the tee endpoints.
+func (o *Overrides) Tee(userID string) []TeeRule {
+	return o.settings(userID).Tee
+}
+
+// UTF8NamesEnabled returns whether the tenant has the UTF-8 names enabled.
+func (o *Overrides) UTF8NamesEnabled(userID string) bool {
+	return o.FeatureEnabled(userID, FeatureUTF8Names)
+}
+
+// FeatureEnabled returns whether the input feature is enabled for the tenant.
+func (o *Overrides) FeatureEnabled(userID, feature string) bool {
+	return o.settings(userID).FeatureEnabled(feature)
+}
+
+// Settings returns a copy of the effective settings of the tenant.
+func (o *Overrides) Settings(userID string) Settings {
+	return *o.settings(userID)
+}
+
+func (o *Overrides) settings(userID string) *Settings {
+	if o.tenants != nil {
+		if s := o.tenants.ByUserID(userID); s != nil {
+			return s
+		}
+	}
+	return &o.defaults
+}
@@ -0,0 +1,107 @@
+package overrides
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *Config)
+		expected error
+	}{
+		"default config": {
+			setup:    func(*Config) {},
+			expected: nil,
+		},
+		"kv backend": {
+			setup:    func(cfg *Config) { cfg.Backend = BackendKV },
+			expected: nil,
+		},
+		"kv backend with empty key": {
+			setup: func(cfg *Config) {
+				cfg.Backend = BackendKV
+				cfg.Key = ""
+			},
+			expected: errEmptyKey,
+		},
+		"unsupported backend": {
+			setup:    func(cfg *Config) { cfg.Backend = "unknown" },
+			expected: errUnsupportedBackend,
+		},
+		"default request rate without burst": {
+			setup:    func(cfg *Config) { cfg.Defaults.RequestRate = 10 },
+			expected: errInvalidBurstSize,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+			assert.ErrorIs(t, cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+func TestSettings_UnmarshalYAMLShouldStartFromDefaults(t *testing.T) {
+	defaults := Settings{
+		RetentionPeriod: model.Duration(24 * time.Hour),
+		EnabledFeatures: []string{"a"},
+	}
+	SetDefaultSettingsForYAMLUnmarshalling(defaults)
+	t.Cleanup(func() { defaultSettings = nil })
+
+	tenants := map[string]*Settings{}
+	require.NoError(t, yaml.Unmarshal([]byte(`
+user-1:
+  out_of_order_time_window: 10m
+user-2:
+  retention_period: 1h
+  tiering_threshold: 720h
+  enabled_features: b,c
+  tee:
+    - endpoint: analytics
+      matchers: ['{job="api"}']
+`), &tenants))
+
+	o := NewOverrides(defaults, staticSettings(tenants))
+
+	assert.Equal(t, 24*time.Hour, o.RetentionPeriod("user-1"))
+	assert.Equal(t, 10*time.Minute, o.OutOfOrderTimeWindow("user-1"))
+	assert.True(t, o.FeatureEnabled("user-1", "a"))
+
+	assert.Equal(t, time.Hour, o.RetentionPeriod("user-2"))
+	assert.Equal(t, 720*time.Hour, o.TieringThreshold("user-2"))
+	assert.Equal(t, time.Duration(0), o.TieringThreshold("user-1"))
+	assert.False(t, o.FeatureEnabled("user-2", "a"))
+	assert.True(t, o.FeatureEnabled("user-2", "c"))
+	assert.Equal(t, []TeeRule{{Endpoint: "analytics", Matchers: []string{`{job="api"}`}}}, o.Tee("user-2"))
+	assert.Empty(t, o.Tee("user-1"))
+
+	assert.Equal(t, 24*time.Hour, o.RetentionPeriod("user-3"))
+	assert.Equal(t, time.Duration(0), o.OutOfOrderTimeWindow("user-3"))
+	assert.Equal(t, defaults, o.Settings("user-3"))
+}
+
+type staticSettings map[string]*Settings
+
+func (s staticSettings) ByUserID(userID string) *Settings  { return s[userID] }
+func (s staticSettings) AllByUserID() map[string]*Settings { return s }
+
+func TestSettings_UnmarshalYAMLShouldRejectRequestRateWithoutBurst(t *testing.T) {
+	tenants := map[string]*Settings{}
+	err := yaml.Unmarshal([]byte(`
+user-1:
+  request_rate: 10
+`), &tenants)
+	assert.ErrorIs(t, err, errInvalidBurstSize)
+}
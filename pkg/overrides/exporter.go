@@ -0,0 +1,99 @@
+package overrides
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+// Exporter exposes the effective limits and settings of each overridden tenant as metrics,
+// so that operators can verify the overrides in effect.
+type Exporter struct {
+	limits         *validation.Overrides
+	tenantLimits   validation.TenantLimits
+	settings       *Overrides
+	tenantSettings TenantSettings
+
+	overrideDesc *prometheus.Desc
+	featureDesc  *prometheus.Desc
+}
+
+// NewExporter makes a new Exporter. The tenantLimits and tenantSettings are used to list
+// the overridden tenants, and may be nil.
+func NewExporter(limits *validation.Overrides, tenantLimits validation.TenantLimits, settings *Overrides, tenantSettings TenantSettings) *Exporter {
+	return &Exporter{
+		limits:         limits,
+		tenantLimits:   tenantLimits,
+		settings:       settings,
+		tenantSettings: tenantSettings,
+		overrideDesc: prometheus.NewDesc(
+			"cortex_overrides",
+			"Effective value of the limits and settings of each overridden tenant.",
+			[]string{"limit_name", "user"}, nil),
+		featureDesc: prometheus.NewDesc(
+			"cortex_overrides_feature_enabled",
+			"1 if the feature is enabled for the overridden tenant.",
+			[]string{"feature", "user"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- e.overrideDesc
+	ch <- e.featureDesc
+}
+
+// Collect implements prometheus.Collector.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	for _, userID := range e.tenants() {
+		for name, value := range map[string]float64{
+			"ingestion_rate":                   e.limits.IngestionRate(userID),
+			"ingestion_burst_size":             float64(e.limits.IngestionBurstSize(userID)),
+			"max_local_series_per_user":        float64(e.limits.MaxLocalSeriesPerUser(userID)),
+			"max_local_series_per_metric":      float64(e.limits.MaxLocalSeriesPerMetric(userID)),
+			"max_global_series_per_user":       float64(e.limits.MaxGlobalSeriesPerUser(userID)),
+			"max_global_series_per_metric":     float64(e.limits.MaxGlobalSeriesPerMetric(userID)),
+			"max_label_names_per_series":       float64(e.limits.MaxLabelNamesPerSeries(userID)),
+			"max_label_value_length":           float64(e.limits.MaxLabelValueLength(userID)),
+			"retention_period_seconds":         e.settings.RetentionPeriod(userID).Seconds(),
+			"out_of_order_time_window_seconds": e.settings.OutOfOrderTimeWindow(userID).Seconds(),
+			"request_rate":                     e.settings.RequestRate(userID),
+			"request_burst_size":               float64(e.settings.RequestBurstSize(userID)),
+		} {
+			ch <- prometheus.MustNewConstMetric(e.overrideDesc, prometheus.GaugeValue, value, name, userID)
+		}
+
+		seen := map[string]struct{}{}
+		for _, feature := range e.settings.settings(userID).EnabledFeatures {
+			if _, ok := seen[feature]; ok {
+				continue
+			}
+			seen[feature] = struct{}{}
+			ch <- prometheus.MustNewConstMetric(e.featureDesc, prometheus.GaugeValue, 1, feature, userID)
+		}
+	}
+}
+
+// tenants returns the sorted list of tenants having limits or settings overridden.
+func (e *Exporter) tenants() []string {
+	unique := map[string]struct{}{}
+	if e.tenantLimits != nil {
+		for userID := range e.tenantLimits.AllByUserID() {
+			unique[userID] = struct{}{}
+		}
+	}
+	if e.tenantSettings != nil {
+		for userID := range e.tenantSettings.AllByUserID() {
+			unique[userID] = struct{}{}
+		}
+	}
+
+	out := make([]string, 0, len(unique))
+	for userID := range unique {
+		out = append(out, userID)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,71 @@
+package overrides
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+type staticLimits map[string]*validation.Limits
+
+func (l staticLimits) ByUserID(userID string) *validation.Limits  { return l[userID] }
+func (l staticLimits) AllByUserID() map[string]*validation.Limits { return l }
+
+func TestExporter_Collect(t *testing.T) {
+	defaults := validation.Limits{}
+	flagext.DefaultValues(&defaults)
+
+	userLimits := defaults
+	userLimits.IngestionRate = 100
+	userLimits.MaxLocalSeriesPerUser = 1000
+	tenantLimits := staticLimits{"user-1": &userLimits}
+
+	limits, err := validation.NewOverrides(defaults, tenantLimits)
+	require.NoError(t, err)
+
+	tenantSettings := staticSettings{"user-2": &Settings{EnabledFeatures: []string{"relabeling", "relabeling"}}}
+	settings := NewOverrides(Settings{}, tenantSettings)
+
+	exporter := NewExporter(limits, tenantLimits, settings, tenantSettings)
+
+	require.NoError(t, testutil.CollectAndCompare(exporter, strings.NewReader(`
+		# HELP cortex_overrides_feature_enabled 1 if the feature is enabled for the overridden tenant.
+		# TYPE cortex_overrides_feature_enabled gauge
+		cortex_overrides_feature_enabled{feature="relabeling",user="user-2"} 1
+	`), "cortex_overrides_feature_enabled"))
+
+	require.Equal(t, float64(100), collectValue(t, exporter, "ingestion_rate", "user-1"))
+	require.Equal(t, float64(1000), collectValue(t, exporter, "max_local_series_per_user", "user-1"))
+	require.Equal(t, defaults.IngestionRate, collectValue(t, exporter, "ingestion_rate", "user-2"))
+}
+
+func collectValue(t *testing.T, exporter *Exporter, limitName, userID string) float64 {
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(exporter)
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, mf := range families {
+		if mf.GetName() != "cortex_overrides" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			lbls := map[string]string{}
+			for _, l := range m.GetLabel() {
+				lbls[l.GetName()] = l.GetValue()
+			}
+			if lbls["limit_name"] == limitName && lbls["user"] == userID {
+				return m.GetGauge().GetValue()
+			}
+		}
+	}
+
+	t.Fatalf("metric for limit %s and user %s not found", limitName, userID)
+	return 0
+}
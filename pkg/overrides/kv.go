@@ -0,0 +1,138 @@
+package overrides
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/atomic"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/cortexproject/cortex/pkg/util/runtimeconfig"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+// loaderCodec decodes the values stored in the KV store with a runtimeconfig.Loader. Values
+// are stored as raw YAML, so that operators can edit them with the KV store tooling.
+type loaderCodec struct {
+	loader runtimeconfig.Loader
+}
+
+// CodecID implements codec.Codec.
+func (loaderCodec) CodecID() string { return "runtimeConfig" }
+
+// Decode implements codec.Codec.
+func (c loaderCodec) Decode(data []byte) (interface{}, error) {
+	return c.loader(bytes.NewReader(data))
+}
+
+// Encode implements codec.Codec. Only raw YAML can be written to the KV store.
+func (loaderCodec) Encode(v interface{}) ([]byte, error) {
+	if data, ok := v.([]byte); ok {
+		return data, nil
+	}
+	return nil, errors.Errorf("unsupported overrides value type %T", v)
+}
+
+// DecodeMultiKey implements codec.Codec. The overrides are stored under a single key.
+func (loaderCodec) DecodeMultiKey(map[string][]byte) (interface{}, error) {
+	return nil, errors.New("the overrides codec doesn't support multi-key values")
+}
+
+// EncodeMultiKey implements codec.Codec.
+func (loaderCodec) EncodeMultiKey(interface{}) (map[string][]byte, error) {
+	return nil, errors.New("the overrides codec doesn't support multi-key values")
+}
+
+// KVProvider keeps the runtime config stored in a KV store up to date by watching its key.
+// It exposes the same GetConfig() of the runtimeconfig.Manager.
+type KVProvider struct {
+	services.Service
+
+	client kv.Client
+	key    string
+	logger log.Logger
+
+	config atomic.Value
+
+	lastReloadSuccessful prometheus.Gauge
+	lastReloadTimestamp  prometheus.Gauge
+}
+
+// NewKVProvider makes a new KVProvider decoding the KV store value with the input loader.
+func NewKVProvider(cfg Config, loader runtimeconfig.Loader, logger log.Logger, reg prometheus.Registerer) (*KVProvider, error) {
+	client, err := kv.NewClient(cfg.KVStore, loaderCodec{loader: loader}, kv.RegistererWithKVName(reg, "overrides"), logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "create overrides KV client")
+	}
+
+	return newKVProvider(client, cfg.Key, logger, reg), nil
+}
+
+func newKVProvider(client kv.Client, key string, logger log.Logger, reg prometheus.Registerer) *KVProvider {
+	p := &KVProvider{
+		client: client,
+		key:    key,
+		logger: logger,
+		lastReloadSuccessful: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_overrides_kv_last_reload_successful",
+			Help: "Whether the last overrides reload from the KV store succeeded.",
+		}),
+		lastReloadTimestamp: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_overrides_kv_last_reload_timestamp_seconds",
+			Help: "Timestamp of the last successful overrides reload from the KV store.",
+		}),
+	}
+
+	p.Service = services.NewBasicService(p.starting, p.running, nil)
+	return p
+}
+
+func (p *KVProvider) starting(ctx context.Context) error {
+	val, err := p.client.Get(ctx, p.key)
+	if err != nil {
+		// Failing to load the overrides at startup is fatal, like for the file backend,
+		// otherwise the default limits would be enforced on all tenants.
+		p.lastReloadSuccessful.Set(0)
+		return errors.Wrap(err, "load overrides from KV store")
+	}
+
+	p.set(val)
+	return nil
+}
+
+func (p *KVProvider) running(ctx context.Context) error {
+	p.client.WatchKey(ctx, p.key, func(val interface{}) bool {
+		p.set(val)
+		return true
+	})
+	return nil
+}
+
+func (p *KVProvider) set(val interface{}) {
+	// A missing key means no overrides, so the tenants are reset to the defaults when it's
+	// deleted. The value is wrapped since an atomic.Value can't store nil.
+	p.config.Store(kvConfig{value: val})
+
+	p.lastReloadSuccessful.Set(1)
+	p.lastReloadTimestamp.Set(float64(time.Now().Unix()))
+	level.Debug(p.logger).Log("msg", "overrides reloaded from KV store", "key", p.key)
+}
+
+// GetConfig returns the last loaded config, or nil if none has been loaded or the key
+// doesn't exist.
+func (p *KVProvider) GetConfig() interface{} {
+	if cfg, ok := p.config.Load().(kvConfig); ok {
+		return cfg.value
+	}
+	return nil
+}
+
+type kvConfig struct {
+	value interface{}
+}
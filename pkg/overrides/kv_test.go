@@ -0,0 +1,74 @@
+package overrides
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/cortexproject/cortex/pkg/util/test"
+)
+
+type testValues struct {
+	Settings map[string]*Settings `yaml:"tenant_settings"`
+}
+
+func loadTestValues(r io.Reader) (interface{}, error) {
+	values := &testValues{}
+	if err := yaml.NewDecoder(r).Decode(values); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return values, nil
+}
+
+func TestKVProvider_ShouldReloadOnChange(t *testing.T) {
+	ctx := context.Background()
+	client, closer := consul.NewInMemoryClient(loaderCodec{loader: loadTestValues}, log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	put := func(data string) {
+		require.NoError(t, client.CAS(ctx, "runtime-config", func(interface{}) (interface{}, bool, error) {
+			return []byte(data), true, nil
+		}))
+	}
+	put("tenant_settings:\n  user-1:\n    retention_period: 1h\n")
+
+	p := newKVProvider(client, "runtime-config", log.NewNopLogger(), nil)
+	require.NoError(t, services.StartAndAwaitRunning(ctx, p))
+	t.Cleanup(func() { assert.NoError(t, services.StopAndAwaitTerminated(ctx, p)) })
+
+	retention := func() time.Duration {
+		values, ok := p.GetConfig().(*testValues)
+		if !ok || values.Settings["user-1"] == nil {
+			return 0
+		}
+		return time.Duration(values.Settings["user-1"].RetentionPeriod)
+	}
+	assert.Equal(t, time.Hour, retention())
+
+	put("tenant_settings:\n  user-1:\n    retention_period: 2h\n")
+	test.Poll(t, time.Second, 2*time.Hour, func() interface{} { return retention() })
+
+	// The overrides are reset to the defaults once the key is deleted.
+	p.set(nil)
+	assert.Nil(t, p.GetConfig())
+	assert.Equal(t, time.Duration(0), retention())
+}
+
+func TestLoaderCodec_Encode(t *testing.T) {
+	c := loaderCodec{loader: loadTestValues}
+
+	data, err := c.Encode([]byte("tenant_settings: {}"))
+	require.NoError(t, err)
+	assert.Equal(t, "tenant_settings: {}", string(data))
+
+	_, err = c.Encode(&testValues{})
+	require.Error(t, err)
+}
@@ -0,0 +1,259 @@
+package profiling
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/push"
+	"objectstorage/pkg/tenant"
+	"objectstorage/pkg/util/flagext"
+)
+
+var (
+	errInvalidServerAddress = errors.New("the profiling server address must be an http or https URL")
+	errInvalidUploadPeriod  = errors.New("the profiling upload period must be at least 1s")
+	errMissingAppName       = errors.New("the profiling application name is required")
+)
+
+// Config holds the configuration of the continuous profiling, pushing the CPU and heap
+// profiles to a server implementing the Pyroscope ingest API.
+type Config struct {
+	ServerAddress     string         `yaml:"server_address"`
+	ApplicationName   string         `yaml:"application_name"`
+	UploadPeriod      time.Duration  `yaml:"upload_period"`
+	TenantID          string         `yaml:"tenant_id"`
+	BasicAuthUser     string         `yaml:"basic_auth_user"`
+	BasicAuthPassword flagext.Secret `yaml:"basic_auth_password"`
+}
+
+// RegisterFlags registers the profiling flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.ServerAddress, "profiling.server-address", "", "URL of the server the CPU and heap profiles are continuously pushed to, implementing the Pyroscope ingest API, for example Pyroscope or Parca behind its Pyroscope compatible receiver. Empty to disable.")
+	f.StringVar(&cfg.ApplicationName, "profiling.application-name", "blockstorage-ingester", "Application name the profiles are pushed as.")
+	f.DurationVar(&cfg.UploadPeriod, "profiling.upload-period", 15*time.Second, "Duration of each CPU profile, and how frequently the profiles are pushed.")
+	f.StringVar(&cfg.TenantID, "profiling.tenant-id", "", "Tenant ID sent in the X-Scope-OrgID header, for multi-tenant profiling servers.")
+	f.StringVar(&cfg.BasicAuthUser, "profiling.basic-auth-user", "", "Basic auth username used to push the profiles.")
+	f.Var(&cfg.BasicAuthPassword, "profiling.basic-auth-password", "Basic auth password used to push the profiles.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled() {
+		return nil
+	}
+	if u, err := url.Parse(cfg.ServerAddress); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errInvalidServerAddress
+	}
+	if cfg.UploadPeriod < time.Second {
+		return errInvalidUploadPeriod
+	}
+	if cfg.ApplicationName == "" {
+		return errMissingAppName
+	}
+	return nil
+}
+
+// Enabled returns whether the continuous profiling is enabled.
+func (cfg *Config) Enabled() bool {
+	return cfg.ServerAddress != ""
+}
+
+// Profiler continuously collects the CPU and heap profiles and pushes them, every upload
+// period, labeled with the static labels. The samples are labeled with the pprof labels of
+// the code paths, see Middleware.
+type Profiler struct {
+	services.Service
+
+	cfg    Config
+	name   string
+	client *http.Client
+	logger log.Logger
+
+	cpu      bytes.Buffer
+	cpuStart time.Time
+	cpuOn    bool
+
+	uploads       *prometheus.CounterVec
+	uploadsFailed *prometheus.CounterVec
+}
+
+// NewProfiler makes a new Profiler. The labels are attached to all the pushed profiles, the
+// instance label is added if missing.
+func NewProfiler(cfg Config, labels map[string]string, logger log.Logger, reg prometheus.Registerer) *Profiler {
+	if _, ok := labels["instance"]; !ok {
+		if hostname, err := os.Hostname(); err == nil {
+			labels = withLabel(labels, "instance", hostname)
+		}
+	}
+
+	p := &Profiler{
+		cfg:    cfg,
+		name:   cfg.ApplicationName + formatLabels(labels),
+		client: &http.Client{Timeout: cfg.UploadPeriod},
+		logger: logger,
+		uploads: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_profiling_uploads_total",
+			Help: "Total number of profiles pushed, by profile type.",
+		}, []string{"type"}),
+		uploadsFailed: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_profiling_uploads_failed_total",
+			Help: "Total number of profiles failed to be collected or pushed, by profile type.",
+		}, []string{"type"}),
+	}
+
+	p.Service = services.NewTimerService(cfg.UploadPeriod, p.starting, p.iteration, p.stopping)
+	return p
+}
+
+func (p *Profiler) starting(_ context.Context) error {
+	p.startCPU()
+	return nil
+}
+
+func (p *Profiler) iteration(ctx context.Context) error {
+	// Failures are counted and logged, but never fail the service.
+	p.flush(ctx)
+	p.startCPU()
+	return nil
+}
+
+func (p *Profiler) stopping(_ error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.UploadPeriod)
+	defer cancel()
+
+	p.flush(ctx)
+	return nil
+}
+
+// startCPU starts the CPU profile. It fails if a CPU profile is already being captured, for
+// example from the pprof endpoint, in which case it's retried on the next iteration.
+func (p *Profiler) startCPU() {
+	p.cpu.Reset()
+	p.cpuStart = time.Now()
+	if err := pprof.StartCPUProfile(&p.cpu); err != nil {
+		p.uploadsFailed.WithLabelValues("cpu").Inc()
+		level.Debug(p.logger).Log("msg", "failed to start the CPU profile", "err", err)
+		return
+	}
+	p.cpuOn = true
+}
+
+// flush stops the CPU profile and pushes it along with the heap profile.
+func (p *Profiler) flush(ctx context.Context) {
+	now := time.Now()
+
+	if p.cpuOn {
+		pprof.StopCPUProfile()
+		p.cpuOn = false
+		p.upload(ctx, "cpu", p.cpu.Bytes(), p.cpuStart, now)
+	}
+
+	var heap bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&heap, 0); err != nil {
+		p.uploadsFailed.WithLabelValues("heap").Inc()
+		level.Warn(p.logger).Log("msg", "failed to collect the heap profile", "err", err)
+		return
+	}
+	p.upload(ctx, "heap", heap.Bytes(), now.Add(-p.cfg.UploadPeriod), now)
+}
+
+func (p *Profiler) upload(ctx context.Context, typ string, profile []byte, from, until time.Time) {
+	if err := p.send(ctx, profile, from, until); err != nil {
+		p.uploadsFailed.WithLabelValues(typ).Inc()
+		level.Warn(p.logger).Log("msg", "failed to push the profile", "type", typ, "err", err)
+		return
+	}
+	p.uploads.WithLabelValues(typ).Inc()
+}
+
+func (p *Profiler) send(ctx context.Context, profile []byte, from, until time.Time) error {
+	params := url.Values{}
+	params.Set("name", p.name)
+	params.Set("from", strconv.FormatInt(from.Unix(), 10))
+	params.Set("until", strconv.FormatInt(until.Unix(), 10))
+	params.Set("format", "pprof")
+	params.Set("spyName", "gospy")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(p.cfg.ServerAddress, "/")+"/ingest?"+params.Encode(), bytes.NewReader(profile))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if p.cfg.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", p.cfg.TenantID)
+	}
+	if p.cfg.BasicAuthUser != "" {
+		req.SetBasicAuth(p.cfg.BasicAuthUser, p.cfg.BasicAuthPassword.Value)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Middleware returns the push middleware labeling the samples of the downstream stages with
+// the module and the tenant, so that the hot paths can be broken down by tenant.
+func Middleware(module string) push.Middleware {
+	return func(next push.Func) push.Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (resp *cortexpb.WriteResponse, err error) {
+			labels := []string{"module", module}
+			if userID, tenantErr := tenant.TenantID(ctx); tenantErr == nil {
+				labels = append(labels, "tenant", userID)
+			}
+
+			pprof.Do(ctx, pprof.Labels(labels...), func(ctx context.Context) {
+				resp, err = next(ctx, req)
+			})
+			return resp, err
+		}
+	}
+}
+
+// formatLabels returns the labels in the Pyroscope application name format.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func withLabel(labels map[string]string, name, value string) map[string]string {
+	out := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		out[k] = v
+	}
+	out[name] = value
+	return out
+}
@@ -0,0 +1,89 @@
+package profiling
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		cfg      Config
+		expected error
+	}{
+		"disabled": {
+			cfg:      Config{},
+			expected: nil,
+		},
+		"enabled": {
+			cfg:      Config{ServerAddress: "http://pyroscope:4040", ApplicationName: "ingester", UploadPeriod: 15 * time.Second},
+			expected: nil,
+		},
+		"invalid server address": {
+			cfg:      Config{ServerAddress: "pyroscope:4040", ApplicationName: "ingester", UploadPeriod: 15 * time.Second},
+			expected: errInvalidServerAddress,
+		},
+		"invalid upload period": {
+			cfg:      Config{ServerAddress: "http://pyroscope:4040", ApplicationName: "ingester"},
+			expected: errInvalidUploadPeriod,
+		},
+		"missing application name": {
+			cfg:      Config{ServerAddress: "http://pyroscope:4040", UploadPeriod: 15 * time.Second},
+			expected: errMissingAppName,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, tc.cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+func TestProfiler_Flush(t *testing.T) {
+	var names []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/ingest", r.URL.Path)
+		assert.Equal(t, "pprof", r.URL.Query().Get("format"))
+		assert.Equal(t, "team-a", r.Header.Get("X-Scope-OrgID"))
+		names = append(names, r.URL.Query().Get("name"))
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := Config{ServerAddress: srv.URL, ApplicationName: "ingester", UploadPeriod: time.Minute, TenantID: "team-a"}
+	p := NewProfiler(cfg, map[string]string{"instance": "ingester-1", "cluster": "eu"}, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+
+	p.startCPU()
+	p.flush(context.Background())
+
+	assert.Contains(t, names, "ingester{cluster=eu,instance=ingester-1}")
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.uploads.WithLabelValues("heap")))
+}
+
+func TestMiddleware(t *testing.T) {
+	var module, tenantID string
+	next := func(ctx context.Context, _ *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		module, _ = pprof.Label(ctx, "module")
+		tenantID, _ = pprof.Label(ctx, "tenant")
+		return &cortexpb.WriteResponse{}, nil
+	}
+
+	ctx := user.InjectOrgID(context.Background(), "user-1")
+	_, err := Middleware("push")(next)(ctx, &cortexpb.WriteRequest{})
+	require.NoError(t, err)
+
+	assert.Equal(t, "push", module)
+	assert.Equal(t, "user-1", tenantID)
+}
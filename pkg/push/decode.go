@@ -0,0 +1,289 @@
+package push
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	util_log "github.com/cortexproject/cortex/pkg/util/log"
+
+	"objectstorage/pkg/util/intern"
+	"objectstorage/pkg/util/slab"
+)
+
+// HandlerConfig holds the configuration of the push HTTP handler.
+type HandlerConfig struct {
+	ZeroCopyUnmarshal       bool `yaml:"zero_copy_unmarshal"`
+	MaxRequestBodySize      int  `yaml:"max_request_body_size_bytes"`
+	MaxDecompressedBodySize int  `yaml:"max_decompressed_body_size_bytes"`
+}
+
+// RegisterFlags registers the push handler flags.
+func (cfg *HandlerConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.ZeroCopyUnmarshal, "push.zero-copy-unmarshal", true, "True to decode the label names and values of the remote write requests as references to the request body, instead of copying them. Any label kept after the request, for example by a tracker, keeps the whole body in memory: disable to copy the labels of each request in a single allocation instead. Ignored if -label-interning.max-size-bytes is not 0: the labels are then interned, which only copies the labels not interned yet.")
+	f.IntVar(&cfg.MaxRequestBodySize, "push.max-request-body-size-bytes", 0, "Maximum size in bytes of the body of the push requests, as received. The larger requests are rejected with 413. 0 to use -server.grpc-max-recv-msg-size-bytes.")
+	f.IntVar(&cfg.MaxDecompressedBodySize, "push.max-decompressed-body-size-bytes", 0, "Maximum size in bytes of the body of the push requests once decompressed. The larger requests are rejected with 413 before the body is decompressed when its size is known, else as soon as the limit is reached. 0 to use -server.grpc-max-recv-msg-size-bytes.")
+}
+
+// bodyTooLargeError is returned for the request bodies larger than the limit, as received or
+// once decompressed.
+type bodyTooLargeError struct {
+	decompressed bool
+	// size is -1 if the body is only known to be larger than the limit.
+	size  int
+	limit int
+}
+
+func (e bodyTooLargeError) Error() string {
+	body := "request body"
+	if e.decompressed {
+		body = "decompressed request body"
+	}
+	if e.size < 0 {
+		return fmt.Sprintf("%s larger than the limit of %d bytes", body, e.limit)
+	}
+	return fmt.Sprintf("%s of %d bytes larger than the limit of %d bytes", body, e.size, e.limit)
+}
+
+// bodyBuffers pools the buffers the compressed request bodies are read into. The decompressed
+// bodies are taken from the slabs of the decoder instead, since they can only be reused once
+// the labels of the decoded requests don't reference them anymore.
+var bodyBuffers = sync.Pool{New: func() interface{} { return &bytes.Buffer{} }}
+
+// Content encodings of the push requests.
+const (
+	encodingSnappy   = "snappy"
+	encodingZstd     = "zstd"
+	encodingIdentity = "identity"
+)
+
+// minSlabSize is the size of the smallest slab the decompressed bodies are taken from.
+const minSlabSize = 4 << 10
+
+// zstdExpectedRatio is the compression ratio the zstd bodies are expected to have.
+const zstdExpectedRatio = 8
+
+// zstdMagic is the magic number prefixing the zstd frames.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// errUnsupportedEncoding is returned for the requests with an unsupported content encoding.
+var errUnsupportedEncoding = errors.New("unsupported content encoding, expected one of snappy, zstd, identity")
+
+// decoder decodes the write requests of the push handler.
+type decoder struct {
+	maxSize             int
+	maxDecompressedSize int
+	zeroCopy            bool
+	zstd                *zstd.Decoder
+	slabs               *slab.Pool
+
+	// interner is nil if the label interning is disabled.
+	interner *intern.Table
+}
+
+// newDecoder makes a new decoder. The body size limits default to the defaultMaxSize.
+func newDecoder(cfg HandlerConfig, defaultMaxSize int) *decoder {
+	d := &decoder{
+		maxSize:             defaultMaxSize,
+		maxDecompressedSize: defaultMaxSize,
+		zeroCopy:            cfg.ZeroCopyUnmarshal,
+		interner:            intern.Global(),
+	}
+	if cfg.MaxRequestBodySize > 0 {
+		d.maxSize = cfg.MaxRequestBodySize
+	}
+	if cfg.MaxDecompressedBodySize > 0 {
+		d.maxDecompressedSize = cfg.MaxDecompressedBodySize
+	}
+	d.slabs = slab.NewPool(minSlabSize, maxInt(minSlabSize, d.maxDecompressedSize))
+
+	// The decoder is safe for concurrent use with DecodeAll, and fails the requests which
+	// would decompress beyond the max size before allocating them.
+	var err error
+	if d.zstd, err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(d.maxDecompressedSize))); err != nil {
+		level.Warn(util_log.Logger).Log("msg", "failed to create the zstd decoder, zstd push requests are rejected", "err", err)
+	}
+	return d
+}
+
+// decode reads and decodes the write request in the body of r, compressed with the content
+// encoding of the request. Without Content-Encoding header, the encoding is detected from
+// the body, defaulting to snappy as sent by Prometheus. The request fails if either the
+// compressed or the decompressed body is larger than the max size. The labels of the request
+// are interned once decoded if the label interning is enabled, else copied unless zero copy.
+// The decompressed body is released to the slabs once decoded, unless the labels reference it.
+func (d *decoder) decode(r *http.Request, req *cortexpb.PreallocWriteRequest) error {
+	if r.ContentLength > int64(d.maxSize) {
+		return bodyTooLargeError{size: int(r.ContentLength), limit: d.maxSize}
+	}
+
+	buf := bodyBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		bodyBuffers.Put(buf)
+	}()
+
+	if r.ContentLength > 0 {
+		buf.Grow(int(r.ContentLength) + bytes.MinRead)
+	}
+	// Read at most one byte more than the limit, to detect the larger bodies.
+	if _, err := buf.ReadFrom(io.LimitReader(r.Body, int64(d.maxSize)+1)); err != nil {
+		return err
+	}
+	if buf.Len() > d.maxSize {
+		return bodyTooLargeError{size: -1, limit: d.maxSize}
+	}
+
+	// With zero copy, the labels reference the body past the request, for as long as they're
+	// kept by the trackers, so it can't be reused. The interned labels never reference it, so
+	// zero copy doesn't apply with interning.
+	var slabs *slab.Pool
+	if d.interner != nil || !d.zeroCopy {
+		slabs = d.slabs
+	}
+
+	body, err := d.decompress(contentEncoding(r, buf.Bytes()), buf.Bytes(), slabs)
+	if err != nil {
+		return err
+	}
+	if slabs != nil {
+		defer slabs.Put(body)
+	}
+
+	// The labels are decoded by cortexpb as unsafe references to the body.
+	if err := req.Unmarshal(body); err != nil {
+		return err
+	}
+	switch {
+	case d.interner != nil:
+		forEachLabels(&req.WriteRequest, d.interner.Labels)
+	case !d.zeroCopy:
+		copyLabels(&req.WriteRequest)
+	}
+	return nil
+}
+
+// decompress returns the decompressed body, taken from the slabs if not nil. It's never the
+// input buffer, which is pooled.
+func (d *decoder) decompress(encoding string, compressed []byte, slabs *slab.Pool) ([]byte, error) {
+	alloc := func(n int) []byte { return make([]byte, n) }
+	if slabs != nil {
+		alloc = slabs.Get
+	}
+
+	switch encoding {
+	case encodingSnappy:
+		size, err := snappy.DecodedLen(compressed)
+		if err != nil {
+			return nil, err
+		}
+		if size > d.maxDecompressedSize {
+			return nil, bodyTooLargeError{decompressed: true, size: size, limit: d.maxDecompressedSize}
+		}
+		return snappy.Decode(alloc(size), compressed)
+
+	case encodingZstd:
+		if d.zstd == nil {
+			return nil, errUnsupportedEncoding
+		}
+		// The frames don't always carry their decompressed size, so the body is appended to a
+		// slab sized for a typical compression ratio, reallocated if too small.
+		var dst []byte
+		if slabs != nil {
+			dst = slabs.Get(minInt(zstdExpectedRatio*len(compressed), d.maxDecompressedSize))[:0]
+		}
+		body, err := d.zstd.DecodeAll(compressed, dst)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+			return nil, bodyTooLargeError{decompressed: true, size: -1, limit: d.maxDecompressedSize}
+		}
+		return body, err
+
+	case encodingIdentity:
+		if len(compressed) > d.maxDecompressedSize {
+			return nil, bodyTooLargeError{decompressed: true, size: len(compressed), limit: d.maxDecompressedSize}
+		}
+		body := alloc(len(compressed))
+		copy(body, compressed)
+		return body, nil
+	}
+	return nil, errUnsupportedEncoding
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// contentEncoding returns the content encoding of the request, detected from the body if the
+// request has no Content-Encoding header.
+func contentEncoding(r *http.Request, body []byte) string {
+	if enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc != "" {
+		return enc
+	}
+	if bytes.HasPrefix(body, zstdMagic) {
+		return encodingZstd
+	}
+	return encodingSnappy
+}
+
+// copyLabels copies the label names and values of the series and exemplars of the request
+// into a single string, so that they don't reference the request body anymore.
+func copyLabels(req *cortexpb.WriteRequest) {
+	size := 0
+	forEachLabels(req, func(lbls []cortexpb.LabelAdapter) {
+		for _, l := range lbls {
+			size += len(l.Name) + len(l.Value)
+		}
+	})
+
+	sb := strings.Builder{}
+	sb.Grow(size)
+	forEachLabels(req, func(lbls []cortexpb.LabelAdapter) {
+		for _, l := range lbls {
+			sb.WriteString(l.Name)
+			sb.WriteString(l.Value)
+		}
+	})
+
+	// The labels are pointed to the substrings of the copy, in the same order.
+	all, offset := sb.String(), 0
+	next := func(n int) string {
+		s := all[offset : offset+n]
+		offset += n
+		return s
+	}
+	forEachLabels(req, func(lbls []cortexpb.LabelAdapter) {
+		for i := range lbls {
+			lbls[i].Name = next(len(lbls[i].Name))
+			lbls[i].Value = next(len(lbls[i].Value))
+		}
+	})
+}
+
+func forEachLabels(req *cortexpb.WriteRequest, f func(lbls []cortexpb.LabelAdapter)) {
+	for _, ts := range req.Timeseries {
+		f(ts.Labels)
+		for _, e := range ts.Exemplars {
+			f(e.Labels)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package push
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+
+	"objectstorage/pkg/util/intern"
+)
+
+func TestCopyLabels(t *testing.T) {
+	req := &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+		Labels:    []cortexpb.LabelAdapter{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node"}},
+		Samples:   []cortexpb.Sample{{Value: 1, TimestampMs: 1}},
+		Exemplars: []cortexpb.Exemplar{{Labels: []cortexpb.LabelAdapter{{Name: "trace_id", Value: "abc"}}, Value: 1, TimestampMs: 1}},
+	}}}}
+	body, err := req.Marshal()
+	require.NoError(t, err)
+
+	decoded := &cortexpb.PreallocWriteRequest{}
+	require.NoError(t, decoded.Unmarshal(body))
+	copyLabels(&decoded.WriteRequest)
+
+	// The copied labels don't change if the body is reused.
+	for i := range body {
+		body[i] = 0
+	}
+	assert.Equal(t, req.Timeseries[0].Labels, decoded.Timeseries[0].Labels)
+	assert.Equal(t, req.Timeseries[0].Exemplars[0].Labels, decoded.Timeseries[0].Exemplars[0].Labels)
+}
+
+func TestDecoder_SlabsReusedOnceLabelsCopied(t *testing.T) {
+	zstdEncoder, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+
+	encode := map[string]func([]byte) []byte{
+		encodingSnappy:   func(b []byte) []byte { return snappy.Encode(nil, b) },
+		encodingZstd:     func(b []byte) []byte { return zstdEncoder.EncodeAll(b, nil) },
+		encodingIdentity: func(b []byte) []byte { return b },
+	}
+
+	for encoding, enc := range encode {
+		t.Run(encoding, func(t *testing.T) {
+			dec := newDecoder(HandlerConfig{ZeroCopyUnmarshal: false}, 1<<20)
+
+			decode := func(value string) *cortexpb.PreallocWriteRequest {
+				body, err := (&cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+					Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: value}},
+					Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}},
+				}}}}).Marshal()
+				require.NoError(t, err)
+
+				r := httptest.NewRequest(http.MethodPost, "/api/v1/push", bytes.NewReader(enc(body)))
+				r.Header.Set("Content-Encoding", encoding)
+				req := &cortexpb.PreallocWriteRequest{}
+				require.NoError(t, dec.decode(r, req))
+				return req
+			}
+
+			// The second request is decoded into the slab released by the first one.
+			first := decode("first")
+			second := decode("other")
+			assert.Equal(t, "first", first.Timeseries[0].Labels[0].Value)
+			assert.Equal(t, "other", second.Timeseries[0].Labels[0].Value)
+		})
+	}
+}
+
+func TestDecoder_SlabsReusedOnceLabelsInterned(t *testing.T) {
+	// Zero copy is ignored with interning, since the interned labels never reference the body.
+	dec := newDecoder(HandlerConfig{ZeroCopyUnmarshal: true}, 1<<20)
+	dec.interner = intern.NewTable(1<<20, nil)
+
+	decode := func(value string) *cortexpb.PreallocWriteRequest {
+		body, err := (&cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+			Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: value}},
+			Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}},
+		}}}}).Marshal()
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/push", bytes.NewReader(snappy.Encode(nil, body)))
+		r.Header.Set("Content-Encoding", encodingSnappy)
+		req := &cortexpb.PreallocWriteRequest{}
+		require.NoError(t, dec.decode(r, req))
+		return req
+	}
+
+	// The second request is decoded into the slab released by the first one.
+	first := decode("first")
+	second := decode("other")
+	assert.Equal(t, "first", first.Timeseries[0].Labels[0].Value)
+	assert.Equal(t, "other", second.Timeseries[0].Labels[0].Value)
+}
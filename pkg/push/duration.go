@@ -0,0 +1,37 @@
+package push
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+
+	"objectstorage/pkg/util/histogram"
+)
+
+// DurationMiddleware returns the middleware observing the time spent handling the push
+// requests, by outcome, as a native histogram with classic fallback buckets. It should be the
+// first middleware, to cover all the stages.
+func DurationMiddleware(histograms histogram.Config, reg prometheus.Registerer) Middleware {
+	duration := promauto.With(reg).NewHistogramVec(histograms.Opts(prometheus.HistogramOpts{
+		Name: "cortex_push_request_duration_seconds",
+		Help: "Time spent handling a push request, by outcome.",
+	}), []string{"status"})
+
+	return func(next Func) Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			start := time.Now()
+			resp, err := next(ctx, req)
+
+			status := "success"
+			if err != nil {
+				status = "failure"
+			}
+			duration.WithLabelValues(status).Observe(time.Since(start).Seconds())
+			return resp, err
+		}
+	}
+}
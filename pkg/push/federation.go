@@ -0,0 +1,199 @@
+package push
+
+import (
+	"context"
+	"flag"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+
+	"objectstorage/pkg/tenant"
+)
+
+var errFederationDisabled = errors.New("push requests targeting multiple tenants are not allowed because the write path tenant federation is disabled")
+
+// FederationConfig holds the configuration of the tenant federation on the write path.
+type FederationConfig struct {
+	Enabled           bool   `yaml:"enabled"`
+	TenantLabel       string `yaml:"tenant_label"`
+	RemoveTenantLabel bool   `yaml:"remove_tenant_label"`
+}
+
+// RegisterFlags registers the write path tenant federation flags.
+func (cfg *FederationConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "tenant-federation.write.enabled", false, "If enabled, push requests can target multiple tenants. When the X-Scope-OrgID header contains multiple pipe-separated tenants, each series is written to the tenant in its tenant label if configured, or to all tenants otherwise.")
+	f.StringVar(&cfg.TenantLabel, "tenant-federation.write.tenant-label", "", "Name of the series label used to route each series to a tenant. The tenant must be listed in the X-Scope-OrgID header. Empty to disable per-series routing.")
+	f.BoolVar(&cfg.RemoveTenantLabel, "tenant-federation.write.remove-tenant-label", true, "True to remove the tenant label from series before writing them.")
+}
+
+// Federation splits push requests targeting multiple tenants into one request per tenant.
+type Federation struct {
+	cfg FederationConfig
+
+	splitRequests prometheus.Counter
+}
+
+// NewFederation makes a new Federation.
+func NewFederation(cfg FederationConfig, reg prometheus.Registerer) *Federation {
+	return &Federation{
+		cfg: cfg,
+		splitRequests: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_push_federated_requests_total",
+			Help: "Total number of push requests split across multiple tenants.",
+		}),
+	}
+}
+
+// Middleware returns the push.Middleware splitting requests by tenant.
+func (f *Federation) Middleware() Middleware {
+	return func(next Func) Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			tenantIDs, err := tenant.TenantIDs(ctx)
+			if err != nil {
+				return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+			}
+
+			if !f.cfg.Enabled && len(tenantIDs) > 1 {
+				return nil, httpgrpc.Errorf(http.StatusBadRequest, errFederationDisabled.Error())
+			}
+			if !f.cfg.Enabled || (len(tenantIDs) == 1 && f.cfg.TenantLabel == "") {
+				return next(ctx, req)
+			}
+
+			byTenant, err := f.split(tenantIDs, req)
+			if err != nil {
+				return nil, err
+			}
+			if len(byTenant) > 1 {
+				f.splitRequests.Inc()
+			}
+
+			var firstErr error
+			for _, tenantID := range tenantIDs {
+				tenantReq, ok := byTenant[tenantID]
+				if !ok {
+					continue
+				}
+
+				if _, err := next(user.InjectOrgID(ctx, tenantID), tenantReq); err != nil && preferError(firstErr, err) {
+					firstErr = err
+				}
+			}
+
+			if firstErr != nil {
+				return nil, firstErr
+			}
+			return &cortexpb.WriteResponse{}, nil
+		}
+	}
+}
+
+// split returns the per-tenant requests. Without a tenant label, the whole request is
+// written to every tenant, each tenant after the first one getting a copy of the series since
+// the ingester returns the series of the request to the pools once appended. Otherwise each series is routed to the tenant in its label,
+// which must be one of the requested tenants; series without the label are accepted only
+// if a single tenant has been requested.
+func (f *Federation) split(tenantIDs []string, req *cortexpb.WriteRequest) (map[string]*cortexpb.WriteRequest, error) {
+	out := make(map[string]*cortexpb.WriteRequest, len(tenantIDs))
+	get := func(tenantID string) *cortexpb.WriteRequest {
+		r, ok := out[tenantID]
+		if !ok {
+			r = &cortexpb.WriteRequest{Source: req.Source, Metadata: req.Metadata, SkipLabelNameValidation: req.SkipLabelNameValidation}
+			out[tenantID] = r
+		}
+		return r
+	}
+
+	if f.cfg.TenantLabel == "" {
+		for i, tenantID := range tenantIDs {
+			r := get(tenantID)
+			if i == 0 {
+				r.Timeseries = req.Timeseries
+			} else {
+				r.Timeseries = copySeries(req.Timeseries)
+			}
+		}
+		return out, nil
+	}
+
+	allowed := make(map[string]struct{}, len(tenantIDs))
+	for _, tenantID := range tenantIDs {
+		allowed[tenantID] = struct{}{}
+	}
+
+	for _, ts := range req.Timeseries {
+		tenantID, idx := "", -1
+		for i, l := range ts.Labels {
+			if l.Name == f.cfg.TenantLabel {
+				tenantID, idx = l.Value, i
+				break
+			}
+		}
+
+		switch {
+		case idx < 0 && len(tenantIDs) == 1:
+			tenantID = tenantIDs[0]
+		case idx < 0:
+			return nil, httpgrpc.Errorf(http.StatusBadRequest, "series %s has no %s label but multiple tenants have been requested", cortexpb.FromLabelAdaptersToLabels(ts.Labels).String(), f.cfg.TenantLabel)
+		default:
+			if _, ok := allowed[tenantID]; !ok {
+				return nil, httpgrpc.Errorf(http.StatusBadRequest, "series %s targets tenant %s which is not listed in the %s header", cortexpb.FromLabelAdaptersToLabels(ts.Labels).String(), tenantID, user.OrgIDHeaderName)
+			}
+
+			if f.cfg.RemoveTenantLabel {
+				lbls := make([]cortexpb.LabelAdapter, 0, len(ts.Labels)-1)
+				lbls = append(lbls, ts.Labels[:idx]...)
+				lbls = append(lbls, ts.Labels[idx+1:]...)
+				ts = cortexpb.PreallocTimeseries{TimeSeries: &cortexpb.TimeSeries{Labels: lbls, Samples: ts.Samples, Exemplars: ts.Exemplars}}
+			}
+		}
+
+		r := get(tenantID)
+		r.Timeseries = append(r.Timeseries, ts)
+	}
+
+	return out, nil
+}
+
+// copySeries returns a copy of the series, taken from the cortexpb pools.
+func copySeries(in []cortexpb.PreallocTimeseries) []cortexpb.PreallocTimeseries {
+	out := cortexpb.PreallocTimeseriesSliceFromPool()
+	for _, ts := range in {
+		c := cortexpb.TimeseriesFromPool()
+		c.Labels = append(c.Labels, ts.Labels...)
+		c.Samples = append(c.Samples, ts.Samples...)
+		for _, e := range ts.Exemplars {
+			c.Exemplars = append(c.Exemplars, cortexpb.Exemplar{
+				Labels:      append([]cortexpb.LabelAdapter(nil), e.Labels...),
+				Value:       e.Value,
+				TimestampMs: e.TimestampMs,
+			})
+		}
+		out = append(out, cortexpb.PreallocTimeseries{TimeSeries: c})
+	}
+	return out
+}
+
+// preferError returns whether next should replace the current error. Server errors are
+// preferred over client errors, because they cause the client to retry.
+func preferError(current, next error) bool {
+	if current == nil {
+		return true
+	}
+
+	currentResp, ok := httpgrpc.HTTPResponseFromError(current)
+	if !ok {
+		return false
+	}
+	nextResp, ok := httpgrpc.HTTPResponseFromError(next)
+	if !ok {
+		return true
+	}
+	return currentResp.Code/100 == 4 && nextResp.Code/100 == 5
+}
@@ -0,0 +1,161 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+func TestFederation_Middleware(t *testing.T) {
+	series := func(lbls ...string) cortexpb.PreallocTimeseries {
+		ts := &cortexpb.TimeSeries{Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}}}
+		for i := 0; i < len(lbls); i += 2 {
+			ts.Labels = append(ts.Labels, cortexpb.LabelAdapter{Name: lbls[i], Value: lbls[i+1]})
+		}
+		return cortexpb.PreallocTimeseries{TimeSeries: ts}
+	}
+
+	tests := map[string]struct {
+		cfg          FederationConfig
+		orgID        string
+		series       []cortexpb.PreallocTimeseries
+		expected     map[string][]string
+		expectedCode int32
+	}{
+		"disabled with a single tenant": {
+			cfg:      FederationConfig{},
+			orgID:    "team-a",
+			series:   []cortexpb.PreallocTimeseries{series("__name__", "up")},
+			expected: map[string][]string{"team-a": {`{__name__="up"}`}},
+		},
+		"disabled with multiple tenants": {
+			cfg:          FederationConfig{},
+			orgID:        "team-a|team-b",
+			series:       []cortexpb.PreallocTimeseries{series("__name__", "up")},
+			expectedCode: http.StatusBadRequest,
+		},
+		"no tenant label fans out to all tenants": {
+			cfg:    FederationConfig{Enabled: true},
+			orgID:  "team-a|team-b",
+			series: []cortexpb.PreallocTimeseries{series("__name__", "up")},
+			expected: map[string][]string{
+				"team-a": {`{__name__="up"}`},
+				"team-b": {`{__name__="up"}`},
+			},
+		},
+		"tenant label routes series": {
+			cfg:   FederationConfig{Enabled: true, TenantLabel: "team", RemoveTenantLabel: true},
+			orgID: "team-a|team-b",
+			series: []cortexpb.PreallocTimeseries{
+				series("__name__", "up", "team", "team-a"),
+				series("__name__", "down", "team", "team-b"),
+				series("__name__", "up", "job", "x", "team", "team-b"),
+			},
+			expected: map[string][]string{
+				"team-a": {`{__name__="up"}`},
+				"team-b": {`{__name__="down"}`, `{__name__="up", job="x"}`},
+			},
+		},
+		"tenant label is kept if configured": {
+			cfg:      FederationConfig{Enabled: true, TenantLabel: "team"},
+			orgID:    "team-a|team-b",
+			series:   []cortexpb.PreallocTimeseries{series("__name__", "up", "team", "team-a")},
+			expected: map[string][]string{"team-a": {`{__name__="up", team="team-a"}`}},
+		},
+		"series without tenant label defaults to the single tenant": {
+			cfg:      FederationConfig{Enabled: true, TenantLabel: "team", RemoveTenantLabel: true},
+			orgID:    "team-a",
+			series:   []cortexpb.PreallocTimeseries{series("__name__", "up")},
+			expected: map[string][]string{"team-a": {`{__name__="up"}`}},
+		},
+		"series without tenant label and multiple tenants": {
+			cfg:          FederationConfig{Enabled: true, TenantLabel: "team"},
+			orgID:        "team-a|team-b",
+			series:       []cortexpb.PreallocTimeseries{series("__name__", "up")},
+			expectedCode: http.StatusBadRequest,
+		},
+		"series targeting a tenant not in the header": {
+			cfg:          FederationConfig{Enabled: true, TenantLabel: "team"},
+			orgID:        "team-a|team-b",
+			series:       []cortexpb.PreallocTimeseries{series("__name__", "up", "team", "team-c")},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			received := map[string][]string{}
+			f := NewFederation(tc.cfg, nil).Middleware()(func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				userID, err := user.ExtractOrgID(ctx)
+				require.NoError(t, err)
+				for _, ts := range req.Timeseries {
+					received[userID] = append(received[userID], cortexpb.FromLabelAdaptersToLabels(ts.Labels).String())
+				}
+				return &cortexpb.WriteResponse{}, nil
+			})
+
+			_, err := f(user.InjectOrgID(context.Background(), tc.orgID), &cortexpb.WriteRequest{Timeseries: tc.series})
+			if tc.expectedCode != 0 {
+				resp, ok := httpgrpc.HTTPResponseFromError(err)
+				require.True(t, ok)
+				assert.Equal(t, tc.expectedCode, resp.Code)
+				return
+			}
+
+			require.NoError(t, err)
+			for _, lbls := range received {
+				sort.Strings(lbls)
+			}
+			assert.Equal(t, tc.expected, received)
+		})
+	}
+}
+
+func TestFederation_Middleware_ShouldCopyTheSeriesFannedOut(t *testing.T) {
+	received := map[string][]string{}
+	f := NewFederation(FederationConfig{Enabled: true}, nil).Middleware()(func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		// The ingester returns the series to the pools once appended.
+		defer cortexpb.ReuseSlice(req.Timeseries)
+
+		userID, err := user.ExtractOrgID(ctx)
+		require.NoError(t, err)
+		for _, ts := range req.Timeseries {
+			require.Len(t, ts.Samples, 1)
+			received[userID] = append(received[userID], fmt.Sprintf("%s %v", cortexpb.FromLabelAdaptersToLabels(ts.Labels).String(), ts.Samples[0].Value))
+		}
+		return &cortexpb.WriteResponse{}, nil
+	})
+
+	req := &cortexpb.WriteRequest{Timeseries: cortexpb.PreallocTimeseriesSliceFromPool()}
+	for _, name := range []string{"up", "down"} {
+		ts := cortexpb.TimeseriesFromPool()
+		ts.Labels = append(ts.Labels, cortexpb.LabelAdapter{Name: "__name__", Value: name})
+		ts.Samples = append(ts.Samples, cortexpb.Sample{Value: 1, TimestampMs: 1})
+		req.Timeseries = append(req.Timeseries, cortexpb.PreallocTimeseries{TimeSeries: ts})
+	}
+
+	_, err := f(user.InjectOrgID(context.Background(), "team-a|team-b|team-c"), req)
+	require.NoError(t, err)
+
+	expected := []string{`{__name__="up"} 1`, `{__name__="down"} 1`}
+	assert.Equal(t, map[string][]string{"team-a": expected, "team-b": expected, "team-c": expected}, received)
+}
+
+func TestPreferError(t *testing.T) {
+	clientErr := httpgrpc.Errorf(http.StatusBadRequest, "bad")
+	serverErr := httpgrpc.Errorf(http.StatusInternalServerError, "failed")
+
+	assert.True(t, preferError(nil, clientErr))
+	assert.True(t, preferError(clientErr, serverErr))
+	assert.False(t, preferError(serverErr, clientErr))
+	assert.False(t, preferError(clientErr, clientErr))
+}
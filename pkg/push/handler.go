@@ -0,0 +1,82 @@
+package push
+
+import (
+	"net/http"
+
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/middleware"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util"
+	util_log "github.com/cortexproject/cortex/pkg/util/log"
+
+	"objectstorage/pkg/util/logging"
+)
+
+// Handler is a http.Handler which accepts Prometheus remote write requests, compressed with
+// snappy, zstd or not at all, and passes them down to the input push Func.
+func Handler(cfg HandlerConfig, maxRecvMsgSize int, sourceIPs *middleware.SourceIPExtractor, push Func) http.Handler {
+	dec := newDecoder(cfg, maxRecvMsgSize)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.WithContext(ctx, util_log.Logger)
+		if sourceIPs != nil {
+			source := sourceIPs.Get(r)
+			if source != "" {
+				ctx = util.AddSourceIPsToOutgoingContext(ctx, source)
+				logger = util_log.WithSourceIPs(source, logger)
+			}
+		}
+
+		var req cortexpb.PreallocWriteRequest
+		if err := dec.decode(r, &req); err != nil {
+			level.Error(logger).Log("err", err.Error())
+			code := http.StatusBadRequest
+			if errors.Is(err, errUnsupportedEncoding) {
+				code = http.StatusUnsupportedMediaType
+			} else if errors.As(err, &bodyTooLargeError{}) {
+				code = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+
+		req.SkipLabelNameValidation = false
+		if req.Source == 0 {
+			req.Source = cortexpb.API
+		}
+
+		if _, err := push(ctx, &req.WriteRequest); err != nil {
+			resp, ok := httpgrpc.HTTPResponseFromError(err)
+			if !ok {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if resp.GetCode()/100 == 5 {
+				level.Error(logger).Log("msg", "push error", "err", err)
+			}
+			writeErrorResponse(w, resp)
+		}
+	})
+}
+
+// writeErrorResponse writes the error response. Responses carrying their own headers, like
+// structured JSON errors, are written as is, while the others are written as plain text.
+func writeErrorResponse(w http.ResponseWriter, resp *httpgrpc.HTTPResponse) {
+	if len(resp.Headers) == 0 {
+		http.Error(w, string(resp.Body), int(resp.Code))
+		return
+	}
+
+	for _, h := range resp.Headers {
+		for _, v := range h.Values {
+			w.Header().Add(h.Key, v)
+		}
+	}
+	w.WriteHeader(int(resp.Code))
+	if _, err := w.Write(resp.Body); err != nil {
+		level.Warn(util_log.Logger).Log("msg", "failed to write push error response", "err", err)
+	}
+}
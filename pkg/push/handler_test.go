@@ -0,0 +1,167 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+func TestHandler(t *testing.T) {
+	req := &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+		Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: "up"}},
+		Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}},
+	}}}}
+	body, err := req.Marshal()
+	require.NoError(t, err)
+
+	largeReq := &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+		Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: strings.Repeat("a", 2<<20)}},
+		Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}},
+	}}}}
+	largeBody, err := largeReq.Marshal()
+	require.NoError(t, err)
+
+	zstdEncoder, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	zstdBody := zstdEncoder.EncodeAll(body, nil)
+
+	tests := map[string]struct {
+		cfg                 HandlerConfig
+		body                []byte
+		contentEncoding     string
+		pushErr             error
+		expectedCode        int
+		expectedContentType string
+		expectedBody        string
+		expectedError       string
+	}{
+		"valid request": {
+			body:         snappy.Encode(nil, body),
+			expectedCode: http.StatusOK,
+		},
+		"snappy encoded request": {
+			body:            snappy.Encode(nil, body),
+			contentEncoding: "snappy",
+			expectedCode:    http.StatusOK,
+		},
+		"zstd encoded request": {
+			body:            zstdBody,
+			contentEncoding: "zstd",
+			expectedCode:    http.StatusOK,
+		},
+		"zstd request detected without content encoding": {
+			body:         zstdBody,
+			expectedCode: http.StatusOK,
+		},
+		"identity encoded request": {
+			body:            body,
+			contentEncoding: "identity",
+			expectedCode:    http.StatusOK,
+		},
+		"unsupported content encoding": {
+			body:            body,
+			contentEncoding: "gzip",
+			expectedCode:    http.StatusUnsupportedMediaType,
+		},
+		"zstd request larger than the limit once decompressed": {
+			body:            zstdEncoder.EncodeAll(make([]byte, 2<<20), nil),
+			contentEncoding: "zstd",
+			expectedCode:    http.StatusRequestEntityTooLarge,
+			expectedError:   "decompressed request body larger than the limit of 1048576 bytes",
+		},
+		"snappy request larger than the decompressed limit": {
+			cfg:           HandlerConfig{MaxDecompressedBodySize: 1 << 10},
+			body:          snappy.Encode(nil, make([]byte, 2<<10)),
+			expectedCode:  http.StatusRequestEntityTooLarge,
+			expectedError: "decompressed request body of 2048 bytes larger than the limit of 1024 bytes",
+		},
+		"identity request larger than the decompressed limit": {
+			cfg:             HandlerConfig{MaxRequestBodySize: 4 << 10, MaxDecompressedBodySize: 1 << 10},
+			body:            make([]byte, 2<<10),
+			contentEncoding: "identity",
+			expectedCode:    http.StatusRequestEntityTooLarge,
+			expectedError:   "decompressed request body of 2048 bytes larger than the limit of 1024 bytes",
+		},
+		"request within the decompressed limit configured above the default": {
+			cfg:          HandlerConfig{MaxDecompressedBodySize: 4 << 20},
+			body:         snappy.Encode(nil, largeBody),
+			expectedCode: http.StatusOK,
+		},
+		"invalid body": {
+			body:         []byte("invalid"),
+			expectedCode: http.StatusBadRequest,
+		},
+		"body larger than the limit": {
+			body:          make([]byte, 2<<20),
+			expectedCode:  http.StatusRequestEntityTooLarge,
+			expectedError: "request body of 2097152 bytes larger than the limit of 1048576 bytes",
+		},
+		"body larger than the configured limit": {
+			cfg:           HandlerConfig{MaxRequestBodySize: 1 << 10},
+			body:          make([]byte, 2<<10),
+			expectedCode:  http.StatusRequestEntityTooLarge,
+			expectedError: "request body of 2048 bytes larger than the limit of 1024 bytes",
+		},
+		"push returns a client error": {
+			body:         snappy.Encode(nil, body),
+			pushErr:      httpgrpc.Errorf(http.StatusTooManyRequests, "rate limited"),
+			expectedCode: http.StatusTooManyRequests,
+		},
+		"push returns a structured error": {
+			body: snappy.Encode(nil, body),
+			pushErr: httpgrpc.ErrorFromHTTPResponse(&httpgrpc.HTTPResponse{
+				Code:    http.StatusTooManyRequests,
+				Headers: []*httpgrpc.Header{{Key: "Content-Type", Values: []string{"application/json"}}},
+				Body:    []byte(`{"status":"error"}`),
+			}),
+			expectedCode:        http.StatusTooManyRequests,
+			expectedContentType: "application/json",
+			expectedBody:        `{"status":"error"}`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var received *cortexpb.WriteRequest
+			cfg := tc.cfg
+			cfg.ZeroCopyUnmarshal = true
+			h := Handler(cfg, 1<<20, nil, func(_ context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				received = req
+				return &cortexpb.WriteResponse{}, tc.pushErr
+			})
+
+			httpReq := httptest.NewRequest(http.MethodPost, "/api/v1/push", bytes.NewReader(tc.body))
+			if tc.contentEncoding != "" {
+				httpReq.Header.Set("Content-Encoding", tc.contentEncoding)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httpReq)
+			assert.Equal(t, tc.expectedCode, rec.Code)
+			if tc.expectedContentType != "" {
+				assert.Equal(t, tc.expectedContentType, rec.Header().Get("Content-Type"))
+				assert.Equal(t, tc.expectedBody, rec.Body.String())
+			}
+			if tc.expectedError != "" {
+				assert.Contains(t, rec.Body.String(), tc.expectedError)
+			}
+
+			if tc.expectedCode == http.StatusOK {
+				require.NotNil(t, received)
+				assert.Equal(t, cortexpb.API, received.Source)
+				assert.Len(t, received.Timeseries, 1)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package push
+
+import (
+	"context"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+// Func is the signature of a function handling a write request on the push path.
+type Func func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error)
+
+// Middleware wraps a Func, typically to validate or transform the request before
+// passing it down to the next Func.
+type Middleware func(next Func) Func
+
+// Chain wraps f with the input middlewares. The first middleware is the outermost
+// one, so it's the first to see the request.
+func Chain(f Func, middlewares ...Middleware) Func {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		f = middlewares[i](f)
+	}
+	return f
+}
@@ -0,0 +1,80 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+
+	record := func(name string) Middleware {
+		return func(next Func) Func {
+			return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				calls = append(calls, name)
+				return next(ctx, req)
+			}
+		}
+	}
+
+	f := Chain(func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		calls = append(calls, "push")
+		return &cortexpb.WriteResponse{}, nil
+	}, record("first"), record("second"))
+
+	_, err := f(context.Background(), &cortexpb.WriteRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "second", "push"}, calls)
+}
+
+func TestTraced(t *testing.T) {
+	var calls []string
+
+	f := Chain(func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		calls = append(calls, "push")
+		return nil, errors.New("failed")
+	}, Traced("first", func(next Func) Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			calls = append(calls, "first")
+			return next(ctx, req)
+		}
+	}))
+
+	_, err := TracedFunc("push", f)(context.Background(), &cortexpb.WriteRequest{})
+	require.EqualError(t, err, "failed")
+	assert.Equal(t, []string{"first", "push"}, calls)
+}
+
+func TestSlowRequestLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	target := TracedFunc("ingester", func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		time.Sleep(20 * time.Millisecond)
+		return &cortexpb.WriteResponse{}, nil
+	})
+	f := Chain(target,
+		SlowRequestLogger(10*time.Millisecond, log.NewLogfmtLogger(buf)),
+		Traced("limits", func(next Func) Func { return next }))
+
+	req := &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+		Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: "up"}},
+		Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}, {Value: 1, TimestampMs: 2}},
+	}}}}
+	_, err := f(user.InjectOrgID(context.Background(), "user-1"), req)
+	require.NoError(t, err)
+
+	for _, expected := range []string{"level=warn", `msg="slow push request"`, "tenant=user-1", "series=1", "samples=2", "stages=\"limits="} {
+		assert.Contains(t, buf.String(), expected)
+	}
+	assert.Contains(t, buf.String(), " ingester=")
+}
@@ -0,0 +1,83 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+
+	"objectstorage/pkg/util/logging"
+)
+
+type stageTimingsKey struct{}
+
+// stageTimings records the time spent in each traced stage of a push request, in the order
+// the stages are entered. Each stage duration includes the one of the next stages.
+type stageTimings struct {
+	mtx    sync.Mutex
+	stages []string
+	times  []time.Duration
+}
+
+func (t *stageTimings) record(stage string, d time.Duration) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.stages = append(t.stages, stage)
+	t.times = append(t.times, d)
+}
+
+func (t *stageTimings) String() string {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	// The stages return in the reverse order they're entered.
+	parts := make([]string, 0, len(t.stages))
+	for i := len(t.stages) - 1; i >= 0; i-- {
+		parts = append(parts, fmt.Sprintf("%s=%s", t.stages[i], t.times[i]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// recordStage records the duration of the stage, if the request timings are recorded.
+func recordStage(ctx context.Context, stage string, d time.Duration) {
+	if t, ok := ctx.Value(stageTimingsKey{}).(*stageTimings); ok {
+		t.record(stage, d)
+	}
+}
+
+// SlowRequestLogger returns the middleware logging the requests slower than the threshold,
+// with their tenant, size, number of series and samples, and the time spent in each traced
+// stage. It should be the first middleware, to see the request before any stage changes it.
+func SlowRequestLogger(threshold time.Duration, logger log.Logger) Middleware {
+	return func(next Func) Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			timings := &stageTimings{}
+			ctx = context.WithValue(ctx, stageTimingsKey{}, timings)
+
+			series := len(req.Timeseries)
+			samples := 0
+			for _, ts := range req.Timeseries {
+				samples += len(ts.Samples)
+			}
+			size := req.Size()
+
+			start := time.Now()
+			resp, err := next(ctx, req)
+			if elapsed := time.Since(start); elapsed >= threshold {
+				kv := []interface{}{"msg", "slow push request", "duration", elapsed, "request_bytes", size, "series", series, "samples", samples, "stages", timings.String()}
+				if err != nil {
+					kv = append(kv, "err", err)
+				}
+				level.Warn(logging.WithContext(ctx, logger)).Log(kv...)
+			}
+			return resp, err
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package push
+
+import (
+	"context"
+	"time"
+
+	"github.com/weaveworks/common/user"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+
+	"objectstorage/pkg/util/tracing"
+)
+
+// Traced wraps the middleware in a span named after the input stage of the push path. The
+// span covers the next stages too, which are traced as its children, so that the time spent
+// in each stage is the span duration minus the one of its child. The duration is also
+// recorded for the slow requests log.
+func Traced(stage string, mw Middleware) Middleware {
+	return func(next Func) Func {
+		f := mw(next)
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			attrs := []attribute.KeyValue{attribute.Int("series", len(req.Timeseries))}
+			if userID, err := user.ExtractOrgID(ctx); err == nil {
+				attrs = append(attrs, attribute.String("tenant", userID))
+			}
+
+			start := time.Now()
+			ctx, span := tracing.StartSpan(ctx, "push."+stage, attrs...)
+			resp, err := f(ctx, req)
+			tracing.EndSpan(span, err)
+			recordStage(ctx, stage, time.Since(start))
+			return resp, err
+		}
+	}
+}
+
+// TracedFunc wraps f in a span named after the input stage of the push path.
+func TracedFunc(stage string, f Func) Func {
+	return Traced(stage, func(next Func) Func { return next })(f)
+}
@@ -0,0 +1,351 @@
+package querier
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+
+	"objectstorage/pkg/storage/bucket"
+	"objectstorage/pkg/storage/tsdb/bucketindex"
+	"objectstorage/pkg/tenant"
+)
+
+// BlocksQueryable queries the blocks of the tenant in the bucket. The blocks overlapping the
+// queried time range are downloaded on first use and kept on disk until deleted from the
+// bucket. They're kept open too, unless evicted to keep the size of the open blocks under the
+// limit. If the chunks are read from the bucket, only the index of the blocks is downloaded.
+type BlocksQueryable struct {
+	dir              string
+	syncInterval     time.Duration
+	maxStalePeriod   time.Duration
+	bkt              objstore.Bucket
+	chunksFromBucket bool
+	logger           log.Logger
+
+	mtx     sync.Mutex
+	tenants map[string]*tenantBlocks
+	open    *loadedBlocks
+
+	syncs       prometheus.Counter
+	syncsFailed prometheus.Counter
+	downloads   prometheus.Counter
+	loaded      prometheus.Gauge
+	evictions   prometheus.Counter
+
+	// tieredDownloads are the downloads of the blocks transitioned to a colder storage class,
+	// slower and more expensive to retrieve.
+	tieredDownloads *prometheus.CounterVec
+}
+
+type tenantBlocks struct {
+	// mtx serializes the syncs and downloads of the tenant.
+	mtx      sync.Mutex
+	index    *bucketindex.Index
+	syncedAt time.Time
+}
+
+// NewBlocksQueryable makes a new BlocksQueryable. The blocks are read from the bucket index
+// of the tenant if updated within the max stale period, else listed from the bucket. The
+// chunks are read by range from the bucket if the chunks cache is configured, the bucket
+// being expected to cache them.
+func NewBlocksQueryable(cfg Config, bkt objstore.Bucket, logger log.Logger, reg prometheus.Registerer) *BlocksQueryable {
+	b := &BlocksQueryable{
+		dir:              cfg.BlocksDir,
+		syncInterval:     cfg.BlocksSyncInterval,
+		maxStalePeriod:   cfg.BucketIndexMaxStalePeriod,
+		bkt:              bkt,
+		chunksFromBucket: cfg.ChunksCache.Backend != "",
+		logger:           logger,
+		tenants:          map[string]*tenantBlocks{},
+		open:             newLoadedBlocks(cfg.MaxLoadedBlocksBytes),
+		syncs: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_querier_blocks_syncs_total",
+			Help: "Total number of syncs of the bucket blocks of a tenant.",
+		}),
+		syncsFailed: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_querier_blocks_syncs_failed_total",
+			Help: "Total number of syncs of the bucket blocks of a tenant failed.",
+		}),
+		downloads: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_querier_blocks_downloads_total",
+			Help: "Total number of bucket blocks downloaded.",
+		}),
+		loaded: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_querier_blocks_loaded",
+			Help: "Number of bucket blocks open.",
+		}),
+		evictions: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_querier_blocks_evictions_total",
+			Help: "Total number of bucket blocks closed to keep the size of the open blocks under the limit. They're open again on the next query.",
+		}),
+		tieredDownloads: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_querier_tiered_blocks_downloads_total",
+			Help: "Total number of bucket blocks downloaded from a colder storage class, with a higher retrieval latency and cost, by storage class.",
+		}, []string{"storage_class"}),
+	}
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "cortex_querier_blocks_loaded_bytes",
+		Help: "Size in bytes of the index of the bucket blocks open, mmapped from disk.",
+	}, func() float64 { return float64(b.open.totalSize()) })
+	return b
+}
+
+// Querier implements storage.Queryable.
+func (b *BlocksQueryable) Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+	userID, err := tenant.TenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	userBkt := bucket.NewUserBucketClient(userID, b.bkt, nil)
+	newQuerier := func(blk *tsdb.Block) (storage.Querier, error) {
+		var r tsdb.BlockReader = blk
+		if b.chunksFromBucket {
+			r = &bucketBlock{Block: blk, chunks: newBucketChunkReader(ctx, userBkt, blk.Meta().ULID)}
+		}
+
+		q, err := tsdb.NewBlockQuerier(r, mint, maxt)
+		return q, errors.Wrapf(err, "open querier of block %s", blk.Meta().ULID)
+	}
+
+	queriers, err := b.queriersWithin(ctx, userID, userBkt, mint, maxt, newQuerier)
+	if err != nil {
+		for _, q := range queriers {
+			q.Close()
+		}
+		return nil, err
+	}
+	return storage.NewMergeQuerier(queriers, nil, storage.ChainedSeriesMerge), nil
+}
+
+// queriersWithin returns the queriers of the blocks of the tenant overlapping the time range,
+// opening the blocks not open yet. The queriers made are returned on error too, to be closed.
+func (b *BlocksQueryable) queriersWithin(ctx context.Context, userID string, userBkt objstore.Bucket, mint, maxt int64, newQuerier func(*tsdb.Block) (storage.Querier, error)) ([]storage.Querier, error) {
+	tb, err := b.syncedTenant(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer tb.mtx.Unlock()
+
+	deleted := map[ulid.ULID]struct{}{}
+	for _, m := range tb.index.BlockDeletionMarks {
+		deleted[m.ID] = struct{}{}
+	}
+	tiered := map[ulid.ULID]string{}
+	for _, m := range tb.index.BlockTieringMarks {
+		tiered[m.ID] = m.StorageClass
+	}
+
+	var out []storage.Querier
+	for _, m := range tb.index.Blocks {
+		if _, ok := deleted[m.ID]; ok || !m.Within(mint, maxt) {
+			continue
+		}
+
+		q, ok, err := b.open.querier(userID, m.ID, newQuerier)
+		if !ok {
+			var blk *tsdb.Block
+			if blk, err = b.openBlock(ctx, userBkt, userID, m.ID, tiered[m.ID]); err != nil {
+				return out, err
+			}
+
+			var evicted []*tsdb.Block
+			q, evicted, err = b.open.add(userID, blk, newQuerier)
+			b.evict(evicted)
+		}
+		if err != nil {
+			return out, err
+		}
+		out = append(out, q)
+	}
+	return out, nil
+}
+
+// Tombstones returns the tombstones of the tenant, tracked by its bucket index.
+func (b *BlocksQueryable) Tombstones(ctx context.Context, userID string) (bucketindex.Tombstones, error) {
+	tb, err := b.syncedTenant(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer tb.mtx.Unlock()
+	return tb.index.Tombstones, nil
+}
+
+// syncedTenant returns the locked blocks of the tenant, synced if not synced within the sync
+// interval. The caller must unlock them.
+func (b *BlocksQueryable) syncedTenant(ctx context.Context, userID string) (*tenantBlocks, error) {
+	b.mtx.Lock()
+	tb, ok := b.tenants[userID]
+	if !ok {
+		tb = &tenantBlocks{}
+		b.tenants[userID] = tb
+	}
+	b.mtx.Unlock()
+
+	tb.mtx.Lock()
+	if time.Since(tb.syncedAt) >= b.syncInterval {
+		if err := b.sync(ctx, userID, tb); err != nil {
+			tb.mtx.Unlock()
+			return nil, err
+		}
+	}
+	return tb, nil
+}
+
+// evict closes the blocks evicted in the background, the blocks being closed once the queries
+// reading them complete.
+func (b *BlocksQueryable) evict(blocks []*tsdb.Block) {
+	for _, blk := range blocks {
+		b.evictions.Inc()
+		go b.closeBlock(blk)
+	}
+}
+
+// sync refreshes the blocks index of the tenant, and closes the blocks deleted from the
+// bucket.
+func (b *BlocksQueryable) sync(ctx context.Context, userID string, tb *tenantBlocks) error {
+	b.syncs.Inc()
+
+	idx, err := b.readIndex(ctx, userID, tb.index)
+	if err != nil {
+		b.syncsFailed.Inc()
+		return errors.Wrapf(err, "sync blocks of tenant %s", userID)
+	}
+	tb.index = idx
+	tb.syncedAt = time.Now()
+
+	current := map[ulid.ULID]struct{}{}
+	for _, m := range idx.Blocks {
+		current[m.ID] = struct{}{}
+	}
+	for _, m := range idx.BlockDeletionMarks {
+		delete(current, m.ID)
+	}
+
+	for _, id := range b.open.ids(userID) {
+		if _, ok := current[id]; ok {
+			continue
+		}
+		if blk := b.open.remove(userID, id); blk != nil {
+			b.closeBlock(blk)
+		}
+		if err := os.RemoveAll(filepath.Join(b.dir, userID, id.String())); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to remove the deleted block", "user", userID, "block", id, "err", err)
+		}
+	}
+	return nil
+}
+
+// readIndex returns the bucket index of the tenant if fresh enough, else updates the previous
+// one by listing the bucket.
+func (b *BlocksQueryable) readIndex(ctx context.Context, userID string, old *bucketindex.Index) (*bucketindex.Index, error) {
+	if b.maxStalePeriod > 0 {
+		idx, err := bucketindex.ReadIndex(ctx, b.bkt, userID, nil, b.logger)
+		switch {
+		case err == nil && time.Since(idx.GetUpdatedAt()) <= b.maxStalePeriod:
+			return idx, nil
+		case err == nil:
+			level.Warn(b.logger).Log("msg", "the bucket index is stale, listing the bucket", "user", userID, "updated_at", idx.GetUpdatedAt())
+		case !errors.Is(err, bucketindex.ErrIndexNotFound):
+			level.Warn(b.logger).Log("msg", "failed to read the bucket index, listing the bucket", "user", userID, "err", err)
+		}
+	}
+
+	idx, _, _, err := bucketindex.NewUpdater(b.bkt, userID, nil, b.logger).UpdateIndex(ctx, old)
+	return idx, err
+}
+
+// openBlock opens the block, downloading it first if not already on disk. The storage class
+// is the one the block has been transitioned to, empty if not transitioned.
+func (b *BlocksQueryable) openBlock(ctx context.Context, userBkt objstore.Bucket, userID string, id ulid.ULID, storageClass string) (*tsdb.Block, error) {
+	dir := filepath.Join(b.dir, userID, id.String())
+
+	if !b.downloaded(dir) {
+		// The block is downloaded to a temporary directory and then renamed, so that a
+		// partially downloaded block is never opened.
+		tmp := dir + ".download"
+		if err := os.RemoveAll(tmp); err != nil {
+			return nil, err
+		}
+		if err := b.download(ctx, userBkt, id, tmp); err != nil {
+			return nil, errors.Wrapf(err, "download block %s", id)
+		}
+		// The block may have been downloaded without its chunks before.
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(tmp, dir); err != nil {
+			return nil, err
+		}
+		b.downloads.Inc()
+		if storageClass != "" {
+			b.tieredDownloads.WithLabelValues(storageClass).Inc()
+		}
+	}
+
+	blk, err := tsdb.OpenBlock(b.logger, dir, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open block %s", id)
+	}
+	b.loaded.Inc()
+	return blk, nil
+}
+
+// downloaded returns whether the block is on disk, with its chunks unless they're read from
+// the bucket.
+func (b *BlocksQueryable) downloaded(dir string) bool {
+	if b.chunksFromBucket {
+		_, err := os.Stat(dir)
+		return err == nil
+	}
+	segments, err := os.ReadDir(filepath.Join(dir, block.ChunksDirname))
+	return err == nil && len(segments) > 0
+}
+
+// download downloads the block to the directory, without its chunks if they're read from
+// the bucket, in which case the chunks directory is left empty.
+func (b *BlocksQueryable) download(ctx context.Context, userBkt objstore.Bucket, id ulid.ULID, dst string) error {
+	if !b.chunksFromBucket {
+		return block.Download(ctx, b.logger, userBkt, id, dst)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dst, block.ChunksDirname), os.ModePerm); err != nil {
+		return err
+	}
+	for _, name := range []string{metadata.MetaFilename, block.IndexFilename} {
+		if err := objstore.DownloadFile(ctx, b.logger, userBkt, path.Join(id.String(), name), filepath.Join(dst, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BlocksQueryable) closeBlock(blk *tsdb.Block) {
+	if err := blk.Close(); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to close block", "block", blk.Meta().ULID, "err", err)
+	}
+	b.loaded.Dec()
+}
+
+// Close closes all the open blocks. The downloaded blocks are kept on disk.
+func (b *BlocksQueryable) Close() error {
+	for _, blk := range b.open.removeAll() {
+		b.closeBlock(blk)
+	}
+	return nil
+}
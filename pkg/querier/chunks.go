@@ -0,0 +1,94 @@
+package querier
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"path"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// bucketBlock is a block whose index is on disk and whose chunks are read from the bucket,
+// so that the chunk ranges read by the queries are cached by the chunks cache in front of
+// the bucket instead of the whole block being downloaded.
+type bucketBlock struct {
+	*tsdb.Block
+	chunks *bucketChunkReader
+}
+
+// Chunks implements tsdb.BlockReader.
+func (b *bucketBlock) Chunks() (tsdb.ChunkReader, error) {
+	return b.chunks, nil
+}
+
+// bucketChunkReader reads the chunks of a block from its segment files in the bucket. The
+// chunk length is read first, then the chunk itself, both ranges being usually served by the
+// same cached subrange.
+type bucketChunkReader struct {
+	ctx     context.Context
+	userBkt objstore.BucketReader
+	id      ulid.ULID
+	pool    chunkenc.Pool
+}
+
+func newBucketChunkReader(ctx context.Context, userBkt objstore.BucketReader, id ulid.ULID) *bucketChunkReader {
+	return &bucketChunkReader{ctx: ctx, userBkt: userBkt, id: id, pool: chunkenc.NewPool()}
+}
+
+// Chunk implements tsdb.ChunkReader.
+func (r *bucketChunkReader) Chunk(meta chunks.Meta) (chunkenc.Chunk, error) {
+	seq, off := chunks.BlockChunkRef(meta.Ref).Unpack()
+	// The segment files are numbered from 1.
+	name := path.Join(r.id.String(), block.ChunksDirname, fmt.Sprintf("%06d", seq+1))
+
+	head, err := r.readRange(name, int64(off), chunks.MaxChunkLengthFieldSize)
+	if err != nil {
+		return nil, err
+	}
+	dataLen, n := binary.Uvarint(head)
+	if n <= 0 {
+		return nil, errors.Errorf("read length of chunk %d of segment %s failed with %d", off, name, n)
+	}
+
+	// The chunk is made of its encoding, data and CRC32.
+	chk, err := r.readRange(name, int64(off+n), chunks.ChunkEncodingSize+int64(dataLen)+crc32.Size)
+	if err != nil {
+		return nil, err
+	}
+	if len(chk) != chunks.ChunkEncodingSize+int(dataLen)+crc32.Size {
+		return nil, errors.Errorf("segment %s doesn't include enough bytes to read chunk %d", name, off)
+	}
+
+	dataEnd := len(chk) - crc32.Size
+	if binary.BigEndian.Uint32(chk[dataEnd:]) != crc32.Checksum(chk[:dataEnd], castagnoliTable) {
+		return nil, errors.Errorf("checksum mismatch of chunk %d of segment %s", off, name)
+	}
+	return r.pool.Get(chunkenc.Encoding(chk[0]), chk[chunks.ChunkEncodingSize:dataEnd])
+}
+
+func (r *bucketChunkReader) readRange(name string, off, length int64) ([]byte, error) {
+	rc, err := r.userBkt.GetRange(r.ctx, name, off, length)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read range of %s", name)
+	}
+	defer rc.Close()
+
+	b, err := io.ReadAll(rc)
+	return b, errors.Wrapf(err, "read range of %s", name)
+}
+
+// Close implements tsdb.ChunkReader.
+func (r *bucketChunkReader) Close() error {
+	return nil
+}
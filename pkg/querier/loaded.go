@@ -0,0 +1,157 @@
+package querier
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/thanos/pkg/block"
+)
+
+// loadedBlocks are the open blocks of all the tenants, whose index is mmapped. The least
+// recently queried blocks are evicted once the size of the index of the open blocks exceeds
+// maxSize, so that the number of blocks on disk isn't bounded by the memory. The chunks are
+// not accounted for, since only the ones of the queried series are read. A block is queried while
+// the lock is held, so that it's never evicted between being looked up and being queried,
+// the eviction then waiting for the queries to complete to close it.
+type loadedBlocks struct {
+	maxSize int64
+
+	mtx    sync.Mutex
+	lru    *list.List // Of *loadedBlock, the most recently queried first.
+	blocks map[string]map[ulid.ULID]*list.Element
+	size   int64
+}
+
+type loadedBlock struct {
+	userID string
+	blk    *tsdb.Block
+	size   int64
+}
+
+// newLoadedBlocks makes new loadedBlocks. The blocks are never evicted if maxSize is 0.
+func newLoadedBlocks(maxSize int64) *loadedBlocks {
+	return &loadedBlocks{
+		maxSize: maxSize,
+		lru:     list.New(),
+		blocks:  map[string]map[ulid.ULID]*list.Element{},
+	}
+}
+
+// querier returns the querier of the block made by newQuerier, and false if the block isn't
+// open.
+func (l *loadedBlocks) querier(userID string, id ulid.ULID, newQuerier func(*tsdb.Block) (storage.Querier, error)) (storage.Querier, bool, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	e, ok := l.blocks[userID][id]
+	if !ok {
+		return nil, false, nil
+	}
+	l.lru.MoveToFront(e)
+	q, err := newQuerier(e.Value.(*loadedBlock).blk)
+	return q, true, err
+}
+
+// add adds the open block, and returns the querier of the block made by newQuerier, along
+// with the blocks evicted to make room for it, to be closed by the caller.
+func (l *loadedBlocks) add(userID string, blk *tsdb.Block, newQuerier func(*tsdb.Block) (storage.Querier, error)) (storage.Querier, []*tsdb.Block, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.blocks[userID] == nil {
+		l.blocks[userID] = map[ulid.ULID]*list.Element{}
+	}
+	size := indexSize(blk)
+	l.blocks[userID][blk.Meta().ULID] = l.lru.PushFront(&loadedBlock{userID: userID, blk: blk, size: size})
+	l.size += size
+
+	q, err := newQuerier(blk)
+	return q, l.evict(), err
+}
+
+// evict removes the least recently queried blocks until the size of the open blocks is below
+// the max size, keeping the most recently queried one anyway.
+func (l *loadedBlocks) evict() []*tsdb.Block {
+	if l.maxSize <= 0 {
+		return nil
+	}
+
+	var evicted []*tsdb.Block
+	for l.size > l.maxSize && l.lru.Len() > 1 {
+		lb := l.lru.Back().Value.(*loadedBlock)
+		l.removeLocked(lb.userID, lb.blk.Meta().ULID)
+		evicted = append(evicted, lb.blk)
+	}
+	return evicted
+}
+
+// remove removes the block of the tenant, and returns it if it was open.
+func (l *loadedBlocks) remove(userID string, id ulid.ULID) *tsdb.Block {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.removeLocked(userID, id)
+}
+
+func (l *loadedBlocks) removeLocked(userID string, id ulid.ULID) *tsdb.Block {
+	e, ok := l.blocks[userID][id]
+	if !ok {
+		return nil
+	}
+
+	lb := l.lru.Remove(e).(*loadedBlock)
+	delete(l.blocks[userID], id)
+	if len(l.blocks[userID]) == 0 {
+		delete(l.blocks, userID)
+	}
+	l.size -= lb.size
+	return lb.blk
+}
+
+// ids returns the IDs of the open blocks of the tenant.
+func (l *loadedBlocks) ids(userID string) []ulid.ULID {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	out := make([]ulid.ULID, 0, len(l.blocks[userID]))
+	for id := range l.blocks[userID] {
+		out = append(out, id)
+	}
+	return out
+}
+
+// removeAll removes all the blocks, and returns them.
+func (l *loadedBlocks) removeAll() []*tsdb.Block {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	out := make([]*tsdb.Block, 0, l.lru.Len())
+	for e := l.lru.Front(); e != nil; e = e.Next() {
+		out = append(out, e.Value.(*loadedBlock).blk)
+	}
+	l.lru.Init()
+	l.blocks = map[string]map[ulid.ULID]*list.Element{}
+	l.size = 0
+	return out
+}
+
+// totalSize returns the size of the index of the open blocks.
+func (l *loadedBlocks) totalSize() int64 {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.size
+}
+
+// indexSize returns the size of the index file of the block, mmapped while the block is open.
+// It's the size of the whole block if the index file can't be found.
+func indexSize(blk *tsdb.Block) int64 {
+	info, err := os.Stat(filepath.Join(blk.Dir(), block.IndexFilename))
+	if err != nil {
+		return blk.Size()
+	}
+	return info.Size()
+}
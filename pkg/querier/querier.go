@@ -0,0 +1,292 @@
+package querier
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/querysharding"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/ingester/head"
+	"objectstorage/pkg/seriesdeletion"
+	bucket_tsdb "objectstorage/pkg/storage/tsdb"
+	"objectstorage/pkg/util/promapi"
+	"objectstorage/pkg/util/utf8names"
+)
+
+// Paths of the query API.
+const (
+	QueryPath      = "/api/v1/query"
+	QueryRangePath = "/api/v1/query_range"
+)
+
+// maxPoints is the maximum number of points per series of a range query, matching Prometheus.
+const maxPoints = 11000
+
+var (
+	errInvalidSyncInterval  = errors.New("the querier blocks sync interval must be greater than 0")
+	errInvalidMaxLoaded     = errors.New("the querier max loaded blocks bytes must be greater than or equal to 0")
+	errMissingBlocksDir     = errors.New("the querier blocks directory is required")
+	errInvalidStep          = errors.New("zero or negative query resolution step widths are not accepted, try a positive integer")
+	errTooManyPoints        = errors.Errorf("exceeded maximum resolution of %d points per timeseries, try decreasing the query resolution", maxPoints)
+	errMissingQuery         = errors.New("the query parameter is required")
+	errMissingRangeBoundary = errors.New("the start, end and step parameters are required")
+)
+
+// Config holds the configuration of the local querier. The PromQL engine is configured by
+// the Cortex querier config.
+type Config struct {
+	BlocksDir                 string        `yaml:"blocks_dir"`
+	BlocksSyncInterval        time.Duration `yaml:"blocks_sync_interval"`
+	BucketIndexMaxStalePeriod time.Duration `yaml:"bucket_index_max_stale_period"`
+	MaxLoadedBlocksBytes      int64         `yaml:"max_loaded_blocks_bytes"`
+
+	// The chunks cache, in front of the bucket the chunks are read from by range if set,
+	// instead of the blocks being downloaded whole.
+	ChunksCache bucket_tsdb.ChunksCacheConfig `yaml:"chunks_cache"`
+}
+
+// RegisterFlags registers the local querier flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.BlocksDir, "querier.blocks-dir", "./querier-blocks", "Directory the bucket blocks queried are downloaded to. The blocks are kept until deleted from the bucket, so the local querier is only suited to small deployments.")
+	f.DurationVar(&cfg.BlocksSyncInterval, "querier.blocks-sync-interval", 5*time.Minute, "How frequently the blocks of a tenant are listed from the bucket, at most.")
+	f.DurationVar(&cfg.BucketIndexMaxStalePeriod, "querier.bucket-index-max-stale-period", time.Hour, "The blocks of a tenant are read from its bucket index, if updated within this period, instead of being listed from the bucket. 0 to always list the bucket.")
+	f.Int64Var(&cfg.MaxLoadedBlocksBytes, "querier.max-loaded-blocks-bytes", 0, "Max size in bytes of the index of the bucket blocks kept open, mmapped. The chunks are not accounted for. The blocks are open on first query, and the least recently queried ones are closed once over the limit, staying on disk. 0 to keep all the queried blocks open.")
+	cfg.ChunksCache.RegisterFlagsWithPrefix(f, "querier.chunks-cache.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if cfg.BlocksDir == "" {
+		return errMissingBlocksDir
+	}
+	if cfg.BlocksSyncInterval <= 0 {
+		return errInvalidSyncInterval
+	}
+	if cfg.MaxLoadedBlocksBytes < 0 {
+		return errInvalidMaxLoaded
+	}
+	if err := cfg.ChunksCache.Validate(); err != nil {
+		return errors.Wrap(err, "invalid chunks cache config")
+	}
+	return nil
+}
+
+// Querier evaluates PromQL queries against the ingester head and the bucket blocks, so that
+// small deployments can ingest and query with a single binary.
+type Querier struct {
+	services.Service
+
+	cfg       Config
+	blocks    *BlocksQueryable
+	queryable storage.Queryable
+	engine    *promql.Engine
+	limits    Limits
+	analyzer  querysharding.Analyzer
+	logger    log.Logger
+}
+
+// NewQuerier makes a new Querier. The source is nil if the ingester is not in this process,
+// in which case only the bucket blocks are queried. The bucket must cache the chunks if the
+// chunks cache is configured. The shardable range queries are split in
+// the number of shards of the tenant limits, evaluated concurrently.
+func NewQuerier(cfg Config, engineOpts promql.EngineOpts, source head.QuerySource, bkt objstore.Bucket, limits Limits, logger log.Logger, reg prometheus.Registerer) *Querier {
+	q := &Querier{
+		cfg:      cfg,
+		blocks:   NewBlocksQueryable(cfg, bkt, logger, reg),
+		engine:   promql.NewEngine(engineOpts),
+		limits:   limits,
+		analyzer: querysharding.NewQueryAnalyzer(),
+		logger:   logger,
+	}
+
+	queryables := []storage.Queryable{q.blocks}
+	if source != nil {
+		queryables = append(queryables, head.NewQueryable(source))
+	}
+	// The names are translated for the clients of the tenants with UTF-8 names, and the samples
+	// deleted by the tombstones masked.
+	q.queryable = utf8names.Queryable(shardingQueryable(seriesdeletion.Queryable(mergeQueryable(queryables), q.blocks.Tombstones)))
+
+	q.Service = services.NewIdleService(nil, q.stopping)
+	return q
+}
+
+func (q *Querier) stopping(_ error) error {
+	return q.blocks.Close()
+}
+
+// QueryHandler serves the instant queries.
+func (q *Querier) QueryHandler(w http.ResponseWriter, r *http.Request) {
+	ts, err := promapi.ParseTime(r.FormValue("time"), time.Now())
+	if err != nil {
+		promapi.WriteError(w, promapi.ErrorBadData, errors.Wrap(err, "invalid time"), q.logger)
+		return
+	}
+
+	qs := q.queryString(r)
+	if qs == "" {
+		promapi.WriteError(w, promapi.ErrorBadData, errMissingQuery, q.logger)
+		return
+	}
+
+	ctx, cancel, err := q.withTimeout(r)
+	if err != nil {
+		promapi.WriteError(w, promapi.ErrorBadData, err, q.logger)
+		return
+	}
+	defer cancel()
+
+	query, err := q.engine.NewInstantQuery(q.queryable, nil, qs, ts)
+	if err != nil {
+		promapi.WriteError(w, promapi.ErrorBadData, err, q.logger)
+		return
+	}
+	q.exec(ctx, w, query)
+}
+
+// QueryRangeHandler serves the range queries.
+func (q *Querier) QueryRangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("start") == "" || r.FormValue("end") == "" || r.FormValue("step") == "" {
+		promapi.WriteError(w, promapi.ErrorBadData, errMissingRangeBoundary, q.logger)
+		return
+	}
+
+	start, end, err := promapi.ParseTimeRange(r)
+	if err != nil {
+		promapi.WriteError(w, promapi.ErrorBadData, err, q.logger)
+		return
+	}
+	step, err := promapi.ParseDuration(r.FormValue("step"))
+	if err != nil {
+		promapi.WriteError(w, promapi.ErrorBadData, errors.Wrap(err, "invalid step"), q.logger)
+		return
+	}
+	if step <= 0 {
+		promapi.WriteError(w, promapi.ErrorBadData, errInvalidStep, q.logger)
+		return
+	}
+	if end.Sub(start)/step > maxPoints {
+		promapi.WriteError(w, promapi.ErrorBadData, errTooManyPoints, q.logger)
+		return
+	}
+
+	qs := q.queryString(r)
+	if qs == "" {
+		promapi.WriteError(w, promapi.ErrorBadData, errMissingQuery, q.logger)
+		return
+	}
+
+	ctx, cancel, err := q.withTimeout(r)
+	if err != nil {
+		promapi.WriteError(w, promapi.ErrorBadData, err, q.logger)
+		return
+	}
+	defer cancel()
+
+	if shards := q.shardQuery(ctx, qs); shards != nil {
+		q.writeResult(w, q.execShardedRange(ctx, shards, start, end, step))
+		return
+	}
+
+	query, err := q.engine.NewRangeQuery(q.queryable, nil, qs, start, end, step)
+	if err != nil {
+		promapi.WriteError(w, promapi.ErrorBadData, err, q.logger)
+		return
+	}
+	q.exec(ctx, w, query)
+}
+
+// queryString returns the query parameter. The quoted UTF-8 names of its selectors are
+// rewritten in the legacy syntax if the tenant has the UTF-8 names enabled.
+func (q *Querier) queryString(r *http.Request) string {
+	qs := r.FormValue("query")
+	if _, ok := utf8names.FromContext(r.Context()); ok {
+		qs = utf8names.RewriteQuotedNames(qs)
+	}
+	return qs
+}
+
+// withTimeout returns the request context bounded by the timeout parameter, if any.
+func (q *Querier) withTimeout(r *http.Request) (context.Context, context.CancelFunc, error) {
+	ctx := r.Context()
+	if v := r.FormValue("timeout"); v != "" {
+		timeout, err := promapi.ParseDuration(v)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid timeout")
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, cancel, nil
+}
+
+func (q *Querier) exec(ctx context.Context, w http.ResponseWriter, query promql.Query) {
+	defer query.Close()
+
+	q.writeResult(w, query.Exec(ctx))
+}
+
+func (q *Querier) writeResult(w http.ResponseWriter, res *promql.Result) {
+	if res.Err != nil {
+		promapi.WriteError(w, errorType(res.Err), res.Err, q.logger)
+		return
+	}
+
+	var warnings []string
+	for _, warn := range res.Warnings {
+		warnings = append(warnings, warn.Error())
+	}
+
+	promapi.WriteSuccess(w, queryData{ResultType: res.Value.Type(), Result: res.Value}, warnings, q.logger)
+}
+
+type queryData struct {
+	ResultType parser.ValueType `json:"resultType"`
+	Result     parser.Value     `json:"result"`
+}
+
+func errorType(err error) string {
+	switch errors.Cause(err).(type) {
+	case promql.ErrQueryCanceled:
+		return promapi.ErrorCanceled
+	case promql.ErrQueryTimeout:
+		return promapi.ErrorTimeout
+	case promql.ErrStorage:
+		return promapi.ErrorInternal
+	}
+	if errors.Is(err, context.Canceled) {
+		return promapi.ErrorCanceled
+	}
+	return promapi.ErrorExecution
+}
+
+// mergeQueryable returns the queryable merging the series of the input ones. The samples
+// of the head and the blocks overlapping in time are deduplicated.
+func mergeQueryable(queryables []storage.Queryable) storage.Queryable {
+	return storage.QueryableFunc(func(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+		queriers := make([]storage.Querier, 0, len(queryables))
+		for _, queryable := range queryables {
+			querier, err := queryable.Querier(ctx, mint, maxt)
+			if err != nil {
+				for _, q := range queriers {
+					q.Close()
+				}
+				return nil, err
+			}
+			queriers = append(queriers, querier)
+		}
+		return storage.NewMergeQuerier(queriers, nil, storage.ChainedSeriesMerge), nil
+	})
+}
@@ -0,0 +1,374 @@
+package querier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	cortex_testutil "github.com/cortexproject/cortex/pkg/storage/tsdb/testutil"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+
+	"objectstorage/pkg/ingester/head"
+	"objectstorage/pkg/seriesdeletion"
+	"objectstorage/pkg/storage/bucket"
+	bucket_tsdb "objectstorage/pkg/storage/tsdb"
+	"objectstorage/pkg/storage/tsdb/bucketindex"
+)
+
+type sourceMock []cortexpb.TimeSeries
+
+func (m sourceMock) Query(context.Context, *client.QueryRequest) (*client.QueryResponse, error) {
+	return &client.QueryResponse{Timeseries: m}, nil
+}
+
+type limitsMock int
+
+func (m limitsMock) QueryVerticalShardSize(string) int { return int(m) }
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *Config)
+		expected error
+	}{
+		"default config": {
+			setup:    func(*Config) {},
+			expected: nil,
+		},
+		"missing blocks dir": {
+			setup:    func(cfg *Config) { cfg.BlocksDir = "" },
+			expected: errMissingBlocksDir,
+		},
+		"invalid sync interval": {
+			setup:    func(cfg *Config) { cfg.BlocksSyncInterval = 0 },
+			expected: errInvalidSyncInterval,
+		},
+		"negative max loaded blocks bytes": {
+			setup:    func(cfg *Config) { cfg.MaxLoadedBlocksBytes = -1 },
+			expected: errInvalidMaxLoaded,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+			assert.ErrorIs(t, cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+func TestQuerier_QueryHandler(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	source := sourceMock{{
+		Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node"}},
+		Samples: []cortexpb.Sample{{TimestampMs: now.Add(-time.Minute).UnixMilli(), Value: 1}},
+	}}
+
+	q := newTestQuerier(t, source, 0)
+
+	tests := map[string]struct {
+		path           string
+		expectedStatus int
+		expectedType   string
+	}{
+		"instant query": {
+			path:           QueryPath + "?query=sum(up)&time=" + now.Format(time.RFC3339),
+			expectedStatus: http.StatusOK,
+			expectedType:   "vector",
+		},
+		"range query": {
+			path:           QueryRangePath + "?query=up&step=15s&start=" + now.Add(-2*time.Minute).Format(time.RFC3339) + "&end=" + now.Format(time.RFC3339),
+			expectedStatus: http.StatusOK,
+			expectedType:   "matrix",
+		},
+		"missing query": {
+			path:           QueryPath,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"invalid query": {
+			path:           QueryPath + "?query=sum(",
+			expectedStatus: http.StatusBadRequest,
+		},
+		"range query without step": {
+			path:           QueryRangePath + "?query=up&start=0&end=60",
+			expectedStatus: http.StatusBadRequest,
+		},
+		"range query with too many points": {
+			path:           QueryRangePath + "?query=up&start=0&end=100000&step=1",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req = req.WithContext(user.InjectOrgID(req.Context(), "user-1"))
+			rec := httptest.NewRecorder()
+
+			if req.URL.Path == QueryPath {
+				q.QueryHandler(rec, req)
+			} else {
+				q.QueryRangeHandler(rec, req)
+			}
+			require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+
+			if tc.expectedType != "" {
+				var resp struct {
+					Status string `json:"status"`
+					Data   struct {
+						ResultType string            `json:"resultType"`
+						Result     []json.RawMessage `json:"result"`
+					} `json:"data"`
+				}
+				require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+				assert.Equal(t, "success", resp.Status)
+				assert.Equal(t, tc.expectedType, resp.Data.ResultType)
+				assert.Len(t, resp.Data.Result, 1)
+			}
+		})
+	}
+}
+
+func TestQuerier_QueryRangeHandler_Sharding(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	var source sourceMock
+	for _, job := range []string{"api", "db", "node", "web"} {
+		for _, instance := range []string{"a", "b", "c"} {
+			source = append(source, cortexpb.TimeSeries{
+				Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: "up"}, {Name: "instance", Value: instance}, {Name: "job", Value: job}},
+				Samples: []cortexpb.Sample{{TimestampMs: now.Add(-time.Minute).UnixMilli(), Value: 1}},
+			})
+		}
+	}
+
+	path := QueryRangePath + "?step=15s&start=" + now.Add(-2*time.Minute).Format(time.RFC3339) + "&end=" + now.Format(time.RFC3339) + "&query="
+
+	for _, query := range []string{"sum by (job) (up)", "count without (instance) (up)", "sum(up)"} {
+		t.Run(query, func(t *testing.T) {
+			unsharded := queryRange(t, newTestQuerier(t, source, 0), path+url.QueryEscape(query))
+			sharded := queryRange(t, newTestQuerier(t, source, 3), path+url.QueryEscape(query))
+			assert.JSONEq(t, unsharded, sharded)
+		})
+	}
+}
+
+func queryRange(t *testing.T, q *Querier, path string) string {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req = req.WithContext(user.InjectOrgID(req.Context(), "user-1"))
+	rec := httptest.NewRecorder()
+
+	q.QueryRangeHandler(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	return rec.Body.String()
+}
+
+func newTestQuerier(t *testing.T, source head.QuerySource, shards int) *Querier {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.BlocksDir = t.TempDir()
+
+	engineOpts := promql.EngineOpts{MaxSamples: 1000, Timeout: time.Minute, LookbackDelta: 5 * time.Minute}
+
+	q := NewQuerier(cfg, engineOpts, source, objstore.NewInMemBucket(), limitsMock(shards), log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	t.Cleanup(func() { require.NoError(t, q.blocks.Close()) })
+	return q
+}
+
+func TestBlocksQueryable_ReadIndex(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	listed := cortex_testutil.MockStorageBlock(t, bkt, "user-1", 10, 20)
+	indexed := ulid.MustNew(1, nil)
+
+	tests := map[string]struct {
+		maxStalePeriod time.Duration
+		updatedAt      time.Time
+		expected       ulid.ULID
+	}{
+		"fresh index": {
+			maxStalePeriod: time.Hour,
+			updatedAt:      time.Now().Add(-time.Minute),
+			expected:       indexed,
+		},
+		"stale index": {
+			maxStalePeriod: time.Hour,
+			updatedAt:      time.Now().Add(-2 * time.Hour),
+			expected:       listed.ULID,
+		},
+		"index disabled": {
+			maxStalePeriod: 0,
+			updatedAt:      time.Now(),
+			expected:       listed.ULID,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, bucketindex.WriteIndex(ctx, bkt, "user-1", nil, &bucketindex.Index{
+				Version:   bucketindex.IndexVersion1,
+				Blocks:    bucketindex.Blocks{{ID: indexed, MinTime: 10, MaxTime: 20}},
+				UpdatedAt: tc.updatedAt.Unix(),
+			}))
+
+			b := newTestBlocksQueryable(t, bkt, func(cfg *Config) { cfg.BucketIndexMaxStalePeriod = tc.maxStalePeriod })
+			idx, err := b.readIndex(ctx, "user-1", nil)
+			require.NoError(t, err)
+			assert.Equal(t, []ulid.ULID{tc.expected}, idx.Blocks.GetULIDs())
+		})
+	}
+}
+
+// uploadBlock uploads a block of the tenant with two series with samples over the time range.
+func uploadBlock(t *testing.T, bkt objstore.Bucket, userID string, mint, maxt int64) ulid.ULID {
+	series := []labels.Labels{labels.FromStrings("__name__", "up", "job", "a"), labels.FromStrings("__name__", "up", "job", "b")}
+	dir := t.TempDir()
+	id, err := e2eutil.CreateBlock(context.Background(), dir, series, 500, mint, maxt, labels.FromStrings("__org_id__", userID), 0, metadata.NoneFunc)
+	require.NoError(t, err)
+	require.NoError(t, block.Upload(context.Background(), log.NewNopLogger(), bucket.NewUserBucketClient(userID, bkt, nil), filepath.Join(dir, id.String()), metadata.NoneFunc))
+	return id
+}
+
+// querySamples returns the number of samples of each series queried over the time range.
+func querySamples(t *testing.T, b *BlocksQueryable, userID string, mint, maxt int64) map[string]int {
+	q, err := b.Querier(user.InjectOrgID(context.Background(), userID), mint, maxt)
+	require.NoError(t, err)
+	defer q.Close()
+
+	samples := map[string]int{}
+	set := q.Select(false, nil, labels.MustNewMatcher(labels.MatchEqual, "__name__", "up"))
+	for set.Next() {
+		it := set.At().Iterator(nil)
+		for it.Next() != 0 {
+			samples[set.At().Labels().Get("job")]++
+		}
+		require.NoError(t, it.Err())
+	}
+	require.NoError(t, set.Err())
+	return samples
+}
+
+func newTestBlocksQueryable(t *testing.T, bkt objstore.Bucket, setup func(cfg *Config)) *BlocksQueryable {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.BlocksDir = t.TempDir()
+	cfg.BucketIndexMaxStalePeriod = 0
+	setup(&cfg)
+
+	b := NewBlocksQueryable(cfg, bkt, log.NewNopLogger(), nil)
+	t.Cleanup(func() { require.NoError(t, b.Close()) })
+	return b
+}
+
+func TestBlocksQueryable_ChunksFromBucket(t *testing.T) {
+	bkt := objstore.NewInMemBucket()
+	id := uploadBlock(t, bkt, "user-1", 0, 100000)
+
+	b := newTestBlocksQueryable(t, bkt, func(*Config) {})
+	expected := querySamples(t, b, "user-1", 0, 100000)
+	assert.Equal(t, map[string]int{"a": 500, "b": 500}, expected)
+	segments, err := os.ReadDir(filepath.Join(b.dir, "user-1", id.String(), block.ChunksDirname))
+	require.NoError(t, err)
+	assert.NotEmpty(t, segments)
+
+	// The chunks are read from the bucket, and not downloaded.
+	b = newTestBlocksQueryable(t, bkt, func(cfg *Config) { cfg.ChunksCache.Backend = bucket_tsdb.CacheBackendMemcached })
+	assert.Equal(t, expected, querySamples(t, b, "user-1", 0, 100000))
+	segments, err = os.ReadDir(filepath.Join(b.dir, "user-1", id.String(), block.ChunksDirname))
+	require.NoError(t, err)
+	assert.Empty(t, segments)
+}
+
+func TestBlocksQueryable_EvictsTheLeastRecentlyQueriedBlocks(t *testing.T) {
+	bkt := objstore.NewInMemBucket()
+	first := uploadBlock(t, bkt, "user-1", 0, 100000)
+	second := uploadBlock(t, bkt, "user-1", 200000, 300000)
+	third := uploadBlock(t, bkt, "user-2", 0, 100000)
+
+	b := newTestBlocksQueryable(t, bkt, func(*Config) {})
+	querySamples(t, b, "user-1", 0, 100000)
+	querySamples(t, b, "user-1", 200000, 300000)
+
+	// Only the index of the open blocks is accounted for, not their chunks.
+	var indexSize int64
+	for _, id := range []ulid.ULID{first, second} {
+		info, err := os.Stat(filepath.Join(b.dir, "user-1", id.String(), block.IndexFilename))
+		require.NoError(t, err)
+		indexSize += info.Size()
+	}
+	assert.Equal(t, indexSize, b.open.totalSize())
+
+	// Room for the two blocks open only, the blocks being about the same size.
+	b.open.maxSize = b.open.totalSize() * 5 / 4
+
+	// The first block is queried again, so the second one is evicted to open the third.
+	querySamples(t, b, "user-1", 0, 100000)
+	assert.Equal(t, map[string]int{"a": 500, "b": 500}, querySamples(t, b, "user-2", 0, 100000))
+	assert.Equal(t, []ulid.ULID{first}, b.open.ids("user-1"))
+	assert.Equal(t, []ulid.ULID{third}, b.open.ids("user-2"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(b.evictions))
+
+	// The evicted block is open again from disk, without being downloaded again.
+	assert.Equal(t, map[string]int{"a": 500, "b": 500}, querySamples(t, b, "user-1", 200000, 300000))
+	assert.Equal(t, []ulid.ULID{second}, b.open.ids("user-1"))
+	assert.Equal(t, float64(3), testutil.ToFloat64(b.downloads))
+}
+
+func TestQuerier_MasksTheSamplesDeletedByTheTombstones(t *testing.T) {
+	bkt := objstore.NewInMemBucket()
+	uploadBlock(t, bkt, "user-1", 0, 100000)
+	require.NoError(t, bucketindex.WriteTombstone(context.Background(), bucket.NewUserBucketClient("user-1", bkt, nil), &bucketindex.Tombstone{
+		RequestID: "request-1",
+		Version:   bucketindex.TombstoneVersion1,
+		Selectors: []string{`{job="a"}`},
+		StartTime: 0,
+		EndTime:   49999,
+	}))
+
+	b := newTestBlocksQueryable(t, bkt, func(*Config) {})
+	q, err := seriesdeletion.Queryable(b, b.Tombstones).Querier(user.InjectOrgID(context.Background(), "user-1"), 0, 100000)
+	require.NoError(t, err)
+	defer q.Close()
+
+	samples := map[string]int{}
+	set := q.Select(false, nil, labels.MustNewMatcher(labels.MatchEqual, "__name__", "up"))
+	for set.Next() {
+		job := set.At().Labels().Get("job")
+		it := set.At().Iterator(nil)
+		for vt := it.Seek(0); vt != chunkenc.ValNone; vt = it.Next() {
+			if job == "a" {
+				assert.Greater(t, it.AtT(), int64(49999))
+			}
+			samples[job]++
+		}
+		require.NoError(t, it.Err())
+	}
+	require.NoError(t, set.Err())
+
+	assert.Equal(t, 500, samples["b"])
+	assert.Greater(t, samples["a"], 0)
+	assert.Less(t, samples["a"], 500)
+}
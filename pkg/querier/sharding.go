@@ -0,0 +1,175 @@
+package querier
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+
+	cortex_querysharding "github.com/cortexproject/cortex/pkg/querysharding"
+
+	"objectstorage/pkg/tenant"
+	"objectstorage/pkg/util/promapi"
+)
+
+// Limits returns the per-tenant query limits. It's implemented by the Cortex overrides.
+type Limits interface {
+	// QueryVerticalShardSize returns the number of shards the shardable queries of the
+	// tenant are split into. 0 or 1 disables the sharding.
+	QueryVerticalShardSize(userID string) int
+}
+
+// shardQuery returns the shards of the query, or nil if the query is not shardable or the
+// sharding is disabled for the tenant. Each shard selects the series whose hash of the
+// sharding labels matches its index, so that the shards results can be concatenated.
+func (q *Querier) shardQuery(ctx context.Context, qs string) []string {
+	userID, err := tenant.TenantID(ctx)
+	if err != nil {
+		return nil
+	}
+
+	numShards := q.limits.QueryVerticalShardSize(userID)
+	if numShards <= 1 {
+		return nil
+	}
+
+	analysis, err := q.analyzer.Analyze(qs)
+	if err != nil || !analysis.IsShardable() {
+		return nil
+	}
+
+	shards := make([]string, 0, numShards)
+	for i := 0; i < numShards; i++ {
+		shard, err := cortex_querysharding.InjectShardingInfo(qs, &storepb.ShardInfo{
+			TotalShards: int64(numShards),
+			ShardIndex:  int64(i),
+			By:          analysis.ShardBy(),
+			Labels:      analysis.ShardingLabels(),
+		})
+		if err != nil {
+			level.Warn(q.logger).Log("msg", "failed to shard query, running it unsharded", "query", qs, "err", err)
+			return nil
+		}
+		shards = append(shards, shard)
+	}
+	return shards
+}
+
+// execShardedRange runs the shards of a range query concurrently, and merges their results.
+func (q *Querier) execShardedRange(ctx context.Context, shards []string, start, end time.Time, step time.Duration) *promql.Result {
+	queries := make([]promql.Query, 0, len(shards))
+	defer func() {
+		for _, query := range queries {
+			query.Close()
+		}
+	}()
+
+	for _, shard := range shards {
+		query, err := q.engine.NewRangeQuery(q.queryable, nil, shard, start, end, step)
+		if err != nil {
+			return &promql.Result{Err: err}
+		}
+		queries = append(queries, query)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]*promql.Result, len(queries))
+	wg := sync.WaitGroup{}
+	for i, query := range queries {
+		wg.Add(1)
+		go func(i int, query promql.Query) {
+			defer wg.Done()
+
+			results[i] = query.Exec(ctx)
+			// A failed shard fails the query, so the other shards are canceled.
+			if results[i].Err != nil {
+				cancel()
+			}
+		}(i, query)
+	}
+	wg.Wait()
+
+	merged := &promql.Result{}
+	matrix := promql.Matrix{}
+	for _, res := range results {
+		if res.Err != nil {
+			// Prefer the root cause to the cancellation of the other shards.
+			if merged.Err == nil || errorType(merged.Err) == promapi.ErrorCanceled {
+				merged.Err = res.Err
+			}
+			continue
+		}
+		merged.Warnings = append(merged.Warnings, res.Warnings...)
+		if m, ok := res.Value.(promql.Matrix); ok {
+			matrix = append(matrix, m...)
+		}
+	}
+	if merged.Err != nil {
+		return merged
+	}
+
+	sort.Sort(matrix)
+	merged.Value = matrix
+	return merged
+}
+
+// shardingQueryable filters the series selected by the queriers by the sharding matcher of
+// the query shard, if any.
+func shardingQueryable(queryable storage.Queryable) storage.Queryable {
+	return storage.QueryableFunc(func(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+		querier, err := queryable.Querier(ctx, mint, maxt)
+		if err != nil {
+			return nil, err
+		}
+		return shardingQuerier{Querier: querier}, nil
+	})
+}
+
+type shardingQuerier struct {
+	storage.Querier
+}
+
+func (s shardingQuerier) Select(sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	matchers, shardMatcher, err := cortex_querysharding.ExtractShardingMatchers(matchers)
+	if err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+
+	set := s.Querier.Select(sortSeries, hints, matchers...)
+	if !shardMatcher.IsSharded() {
+		shardMatcher.Close()
+		return set
+	}
+	return &shardedSeriesSet{SeriesSet: set, matcher: shardMatcher}
+}
+
+type shardedSeriesSet struct {
+	storage.SeriesSet
+
+	matcher *storepb.ShardMatcher
+	closed  bool
+}
+
+func (s *shardedSeriesSet) Next() bool {
+	if s.closed {
+		return false
+	}
+	for s.SeriesSet.Next() {
+		if s.matcher.MatchesLabels(s.SeriesSet.At().Labels()) {
+			return true
+		}
+	}
+
+	// The matcher buffers are pooled, so it's closed once the set is exhausted.
+	s.matcher.Close()
+	s.closed = true
+	return false
+}
@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"context"
+	"flag"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/httpgrpc"
+)
+
+// baselineDecay is how slowly the latency baseline follows latencies above it: each
+// sample moves it by 1/baselineDecay of the difference, so that a durable latency shift
+// is eventually accepted as the new normal.
+const baselineDecay = 100
+
+var (
+	errInvalidAdaptiveLimits   = errors.New("the adaptive concurrency limits must satisfy 1 <= min <= initial <= max")
+	errInvalidLatencyTolerance = errors.New("the adaptive concurrency latency tolerance must be greater than 1")
+	errInvalidAdaptiveBackoff  = errors.New("the adaptive concurrency backoff ratio must be between 0 and 1, exclusive")
+)
+
+// AdaptiveConfig holds the configuration of the adaptive concurrency limit of the push
+// requests.
+type AdaptiveConfig struct {
+	Enabled          bool    `yaml:"enabled"`
+	InitialLimit     int     `yaml:"initial_limit"`
+	MinLimit         int     `yaml:"min_limit"`
+	MaxLimit         int     `yaml:"max_limit"`
+	LatencyTolerance float64 `yaml:"latency_tolerance"`
+	BackoffRatio     float64 `yaml:"backoff_ratio"`
+}
+
+// RegisterFlags registers the adaptive concurrency limit flags.
+func (cfg *AdaptiveConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "push.adaptive-concurrency.enabled", false, "True to limit the push requests processed at the same time by this instance to a limit adjusted to the observed latency and errors: increased while the pushes are healthy, decreased when they slow down or fail.")
+	f.IntVar(&cfg.InitialLimit, "push.adaptive-concurrency.initial-limit", 50, "Concurrency limit at startup.")
+	f.IntVar(&cfg.MinLimit, "push.adaptive-concurrency.min-limit", 10, "Lowest concurrency limit.")
+	f.IntVar(&cfg.MaxLimit, "push.adaptive-concurrency.max-limit", 1000, "Highest concurrency limit.")
+	f.Float64Var(&cfg.LatencyTolerance, "push.adaptive-concurrency.latency-tolerance", 2, "Ratio of the push latency to the baseline latency above which the instance is considered overloaded and the limit decreased.")
+	f.Float64Var(&cfg.BackoffRatio, "push.adaptive-concurrency.backoff-ratio", 0.9, "Ratio the limit is multiplied by when the instance is overloaded.")
+}
+
+// Validate the config.
+func (cfg *AdaptiveConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.MinLimit < 1 || cfg.InitialLimit < cfg.MinLimit || cfg.MaxLimit < cfg.InitialLimit {
+		return errInvalidAdaptiveLimits
+	}
+	if cfg.LatencyTolerance <= 1 {
+		return errInvalidLatencyTolerance
+	}
+	if cfg.BackoffRatio <= 0 || cfg.BackoffRatio >= 1 {
+		return errInvalidAdaptiveBackoff
+	}
+	return nil
+}
+
+// adaptiveLimiter limits the concurrent push requests with an AIMD algorithm: the limit is
+// increased by one after each healthy request using at least half of it, and multiplied by
+// the backoff ratio after each failed request or request slower than the latency baseline
+// times the tolerance. The baseline is the lowest latency observed, slowly decaying towards
+// the higher ones.
+type adaptiveLimiter struct {
+	cfg AdaptiveConfig
+
+	mtx      sync.Mutex
+	limit    float64
+	inflight int
+	baseline time.Duration
+}
+
+func newAdaptiveLimiter(cfg AdaptiveConfig, reg prometheus.Registerer) *adaptiveLimiter {
+	a := &adaptiveLimiter{cfg: cfg, limit: float64(cfg.InitialLimit)}
+
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "cortex_push_adaptive_concurrency_limit",
+		Help: "Current adaptive concurrency limit of the push requests.",
+	}, func() float64 { return float64(a.currentLimit()) })
+	return a
+}
+
+// acquire returns whether the request is allowed and, if so, the number of requests in
+// flight including it. Allowed requests must be released.
+func (a *adaptiveLimiter) acquire() (int, bool) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.inflight >= int(a.limit) {
+		return a.inflight, false
+	}
+	a.inflight++
+	return a.inflight, true
+}
+
+// release adjusts the limit to the outcome of a request allowed with inflight requests in
+// flight.
+func (a *adaptiveLimiter) release(inflight int, latency time.Duration, failed bool) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	a.inflight--
+
+	overloaded := failed || (a.baseline > 0 && float64(latency) > float64(a.baseline)*a.cfg.LatencyTolerance)
+	if !failed {
+		if a.baseline == 0 || latency < a.baseline {
+			a.baseline = latency
+		} else {
+			a.baseline += (latency - a.baseline) / baselineDecay
+		}
+	}
+
+	switch {
+	case overloaded:
+		a.limit = math.Max(float64(a.cfg.MinLimit), a.limit*a.cfg.BackoffRatio)
+	case inflight*2 >= int(a.limit):
+		// Only grow the limit if it's actually used, otherwise it grows unbounded while
+		// the traffic is low.
+		a.limit = math.Min(float64(a.cfg.MaxLimit), a.limit+1)
+	}
+}
+
+func (a *adaptiveLimiter) currentLimit() int {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	return int(a.limit)
+}
+
+// isOverloadError returns whether the push error is a sign of the instance being
+// overloaded. Client errors, including the limits, and canceled requests are not.
+func isOverloadError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	return !ok || resp.Code/100 == 5
+}
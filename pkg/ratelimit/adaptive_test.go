@@ -0,0 +1,145 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestAdaptiveConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *AdaptiveConfig)
+		expected error
+	}{
+		"disabled": {
+			setup:    func(*AdaptiveConfig) {},
+			expected: nil,
+		},
+		"enabled": {
+			setup:    func(cfg *AdaptiveConfig) { cfg.Enabled = true },
+			expected: nil,
+		},
+		"initial limit lower than the min": {
+			setup: func(cfg *AdaptiveConfig) {
+				cfg.Enabled = true
+				cfg.InitialLimit = cfg.MinLimit - 1
+			},
+			expected: errInvalidAdaptiveLimits,
+		},
+		"max limit lower than the initial": {
+			setup: func(cfg *AdaptiveConfig) {
+				cfg.Enabled = true
+				cfg.MaxLimit = cfg.InitialLimit - 1
+			},
+			expected: errInvalidAdaptiveLimits,
+		},
+		"latency tolerance not greater than 1": {
+			setup: func(cfg *AdaptiveConfig) {
+				cfg.Enabled = true
+				cfg.LatencyTolerance = 1
+			},
+			expected: errInvalidLatencyTolerance,
+		},
+		"invalid backoff ratio": {
+			setup: func(cfg *AdaptiveConfig) {
+				cfg.Enabled = true
+				cfg.BackoffRatio = 1
+			},
+			expected: errInvalidAdaptiveBackoff,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := AdaptiveConfig{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+			assert.ErrorIs(t, cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	cfg := AdaptiveConfig{Enabled: true, InitialLimit: 4, MinLimit: 2, MaxLimit: 6, LatencyTolerance: 2, BackoffRatio: 0.5}
+	a := newAdaptiveLimiter(cfg, nil)
+
+	// The limit is enforced.
+	for i := 1; i <= 4; i++ {
+		inflight, ok := a.acquire()
+		require.True(t, ok)
+		require.Equal(t, i, inflight)
+	}
+	_, ok := a.acquire()
+	require.False(t, ok)
+
+	// Healthy requests using the limit grow it, up to the max.
+	for i := 4; i > 0; i-- {
+		a.release(i, 10*time.Millisecond, false)
+	}
+	assert.Equal(t, 6, a.currentLimit())
+
+	// Healthy requests not using the limit don't grow it.
+	inflight, _ := a.acquire()
+	a.release(inflight, 10*time.Millisecond, false)
+	assert.Equal(t, 6, a.currentLimit())
+
+	// Slow requests shrink the limit.
+	inflight, _ = a.acquire()
+	a.release(inflight, 50*time.Millisecond, false)
+	assert.Equal(t, 3, a.currentLimit())
+
+	// Failed requests shrink the limit, down to the min.
+	inflight, _ = a.acquire()
+	a.release(inflight, 10*time.Millisecond, true)
+	assert.Equal(t, 2, a.currentLimit())
+	inflight, _ = a.acquire()
+	a.release(inflight, 10*time.Millisecond, true)
+	assert.Equal(t, 2, a.currentLimit())
+}
+
+func TestLimiter_AdaptiveConcurrency(t *testing.T) {
+	cfg := Config{RecheckPeriod: time.Minute, InflightRetryAfter: time.Second}
+	cfg.AdaptiveConcurrency = AdaptiveConfig{Enabled: true, InitialLimit: 1, MinLimit: 1, MaxLimit: 1, LatencyTolerance: 2, BackoffRatio: 0.5}
+	l := NewLimiter(cfg, limitsMock{}, nil, nil)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	f := l.PushMiddleware()(func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		close(started)
+		<-release
+		return nil, httpgrpc.Errorf(http.StatusInternalServerError, "failed")
+	})
+	ctx := user.InjectOrgID(context.Background(), "user-1")
+
+	done := make(chan error)
+	go func() {
+		_, err := f(ctx, &cortexpb.WriteRequest{})
+		done <- err
+	}()
+	<-started
+
+	_, err := f(ctx, &cortexpb.WriteRequest{})
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	require.True(t, ok)
+	assert.Equal(t, int32(http.StatusTooManyRequests), resp.Code)
+
+	body := ErrorBody{}
+	require.NoError(t, json.Unmarshal(resp.Body, &body))
+	assert.Equal(t, InstanceAdaptiveConcurrency, body.Limit)
+	assert.Equal(t, ScopeInstance, body.Scope)
+
+	// The failed request is released.
+	close(release)
+	require.Error(t, <-done)
+	_, ok = l.adaptive.acquire()
+	assert.True(t, ok)
+}
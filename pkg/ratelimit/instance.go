@@ -0,0 +1,55 @@
+package ratelimit
+
+import "math"
+
+// InstanceLimits are the limits of the whole instance, enforced on the push path before the
+// appends, to keep a single instance from being overloaded until it crashes. The defaults
+// are the -ingester.instance-limits.* flags of the ingester config and the inflight limits of
+// the Config, and they can be overridden at runtime by the ingester_limits of the runtime
+// config. A limit of 0 disables it.
+type InstanceLimits struct {
+	MaxInMemorySeries       int64   `yaml:"max_series"`
+	MaxInflightPushRequests int64   `yaml:"max_inflight_push_requests"`
+	MaxInflightPushBytes    int64   `yaml:"max_inflight_push_bytes"`
+	MaxIngestionRate        float64 `yaml:"max_ingestion_rate"`
+}
+
+// defaultInstanceLimits are the limits the runtime overrides are applied on top of.
+var defaultInstanceLimits *InstanceLimits
+
+// SetDefaultInstanceLimits sets the limits the runtime overrides are applied on top of. It
+// must be called before the runtime config is loaded.
+func SetDefaultInstanceLimits(l InstanceLimits) {
+	defaultInstanceLimits = &l
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, applying the fields set on top of the defaults.
+func (l *InstanceLimits) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if defaultInstanceLimits != nil {
+		*l = *defaultInstanceLimits
+	}
+	type plain InstanceLimits
+	return unmarshal((*plain)(l))
+}
+
+// InstanceLimitsFn returns the instance limits currently in effect.
+type InstanceLimitsFn func() InstanceLimits
+
+// instanceIngestionStrategy is a limiter.RateLimiterStrategy with the instance max ingestion
+// rate, reloaded with the instance limits. The burst is one second of samples.
+type instanceIngestionStrategy struct {
+	limits InstanceLimitsFn
+}
+
+func (s instanceIngestionStrategy) Limit(string) float64 { return s.limits().MaxIngestionRate }
+func (s instanceIngestionStrategy) Burst(string) int {
+	return int(math.Ceil(s.limits().MaxIngestionRate))
+}
+
+// MinNonZero returns the lowest of the limits, ignoring the disabled ones.
+func MinNonZero(a, b int64) int64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+	"go.uber.org/atomic"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+func TestInstanceLimits_UnmarshalYAML(t *testing.T) {
+	SetDefaultInstanceLimits(InstanceLimits{MaxInMemorySeries: 100, MaxInflightPushRequests: 10})
+	t.Cleanup(func() { defaultInstanceLimits = nil })
+
+	limits := InstanceLimits{}
+	require.NoError(t, yaml.UnmarshalStrict([]byte("max_inflight_push_requests: 0\nmax_ingestion_rate: 1000\n"), &limits))
+	assert.Equal(t, InstanceLimits{MaxInMemorySeries: 100, MaxInflightPushRequests: 0, MaxIngestionRate: 1000}, limits)
+}
+
+func TestLimiter_InstanceLimitsReloaded(t *testing.T) {
+	req := &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+		Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: "up"}},
+		Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}, {Value: 1, TimestampMs: 2}},
+	}}}}
+
+	maxInflight := atomic.NewInt64(0)
+	l := NewLimiter(Config{RecheckPeriod: time.Minute, InflightRetryAfter: time.Second}, limitsMock{}, func() InstanceLimits {
+		return InstanceLimits{MaxInflightPushRequests: maxInflight.Load(), MaxIngestionRate: 5}
+	}, nil)
+
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	f := l.PushMiddleware()(func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		started <- struct{}{}
+		<-release
+		return &cortexpb.WriteResponse{}, nil
+	})
+	ctx := user.InjectOrgID(context.Background(), "user-1")
+
+	done := make(chan error)
+	go func() {
+		_, err := f(ctx, req)
+		done <- err
+	}()
+	<-started
+
+	// The inflight limit is enforced as soon as it's set.
+	maxInflight.Store(1)
+	_, err := f(ctx, req)
+	assertLimitError(t, err, InstanceInflightRequests)
+	close(release)
+	require.NoError(t, <-done)
+
+	// The burst of the ingestion rate is one second of samples, 4 of which have been taken.
+	_, err = f(ctx, req)
+	assertLimitError(t, err, InstanceIngestionRate)
+}
+
+func assertLimitError(t *testing.T, err error, expectedLimit string) {
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	require.True(t, ok)
+	assert.Equal(t, int32(http.StatusTooManyRequests), resp.Code)
+
+	body := ErrorBody{}
+	require.NoError(t, json.Unmarshal(resp.Body, &body))
+	assert.Equal(t, expectedLimit, body.Limit)
+	assert.Equal(t, ScopeInstance, body.Scope)
+}
@@ -0,0 +1,340 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/httpgrpc"
+	"go.uber.org/atomic"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/limiter"
+
+	"objectstorage/pkg/push"
+	"objectstorage/pkg/tenant"
+)
+
+// Names of the rate limits, reported in the error bodies and metrics.
+const (
+	InstanceRequestRate = "instance_request_rate"
+	InstanceSamplesRate = "instance_samples_rate"
+	TenantRequestRate   = "tenant_request_rate"
+	TenantSamplesRate   = "tenant_samples_rate"
+
+	InstanceInflightRequests = "instance_inflight_requests"
+	InstanceInflightBytes    = "instance_inflight_bytes"
+	InstanceIngestionRate    = "instance_ingestion_rate"
+
+	InstanceAdaptiveConcurrency = "instance_adaptive_concurrency"
+)
+
+// Scopes of the limits, reported in the error bodies so that the senders can tell whether
+// a single tenant or the whole instance is overloaded.
+const (
+	ScopeTenant   = "tenant"
+	ScopeInstance = "instance"
+)
+
+var (
+	errInvalidInflightRetryAfter = errors.New("the inflight limits retry after must be greater than 0")
+	errInvalidRequestBurstSize   = errors.New("the instance request burst size must be at least 1 when the instance request rate limit is enabled")
+	errInvalidSamplesBurstSize   = errors.New("the instance samples burst size must be at least 1 when the instance samples rate limit is enabled")
+)
+
+// instanceKey is the key of the instance-level token buckets in the rate limiters.
+const instanceKey = "instance"
+
+// Config holds the configuration of the instance-level rate limits. The per-tenant
+// ones are configured via the overrides.
+type Config struct {
+	RequestRate      float64       `yaml:"request_rate"`
+	RequestBurstSize int           `yaml:"request_burst_size"`
+	SamplesRate      float64       `yaml:"samples_rate"`
+	SamplesBurstSize int           `yaml:"samples_burst_size"`
+	RecheckPeriod    time.Duration `yaml:"recheck_period"`
+
+	MaxInflightRequests int           `yaml:"max_inflight_requests"`
+	MaxInflightBytes    int64         `yaml:"max_inflight_bytes"`
+	InflightRetryAfter  time.Duration `yaml:"inflight_retry_after"`
+
+	AdaptiveConcurrency AdaptiveConfig `yaml:"adaptive_concurrency"`
+}
+
+// RegisterFlags registers the rate limits flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.Float64Var(&cfg.RequestRate, "push.instance-limits.request-rate", 0, "Max push requests per second accepted by this instance, across all tenants. 0 to disable.")
+	f.IntVar(&cfg.RequestBurstSize, "push.instance-limits.request-burst-size", 0, "Burst size of the instance push requests rate limit, in requests. Must be at least 1 if the rate limit is enabled.")
+	f.Float64Var(&cfg.SamplesRate, "push.instance-limits.samples-rate", 0, "Max samples per second accepted by this instance, across all tenants. 0 to disable.")
+	f.IntVar(&cfg.SamplesBurstSize, "push.instance-limits.samples-burst-size", 0, "Burst size of the instance samples rate limit, in samples. Must be at least 1 if the rate limit is enabled, and should be at least the number of samples of the largest push requests, which are rejected otherwise.")
+	f.DurationVar(&cfg.RecheckPeriod, "push.rate-limits.recheck-period", 10*time.Second, "How frequently the per-tenant request rate limits are reloaded from the overrides.")
+	f.IntVar(&cfg.MaxInflightRequests, "push.instance-limits.max-inflight-requests", 0, "Max push requests being processed at the same time by this instance, across all tenants. 0 to disable.")
+	f.Int64Var(&cfg.MaxInflightBytes, "push.instance-limits.max-inflight-bytes", 0, "Max size in bytes of the push requests being processed at the same time by this instance, across all tenants. 0 to disable.")
+	f.DurationVar(&cfg.InflightRetryAfter, "push.instance-limits.inflight-retry-after", time.Second, "Retry-After returned to the push requests rejected because of the inflight limits or the adaptive concurrency limit.")
+	cfg.AdaptiveConcurrency.RegisterFlags(f)
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	// An empty token bucket rejects every request.
+	if cfg.RequestRate > 0 && cfg.RequestBurstSize < 1 {
+		return errInvalidRequestBurstSize
+	}
+	if cfg.SamplesRate > 0 && cfg.SamplesBurstSize < 1 {
+		return errInvalidSamplesBurstSize
+	}
+	if (cfg.MaxInflightRequests > 0 || cfg.MaxInflightBytes > 0 || cfg.AdaptiveConcurrency.Enabled) && cfg.InflightRetryAfter <= 0 {
+		return errInvalidInflightRetryAfter
+	}
+	return cfg.AdaptiveConcurrency.Validate()
+}
+
+// ErrorBody is the JSON body of the responses to rate limited requests.
+type ErrorBody struct {
+	Status     string  `json:"status"`
+	ErrorType  string  `json:"errorType"`
+	Error      string  `json:"error"`
+	Limit      string  `json:"limit"`
+	Scope      string  `json:"scope"`
+	Tenant     string  `json:"tenant,omitempty"`
+	Rate       float64 `json:"rate"`
+	Burst      int     `json:"burst"`
+	Max        int64   `json:"max,omitempty"`
+	RetryAfter int     `json:"retryAfterSeconds"`
+}
+
+// NewError returns the httpgrpc error returned to requests rejected by a rate limit, with
+// the Retry-After header set to how long n tokens take to be refilled. The limit is scoped
+// to the tenant if userID is set, to the instance otherwise.
+func NewError(limit, userID string, rate float64, burst, n int, msg string) error {
+	// Requests larger than the burst are never allowed, retrying once the bucket is full
+	// is the best they can do.
+	if burst > 0 && n > burst {
+		n = burst
+	}
+	retryAfter := time.Second
+	if rate > 0 {
+		retryAfter = time.Duration(float64(n) / rate * float64(time.Second))
+	}
+
+	scope := ScopeInstance
+	if userID != "" {
+		scope = ScopeTenant
+	}
+	return newError(ErrorBody{Limit: limit, Scope: scope, Tenant: userID, Rate: rate, Burst: burst, Error: msg}, retryAfter)
+}
+
+// newInflightError returns the httpgrpc error returned to requests rejected by an
+// instance inflight limit.
+func newInflightError(limit string, max int64, retryAfter time.Duration, msg string) error {
+	return newError(ErrorBody{Limit: limit, Scope: ScopeInstance, Max: max, Error: msg}, retryAfter)
+}
+
+// newError returns a 429 httpgrpc error with the Retry-After header, in seconds rounded up,
+// and a structured JSON body so that clients can tell which limit has been hit.
+func newError(body ErrorBody, retryAfter time.Duration) error {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	body.Status = "error"
+	body.ErrorType = "rate_limited"
+	body.RetryAfter = seconds
+	headers := []*httpgrpc.Header{{Key: "Retry-After", Values: []string{strconv.Itoa(seconds)}}}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return httpgrpc.ErrorFromHTTPResponse(&httpgrpc.HTTPResponse{
+			Code:    http.StatusTooManyRequests,
+			Headers: headers,
+			Body:    []byte(body.Error),
+		})
+	}
+
+	return httpgrpc.ErrorFromHTTPResponse(&httpgrpc.HTTPResponse{
+		Code:    http.StatusTooManyRequests,
+		Headers: append(headers, &httpgrpc.Header{Key: "Content-Type", Values: []string{"application/json"}}),
+		Body:    b,
+	})
+}
+
+// Limits is the subset of the per-tenant overrides used by the rate limiter. It's
+// implemented by overrides.Overrides.
+type Limits interface {
+	RequestRate(userID string) float64
+	RequestBurstSize(userID string) int
+}
+
+// Limiter enforces token-bucket rate limits on the push requests, both at the instance
+// level and per tenant, and the instance concurrency limits.
+type Limiter struct {
+	cfg            Config
+	limits         Limits
+	instanceLimits InstanceLimitsFn
+
+	instanceRequests  *limiter.RateLimiter
+	instanceSamples   *limiter.RateLimiter
+	instanceIngestion *limiter.RateLimiter
+	tenantRequests    *limiter.RateLimiter
+
+	inflightRequests atomic.Int64
+	inflightBytes    atomic.Int64
+
+	// adaptive is nil if the adaptive concurrency limit is disabled.
+	adaptive *adaptiveLimiter
+
+	rateLimited *prometheus.CounterVec
+}
+
+// NewLimiter makes a new Limiter. The instance limits are reloaded on each request, and
+// default to the inflight limits of the config if nil.
+func NewLimiter(cfg Config, limits Limits, instanceLimits InstanceLimitsFn, reg prometheus.Registerer) *Limiter {
+	if instanceLimits == nil {
+		instanceLimits = func() InstanceLimits {
+			return InstanceLimits{MaxInflightPushRequests: int64(cfg.MaxInflightRequests), MaxInflightPushBytes: cfg.MaxInflightBytes}
+		}
+	}
+
+	l := &Limiter{
+		cfg:               cfg,
+		limits:            limits,
+		instanceLimits:    instanceLimits,
+		instanceRequests:  limiter.NewRateLimiter(staticStrategy{rate: cfg.RequestRate, burst: cfg.RequestBurstSize}, cfg.RecheckPeriod),
+		instanceSamples:   limiter.NewRateLimiter(staticStrategy{rate: cfg.SamplesRate, burst: cfg.SamplesBurstSize}, cfg.RecheckPeriod),
+		instanceIngestion: limiter.NewRateLimiter(instanceIngestionStrategy{limits: instanceLimits}, cfg.RecheckPeriod),
+		tenantRequests:    limiter.NewRateLimiter(tenantRequestsStrategy{limits: limits}, cfg.RecheckPeriod),
+		rateLimited: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_push_rate_limited_requests_total",
+			Help: "Total number of push requests rejected because a rate limit was reached, by limit.",
+		}, []string{"limit"}),
+	}
+
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "cortex_push_inflight_requests",
+		Help: "Number of push requests being processed by this instance.",
+	}, func() float64 { return float64(l.inflightRequests.Load()) })
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "cortex_push_inflight_bytes",
+		Help: "Size in bytes of the push requests being processed by this instance.",
+	}, func() float64 { return float64(l.inflightBytes.Load()) })
+
+	if cfg.AdaptiveConcurrency.Enabled {
+		l.adaptive = newAdaptiveLimiter(cfg.AdaptiveConcurrency, reg)
+	}
+	return l
+}
+
+// PushMiddleware returns the push.Middleware enforcing the rate limits.
+func (l *Limiter) PushMiddleware() push.Middleware {
+	return func(next push.Func) push.Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			userID, err := tenant.TenantID(ctx)
+			if err != nil {
+				return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+			}
+
+			if err := l.allow(userID, req, time.Now()); err != nil {
+				return nil, err
+			}
+
+			size := int64(req.Size())
+			defer l.inflightRequests.Dec()
+			defer l.inflightBytes.Sub(size)
+			if err := l.acquire(size); err != nil {
+				return nil, err
+			}
+			if l.adaptive == nil {
+				return next(ctx, req)
+			}
+
+			inflight, ok := l.adaptive.acquire()
+			if !ok {
+				l.rateLimited.WithLabelValues(InstanceAdaptiveConcurrency).Inc()
+				return nil, newInflightError(InstanceAdaptiveConcurrency, int64(inflight), l.cfg.InflightRetryAfter, fmt.Sprintf("instance adaptive concurrency limit (%d) reached", inflight))
+			}
+			start := time.Now()
+			resp, err := next(ctx, req)
+			l.adaptive.release(inflight, time.Since(start), isOverloadError(err))
+			return resp, err
+		}
+	}
+}
+
+func (l *Limiter) allow(userID string, req *cortexpb.WriteRequest, now time.Time) error {
+	if l.cfg.RequestRate > 0 && !l.instanceRequests.AllowN(now, instanceKey, 1) {
+		l.rateLimited.WithLabelValues(InstanceRequestRate).Inc()
+		return NewError(InstanceRequestRate, "", l.cfg.RequestRate, l.cfg.RequestBurstSize, 1, fmt.Sprintf("instance push requests rate limit (%v/s) exceeded", l.cfg.RequestRate))
+	}
+
+	if rate := l.limits.RequestRate(userID); rate > 0 && !l.tenantRequests.AllowN(now, userID, 1) {
+		l.rateLimited.WithLabelValues(TenantRequestRate).Inc()
+		return NewError(TenantRequestRate, userID, rate, l.limits.RequestBurstSize(userID), 1, fmt.Sprintf("push requests rate limit (%v/s) exceeded", rate))
+	}
+
+	maxIngestionRate := l.instanceLimits().MaxIngestionRate
+	if l.cfg.SamplesRate <= 0 && maxIngestionRate <= 0 {
+		return nil
+	}
+
+	samples := 0
+	for _, ts := range req.Timeseries {
+		samples += len(ts.Samples)
+	}
+
+	if l.cfg.SamplesRate > 0 && !l.instanceSamples.AllowN(now, instanceKey, samples) {
+		l.rateLimited.WithLabelValues(InstanceSamplesRate).Inc()
+		return NewError(InstanceSamplesRate, "", l.cfg.SamplesRate, l.cfg.SamplesBurstSize, samples, fmt.Sprintf("instance samples rate limit (%v/s) exceeded while adding %d samples", l.cfg.SamplesRate, samples))
+	}
+
+	if maxIngestionRate > 0 && !l.instanceIngestion.AllowN(now, instanceKey, samples) {
+		l.rateLimited.WithLabelValues(InstanceIngestionRate).Inc()
+		return NewError(InstanceIngestionRate, "", maxIngestionRate, int(math.Ceil(maxIngestionRate)), samples, fmt.Sprintf("instance max ingestion rate (%v/s) exceeded while adding %d samples", maxIngestionRate, samples))
+	}
+
+	return nil
+}
+
+// acquire accounts the request against the inflight limits. The request is accounted even
+// if rejected, the caller releases it in any case.
+func (l *Limiter) acquire(size int64) error {
+	requests := l.inflightRequests.Inc()
+	bytes := l.inflightBytes.Add(size)
+	limits := l.instanceLimits()
+
+	if max := limits.MaxInflightPushRequests; max > 0 && requests > max {
+		l.rateLimited.WithLabelValues(InstanceInflightRequests).Inc()
+		return newInflightError(InstanceInflightRequests, max, l.cfg.InflightRetryAfter, fmt.Sprintf("instance inflight push requests limit (%d) reached", max))
+	}
+	if max := limits.MaxInflightPushBytes; max > 0 && bytes > max {
+		l.rateLimited.WithLabelValues(InstanceInflightBytes).Inc()
+		return newInflightError(InstanceInflightBytes, max, l.cfg.InflightRetryAfter, fmt.Sprintf("instance inflight push requests size limit (%d bytes) reached while adding %d bytes", max, size))
+	}
+	return nil
+}
+
+// staticStrategy is a limiter.RateLimiterStrategy with a fixed rate, used for the
+// instance-level limits.
+type staticStrategy struct {
+	rate  float64
+	burst int
+}
+
+func (s staticStrategy) Limit(string) float64 { return s.rate }
+func (s staticStrategy) Burst(string) int     { return s.burst }
+
+// tenantRequestsStrategy reads the per-tenant requests rate from the overrides.
+type tenantRequestsStrategy struct {
+	limits Limits
+}
+
+func (s tenantRequestsStrategy) Limit(userID string) float64 { return s.limits.RequestRate(userID) }
+func (s tenantRequestsStrategy) Burst(userID string) int     { return s.limits.RequestBurstSize(userID) }
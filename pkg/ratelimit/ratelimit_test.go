@@ -0,0 +1,210 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *Config)
+		expected error
+	}{
+		"default config": {
+			setup:    func(*Config) {},
+			expected: nil,
+		},
+		"request rate with burst": {
+			setup: func(cfg *Config) {
+				cfg.RequestRate = 10
+				cfg.RequestBurstSize = 10
+			},
+			expected: nil,
+		},
+		"request rate without burst": {
+			setup:    func(cfg *Config) { cfg.RequestRate = 10 },
+			expected: errInvalidRequestBurstSize,
+		},
+		"samples rate without burst": {
+			setup:    func(cfg *Config) { cfg.SamplesRate = 1000 },
+			expected: errInvalidSamplesBurstSize,
+		},
+		"inflight limit without retry after": {
+			setup: func(cfg *Config) {
+				cfg.MaxInflightRequests = 10
+				cfg.InflightRetryAfter = 0
+			},
+			expected: errInvalidInflightRetryAfter,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+			assert.ErrorIs(t, cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+type limitsMock map[string]float64
+
+func (m limitsMock) RequestRate(userID string) float64  { return m[userID] }
+func (m limitsMock) RequestBurstSize(userID string) int { return int(m[userID]) }
+
+func TestLimiter_PushMiddleware(t *testing.T) {
+	request := func(samples int) *cortexpb.WriteRequest {
+		return &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+			Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: "up"}},
+			Samples: make([]cortexpb.Sample, samples),
+		}}}}
+	}
+
+	tests := map[string]struct {
+		cfg                Config
+		limits             limitsMock
+		requests           []*cortexpb.WriteRequest
+		expectedLimit      string
+		expectedScope      string
+		expectedRetryAfter string
+	}{
+		"no limits": {
+			limits:   limitsMock{},
+			requests: []*cortexpb.WriteRequest{request(1), request(1), request(1)},
+		},
+		"instance request rate": {
+			cfg:                Config{RequestRate: 0.001, RequestBurstSize: 2},
+			limits:             limitsMock{},
+			requests:           []*cortexpb.WriteRequest{request(1), request(1), request(1)},
+			expectedLimit:      InstanceRequestRate,
+			expectedScope:      ScopeInstance,
+			expectedRetryAfter: "1000",
+		},
+		"instance samples rate": {
+			cfg:                Config{SamplesRate: 0.001, SamplesBurstSize: 10},
+			limits:             limitsMock{},
+			requests:           []*cortexpb.WriteRequest{request(5), request(5), request(1)},
+			expectedLimit:      InstanceSamplesRate,
+			expectedScope:      ScopeInstance,
+			expectedRetryAfter: "1000",
+		},
+		"tenant request rate": {
+			limits:             limitsMock{"user-1": 1},
+			requests:           []*cortexpb.WriteRequest{request(1), request(1)},
+			expectedLimit:      TenantRequestRate,
+			expectedScope:      ScopeTenant,
+			expectedRetryAfter: "1",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc.cfg.RecheckPeriod = time.Minute
+			l := NewLimiter(tc.cfg, tc.limits, nil, nil)
+			f := l.PushMiddleware()(func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				return &cortexpb.WriteResponse{}, nil
+			})
+
+			var err error
+			for _, req := range tc.requests {
+				if _, err = f(user.InjectOrgID(context.Background(), "user-1"), req); err != nil {
+					break
+				}
+			}
+
+			if tc.expectedLimit == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			resp, ok := httpgrpc.HTTPResponseFromError(err)
+			require.True(t, ok)
+			assert.Equal(t, int32(http.StatusTooManyRequests), resp.Code)
+
+			body := ErrorBody{}
+			require.NoError(t, json.Unmarshal(resp.Body, &body))
+			assert.Equal(t, tc.expectedLimit, body.Limit)
+			assert.Equal(t, "rate_limited", body.ErrorType)
+			assert.Equal(t, tc.expectedScope, body.Scope)
+			assert.Equal(t, tc.expectedRetryAfter, strconv.Itoa(body.RetryAfter))
+			assert.Contains(t, resp.Headers, &httpgrpc.Header{Key: "Retry-After", Values: []string{tc.expectedRetryAfter}})
+		})
+	}
+}
+
+func TestLimiter_InflightLimits(t *testing.T) {
+	req := &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+		Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: "up"}},
+		Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}},
+	}}}}
+
+	tests := map[string]struct {
+		cfg           Config
+		expectedLimit string
+		expectedMax   int64
+	}{
+		"inflight requests": {
+			cfg:           Config{MaxInflightRequests: 1, InflightRetryAfter: 2 * time.Second},
+			expectedLimit: InstanceInflightRequests,
+			expectedMax:   1,
+		},
+		"inflight bytes": {
+			cfg:           Config{MaxInflightBytes: int64(req.Size()) + 1, InflightRetryAfter: 2 * time.Second},
+			expectedLimit: InstanceInflightBytes,
+			expectedMax:   int64(req.Size()) + 1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc.cfg.RecheckPeriod = time.Minute
+			l := NewLimiter(tc.cfg, limitsMock{}, nil, nil)
+
+			started, release := make(chan struct{}), make(chan struct{})
+			f := l.PushMiddleware()(func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				close(started)
+				<-release
+				return &cortexpb.WriteResponse{}, nil
+			})
+			ctx := user.InjectOrgID(context.Background(), "user-1")
+
+			done := make(chan error)
+			go func() {
+				_, err := f(ctx, req)
+				done <- err
+			}()
+			<-started
+
+			_, err := f(ctx, req)
+			resp, ok := httpgrpc.HTTPResponseFromError(err)
+			require.True(t, ok)
+			assert.Equal(t, int32(http.StatusTooManyRequests), resp.Code)
+			assert.Contains(t, resp.Headers, &httpgrpc.Header{Key: "Retry-After", Values: []string{"2"}})
+
+			body := ErrorBody{}
+			require.NoError(t, json.Unmarshal(resp.Body, &body))
+			assert.Equal(t, tc.expectedLimit, body.Limit)
+			assert.Equal(t, ScopeInstance, body.Scope)
+			assert.Equal(t, tc.expectedMax, body.Max)
+
+			// The rejected request is released, and the inflight one once done.
+			close(release)
+			require.NoError(t, <-done)
+			assert.Equal(t, int64(0), l.inflightRequests.Load())
+			assert.Equal(t, int64(0), l.inflightBytes.Load())
+		})
+	}
+}
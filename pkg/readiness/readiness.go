@@ -0,0 +1,151 @@
+package readiness
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+// The checks the readiness is composed of. The services check, failing until all the
+// modules are running, is always required.
+const (
+	CheckServices  = "services"
+	CheckRing      = "ring"
+	CheckWALReplay = "wal-replay"
+	CheckStorage   = "storage"
+)
+
+var (
+	errInvalidStorageCheckInterval = errors.New("the storage check interval must be greater than 0")
+	errInvalidStorageCheckTimeout  = errors.New("the storage check timeout must be greater than 0")
+)
+
+// Config holds the configuration of the readiness.
+type Config struct {
+	RequiredChecks       flagext.StringSliceCSV `yaml:"required_checks"`
+	StorageCheckInterval time.Duration          `yaml:"storage_check_interval"`
+	StorageCheckTimeout  time.Duration          `yaml:"storage_check_timeout"`
+}
+
+// RegisterFlags registers the readiness flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	cfg.RequiredChecks = []string{CheckRing, CheckWALReplay, CheckStorage}
+	f.Var(&cfg.RequiredChecks, "readiness.required-checks", "Comma-separated checks, besides all the modules running, required for the instance to be ready: ring, for the ingester to be ACTIVE in the ring, wal-replay, for the WAL to be replayed, and storage, for the bucket to be reachable. Leaving storage out keeps the instance ready, ingesting into the WAL, while the bucket is degraded. The checks not required are still reported by /ready.")
+	f.DurationVar(&cfg.StorageCheckInterval, "readiness.storage-check-interval", 30*time.Second, "How frequently the bucket is checked to be reachable, for the storage check.")
+	f.DurationVar(&cfg.StorageCheckTimeout, "readiness.storage-check-timeout", 10*time.Second, "Timeout of each storage check.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	for _, name := range cfg.RequiredChecks {
+		switch name {
+		case CheckServices, CheckRing, CheckWALReplay, CheckStorage:
+		default:
+			return fmt.Errorf("unknown readiness check %q", name)
+		}
+	}
+	if cfg.StorageCheckInterval <= 0 {
+		return errInvalidStorageCheckInterval
+	}
+	if cfg.StorageCheckTimeout <= 0 {
+		return errInvalidStorageCheckTimeout
+	}
+	return nil
+}
+
+// CheckFunc returns an error if the instance isn't ready as far as the check is concerned.
+type CheckFunc func(ctx context.Context) error
+
+// Result is the result of a check.
+type Result struct {
+	Name     string
+	Required bool
+	Err      error
+}
+
+type check struct {
+	name string
+	fn   CheckFunc
+}
+
+// Handler composes the registered checks into the readiness of the instance, which is ready
+// if all the required checks pass. The required checks not registered, because the
+// component they check isn't running, are skipped.
+type Handler struct {
+	required map[string]bool
+
+	mtx    sync.RWMutex
+	checks []check
+}
+
+// NewHandler makes a new Handler.
+func NewHandler(cfg Config) *Handler {
+	required := map[string]bool{CheckServices: true}
+	for _, name := range cfg.RequiredChecks {
+		required[name] = true
+	}
+	return &Handler{required: required}
+}
+
+// Register registers a check, run in order of registration.
+func (h *Handler) Register(name string, fn CheckFunc) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.checks = append(h.checks, check{name: name, fn: fn})
+}
+
+// Check runs the checks, and returns their results and whether the instance is ready.
+func (h *Handler) Check(ctx context.Context) ([]Result, bool) {
+	h.mtx.RLock()
+	checks := h.checks
+	h.mtx.RUnlock()
+
+	ready := true
+	results := make([]Result, 0, len(checks))
+	for _, c := range checks {
+		res := Result{Name: c.name, Required: h.required[c.name], Err: c.fn(ctx)}
+		if res.Err != nil && res.Required {
+			ready = false
+		}
+		results = append(results, res)
+	}
+	return results, ready
+}
+
+// ServeHTTP returns 200 if the instance is ready and 503 otherwise, with the result of
+// each check in the body.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	results, ready := h.Check(r.Context())
+
+	body := bytes.Buffer{}
+	status := http.StatusOK
+	if ready {
+		body.WriteString("ready\n")
+	} else {
+		body.WriteString("not ready\n")
+		status = http.StatusServiceUnavailable
+	}
+	for _, res := range results {
+		result := "ok"
+		if res.Err != nil {
+			result = res.Err.Error()
+		}
+		if !res.Required {
+			result += " (not required)"
+		}
+		fmt.Fprintf(&body, "%s: %s\n", res.Name, result)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_, _ = w.Write(body.Bytes())
+}
@@ -0,0 +1,116 @@
+package readiness
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		cfg      func(*Config)
+		expected string
+	}{
+		"default": {
+			cfg: func(*Config) {},
+		},
+		"storage not required": {
+			cfg: func(cfg *Config) { cfg.RequiredChecks = []string{CheckRing, CheckWALReplay} },
+		},
+		"unknown check": {
+			cfg:      func(cfg *Config) { cfg.RequiredChecks = []string{CheckRing, "disk"} },
+			expected: `unknown readiness check "disk"`,
+		},
+		"invalid storage check interval": {
+			cfg:      func(cfg *Config) { cfg.StorageCheckInterval = 0 },
+			expected: errInvalidStorageCheckInterval.Error(),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			cfg.RegisterFlags(flag.NewFlagSet("", flag.PanicOnError))
+			tc.cfg(&cfg)
+			if tc.expected == "" {
+				assert.NoError(t, cfg.Validate())
+			} else {
+				assert.EqualError(t, cfg.Validate(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var storageErr error
+	h := NewHandler(Config{RequiredChecks: []string{CheckRing}})
+	h.Register(CheckServices, func(context.Context) error { return nil })
+	h.Register(CheckRing, func(context.Context) error { return nil })
+	h.Register(CheckStorage, func(context.Context) error { return storageErr })
+
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		return rec
+	}
+
+	rec := serve()
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ready\nservices: ok\nring: ok\nstorage: ok (not required)\n", rec.Body.String())
+
+	// The storage isn't required, so the instance stays ready while the storage is degraded.
+	storageErr = errors.New("bucket unreachable")
+	rec = serve()
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ready\nservices: ok\nring: ok\nstorage: bucket unreachable (not required)\n", rec.Body.String())
+
+	h.Register(CheckWALReplay, func(context.Context) error { return errors.New("replaying the WAL") })
+	results, ready := h.Check(context.Background())
+	assert.True(t, ready)
+	assert.Len(t, results, 4)
+
+	h = NewHandler(Config{RequiredChecks: []string{CheckStorage}})
+	h.Register(CheckStorage, func(context.Context) error { return storageErr })
+	rec = serve()
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "not ready\nstorage: bucket unreachable\n", rec.Body.String())
+}
+
+func TestStorageProbe(t *testing.T) {
+	ctx := context.Background()
+	bkt := &failingBucket{Bucket: objstore.NewInMemBucket()}
+	p := NewStorageProbe(Config{StorageCheckInterval: time.Hour, StorageCheckTimeout: time.Second}, bkt, log.NewNopLogger())
+	assert.Equal(t, errStorageNotChecked, p.Check(ctx))
+
+	require.NoError(t, p.iteration(ctx))
+	assert.NoError(t, p.Check(ctx))
+
+	bkt.err = errors.New("connection refused")
+	require.NoError(t, p.iteration(ctx))
+	assert.EqualError(t, p.Check(ctx), "bucket unreachable: connection refused")
+
+	bkt.err = nil
+	require.NoError(t, p.iteration(ctx))
+	assert.NoError(t, p.Check(ctx))
+}
+
+type failingBucket struct {
+	objstore.Bucket
+	err error
+}
+
+func (b *failingBucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.Bucket.Iter(ctx, dir, f, options...)
+}
@@ -0,0 +1,79 @@
+package readiness
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+var errStorageNotChecked = errors.New("bucket not checked yet")
+
+// StorageProbe periodically checks that the bucket is reachable, so that the storage check
+// of the readiness, run on each probe of /ready, doesn't hit the bucket.
+type StorageProbe struct {
+	services.Service
+
+	bkt     objstore.BucketReader
+	timeout time.Duration
+	logger  log.Logger
+
+	mtx sync.RWMutex
+	err error
+}
+
+// NewStorageProbe makes a new StorageProbe.
+func NewStorageProbe(cfg Config, bkt objstore.BucketReader, logger log.Logger) *StorageProbe {
+	p := &StorageProbe{
+		bkt:     bkt,
+		timeout: cfg.StorageCheckTimeout,
+		logger:  logger,
+		err:     errStorageNotChecked,
+	}
+	p.Service = services.NewTimerService(cfg.StorageCheckInterval, p.starting, p.iteration, nil)
+	return p
+}
+
+func (p *StorageProbe) starting(ctx context.Context) error {
+	// A failure is reported by the check, not fatal.
+	return p.iteration(ctx)
+}
+
+func (p *StorageProbe) iteration(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	errFound := errors.New("found")
+	err := p.bkt.Iter(ctx, "", func(string) error { return errFound })
+	if errors.Is(err, errFound) {
+		err = nil
+	}
+	if err != nil {
+		err = errors.Wrap(err, "bucket unreachable")
+	}
+
+	p.mtx.Lock()
+	// Only the changes are logged.
+	switch {
+	case err != nil && (p.err == nil || p.err == errStorageNotChecked):
+		level.Warn(p.logger).Log("msg", "storage degraded", "err", err)
+	case err == nil && p.err != nil && p.err != errStorageNotChecked:
+		level.Info(p.logger).Log("msg", "storage recovered")
+	}
+	p.err = err
+	p.mtx.Unlock()
+	return nil
+}
+
+// Check returns the error of the last check of the bucket, if any.
+func (p *StorageProbe) Check(context.Context) error {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	return p.err
+}
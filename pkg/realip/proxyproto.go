@@ -0,0 +1,222 @@
+package realip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"objectstorage/pkg/ipfilter"
+)
+
+const (
+	// The longest v1 header, with IPv6 addresses, including the CRLF.
+	maxV1HeaderLen = 107
+
+	v2HeaderLen  = 16
+	v2CmdLocal   = 0x0
+	v2CmdProxy   = 0x1
+	v2FamilyTCP4 = 0x11
+	v2FamilyTCP6 = 0x21
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errInvalidHeader = errors.New("invalid PROXY protocol header")
+)
+
+// proxyListener reads the PROXY protocol header of the connections accepted from the
+// trusted sources. The header is read by the goroutine serving the connection, on the first
+// read or call to RemoteAddr, so that a slow client doesn't block the accept loop.
+type proxyListener struct {
+	net.Listener
+
+	trusted []*net.IPNet
+	timeout time.Duration
+	invalid prometheus.Counter
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !ipfilter.Contains(l.trusted, ipfilter.HostIP(c.RemoteAddr().String())) {
+		return c, nil
+	}
+	return &proxyConn{Conn: c, reader: bufio.NewReader(c), timeout: l.timeout, invalid: l.invalid}, nil
+}
+
+type proxyConn struct {
+	net.Conn
+
+	reader  *bufio.Reader
+	timeout time.Duration
+	invalid prometheus.Counter
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the source address of the header, if any, or the address of the peer.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) readHeader() {
+	if c.timeout > 0 {
+		if c.err = c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); c.err != nil {
+			return
+		}
+		defer func() {
+			if err := c.Conn.SetReadDeadline(time.Time{}); err != nil && c.err == nil {
+				c.err = err
+			}
+		}()
+	}
+
+	c.remote, c.err = readHeader(c.reader)
+	if errors.Is(c.err, errInvalidHeader) {
+		c.invalid.Inc()
+	}
+}
+
+// readHeader reads the v1 or v2 header, and returns its source address. It returns a nil
+// address if the connection doesn't start with a header, or if the header doesn't carry
+// the address of the client, such as for the health checks of the load balancer.
+func readHeader(r *bufio.Reader) (net.Addr, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, ignoreEOF(err)
+	}
+
+	switch first[0] {
+	case v1Prefix[0]:
+		// The HTTP methods starting with P are told apart by the rest of the prefix.
+		prefix, err := r.Peek(len(v1Prefix))
+		if err != nil || !bytes.Equal(prefix, v1Prefix) {
+			return nil, ignoreEOF(err)
+		}
+		return readV1Header(r)
+	case v2Signature[0]:
+		signature, err := r.Peek(len(v2Signature))
+		if err != nil || !bytes.Equal(signature, v2Signature) {
+			return nil, ignoreEOF(err)
+		}
+		return readV2Header(r)
+	}
+	return nil, nil
+}
+
+// readV1Header reads the human-readable header, such as "PROXY TCP4 192.0.2.1 192.0.2.2
+// 56324 443\r\n".
+func readV1Header(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= maxV1HeaderLen {
+			return nil, errors.Wrap(errInvalidHeader, "v1 header too long")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.Wrap(errInvalidHeader, "v1 header not terminated by CRLF")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.Wrapf(errInvalidHeader, "malformed v1 header %q", line)
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, errors.Wrapf(errInvalidHeader, "malformed v1 header %q", line)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readV2Header reads the binary header: the signature, the version and command, the address
+// family, the length of the addresses and TLVs, then the addresses and TLVs.
+func readV2Header(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, v2HeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, errors.Wrapf(errInvalidHeader, "unsupported version %d", header[12]>>4)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	switch header[12] & 0xf {
+	case v2CmdLocal:
+		return nil, nil
+	case v2CmdProxy:
+	default:
+		return nil, errors.Wrapf(errInvalidHeader, "unsupported command %d", header[12]&0xf)
+	}
+
+	// The addresses are followed by the source and destination ports.
+	var ipLen int
+	switch header[13] {
+	case v2FamilyTCP4:
+		ipLen = net.IPv4len
+	case v2FamilyTCP6:
+		ipLen = net.IPv6len
+	default:
+		// The address isn't a TCP one, such as for a UNIX socket.
+		return nil, nil
+	}
+	if len(payload) < 2*ipLen+4 {
+		return nil, errors.Wrap(errInvalidHeader, "v2 addresses too short")
+	}
+	return &net.TCPAddr{
+		IP:   net.IP(payload[:ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen:])),
+	}, nil
+}
+
+// ignoreEOF ignores the connections closed before sending enough bytes to tell if they
+// start with a header: the error is returned by the next read anyway.
+func ignoreEOF(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
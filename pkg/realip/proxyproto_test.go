@@ -0,0 +1,191 @@
+package realip
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func v2Header(cmd, family byte, payload []byte) string {
+	header := append([]byte(nil), v2Signature...)
+	header = append(header, 0x20|cmd, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(payload)))
+	return string(append(header, payload...))
+}
+
+func TestReadHeader(t *testing.T) {
+	v4 := []byte{192, 0, 2, 1, 192, 0, 2, 2, 0xdc, 0x04, 0x01, 0xbb}
+	v6 := append(append(append([]byte(nil), net.ParseIP("2001:db8::1")...), net.ParseIP("2001:db8::2")...), 0xdc, 0x04, 0x01, 0xbb)
+
+	tests := map[string]struct {
+		input    string
+		expected string
+		err      string
+	}{
+		"no header": {
+			input: "GET / HTTP/1.1\r\n\r\n",
+		},
+		"no header, method starting with P": {
+			input: "POST / HTTP/1.1\r\n\r\n",
+		},
+		"gRPC preface": {
+			input: "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n",
+		},
+		"v1 TCP4": {
+			input:    "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nGET / HTTP/1.1\r\n\r\n",
+			expected: "192.0.2.1:56324",
+		},
+		"v1 TCP6": {
+			input:    "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\nGET / HTTP/1.1\r\n\r\n",
+			expected: "[2001:db8::1]:56324",
+		},
+		"v1 UNKNOWN": {
+			input: "PROXY UNKNOWN\r\nGET / HTTP/1.1\r\n\r\n",
+		},
+		"v1 mismatching family": {
+			input: "PROXY TCP6 192.0.2.1 192.0.2.2 56324 443\r\n",
+			err:   `malformed v1 header "PROXY TCP6 192.0.2.1 192.0.2.2 56324 443\r\n": invalid PROXY protocol header`,
+		},
+		"v1 not terminated by CRLF": {
+			input: "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\n",
+			err:   "v1 header not terminated by CRLF: invalid PROXY protocol header",
+		},
+		"v1 too long": {
+			input: "PROXY TCP4 " + strings.Repeat("1", maxV1HeaderLen) + "\r\n",
+			err:   "v1 header too long: invalid PROXY protocol header",
+		},
+		"v2 TCP4": {
+			input:    v2Header(v2CmdProxy, v2FamilyTCP4, v4) + "GET / HTTP/1.1\r\n\r\n",
+			expected: "192.0.2.1:56324",
+		},
+		"v2 TCP6 with TLVs": {
+			input:    v2Header(v2CmdProxy, v2FamilyTCP6, append(v6, 0x04, 0x00, 0x01, 0x00)) + "GET / HTTP/1.1\r\n\r\n",
+			expected: "[2001:db8::1]:56324",
+		},
+		"v2 LOCAL": {
+			input: v2Header(v2CmdLocal, 0x00, nil) + "GET / HTTP/1.1\r\n\r\n",
+		},
+		"v2 addresses too short": {
+			input: v2Header(v2CmdProxy, v2FamilyTCP4, v4[:8]),
+			err:   "v2 addresses too short: invalid PROXY protocol header",
+		},
+		"v2 unsupported command": {
+			input: v2Header(0x2, v2FamilyTCP4, v4),
+			err:   "unsupported command 2: invalid PROXY protocol header",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tc.input))
+			addr, err := readHeader(r)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			if tc.expected == "" {
+				assert.Nil(t, addr)
+			} else {
+				require.NotNil(t, addr)
+				assert.Equal(t, tc.expected, addr.String())
+			}
+
+			// The request following the header is left to be read.
+			rest, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.True(t, strings.HasSuffix(tc.input, string(rest)))
+			assert.True(t, strings.HasSuffix(string(rest), "\r\n\r\n"))
+		})
+	}
+}
+
+func TestProxyProtocol_WrapListener(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	p, err := NewProxyProtocol(Config{ProxyProtocolTrustedCIDRs: []string{"127.0.0.1"}, ProxyProtocolHeaderTimeout: time.Second}, reg)
+	require.NoError(t, err)
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l := p.WrapListener("http", inner)
+	defer l.Close()
+
+	accept := func(input string) (net.Conn, string) {
+		client, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = client.Close() })
+		_, err = client.Write([]byte(input))
+		require.NoError(t, err)
+
+		conn, err := l.Accept()
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		return conn, client.LocalAddr().String()
+	}
+
+	conn, _ := accept("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nping")
+	assert.Equal(t, "192.0.2.1:56324", conn.RemoteAddr().String())
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+
+	// The connections without a header are served with the address of the peer.
+	conn, peer := accept("ping")
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+	assert.Equal(t, peer, conn.RemoteAddr().String())
+
+	conn, _ = accept("PROXY TCP4 invalid\r\nping")
+	_, err = conn.Read(buf)
+	assert.ErrorIs(t, err, errInvalidHeader)
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_proxy_protocol_invalid_headers_total Total number of connections closed because of an invalid PROXY protocol header, by listener.
+		# TYPE cortex_proxy_protocol_invalid_headers_total counter
+		cortex_proxy_protocol_invalid_headers_total{listener="http"} 1
+	`)))
+}
+
+func TestProxyProtocol_WrapListener_ShouldIgnoreTheHeaderFromUntrustedSources(t *testing.T) {
+	for name, trusted := range map[string][]string{"other sources": {"192.0.2.0/24"}, "no trusted source": nil} {
+		t.Run(name, func(t *testing.T) {
+			testProxyProtocolIgnoresTheHeader(t, trusted)
+		})
+	}
+}
+
+func testProxyProtocolIgnoresTheHeader(t *testing.T, trusted []string) {
+	p, err := NewProxyProtocol(Config{ProxyProtocolTrustedCIDRs: trusted, ProxyProtocolHeaderTimeout: time.Second}, nil)
+	require.NoError(t, err)
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l := p.WrapListener("http", inner)
+	defer l.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"))
+	require.NoError(t, err)
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, client.LocalAddr().String(), conn.RemoteAddr().String())
+
+	buf := make([]byte, len(v1Prefix))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, v1Prefix, buf)
+}
@@ -0,0 +1,225 @@
+package realip
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/middleware"
+	"github.com/weaveworks/common/server"
+	"google.golang.org/grpc"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/ipfilter"
+)
+
+var (
+	errInvalidHeaderTimeout = errors.New("the PROXY protocol header timeout must be greater than 0")
+	errMissingTrustedCIDRs  = errors.New("the PROXY protocol trusted CIDRs are required if the PROXY protocol is enabled")
+	errInvalidListenPort    = errors.New("the PROXY protocol HTTP and gRPC listen ports must be greater than 0")
+)
+
+// Config holds the configuration of the client address resolution, when the servers are
+// behind load balancers or proxies.
+type Config struct {
+	ProxyProtocolEnabled        bool                   `yaml:"proxy_protocol_enabled"`
+	ProxyProtocolHTTPListenPort int                    `yaml:"proxy_protocol_http_listen_port"`
+	ProxyProtocolGRPCListenPort int                    `yaml:"proxy_protocol_grpc_listen_port"`
+	ProxyProtocolTrustedCIDRs   flagext.StringSliceCSV `yaml:"proxy_protocol_trusted_cidrs"`
+	ProxyProtocolHeaderTimeout  time.Duration          `yaml:"proxy_protocol_header_timeout"`
+	TrustedForwarderCIDRs       flagext.StringSliceCSV `yaml:"trusted_forwarder_cidrs"`
+}
+
+// RegisterFlags registers the client address resolution flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.ProxyProtocolEnabled, "server.proxy-protocol-enabled", false, "True to serve the HTTP and gRPC servers on additional listeners accepting the PROXY protocol v1 and v2 headers, sent by L4 load balancers to pass the address of the client. The connections without a header are served as is.")
+	f.IntVar(&cfg.ProxyProtocolHTTPListenPort, "server.proxy-protocol-http-listen-port", 9080, "Port of the HTTP listener accepting the PROXY protocol headers, on the HTTP listen address.")
+	f.IntVar(&cfg.ProxyProtocolGRPCListenPort, "server.proxy-protocol-grpc-listen-port", 9096, "Port of the gRPC listener accepting the PROXY protocol headers, on the gRPC listen address.")
+	f.Var(&cfg.ProxyProtocolTrustedCIDRs, "server.proxy-protocol-trusted-cidrs", "Comma-separated list of CIDRs or IP addresses of the load balancers the PROXY protocol headers are accepted from. The headers are not read from the connections of other sources. Required if the PROXY protocol is enabled.")
+	f.DurationVar(&cfg.ProxyProtocolHeaderTimeout, "server.proxy-protocol-header-timeout", 5*time.Second, "Maximum time to wait for the PROXY protocol header of a new connection.")
+	f.Var(&cfg.TrustedForwarderCIDRs, "server.trusted-forwarder-cidrs", "Comma-separated list of CIDRs or IP addresses of the HTTP proxies the X-Forwarded-For header is honored from, to get the address of the client. Empty to ignore the header.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if _, err := ipfilter.ParseCIDRs(cfg.ProxyProtocolTrustedCIDRs); err != nil {
+		return err
+	}
+	if _, err := ipfilter.ParseCIDRs(cfg.TrustedForwarderCIDRs); err != nil {
+		return err
+	}
+	if !cfg.ProxyProtocolEnabled {
+		return nil
+	}
+	if len(cfg.ProxyProtocolTrustedCIDRs) == 0 {
+		return errMissingTrustedCIDRs
+	}
+	if cfg.ProxyProtocolHTTPListenPort <= 0 || cfg.ProxyProtocolGRPCListenPort <= 0 {
+		return errInvalidListenPort
+	}
+	if cfg.ProxyProtocolHeaderTimeout <= 0 {
+		return errInvalidHeaderTimeout
+	}
+	return nil
+}
+
+// ProxyProtocol wraps the listeners to read the PROXY protocol header of the connections, so
+// that the servers, and the source address filters, see the address of the client instead of
+// the one of the load balancer.
+type ProxyProtocol struct {
+	cfg     Config
+	trusted []*net.IPNet
+	timeout time.Duration
+
+	invalid *prometheus.CounterVec
+}
+
+// NewProxyProtocol makes a new ProxyProtocol. The config must be valid.
+func NewProxyProtocol(cfg Config, reg prometheus.Registerer) (*ProxyProtocol, error) {
+	trusted, err := ipfilter.ParseCIDRs(cfg.ProxyProtocolTrustedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	return &ProxyProtocol{
+		cfg:     cfg,
+		trusted: trusted,
+		timeout: cfg.ProxyProtocolHeaderTimeout,
+		invalid: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_proxy_protocol_invalid_headers_total",
+			Help: "Total number of connections closed because of an invalid PROXY protocol header, by listener.",
+		}, []string{"listener"}),
+	}, nil
+}
+
+// WrapListener wraps the listener to read the PROXY protocol header of the connections.
+func (p *ProxyProtocol) WrapListener(name string, l net.Listener) net.Listener {
+	return &proxyListener{
+		Listener: l,
+		trusted:  p.trusted,
+		timeout:  p.timeout,
+		invalid:  p.invalid.WithLabelValues(name),
+	}
+}
+
+// Listeners serves the HTTP and gRPC servers on the listeners accepting the PROXY protocol
+// headers, on the listen addresses of the server config and the PROXY protocol ports. The
+// servers are shut down, along with their connections, by the owner of the servers.
+type Listeners struct {
+	services.Service
+
+	proxy      *ProxyProtocol
+	httpAddr   string
+	grpcAddr   string
+	httpServer *http.Server
+	grpcServer *grpc.Server
+	logger     log.Logger
+
+	httpListener net.Listener
+	grpcListener net.Listener
+}
+
+// NewListeners makes new Listeners.
+func (p *ProxyProtocol) NewListeners(serverCfg server.Config, httpServer *http.Server, grpcServer *grpc.Server, logger log.Logger) *Listeners {
+	l := &Listeners{
+		proxy:      p,
+		httpAddr:   net.JoinHostPort(serverCfg.HTTPListenAddress, fmt.Sprint(p.cfg.ProxyProtocolHTTPListenPort)),
+		grpcAddr:   net.JoinHostPort(serverCfg.GRPCListenAddress, fmt.Sprint(p.cfg.ProxyProtocolGRPCListenPort)),
+		httpServer: httpServer,
+		grpcServer: grpcServer,
+		logger:     logger,
+	}
+	l.Service = services.NewBasicService(l.starting, l.running, l.stopping)
+	return l
+}
+
+func (l *Listeners) starting(_ context.Context) error {
+	httpListener, err := net.Listen("tcp", l.httpAddr)
+	if err != nil {
+		return errors.Wrap(err, "listen on the HTTP PROXY protocol address")
+	}
+	l.httpListener = l.proxy.WrapListener("http", httpListener)
+
+	grpcListener, err := net.Listen("tcp", l.grpcAddr)
+	if err != nil {
+		_ = l.httpListener.Close()
+		return errors.Wrap(err, "listen on the gRPC PROXY protocol address")
+	}
+	l.grpcListener = l.proxy.WrapListener("grpc", grpcListener)
+
+	level.Info(l.logger).Log("msg", "servers listening for the PROXY protocol", "http", l.httpListener.Addr(), "grpc", l.grpcListener.Addr())
+	return nil
+}
+
+func (l *Listeners) running(ctx context.Context) error {
+	errs := make(chan error, 2)
+	go func() {
+		errs <- errors.Wrap(l.httpServer.Serve(l.httpListener), "HTTP PROXY protocol listener")
+	}()
+	go func() {
+		errs <- errors.Wrap(l.grpcServer.Serve(l.grpcListener), "gRPC PROXY protocol listener")
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errs:
+		return err
+	}
+}
+
+func (l *Listeners) stopping(_ error) error {
+	_ = l.httpListener.Close()
+	_ = l.grpcListener.Close()
+	return nil
+}
+
+// ForwardedMiddleware returns the middleware replacing the remote address of the HTTP
+// requests coming from the trusted forwarders with the address of the client, from the
+// X-Forwarded-For header. The client is the last address of the header not in the trusted
+// CIDRs, since the addresses before it could have been set by the client itself.
+func ForwardedMiddleware(trusted []*net.IPNet) middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := forwardedFor(r, trusted); ip != nil {
+				r.RemoteAddr = ip.String()
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+}
+
+func forwardedFor(r *http.Request, trusted []*net.IPNet) net.IP {
+	if !ipfilter.Contains(trusted, ipfilter.HostIP(r.RemoteAddr)) {
+		return nil
+	}
+
+	var addrs []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		addrs = append(addrs, strings.Split(h, ",")...)
+	}
+
+	var client net.IP
+	for i := len(addrs) - 1; i >= 0; i-- {
+		ip := ipfilter.HostIP(strings.TrimSpace(addrs[i]))
+		if ip == nil {
+			// The addresses before an invalid one can't be trusted.
+			break
+		}
+		client = ip
+		if !ipfilter.Contains(trusted, ip) {
+			break
+		}
+	}
+	return client
+}
@@ -0,0 +1,155 @@
+package realip
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/server"
+	"google.golang.org/grpc"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/ipfilter"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		cfg      Config
+		expected string
+	}{
+		"disabled": {
+			cfg: Config{},
+		},
+		"enabled": {
+			cfg: Config{ProxyProtocolEnabled: true, ProxyProtocolHTTPListenPort: 9080, ProxyProtocolGRPCListenPort: 9096, ProxyProtocolTrustedCIDRs: []string{"10.0.0.0/8"}, ProxyProtocolHeaderTimeout: 1, TrustedForwarderCIDRs: []string{"192.0.2.1"}},
+		},
+		"invalid proxy protocol CIDR": {
+			cfg:      Config{ProxyProtocolEnabled: true, ProxyProtocolHTTPListenPort: 9080, ProxyProtocolGRPCListenPort: 9096, ProxyProtocolTrustedCIDRs: []string{"10.0.0.0/33"}, ProxyProtocolHeaderTimeout: 1},
+			expected: "10.0.0.0/33: invalid CIDR",
+		},
+		"missing proxy protocol trusted CIDRs": {
+			cfg:      Config{ProxyProtocolEnabled: true, ProxyProtocolHTTPListenPort: 9080, ProxyProtocolGRPCListenPort: 9096, ProxyProtocolHeaderTimeout: 1},
+			expected: errMissingTrustedCIDRs.Error(),
+		},
+		"invalid proxy protocol listen port": {
+			cfg:      Config{ProxyProtocolEnabled: true, ProxyProtocolHTTPListenPort: 9080, ProxyProtocolTrustedCIDRs: []string{"10.0.0.0/8"}, ProxyProtocolHeaderTimeout: 1},
+			expected: errInvalidListenPort.Error(),
+		},
+		"invalid forwarder CIDR": {
+			cfg:      Config{TrustedForwarderCIDRs: []string{"proxy"}},
+			expected: "proxy: invalid CIDR",
+		},
+		"invalid header timeout": {
+			cfg:      Config{ProxyProtocolEnabled: true, ProxyProtocolHTTPListenPort: 9080, ProxyProtocolGRPCListenPort: 9096, ProxyProtocolTrustedCIDRs: []string{"10.0.0.0/8"}},
+			expected: errInvalidHeaderTimeout.Error(),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if tc.expected == "" {
+				assert.NoError(t, tc.cfg.Validate())
+			} else {
+				assert.EqualError(t, tc.cfg.Validate(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestForwardedMiddleware(t *testing.T) {
+	trusted, err := ipfilter.ParseCIDRs([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		remoteAddr   string
+		forwardedFor []string
+		expectedAddr string
+	}{
+		"untrusted peer": {
+			remoteAddr:   "192.0.2.1:1234",
+			forwardedFor: []string{"198.51.100.1"},
+			expectedAddr: "192.0.2.1:1234",
+		},
+		"trusted peer without header": {
+			remoteAddr:   "10.0.0.1:1234",
+			expectedAddr: "10.0.0.1:1234",
+		},
+		"trusted peer": {
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"198.51.100.1"},
+			expectedAddr: "198.51.100.1",
+		},
+		"chain of trusted proxies": {
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"203.0.113.1, 198.51.100.1", "10.0.0.2"},
+			expectedAddr: "198.51.100.1",
+		},
+		"only trusted addresses": {
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"10.0.0.3, 10.0.0.2"},
+			expectedAddr: "10.0.0.3",
+		},
+		"invalid address": {
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"198.51.100.1, unknown"},
+			expectedAddr: "10.0.0.1:1234",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var remoteAddr string
+			h := ForwardedMiddleware(trusted).Wrap(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				remoteAddr = r.RemoteAddr
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for _, v := range tc.forwardedFor {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tc.expectedAddr, remoteAddr)
+		})
+	}
+}
+
+func TestProxyProtocol_Listeners(t *testing.T) {
+	p, err := NewProxyProtocol(Config{ProxyProtocolTrustedCIDRs: []string{"127.0.0.1"}, ProxyProtocolHeaderTimeout: time.Second}, nil)
+	require.NoError(t, err)
+
+	var remoteAddr string
+	httpServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+	})}
+	grpcServer := grpc.NewServer()
+
+	l := p.NewListeners(server.Config{HTTPListenAddress: "127.0.0.1", GRPCListenAddress: "127.0.0.1"}, httpServer, grpcServer, log.NewNopLogger())
+	l.httpAddr, l.grpcAddr = "127.0.0.1:0", "127.0.0.1:0"
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), l))
+	defer func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), l))
+		_ = httpServer.Close()
+		grpcServer.Stop()
+	}()
+
+	conn, err := net.Dial("tcp", l.httpListener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "192.0.2.1:56324", remoteAddr)
+}
@@ -0,0 +1,93 @@
+package relabeling
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+
+	"objectstorage/pkg/push"
+	"objectstorage/pkg/tenant"
+)
+
+// Limits is the subset of the per-tenant overrides used by the relabeling. It's
+// implemented by validation.Overrides, so relabel configs are reloaded with the
+// runtime config.
+type Limits interface {
+	MetricRelabelConfigs(userID string) []*relabel.Config
+}
+
+// Relabeler applies the per-tenant metric_relabel_configs to the series on the push path.
+type Relabeler struct {
+	limits Limits
+
+	droppedSamples  *prometheus.CounterVec
+	relabeledSeries *prometheus.CounterVec
+}
+
+// NewRelabeler makes a new Relabeler.
+func NewRelabeler(limits Limits, reg prometheus.Registerer) *Relabeler {
+	return &Relabeler{
+		limits: limits,
+		droppedSamples: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_relabeling_dropped_samples_total",
+			Help: "Total number of samples dropped by the tenant relabel configs.",
+		}, []string{"user"}),
+		relabeledSeries: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_relabeling_processed_series_total",
+			Help: "Total number of series processed by the tenant relabel configs.",
+		}, []string{"user"}),
+	}
+}
+
+// PushMiddleware returns the push.Middleware relabeling the series.
+func (r *Relabeler) PushMiddleware() push.Middleware {
+	return func(next push.Func) push.Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			userID, err := tenant.TenantID(ctx)
+			if err != nil {
+				return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+			}
+
+			cfgs := r.limits.MetricRelabelConfigs(userID)
+			if len(cfgs) == 0 {
+				return next(ctx, req)
+			}
+
+			r.relabel(userID, req, cfgs)
+			if len(req.Timeseries) == 0 && len(req.Metadata) == 0 {
+				// Everything has been dropped on purpose, so it's not an error.
+				return &cortexpb.WriteResponse{}, nil
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+func (r *Relabeler) relabel(userID string, req *cortexpb.WriteRequest, cfgs []*relabel.Config) {
+	kept := req.Timeseries[:0]
+	dropped := 0
+
+	for _, ts := range req.Timeseries {
+		lbls, keep := relabel.Process(cortexpb.FromLabelAdaptersToLabels(ts.Labels), cfgs...)
+		adapters := cortexpb.FromLabelsToLabelAdapters(lbls)
+		if !keep || len(adapters) == 0 {
+			dropped += len(ts.Samples)
+			continue
+		}
+
+		ts.Labels = adapters
+		kept = append(kept, ts)
+	}
+
+	r.relabeledSeries.WithLabelValues(userID).Add(float64(len(req.Timeseries)))
+	if dropped > 0 {
+		r.droppedSamples.WithLabelValues(userID).Add(float64(dropped))
+	}
+	req.Timeseries = kept
+}
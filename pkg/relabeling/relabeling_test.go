@@ -0,0 +1,84 @@
+package relabeling
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+type limitsMock map[string][]*relabel.Config
+
+func (m limitsMock) MetricRelabelConfigs(userID string) []*relabel.Config { return m[userID] }
+
+func TestRelabeler_PushMiddleware(t *testing.T) {
+	var cfgs []*relabel.Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+- source_labels: [__name__]
+  regex: noisy_.*
+  action: drop
+- regex: pod_uid
+  action: labeldrop
+- source_labels: [env]
+  regex: prod-(.*)
+  target_label: env
+  replacement: production-$1
+  action: replace
+`), &cfgs))
+
+	series := func(lbls ...string) cortexpb.PreallocTimeseries {
+		ts := &cortexpb.TimeSeries{Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}}}
+		for i := 0; i < len(lbls); i += 2 {
+			ts.Labels = append(ts.Labels, cortexpb.LabelAdapter{Name: lbls[i], Value: lbls[i+1]})
+		}
+		return cortexpb.PreallocTimeseries{TimeSeries: ts}
+	}
+
+	tests := map[string]struct {
+		userID   string
+		series   []cortexpb.PreallocTimeseries
+		expected [][]cortexpb.LabelAdapter
+	}{
+		"tenant without relabel configs": {
+			userID:   "user-2",
+			series:   []cortexpb.PreallocTimeseries{series("__name__", "noisy_metric")},
+			expected: [][]cortexpb.LabelAdapter{{{Name: "__name__", Value: "noisy_metric"}}},
+		},
+		"drop, labeldrop and replace": {
+			userID: "user-1",
+			series: []cortexpb.PreallocTimeseries{
+				series("__name__", "noisy_metric"),
+				series("__name__", "up", "env", "prod-eu", "pod_uid", "1234"),
+			},
+			expected: [][]cortexpb.LabelAdapter{{{Name: "__name__", Value: "up"}, {Name: "env", Value: "production-eu"}}},
+		},
+		"all series dropped": {
+			userID: "user-1",
+			series: []cortexpb.PreallocTimeseries{series("__name__", "noisy_metric")},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := NewRelabeler(limitsMock{"user-1": cfgs}, nil)
+
+			var pushed [][]cortexpb.LabelAdapter
+			f := r.PushMiddleware()(func(_ context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				for _, ts := range req.Timeseries {
+					pushed = append(pushed, ts.Labels)
+				}
+				return &cortexpb.WriteResponse{}, nil
+			})
+
+			_, err := f(user.InjectOrgID(context.Background(), tc.userID), &cortexpb.WriteRequest{Timeseries: tc.series})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, pushed)
+		})
+	}
+}
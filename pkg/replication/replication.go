@@ -0,0 +1,296 @@
+// Package replication copies the blocks shipped to the bucket to a bucket in another region,
+// for disaster recovery, without relying on the replication of the storage provider.
+package replication
+
+import (
+	"context"
+	"flag"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+
+	"github.com/cortexproject/cortex/pkg/util/concurrency"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/storage/bucket"
+	bucket_tsdb "objectstorage/pkg/storage/tsdb"
+)
+
+var (
+	errInvalidSyncInterval = errors.New("the replication sync interval must be greater than 0")
+	errInvalidConcurrency  = errors.New("the replication concurrency must be greater than 0")
+)
+
+// Config holds the configuration of the block replication.
+type Config struct {
+	Enabled      bool          `yaml:"enabled"`
+	Bucket       bucket.Config `yaml:"bucket"`
+	SyncInterval time.Duration `yaml:"sync_interval"`
+	Concurrency  int           `yaml:"concurrency"`
+}
+
+// RegisterFlags registers the block replication flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "replication.enabled", false, "True to copy the blocks shipped to the bucket to the replica bucket, usually in another region. The blocks are copied by the leader only, and never deleted from the replica bucket, whose lifecycle policy is expected to expire them.")
+	f.DurationVar(&cfg.SyncInterval, "replication.sync-interval", time.Minute, "How frequently the new blocks are copied to the replica bucket. The replication lag is bounded by the interval plus the time to copy the blocks.")
+	f.IntVar(&cfg.Concurrency, "replication.concurrency", 10, "Number of tenants whose blocks are copied concurrently.")
+	cfg.Bucket.RegisterFlagsWithPrefix("replication.", f)
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.SyncInterval <= 0 {
+		return errInvalidSyncInterval
+	}
+	if cfg.Concurrency <= 0 {
+		return errInvalidConcurrency
+	}
+	return errors.Wrap(cfg.Bucket.Validate(), "invalid replica bucket config")
+}
+
+// LeaderRunner runs a job only on the elected leader. It's implemented by leaderelection.Elector.
+type LeaderRunner interface {
+	services.Service
+	RunIfLeader(f func(ctx context.Context) error) func(ctx context.Context) error
+}
+
+// Replicator periodically copies the complete blocks of each tenant missing from the replica
+// bucket. The blocks marked for deletion, and the tenants marked for deletion, aren't copied.
+type Replicator struct {
+	services.Service
+
+	cfg         Config
+	bkt         objstore.Bucket
+	replica     objstore.Bucket
+	cfgProvider bucket.TenantConfigProvider
+	leader      LeaderRunner
+	logger      log.Logger
+
+	// The blocks known to be in the replica bucket, by tenant, so that they're not looked up
+	// again on each run. The blocks of a tenant are only accessed by the goroutine copying them.
+	mtx        sync.Mutex
+	replicated map[string]map[ulid.ULID]struct{}
+
+	runs              *prometheus.CounterVec
+	replicatedBlocks  prometheus.Counter
+	failedBlocks      prometheus.Counter
+	lastSuccessfulRun prometheus.Gauge
+	lag               *prometheus.GaugeVec
+}
+
+// NewReplicator makes a new Replicator, copying the blocks of bkt to replica. The leader may
+// be nil, in which case the blocks are copied by every instance.
+func NewReplicator(cfg Config, bkt, replica objstore.Bucket, cfgProvider bucket.TenantConfigProvider, leader LeaderRunner, logger log.Logger, reg prometheus.Registerer) *Replicator {
+	r := &Replicator{
+		cfg:         cfg,
+		bkt:         bkt,
+		replica:     replica,
+		cfgProvider: cfgProvider,
+		leader:      leader,
+		logger:      logger,
+		replicated:  map[string]map[ulid.ULID]struct{}{},
+		runs: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_replication_runs_total",
+			Help: "Total number of runs copying the new blocks of all the tenants to the replica bucket, by outcome.",
+		}, []string{"outcome"}),
+		replicatedBlocks: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_replication_replicated_blocks_total",
+			Help: "Total number of blocks copied to the replica bucket.",
+		}),
+		failedBlocks: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_replication_failed_blocks_total",
+			Help: "Total number of blocks failed to be copied to the replica bucket. They're copied again on the next run.",
+		}),
+		lastSuccessfulRun: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_replication_last_successful_run_timestamp_seconds",
+			Help: "Unix timestamp of the last run having copied the new blocks of all the tenants.",
+		}),
+		lag: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_replication_lag_seconds",
+			Help: "Age of the oldest block of the tenant not copied to the replica bucket yet, as of the last run. 0 when all the blocks have been copied.",
+		}, []string{"user"}),
+	}
+	r.Service = services.NewTimerService(cfg.SyncInterval, r.starting, r.iteration, r.stopping)
+	return r
+}
+
+func (r *Replicator) starting(ctx context.Context) error {
+	if r.leader != nil {
+		if err := services.StartAndAwaitRunning(ctx, r.leader); err != nil {
+			return errors.Wrap(err, "start replication leader election")
+		}
+	}
+	return nil
+}
+
+func (r *Replicator) stopping(_ error) error {
+	if r.leader != nil {
+		return services.StopAndAwaitTerminated(context.Background(), r.leader)
+	}
+	return nil
+}
+
+func (r *Replicator) iteration(ctx context.Context) error {
+	replicate := r.replicateAll
+	if r.leader != nil {
+		replicate = r.leader.RunIfLeader(r.replicateAll)
+	}
+	if err := replicate(ctx); err != nil {
+		r.runs.WithLabelValues("failed").Inc()
+		level.Warn(r.logger).Log("msg", "failed to replicate the blocks", "err", err)
+		return nil
+	}
+	r.runs.WithLabelValues("success").Inc()
+	r.lastSuccessfulRun.SetToCurrentTime()
+	return nil
+}
+
+// replicateAll copies the new blocks of all the tenants in the bucket. It fails if the blocks
+// of any tenant failed to be copied, the others being copied anyway.
+func (r *Replicator) replicateAll(ctx context.Context) error {
+	users, _, err := bucket_tsdb.NewUsersScanner(r.bkt, bucket_tsdb.AllUsers, r.logger).ScanUsers(ctx)
+	if err != nil {
+		return errors.Wrap(err, "scan users")
+	}
+	r.removeDeletedTenants(users)
+
+	return concurrency.ForEachUser(ctx, users, r.cfg.Concurrency, r.replicateTenant)
+}
+
+// replicateTenant copies the complete blocks of the tenant missing from the replica bucket,
+// oldest first.
+func (r *Replicator) replicateTenant(ctx context.Context, userID string) error {
+	userBkt := bucket.NewUserBucketClient(userID, r.bkt, r.cfgProvider)
+	// The server-side encryption config of the tenant is specific to the source bucket.
+	userReplica := bucket.NewUserBucketClient(userID, r.replica, nil)
+
+	var ids []ulid.ULID
+	err := userBkt.Iter(ctx, "", func(name string) error {
+		if id, err := ulid.Parse(strings.TrimSuffix(name, "/")); err == nil {
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "list blocks of tenant %s", userID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Compare(ids[j]) < 0 })
+
+	r.mtx.Lock()
+	replicated, ok := r.replicated[userID]
+	if !ok {
+		replicated = map[ulid.ULID]struct{}{}
+		r.replicated[userID] = replicated
+	}
+	r.mtx.Unlock()
+
+	var (
+		now     = time.Now()
+		lag     time.Duration
+		lastErr error
+	)
+	for _, id := range ids {
+		if _, ok := replicated[id]; ok {
+			continue
+		}
+
+		copied, err := r.replicateBlock(ctx, userBkt, userReplica, id)
+		if err != nil {
+			r.failedBlocks.Inc()
+			level.Warn(r.logger).Log("msg", "failed to replicate the block", "user", userID, "block", id.String(), "err", err)
+			lastErr = err
+
+			// The ULID time is when the block was compacted from the head.
+			if lag == 0 {
+				lag = now.Sub(ulid.Time(id.Time()))
+			}
+			continue
+		}
+		if copied {
+			replicated[id] = struct{}{}
+		}
+	}
+
+	r.lag.WithLabelValues(userID).Set(lag.Seconds())
+	return errors.Wrapf(lastErr, "replicate blocks of tenant %s", userID)
+}
+
+// replicateBlock copies the block to the replica bucket, unless it's already there, returning
+// whether the block is in the replica bucket. The partial blocks, still being uploaded or
+// whose upload failed, and the blocks marked for deletion aren't copied.
+func (r *Replicator) replicateBlock(ctx context.Context, bkt, replica objstore.Bucket, id ulid.ULID) (bool, error) {
+	metaPath := path.Join(id.String(), metadata.MetaFilename)
+	if ok, err := replica.Exists(ctx, metaPath); err != nil || ok {
+		return ok, errors.Wrap(err, "check meta in the replica bucket")
+	}
+	if ok, err := bkt.Exists(ctx, metaPath); err != nil || !ok {
+		return false, errors.Wrap(err, "check meta")
+	}
+	if ok, err := bkt.Exists(ctx, path.Join(id.String(), metadata.DeletionMarkFilename)); err != nil || ok {
+		return false, errors.Wrap(err, "check deletion mark")
+	}
+
+	var files []string
+	err := bkt.Iter(ctx, id.String(), func(name string) error {
+		if name != metaPath {
+			files = append(files, name)
+		}
+		return nil
+	}, objstore.WithRecursiveIter)
+	if err != nil {
+		return false, errors.Wrap(err, "list block files")
+	}
+
+	// The meta is copied last, so that a block whose copy failed is partial in the replica
+	// bucket too, and copied again.
+	for _, name := range append(files, metaPath) {
+		if err := copyObject(ctx, bkt, replica, name); err != nil {
+			return false, errors.Wrapf(err, "copy %s", name)
+		}
+	}
+
+	r.replicatedBlocks.Inc()
+	return true, nil
+}
+
+func copyObject(ctx context.Context, from, to objstore.Bucket, name string) error {
+	rc, err := from.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return to.Upload(ctx, name, rc)
+}
+
+// removeDeletedTenants forgets the blocks and removes the metrics of the tenants not in the
+// bucket anymore, or marked for deletion.
+func (r *Replicator) removeDeletedTenants(users []string) {
+	current := make(map[string]struct{}, len(users))
+	for _, userID := range users {
+		current[userID] = struct{}{}
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for userID := range r.replicated {
+		if _, ok := current[userID]; ok {
+			continue
+		}
+		delete(r.replicated, userID)
+		r.lag.DeleteLabelValues(userID)
+	}
+}
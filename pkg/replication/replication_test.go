@@ -0,0 +1,180 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+
+	cortex_testutil "github.com/cortexproject/cortex/pkg/storage/tsdb/testutil"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+
+	bucket_tsdb "objectstorage/pkg/storage/tsdb"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *Config)
+		expected error
+	}{
+		"disabled": {
+			setup:    func(*Config) {},
+			expected: nil,
+		},
+		"enabled": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.Bucket.Backend = "filesystem"
+			},
+			expected: nil,
+		},
+		"zero sync interval": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.Bucket.Backend = "filesystem"
+				cfg.SyncInterval = 0
+			},
+			expected: errInvalidSyncInterval,
+		},
+		"zero concurrency": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.Bucket.Backend = "filesystem"
+				cfg.Concurrency = 0
+			},
+			expected: errInvalidConcurrency,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+			assert.Equal(t, tc.expected, cfg.Validate())
+		})
+	}
+
+	t.Run("invalid bucket config", func(t *testing.T) {
+		cfg := Config{}
+		flagext.DefaultValues(&cfg)
+		cfg.Enabled = true
+		cfg.Bucket.Backend = "unknown"
+		assert.Error(t, cfg.Validate())
+	})
+}
+
+// objects returns the content of the objects of the bucket, by name.
+func objects(t *testing.T, bkt objstore.Bucket) map[string]string {
+	out := map[string]string{}
+	require.NoError(t, bkt.Iter(context.Background(), "", func(name string) error {
+		r, err := bkt.Get(context.Background(), name)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		b, err := io.ReadAll(r)
+		out[name] = string(b)
+		return err
+	}, objstore.WithRecursiveIter))
+	return out
+}
+
+func TestReplicator(t *testing.T) {
+	ctx := context.Background()
+	bkt, replica := objstore.NewInMemBucket(), objstore.NewInMemBucket()
+
+	block1 := cortex_testutil.MockStorageBlock(t, bkt, "user-1", 10, 20)
+	require.NoError(t, bkt.Upload(ctx, path.Join("user-1", block1.ULID.String(), "chunks", "000001"), strings.NewReader("chunks")))
+	block2 := cortex_testutil.MockStorageBlock(t, bkt, "user-1", 20, 30)
+	cortex_testutil.MockStorageDeletionMark(t, bkt, "user-1", block2)
+	block3 := cortex_testutil.MockStorageBlock(t, bkt, "user-2", 10, 20)
+	// Partial block, without meta.
+	require.NoError(t, bkt.Upload(ctx, path.Join("user-2", ulid.MustNew(1, nil).String(), "index"), bytes.NewReader(nil)))
+	// Tenant marked for deletion.
+	cortex_testutil.MockStorageBlock(t, bkt, "user-3", 10, 20)
+	require.NoError(t, bucket_tsdb.WriteTenantDeletionMark(ctx, bkt, "user-3", nil, bucket_tsdb.NewTenantDeletionMark(time.Now())))
+
+	r := NewReplicator(Config{Enabled: true, SyncInterval: time.Minute, Concurrency: 2}, bkt, replica, nil, nil, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, r.iteration(ctx))
+
+	// Only the complete blocks, not marked for deletion, of the active tenants are copied.
+	source, copied := objects(t, bkt), objects(t, replica)
+	var names []string
+	for name, content := range copied {
+		names = append(names, name)
+		assert.Equal(t, source[name], content, name)
+	}
+	assert.ElementsMatch(t, []string{
+		path.Join("user-1", block1.ULID.String(), metadata.MetaFilename),
+		path.Join("user-1", block1.ULID.String(), "index"),
+		path.Join("user-1", block1.ULID.String(), "chunks", "000001"),
+		path.Join("user-2", block3.ULID.String(), metadata.MetaFilename),
+		path.Join("user-2", block3.ULID.String(), "index"),
+	}, names)
+	assert.Equal(t, float64(2), testutil.ToFloat64(r.replicatedBlocks))
+	assert.Equal(t, float64(0), testutil.ToFloat64(r.lag.WithLabelValues("user-1")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(r.runs.WithLabelValues("success")))
+
+	// The next run copies the new blocks only.
+	block4 := cortex_testutil.MockStorageBlock(t, bkt, "user-1", 30, 40)
+	require.NoError(t, r.iteration(ctx))
+	assert.Equal(t, float64(3), testutil.ToFloat64(r.replicatedBlocks))
+	exists, err := replica.Exists(ctx, path.Join("user-1", block4.ULID.String(), metadata.MetaFilename))
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+// failingBucket fails the uploads of the objects with the given suffix.
+type failingBucket struct {
+	objstore.Bucket
+	suffix string
+}
+
+func (b *failingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if strings.HasSuffix(name, b.suffix) {
+		return errors.New("failed")
+	}
+	return b.Bucket.Upload(ctx, name, r)
+}
+
+func TestReplicator_ReportsTheLagOfTheBlocksFailedToBeCopied(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	replica := &failingBucket{Bucket: objstore.NewInMemBucket(), suffix: metadata.MetaFilename}
+
+	maxT := time.Now().Add(-time.Hour).UnixMilli()
+	block := cortex_testutil.MockStorageBlock(t, bkt, "user-1", maxT-10, maxT)
+
+	r := NewReplicator(Config{Enabled: true, SyncInterval: time.Minute, Concurrency: 1}, bkt, replica, nil, nil, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, r.iteration(ctx))
+	assert.Equal(t, float64(1), testutil.ToFloat64(r.runs.WithLabelValues("failed")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(r.failedBlocks))
+	assert.InDelta(t, time.Hour.Seconds(), testutil.ToFloat64(r.lag.WithLabelValues("user-1")), 60)
+
+	// The block, partial in the replica bucket, is copied again on the next run.
+	replica.suffix = "unknown"
+	require.NoError(t, r.iteration(ctx))
+	exists, err := replica.Exists(ctx, path.Join("user-1", block.ULID.String(), metadata.MetaFilename))
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, float64(0), testutil.ToFloat64(r.lag.WithLabelValues("user-1")))
+
+	// The metrics of the tenants deleted are removed.
+	require.NoError(t, bucket_tsdb.WriteTenantDeletionMark(ctx, bkt, "user-1", nil, bucket_tsdb.NewTenantDeletionMark(time.Now())))
+	require.NoError(t, r.iteration(ctx))
+	assert.Equal(t, 0, testutil.CollectAndCount(r.lag))
+}
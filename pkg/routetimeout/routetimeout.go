@@ -0,0 +1,198 @@
+package routetimeout
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Classes of the HTTP routes, each with its own timeout.
+const (
+	ClassPush  = "push"
+	ClassAdmin = "admin"
+	ClassQuery = "query"
+)
+
+var errNegativeTimeout = errors.New("the route timeouts must not be negative")
+
+// Config holds the configuration of the server-side timeouts of the HTTP routes, by class.
+type Config struct {
+	PushTimeout  time.Duration `yaml:"push_timeout"`
+	AdminTimeout time.Duration `yaml:"admin_timeout"`
+	QueryTimeout time.Duration `yaml:"query_timeout"`
+}
+
+// RegisterFlags registers the route timeouts flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.DurationVar(&cfg.PushTimeout, "server.push-route-timeout", 0, "Maximum time to serve a push request. Once elapsed, the request context is canceled and the request fails with 503, unless the response has already started. 0 to disable.")
+	f.DurationVar(&cfg.AdminTimeout, "server.admin-route-timeout", 0, "Maximum time to serve a request to the administrative endpoints, like the ring, read-only mode and tenant deletion ones. Once elapsed, the request context is canceled and the request fails with 503, unless the response has already started. 0 to disable.")
+	f.DurationVar(&cfg.QueryTimeout, "server.query-route-timeout", 0, "Maximum time to serve a query, including the cardinality and head API requests. Once elapsed, the request context is canceled and the request fails with 503, unless the response has already started. 0 to disable.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if cfg.PushTimeout < 0 || cfg.AdminTimeout < 0 || cfg.QueryTimeout < 0 {
+		return errNegativeTimeout
+	}
+	return nil
+}
+
+func (cfg *Config) timeout(class string) time.Duration {
+	switch class {
+	case ClassPush:
+		return cfg.PushTimeout
+	case ClassAdmin:
+		return cfg.AdminTimeout
+	case ClassQuery:
+		return cfg.QueryTimeout
+	}
+	return 0
+}
+
+// Timeouts applies the configured timeouts to the HTTP routes.
+type Timeouts struct {
+	cfg Config
+
+	timedOut *prometheus.CounterVec
+}
+
+// NewTimeouts makes a new Timeouts.
+func NewTimeouts(cfg Config, reg prometheus.Registerer) *Timeouts {
+	return &Timeouts{
+		cfg: cfg,
+		timedOut: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_http_route_timeouts_total",
+			Help: "Total number of HTTP requests which timed out, by route class.",
+		}, []string{"class"}),
+	}
+}
+
+// Wrap returns the handler of a route of the class, served with the timeout of the class.
+// The handler runs with a context canceled once the timeout elapses. If the handler hasn't
+// started the response by then, the request fails with 503 and the time spent, and the
+// later writes of the handler fail with http.ErrHandlerTimeout. Else the response is left
+// to the handler, which is expected to stop on the canceled context.
+func (t *Timeouts) Wrap(class string, next http.Handler) http.Handler {
+	timeout := t.cfg.timeout(class)
+	if timeout <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		tw := &timeoutWriter{w: w, header: make(http.Header)}
+		done := make(chan struct{})
+		panicked := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+		case <-ctx.Done():
+			if !tw.timeout() {
+				// The response has started: let the handler complete it.
+				select {
+				case p := <-panicked:
+					panic(p)
+				case <-done:
+				}
+				return
+			}
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				t.timedOut.WithLabelValues(class).Inc()
+				http.Error(w, fmt.Sprintf("%s request timed out after %s, above the %s route timeout of %s", class, time.Since(start).Round(time.Millisecond), class, timeout), http.StatusServiceUnavailable)
+			}
+		}
+	})
+}
+
+// timeoutWriter is the response writer of the handlers served with a timeout. The headers
+// are buffered until the response starts, so that they're dropped if the request times out
+// before.
+type timeoutWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+
+	mtx      sync.Mutex
+	started  bool
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mtx.Lock()
+	defer tw.mtx.Unlock()
+	tw.start(code)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mtx.Lock()
+	defer tw.mtx.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.start(http.StatusOK)
+	return tw.w.Write(b)
+}
+
+// Flush implements http.Flusher, for the handlers streaming their response.
+func (tw *timeoutWriter) Flush() {
+	tw.mtx.Lock()
+	defer tw.mtx.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.start(http.StatusOK)
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// start starts the response, unless it has already started or the request timed out. It
+// must be called with the lock held.
+func (tw *timeoutWriter) start(code int) {
+	if tw.started || tw.timedOut {
+		return
+	}
+	tw.started = true
+
+	dst := tw.w.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+	tw.w.WriteHeader(code)
+}
+
+// timeout marks the request as timed out, and returns false if the response has already
+// started.
+func (tw *timeoutWriter) timeout() bool {
+	tw.mtx.Lock()
+	defer tw.mtx.Unlock()
+	if tw.started {
+		return false
+	}
+	tw.timedOut = true
+	return true
+}
@@ -0,0 +1,129 @@
+package routetimeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, (&Config{}).Validate())
+	assert.NoError(t, (&Config{PushTimeout: time.Second, QueryTimeout: time.Minute}).Validate())
+	assert.ErrorIs(t, (&Config{AdminTimeout: -time.Second}).Validate(), errNegativeTimeout)
+}
+
+func TestTimeouts_Wrap(t *testing.T) {
+	tests := map[string]struct {
+		class        string
+		handler      http.HandlerFunc
+		expectedCode int
+		expectedBody string
+		timedOut     bool
+	}{
+		"completed within the timeout": {
+			class: ClassPush,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				_, _ = w.Write([]byte("ok"))
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: "ok",
+		},
+		"timed out before the response": {
+			class: ClassPush,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			},
+			expectedCode: http.StatusServiceUnavailable,
+			expectedBody: "push request timed out after",
+			timedOut:     true,
+		},
+		"timed out after the response started": {
+			class: ClassPush,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				<-r.Context().Done()
+				_, _ = w.Write([]byte("partial"))
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: "partial",
+		},
+		"streamed response": {
+			class: ClassPush,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("chunk"))
+				w.(http.Flusher).Flush()
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: "chunk",
+		},
+		"class without timeout": {
+			class: ClassQuery,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, hasDeadline := r.Context().Deadline()
+				assert.False(t, hasDeadline)
+			},
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			reg := prometheus.NewPedanticRegistry()
+			timeouts := NewTimeouts(Config{PushTimeout: 50 * time.Millisecond}, reg)
+
+			rec := httptest.NewRecorder()
+			timeouts.Wrap(tc.class, tc.handler).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/push", nil))
+
+			assert.Equal(t, tc.expectedCode, rec.Code)
+			assert.True(t, strings.HasPrefix(rec.Body.String(), tc.expectedBody), rec.Body.String())
+
+			expected := 0
+			if tc.timedOut {
+				expected = 1
+				assert.Contains(t, rec.Body.String(), "above the push route timeout of 50ms")
+			}
+			require.Equal(t, expected, testutil.CollectAndCount(reg, "cortex_http_route_timeouts_total"))
+		})
+	}
+}
+
+func TestTimeouts_Wrap_WritesAfterTheTimeout(t *testing.T) {
+	timeouts := NewTimeouts(Config{PushTimeout: 50 * time.Millisecond}, nil)
+
+	release := make(chan struct{})
+	writeErr := make(chan error, 1)
+	handler := timeouts.Wrap(ClassPush, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte("late"))
+		writeErr <- err
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/push", nil))
+	close(release)
+
+	assert.ErrorIs(t, <-writeErr, http.ErrHandlerTimeout)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.NotEqual(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.NotContains(t, rec.Body.String(), "late")
+}
+
+func TestTimeouts_Wrap_Panic(t *testing.T) {
+	timeouts := NewTimeouts(Config{AdminTimeout: time.Second}, nil)
+	handler := timeouts.Wrap(ClassAdmin, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	}))
+
+	assert.PanicsWithValue(t, "boom", func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ingester/ring", nil))
+	})
+}
@@ -0,0 +1,71 @@
+package scraper
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/metadata"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+var errNativeHistograms = errors.New("the native histograms are not supported by the scraper")
+
+// appender buffers the samples of a scrape, and pushes them on commit. The series references
+// are not cached, so that the scrape loop always passes the series labels.
+type appender struct {
+	ctx     context.Context
+	scraper *Scraper
+	series  []cortexpb.PreallocTimeseries
+}
+
+func (a *appender) Append(_ storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	// The labels are copied, since the push path may modify them.
+	a.series = append(a.series, cortexpb.PreallocTimeseries{TimeSeries: &cortexpb.TimeSeries{
+		Labels:  cortexpb.FromLabelsToLabelAdapters(l.Copy()),
+		Samples: []cortexpb.Sample{{TimestampMs: t, Value: v}},
+	}})
+	return 0, nil
+}
+
+// AppendExemplar drops the exemplars, which are only exposed by the OpenMetrics targets.
+func (a *appender) AppendExemplar(_ storage.SeriesRef, _ labels.Labels, _ exemplar.Exemplar) (storage.SeriesRef, error) {
+	return 0, nil
+}
+
+// AppendHistogram is never called, since the protobuf negotiation isn't enabled.
+func (a *appender) AppendHistogram(_ storage.SeriesRef, _ labels.Labels, _ int64, _ *histogram.Histogram, _ *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	return 0, errNativeHistograms
+}
+
+// UpdateMetadata is never called, since the metadata storage isn't enabled.
+func (a *appender) UpdateMetadata(_ storage.SeriesRef, _ labels.Labels, _ metadata.Metadata) (storage.SeriesRef, error) {
+	return 0, nil
+}
+
+func (a *appender) Commit() error {
+	if len(a.series) == 0 {
+		return nil
+	}
+
+	samples := len(a.series)
+	req := &cortexpb.WriteRequest{Source: cortexpb.API, Timeseries: a.series}
+	a.series = nil
+
+	if _, err := a.scraper.pushFn(user.InjectOrgID(a.ctx, a.scraper.cfg.Tenant), req); err != nil {
+		a.scraper.failedPushes.Inc()
+		return errors.Wrap(err, "push the scraped samples")
+	}
+	a.scraper.pushedSamples.Add(float64(samples))
+	return nil
+}
+
+func (a *appender) Rollback() error {
+	a.series = nil
+	return nil
+}
@@ -0,0 +1,6 @@
+package scraper
+
+import (
+	// Registers the kubernetes_sd_configs, so that they can be decoded from the scrape configs.
+	_ "github.com/prometheus/prometheus/discovery/kubernetes"
+)
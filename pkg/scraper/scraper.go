@@ -0,0 +1,162 @@
+// Package scraper implements the embedded pull-based scrape mode: the targets of a minimal
+// scrape_configs section are scraped, and the samples are pushed through the same pipeline
+// as the remote write requests, so that a small site can run a single binary instead of
+// Prometheus and remote write.
+package scraper
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/push"
+	"objectstorage/pkg/tenant"
+)
+
+// Names of the supported service discoveries.
+const (
+	staticSD     = "static"
+	kubernetesSD = "kubernetes"
+)
+
+var (
+	errUnsupportedSD         = errors.New("unsupported service discovery, only static_configs and kubernetes_sd_configs are supported")
+	errInvalidScrapeInterval = errors.New("the scrape interval must be greater than 0")
+	errInvalidScrapeTimeout  = errors.New("the scrape timeout must be greater than 0")
+)
+
+// Config holds the configuration of the embedded scraper. The scrape configs can only be set
+// in the YAML config, in the Prometheus format.
+type Config struct {
+	Tenant         string                 `yaml:"tenant"`
+	ScrapeInterval time.Duration          `yaml:"scrape_interval"`
+	ScrapeTimeout  time.Duration          `yaml:"scrape_timeout"`
+	ScrapeConfigs  []*config.ScrapeConfig `yaml:"scrape_configs"`
+}
+
+// RegisterFlags registers the scraper flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.Tenant, "scraper.tenant", tenant.DefaultNoAuthTenant, "Tenant the scraped samples are ingested for.")
+	f.DurationVar(&cfg.ScrapeInterval, "scraper.scrape-interval", time.Minute, "How frequently the targets are scraped, unless set in their scrape config.")
+	f.DurationVar(&cfg.ScrapeTimeout, "scraper.scrape-timeout", 10*time.Second, "Timeout of each scrape, unless set in their scrape config.")
+}
+
+// Enabled returns true if any target is configured to be scraped.
+func (cfg *Config) Enabled() bool {
+	return len(cfg.ScrapeConfigs) > 0
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled() {
+		return nil
+	}
+	if err := tenant.ValidTenantID(cfg.Tenant); err != nil {
+		return errors.Wrap(err, "invalid scraper tenant")
+	}
+	if cfg.ScrapeInterval <= 0 {
+		return errInvalidScrapeInterval
+	}
+	if cfg.ScrapeTimeout <= 0 {
+		return errInvalidScrapeTimeout
+	}
+
+	for _, scfg := range cfg.ScrapeConfigs {
+		if scfg == nil {
+			continue
+		}
+		for _, sd := range scfg.ServiceDiscoveryConfigs {
+			if name := sd.Name(); name != staticSD && name != kubernetesSD {
+				return errors.Wrapf(errUnsupportedSD, "job %s: %s", scfg.JobName, name)
+			}
+		}
+	}
+
+	// The scrape configs are checked as done by Prometheus, which also sets their defaults.
+	_, err := cfg.promConfig().GetScrapeConfigs()
+	return err
+}
+
+// promConfig returns the Prometheus config of the scrape configs, with the global defaults.
+func (cfg *Config) promConfig() *config.Config {
+	global := config.DefaultGlobalConfig
+	global.ScrapeInterval = model.Duration(cfg.ScrapeInterval)
+	global.ScrapeTimeout = model.Duration(cfg.ScrapeTimeout)
+	return &config.Config{GlobalConfig: global, ScrapeConfigs: cfg.ScrapeConfigs}
+}
+
+// Scraper discovers and scrapes the targets, pushing the samples of each scrape in a single
+// write request.
+type Scraper struct {
+	services.Service
+
+	cfg    Config
+	pushFn push.Func
+	logger log.Logger
+
+	scrapeManager *scrape.Manager
+
+	pushedSamples prometheus.Counter
+	failedPushes  prometheus.Counter
+}
+
+// NewScraper makes a new Scraper, pushing the samples to pushFn.
+func NewScraper(cfg Config, pushFn push.Func, logger log.Logger, reg prometheus.Registerer) *Scraper {
+	s := &Scraper{
+		cfg:    cfg,
+		pushFn: pushFn,
+		logger: logger,
+		pushedSamples: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_scraper_samples_pushed_total",
+			Help: "Total number of scraped samples pushed.",
+		}),
+		failedPushes: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_scraper_pushes_failed_total",
+			Help: "Total number of pushes of the scraped samples which failed. The samples of a failed push are lost.",
+		}),
+	}
+
+	s.Service = services.NewBasicService(s.starting, s.running, nil)
+	return s
+}
+
+func (s *Scraper) starting(_ context.Context) error {
+	s.scrapeManager = scrape.NewManager(&scrape.Options{}, log.With(s.logger, "component", "scrape manager"), s)
+	return errors.Wrap(s.scrapeManager.ApplyConfig(s.cfg.promConfig()), "apply the scrape configs")
+}
+
+func (s *Scraper) running(ctx context.Context) error {
+	// The discovery runs until the context is canceled.
+	discoveryManager := discovery.NewManager(ctx, log.With(s.logger, "component", "discovery manager"))
+	sdConfigs := make(map[string]discovery.Configs, len(s.cfg.ScrapeConfigs))
+	for _, scfg := range s.cfg.ScrapeConfigs {
+		sdConfigs[scfg.JobName] = scfg.ServiceDiscoveryConfigs
+	}
+	if err := discoveryManager.ApplyConfig(sdConfigs); err != nil {
+		return errors.Wrap(err, "apply the service discovery configs")
+	}
+
+	go func() { _ = discoveryManager.Run() }()
+	go func() { _ = s.scrapeManager.Run(discoveryManager.SyncCh()) }()
+
+	<-ctx.Done()
+	s.scrapeManager.Stop()
+	return nil
+}
+
+// Appender implements storage.Appendable.
+func (s *Scraper) Appender(ctx context.Context) storage.Appender {
+	return &appender{ctx: ctx, scraper: s}
+}
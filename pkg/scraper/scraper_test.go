@@ -0,0 +1,243 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		yaml     string
+		setup    func(cfg *Config)
+		expected string
+	}{
+		"disabled": {
+			setup: func(cfg *Config) { cfg.Tenant = "" },
+		},
+		"static targets": {
+			yaml: `
+scrape_configs:
+  - job_name: node
+    static_configs:
+      - targets: ["localhost:9100"]
+`,
+		},
+		"invalid tenant": {
+			yaml: `
+scrape_configs:
+  - job_name: node
+    static_configs:
+      - targets: ["localhost:9100"]
+`,
+			setup:    func(cfg *Config) { cfg.Tenant = "" },
+			expected: "invalid scraper tenant",
+		},
+		"invalid scrape interval": {
+			yaml: `
+scrape_configs:
+  - job_name: node
+    static_configs:
+      - targets: ["localhost:9100"]
+`,
+			setup:    func(cfg *Config) { cfg.ScrapeInterval = 0 },
+			expected: errInvalidScrapeInterval.Error(),
+		},
+		"scrape timeout greater than the interval": {
+			yaml: `
+scrape_configs:
+  - job_name: node
+    scrape_interval: 5s
+    scrape_timeout: 10s
+    static_configs:
+      - targets: ["localhost:9100"]
+`,
+			expected: "scrape timeout greater than scrape interval",
+		},
+		"duplicated job": {
+			yaml: `
+scrape_configs:
+  - job_name: node
+    static_configs:
+      - targets: ["localhost:9100"]
+  - job_name: node
+    static_configs:
+      - targets: ["localhost:9101"]
+`,
+			expected: "found multiple scrape configs",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			require.NoError(t, yaml.UnmarshalStrict([]byte(tc.yaml), &cfg))
+			if tc.setup != nil {
+				tc.setup(&cfg)
+			}
+
+			err := cfg.Validate()
+			if tc.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expected)
+		})
+	}
+}
+
+func TestConfig_Validate_SetsTheDefaults(t *testing.T) {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	require.NoError(t, yaml.UnmarshalStrict([]byte(`
+scrape_configs:
+  - job_name: node
+    static_configs:
+      - targets: ["localhost:9100"]
+`), &cfg))
+	cfg.ScrapeInterval = 15 * time.Second
+
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, 15*time.Second, time.Duration(cfg.ScrapeConfigs[0].ScrapeInterval))
+	assert.Equal(t, 10*time.Second, time.Duration(cfg.ScrapeConfigs[0].ScrapeTimeout))
+}
+
+// pushMock records the write requests pushed, by tenant.
+type pushMock struct {
+	mtx      sync.Mutex
+	err      error
+	requests map[string][]*cortexpb.WriteRequest
+}
+
+func (m *pushMock) push(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.err != nil {
+		return nil, m.err
+	}
+	userID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if m.requests == nil {
+		m.requests = map[string][]*cortexpb.WriteRequest{}
+	}
+	m.requests[userID] = append(m.requests[userID], req)
+	return &cortexpb.WriteResponse{}, nil
+}
+
+func (m *pushMock) received(userID string) []*cortexpb.WriteRequest {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return append([]*cortexpb.WriteRequest(nil), m.requests[userID]...)
+}
+
+func TestAppender(t *testing.T) {
+	mock := &pushMock{}
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Tenant = "user-1"
+	s := NewScraper(cfg, mock.push, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+
+	app := s.Appender(context.Background())
+	_, err := app.Append(0, labels.FromStrings("__name__", "up", "job", "node"), 10, 1)
+	require.NoError(t, err)
+	_, err = app.Append(0, labels.FromStrings("__name__", "node_load1", "job", "node"), 10, 0.5)
+	require.NoError(t, err)
+	_, err = app.AppendHistogram(0, labels.FromStrings("__name__", "latency", "job", "node"), 10, nil, nil)
+	require.ErrorIs(t, err, errNativeHistograms)
+	require.NoError(t, app.Commit())
+
+	// The samples of the rolled back scrapes and the empty scrapes aren't pushed.
+	app = s.Appender(context.Background())
+	_, err = app.Append(0, labels.FromStrings("__name__", "up", "job", "node"), 20, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Rollback())
+	require.NoError(t, s.Appender(context.Background()).Commit())
+
+	requests := mock.received("user-1")
+	require.Len(t, requests, 1)
+	require.Len(t, requests[0].Timeseries, 2)
+	assert.Equal(t, cortexpb.API, requests[0].Source)
+	assert.Equal(t, labels.FromStrings("__name__", "up", "job", "node"), cortexpb.FromLabelAdaptersToLabels(requests[0].Timeseries[0].Labels))
+	assert.Equal(t, []cortexpb.Sample{{TimestampMs: 10, Value: 1}}, requests[0].Timeseries[0].Samples)
+	assert.Equal(t, []cortexpb.Sample{{TimestampMs: 10, Value: 0.5}}, requests[0].Timeseries[1].Samples)
+	assert.Equal(t, float64(2), testutil.ToFloat64(s.pushedSamples))
+
+	// The failed pushes are counted, and reported to the scrape loop.
+	mock.err = errors.New("rate limited")
+	app = s.Appender(context.Background())
+	_, err = app.Append(0, labels.FromStrings("__name__", "up", "job", "node"), 30, 1)
+	require.NoError(t, err)
+	require.Error(t, app.Commit())
+	assert.Equal(t, float64(1), testutil.ToFloat64(s.failedPushes))
+}
+
+func TestScraper(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, "# TYPE node_load1 gauge")
+		fmt.Fprintln(w, "node_load1 0.5")
+	}))
+	defer target.Close()
+
+	targetURL, err := url.Parse(target.URL)
+	require.NoError(t, err)
+
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	require.NoError(t, yaml.UnmarshalStrict([]byte(fmt.Sprintf(`
+tenant: user-1
+scrape_interval: 100ms
+scrape_timeout: 100ms
+scrape_configs:
+  - job_name: node
+    static_configs:
+      - targets: [%q]
+`, targetURL.Host)), &cfg))
+	require.NoError(t, cfg.Validate())
+
+	mock := &pushMock{}
+	s := NewScraper(cfg, mock.push, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), s))
+	defer func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), s))
+	}()
+
+	// The targets are synced by the scrape manager every 5s.
+	require.Eventually(t, func() bool { return len(mock.received("user-1")) > 0 }, 15*time.Second, 100*time.Millisecond)
+
+	// The scraped samples are pushed along with the samples of the scrape health.
+	series := map[string]cortexpb.PreallocTimeseries{}
+	for _, ts := range mock.received("user-1")[0].Timeseries {
+		lbls := cortexpb.FromLabelAdaptersToLabels(ts.Labels)
+		assert.Equal(t, "node", lbls.Get("job"))
+		assert.Equal(t, targetURL.Host, lbls.Get("instance"))
+		series[lbls.Get(labels.MetricName)] = ts
+	}
+	require.Contains(t, series, "node_load1")
+	require.Contains(t, series, "up")
+	assert.Equal(t, 0.5, series["node_load1"].Samples[0].Value)
+	assert.Equal(t, float64(1), series["up"].Samples[0].Value)
+}
@@ -0,0 +1,330 @@
+package seriesdeletion
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	prom_tsdb "github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc/metadata"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+
+	"objectstorage/pkg/storage/bucket"
+	"objectstorage/pkg/storage/tsdb/bucketindex"
+	"objectstorage/pkg/tenant"
+)
+
+// TombstonesFunc returns the tombstones of the tenant.
+type TombstonesFunc func(ctx context.Context, userID string) (bucketindex.Tombstones, error)
+
+// TombstonesLoader loads the tombstones of the tenants from their bucket index, reloaded once
+// older than the TTL. The tenants without a bucket index have no tombstones.
+type TombstonesLoader struct {
+	bkt         objstore.Bucket
+	cfgProvider bucket.TenantConfigProvider
+	ttl         time.Duration
+	logger      log.Logger
+
+	mtx     sync.Mutex
+	tenants map[string]*loadedTombstones
+}
+
+type loadedTombstones struct {
+	tombstones bucketindex.Tombstones
+	loadedAt   time.Time
+}
+
+// NewTombstonesLoader makes a new TombstonesLoader.
+func NewTombstonesLoader(bkt objstore.Bucket, cfgProvider bucket.TenantConfigProvider, ttl time.Duration, logger log.Logger) *TombstonesLoader {
+	return &TombstonesLoader{
+		bkt:         bkt,
+		cfgProvider: cfgProvider,
+		ttl:         ttl,
+		logger:      logger,
+		tenants:     map[string]*loadedTombstones{},
+	}
+}
+
+// Tombstones returns the tombstones of the tenant. It's a TombstonesFunc.
+func (l *TombstonesLoader) Tombstones(ctx context.Context, userID string) (bucketindex.Tombstones, error) {
+	l.mtx.Lock()
+	loaded, ok := l.tenants[userID]
+	l.mtx.Unlock()
+	if ok && time.Since(loaded.loadedAt) < l.ttl {
+		return loaded.tombstones, nil
+	}
+
+	// Concurrent loads of the same tenant are harmless, the last one wins.
+	idx, err := bucketindex.ReadIndex(ctx, l.bkt, userID, l.cfgProvider, l.logger)
+	if err != nil && !errors.Is(err, bucketindex.ErrIndexNotFound) {
+		return nil, errors.Wrap(err, "read bucket index")
+	}
+	loaded = &loadedTombstones{loadedAt: time.Now()}
+	if idx != nil {
+		loaded.tombstones = idx.Tombstones
+	}
+
+	l.mtx.Lock()
+	l.tenants[userID] = loaded
+	l.mtx.Unlock()
+	return loaded.tombstones, nil
+}
+
+// deletion is a series selector of a tombstone, along with its deleted time range.
+type deletion struct {
+	matchers []*labels.Matcher
+	interval tombstones.Interval
+}
+
+type deletions []deletion
+
+// deletionsOf returns the deletions of the tombstones overlapping the time range.
+func deletionsOf(ts bucketindex.Tombstones, mint, maxt int64) (deletions, error) {
+	var out deletions
+	for _, t := range ts {
+		if t.EndTime < mint || t.StartTime > maxt {
+			continue
+		}
+		sets, err := t.Matchers()
+		if err != nil {
+			return nil, err
+		}
+		for _, matchers := range sets {
+			out = append(out, deletion{matchers: matchers, interval: tombstones.Interval{Mint: t.StartTime, Maxt: t.EndTime}})
+		}
+	}
+	return out, nil
+}
+
+// intervals returns the deleted time ranges of the series.
+func (d deletions) intervals(lbls labels.Labels) tombstones.Intervals {
+	var intervals tombstones.Intervals
+DeletionsLoop:
+	for _, del := range d {
+		for _, m := range del.matchers {
+			if !m.Matches(lbls.Get(m.Name)) {
+				continue DeletionsLoop
+			}
+		}
+		intervals = intervals.Add(del.interval)
+	}
+	return intervals
+}
+
+// Queryable masks the samples deleted by the tombstones of the tenant, until the blocks holding
+// them are rewritten without them. The series whose samples are all deleted are still
+// selected, without samples.
+func Queryable(queryable storage.Queryable, tombstonesOf TombstonesFunc) storage.Queryable {
+	return storage.QueryableFunc(func(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+		userID, err := tenant.TenantID(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ts, err := tombstonesOf(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		deletions, err := deletionsOf(ts, mint, maxt)
+		if err != nil {
+			return nil, err
+		}
+
+		querier, err := queryable.Querier(ctx, mint, maxt)
+		if err != nil || len(deletions) == 0 {
+			return querier, err
+		}
+		return maskingQuerier{Querier: querier, deletions: deletions}, nil
+	})
+}
+
+type maskingQuerier struct {
+	storage.Querier
+
+	deletions deletions
+}
+
+func (q maskingQuerier) Select(sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	return &maskingSeriesSet{SeriesSet: q.Querier.Select(sortSeries, hints, matchers...), deletions: q.deletions}
+}
+
+type maskingSeriesSet struct {
+	storage.SeriesSet
+
+	deletions deletions
+}
+
+func (s *maskingSeriesSet) At() storage.Series {
+	series := s.SeriesSet.At()
+	intervals := s.deletions.intervals(series.Labels())
+	if len(intervals) == 0 {
+		return series
+	}
+	return &maskingSeries{Series: series, intervals: intervals}
+}
+
+type maskingSeries struct {
+	storage.Series
+
+	intervals tombstones.Intervals
+}
+
+func (s *maskingSeries) Iterator(it chunkenc.Iterator) chunkenc.Iterator {
+	if d, ok := it.(*prom_tsdb.DeletedIterator); ok {
+		it = d.Iter
+	}
+	return &prom_tsdb.DeletedIterator{Iter: s.Series.Iterator(it), Intervals: s.intervals}
+}
+
+// StoreGatewayServer masks the samples deleted by the tombstones of the tenant in the series
+// streamed by the store-gateway, re-encoding the chunks holding deleted samples. The chunks
+// left without samples are dropped.
+func StoreGatewayServer(srv storegatewaypb.StoreGatewayServer, tombstonesOf TombstonesFunc) storegatewaypb.StoreGatewayServer {
+	return &maskingStoreGateway{StoreGatewayServer: srv, tombstonesOf: tombstonesOf}
+}
+
+type maskingStoreGateway struct {
+	storegatewaypb.StoreGatewayServer
+
+	tombstonesOf TombstonesFunc
+}
+
+func (g *maskingStoreGateway) Series(req *storepb.SeriesRequest, stream storegatewaypb.StoreGateway_SeriesServer) error {
+	// The tenant is sent in the gRPC metadata by the queriers, like the store-gateway expects.
+	var userID string
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
+		if values := md.Get(cortex_tsdb.TenantIDExternalLabel); len(values) == 1 {
+			userID = values[0]
+		}
+	}
+	if userID == "" {
+		return g.StoreGatewayServer.Series(req, stream)
+	}
+
+	ts, err := g.tombstonesOf(stream.Context(), userID)
+	if err != nil {
+		return err
+	}
+	deletions, err := deletionsOf(ts, req.MinTime, req.MaxTime)
+	if err != nil {
+		return err
+	}
+	if len(deletions) == 0 {
+		return g.StoreGatewayServer.Series(req, stream)
+	}
+	return g.StoreGatewayServer.Series(req, &maskingSeriesServer{StoreGateway_SeriesServer: stream, deletions: deletions})
+}
+
+type maskingSeriesServer struct {
+	storegatewaypb.StoreGateway_SeriesServer
+
+	deletions deletions
+}
+
+func (s *maskingSeriesServer) Send(resp *storepb.SeriesResponse) error {
+	series := resp.GetSeries()
+	if series == nil {
+		return s.StoreGateway_SeriesServer.Send(resp)
+	}
+	intervals := s.deletions.intervals(labelpb.ZLabelsToPromLabels(series.Labels))
+	if len(intervals) == 0 {
+		return s.StoreGateway_SeriesServer.Send(resp)
+	}
+
+	chunks := series.Chunks[:0]
+	for _, c := range series.Chunks {
+		masked, ok, err := maskChunk(c, intervals)
+		if err != nil {
+			return err
+		}
+		if ok {
+			chunks = append(chunks, masked)
+		}
+	}
+	series.Chunks = chunks
+	return s.StoreGateway_SeriesServer.Send(resp)
+}
+
+// maskChunk returns the chunk without the samples within the intervals, false if none is left.
+func maskChunk(c storepb.AggrChunk, intervals tombstones.Intervals) (storepb.AggrChunk, bool, error) {
+	if !overlaps(c, intervals) {
+		return c, true, nil
+	}
+	if c.Raw == nil {
+		return c, false, nil
+	}
+
+	var enc chunkenc.Encoding
+	switch c.Raw.Type {
+	case storepb.Chunk_XOR:
+		enc = chunkenc.EncXOR
+	case storepb.Chunk_HISTOGRAM:
+		enc = chunkenc.EncHistogram
+	default:
+		return c, false, errors.Errorf("unsupported chunk encoding %s", c.Raw.Type)
+	}
+	raw, err := chunkenc.FromData(enc, c.Raw.Data)
+	if err != nil {
+		return c, false, errors.Wrap(err, "decode chunk")
+	}
+	out, err := chunkenc.NewEmptyChunk(enc)
+	if err != nil {
+		return c, false, err
+	}
+	app, err := out.Appender()
+	if err != nil {
+		return c, false, err
+	}
+
+	masked := storepb.AggrChunk{MinTime: c.MaxTime, MaxTime: c.MinTime}
+	it := &prom_tsdb.DeletedIterator{Iter: raw.Iterator(nil), Intervals: intervals}
+	for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+		t := it.AtT()
+		switch vt {
+		case chunkenc.ValFloat:
+			_, v := it.At()
+			app.Append(t, v)
+		case chunkenc.ValHistogram:
+			_, h := it.AtHistogram()
+			app.AppendHistogram(t, h)
+		case chunkenc.ValFloatHistogram:
+			_, h := it.AtFloatHistogram()
+			app.AppendFloatHistogram(t, h)
+		}
+		if t < masked.MinTime {
+			masked.MinTime = t
+		}
+		if t > masked.MaxTime {
+			masked.MaxTime = t
+		}
+	}
+	if err := it.Err(); err != nil {
+		return c, false, errors.Wrap(err, "iterate chunk")
+	}
+	if out.NumSamples() == 0 {
+		return c, false, nil
+	}
+
+	masked.Raw = &storepb.Chunk{Type: c.Raw.Type, Data: out.Bytes()}
+	return masked, true, nil
+}
+
+// overlaps returns whether the time range of the chunk overlaps any of the intervals.
+func overlaps(c storepb.AggrChunk, intervals tombstones.Intervals) bool {
+	for _, i := range intervals {
+		if i.Mint <= c.MaxTime && c.MinTime <= i.Maxt {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package seriesdeletion
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/cortexproject/cortex/pkg/compactor"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/storage/bucket"
+	"objectstorage/pkg/storage/tsdb/bucketindex"
+)
+
+func TestTombstonesLoader_ShouldKeepTheTombstonesOnceTheCompactorRewritesTheIndex(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	d := newTestDeleter(t, bkt)
+
+	uploadBlock(t, bucket.NewUserBucketClient("user-1", bkt, nil), 0, 100000)
+	require.Equal(t, http.StatusNoContent, deleteSeries(d, "user-1", url.Values{"match[]": {`{job="a"}`}}).Code)
+	updateIndex(t, bkt)
+
+	// A compactor cleanup cycle rewrites the bucket index of the tenant.
+	cleaner := compactor.NewBlocksCleaner(compactor.BlocksCleanerConfig{
+		DeletionDelay:      time.Hour,
+		CleanupInterval:    time.Hour,
+		CleanupConcurrency: 1,
+	}, bkt, cortex_tsdb.NewUsersScanner(bkt, cortex_tsdb.AllUsers, log.NewNopLogger()), retentionProvider{}, log.NewNopLogger(), nil)
+	require.NoError(t, services.StartAndAwaitRunning(ctx, cleaner))
+	require.NoError(t, services.StopAndAwaitTerminated(ctx, cleaner))
+
+	ts, err := NewTombstonesLoader(bkt, nil, time.Minute, log.NewNopLogger()).Tombstones(ctx, "user-1")
+	require.NoError(t, err)
+	require.Len(t, ts, 1)
+	assert.Equal(t, []string{`{job="a"}`}, ts[0].Selectors)
+}
+
+func TestTombstonesLoader_Tombstones(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	d := newTestDeleter(t, bkt)
+	l := NewTombstonesLoader(bkt, nil, time.Hour, log.NewNopLogger())
+
+	// The tenants without a bucket index have no tombstones.
+	ts, err := l.Tombstones(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Empty(t, ts)
+
+	require.Equal(t, http.StatusNoContent, deleteSeries(d, "user-1", url.Values{"match[]": {`{job="a"}`}}).Code)
+	updateIndex(t, bkt)
+
+	// The tombstones are reloaded once older than the TTL.
+	ts, err = l.Tombstones(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Empty(t, ts)
+
+	l.ttl = 0
+	ts, err = l.Tombstones(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Len(t, ts, 1)
+}
+
+func TestStoreGatewayServer_ShouldMaskTheDeletedSamples(t *testing.T) {
+	srv := &storeGatewayMock{series: []*storepb.Series{
+		newSeries(t, labels.FromStrings("__name__", "up", "job", "a"), [][]int64{{0, 10, 20}, {30, 40}}),
+		newSeries(t, labels.FromStrings("__name__", "up", "job", "b"), [][]int64{{0, 10, 20}, {30, 40}}),
+	}}
+	g := StoreGatewayServer(srv, func(_ context.Context, userID string) (bucketindex.Tombstones, error) {
+		require.Equal(t, "user-1", userID)
+		return bucketindex.Tombstones{{RequestID: "request-1", Selectors: []string{`{job="a"}`}, StartTime: 5, EndTime: 40}}, nil
+	})
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(cortex_tsdb.TenantIDExternalLabel, "user-1"))
+	stream := &seriesServerMock{ctx: ctx}
+	require.NoError(t, g.Series(&storepb.SeriesRequest{MinTime: 0, MaxTime: 100}, stream))
+
+	require.Len(t, stream.series, 2)
+	assert.Equal(t, [][]int64{{0}}, chunksTimestamps(t, stream.series[0]))
+	assert.Equal(t, int64(0), stream.series[0].Chunks[0].MaxTime)
+	assert.Equal(t, [][]int64{{0, 10, 20}, {30, 40}}, chunksTimestamps(t, stream.series[1]))
+}
+
+type storeGatewayMock struct {
+	storegatewaypb.StoreGatewayServer
+
+	series []*storepb.Series
+}
+
+func (m *storeGatewayMock) Series(_ *storepb.SeriesRequest, stream storegatewaypb.StoreGateway_SeriesServer) error {
+	for _, s := range m.series {
+		if err := stream.Send(storepb.NewSeriesResponse(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type seriesServerMock struct {
+	storegatewaypb.StoreGateway_SeriesServer
+
+	ctx    context.Context
+	series []*storepb.Series
+}
+
+func (m *seriesServerMock) Context() context.Context {
+	return m.ctx
+}
+
+func (m *seriesServerMock) Send(resp *storepb.SeriesResponse) error {
+	m.series = append(m.series, resp.GetSeries())
+	return nil
+}
+
+// newSeries returns the series with a XOR chunk per timestamps slice.
+func newSeries(t *testing.T, lbls labels.Labels, chunks [][]int64) *storepb.Series {
+	s := &storepb.Series{Labels: labelpb.ZLabelsFromPromLabels(lbls)}
+	for _, timestamps := range chunks {
+		c := chunkenc.NewXORChunk()
+		app, err := c.Appender()
+		require.NoError(t, err)
+		for _, ts := range timestamps {
+			app.Append(ts, 1)
+		}
+		s.Chunks = append(s.Chunks, storepb.AggrChunk{
+			MinTime: timestamps[0],
+			MaxTime: timestamps[len(timestamps)-1],
+			Raw:     &storepb.Chunk{Type: storepb.Chunk_XOR, Data: c.Bytes()},
+		})
+	}
+	return s
+}
+
+func chunksTimestamps(t *testing.T, s *storepb.Series) [][]int64 {
+	var out [][]int64
+	for _, c := range s.Chunks {
+		raw, err := chunkenc.FromData(chunkenc.EncXOR, c.Raw.Data)
+		require.NoError(t, err)
+
+		var timestamps []int64
+		it := raw.Iterator(nil)
+		for it.Next() != chunkenc.ValNone {
+			timestamps = append(timestamps, it.AtT())
+		}
+		require.NoError(t, it.Err())
+		out = append(out, timestamps)
+	}
+	return out
+}
+
+// retentionProvider disables the retention of the blocks of all the tenants.
+type retentionProvider struct{}
+
+func (retentionProvider) S3SSEType(string) string                 { return "" }
+func (retentionProvider) S3SSEKMSKeyID(string) string             { return "" }
+func (retentionProvider) S3SSEKMSEncryptionContext(string) string { return "" }
+
+func (retentionProvider) CompactorBlocksRetentionPeriod(string) time.Duration {
+	return 0
+}
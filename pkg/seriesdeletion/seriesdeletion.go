@@ -0,0 +1,451 @@
+// Package seriesdeletion deletes the samples of series within a time range on request. The
+// deletion requests are recorded as tombstones in the bucket, tracked by the bucket index so
+// that the readers mask the deleted samples, and the blocks holding them are eventually
+// rewritten without them.
+package seriesdeletion
+
+import (
+	"context"
+	"crypto/rand"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	prom_tsdb "github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compactv2"
+
+	"github.com/cortexproject/cortex/pkg/util/concurrency"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/storage/bucket"
+	bucket_tsdb "objectstorage/pkg/storage/tsdb"
+	"objectstorage/pkg/storage/tsdb/bucketindex"
+	"objectstorage/pkg/tenant"
+	"objectstorage/pkg/util/promapi"
+)
+
+// DeletePath is the path of the series deletion API, matching the Prometheus one.
+const DeletePath = "/api/v1/admin/tsdb/delete_series"
+
+var (
+	errInvalidInterval    = errors.New("the series deletion rewrite interval must be greater than 0")
+	errInvalidConcurrency = errors.New("the series deletion rewrite concurrency must be greater than 0")
+	errMissingDir         = errors.New("the series deletion rewrite directory is required")
+	errInvalidTTL         = errors.New("the series deletion tombstones TTL must be greater than 0")
+	errMissingMatchers    = errors.New("no match[] parameter provided")
+)
+
+// Config holds the configuration of the series deletion.
+type Config struct {
+	Enabled            bool          `yaml:"enabled"`
+	RewriteInterval    time.Duration `yaml:"rewrite_interval"`
+	RewriteConcurrency int           `yaml:"rewrite_concurrency"`
+	RewriteDir         string        `yaml:"rewrite_dir"`
+	TombstonesTTL      time.Duration `yaml:"tombstones_ttl"`
+}
+
+// RegisterFlags registers the series deletion flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "series-deletion.enabled", false, "True to serve the series deletion API. The deleted samples are masked on read once the bucket index is updated, and the blocks holding them rewritten by the compactor. Requires the bucket index to be enabled. The head API and store-gateway targets mask the deleted samples only if enabled.")
+	f.DurationVar(&cfg.RewriteInterval, "series-deletion.rewrite-interval", time.Hour, "How frequently the compactor rewrites the blocks holding deleted samples.")
+	f.IntVar(&cfg.RewriteConcurrency, "series-deletion.rewrite-concurrency", 1, "Number of tenants whose blocks are rewritten concurrently.")
+	f.StringVar(&cfg.RewriteDir, "series-deletion.rewrite-dir", "./series-deletion", "Directory the blocks are downloaded to and rewritten in.")
+	f.DurationVar(&cfg.TombstonesTTL, "series-deletion.tombstones-ttl", time.Minute, "How long the tombstones of a tenant are cached by the head API and store-gateway before being read again from its bucket index.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.RewriteInterval <= 0 {
+		return errInvalidInterval
+	}
+	if cfg.RewriteConcurrency <= 0 {
+		return errInvalidConcurrency
+	}
+	if cfg.RewriteDir == "" {
+		return errMissingDir
+	}
+	if cfg.TombstonesTTL <= 0 {
+		return errInvalidTTL
+	}
+	return nil
+}
+
+// LeaderRunner runs a job only on the elected leader. It's implemented by leaderelection.Elector.
+type LeaderRunner interface {
+	services.Service
+	RunIfLeader(f func(ctx context.Context) error) func(ctx context.Context) error
+}
+
+// Deleter records the series deletion requests as tombstones, and rewrites the blocks holding
+// deleted samples if enabled. The blocks are looked up in the bucket index, so the tenants
+// without an index yet are skipped, and the blocks marked for deletion aren't rewritten.
+//
+// A rewritten block records the deletions applied in its meta, so that it isn't rewritten
+// again. The blocks compacted from rewritten blocks lose this record and are checked again,
+// the ones without deleted samples being remembered until restart.
+type Deleter struct {
+	services.Service
+
+	cfg         Config
+	bkt         objstore.Bucket
+	cfgProvider bucket.TenantConfigProvider
+	leader      LeaderRunner
+	logger      log.Logger
+
+	// checked are the blocks checked without samples deleted by the tombstone, by request ID.
+	checkedMtx sync.Mutex
+	checked    map[string]map[ulid.ULID]struct{}
+
+	requests          prometheus.Counter
+	runs              *prometheus.CounterVec
+	rewrittenBlocks   prometheus.Counter
+	failedBlocks      prometheus.Counter
+	deletedBlocks     prometheus.Counter
+	lastSuccessfulRun prometheus.Gauge
+}
+
+// NewDeleter makes a new Deleter. The blocks are rewritten only if rewrite is true, by the
+// leader only unless the leader is nil.
+func NewDeleter(cfg Config, bkt objstore.Bucket, cfgProvider bucket.TenantConfigProvider, rewrite bool, leader LeaderRunner, logger log.Logger, reg prometheus.Registerer) *Deleter {
+	d := &Deleter{
+		cfg:         cfg,
+		bkt:         bkt,
+		cfgProvider: cfgProvider,
+		leader:      leader,
+		logger:      logger,
+		checked:     map[string]map[ulid.ULID]struct{}{},
+		requests: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_series_deletion_requests_total",
+			Help: "Total number of series deletion requests recorded.",
+		}),
+		runs: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_series_deletion_rewrite_runs_total",
+			Help: "Total number of runs rewriting the blocks holding deleted samples of all the tenants, by outcome.",
+		}, []string{"outcome"}),
+		rewrittenBlocks: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_series_deletion_rewritten_blocks_total",
+			Help: "Total number of blocks rewritten without the deleted samples.",
+		}),
+		failedBlocks: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_series_deletion_failed_blocks_total",
+			Help: "Total number of blocks failed to be rewritten. They're rewritten again on the next run.",
+		}),
+		deletedBlocks: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_series_deletion_blocks_marked_for_deletion_total",
+			Help: "Total number of blocks marked for deletion once rewritten.",
+		}),
+		lastSuccessfulRun: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_series_deletion_rewrite_last_successful_run_timestamp_seconds",
+			Help: "Unix timestamp of the last run having rewritten the blocks holding deleted samples of all the tenants.",
+		}),
+	}
+
+	if rewrite {
+		d.Service = services.NewTimerService(cfg.RewriteInterval, d.starting, d.iteration, d.stopping)
+	} else {
+		d.Service = services.NewIdleService(nil, nil)
+	}
+	return d
+}
+
+func (d *Deleter) starting(ctx context.Context) error {
+	if d.leader != nil {
+		if err := services.StartAndAwaitRunning(ctx, d.leader); err != nil {
+			return errors.Wrap(err, "start series deletion leader election")
+		}
+	}
+	return nil
+}
+
+func (d *Deleter) stopping(_ error) error {
+	if d.leader != nil {
+		return services.StopAndAwaitTerminated(context.Background(), d.leader)
+	}
+	return nil
+}
+
+// DeleteHandler records the deletion of the samples of the series matching the match[]
+// selectors within the start and end time range, defaulting to the whole time range.
+func (d *Deleter) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := tenant.TenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	start, end, err := promapi.ParseTimeRange(r)
+	if err != nil {
+		promapi.WriteError(w, promapi.ErrorBadData, err, d.logger)
+		return
+	}
+	sets, err := promapi.ParseMatchers(r)
+	if err != nil {
+		promapi.WriteError(w, promapi.ErrorBadData, err, d.logger)
+		return
+	}
+	if len(sets) == 0 {
+		promapi.WriteError(w, promapi.ErrorBadData, errMissingMatchers, d.logger)
+		return
+	}
+
+	t := &bucketindex.Tombstone{
+		RequestID:    ulid.MustNew(ulid.Now(), rand.Reader).String(),
+		Version:      bucketindex.TombstoneVersion1,
+		Selectors:    selectors(sets),
+		StartTime:    timestamp.FromTime(start),
+		EndTime:      timestamp.FromTime(end),
+		CreationTime: time.Now().Unix(),
+	}
+	if err := bucketindex.WriteTombstone(r.Context(), bucket.NewUserBucketClient(userID, d.bkt, d.cfgProvider), t); err != nil {
+		level.Error(d.logger).Log("msg", "failed to write tombstone", "user", userID, "err", err)
+		promapi.WriteError(w, promapi.ErrorInternal, err, d.logger)
+		return
+	}
+
+	d.requests.Inc()
+	level.Info(d.logger).Log("msg", "series deletion requested", "user", userID, "request_id", t.RequestID, "selectors", strings.Join(t.Selectors, ","), "start", start, "end", end)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// selectors returns the series selectors of the sets of matchers.
+func selectors(sets [][]*labels.Matcher) []string {
+	out := make([]string, 0, len(sets))
+	for _, matchers := range sets {
+		s := make([]string, 0, len(matchers))
+		for _, m := range matchers {
+			s = append(s, m.String())
+		}
+		out = append(out, "{"+strings.Join(s, ", ")+"}")
+	}
+	return out
+}
+
+func (d *Deleter) iteration(ctx context.Context) error {
+	rewrite := d.rewriteAll
+	if d.leader != nil {
+		rewrite = d.leader.RunIfLeader(d.rewriteAll)
+	}
+	if err := rewrite(ctx); err != nil {
+		d.runs.WithLabelValues("failed").Inc()
+		level.Warn(d.logger).Log("msg", "failed to rewrite the blocks holding deleted samples", "err", err)
+		return nil
+	}
+	d.runs.WithLabelValues("success").Inc()
+	d.lastSuccessfulRun.SetToCurrentTime()
+	return nil
+}
+
+// rewriteAll rewrites the blocks holding deleted samples of all the tenants in the bucket. It
+// fails if the blocks of any tenant failed to be rewritten, the others being rewritten anyway.
+func (d *Deleter) rewriteAll(ctx context.Context) error {
+	users, _, err := bucket_tsdb.NewUsersScanner(d.bkt, bucket_tsdb.AllUsers, d.logger).ScanUsers(ctx)
+	if err != nil {
+		return errors.Wrap(err, "scan users")
+	}
+
+	return concurrency.ForEachUser(ctx, users, d.cfg.RewriteConcurrency, d.rewriteTenant)
+}
+
+// rewriteTenant rewrites the blocks of the tenant overlapping the time range of its
+// tombstones, not marked for deletion.
+func (d *Deleter) rewriteTenant(ctx context.Context, userID string) error {
+	idx, err := bucketindex.ReadIndex(ctx, d.bkt, userID, d.cfgProvider, d.logger)
+	if errors.Is(err, bucketindex.ErrIndexNotFound) {
+		level.Debug(d.logger).Log("msg", "bucket index not found, skipping the series deletion of the tenant", "user", userID)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "read bucket index of tenant %s", userID)
+	}
+	if len(idx.Tombstones) == 0 {
+		return nil
+	}
+
+	deleted := make(map[ulid.ULID]struct{}, len(idx.BlockDeletionMarks))
+	for _, id := range idx.BlockDeletionMarks.GetULIDs() {
+		deleted[id] = struct{}{}
+	}
+
+	userBkt := bucket.NewUserBucketClient(userID, d.bkt, d.cfgProvider)
+
+	var lastErr error
+	for _, b := range idx.Blocks {
+		if _, ok := deleted[b.ID]; ok {
+			continue
+		}
+
+		var overlapping []*bucketindex.Tombstone
+		for _, t := range idx.Tombstones {
+			// The block max time is exclusive, the tombstone end time inclusive.
+			if t.StartTime < b.MaxTime && t.EndTime >= b.MinTime && !d.isChecked(t.RequestID, b.ID) {
+				overlapping = append(overlapping, t)
+			}
+		}
+		if len(overlapping) == 0 {
+			continue
+		}
+
+		if err := d.rewriteBlock(ctx, userID, userBkt, b.ID, overlapping); err != nil {
+			d.failedBlocks.Inc()
+			level.Warn(d.logger).Log("msg", "failed to rewrite the block", "user", userID, "block", b.ID.String(), "err", err)
+			lastErr = err
+		}
+	}
+	return errors.Wrapf(lastErr, "rewrite blocks of tenant %s", userID)
+}
+
+// rewriteBlock rewrites the block without the samples deleted by the tombstones not applied
+// yet, and marks it for deletion. The block is left as is if it holds no deleted sample.
+func (d *Deleter) rewriteBlock(ctx context.Context, userID string, userBkt objstore.Bucket, id ulid.ULID, overlapping []*bucketindex.Tombstone) error {
+	meta, err := block.DownloadMeta(ctx, d.logger, userBkt, id)
+	if err != nil {
+		return errors.Wrap(err, "download meta")
+	}
+
+	applied := map[string]struct{}{}
+	for _, r := range meta.Thanos.Rewrites {
+		for _, del := range r.DeletionsApplied {
+			applied[del.RequestID] = struct{}{}
+		}
+	}
+
+	var pending []*bucketindex.Tombstone
+	var deletions []metadata.DeletionRequest
+	for _, t := range overlapping {
+		if _, ok := applied[t.RequestID]; ok {
+			d.setChecked(t.RequestID, id)
+			continue
+		}
+
+		sets, err := t.Matchers()
+		if err != nil {
+			return err
+		}
+		for _, matchers := range sets {
+			deletions = append(deletions, metadata.DeletionRequest{
+				Matchers:  matchers,
+				Intervals: tombstones.Intervals{{Mint: t.StartTime, Maxt: t.EndTime}},
+				RequestID: t.RequestID,
+			})
+		}
+		pending = append(pending, t)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	dir := filepath.Join(d.cfg.RewriteDir, userID)
+	srcDir, dstDir := filepath.Join(dir, id.String()), filepath.Join(dir, id.String()+".rewrite")
+	for _, p := range []string{srcDir, dstDir} {
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+		defer os.RemoveAll(p)
+	}
+
+	if err := block.Download(ctx, d.logger, userBkt, id, srcDir); err != nil {
+		return errors.Wrap(err, "download block")
+	}
+	pool := chunkenc.NewPool()
+	src, err := prom_tsdb.OpenBlock(d.logger, srcDir, pool)
+	if err != nil {
+		return errors.Wrap(err, "open block")
+	}
+	defer src.Close()
+
+	newID := ulid.MustNew(ulid.Now(), rand.Reader)
+	newDir := filepath.Join(dstDir, newID.String())
+	if err := os.MkdirAll(newDir, os.ModePerm); err != nil {
+		return err
+	}
+	writer, err := block.NewDiskWriter(ctx, d.logger, newDir)
+	if err != nil {
+		return errors.Wrap(err, "create block writer")
+	}
+
+	changes := &deletionCounter{}
+	comp := compactv2.New(dstDir, d.logger, changes, pool)
+	if err := comp.WriteSeries(ctx, []block.Reader{src}, writer, compactv2.NewProgressLogger(d.logger, int(src.Meta().Stats.NumSeries)), compactv2.WithDeletionModifier(deletions...)); err != nil {
+		return errors.Wrap(err, "rewrite series")
+	}
+	stats, err := writer.Flush()
+	if err != nil {
+		return errors.Wrap(err, "flush block")
+	}
+
+	// The block holds no deleted sample, it's remembered not to be checked again.
+	if changes.deleted == 0 {
+		for _, t := range pending {
+			d.setChecked(t.RequestID, id)
+		}
+		return nil
+	}
+
+	meta.ULID = newID
+	meta.Stats = stats
+	meta.Thanos.Rewrites = append(meta.Thanos.Rewrites, metadata.Rewrite{
+		Sources:          meta.Compaction.Sources,
+		DeletionsApplied: deletions,
+	})
+	meta.Compaction.Sources = []ulid.ULID{newID}
+	meta.Thanos.Source = metadata.BucketRewriteSource
+	if err := meta.WriteToDir(d.logger, newDir); err != nil {
+		return errors.Wrap(err, "write meta")
+	}
+
+	if err := block.Upload(ctx, d.logger, userBkt, newDir, metadata.NoneFunc); err != nil {
+		return errors.Wrap(err, "upload rewritten block")
+	}
+	d.rewrittenBlocks.Inc()
+
+	if err := block.MarkForDeletion(ctx, d.logger, bucketindex.BucketWithGlobalMarkers(userBkt), id, "series deleted", d.deletedBlocks); err != nil {
+		return errors.Wrap(err, "mark rewritten block for deletion")
+	}
+	level.Info(d.logger).Log("msg", "rewrote block without the deleted series", "user", userID, "block", id.String(), "new_block", newID.String(), "deleted_series", changes.deleted)
+	return nil
+}
+
+func (d *Deleter) isChecked(requestID string, id ulid.ULID) bool {
+	d.checkedMtx.Lock()
+	defer d.checkedMtx.Unlock()
+	_, ok := d.checked[requestID][id]
+	return ok
+}
+
+func (d *Deleter) setChecked(requestID string, id ulid.ULID) {
+	d.checkedMtx.Lock()
+	defer d.checkedMtx.Unlock()
+	if d.checked[requestID] == nil {
+		d.checked[requestID] = map[ulid.ULID]struct{}{}
+	}
+	d.checked[requestID][id] = struct{}{}
+}
+
+// deletionCounter counts the series whose samples have been deleted by the rewrite.
+type deletionCounter struct {
+	deleted int
+}
+
+func (c *deletionCounter) DeleteSeries(labels.Labels, tombstones.Intervals) { c.deleted++ }
+
+func (c *deletionCounter) ModifySeries(labels.Labels, labels.Labels) {}
@@ -0,0 +1,176 @@
+package seriesdeletion
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+
+	"objectstorage/pkg/storage/bucket"
+	"objectstorage/pkg/storage/tsdb/bucketindex"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *Config)
+		expected error
+	}{
+		"should pass with defaults": {
+			setup: func(*Config) {},
+		},
+		"should pass when disabled with an invalid interval": {
+			setup: func(cfg *Config) { cfg.RewriteInterval = 0 },
+		},
+		"should fail on invalid interval": {
+			setup:    func(cfg *Config) { cfg.Enabled, cfg.RewriteInterval = true, 0 },
+			expected: errInvalidInterval,
+		},
+		"should fail on invalid concurrency": {
+			setup:    func(cfg *Config) { cfg.Enabled, cfg.RewriteConcurrency = true, 0 },
+			expected: errInvalidConcurrency,
+		},
+		"should fail on missing directory": {
+			setup:    func(cfg *Config) { cfg.Enabled, cfg.RewriteDir = true, "" },
+			expected: errMissingDir,
+		},
+		"should fail on invalid tombstones TTL": {
+			setup:    func(cfg *Config) { cfg.Enabled, cfg.TombstonesTTL = true, 0 },
+			expected: errInvalidTTL,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+			assert.Equal(t, tc.expected, cfg.Validate())
+		})
+	}
+}
+
+func TestDeleter_DeleteHandler(t *testing.T) {
+	bkt := objstore.NewInMemBucket()
+	d := newTestDeleter(t, bkt)
+
+	// The selectors are required.
+	rec := deleteSeries(d, "user-1", url.Values{})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = deleteSeries(d, "user-1", url.Values{"match[]": {`up{job="a"}`, `{job="b"}`}, "start": {"10"}, "end": {"20"}})
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	idx, _, _, err := bucketindex.NewUpdater(bkt, "user-1", nil, log.NewNopLogger()).UpdateIndex(context.Background(), nil)
+	require.NoError(t, err)
+	require.Len(t, idx.Tombstones, 1)
+	assert.Equal(t, []string{`{job="a", __name__="up"}`, `{job="b"}`}, idx.Tombstones[0].Selectors)
+	assert.Equal(t, int64(10000), idx.Tombstones[0].StartTime)
+	assert.Equal(t, int64(20000), idx.Tombstones[0].EndTime)
+	assert.Equal(t, 1.0, testutil.ToFloat64(d.requests))
+}
+
+func TestDeleter_ShouldRewriteTheBlocksWithoutTheDeletedSeries(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	userBkt := bucket.NewUserBucketClient("user-1", bkt, nil)
+	d := newTestDeleter(t, bkt)
+
+	matching := uploadBlock(t, userBkt, 0, 100000)
+	notMatching := uploadBlock(t, userBkt, 200000, 300000)
+	require.Equal(t, http.StatusNoContent, deleteSeries(d, "user-1", url.Values{"match[]": {`{job="a"}`}, "end": {"150"}}).Code)
+	updateIndex(t, bkt)
+
+	require.NoError(t, d.rewriteAll(ctx))
+	assert.Equal(t, 1.0, testutil.ToFloat64(d.rewrittenBlocks))
+	assert.Equal(t, 1.0, testutil.ToFloat64(d.deletedBlocks))
+
+	idx := updateIndex(t, bkt)
+	require.Len(t, idx.BlockDeletionMarks, 1)
+	assert.Equal(t, matching, idx.BlockDeletionMarks[0].ID)
+
+	var rewritten ulid.ULID
+	for _, b := range idx.Blocks {
+		if b.ID != matching && b.ID != notMatching {
+			rewritten = b.ID
+		}
+	}
+	assert.Equal(t, []string{"b"}, blockJobs(t, userBkt, rewritten))
+
+	// The rewritten block isn't rewritten again.
+	require.NoError(t, d.rewriteAll(ctx))
+	assert.Equal(t, 1.0, testutil.ToFloat64(d.rewrittenBlocks))
+	assert.Equal(t, 0.0, testutil.ToFloat64(d.failedBlocks))
+}
+
+func newTestDeleter(t *testing.T, bkt objstore.Bucket) *Deleter {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Enabled = true
+	cfg.RewriteDir = t.TempDir()
+	return NewDeleter(cfg, bkt, nil, true, nil, log.NewNopLogger(), nil)
+}
+
+func deleteSeries(d *Deleter, userID string, form url.Values) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, DeletePath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	d.DeleteHandler(rec, req.WithContext(user.InjectOrgID(req.Context(), userID)))
+	return rec
+}
+
+func uploadBlock(t *testing.T, userBkt objstore.Bucket, mint, maxt int64) ulid.ULID {
+	series := []labels.Labels{labels.FromStrings("__name__", "up", "job", "a"), labels.FromStrings("__name__", "up", "job", "b")}
+	dir := t.TempDir()
+	id, err := e2eutil.CreateBlock(context.Background(), dir, series, 100, mint, maxt, labels.FromStrings("__org_id__", "user-1"), 0, metadata.NoneFunc)
+	require.NoError(t, err)
+	require.NoError(t, block.Upload(context.Background(), log.NewNopLogger(), userBkt, filepath.Join(dir, id.String()), metadata.NoneFunc))
+	return id
+}
+
+func updateIndex(t *testing.T, bkt objstore.Bucket) *bucketindex.Index {
+	idx, _, _, err := bucketindex.NewUpdater(bkt, "user-1", nil, log.NewNopLogger()).UpdateIndex(context.Background(), nil)
+	require.NoError(t, err)
+	require.NoError(t, bucketindex.WriteIndex(context.Background(), bkt, "user-1", nil, idx))
+	return idx
+}
+
+// blockJobs returns the job label values of the series of the block.
+func blockJobs(t *testing.T, userBkt objstore.Bucket, id ulid.ULID) []string {
+	dir := filepath.Join(t.TempDir(), id.String())
+	require.NoError(t, block.Download(context.Background(), log.NewNopLogger(), userBkt, id, dir))
+	b, err := tsdb.OpenBlock(nil, dir, nil)
+	require.NoError(t, err)
+	defer b.Close()
+
+	ir, err := b.Index()
+	require.NoError(t, err)
+	defer ir.Close()
+
+	values, err := ir.SortedLabelValues("job")
+	require.NoError(t, err)
+
+	// The values are mmapped, so they're copied before the block is closed.
+	jobs := make([]string, 0, len(values))
+	for _, v := range values {
+		jobs = append(jobs, strings.Clone(v))
+	}
+	return jobs
+}
@@ -0,0 +1,325 @@
+package seriesvalidation
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+
+	"objectstorage/pkg/push"
+	"objectstorage/pkg/tenant"
+	"objectstorage/pkg/util/utf8names"
+)
+
+// Reasons used to label the discarded samples. They match the Cortex ones.
+const (
+	reasonMissingMetricName   = "missing_metric_name"
+	reasonInvalidMetricName   = "metric_name_invalid"
+	reasonMaxLabelNames       = "max_label_names_per_series"
+	reasonInvalidLabel        = "label_invalid"
+	reasonLabelNameTooLong    = "label_name_too_long"
+	reasonLabelValueTooLong   = "label_value_too_long"
+	reasonDuplicateLabelNames = "duplicate_label_names"
+	reasonTooOld              = "greater_than_max_sample_age"
+	reasonTooFarInFuture      = "too_far_in_future"
+)
+
+// Limits is the subset of the per-tenant limits enforced by the validation. It's
+// implemented by validation.Overrides.
+type Limits interface {
+	MaxLabelNameLength(userID string) int
+	MaxLabelValueLength(userID string) int
+	MaxLabelNamesPerSeries(userID string) int
+	RejectOldSamples(userID string) bool
+	RejectOldSamplesMaxAge(userID string) time.Duration
+	CreationGracePeriod(userID string) time.Duration
+}
+
+// Settings is the subset of the per-tenant settings enabling the validation rules. It's
+// implemented by overrides.Overrides.
+type Settings interface {
+	ValidateMetricNames(userID string) bool
+	ValidateLabelNames(userID string) bool
+	RejectDuplicateLabelNames(userID string) bool
+	UTF8NamesEnabled(userID string) bool
+	ClampSampleTimestamps(userID string) bool
+}
+
+// Rejected receives the series rejected by the validation, with the reason. It's
+// implemented by deadletter.Writer.
+type Rejected interface {
+	Add(userID, reason string, cause error, lbls []cortexpb.LabelAdapter, samples []cortexpb.Sample)
+}
+
+// Validator validates the labels and the sample timestamps of the pushed series. Invalid
+// series and samples are discarded, while the rest of the request is still ingested.
+type Validator struct {
+	limits   Limits
+	settings Settings
+	rejected Rejected
+
+	discardedSamples *prometheus.CounterVec
+	clampedSamples   *prometheus.CounterVec
+}
+
+// NewValidator makes a new Validator. The rejected series are passed to rejected, unless nil.
+func NewValidator(limits Limits, settings Settings, rejected Rejected, reg prometheus.Registerer) *Validator {
+	return &Validator{
+		limits:   limits,
+		settings: settings,
+		rejected: rejected,
+		discardedSamples: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_validation_discarded_samples_total",
+			Help: "Total number of samples discarded on the push path because their series failed the validation, by reason.",
+		}, []string{"reason", "user"}),
+		clampedSamples: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_validation_clamped_samples_total",
+			Help: "Total number of samples whose timestamp was out of the accepted time range and has been clamped to it, by reason.",
+		}, []string{"reason", "user"}),
+	}
+}
+
+// PushMiddleware returns the push.Middleware validating the series.
+func (v *Validator) PushMiddleware() push.Middleware {
+	return func(next push.Func) push.Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			userID, err := tenant.TenantID(ctx)
+			if err != nil {
+				return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+			}
+
+			firstErr := v.filter(userID, req)
+			if len(req.Timeseries) > 0 || len(req.Metadata) > 0 {
+				if _, err := next(ctx, req); err != nil {
+					return nil, err
+				}
+			}
+
+			if firstErr != nil {
+				return nil, httpgrpc.Errorf(http.StatusBadRequest, firstErr.Error())
+			}
+			return &cortexpb.WriteResponse{}, nil
+		}
+	}
+}
+
+// filter removes from the request the invalid series and samples, and returns the first
+// validation error, if any.
+func (v *Validator) filter(userID string, req *cortexpb.WriteRequest) error {
+	var (
+		firstErr error
+		rules    = v.rules(userID, time.Now())
+		kept     = req.Timeseries[:0]
+	)
+
+	for _, ts := range req.Timeseries {
+		reason, err := rules.validate(ts.Labels)
+		if err != nil {
+			v.reject(userID, reason, err, ts.Labels, ts.Samples)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if err := v.filterSamples(userID, rules, ts.TimeSeries); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			// The series is dropped if none of its samples is left.
+			if len(ts.Samples) == 0 && len(ts.Exemplars) == 0 {
+				continue
+			}
+		}
+		kept = append(kept, ts)
+	}
+
+	req.Timeseries = kept
+	return firstErr
+}
+
+// filterSamples removes from the series the samples out of the accepted time range, or clamps
+// their timestamp to it, and returns the error of the first removed sample, if any. The
+// clamped samples may collide with the samples of the series at the bounds, in which case
+// they're rejected as duplicates by the ingester.
+func (v *Validator) filterSamples(userID string, r rules, ts *cortexpb.TimeSeries) error {
+	var (
+		firstErr       error
+		tooOld, tooNew []cortexpb.Sample
+		kept           = ts.Samples[:0]
+	)
+
+	for _, s := range ts.Samples {
+		switch {
+		case s.TimestampMs < r.minTimestampMs:
+			if r.clampTimestamps {
+				v.clampedSamples.WithLabelValues(reasonTooOld, userID).Inc()
+				s.TimestampMs = r.minTimestampMs
+				break
+			}
+			tooOld = append(tooOld, s)
+			continue
+		case s.TimestampMs > r.maxTimestampMs:
+			if r.clampTimestamps {
+				v.clampedSamples.WithLabelValues(reasonTooFarInFuture, userID).Inc()
+				s.TimestampMs = r.maxTimestampMs
+				break
+			}
+			tooNew = append(tooNew, s)
+			continue
+		}
+		kept = append(kept, s)
+	}
+	ts.Samples = kept
+
+	if len(tooOld) > 0 {
+		firstErr = fmt.Errorf("sample timestamp %s is older than the max age of %s: %s", formatTimestamp(tooOld[0].TimestampMs), r.maxAge, formatLabels(ts.Labels))
+		v.reject(userID, reasonTooOld, firstErr, ts.Labels, tooOld)
+	}
+	if len(tooNew) > 0 {
+		err := fmt.Errorf("sample timestamp %s is too far in the future, beyond the creation grace period of %s: %s", formatTimestamp(tooNew[0].TimestampMs), r.gracePeriod, formatLabels(ts.Labels))
+		v.reject(userID, reasonTooFarInFuture, err, ts.Labels, tooNew)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// reject accounts the samples rejected for the reason, and passes them to the rejected sink.
+func (v *Validator) reject(userID, reason string, err error, lbls []cortexpb.LabelAdapter, samples []cortexpb.Sample) {
+	v.discardedSamples.WithLabelValues(reason, userID).Add(float64(len(samples)))
+	if v.rejected != nil {
+		v.rejected.Add(userID, reason, err, lbls, samples)
+	}
+}
+
+// rules returns the validation rules of the tenant, read once per request. The sample
+// timestamps are accepted within the max age before now, if the old samples are rejected,
+// and the creation grace period after now, if not 0.
+func (v *Validator) rules(userID string, now time.Time) rules {
+	r := rules{
+		maxLabelNameLength:        v.limits.MaxLabelNameLength(userID),
+		maxLabelValueLength:       v.limits.MaxLabelValueLength(userID),
+		maxLabelNames:             v.limits.MaxLabelNamesPerSeries(userID),
+		validateMetricNames:       v.settings.ValidateMetricNames(userID),
+		validateLabelNames:        v.settings.ValidateLabelNames(userID),
+		rejectDuplicateLabelNames: v.settings.RejectDuplicateLabelNames(userID),
+		utf8Names:                 v.settings.UTF8NamesEnabled(userID),
+		minTimestampMs:            math.MinInt64,
+		maxTimestampMs:            math.MaxInt64,
+		clampTimestamps:           v.settings.ClampSampleTimestamps(userID),
+	}
+
+	if v.limits.RejectOldSamples(userID) {
+		r.maxAge = v.limits.RejectOldSamplesMaxAge(userID)
+		r.minTimestampMs = timestamp.FromTime(now.Add(-r.maxAge))
+	}
+	if r.gracePeriod = v.limits.CreationGracePeriod(userID); r.gracePeriod > 0 {
+		r.maxTimestampMs = timestamp.FromTime(now.Add(r.gracePeriod))
+	}
+	return r
+}
+
+// rules are the validation rules of a tenant. The limits are disabled when 0. The names are
+// validated as UTF-8, instead of in the legacy charset, for the tenants with UTF-8 names.
+type rules struct {
+	maxLabelNameLength        int
+	maxLabelValueLength       int
+	maxLabelNames             int
+	validateMetricNames       bool
+	validateLabelNames        bool
+	rejectDuplicateLabelNames bool
+	utf8Names                 bool
+
+	minTimestampMs  int64
+	maxTimestampMs  int64
+	maxAge          time.Duration
+	gracePeriod     time.Duration
+	clampTimestamps bool
+}
+
+// validate returns the reason and the error if the series is invalid.
+func (r rules) validate(lbls []cortexpb.LabelAdapter) (string, error) {
+	metricName, ok := "", false
+	for _, l := range lbls {
+		if l.Name == model.MetricNameLabel {
+			metricName, ok = l.Value, true
+			break
+		}
+	}
+	if !ok || metricName == "" {
+		return reasonMissingMetricName, fmt.Errorf("series has no metric name: %s", formatLabels(lbls))
+	}
+	if r.validateMetricNames && !utf8names.IsValidMetricName(metricName, r.utf8Names) {
+		return reasonInvalidMetricName, fmt.Errorf("metric name %q is invalid: %s", metricName, formatLabels(lbls))
+	}
+
+	if r.maxLabelNames > 0 && len(lbls) > r.maxLabelNames {
+		return reasonMaxLabelNames, fmt.Errorf("series has %d label names, exceeding the limit of %d: %s", len(lbls), r.maxLabelNames, formatLabels(lbls))
+	}
+
+	for _, l := range lbls {
+		if r.validateLabelNames && !utf8names.IsValidLabelName(l.Name, r.utf8Names) {
+			return reasonInvalidLabel, fmt.Errorf("label name %q is invalid: %s", l.Name, formatLabels(lbls))
+		}
+		if r.maxLabelNameLength > 0 && len(l.Name) > r.maxLabelNameLength {
+			return reasonLabelNameTooLong, fmt.Errorf("label name %s is longer than the limit of %d characters: %s", l.Name, r.maxLabelNameLength, formatLabels(lbls))
+		}
+		if r.maxLabelValueLength > 0 && len(l.Value) > r.maxLabelValueLength {
+			return reasonLabelValueTooLong, fmt.Errorf("label %s value is longer than the limit of %d characters: %s", l.Name, r.maxLabelValueLength, formatLabels(lbls))
+		}
+	}
+
+	if r.rejectDuplicateLabelNames {
+		if name, ok := duplicateLabelName(lbls); ok {
+			return reasonDuplicateLabelNames, fmt.Errorf("label name %s is duplicated: %s", name, formatLabels(lbls))
+		}
+	}
+
+	return "", nil
+}
+
+// duplicateLabelName returns the first label name found more than once. The labels are
+// expected to be sorted, as required by the remote write protocol, so only the unsorted
+// ones pay for the set.
+func duplicateLabelName(lbls []cortexpb.LabelAdapter) (string, bool) {
+	sorted := true
+	for i := 1; i < len(lbls); i++ {
+		if lbls[i].Name == lbls[i-1].Name {
+			return lbls[i].Name, true
+		}
+		if lbls[i].Name < lbls[i-1].Name {
+			sorted = false
+		}
+	}
+	if sorted {
+		return "", false
+	}
+
+	seen := make(map[string]struct{}, len(lbls))
+	for _, l := range lbls {
+		if _, ok := seen[l.Name]; ok {
+			return l.Name, true
+		}
+		seen[l.Name] = struct{}{}
+	}
+	return "", false
+}
+
+func formatTimestamp(ms int64) string {
+	return timestamp.Time(ms).Format(time.RFC3339Nano)
+}
+
+func formatLabels(lbls []cortexpb.LabelAdapter) string {
+	return cortexpb.FromLabelAdaptersToLabels(lbls).String()
+}
@@ -0,0 +1,285 @@
+package seriesvalidation
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+type limitsMock struct {
+	maxLabelNameLength        int
+	maxLabelValueLength       int
+	maxLabelNames             int
+	validateMetricNames       bool
+	validateLabelNames        bool
+	rejectDuplicateLabelNames bool
+	utf8Names                 bool
+	rejectOldSamplesMaxAge    time.Duration
+	creationGracePeriod       time.Duration
+	clampSampleTimestamps     bool
+}
+
+func (m limitsMock) MaxLabelNameLength(string) int               { return m.maxLabelNameLength }
+func (m limitsMock) MaxLabelValueLength(string) int              { return m.maxLabelValueLength }
+func (m limitsMock) MaxLabelNamesPerSeries(string) int           { return m.maxLabelNames }
+func (m limitsMock) ValidateMetricNames(string) bool             { return m.validateMetricNames }
+func (m limitsMock) ValidateLabelNames(string) bool              { return m.validateLabelNames }
+func (m limitsMock) RejectDuplicateLabelNames(string) bool       { return m.rejectDuplicateLabelNames }
+func (m limitsMock) UTF8NamesEnabled(string) bool                { return m.utf8Names }
+func (m limitsMock) RejectOldSamples(string) bool                { return m.rejectOldSamplesMaxAge > 0 }
+func (m limitsMock) RejectOldSamplesMaxAge(string) time.Duration { return m.rejectOldSamplesMaxAge }
+func (m limitsMock) CreationGracePeriod(string) time.Duration    { return m.creationGracePeriod }
+func (m limitsMock) ClampSampleTimestamps(string) bool           { return m.clampSampleTimestamps }
+
+func newSeries(lbls ...string) cortexpb.PreallocTimeseries {
+	ts := &cortexpb.TimeSeries{Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}}}
+	for i := 0; i < len(lbls); i += 2 {
+		ts.Labels = append(ts.Labels, cortexpb.LabelAdapter{Name: lbls[i], Value: lbls[i+1]})
+	}
+	return cortexpb.PreallocTimeseries{TimeSeries: ts}
+}
+
+// rejectedMock counts the rejected series by reason.
+type rejectedMock map[string]int
+
+func (m rejectedMock) Add(_, reason string, _ error, _ []cortexpb.LabelAdapter, _ []cortexpb.Sample) {
+	m[reason]++
+}
+
+func TestValidator_PushMiddleware(t *testing.T) {
+	strict := limitsMock{validateMetricNames: true, validateLabelNames: true, rejectDuplicateLabelNames: true}
+
+	tests := map[string]struct {
+		limits            limitsMock
+		series            []cortexpb.PreallocTimeseries
+		expectedPushed    int
+		expectedDiscarded map[string]float64
+	}{
+		"valid series": {
+			limits:         limitsMock{maxLabelNameLength: 8, maxLabelValueLength: 8, maxLabelNames: 2, validateMetricNames: true, validateLabelNames: true, rejectDuplicateLabelNames: true},
+			series:         []cortexpb.PreallocTimeseries{newSeries("__name__", "up", "job", "node"), newSeries("__name__", "ns:down")},
+			expectedPushed: 2,
+		},
+		"missing metric name": {
+			limits:            limitsMock{},
+			series:            []cortexpb.PreallocTimeseries{newSeries("__name__", "up"), newSeries("job", "a"), newSeries("__name__", "")},
+			expectedPushed:    1,
+			expectedDiscarded: map[string]float64{reasonMissingMetricName: 2},
+		},
+		"invalid metric name": {
+			limits:            strict,
+			series:            []cortexpb.PreallocTimeseries{newSeries("__name__", "up"), newSeries("__name__", "http-requests")},
+			expectedPushed:    1,
+			expectedDiscarded: map[string]float64{reasonInvalidMetricName: 1},
+		},
+		"invalid metric name not validated": {
+			limits:         limitsMock{},
+			series:         []cortexpb.PreallocTimeseries{newSeries("__name__", "http-requests")},
+			expectedPushed: 1,
+		},
+		"max label names per series": {
+			limits:            limitsMock{maxLabelNames: 1},
+			series:            []cortexpb.PreallocTimeseries{newSeries("__name__", "up"), newSeries("__name__", "up", "job", "a")},
+			expectedPushed:    1,
+			expectedDiscarded: map[string]float64{reasonMaxLabelNames: 1},
+		},
+		"invalid label name": {
+			limits:            strict,
+			series:            []cortexpb.PreallocTimeseries{newSeries("__name__", "up", "job", "a"), newSeries("__name__", "up", "k8s.pod", "a")},
+			expectedPushed:    1,
+			expectedDiscarded: map[string]float64{reasonInvalidLabel: 1},
+		},
+		"invalid label name not validated": {
+			limits:         limitsMock{},
+			series:         []cortexpb.PreallocTimeseries{newSeries("__name__", "up", "k8s.pod", "a")},
+			expectedPushed: 1,
+		},
+		"label name too long": {
+			limits:            limitsMock{maxLabelNameLength: 8},
+			series:            []cortexpb.PreallocTimeseries{newSeries("__name__", "up", "job", "a"), newSeries("__name__", "up", "kubernetes_namespace", "a")},
+			expectedPushed:    1,
+			expectedDiscarded: map[string]float64{reasonLabelNameTooLong: 1},
+		},
+		"label value too long": {
+			limits:            limitsMock{maxLabelValueLength: 4},
+			series:            []cortexpb.PreallocTimeseries{newSeries("__name__", "up"), newSeries("__name__", "up", "job", "too-long")},
+			expectedPushed:    1,
+			expectedDiscarded: map[string]float64{reasonLabelValueTooLong: 1},
+		},
+		"utf-8 names": {
+			limits:            limitsMock{validateMetricNames: true, validateLabelNames: true, utf8Names: true},
+			series:            []cortexpb.PreallocTimeseries{newSeries("__name__", "http.server.duration", "k8s.pod", "a"), newSeries("__name__", "up", "job\xff", "a")},
+			expectedPushed:    1,
+			expectedDiscarded: map[string]float64{reasonInvalidLabel: 1},
+		},
+		"duplicate label names": {
+			limits: strict,
+			series: []cortexpb.PreallocTimeseries{
+				newSeries("__name__", "up", "job", "a", "job", "b"),
+				newSeries("job", "a", "__name__", "up", "job", "b"),
+				newSeries("job", "a", "__name__", "up"),
+			},
+			expectedPushed:    1,
+			expectedDiscarded: map[string]float64{reasonDuplicateLabelNames: 2},
+		},
+		"duplicate label names not rejected": {
+			limits:         limitsMock{},
+			series:         []cortexpb.PreallocTimeseries{newSeries("__name__", "up", "job", "a", "job", "b")},
+			expectedPushed: 1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			reg := prometheus.NewPedanticRegistry()
+			rejected := rejectedMock{}
+			v := NewValidator(tc.limits, tc.limits, rejected, reg)
+
+			pushed := 0
+			f := v.PushMiddleware()(func(_ context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				pushed += len(req.Timeseries)
+				return &cortexpb.WriteResponse{}, nil
+			})
+
+			_, err := f(user.InjectOrgID(context.Background(), "user-1"), &cortexpb.WriteRequest{Timeseries: tc.series})
+			if len(tc.expectedDiscarded) > 0 {
+				resp, ok := httpgrpc.HTTPResponseFromError(err)
+				require.True(t, ok)
+				assert.Equal(t, int32(http.StatusBadRequest), resp.Code)
+			} else {
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.expectedPushed, pushed)
+			for reason, expected := range tc.expectedDiscarded {
+				assert.Equal(t, expected, testutil.ToFloat64(v.discardedSamples.WithLabelValues(reason, "user-1")), reason)
+				assert.Equal(t, int(expected), rejected[reason], reason)
+			}
+			assert.Len(t, rejected, len(tc.expectedDiscarded))
+		})
+	}
+}
+
+func TestValidator_NothingLeftToPush(t *testing.T) {
+	v := NewValidator(limitsMock{}, limitsMock{}, nil, nil)
+
+	called := false
+	f := v.PushMiddleware()(func(_ context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		called = true
+		return &cortexpb.WriteResponse{}, nil
+	})
+
+	_, err := f(user.InjectOrgID(context.Background(), "user-1"), &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{newSeries("job", "a")}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `series has no metric name: {job="a"}`)
+	assert.False(t, called)
+}
+
+func TestValidator_SampleTimestamps(t *testing.T) {
+	now := time.Now()
+	var (
+		old    = cortexpb.Sample{TimestampMs: timestamp.FromTime(now.Add(-2 * time.Hour)), Value: 1}
+		recent = cortexpb.Sample{TimestampMs: timestamp.FromTime(now.Add(-time.Minute)), Value: 2}
+		future = cortexpb.Sample{TimestampMs: timestamp.FromTime(now.Add(time.Hour)), Value: 3}
+	)
+	series := func(samples ...cortexpb.Sample) cortexpb.PreallocTimeseries {
+		return cortexpb.PreallocTimeseries{TimeSeries: &cortexpb.TimeSeries{
+			Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: "up"}},
+			Samples: samples,
+		}}
+	}
+
+	tests := map[string]struct {
+		limits            limitsMock
+		series            []cortexpb.PreallocTimeseries
+		expectedSamples   []float64
+		expectedDiscarded map[string]float64
+		expectedClamped   map[string]float64
+	}{
+		"no bounds": {
+			limits:          limitsMock{},
+			series:          []cortexpb.PreallocTimeseries{series(old, recent, future)},
+			expectedSamples: []float64{1, 2, 3},
+		},
+		"old samples": {
+			limits:            limitsMock{rejectOldSamplesMaxAge: time.Hour},
+			series:            []cortexpb.PreallocTimeseries{series(old, recent, future)},
+			expectedSamples:   []float64{2, 3},
+			expectedDiscarded: map[string]float64{reasonTooOld: 1},
+		},
+		"samples too far in the future": {
+			limits:            limitsMock{creationGracePeriod: 10 * time.Minute},
+			series:            []cortexpb.PreallocTimeseries{series(old, recent, future)},
+			expectedSamples:   []float64{1, 2},
+			expectedDiscarded: map[string]float64{reasonTooFarInFuture: 1},
+		},
+		"series without samples left": {
+			limits:            limitsMock{rejectOldSamplesMaxAge: time.Hour, creationGracePeriod: 10 * time.Minute},
+			series:            []cortexpb.PreallocTimeseries{series(old, future), series(recent)},
+			expectedSamples:   []float64{2},
+			expectedDiscarded: map[string]float64{reasonTooOld: 1, reasonTooFarInFuture: 1},
+		},
+		"clamped samples": {
+			limits:          limitsMock{rejectOldSamplesMaxAge: time.Hour, creationGracePeriod: 10 * time.Minute, clampSampleTimestamps: true},
+			series:          []cortexpb.PreallocTimeseries{series(old, recent, future)},
+			expectedSamples: []float64{1, 2, 3},
+			expectedClamped: map[string]float64{reasonTooOld: 1, reasonTooFarInFuture: 1},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rejected := rejectedMock{}
+			v := NewValidator(tc.limits, tc.limits, rejected, prometheus.NewPedanticRegistry())
+
+			var pushed []cortexpb.Sample
+			f := v.PushMiddleware()(func(_ context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				for _, ts := range req.Timeseries {
+					require.NotEmpty(t, ts.Samples)
+					pushed = append(pushed, ts.Samples...)
+				}
+				return &cortexpb.WriteResponse{}, nil
+			})
+
+			_, err := f(user.InjectOrgID(context.Background(), "user-1"), &cortexpb.WriteRequest{Timeseries: tc.series})
+			if len(tc.expectedDiscarded) > 0 {
+				resp, ok := httpgrpc.HTTPResponseFromError(err)
+				require.True(t, ok)
+				assert.Equal(t, int32(http.StatusBadRequest), resp.Code)
+			} else {
+				require.NoError(t, err)
+			}
+
+			values := make([]float64, 0, len(pushed))
+			for _, s := range pushed {
+				values = append(values, s.Value)
+
+				// The clamped samples are within the bounds.
+				if tc.limits.clampSampleTimestamps {
+					assert.GreaterOrEqual(t, s.TimestampMs, timestamp.FromTime(now.Add(-tc.limits.rejectOldSamplesMaxAge)))
+					assert.LessOrEqual(t, s.TimestampMs, timestamp.FromTime(time.Now().Add(tc.limits.creationGracePeriod)))
+				}
+			}
+			assert.Equal(t, tc.expectedSamples, values)
+
+			for reason, expected := range tc.expectedDiscarded {
+				assert.Equal(t, expected, testutil.ToFloat64(v.discardedSamples.WithLabelValues(reason, "user-1")), reason)
+				assert.Equal(t, 1, rejected[reason], reason)
+			}
+			for reason, expected := range tc.expectedClamped {
+				assert.Equal(t, expected, testutil.ToFloat64(v.clampedSamples.WithLabelValues(reason, "user-1")), reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+// Package shadow mirrors a fraction of the push requests to a secondary deployment, to
+// validate a new version against the production traffic. The responses of the secondary
+// deployment are only compared with the ones returned to the clients.
+package shadow
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/push"
+)
+
+// Results of the mirrored requests.
+const (
+	resultMatch    = "match"
+	resultMismatch = "mismatch"
+	resultFailed   = "failed"
+)
+
+var (
+	errMissingURL         = errors.New("the shadow URL has not been configured")
+	errInvalidSampleRatio = errors.New("the shadow sample ratio must be between 0 and 1")
+	errInvalidMaxInFlight = errors.New("the shadow max in-flight requests must be greater than 0")
+	errInvalidTimeout     = errors.New("the shadow timeout must be greater than 0")
+)
+
+// Config holds the configuration of the shadow traffic.
+type Config struct {
+	Enabled     bool          `yaml:"enabled"`
+	URL         string        `yaml:"url"`
+	SampleRatio float64       `yaml:"sample_ratio"`
+	MaxInFlight int           `yaml:"max_in_flight"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+// RegisterFlags registers the shadow traffic flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "shadow.enabled", false, "True to mirror a fraction of the push requests to a secondary deployment, comparing its responses with the ones returned to the clients. The mirrored requests are sent in background and never affect the clients.")
+	f.StringVar(&cfg.URL, "shadow.url", "", "Remote write URL of the secondary deployment, like http://shadow-ingester/api/v1/push.")
+	f.Float64Var(&cfg.SampleRatio, "shadow.sample-ratio", 0.01, "Fraction of the push requests which are mirrored, between 0 and 1.")
+	f.IntVar(&cfg.MaxInFlight, "shadow.max-in-flight", 10, "Maximum number of mirrored requests in flight. The push requests received while the limit is reached aren't mirrored, so that a slow secondary deployment doesn't pile up requests.")
+	f.DurationVar(&cfg.Timeout, "shadow.timeout", 10*time.Second, "Timeout of each mirrored request.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.URL == "" {
+		return errMissingURL
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return errInvalidSampleRatio
+	}
+	if cfg.MaxInFlight <= 0 {
+		return errInvalidMaxInFlight
+	}
+	if cfg.Timeout <= 0 {
+		return errInvalidTimeout
+	}
+	return nil
+}
+
+// Mirror sends a sample of the push requests to the secondary deployment, and records
+// whether its responses match the ones of the push path.
+type Mirror struct {
+	services.Service
+
+	cfg    Config
+	client *http.Client
+	logger log.Logger
+
+	// inFlight limits the mirrored requests in flight, and wg waits for them on stop. No
+	// request is mirrored once stopped.
+	inFlight chan struct{}
+	wg       sync.WaitGroup
+	mtx      sync.Mutex
+	stopped  bool
+
+	// sample returns a random number in [0, 1), replaced in tests.
+	sample func() float64
+
+	mirroredRequests *prometheus.CounterVec
+	mismatches       *prometheus.CounterVec
+	skippedRequests  prometheus.Counter
+	requestDuration  prometheus.Histogram
+}
+
+// NewMirror makes a new Mirror.
+func NewMirror(cfg Config, logger log.Logger, reg prometheus.Registerer) *Mirror {
+	m := &Mirror{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		logger:   logger,
+		inFlight: make(chan struct{}, cfg.MaxInFlight),
+		sample:   rand.Float64,
+		mirroredRequests: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_shadow_mirrored_requests_total",
+			Help: "Total number of push requests mirrored to the secondary deployment, by result: match or mismatch of the response status code, or failed if the secondary deployment couldn't be reached.",
+		}, []string{"result"}),
+		mismatches: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_shadow_mismatched_responses_total",
+			Help: "Total number of mirrored push requests whose response status code differs from the one returned to the client, by status code.",
+		}, []string{"primary_status_code", "shadow_status_code"}),
+		skippedRequests: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_shadow_skipped_requests_total",
+			Help: "Total number of sampled push requests which haven't been mirrored because the max in-flight requests was reached.",
+		}),
+		requestDuration: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Name:    "cortex_shadow_request_duration_seconds",
+			Help:    "Duration of the mirrored push requests.",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+
+	m.Service = services.NewIdleService(nil, m.stopping)
+	return m
+}
+
+// stopping waits for the mirrored requests in flight.
+func (m *Mirror) stopping(_ error) error {
+	m.mtx.Lock()
+	m.stopped = true
+	m.mtx.Unlock()
+
+	m.wg.Wait()
+	return nil
+}
+
+// acquire reserves a mirrored request in flight, returning false if the max in-flight
+// requests is reached or the mirror is stopped.
+func (m *Mirror) acquire() bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.stopped {
+		return false
+	}
+
+	select {
+	case m.inFlight <- struct{}{}:
+		m.wg.Add(1)
+		return true
+	default:
+		m.skippedRequests.Inc()
+		return false
+	}
+}
+
+func (m *Mirror) release() {
+	<-m.inFlight
+	m.wg.Done()
+}
+
+// PushMiddleware returns the push.Middleware mirroring the sampled requests. The request
+// is encoded before being pushed, since the push path reuses the request series once done,
+// and sent in background once the response of the push path is known.
+func (m *Mirror) PushMiddleware() push.Middleware {
+	return func(next push.Func) push.Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			if m.cfg.SampleRatio == 0 || m.sample() >= m.cfg.SampleRatio {
+				return next(ctx, req)
+			}
+			// The raw org ID is mirrored, so that the federated requests are mirrored as is.
+			orgID, err := user.ExtractOrgID(ctx)
+			if err != nil {
+				return next(ctx, req)
+			}
+
+			if !m.acquire() {
+				return next(ctx, req)
+			}
+
+			buf, err := req.Marshal()
+			if err != nil {
+				m.release()
+				level.Warn(m.logger).Log("msg", "failed to encode the mirrored push request", "tenant", orgID, "err", err)
+				return next(ctx, req)
+			}
+			body := snappy.Encode(nil, buf)
+
+			resp, err := next(ctx, req)
+			primaryCode := statusCode(err)
+
+			go func() {
+				defer m.release()
+				m.mirror(orgID, body, primaryCode)
+			}()
+			return resp, err
+		}
+	}
+}
+
+// mirror sends the request to the secondary deployment and compares the response status
+// code with the one of the push path.
+func (m *Mirror) mirror(orgID string, body []byte, primaryCode int) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.cfg.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	shadowCode, err := m.send(ctx, orgID, body)
+	m.requestDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.mirroredRequests.WithLabelValues(resultFailed).Inc()
+		level.Debug(m.logger).Log("msg", "failed to mirror the push request", "tenant", orgID, "err", err)
+		return
+	}
+
+	if shadowCode == primaryCode {
+		m.mirroredRequests.WithLabelValues(resultMatch).Inc()
+		return
+	}
+	m.mirroredRequests.WithLabelValues(resultMismatch).Inc()
+	m.mismatches.WithLabelValues(strconv.Itoa(primaryCode), strconv.Itoa(shadowCode)).Inc()
+	level.Debug(m.logger).Log("msg", "mirrored push request response mismatch", "tenant", orgID, "primary_status_code", primaryCode, "shadow_status_code", shadowCode)
+}
+
+// send posts the snappy-encoded write request, returning the response status code.
+func (m *Mirror) send(ctx context.Context, orgID string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set(user.OrgIDHeaderName, orgID)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// The response body is drained, so that the connection is reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// statusCode returns the HTTP status code the push handler responds with for the error.
+func statusCode(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	if resp, ok := httpgrpc.HTTPResponseFromError(err); ok {
+		return int(resp.Code)
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,221 @@
+package shadow
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *Config)
+		expected error
+	}{
+		"disabled": {
+			setup:    func(*Config) {},
+			expected: nil,
+		},
+		"enabled": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.URL = "http://shadow-ingester/api/v1/push"
+			},
+			expected: nil,
+		},
+		"missing URL": {
+			setup:    func(cfg *Config) { cfg.Enabled = true },
+			expected: errMissingURL,
+		},
+		"invalid sample ratio": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.URL = "http://shadow-ingester/api/v1/push"
+				cfg.SampleRatio = 1.5
+			},
+			expected: errInvalidSampleRatio,
+		},
+		"invalid max in-flight": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.URL = "http://shadow-ingester/api/v1/push"
+				cfg.MaxInFlight = 0
+			},
+			expected: errInvalidMaxInFlight,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+			assert.ErrorIs(t, cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+// shadowMock records the mirrored series, by org ID, responding with the configured status
+// code.
+type shadowMock struct {
+	mtx     sync.Mutex
+	status  int
+	release chan struct{}
+	series  map[string][]string
+}
+
+func (m *shadowMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.release != nil {
+		<-m.release
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	compressed, _ := io.ReadAll(r.Body)
+	buf, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req := cortexpb.WriteRequest{}
+	if err := req.Unmarshal(buf); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if m.series == nil {
+		m.series = map[string][]string{}
+	}
+	orgID := r.Header.Get(user.OrgIDHeaderName)
+	for _, ts := range req.Timeseries {
+		m.series[orgID] = append(m.series[orgID], cortexpb.FromLabelAdaptersToLabels(ts.Labels).String())
+	}
+	if m.status != 0 {
+		w.WriteHeader(m.status)
+	}
+}
+
+func (m *shadowMock) received(orgID string) []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return append([]string(nil), m.series[orgID]...)
+}
+
+func newTestMirror(t *testing.T, url string, maxInFlight int) *Mirror {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Enabled = true
+	cfg.URL = url
+	cfg.SampleRatio = 0.5
+	cfg.MaxInFlight = maxInFlight
+	require.NoError(t, cfg.Validate())
+
+	m := NewMirror(cfg, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), m))
+	return m
+}
+
+func newRequest(metric string) *cortexpb.WriteRequest {
+	return &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+		Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: metric}},
+		Samples: []cortexpb.Sample{{TimestampMs: 1, Value: 1}},
+	}}}}
+}
+
+func TestMirror(t *testing.T) {
+	remote := &shadowMock{status: http.StatusTooManyRequests}
+	srv := httptest.NewServer(remote)
+	defer srv.Close()
+
+	m := newTestMirror(t, srv.URL, 10)
+
+	var pushErr error
+	push := m.PushMiddleware()(func(_ context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		// The push path reuses the request series once done.
+		req.Timeseries[0].Labels[0].Value = "reused"
+		return &cortexpb.WriteResponse{}, pushErr
+	})
+	ctx := user.InjectOrgID(context.Background(), "user-1|user-2")
+
+	// The requests not sampled aren't mirrored.
+	m.sample = func() float64 { return 0.5 }
+	_, err := push(ctx, newRequest("not_sampled"))
+	require.NoError(t, err)
+
+	// The primary response differs from the shadow one.
+	m.sample = func() float64 { return 0.1 }
+	_, err = push(ctx, newRequest("series_1"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(remote.received("user-1|user-2")) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// The primary response matches the shadow one.
+	pushErr = httpgrpc.Errorf(http.StatusTooManyRequests, "rate limited")
+	_, err = push(ctx, newRequest("series_2"))
+	require.Equal(t, pushErr, err)
+
+	// The mirrored requests in flight are waited for on stop.
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), m))
+	assert.Equal(t, []string{`{__name__="series_1"}`, `{__name__="series_2"}`}, remote.received("user-1|user-2"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.mirroredRequests.WithLabelValues(resultMatch)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.mirroredRequests.WithLabelValues(resultMismatch)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.mismatches.WithLabelValues("200", "429")))
+
+	// No request is mirrored once stopped.
+	_, err = push(ctx, newRequest("series_3"))
+	require.Equal(t, pushErr, err)
+	assert.Len(t, remote.received("user-1|user-2"), 2)
+}
+
+func TestMirror_SkipsTheRequestsWhenTheMaxInFlightIsReached(t *testing.T) {
+	remote := &shadowMock{release: make(chan struct{})}
+	srv := httptest.NewServer(remote)
+	defer srv.Close()
+
+	m := newTestMirror(t, srv.URL, 1)
+	m.sample = func() float64 { return 0 }
+	push := m.PushMiddleware()(func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		return &cortexpb.WriteResponse{}, nil
+	})
+	ctx := user.InjectOrgID(context.Background(), "user-1")
+
+	_, err := push(ctx, newRequest("series_1"))
+	require.NoError(t, err)
+	_, err = push(ctx, newRequest("series_2"))
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.skippedRequests))
+
+	close(remote.release)
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), m))
+	assert.Equal(t, []string{`{__name__="series_1"}`}, remote.received("user-1"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.mirroredRequests.WithLabelValues(resultMatch)))
+}
+
+func TestMirror_CountsTheFailedRequests(t *testing.T) {
+	m := newTestMirror(t, "http://127.0.0.1:0/api/v1/push", 1)
+	m.sample = func() float64 { return 0 }
+	push := m.PushMiddleware()(func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		return &cortexpb.WriteResponse{}, nil
+	})
+
+	_, err := push(user.InjectOrgID(context.Background(), "user-1"), newRequest("series_1"))
+	require.NoError(t, err)
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), m))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.mirroredRequests.WithLabelValues(resultFailed)))
+}
@@ -0,0 +1,44 @@
+package singleport
+
+import (
+	"flag"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// Config holds the configuration of the gRPC requests served on the HTTP listener.
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// RegisterFlags registers the single port flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "server.single-port-enabled", false, "True to also serve the gRPC requests on the HTTP listener, over cleartext HTTP/2 (h2c) or TLS, for the clusters allowing a single port per service. The gRPC requests are told apart by their content type. The gRPC listener keeps serving. The HTTP server timeouts apply to the gRPC streams on the HTTP listener.")
+}
+
+// Handler returns the handler serving the gRPC requests with the gRPC handler, such as a
+// grpc.Server, and the other requests with the HTTP handler. The cleartext HTTP/2
+// connections, which the gRPC clients use without TLS, are upgraded by the handler, since
+// the HTTP server only supports HTTP/2 over TLS.
+func Handler(httpHandler, grpcHandler http.Handler) http.Handler {
+	return h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsGRPC(r) {
+			grpcHandler.ServeHTTP(w, r)
+			return
+		}
+		httpHandler.ServeHTTP(w, r)
+	}), &http2.Server{})
+}
+
+// IsGRPC returns true if the request is a gRPC request: an HTTP/2 request with the
+// application/grpc content type, optionally followed by a codec, such as application/grpc+proto.
+func IsGRPC(r *http.Request) bool {
+	if r.ProtoMajor != 2 {
+		return false
+	}
+	contentType := r.Header.Get("Content-Type")
+	return contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+") || strings.HasPrefix(contentType, "application/grpc;")
+}
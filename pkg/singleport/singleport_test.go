@@ -0,0 +1,72 @@
+package singleport
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHandler(t *testing.T) {
+	grpcServer := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(grpcServer, health.NewServer())
+	defer grpcServer.Stop()
+
+	httpServer := &http.Server{Handler: Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	}), grpcServer)}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = httpServer.Serve(listener) }()
+	defer httpServer.Close()
+
+	// HTTP/1.1 requests are served by the HTTP handler.
+	resp, err := http.Get("http://" + listener.Addr().String() + "/")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "HTTP/1.1", string(body))
+
+	// The gRPC requests over h2c on the same port are served by the gRPC server.
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	res, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, res.Status)
+}
+
+func TestIsGRPC(t *testing.T) {
+	tests := map[string]struct {
+		protoMajor  int
+		contentType string
+		expected    bool
+	}{
+		"gRPC":                      {protoMajor: 2, contentType: "application/grpc", expected: true},
+		"gRPC with codec":           {protoMajor: 2, contentType: "application/grpc+proto", expected: true},
+		"gRPC over HTTP/1.1":        {protoMajor: 1, contentType: "application/grpc"},
+		"gRPC-Web":                  {protoMajor: 2, contentType: "application/grpc-web"},
+		"protobuf over HTTP/2":      {protoMajor: 2, contentType: "application/x-protobuf"},
+		"no content type on HTTP/2": {protoMajor: 2},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := &http.Request{ProtoMajor: tc.protoMajor, Header: http.Header{}}
+			if tc.contentType != "" {
+				r.Header.Set("Content-Type", tc.contentType)
+			}
+			assert.Equal(t, tc.expected, IsGRPC(r))
+		})
+	}
+}
@@ -4,7 +4,8 @@ import (
 	"flag"
 
 	"github.com/cortexproject/cortex/pkg/storage/bucket/http"
-	"github.com/cortexproject/cortex/pkg/util/flagext"
+
+	"objectstorage/pkg/util/flagext"
 )
 
 // Config holds the config options for an Azure backend
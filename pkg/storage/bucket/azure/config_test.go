@@ -9,6 +9,8 @@ import (
 
 	"github.com/cortexproject/cortex/pkg/storage/bucket/http"
 	"github.com/cortexproject/cortex/pkg/util/flagext"
+
+	util_flagext "objectstorage/pkg/util/flagext"
 )
 
 // defaultConfig should match the default flag values defined in RegisterFlagsWithPrefix.
@@ -60,7 +62,7 @@ http:
 `,
 			expectedConfig: Config{
 				StorageAccountName: "test-account-name",
-				StorageAccountKey:  flagext.Secret{Value: "test-account-key"},
+				StorageAccountKey:  util_flagext.Secret{Value: "test-account-key"},
 				ContainerName:      "test-container-name",
 				Endpoint:           "test-endpoint-suffix",
 				MSIResource:        "test-msi-resource",
@@ -13,7 +13,8 @@ import (
 
 	bucket_http "github.com/cortexproject/cortex/pkg/storage/bucket/http"
 	"github.com/cortexproject/cortex/pkg/util"
-	"github.com/cortexproject/cortex/pkg/util/flagext"
+
+	"objectstorage/pkg/util/flagext"
 )
 
 const (
@@ -0,0 +1,75 @@
+package bucket
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+
+	"objectstorage/pkg/util/histogram"
+)
+
+// UploadDurationBucketClient is a wrapper around an objstore.Bucket observing the time spent
+// uploading each object, by kind of block file, as a native histogram with classic fallback
+// buckets.
+type UploadDurationBucketClient struct {
+	objstore.Bucket
+
+	duration *prometheus.HistogramVec
+}
+
+// NewUploadDurationBucketClient makes a new UploadDurationBucketClient.
+func NewUploadDurationBucketClient(bkt objstore.Bucket, histograms histogram.Config, reg prometheus.Registerer) *UploadDurationBucketClient {
+	return &UploadDurationBucketClient{
+		Bucket: bkt,
+		duration: promauto.With(reg).NewHistogramVec(histograms.Opts(prometheus.HistogramOpts{
+			Name: "cortex_bucket_upload_duration_seconds",
+			Help: "Time spent uploading an object to the bucket, by kind of block file and outcome.",
+		}), []string{"kind", "status"}),
+	}
+}
+
+// Upload the contents of the reader as an object into the bucket.
+func (b *UploadDurationBucketClient) Upload(ctx context.Context, name string, r io.Reader) error {
+	start := time.Now()
+	err := b.Bucket.Upload(ctx, name, r)
+
+	status := "success"
+	if err != nil {
+		status = "failure"
+	}
+	b.duration.WithLabelValues(objectKind(name), status).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// ReaderWithExpectedErrs implements objstore.InstrumentedBucket, keeping the wrapper.
+func (b *UploadDurationBucketClient) ReaderWithExpectedErrs(fn objstore.IsOpFailureExpectedFunc) objstore.BucketReader {
+	return b.WithExpectedErrs(fn)
+}
+
+// WithExpectedErrs implements objstore.InstrumentedBucket, keeping the wrapper.
+func (b *UploadDurationBucketClient) WithExpectedErrs(fn objstore.IsOpFailureExpectedFunc) objstore.Bucket {
+	if ib, ok := b.Bucket.(objstore.InstrumentedBucket); ok {
+		return &UploadDurationBucketClient{Bucket: ib.WithExpectedErrs(fn), duration: b.duration}
+	}
+	return b
+}
+
+// objectKind returns the kind of block file of the object, to keep the label cardinality low.
+func objectKind(name string) string {
+	switch base := path.Base(name); {
+	case strings.Contains(name, "/chunks/"):
+		return "chunks"
+	case base == "index":
+		return "index"
+	case base == "meta.json":
+		return "meta"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,41 @@
+package bucket
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+
+	"objectstorage/pkg/util/histogram"
+)
+
+func TestUploadDurationBucketClient_Upload(t *testing.T) {
+	histograms := histogram.Config{}
+	flagext.DefaultValues(&histograms)
+
+	reg := prometheus.NewPedanticRegistry()
+	bkt := NewUploadDurationBucketClient(objstore.NewInMemBucket(), histograms, reg)
+
+	require.NoError(t, bkt.Upload(context.Background(), "user-1/01GZ/meta.json", strings.NewReader("{}")))
+	require.NoError(t, bkt.WithExpectedErrs(func(error) bool { return false }).Upload(context.Background(), "user-1/01GZ/chunks/000001", strings.NewReader("chunk")))
+
+	assert.Equal(t, 2, testutil.CollectAndCount(bkt.duration))
+}
+
+func TestObjectKind(t *testing.T) {
+	for name, expected := range map[string]string{
+		"user-1/01GZ/chunks/000001": "chunks",
+		"user-1/01GZ/index":         "index",
+		"user-1/01GZ/meta.json":     "meta",
+		"user-1/bucket-index.json":  "other",
+	} {
+		assert.Equal(t, expected, objectKind(name), "object: %s", name)
+	}
+}
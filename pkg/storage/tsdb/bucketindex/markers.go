@@ -25,6 +25,7 @@ var (
 	MarkersMap = map[string]func(ulid.ULID) string{
 		metadata.DeletionMarkFilename:  BlockDeletionMarkFilepath,
 		metadata.NoCompactMarkFilename: NoCompactMarkFilenameMarkFilepath,
+		TieringMarkFilename:            TieringMarkFilepath,
 	}
 )
 
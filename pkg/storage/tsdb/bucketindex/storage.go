@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 
 	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
 	"github.com/thanos-io/objstore"
 
@@ -14,48 +15,103 @@ import (
 	"github.com/cortexproject/cortex/pkg/util/runutil"
 )
 
+const (
+	// IndexExtensionFilename is the name of the object holding the parts of the index unknown
+	// to the Cortex bucket index, which the compactor rewrites without them.
+	IndexExtensionFilename           = "bucket-index-ext.json"
+	IndexExtensionCompressedFilename = IndexExtensionFilename + ".gz"
+)
+
 var (
 	ErrIndexNotFound  = errors.New("bucket index not found")
 	ErrIndexCorrupted = errors.New("bucket index corrupted")
 )
 
-// ReadIndex reads, parses and returns a bucket index from the bucket.
+// indexExtension holds the parts of the index unknown to the Cortex bucket index.
+type indexExtension struct {
+	BlockTieringMarks BlockTieringMarks `json:"block_tiering_marks,omitempty"`
+	Tombstones        Tombstones        `json:"tombstones,omitempty"`
+}
+
+// ReadIndex reads, parses and returns a bucket index from the bucket. The tiering marks and
+// tombstones are read from the index extension if any, since the bucket index may have been
+// rewritten by the compactor without them.
 func ReadIndex(ctx context.Context, bkt objstore.Bucket, userID string, cfgProvider bucket.TenantConfigProvider, logger log.Logger) (*Index, error) {
 	userBkt := bucket.NewUserBucketClient(userID, bkt, cfgProvider)
 
 	// Get the bucket index.
-	reader, err := userBkt.WithExpectedErrs(userBkt.IsObjNotFoundErr).Get(ctx, IndexCompressedFilename)
+	index := &Index{}
+	if err := readObject(ctx, userBkt, IndexCompressedFilename, index, logger); err != nil {
+		return nil, err
+	}
+
+	// Get the index extension, missing if the index has been written by the compactor only.
+	ext := &indexExtension{}
+	err := readObject(ctx, userBkt, IndexExtensionCompressedFilename, ext, logger)
+	if errors.Is(err, ErrIndexNotFound) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The tiering marks of the blocks deleted since the extension was written are dropped.
+	blocks := make(map[ulid.ULID]struct{}, len(index.Blocks))
+	for _, b := range index.Blocks {
+		blocks[b.ID] = struct{}{}
+	}
+	index.BlockTieringMarks = index.BlockTieringMarks[:0]
+	for _, m := range ext.BlockTieringMarks {
+		if _, ok := blocks[m.ID]; ok {
+			index.BlockTieringMarks = append(index.BlockTieringMarks, m)
+		}
+	}
+	index.Tombstones = ext.Tombstones
+
+	return index, nil
+}
+
+func readObject(ctx context.Context, userBkt objstore.InstrumentedBucket, name string, v interface{}, logger log.Logger) error {
+	reader, err := userBkt.WithExpectedErrs(userBkt.IsObjNotFoundErr).Get(ctx, name)
 	if err != nil {
 		if userBkt.IsObjNotFoundErr(err) {
-			return nil, ErrIndexNotFound
+			return ErrIndexNotFound
 		}
-		return nil, errors.Wrap(err, "read bucket index")
+		return errors.Wrap(err, "read bucket index")
 	}
 	defer runutil.CloseWithLogOnErr(logger, reader, "close bucket index reader")
 
 	// Read all the content.
 	gzipReader, err := gzip.NewReader(reader)
 	if err != nil {
-		return nil, ErrIndexCorrupted
+		return ErrIndexCorrupted
 	}
 	defer runutil.CloseWithLogOnErr(logger, gzipReader, "close bucket index gzip reader")
 
 	// Deserialize it.
-	index := &Index{}
 	d := json.NewDecoder(gzipReader)
-	if err := d.Decode(index); err != nil {
-		return nil, ErrIndexCorrupted
+	if err := d.Decode(v); err != nil {
+		return ErrIndexCorrupted
 	}
 
-	return index, nil
+	return nil
 }
 
-// WriteIndex uploads the provided index to the storage.
+// WriteIndex uploads the provided index to the storage, along with its extension. The
+// extension is written last, so that it's never older than the index written by this function.
 func WriteIndex(ctx context.Context, bkt objstore.Bucket, userID string, cfgProvider bucket.TenantConfigProvider, idx *Index) error {
 	bkt = bucket.NewUserBucketClient(userID, bkt, cfgProvider)
 
+	if err := writeObject(ctx, bkt, IndexFilename, idx); err != nil {
+		return err
+	}
+	ext := &indexExtension{BlockTieringMarks: idx.BlockTieringMarks, Tombstones: idx.Tombstones}
+	return writeObject(ctx, bkt, IndexExtensionFilename, ext)
+}
+
+func writeObject(ctx context.Context, bkt objstore.Bucket, name string, v interface{}) error {
 	// Marshal the index.
-	content, err := json.Marshal(idx)
+	content, err := json.Marshal(v)
 	if err != nil {
 		return errors.Wrap(err, "marshal bucket index")
 	}
@@ -63,7 +119,7 @@ func WriteIndex(ctx context.Context, bkt objstore.Bucket, userID string, cfgProv
 	// Compress it.
 	var gzipContent bytes.Buffer
 	gzip := gzip.NewWriter(&gzipContent)
-	gzip.Name = IndexFilename
+	gzip.Name = name
 
 	if _, err := gzip.Write(content); err != nil {
 		return errors.Wrap(err, "gzip bucket index")
@@ -73,21 +129,23 @@ func WriteIndex(ctx context.Context, bkt objstore.Bucket, userID string, cfgProv
 	}
 
 	// Upload the index to the storage.
-	if err := bkt.Upload(ctx, IndexCompressedFilename, &gzipContent); err != nil {
+	if err := bkt.Upload(ctx, name+".gz", &gzipContent); err != nil {
 		return errors.Wrap(err, "upload bucket index")
 	}
 
 	return nil
 }
 
-// DeleteIndex deletes the bucket index from the storage. No error is returned if the index
-// does not exist.
+// DeleteIndex deletes the bucket index and its extension from the storage. No error is returned
+// if the index does not exist.
 func DeleteIndex(ctx context.Context, bkt objstore.Bucket, userID string, cfgProvider bucket.TenantConfigProvider) error {
 	bkt = bucket.NewUserBucketClient(userID, bkt, cfgProvider)
 
-	err := bkt.Delete(ctx, IndexCompressedFilename)
-	if err != nil && !bkt.IsObjNotFoundErr(err) {
-		return errors.Wrap(err, "delete bucket index")
+	for _, name := range []string{IndexExtensionCompressedFilename, IndexCompressedFilename} {
+		err := bkt.Delete(ctx, name)
+		if err != nil && !bkt.IsObjNotFoundErr(err) {
+			return errors.Wrap(err, "delete bucket index")
+		}
 	}
 	return nil
 }
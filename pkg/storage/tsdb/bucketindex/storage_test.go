@@ -5,13 +5,18 @@ import (
 	"path"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/cortexproject/cortex/pkg/compactor"
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
 	"github.com/cortexproject/cortex/pkg/storage/tsdb/testutil"
 	cortex_testutil "github.com/cortexproject/cortex/pkg/storage/tsdb/testutil"
+	"github.com/cortexproject/cortex/pkg/util/services"
 )
 
 func TestReadIndex_ShouldReturnErrorIfIndexDoesNotExist(t *testing.T) {
@@ -62,6 +67,82 @@ func TestReadIndex_ShouldReturnTheParsedIndexOnSuccess(t *testing.T) {
 	assert.Equal(t, expectedIdx, actualIdx)
 }
 
+func TestReadIndex_ShouldKeepTheTieringMarksAndTombstonesOfTheIndexRewrittenByTheCompactor(t *testing.T) {
+	const userID = "user-1"
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+
+	bkt, _ := cortex_testutil.PrepareFilesystemBucket(t)
+	bkt = BucketWithGlobalMarkers(bkt)
+	userBkt := bucket.NewUserBucketClient(userID, bkt, nil)
+
+	tiered := testutil.MockStorageBlock(t, bkt, userID, 10, 20)
+	deleted := testutil.MockStorageBlock(t, bkt, userID, 20, 30)
+	require.NoError(t, WriteTieringMark(ctx, userBkt, TieringMark{ID: tiered.ULID, Version: 1, StorageClass: "STANDARD_IA", TieringTime: time.Now().Unix()}))
+	require.NoError(t, WriteTombstone(ctx, userBkt, &Tombstone{RequestID: "request-1", Version: 1, Selectors: []string{`{job="a"}`}, StartTime: 10, EndTime: 20}))
+
+	idx, _, _, err := NewUpdater(bkt, userID, nil, logger).UpdateIndex(ctx, nil)
+	require.NoError(t, err)
+	require.NoError(t, WriteIndex(ctx, bkt, userID, nil, idx))
+
+	// The compactor cleaner rewrites the index with the deletion mark of the block, without the
+	// tiering marks and tombstones unknown to the Cortex bucket index.
+	testutil.MockStorageDeletionMark(t, bkt, userID, deleted)
+	cleaner := compactor.NewBlocksCleaner(compactor.BlocksCleanerConfig{
+		DeletionDelay:      time.Hour,
+		CleanupInterval:    time.Hour,
+		CleanupConcurrency: 1,
+	}, bkt, cortex_tsdb.NewUsersScanner(bkt, cortex_tsdb.AllUsers, logger), retentionProvider{}, logger, nil)
+	require.NoError(t, services.StartAndAwaitRunning(ctx, cleaner))
+	require.NoError(t, services.StopAndAwaitTerminated(ctx, cleaner))
+
+	actualIdx, err := ReadIndex(ctx, bkt, userID, nil, logger)
+	require.NoError(t, err)
+	require.Len(t, actualIdx.BlockDeletionMarks, 1)
+	assert.Equal(t, deleted.ULID, actualIdx.BlockDeletionMarks[0].ID)
+	assert.Equal(t, idx.BlockTieringMarks, actualIdx.BlockTieringMarks)
+	assert.Equal(t, idx.Tombstones, actualIdx.Tombstones)
+}
+
+func TestReadIndex_ShouldDropTheTieringMarksOfTheDeletedBlocks(t *testing.T) {
+	const userID = "user-1"
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+
+	bkt, _ := cortex_testutil.PrepareFilesystemBucket(t)
+	bkt = BucketWithGlobalMarkers(bkt)
+	userBkt := bucket.NewUserBucketClient(userID, bkt, nil)
+
+	block := testutil.MockStorageBlock(t, bkt, userID, 10, 20)
+	require.NoError(t, WriteTieringMark(ctx, userBkt, TieringMark{ID: block.ULID, Version: 1, StorageClass: "STANDARD_IA"}))
+
+	idx, _, _, err := NewUpdater(bkt, userID, nil, logger).UpdateIndex(ctx, nil)
+	require.NoError(t, err)
+	require.NoError(t, WriteIndex(ctx, bkt, userID, nil, idx))
+	require.Len(t, idx.BlockTieringMarks, 1)
+
+	// The index is rewritten without the block, but the extension isn't.
+	idx.RemoveBlock(block.ULID)
+	require.NoError(t, writeObject(ctx, userBkt, IndexFilename, idx))
+
+	actualIdx, err := ReadIndex(ctx, bkt, userID, nil, logger)
+	require.NoError(t, err)
+	assert.Empty(t, actualIdx.BlockTieringMarks)
+}
+
+// retentionProvider disables the retention of the blocks of all the tenants.
+type retentionProvider struct{}
+
+func (retentionProvider) S3SSEType(string) string                 { return "" }
+func (retentionProvider) S3SSEKMSKeyID(string) string             { return "" }
+func (retentionProvider) S3SSEKMSEncryptionContext(string) string { return "" }
+
+func (retentionProvider) CompactorBlocksRetentionPeriod(string) time.Duration {
+	return 0
+}
+
 func BenchmarkReadIndex(b *testing.B) {
 	const (
 		numBlocks             = 1000
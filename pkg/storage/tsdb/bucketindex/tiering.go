@@ -0,0 +1,133 @@
+package bucketindex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+)
+
+const (
+	// TieringMarkFilename is the name of the marker of a block transitioned to a colder storage
+	// class.
+	TieringMarkFilename = "tiering-mark.json"
+	TieringMarkVersion1 = 1
+)
+
+var (
+	ErrTieringMarkNotFound  = errors.New("block tiering mark not found")
+	ErrTieringMarkCorrupted = errors.New("block tiering mark corrupted")
+)
+
+// TieringMark marks a block whose objects have been transitioned to a colder storage class,
+// cheaper to store but with a higher retrieval latency and cost.
+type TieringMark struct {
+	ID      ulid.ULID `json:"id"`
+	Version int       `json:"version"`
+
+	// StorageClass is the storage class the block has been transitioned to, like STANDARD_IA.
+	StorageClass string `json:"storage_class"`
+
+	// TieringTime is a unix timestamp (seconds precision) of when the block has been transitioned.
+	TieringTime int64 `json:"tiering_time"`
+}
+
+// TieringMarkFilepath returns the path, relative to the tenant's bucket location, of a block
+// tiering mark in the bucket markers location.
+func TieringMarkFilepath(blockID ulid.ULID) string {
+	return fmt.Sprintf("%s/%s-%s", MarkersPathname, blockID.String(), TieringMarkFilename)
+}
+
+// IsBlockTieringMarkFilename returns whether the input filename matches the expected pattern
+// of block tiering markers stored in the markers location.
+func IsBlockTieringMarkFilename(name string) (ulid.ULID, bool) {
+	parts := strings.SplitN(name, "-", 2)
+	if len(parts) != 2 || parts[1] != TieringMarkFilename {
+		return ulid.ULID{}, false
+	}
+
+	id, err := ulid.Parse(filepath.Base(parts[0]))
+	return id, err == nil
+}
+
+// WriteTieringMark writes the tiering mark in the block location and in the global markers
+// location. The bucket must be the tenant's bucket.
+func WriteTieringMark(ctx context.Context, userBkt objstore.Bucket, mark TieringMark) error {
+	data, err := json.Marshal(mark)
+	if err != nil {
+		return errors.Wrap(err, "marshal tiering mark")
+	}
+	return BucketWithGlobalMarkers(userBkt).Upload(ctx, path.Join(mark.ID.String(), TieringMarkFilename), bytes.NewReader(data))
+}
+
+// ReadTieringMark reads the tiering mark of the block from the global markers location. The
+// bucket must be the tenant's bucket.
+func ReadTieringMark(ctx context.Context, userBkt objstore.InstrumentedBucket, id ulid.ULID) (*TieringMark, error) {
+	r, err := userBkt.WithExpectedErrs(userBkt.IsObjNotFoundErr).Get(ctx, TieringMarkFilepath(id))
+	if userBkt.IsObjNotFoundErr(err) {
+		return nil, ErrTieringMarkNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "get tiering mark of block %s", id)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read tiering mark of block %s", id)
+	}
+
+	mark := TieringMark{}
+	if err := json.Unmarshal(data, &mark); err != nil {
+		return nil, errors.Wrapf(ErrTieringMarkCorrupted, "unmarshal tiering mark of block %s: %v", id, err)
+	}
+	if mark.Version != TieringMarkVersion1 {
+		return nil, errors.Wrapf(ErrTieringMarkCorrupted, "unexpected tiering mark version of block %s: %d", id, mark.Version)
+	}
+	return &mark, nil
+}
+
+// BlockTieringMark holds the information about a block's tiering mark in the index, so that
+// the readers know which blocks are slower and more expensive to retrieve.
+type BlockTieringMark struct {
+	// Block ID.
+	ID ulid.ULID `json:"block_id"`
+
+	// StorageClass is the storage class the block has been transitioned to.
+	StorageClass string `json:"storage_class"`
+
+	// TieringTime is a unix timestamp (seconds precision) of when the block has been transitioned.
+	TieringTime int64 `json:"tiering_time"`
+}
+
+func (m *BlockTieringMark) GetTieringTime() time.Time {
+	return time.Unix(m.TieringTime, 0)
+}
+
+func BlockTieringMarkFromMark(mark *TieringMark) *BlockTieringMark {
+	return &BlockTieringMark{
+		ID:           mark.ID,
+		StorageClass: mark.StorageClass,
+		TieringTime:  mark.TieringTime,
+	}
+}
+
+// BlockTieringMarks holds a set of block tiering marks in the index. No ordering guaranteed.
+type BlockTieringMarks []*BlockTieringMark
+
+func (s BlockTieringMarks) GetULIDs() []ulid.ULID {
+	ids := make([]ulid.ULID, len(s))
+	for i, m := range s {
+		ids[i] = m.ID
+	}
+	return ids
+}
@@ -0,0 +1,61 @@
+package bucketindex
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/oklog/ulid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+)
+
+func TestTieringMarkFilepath(t *testing.T) {
+	id := ulid.MustNew(1, nil)
+
+	assert.Equal(t, "markers/"+id.String()+"-tiering-mark.json", TieringMarkFilepath(id))
+}
+
+func TestIsBlockTieringMarkFilename(t *testing.T) {
+	expected := ulid.MustNew(1, nil)
+
+	_, ok := IsBlockTieringMarkFilename("xxx-tiering-mark.json")
+	assert.False(t, ok)
+
+	_, ok = IsBlockTieringMarkFilename(expected.String() + "-deletion-mark.json")
+	assert.False(t, ok)
+
+	actual, ok := IsBlockTieringMarkFilename(expected.String() + "-tiering-mark.json")
+	assert.True(t, ok)
+	assert.Equal(t, expected, actual)
+}
+
+func TestWriteTieringMark(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	userBkt := bucket.NewUserBucketClient("user-1", bkt, nil)
+	id := ulid.MustNew(1, nil)
+
+	_, err := ReadTieringMark(ctx, userBkt, id)
+	assert.ErrorIs(t, err, ErrTieringMarkNotFound)
+
+	mark := TieringMark{ID: id, Version: TieringMarkVersion1, StorageClass: "STANDARD_IA", TieringTime: 10}
+	require.NoError(t, WriteTieringMark(ctx, userBkt, mark))
+
+	// The mark is written in the block location too.
+	exists, err := bkt.Exists(ctx, path.Join("user-1", id.String(), TieringMarkFilename))
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	actual, err := ReadTieringMark(ctx, userBkt, id)
+	require.NoError(t, err)
+	assert.Equal(t, mark, *actual)
+
+	require.NoError(t, bkt.Upload(ctx, path.Join("user-1", TieringMarkFilepath(id)), strings.NewReader("{}")))
+	_, err = ReadTieringMark(ctx, userBkt, id)
+	assert.ErrorIs(t, err, ErrTieringMarkCorrupted)
+}
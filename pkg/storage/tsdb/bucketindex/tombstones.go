@@ -0,0 +1,116 @@
+package bucketindex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/thanos-io/objstore"
+)
+
+const (
+	// TombstonesPathname is the location of the series deletion tombstones, relative to the
+	// tenant's bucket location.
+	TombstonesPathname = "tombstones"
+	TombstoneVersion1  = 1
+)
+
+var (
+	ErrTombstoneNotFound  = errors.New("tombstone not found")
+	ErrTombstoneCorrupted = errors.New("tombstone corrupted")
+)
+
+// Tombstone is a request to delete the samples of the series matching any of the selectors
+// within the time range. The samples are masked on read until the blocks holding them are
+// rewritten without them.
+type Tombstone struct {
+	RequestID string `json:"request_id"`
+	Version   int    `json:"version"`
+
+	// Selectors are the series selectors, like {job="node"}.
+	Selectors []string `json:"selectors"`
+
+	// StartTime and EndTime are the unix timestamps (milliseconds precision) of the deleted
+	// time range, both inclusive.
+	StartTime int64 `json:"start_time"`
+	EndTime   int64 `json:"end_time"`
+
+	// CreationTime is a unix timestamp (seconds precision) of when the deletion was requested.
+	CreationTime int64 `json:"creation_time"`
+}
+
+func (t *Tombstone) GetCreationTime() time.Time {
+	return time.Unix(t.CreationTime, 0)
+}
+
+// Matchers returns the matchers of each selector.
+func (t *Tombstone) Matchers() ([][]*labels.Matcher, error) {
+	out := make([][]*labels.Matcher, 0, len(t.Selectors))
+	for _, s := range t.Selectors {
+		m, err := parser.ParseMetricSelector(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse selector %s of tombstone %s", s, t.RequestID)
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// TombstoneFilepath returns the path, relative to the tenant's bucket location, of the
+// tombstone of the deletion request.
+func TombstoneFilepath(requestID string) string {
+	return path.Join(TombstonesPathname, requestID+".json")
+}
+
+// IsTombstoneFilename returns the request ID of the tombstone if the input filename matches
+// the expected pattern of tombstones.
+func IsTombstoneFilename(name string) (string, bool) {
+	requestID := strings.TrimSuffix(path.Base(name), ".json")
+	return requestID, requestID != "" && requestID != path.Base(name)
+}
+
+// WriteTombstone writes the tombstone. The bucket must be the tenant's bucket.
+func WriteTombstone(ctx context.Context, userBkt objstore.Bucket, t *Tombstone) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return errors.Wrap(err, "marshal tombstone")
+	}
+	return userBkt.Upload(ctx, TombstoneFilepath(t.RequestID), bytes.NewReader(data))
+}
+
+// ReadTombstone reads the tombstone of the deletion request. The bucket must be the tenant's
+// bucket.
+func ReadTombstone(ctx context.Context, userBkt objstore.InstrumentedBucket, requestID string) (*Tombstone, error) {
+	r, err := userBkt.WithExpectedErrs(userBkt.IsObjNotFoundErr).Get(ctx, TombstoneFilepath(requestID))
+	if userBkt.IsObjNotFoundErr(err) {
+		return nil, ErrTombstoneNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "get tombstone %s", requestID)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read tombstone %s", requestID)
+	}
+
+	t := Tombstone{}
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, errors.Wrapf(ErrTombstoneCorrupted, "unmarshal tombstone %s: %v", requestID, err)
+	}
+	if t.Version != TombstoneVersion1 {
+		return nil, errors.Wrapf(ErrTombstoneCorrupted, "unexpected tombstone version %s: %d", requestID, t.Version)
+	}
+	return &t, nil
+}
+
+// Tombstones holds a set of tombstones in the index. No ordering guaranteed.
+type Tombstones []*Tombstone
@@ -0,0 +1,61 @@
+package bucketindex
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+)
+
+func TestIsTombstoneFilename(t *testing.T) {
+	_, ok := IsTombstoneFilename("tombstones/.json")
+	assert.False(t, ok)
+
+	_, ok = IsTombstoneFilename("tombstones/request-1.txt")
+	assert.False(t, ok)
+
+	actual, ok := IsTombstoneFilename(TombstoneFilepath("request-1"))
+	assert.True(t, ok)
+	assert.Equal(t, "request-1", actual)
+}
+
+func TestWriteTombstone(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	userBkt := bucket.NewUserBucketClient("user-1", bkt, nil)
+
+	_, err := ReadTombstone(ctx, userBkt, "request-1")
+	assert.ErrorIs(t, err, ErrTombstoneNotFound)
+
+	tombstone := &Tombstone{RequestID: "request-1", Version: TombstoneVersion1, Selectors: []string{`{job="node"}`}, StartTime: 10, EndTime: 20, CreationTime: 30}
+	require.NoError(t, WriteTombstone(ctx, userBkt, tombstone))
+
+	actual, err := ReadTombstone(ctx, userBkt, "request-1")
+	require.NoError(t, err)
+	assert.Equal(t, tombstone, actual)
+
+	require.NoError(t, bkt.Upload(ctx, path.Join("user-1", TombstoneFilepath("request-1")), strings.NewReader("{}")))
+	_, err = ReadTombstone(ctx, userBkt, "request-1")
+	assert.ErrorIs(t, err, ErrTombstoneCorrupted)
+}
+
+func TestTombstone_Matchers(t *testing.T) {
+	tombstone := &Tombstone{Selectors: []string{`up{job="node"}`, `{instance=~"a.*"}`}}
+	matchers, err := tombstone.Matchers()
+	require.NoError(t, err)
+	assert.Equal(t, [][]*labels.Matcher{
+		{labels.MustNewMatcher(labels.MatchEqual, "job", "node"), labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up")},
+		{labels.MustNewMatcher(labels.MatchRegexp, "instance", "a.*")},
+	}, matchers)
+
+	tombstone.Selectors = []string{"{"}
+	_, err = tombstone.Matchers()
+	assert.Error(t, err)
+}
@@ -1,6 +1,7 @@
 package tsdb
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"path/filepath"
@@ -18,6 +19,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/cache"
 	"github.com/thanos-io/thanos/pkg/cacheutil"
+	"github.com/thanos-io/thanos/pkg/model"
 	storecache "github.com/thanos-io/thanos/pkg/store/cache"
 )
 
@@ -26,6 +28,11 @@ const (
 	CacheBackendRedis     = "redis"
 )
 
+var (
+	errInvalidInMemoryMaxSize = errors.New("the in-memory cache max size must be greater than or equal to 0")
+	errInvalidInMemoryTTL     = errors.New("the in-memory cache TTL must be greater than 0")
+)
+
 type CacheBackend struct {
 	Backend   string                `yaml:"backend"`
 	Memcached MemcachedClientConfig `yaml:"memcached"`
@@ -56,7 +63,7 @@ type ChunksCacheConfig struct {
 }
 
 func (cfg *ChunksCacheConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
-	f.StringVar(&cfg.Backend, prefix+"backend", "", fmt.Sprintf("Backend for chunks cache, if not empty. Supported values: %s.", CacheBackendMemcached))
+	f.StringVar(&cfg.Backend, prefix+"backend", "", fmt.Sprintf("Backend for chunks cache, if not empty. Supported values: %s, %s.", CacheBackendMemcached, CacheBackendRedis))
 
 	cfg.Memcached.RegisterFlagsWithPrefix(f, prefix+"memcached.")
 	cfg.Redis.RegisterFlagsWithPrefix(f, prefix+"redis.")
@@ -85,10 +92,15 @@ type MetadataCacheConfig struct {
 	BlockIndexAttributesTTL time.Duration `yaml:"block_index_attributes_ttl"`
 	BucketIndexContentTTL   time.Duration `yaml:"bucket_index_content_ttl"`
 	BucketIndexMaxSize      int           `yaml:"bucket_index_max_size_bytes"`
+
+	// The in-memory L1 cache, in front of the backend if any, which saves the round trips to
+	// the backend for the objects read the most, like the bucket index.
+	InMemoryMaxSize int           `yaml:"inmemory_max_size_bytes"`
+	InMemoryTTL     time.Duration `yaml:"inmemory_ttl"`
 }
 
 func (cfg *MetadataCacheConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
-	f.StringVar(&cfg.Backend, prefix+"backend", "", fmt.Sprintf("Backend for metadata cache, if not empty. Supported values: %s.", CacheBackendMemcached))
+	f.StringVar(&cfg.Backend, prefix+"backend", "", fmt.Sprintf("Backend for metadata cache, if not empty. Supported values: %s, %s.", CacheBackendMemcached, CacheBackendRedis))
 
 	cfg.Memcached.RegisterFlagsWithPrefix(f, prefix+"memcached.")
 	cfg.Redis.RegisterFlagsWithPrefix(f, prefix+"redis.")
@@ -104,9 +116,17 @@ func (cfg *MetadataCacheConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix
 	f.DurationVar(&cfg.BlockIndexAttributesTTL, prefix+"block-index-attributes-ttl", 168*time.Hour, "How long to cache attributes of the block index.")
 	f.DurationVar(&cfg.BucketIndexContentTTL, prefix+"bucket-index-content-ttl", 5*time.Minute, "How long to cache content of the bucket index.")
 	f.IntVar(&cfg.BucketIndexMaxSize, prefix+"bucket-index-max-size-bytes", 1*1024*1024, "Maximum size of bucket index content to cache in bytes. Caching will be skipped if the content exceeds this size. This is useful to avoid network round trip for large content if the configured caching backend has an hard limit on cached items size (in this case, you should set this limit to the same limit in the caching backend).")
+	f.IntVar(&cfg.InMemoryMaxSize, prefix+"inmemory-max-size-bytes", 0, "Maximum size of the in-memory metadata cache, looked up before the backend, in bytes. 0 to disable the in-memory cache. The in-memory cache can be enabled without backend.")
+	f.DurationVar(&cfg.InMemoryTTL, prefix+"inmemory-ttl", time.Minute, "Maximum time an item is kept in the in-memory metadata cache. The items are kept for the lowest of this TTL and the TTL of their kind, so that the instances don't serve stale metadata for longer than this TTL after an update.")
 }
 
 func (cfg *MetadataCacheConfig) Validate() error {
+	if cfg.InMemoryMaxSize < 0 {
+		return errInvalidInMemoryMaxSize
+	}
+	if cfg.InMemoryMaxSize > 0 && cfg.InMemoryTTL <= 0 {
+		return errInvalidInMemoryTTL
+	}
 	return cfg.CacheBackend.Validate()
 }
 
@@ -128,6 +148,10 @@ func CreateCachingBucket(chunksConfig ChunksCacheConfig, metadataConfig Metadata
 	if err != nil {
 		return nil, errors.Wrapf(err, "metadata-cache")
 	}
+	metadataCache, err = withInMemoryCache("metadata-cache", metadataCache, metadataConfig.InMemoryMaxSize, metadataConfig.InMemoryTTL, logger, reg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "metadata-cache")
+	}
 	if metadataCache != nil {
 		cachingConfigured = true
 		metadataCache = cache.NewTracingCache(metadataCache)
@@ -178,6 +202,37 @@ func createCache(cacheName string, cacheBackend *CacheBackend, logger log.Logger
 	}
 }
 
+// withInMemoryCache puts an in-memory cache of maxSize bytes in front of the backend cache,
+// which may be nil. The backend cache is returned as is if maxSize is 0.
+func withInMemoryCache(cacheName string, backend cache.Cache, maxSize int, ttl time.Duration, logger log.Logger, reg prometheus.Registerer) (cache.Cache, error) {
+	if maxSize <= 0 {
+		return backend, nil
+	}
+
+	inMemory, err := cache.NewInMemoryCacheWithConfig(cacheName, logger, reg, cache.InMemoryCacheConfig{
+		MaxSize:     model.Bytes(maxSize),
+		MaxItemSize: model.Bytes(maxSize),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create in-memory cache")
+	}
+	if backend == nil {
+		return newMultiLevelCache(inMemory, noopCache{name: cacheName}, ttl), nil
+	}
+	return newMultiLevelCache(inMemory, backend, ttl), nil
+}
+
+// noopCache is the L2 cache of the in-memory cache configured without backend.
+type noopCache struct {
+	name string
+}
+
+func (noopCache) Store(context.Context, map[string][]byte, time.Duration) {}
+
+func (noopCache) Fetch(context.Context, []string) map[string][]byte { return nil }
+
+func (c noopCache) Name() string { return c.name }
+
 var chunksMatcher = regexp.MustCompile(`^.*/chunks/\d+$`)
 
 func isTSDBChunkFile(name string) bool { return chunksMatcher.MatchString(name) }
@@ -198,7 +253,7 @@ func isBlockIndexFile(name string) bool {
 
 func isBucketIndexFile(name string) bool {
 	// TODO can't reference bucketindex because of a circular dependency. To be fixed.
-	return strings.HasSuffix(name, "/bucket-index.json.gz")
+	return strings.HasSuffix(name, "/bucket-index.json.gz") || strings.HasSuffix(name, "/bucket-index-ext.json.gz")
 }
 
 func isTenantsDir(name string) bool {
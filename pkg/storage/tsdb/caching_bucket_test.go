@@ -1,13 +1,105 @@
 package tsdb
 
 import (
+	"bytes"
+	"context"
+	"flag"
 	"fmt"
+	"io"
 	"testing"
+	"time"
 
+	"github.com/go-kit/log"
 	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
 )
 
+func defaultMetadataCacheConfig() MetadataCacheConfig {
+	cfg := MetadataCacheConfig{}
+	cfg.RegisterFlagsWithPrefix(flag.NewFlagSet("", flag.PanicOnError), "")
+	return cfg
+}
+
+func TestMetadataCacheConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *MetadataCacheConfig)
+		expected error
+	}{
+		"default config": {
+			setup:    func(*MetadataCacheConfig) {},
+			expected: nil,
+		},
+		"in-memory cache without backend": {
+			setup:    func(cfg *MetadataCacheConfig) { cfg.InMemoryMaxSize = 1024 },
+			expected: nil,
+		},
+		"negative in-memory max size": {
+			setup:    func(cfg *MetadataCacheConfig) { cfg.InMemoryMaxSize = -1 },
+			expected: errInvalidInMemoryMaxSize,
+		},
+		"zero in-memory TTL": {
+			setup: func(cfg *MetadataCacheConfig) {
+				cfg.InMemoryMaxSize = 1024
+				cfg.InMemoryTTL = 0
+			},
+			expected: errInvalidInMemoryTTL,
+		},
+		"no memcached addresses": {
+			setup:    func(cfg *MetadataCacheConfig) { cfg.Backend = CacheBackendMemcached },
+			expected: errNoIndexCacheAddresses,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := defaultMetadataCacheConfig()
+			tc.setup(&cfg)
+			assert.Equal(t, tc.expected, cfg.Validate())
+		})
+	}
+}
+
+// countingBucket counts the Get calls, by object name.
+type countingBucket struct {
+	objstore.Bucket
+	gets map[string]int
+}
+
+func (b *countingBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	b.gets[name]++
+	return b.Bucket.Get(ctx, name)
+}
+
+func TestCreateCachingBucket_ShouldCacheTheMetadataInMemory(t *testing.T) {
+	ctx := context.Background()
+	bkt := &countingBucket{Bucket: objstore.NewInMemBucket(), gets: map[string]int{}}
+	metaPath := ulid.MustNew(1, nil).String() + "/meta.json"
+	require.NoError(t, bkt.Upload(ctx, "user-1/bucket-index.json.gz", bytes.NewReader([]byte("index"))))
+	require.NoError(t, bkt.Upload(ctx, "user-1/"+metaPath, bytes.NewReader([]byte("{}"))))
+
+	cfg := defaultMetadataCacheConfig()
+	cfg.InMemoryMaxSize = 1024 * 1024
+	cfg.InMemoryTTL = time.Minute
+
+	cached, err := CreateCachingBucket(ChunksCacheConfig{}, cfg, bkt, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		for _, name := range []string{"user-1/bucket-index.json.gz", "user-1/" + metaPath} {
+			r, err := cached.Get(ctx, name)
+			require.NoError(t, err)
+			_, err = io.ReadAll(r)
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+		}
+	}
+	assert.Equal(t, 1, bkt.gets["user-1/bucket-index.json.gz"])
+	assert.Equal(t, 1, bkt.gets["user-1/"+metaPath])
+}
+
 func TestIsTenantDir(t *testing.T) {
 	assert.False(t, isTenantBlocksDir(""))
 	assert.True(t, isTenantBlocksDir("test"))
@@ -22,6 +114,7 @@ func TestIsBucketIndexFile(t *testing.T) {
 	assert.False(t, isBucketIndexFile("test/block"))
 	assert.False(t, isBucketIndexFile("test/block/chunks"))
 	assert.True(t, isBucketIndexFile("test/bucket-index.json.gz"))
+	assert.True(t, isBucketIndexFile("test/bucket-index-ext.json.gz"))
 }
 
 func TestIsBlockIndexFile(t *testing.T) {
@@ -0,0 +1,65 @@
+package tsdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/thanos-io/thanos/pkg/cache"
+)
+
+// multiLevelCache is a cache.Cache looking up the items in an in-memory L1 cache first, and
+// then in the shared L2 cache, backfilling the L1 cache with the items found. The items are
+// kept in the L1 cache for l1TTL at most, since they're never invalidated on update.
+type multiLevelCache struct {
+	l1    cache.Cache
+	l2    cache.Cache
+	l1TTL time.Duration
+}
+
+func newMultiLevelCache(l1, l2 cache.Cache, l1TTL time.Duration) cache.Cache {
+	return &multiLevelCache{l1: l1, l2: l2, l1TTL: l1TTL}
+}
+
+// Store implements cache.Cache.
+func (c *multiLevelCache) Store(ctx context.Context, data map[string][]byte, ttl time.Duration) {
+	l1TTL := ttl
+	if l1TTL > c.l1TTL {
+		l1TTL = c.l1TTL
+	}
+	c.l1.Store(ctx, data, l1TTL)
+	c.l2.Store(ctx, data, ttl)
+}
+
+// Fetch implements cache.Cache.
+func (c *multiLevelCache) Fetch(ctx context.Context, keys []string) map[string][]byte {
+	hits := c.l1.Fetch(ctx, keys)
+	if len(hits) == len(keys) {
+		return hits
+	}
+
+	missing := make([]string, 0, len(keys)-len(hits))
+	for _, key := range keys {
+		if _, ok := hits[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+
+	l2Hits := c.l2.Fetch(ctx, missing)
+	if len(l2Hits) == 0 {
+		return hits
+	}
+	c.l1.Store(ctx, l2Hits, c.l1TTL)
+
+	if hits == nil {
+		hits = make(map[string][]byte, len(l2Hits))
+	}
+	for key, value := range l2Hits {
+		hits[key] = value
+	}
+	return hits
+}
+
+// Name implements cache.Cache.
+func (c *multiLevelCache) Name() string {
+	return c.l2.Name()
+}
@@ -0,0 +1,44 @@
+package tsdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/cache"
+)
+
+func newTestInMemoryCache(t *testing.T, name string) cache.Cache {
+	c, err := cache.NewInMemoryCacheWithConfig(name, log.NewNopLogger(), prometheus.NewPedanticRegistry(), cache.InMemoryCacheConfig{MaxSize: 1024, MaxItemSize: 1024})
+	require.NoError(t, err)
+	return c
+}
+
+func TestMultiLevelCache(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := newTestInMemoryCache(t, "l1"), newTestInMemoryCache(t, "l2")
+	c := newMultiLevelCache(l1, l2, time.Minute)
+
+	c.Store(ctx, map[string][]byte{"a": []byte("1")}, time.Hour)
+	l2.Store(ctx, map[string][]byte{"b": []byte("2")}, time.Hour)
+
+	assert.Equal(t, map[string][]byte{"a": []byte("1"), "b": []byte("2")}, c.Fetch(ctx, []string{"a", "b", "c"}))
+	assert.Equal(t, "l2", c.Name())
+
+	// The items found in the L2 cache are backfilled in the L1 cache.
+	assert.Equal(t, map[string][]byte{"a": []byte("1"), "b": []byte("2")}, l1.Fetch(ctx, []string{"a", "b"}))
+}
+
+func TestMultiLevelCache_ShouldNotKeepTheItemsInTheL1CacheLongerThanTheirTTL(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := newTestInMemoryCache(t, "l1"), newTestInMemoryCache(t, "l2")
+	c := newMultiLevelCache(l1, l2, time.Minute)
+
+	c.Store(ctx, map[string][]byte{"a": []byte("1")}, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, l1.Fetch(ctx, []string{"a"}))
+}
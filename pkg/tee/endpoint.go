@@ -0,0 +1,198 @@
+package tee
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/backoff"
+)
+
+// endpointMetrics are the metrics of the endpoints, by endpoint.
+type endpointMetrics struct {
+	sentSeries     *prometheus.CounterVec
+	failedRequests *prometheus.CounterVec
+	queueLength    *prometheus.GaugeVec
+	lag            *prometheus.GaugeVec
+}
+
+func newEndpointMetrics(reg prometheus.Registerer) *endpointMetrics {
+	return &endpointMetrics{
+		sentSeries: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_tee_sent_series_total",
+			Help: "Total number of series forwarded to the tee endpoint.",
+		}, []string{"endpoint"}),
+		failedRequests: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_tee_failed_requests_total",
+			Help: "Total number of requests to the tee endpoint which failed, including the retried ones.",
+		}, []string{"endpoint"}),
+		queueLength: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_tee_queue_length",
+			Help: "Number of series waiting to be forwarded to the tee endpoint.",
+		}, []string{"endpoint"}),
+		lag: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_tee_lag_seconds",
+			Help: "Time the series being forwarded to the tee endpoint have been waiting since ingested. 0 when the queue is empty.",
+		}, []string{"endpoint"}),
+	}
+}
+
+// endpoint queues the series forwarded to a remote write endpoint, and sends them in order,
+// in batches.
+type endpoint struct {
+	cfg     EndpointConfig
+	client  *http.Client
+	logger  log.Logger
+	queue   chan entry
+	backoff backoff.Config
+
+	dropped        *prometheus.CounterVec
+	sentSeries     prometheus.Counter
+	failedRequests prometheus.Counter
+	queueLength    prometheus.Gauge
+	lag            prometheus.Gauge
+}
+
+func newEndpoint(cfg EndpointConfig, dropped *prometheus.CounterVec, m *endpointMetrics, logger log.Logger) *endpoint {
+	return &endpoint{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		logger: logger,
+		queue:  make(chan entry, cfg.QueueSize),
+		// The first attempt counts as a retry.
+		backoff: backoff.Config{
+			MinBackoff: 100 * time.Millisecond,
+			MaxBackoff: 10 * time.Second,
+			MaxRetries: cfg.MaxRetries + 1,
+		},
+		dropped:        dropped,
+		sentSeries:     m.sentSeries.WithLabelValues(cfg.Name),
+		failedRequests: m.failedRequests.WithLabelValues(cfg.Name),
+		queueLength:    m.queueLength.WithLabelValues(cfg.Name),
+		lag:            m.lag.WithLabelValues(cfg.Name),
+	}
+}
+
+// enqueue queues the entries. The entries are dropped if the queue is full.
+func (e *endpoint) enqueue(entries []entry) {
+	for i, ent := range entries {
+		select {
+		case e.queue <- ent:
+		default:
+			e.dropped.WithLabelValues(e.cfg.Name, reasonQueueFull).Add(float64(len(entries) - i))
+			return
+		}
+	}
+}
+
+func (e *endpoint) run(ctx context.Context) {
+	batch := make([]entry, 0, e.cfg.BatchSize)
+	for {
+		e.queueLength.Set(float64(len(e.queue)))
+		if len(e.queue) == 0 {
+			e.lag.Set(0)
+		}
+
+		select {
+		case ent := <-e.queue:
+			batch = append(batch[:0], ent)
+		case <-ctx.Done():
+			return
+		}
+
+		// The queued entries are batched, up to the batch size, without waiting for more.
+		for len(batch) < e.cfg.BatchSize && len(e.queue) > 0 {
+			batch = append(batch, <-e.queue)
+		}
+		e.lag.Set(time.Since(batch[0].enqueued).Seconds())
+		e.sendBatch(ctx, batch)
+	}
+}
+
+// sendBatch sends the batch, in one request per tenant.
+func (e *endpoint) sendBatch(ctx context.Context, batch []entry) {
+	var (
+		tenants []string
+		series  = map[string][]cortexpb.PreallocTimeseries{}
+	)
+	for _, ent := range batch {
+		if _, ok := series[ent.userID]; !ok {
+			tenants = append(tenants, ent.userID)
+		}
+		series[ent.userID] = append(series[ent.userID], cortexpb.PreallocTimeseries{TimeSeries: &cortexpb.TimeSeries{
+			Labels:  cortexpb.FromLabelsToLabelAdapters(ent.labels),
+			Samples: ent.samples,
+		}})
+	}
+
+	for _, userID := range tenants {
+		if err := e.sendWithRetries(ctx, userID, series[userID]); err != nil {
+			e.dropped.WithLabelValues(e.cfg.Name, reasonSendFailed).Add(float64(len(series[userID])))
+			level.Warn(e.logger).Log("msg", "failed to forward the series to the tee endpoint", "tenant", userID, "series", len(series[userID]), "err", err)
+			continue
+		}
+		e.sentSeries.Add(float64(len(series[userID])))
+	}
+}
+
+// sendWithRetries sends the series, retrying with backoff the requests failed because of the
+// network, a server error or a rate limit.
+func (e *endpoint) sendWithRetries(ctx context.Context, userID string, series []cortexpb.PreallocTimeseries) error {
+	req := cortexpb.WriteRequest{Source: cortexpb.API, Timeseries: series}
+	buf, err := req.Marshal()
+	if err != nil {
+		return err
+	}
+	body := snappy.Encode(nil, buf)
+
+	retries := backoff.New(ctx, e.backoff)
+	for {
+		code, err := e.send(ctx, userID, body)
+		if err == nil {
+			return nil
+		}
+		e.failedRequests.Inc()
+		if code/100 == 4 && code != http.StatusTooManyRequests {
+			return err
+		}
+
+		retries.Wait()
+		if !retries.Ongoing() {
+			return err
+		}
+	}
+}
+
+// send posts the snappy-encoded write request, returning the response status code if any.
+func (e *endpoint) send(ctx context.Context, userID string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("X-Scope-OrgID", userID)
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp.StatusCode, errors.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,312 @@
+// Package tee forwards the series ingested for the tenants to external remote write
+// endpoints, in addition to the local storage, to feed secondary systems like analytics.
+package tee
+
+import (
+	"context"
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/overrides"
+	"objectstorage/pkg/push"
+	"objectstorage/pkg/tenant"
+)
+
+// Reasons of the dropped series.
+const (
+	reasonQueueFull       = "queue_full"
+	reasonSendFailed      = "send_failed"
+	reasonUnknownEndpoint = "unknown_endpoint"
+)
+
+var (
+	errMissingName       = errors.New("the tee endpoint name must not be empty")
+	errDuplicateName     = errors.New("the tee endpoint name must be unique")
+	errMissingURL        = errors.New("the tee endpoint URL has not been configured")
+	errInvalidQueueSize  = errors.New("the tee queue size must be greater than 0")
+	errInvalidBatchSize  = errors.New("the tee batch size must be greater than 0")
+	errInvalidTimeout    = errors.New("the tee timeout must be greater than 0")
+	errInvalidMaxRetries = errors.New("the tee max retries must be 0 or greater")
+)
+
+// EndpointConfig is a remote write endpoint the series are forwarded to. The settings left
+// empty default to the ones of the tee config.
+type EndpointConfig struct {
+	Name       string        `yaml:"name"`
+	URL        string        `yaml:"url"`
+	QueueSize  int           `yaml:"queue_size"`
+	BatchSize  int           `yaml:"batch_size"`
+	Timeout    time.Duration `yaml:"timeout"`
+	MaxRetries int           `yaml:"max_retries"`
+}
+
+// Config holds the configuration of the tee. The endpoints can only be set in the YAML
+// config, while the per-tenant rules selecting the forwarded series are set in the overrides.
+type Config struct {
+	Endpoints  []EndpointConfig `yaml:"endpoints"`
+	QueueSize  int              `yaml:"queue_size"`
+	BatchSize  int              `yaml:"batch_size"`
+	Timeout    time.Duration    `yaml:"timeout"`
+	MaxRetries int              `yaml:"max_retries"`
+}
+
+// RegisterFlags registers the tee flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.IntVar(&cfg.QueueSize, "tee.queue-size", 10000, "Default maximum number of series waiting to be forwarded to each endpoint. The series ingested while the queue is full aren't forwarded, so that a slow endpoint doesn't slow down the ingestion.")
+	f.IntVar(&cfg.BatchSize, "tee.batch-size", 500, "Default maximum number of series per request forwarded to each endpoint.")
+	f.DurationVar(&cfg.Timeout, "tee.timeout", 10*time.Second, "Default timeout of each request forwarded to each endpoint.")
+	f.IntVar(&cfg.MaxRetries, "tee.max-retries", 3, "Default maximum number of retries of a failed request, with an exponential backoff. The series of the request are dropped afterwards.")
+}
+
+// Enabled returns true if any endpoint is configured.
+func (cfg *Config) Enabled() bool {
+	return len(cfg.Endpoints) > 0
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if cfg.QueueSize <= 0 {
+		return errInvalidQueueSize
+	}
+	if cfg.BatchSize <= 0 {
+		return errInvalidBatchSize
+	}
+	if cfg.Timeout <= 0 {
+		return errInvalidTimeout
+	}
+	if cfg.MaxRetries < 0 {
+		return errInvalidMaxRetries
+	}
+
+	names := map[string]struct{}{}
+	for _, e := range cfg.endpoints() {
+		if e.Name == "" {
+			return errMissingName
+		}
+		if _, ok := names[e.Name]; ok {
+			return errors.Wrap(errDuplicateName, e.Name)
+		}
+		names[e.Name] = struct{}{}
+
+		switch {
+		case e.URL == "":
+			return errors.Wrap(errMissingURL, e.Name)
+		case e.QueueSize <= 0:
+			return errors.Wrap(errInvalidQueueSize, e.Name)
+		case e.BatchSize <= 0:
+			return errors.Wrap(errInvalidBatchSize, e.Name)
+		case e.Timeout <= 0:
+			return errors.Wrap(errInvalidTimeout, e.Name)
+		case e.MaxRetries < 0:
+			return errors.Wrap(errInvalidMaxRetries, e.Name)
+		}
+	}
+	return nil
+}
+
+// endpoints returns the endpoints, with the empty settings set to the defaults.
+func (cfg *Config) endpoints() []EndpointConfig {
+	out := make([]EndpointConfig, 0, len(cfg.Endpoints))
+	for _, e := range cfg.Endpoints {
+		if e.QueueSize == 0 {
+			e.QueueSize = cfg.QueueSize
+		}
+		if e.BatchSize == 0 {
+			e.BatchSize = cfg.BatchSize
+		}
+		if e.Timeout == 0 {
+			e.Timeout = cfg.Timeout
+		}
+		if e.MaxRetries == 0 {
+			e.MaxRetries = cfg.MaxRetries
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// Limits is the subset of the per-tenant overrides used by the tee. It's implemented by
+// overrides.Overrides.
+type Limits interface {
+	Tee(userID string) []overrides.TeeRule
+}
+
+// entry is a series queued to be forwarded.
+type entry struct {
+	userID   string
+	labels   labels.Labels
+	samples  []cortexpb.Sample
+	enqueued time.Time
+}
+
+// Tee queues the ingested series matching the tenant rules, and forwards them in background
+// to each endpoint.
+type Tee struct {
+	services.Service
+
+	limits    Limits
+	logger    log.Logger
+	endpoints map[string]*endpoint
+
+	// Parsed series selectors, by selector. Invalid selectors are stored as nil.
+	selectors sync.Map
+
+	droppedSeries *prometheus.CounterVec
+}
+
+// NewTee makes a new Tee.
+func NewTee(cfg Config, limits Limits, logger log.Logger, reg prometheus.Registerer) *Tee {
+	t := &Tee{
+		limits:    limits,
+		logger:    logger,
+		endpoints: map[string]*endpoint{},
+		droppedSeries: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_tee_dropped_series_total",
+			Help: "Total number of ingested series matching a tee rule which haven't been forwarded, by endpoint and reason.",
+		}, []string{"endpoint", "reason"}),
+	}
+
+	m := newEndpointMetrics(reg)
+	for _, e := range cfg.endpoints() {
+		t.endpoints[e.Name] = newEndpoint(e, t.droppedSeries, m, log.With(logger, "endpoint", e.Name))
+	}
+
+	t.Service = services.NewBasicService(nil, t.running, nil)
+	return t
+}
+
+func (t *Tee) running(ctx context.Context) error {
+	wg := sync.WaitGroup{}
+	for _, e := range t.endpoints {
+		wg.Add(1)
+		go func(e *endpoint) {
+			defer wg.Done()
+			e.run(ctx)
+		}(e)
+	}
+	wg.Wait()
+	return nil
+}
+
+// PushMiddleware returns the push.Middleware forwarding the ingested series. The matching
+// series are copied before being pushed, since the push path reuses the request series once
+// done, and queued once successfully pushed.
+func (t *Tee) PushMiddleware() push.Middleware {
+	return func(next push.Func) push.Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			userID, err := tenant.TenantID(ctx)
+			if err != nil {
+				return next(ctx, req)
+			}
+			rules := t.limits.Tee(userID)
+			if len(rules) == 0 {
+				return next(ctx, req)
+			}
+
+			matched := t.match(userID, req, rules)
+			resp, err := next(ctx, req)
+			if err == nil {
+				for name, entries := range matched {
+					if e, ok := t.endpoints[name]; ok {
+						e.enqueue(entries)
+						continue
+					}
+					t.droppedSeries.WithLabelValues(name, reasonUnknownEndpoint).Add(float64(len(entries)))
+				}
+			}
+			return resp, err
+		}
+	}
+}
+
+// match returns the copies of the series matching the rules, by endpoint. A series matching
+// several rules of the same endpoint is forwarded once.
+func (t *Tee) match(userID string, req *cortexpb.WriteRequest, rules []overrides.TeeRule) map[string][]entry {
+	// The selectors of the rules, by endpoint. A nil selector matches all the series.
+	selectors := map[string][][]*labels.Matcher{}
+	for _, rule := range rules {
+		if len(rule.Matchers) == 0 {
+			selectors[rule.Endpoint] = append(selectors[rule.Endpoint], nil)
+			continue
+		}
+		for _, s := range rule.Matchers {
+			if matchers := t.selector(s); matchers != nil {
+				selectors[rule.Endpoint] = append(selectors[rule.Endpoint], matchers)
+			}
+		}
+	}
+
+	now := time.Now()
+	matched := map[string][]entry{}
+	for _, ts := range req.Timeseries {
+		var lbls labels.Labels
+		for name, sels := range selectors {
+			if !matchesAny(ts.Labels, sels) {
+				continue
+			}
+			// The labels are copied once for all the endpoints, since they're not modified.
+			if lbls == nil {
+				lbls = cortexpb.FromLabelAdaptersToLabelsWithCopy(ts.Labels)
+			}
+			matched[name] = append(matched[name], entry{
+				userID:   userID,
+				labels:   lbls,
+				samples:  append([]cortexpb.Sample(nil), ts.Samples...),
+				enqueued: now,
+			})
+		}
+	}
+	return matched
+}
+
+// selector returns the parsed series selector, or nil if invalid.
+func (t *Tee) selector(s string) []*labels.Matcher {
+	if cached, ok := t.selectors.Load(s); ok {
+		return cached.([]*labels.Matcher)
+	}
+
+	matchers, err := parser.ParseMetricSelector(s)
+	if err != nil {
+		level.Warn(t.logger).Log("msg", "ignoring the invalid tee series selector", "selector", s, "err", err)
+		matchers = nil
+	}
+	t.selectors.Store(s, matchers)
+	return matchers
+}
+
+func matchesAny(lbls []cortexpb.LabelAdapter, selectors [][]*labels.Matcher) bool {
+	for _, matchers := range selectors {
+		if matchesAll(lbls, matchers) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAll(lbls []cortexpb.LabelAdapter, matchers []*labels.Matcher) bool {
+	for _, m := range matchers {
+		value := ""
+		for _, l := range lbls {
+			if l.Name == m.Name {
+				value = l.Value
+				break
+			}
+		}
+		if !m.Matches(value) {
+			return false
+		}
+	}
+	return true
+}
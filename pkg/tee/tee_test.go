@@ -0,0 +1,263 @@
+package tee
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/overrides"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *Config)
+		expected error
+	}{
+		"default config": {
+			setup:    func(*Config) {},
+			expected: nil,
+		},
+		"endpoints": {
+			setup: func(cfg *Config) {
+				cfg.Endpoints = []EndpointConfig{{Name: "analytics", URL: "http://analytics/api/v1/push"}, {Name: "debug", URL: "http://debug/api/v1/push", QueueSize: 10}}
+			},
+			expected: nil,
+		},
+		"missing name": {
+			setup:    func(cfg *Config) { cfg.Endpoints = []EndpointConfig{{URL: "http://analytics/api/v1/push"}} },
+			expected: errMissingName,
+		},
+		"duplicate name": {
+			setup: func(cfg *Config) {
+				cfg.Endpoints = []EndpointConfig{{Name: "analytics", URL: "http://analytics/api/v1/push"}, {Name: "analytics", URL: "http://debug/api/v1/push"}}
+			},
+			expected: errDuplicateName,
+		},
+		"missing URL": {
+			setup:    func(cfg *Config) { cfg.Endpoints = []EndpointConfig{{Name: "analytics"}} },
+			expected: errMissingURL,
+		},
+		"invalid endpoint queue size": {
+			setup: func(cfg *Config) {
+				cfg.Endpoints = []EndpointConfig{{Name: "analytics", URL: "http://analytics/api/v1/push", QueueSize: -1}}
+			},
+			expected: errInvalidQueueSize,
+		},
+		"invalid batch size": {
+			setup:    func(cfg *Config) { cfg.BatchSize = 0 },
+			expected: errInvalidBatchSize,
+		},
+		"invalid max retries": {
+			setup:    func(cfg *Config) { cfg.MaxRetries = -1 },
+			expected: errInvalidMaxRetries,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+			assert.ErrorIs(t, cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+type limitsMock map[string][]overrides.TeeRule
+
+func (m limitsMock) Tee(userID string) []overrides.TeeRule { return m[userID] }
+
+// remoteMock records the series received, by tenant, failing the first requests with the
+// configured status code.
+type remoteMock struct {
+	mtx      sync.Mutex
+	failures int
+	status   int
+	series   map[string][]string
+}
+
+func (m *remoteMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.failures > 0 {
+		m.failures--
+		w.WriteHeader(m.status)
+		return
+	}
+
+	compressed, _ := io.ReadAll(r.Body)
+	buf, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req := cortexpb.WriteRequest{}
+	if err := req.Unmarshal(buf); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if m.series == nil {
+		m.series = map[string][]string{}
+	}
+	userID := r.Header.Get("X-Scope-OrgID")
+	for _, ts := range req.Timeseries {
+		m.series[userID] = append(m.series[userID], cortexpb.FromLabelAdaptersToLabels(ts.Labels).String())
+	}
+}
+
+func (m *remoteMock) received(userID string) []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return append([]string(nil), m.series[userID]...)
+}
+
+func newRequest(series ...[]cortexpb.LabelAdapter) *cortexpb.WriteRequest {
+	req := &cortexpb.WriteRequest{}
+	for _, lbls := range series {
+		req.Timeseries = append(req.Timeseries, cortexpb.PreallocTimeseries{TimeSeries: &cortexpb.TimeSeries{
+			Labels:  lbls,
+			Samples: []cortexpb.Sample{{TimestampMs: 1, Value: 1}},
+		}})
+	}
+	return req
+}
+
+func series(name, job string) []cortexpb.LabelAdapter {
+	return []cortexpb.LabelAdapter{{Name: "__name__", Value: name}, {Name: "job", Value: job}}
+}
+
+func TestTee(t *testing.T) {
+	analytics, debug := &remoteMock{failures: 1, status: http.StatusServiceUnavailable}, &remoteMock{}
+	analyticsSrv, debugSrv := httptest.NewServer(analytics), httptest.NewServer(debug)
+	defer analyticsSrv.Close()
+	defer debugSrv.Close()
+
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Endpoints = []EndpointConfig{{Name: "analytics", URL: analyticsSrv.URL}, {Name: "debug", URL: debugSrv.URL}}
+	require.NoError(t, cfg.Validate())
+
+	limits := limitsMock{
+		"user-1": {
+			{Endpoint: "analytics", Matchers: []string{`{job="api"}`, `{__name__=~"http_.*"}`}},
+			{Endpoint: "analytics", Matchers: []string{`{job="api"}`}},
+			{Endpoint: "debug"},
+			{Endpoint: "unknown"},
+		},
+	}
+	tee := NewTee(cfg, limits, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	tee.endpoints["analytics"].backoff.MinBackoff = time.Millisecond
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), tee))
+	defer func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), tee))
+	}()
+
+	next := func(_ context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		// The push path reuses the request series once done.
+		for _, ts := range req.Timeseries {
+			ts.Labels[0].Value = "reused"
+		}
+		return &cortexpb.WriteResponse{}, nil
+	}
+	push := tee.PushMiddleware()(next)
+
+	ctx := user.InjectOrgID(context.Background(), "user-1")
+	_, err := push(ctx, newRequest(series("up", "api"), series("http_requests_total", "web"), series("up", "web")))
+	require.NoError(t, err)
+
+	// The series of the tenants without rules aren't forwarded.
+	_, err = push(user.InjectOrgID(context.Background(), "user-2"), newRequest(series("up", "api")))
+	require.NoError(t, err)
+
+	// The series of the failed pushes aren't forwarded.
+	_, err = tee.PushMiddleware()(func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		return nil, errors.New("failed")
+	})(ctx, newRequest(series("failed", "api")))
+	require.Error(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(analytics.received("user-1")) == 2 && len(debug.received("user-1")) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The series matching several rules of an endpoint are forwarded once, the failed
+	// requests being retried.
+	assert.Equal(t, []string{`{__name__="up", job="api"}`, `{__name__="http_requests_total", job="web"}`}, analytics.received("user-1"))
+	assert.Equal(t, []string{`{__name__="up", job="api"}`, `{__name__="http_requests_total", job="web"}`, `{__name__="up", job="web"}`}, debug.received("user-1"))
+	assert.Empty(t, analytics.received("user-2"))
+
+	assert.Equal(t, float64(3), testutil.ToFloat64(tee.droppedSeries.WithLabelValues("unknown", reasonUnknownEndpoint)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(tee.endpoints["analytics"].failedRequests))
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(tee.endpoints["analytics"].sentSeries) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestTee_DropsTheSeriesWhenTheQueueIsFull(t *testing.T) {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Endpoints = []EndpointConfig{{Name: "analytics", URL: "http://analytics/api/v1/push", QueueSize: 2}}
+
+	tee := NewTee(cfg, limitsMock{"user-1": {{Endpoint: "analytics"}}}, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	push := tee.PushMiddleware()(func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		return &cortexpb.WriteResponse{}, nil
+	})
+
+	_, err := push(user.InjectOrgID(context.Background(), "user-1"), newRequest(series("a", "api"), series("b", "api"), series("c", "api")))
+	require.NoError(t, err)
+
+	assert.Len(t, tee.endpoints["analytics"].queue, 2)
+	assert.Equal(t, float64(1), testutil.ToFloat64(tee.droppedSeries.WithLabelValues("analytics", reasonQueueFull)))
+}
+
+func TestEndpoint_DropsTheSeriesRejectedOrFailedAfterMaxRetries(t *testing.T) {
+	for name, tc := range map[string]struct {
+		status           int
+		expectedRequests float64
+	}{
+		"rejected": {
+			status:           http.StatusBadRequest,
+			expectedRequests: 1,
+		},
+		"failed after max retries": {
+			status:           http.StatusInternalServerError,
+			expectedRequests: 3,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			remote := &remoteMock{failures: 10, status: tc.status}
+			srv := httptest.NewServer(remote)
+			defer srv.Close()
+
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			cfg.Endpoints = []EndpointConfig{{Name: "analytics", URL: srv.URL, MaxRetries: 2}}
+
+			tee := NewTee(cfg, nil, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+			e := tee.endpoints["analytics"]
+			e.backoff.MinBackoff, e.backoff.MaxBackoff = time.Millisecond, time.Millisecond
+
+			e.sendBatch(context.Background(), []entry{{userID: "user-1", labels: cortexpb.FromLabelAdaptersToLabels(series("up", "api"))}})
+			assert.Equal(t, tc.expectedRequests, testutil.ToFloat64(e.failedRequests))
+			assert.Equal(t, float64(1), testutil.ToFloat64(tee.droppedSeries.WithLabelValues("analytics", reasonSendFailed)))
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package tenant
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/weaveworks/common/middleware"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// HTTPMiddleware returns a middleware resolving the tenant of each request and injecting
+// it into the request context. When auth is enabled, the tenant is read from the
+// X-Scope-OrgID header and validated; otherwise noAuthTenant is used for all requests.
+// Multiple pipe-separated tenants are accepted here, and rejected by the request paths
+// not supporting them.
+func HTTPMiddleware(authEnabled bool, noAuthTenant string) middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !authEnabled {
+				next.ServeHTTP(w, r.WithContext(user.InjectOrgID(r.Context(), noAuthTenant)))
+				return
+			}
+
+			tenantID, ctx, err := user.ExtractOrgIDFromHTTPRequest(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			if _, err := ParseTenantIDs(tenantID); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+}
+
+// UnaryServerInterceptor returns a gRPC interceptor resolving the tenant of each request.
+// Methods listed in skipMethods are not authenticated.
+func UnaryServerInterceptor(authEnabled bool, noAuthTenant string, skipMethods ...string) grpc.UnaryServerInterceptor {
+	skip := toSet(skipMethods)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if _, ok := skip[info.FullMethod]; ok {
+			return handler(ctx, req)
+		}
+
+		ctx, err := resolveGRPC(ctx, authEnabled, noAuthTenant)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a gRPC stream interceptor resolving the tenant of each
+// stream. Methods listed in skipMethods are not authenticated.
+func StreamServerInterceptor(authEnabled bool, noAuthTenant string, skipMethods ...string) grpc.StreamServerInterceptor {
+	skip := toSet(skipMethods)
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if _, ok := skip[info.FullMethod]; ok {
+			return handler(srv, ss)
+		}
+
+		ctx, err := resolveGRPC(ss.Context(), authEnabled, noAuthTenant)
+		if err != nil {
+			return err
+		}
+		return handler(srv, serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func resolveGRPC(ctx context.Context, authEnabled bool, noAuthTenant string) (context.Context, error) {
+	if !authEnabled {
+		return user.InjectOrgID(ctx, noAuthTenant), nil
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get(lowerOrgIDHeaderName)) != 1 {
+		return nil, status.Error(codes.Unauthenticated, user.ErrNoOrgID.Error())
+	}
+
+	tenantID := md.Get(lowerOrgIDHeaderName)[0]
+	if _, err := ParseTenantIDs(tenantID); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return user.InjectOrgID(ctx, tenantID), nil
+}
+
+// lowerOrgIDHeaderName is the gRPC metadata key used to propagate the tenant ID,
+// as set by user.InjectOrgIDIntoGRPCRequest.
+const lowerOrgIDHeaderName = "x-scope-orgid"
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func toSet(values []string) map[string]struct{} {
+	out := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		out[v] = struct{}{}
+	}
+	return out
+}
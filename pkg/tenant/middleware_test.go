@@ -0,0 +1,87 @@
+package tenant
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	for name, tc := range map[string]struct {
+		authEnabled    bool
+		orgID          string
+		expectedStatus int
+		expectedTenant string
+	}{
+		"auth disabled should use the no-auth tenant": {
+			authEnabled:    false,
+			orgID:          "ignored",
+			expectedStatus: http.StatusOK,
+			expectedTenant: DefaultNoAuthTenant,
+		},
+		"auth enabled without header": {
+			authEnabled:    true,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"auth enabled with invalid tenant": {
+			authEnabled:    true,
+			orgID:          "../other",
+			expectedStatus: http.StatusBadRequest,
+		},
+		"auth enabled with valid tenant": {
+			authEnabled:    true,
+			orgID:          "tenant-a",
+			expectedStatus: http.StatusOK,
+			expectedTenant: "tenant-a",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var actualTenant string
+			handler := HTTPMiddleware(tc.authEnabled, DefaultNoAuthTenant).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				actualTenant, _ = user.ExtractOrgID(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/push", nil)
+			if tc.orgID != "" {
+				req.Header.Set(user.OrgIDHeaderName, tc.orgID)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			assert.Equal(t, tc.expectedTenant, actualTenant)
+		})
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	var actualTenant string
+	handler := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		actualTenant, _ = user.ExtractOrgID(ctx)
+		return nil, nil
+	}
+
+	interceptor := UnaryServerInterceptor(true, DefaultNoAuthTenant, "/grpc.health.v1.Health/Check")
+
+	// Skipped method.
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}, handler)
+	require.NoError(t, err)
+	assert.Empty(t, actualTenant)
+
+	// Missing tenant.
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/cortex.Ingester/Push"}, handler)
+	require.Error(t, err)
+
+	// Valid tenant.
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(lowerOrgIDHeaderName, "tenant-a"))
+	_, err = interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/cortex.Ingester/Push"}, handler)
+	require.NoError(t, err)
+	assert.Equal(t, "tenant-a", actualTenant)
+}
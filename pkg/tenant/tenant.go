@@ -0,0 +1,142 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/weaveworks/common/user"
+)
+
+const (
+	// MaxTenantIDLength is the max length of a single tenant ID.
+	MaxTenantIDLength = 150
+
+	// DefaultNoAuthTenant is the tenant used for all requests when auth is disabled.
+	DefaultNoAuthTenant = "fake"
+
+	// tenantIDsSeparator separates multiple tenant IDs in the X-Scope-OrgID header.
+	tenantIDsSeparator = "|"
+)
+
+var errTenantIDTooLong = fmt.Errorf("tenant ID is too long: max %d characters", MaxTenantIDLength)
+
+type errTenantIDUnsupportedCharacter struct {
+	pos      int
+	tenantID string
+}
+
+func (e *errTenantIDUnsupportedCharacter) Error() string {
+	return fmt.Sprintf(
+		"tenant ID '%s' contains unsupported character '%c'",
+		e.tenantID,
+		e.tenantID[e.pos],
+	)
+}
+
+// ValidTenantID returns an error if the input tenant ID is invalid. A valid tenant ID
+// only contains alphanumeric characters and the special characters !-_.*'(), is not
+// longer than MaxTenantIDLength and is not "." or "..", so that it's safe to use as
+// a path segment both locally and in the object storage.
+func ValidTenantID(s string) error {
+	if s == "" {
+		return user.ErrNoOrgID
+	}
+
+	for i, r := range s {
+		if !isSupported(r) {
+			return &errTenantIDUnsupportedCharacter{
+				tenantID: s,
+				pos:      i,
+			}
+		}
+	}
+
+	if len(s) > MaxTenantIDLength {
+		return errTenantIDTooLong
+	}
+
+	if s == "." || s == ".." {
+		return fmt.Errorf("tenant ID '%s' is not allowed", s)
+	}
+
+	return nil
+}
+
+// TenantID returns the validated tenant ID injected into the input context.
+func TenantID(ctx context.Context) (string, error) {
+	tenantID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if err := ValidTenantID(tenantID); err != nil {
+		return "", err
+	}
+
+	return tenantID, nil
+}
+
+// TenantIDs returns the validated, sorted and deduplicated list of tenant IDs injected into
+// the input context. Multiple tenant IDs are separated by a pipe in the X-Scope-OrgID header.
+func TenantIDs(ctx context.Context) ([]string, error) {
+	orgID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseTenantIDs(orgID)
+}
+
+// ParseTenantIDs parses and validates the pipe-separated list of tenant IDs. The returned
+// list is sorted and deduplicated.
+func ParseTenantIDs(orgID string) ([]string, error) {
+	tenantIDs := strings.Split(orgID, tenantIDsSeparator)
+	for _, tenantID := range tenantIDs {
+		if err := ValidTenantID(tenantID); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(tenantIDs)
+
+	// Remove duplicates.
+	out := tenantIDs[:1]
+	for _, tenantID := range tenantIDs[1:] {
+		if tenantID != out[len(out)-1] {
+			out = append(out, tenantID)
+		}
+	}
+
+	return out, nil
+}
+
+// JoinTenantIDs returns the X-Scope-OrgID value for the input list of tenant IDs.
+func JoinTenantIDs(tenantIDs []string) string {
+	return strings.Join(tenantIDs, tenantIDsSeparator)
+}
+
+// this checks if a rune is supported in tenant IDs (according to
+// https://cortexmetrics.io/docs/guides/limitations/#tenant-id-naming)
+func isSupported(c rune) bool {
+	// characters
+	if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') {
+		return true
+	}
+
+	// digits
+	if '0' <= c && c <= '9' {
+		return true
+	}
+
+	// special
+	return c == '!' ||
+		c == '-' ||
+		c == '_' ||
+		c == '.' ||
+		c == '*' ||
+		c == '\'' ||
+		c == '(' ||
+		c == ')'
+}
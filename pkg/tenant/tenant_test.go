@@ -0,0 +1,59 @@
+package tenant
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+)
+
+func TestValidTenantID(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  string
+	}{
+		{name: "tenant-a"},
+		{name: "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789!-_.*'()"},
+		{name: "", err: "no org id"},
+		{name: "tenant/a", err: "tenant ID 'tenant/a' contains unsupported character '/'"},
+		{name: "tenant|a", err: "tenant ID 'tenant|a' contains unsupported character '|'"},
+		{name: strings.Repeat("a", MaxTenantIDLength+1), err: errTenantIDTooLong.Error()},
+		{name: ".", err: "tenant ID '.' is not allowed"},
+		{name: "..", err: "tenant ID '..' is not allowed"},
+	} {
+		err := ValidTenantID(tc.name)
+		if tc.err == "" {
+			assert.NoError(t, err, tc.name)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.name)
+		}
+	}
+}
+
+func TestTenantID(t *testing.T) {
+	_, err := TenantID(context.Background())
+	assert.Equal(t, user.ErrNoOrgID, err)
+
+	_, err = TenantID(user.InjectOrgID(context.Background(), "tenant/a"))
+	assert.Error(t, err)
+
+	actual, err := TenantID(user.InjectOrgID(context.Background(), "tenant-a"))
+	require.NoError(t, err)
+	assert.Equal(t, "tenant-a", actual)
+}
+
+func TestParseTenantIDs(t *testing.T) {
+	actual, err := ParseTenantIDs("tenant-b|tenant-a|tenant-b")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tenant-a", "tenant-b"}, actual)
+	assert.Equal(t, "tenant-a|tenant-b", JoinTenantIDs(actual))
+
+	_, err = ParseTenantIDs("tenant-a||tenant-b")
+	assert.Error(t, err)
+
+	_, err = ParseTenantIDs("tenant-a|../tenant-b")
+	assert.Error(t, err)
+}
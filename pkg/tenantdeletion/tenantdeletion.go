@@ -0,0 +1,401 @@
+package tenantdeletion
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/events"
+	"objectstorage/pkg/push"
+	"objectstorage/pkg/storage/bucket"
+	bucket_tsdb "objectstorage/pkg/storage/tsdb"
+	"objectstorage/pkg/tenant"
+)
+
+// Paths of the tenant deletion API.
+const (
+	DeletePath = "/api/v1/admin/tenant/delete"
+	StatusPath = "/api/v1/admin/tenant/delete-status"
+)
+
+var errTenantDeleted = errors.New("the tenant has been deleted and doesn't accept writes anymore")
+
+// Config holds the configuration of the tenant deletion.
+type Config struct {
+	CleanupInterval time.Duration `yaml:"cleanup_interval"`
+}
+
+// RegisterFlags registers the tenant deletion flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.DurationVar(&cfg.CleanupInterval, "tenant-deletion.cleanup-interval", 15*time.Minute, "How frequently the objects of the tenants marked for deletion are deleted from the bucket, and the list of deleted tenants refreshed.")
+}
+
+// Status is the tenant deletion status returned by the HTTP API.
+type Status struct {
+	MarkedForDeletion bool      `json:"marked_for_deletion"`
+	DeletionTime      time.Time `json:"deletion_time,omitempty"`
+	FinishedTime      time.Time `json:"finished_time,omitempty"`
+	RemainingObjects  int       `json:"remaining_objects"`
+	Finished          bool      `json:"finished"`
+}
+
+// LeaderRunner runs a job only on the elected leader. It's implemented by leaderelection.Elector.
+type LeaderRunner interface {
+	services.Service
+	RunIfLeader(f func(ctx context.Context) error) func(ctx context.Context) error
+}
+
+// Publisher publishes the ingester events. It's implemented by events.Bus.
+type Publisher interface {
+	Publish(ev events.Event)
+}
+
+// Deleter deletes tenants: once a tenant is marked for deletion, its writes are rejected, its
+// local TSDB is removed and its objects are deleted from the bucket in background. The bucket
+// cleanup runs only on the leader, while each instance removes its own local state.
+type Deleter struct {
+	services.Service
+
+	cfg       Config
+	bkt       objstore.Bucket
+	tsdbDir   string
+	leader    LeaderRunner
+	publisher Publisher
+	logger    log.Logger
+
+	mtx     sync.RWMutex
+	deleted map[string]struct{}
+
+	tenantsMarked   prometheus.Gauge
+	deletedObjects  prometheus.Counter
+	cleanupRuns     *prometheus.CounterVec
+	rejectedPushes  prometheus.Counter
+	tenantsFinished prometheus.Counter
+}
+
+// NewDeleter makes a new Deleter. The leader may be nil, in which case the bucket cleanup
+// runs on every instance. The publisher may be nil.
+func NewDeleter(cfg Config, bkt objstore.Bucket, tsdbDir string, leader LeaderRunner, publisher Publisher, logger log.Logger, reg prometheus.Registerer) *Deleter {
+	d := &Deleter{
+		cfg:       cfg,
+		bkt:       bkt,
+		tsdbDir:   tsdbDir,
+		leader:    leader,
+		publisher: publisher,
+		logger:    logger,
+		deleted:   map[string]struct{}{},
+		tenantsMarked: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_tenant_deletion_marked_tenants",
+			Help: "Number of tenants marked for deletion.",
+		}),
+		deletedObjects: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_tenant_deletion_deleted_objects_total",
+			Help: "Total number of objects deleted from the bucket for deleted tenants.",
+		}),
+		cleanupRuns: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_tenant_deletion_cleanup_runs_total",
+			Help: "Total number of deleted tenants cleanup runs, by outcome.",
+		}, []string{"outcome"}),
+		rejectedPushes: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_tenant_deletion_rejected_pushes_total",
+			Help: "Total number of push requests rejected because the tenant has been deleted.",
+		}),
+		tenantsFinished: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_tenant_deletion_finished_tenants_total",
+			Help: "Total number of tenants whose objects have been fully deleted from the bucket.",
+		}),
+	}
+
+	d.Service = services.NewTimerService(cfg.CleanupInterval, d.starting, d.iteration, d.stopping)
+	return d
+}
+
+func (d *Deleter) starting(ctx context.Context) error {
+	if d.leader != nil {
+		if err := services.StartAndAwaitRunning(ctx, d.leader); err != nil {
+			return errors.Wrap(err, "start tenant deletion leader election")
+		}
+	}
+
+	// Load the deleted tenants before accepting writes.
+	return d.refresh(ctx)
+}
+
+func (d *Deleter) stopping(_ error) error {
+	if d.leader != nil {
+		return services.StopAndAwaitTerminated(context.Background(), d.leader)
+	}
+	return nil
+}
+
+func (d *Deleter) iteration(ctx context.Context) error {
+	if err := d.refresh(ctx); err != nil {
+		level.Warn(d.logger).Log("msg", "failed to refresh the list of deleted tenants", "err", err)
+		return nil
+	}
+
+	cleanup := d.cleanup
+	if d.leader != nil {
+		cleanup = d.leader.RunIfLeader(d.cleanup)
+	}
+
+	if err := cleanup(ctx); err != nil {
+		d.cleanupRuns.WithLabelValues("failed").Inc()
+		level.Warn(d.logger).Log("msg", "failed to clean up deleted tenants", "err", err)
+		return nil
+	}
+
+	d.cleanupRuns.WithLabelValues("success").Inc()
+	return nil
+}
+
+// refresh reloads the tenants marked for deletion from the bucket, and removes their local state.
+func (d *Deleter) refresh(ctx context.Context) error {
+	_, markedForDeletion, err := bucket_tsdb.NewUsersScanner(d.bkt, bucket_tsdb.AllUsers, d.logger).ScanUsers(ctx)
+	if err != nil {
+		return errors.Wrap(err, "scan users")
+	}
+
+	deleted := make(map[string]struct{}, len(markedForDeletion))
+	for _, userID := range markedForDeletion {
+		deleted[userID] = struct{}{}
+		d.deleteLocalState(userID)
+	}
+
+	d.mtx.Lock()
+	d.deleted = deleted
+	d.mtx.Unlock()
+
+	d.tenantsMarked.Set(float64(len(deleted)))
+	return nil
+}
+
+// cleanup deletes the objects of the tenants marked for deletion, and records the deletion as
+// finished once no object but the markers is left.
+func (d *Deleter) cleanup(ctx context.Context) error {
+	for _, userID := range d.deletedTenants() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err := d.cleanupTenant(ctx, userID); err != nil {
+			return errors.Wrapf(err, "clean up tenant %s", userID)
+		}
+	}
+	return nil
+}
+
+func (d *Deleter) cleanupTenant(ctx context.Context, userID string) error {
+	mark, err := bucket_tsdb.ReadTenantDeletionMark(ctx, d.bkt, userID)
+	if err != nil || mark == nil || mark.FinishedTime > 0 {
+		return err
+	}
+
+	deleted := 0
+	err = d.bkt.Iter(ctx, userID+objstore.DirDelim, func(name string) error {
+		if isMarkersPath(userID, name) {
+			return nil
+		}
+
+		if !strings.HasSuffix(name, objstore.DirDelim) {
+			if err := d.bkt.Delete(ctx, name); err != nil {
+				return err
+			}
+			deleted++
+			return nil
+		}
+
+		n, err := bucket.DeletePrefix(ctx, d.bkt, name, d.logger)
+		deleted += n
+		return err
+	})
+	d.deletedObjects.Add(float64(deleted))
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		level.Info(d.logger).Log("msg", "deleted objects of tenant marked for deletion", "tenant", userID, "deleted", deleted)
+		// Check again at the next run, in case objects have been uploaded meanwhile.
+		return nil
+	}
+
+	mark.FinishedTime = time.Now().Unix()
+	if err := bucket_tsdb.WriteTenantDeletionMark(ctx, d.bkt, userID, nil, mark); err != nil {
+		return err
+	}
+
+	d.tenantsFinished.Inc()
+	level.Info(d.logger).Log("msg", "tenant deletion finished", "tenant", userID)
+	return nil
+}
+
+func isMarkersPath(userID, name string) bool {
+	return strings.HasPrefix(name, path.Join(userID, path.Dir(bucket_tsdb.TenantDeletionMarkPath))+objstore.DirDelim)
+}
+
+// deleteLocalState removes the local TSDB of the input tenant, if any.
+func (d *Deleter) deleteLocalState(userID string) {
+	if d.tsdbDir == "" || tenant.ValidTenantID(userID) != nil {
+		return
+	}
+
+	dir := filepath.Join(d.tsdbDir, userID)
+	if _, err := os.Stat(dir); err != nil {
+		return
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		level.Warn(d.logger).Log("msg", "failed to delete local TSDB of deleted tenant", "tenant", userID, "err", err)
+		return
+	}
+	level.Info(d.logger).Log("msg", "deleted local TSDB of deleted tenant", "tenant", userID)
+}
+
+// DeleteTenant marks the tenant for deletion. Writes are rejected from now on, while its
+// data is deleted in background.
+func (d *Deleter) DeleteTenant(ctx context.Context, userID string) error {
+	mark, err := bucket_tsdb.ReadTenantDeletionMark(ctx, d.bkt, userID)
+	if err != nil {
+		return err
+	}
+
+	// Deleting a tenant twice is a no-op, to not reset the deletion time.
+	if mark == nil {
+		if err := bucket_tsdb.WriteTenantDeletionMark(ctx, d.bkt, userID, nil, bucket_tsdb.NewTenantDeletionMark(time.Now())); err != nil {
+			return err
+		}
+		if d.publisher != nil {
+			d.publisher.Publish(events.Event{Type: events.TypeTenantDeleted, Tenant: userID})
+		}
+	}
+
+	d.mtx.Lock()
+	d.deleted[userID] = struct{}{}
+	d.tenantsMarked.Set(float64(len(d.deleted)))
+	d.mtx.Unlock()
+
+	d.deleteLocalState(userID)
+	level.Info(d.logger).Log("msg", "tenant marked for deletion", "tenant", userID)
+	return nil
+}
+
+// Status returns the deletion status of the tenant.
+func (d *Deleter) Status(ctx context.Context, userID string) (Status, error) {
+	mark, err := bucket_tsdb.ReadTenantDeletionMark(ctx, d.bkt, userID)
+	if err != nil || mark == nil {
+		return Status{}, err
+	}
+
+	status := Status{
+		MarkedForDeletion: true,
+		DeletionTime:      time.Unix(mark.DeletionTime, 0).UTC(),
+		Finished:          mark.FinishedTime > 0,
+	}
+	if status.Finished {
+		status.FinishedTime = time.Unix(mark.FinishedTime, 0).UTC()
+	}
+
+	err = d.bkt.Iter(ctx, userID+objstore.DirDelim, func(name string) error {
+		if !isMarkersPath(userID, name) {
+			status.RemainingObjects++
+		}
+		return nil
+	}, objstore.WithRecursiveIter)
+
+	return status, err
+}
+
+// IsDeleted returns whether the tenant has been marked for deletion.
+func (d *Deleter) IsDeleted(userID string) bool {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	_, ok := d.deleted[userID]
+	return ok
+}
+
+func (d *Deleter) deletedTenants() []string {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	out := make([]string, 0, len(d.deleted))
+	for userID := range d.deleted {
+		out = append(out, userID)
+	}
+	return out
+}
+
+// PushMiddleware returns a push.Middleware rejecting the writes of deleted tenants.
+func (d *Deleter) PushMiddleware() push.Middleware {
+	return func(next push.Func) push.Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			userID, err := tenant.TenantID(ctx)
+			if err != nil {
+				return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+			}
+
+			if d.IsDeleted(userID) {
+				d.rejectedPushes.Inc()
+				return nil, httpgrpc.Errorf(http.StatusForbidden, errTenantDeleted.Error())
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+// DeleteHandler marks the tenant of the request for deletion.
+func (d *Deleter) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := tenant.TenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := d.DeleteTenant(r.Context(), userID); err != nil {
+		level.Error(d.logger).Log("msg", "failed to delete tenant", "tenant", userID, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// StatusHandler returns the deletion status of the tenant of the request.
+func (d *Deleter) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := tenant.TenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status, err := d.Status(r.Context(), userID)
+	if err != nil {
+		level.Error(d.logger).Log("msg", "failed to read tenant deletion status", "tenant", userID, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		level.Error(d.logger).Log("msg", "failed to encode tenant deletion status", "err", err)
+	}
+}
@@ -0,0 +1,120 @@
+package tenantdeletion
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+
+	"objectstorage/pkg/events"
+	bucket_tsdb "objectstorage/pkg/storage/tsdb"
+)
+
+type publisherMock struct {
+	events []events.Event
+}
+
+func (m *publisherMock) Publish(ev events.Event) {
+	m.events = append(m.events, ev)
+}
+
+func TestDeleter_ShouldBlockWritesAndCleanupTenant(t *testing.T) {
+	ctx := context.Background()
+	tsdbDir := t.TempDir()
+	bkt := objstore.NewInMemBucket()
+
+	require.NoError(t, bkt.Upload(ctx, "user-1/01EQK4QKFHVSZYVJ908Y7HH9E0/meta.json", bytes.NewReader([]byte("{}"))))
+	require.NoError(t, bkt.Upload(ctx, "user-1/01EQK4QKFHVSZYVJ908Y7HH9E0/chunks/000001", bytes.NewReader([]byte("data"))))
+	require.NoError(t, bkt.Upload(ctx, "user-1/bucket-index.json.gz", bytes.NewReader([]byte("data"))))
+	require.NoError(t, bkt.Upload(ctx, "user-2/bucket-index.json.gz", bytes.NewReader([]byte("data"))))
+	require.NoError(t, os.MkdirAll(filepath.Join(tsdbDir, "user-1", "wal"), os.ModePerm))
+
+	publisher := &publisherMock{}
+	d := NewDeleter(Config{CleanupInterval: time.Minute}, bkt, tsdbDir, nil, publisher, log.NewNopLogger(), nil)
+	require.NoError(t, d.refresh(ctx))
+
+	pushed := 0
+	f := d.PushMiddleware()(func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		pushed++
+		return &cortexpb.WriteResponse{}, nil
+	})
+
+	_, err := f(user.InjectOrgID(ctx, "user-1"), &cortexpb.WriteRequest{})
+	require.NoError(t, err)
+
+	// Delete the tenant via the API.
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, DeletePath, nil)
+	d.DeleteHandler(rec, req.WithContext(user.InjectOrgID(req.Context(), "user-1")))
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	assert.NoDirExists(t, filepath.Join(tsdbDir, "user-1"))
+	assert.Equal(t, []events.Event{{Type: events.TypeTenantDeleted, Tenant: "user-1"}}, publisher.events)
+
+	_, err = f(user.InjectOrgID(ctx, "user-1"), &cortexpb.WriteRequest{})
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	require.True(t, ok)
+	assert.Equal(t, int32(http.StatusForbidden), resp.Code)
+
+	_, err = f(user.InjectOrgID(ctx, "user-2"), &cortexpb.WriteRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, pushed)
+
+	status := readStatus(t, d, "user-1")
+	assert.True(t, status.MarkedForDeletion)
+	assert.False(t, status.Finished)
+	assert.Equal(t, 3, status.RemainingObjects)
+
+	// The first cleanup deletes the objects, the second one records the deletion as finished.
+	require.NoError(t, d.iteration(ctx))
+	status = readStatus(t, d, "user-1")
+	assert.False(t, status.Finished)
+	assert.Equal(t, 0, status.RemainingObjects)
+
+	require.NoError(t, d.iteration(ctx))
+	status = readStatus(t, d, "user-1")
+	assert.True(t, status.Finished)
+
+	exists, err := bucket_tsdb.TenantDeletionMarkExists(ctx, bkt, "user-1")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = bkt.Exists(ctx, "user-2/bucket-index.json.gz")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// Deleted tenants are reloaded from the bucket.
+	other := NewDeleter(Config{CleanupInterval: time.Minute}, bkt, tsdbDir, nil, nil, log.NewNopLogger(), nil)
+	require.NoError(t, other.refresh(ctx))
+	assert.True(t, other.IsDeleted("user-1"))
+	assert.False(t, other.IsDeleted("user-2"))
+}
+
+func TestDeleter_StatusOfNotDeletedTenant(t *testing.T) {
+	d := NewDeleter(Config{CleanupInterval: time.Minute}, objstore.NewInMemBucket(), t.TempDir(), nil, nil, log.NewNopLogger(), nil)
+	assert.False(t, readStatus(t, d, "user-1").MarkedForDeletion)
+}
+
+func readStatus(t *testing.T, d *Deleter, userID string) Status {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, StatusPath, nil)
+	d.StatusHandler(rec, req.WithContext(user.InjectOrgID(req.Context(), userID)))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	status := Status{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	return status
+}
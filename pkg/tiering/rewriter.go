@@ -0,0 +1,115 @@
+package tiering
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	gcs_storage "cloud.google.com/go/storage"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"google.golang.org/api/option"
+
+	cortex_bucket "github.com/cortexproject/cortex/pkg/storage/bucket"
+	"github.com/cortexproject/cortex/pkg/storage/bucket/gcs"
+	"github.com/cortexproject/cortex/pkg/storage/bucket/s3"
+)
+
+// Rewriter rewrites an object of the bucket in place with another storage class. The object
+// name is relative to the bucket root.
+type Rewriter interface {
+	Rewrite(ctx context.Context, name, storageClass string) error
+}
+
+// NewRewriter makes the Rewriter of the bucket, using the storage provider API since the
+// storage class isn't exposed by the bucket client. Only the s3 and gcs backends are
+// supported.
+func NewRewriter(ctx context.Context, cfg cortex_bucket.Config) (Rewriter, error) {
+	switch cfg.Backend {
+	case cortex_bucket.S3:
+		return newS3Rewriter(cfg.S3)
+	case cortex_bucket.GCS:
+		return newGCSRewriter(ctx, cfg.GCS)
+	default:
+		return nil, fmt.Errorf("the tiering rewrite mode doesn't support the %s backend", cfg.Backend)
+	}
+}
+
+// s3Rewriter copies the object onto itself with the storage class.
+type s3Rewriter struct {
+	client     *minio.Client
+	bucketName string
+}
+
+func newS3Rewriter(cfg s3.Config) (*s3Rewriter, error) {
+	creds := credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey.Value, "")
+	if cfg.SignatureVersion == s3.SignatureVersionV2 {
+		creds = credentials.NewStaticV2(cfg.AccessKeyID, cfg.SecretAccessKey.Value, "")
+	}
+	if cfg.AccessKeyID == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+
+	lookup := minio.BucketLookupAuto
+	switch cfg.BucketLookupType {
+	case s3.BucketVirtualHostLookup:
+		lookup = minio.BucketLookupDNS
+	case s3.BucketPathLookup:
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       !cfg.Insecure,
+		Region:       cfg.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3Rewriter{client: client, bucketName: cfg.BucketName}, nil
+}
+
+func (r *s3Rewriter) Rewrite(ctx context.Context, name, storageClass string) error {
+	_, err := r.client.CopyObject(ctx, minio.CopyDestOptions{
+		Bucket: r.bucketName,
+		Object: name,
+		// The storage class is set as a header of the copy request.
+		UserMetadata:    map[string]string{"X-Amz-Storage-Class": storageClass},
+		ReplaceMetadata: true,
+	}, minio.CopySrcOptions{
+		Bucket: r.bucketName,
+		Object: name,
+	})
+	return err
+}
+
+// gcsRewriter rewrites the object onto itself with the storage class.
+type gcsRewriter struct {
+	bucket *gcs_storage.BucketHandle
+}
+
+func newGCSRewriter(ctx context.Context, cfg gcs.Config) (*gcsRewriter, error) {
+	var opts []option.ClientOption
+	if cfg.ServiceAccount.Value != "" {
+		opts = append(opts, option.WithCredentialsJSON([]byte(cfg.ServiceAccount.Value)))
+	}
+
+	client, err := gcs_storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsRewriter{bucket: client.Bucket(cfg.BucketName)}, nil
+}
+
+func (r *gcsRewriter) Rewrite(ctx context.Context, name, storageClass string) error {
+	obj := r.bucket.Object(name)
+	copier := obj.CopierFrom(obj)
+	copier.StorageClass = storageClass
+	_, err := copier.Run(ctx)
+	return err
+}
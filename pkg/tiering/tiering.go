@@ -0,0 +1,298 @@
+// Package tiering transitions the old blocks to a colder storage class, cheaper to store but
+// slower and more expensive to retrieve. The transitioned blocks are marked, so that the
+// bucket index tells the readers which blocks have a higher retrieval latency.
+package tiering
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+
+	"github.com/cortexproject/cortex/pkg/util/concurrency"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/storage/bucket"
+	bucket_tsdb "objectstorage/pkg/storage/tsdb"
+	"objectstorage/pkg/storage/tsdb/bucketindex"
+)
+
+// Modes of the transition of the blocks.
+const (
+	// ModeRewrite rewrites the block objects with the colder storage class.
+	ModeRewrite = "rewrite"
+	// ModeMarker only marks the blocks, the transition being made by the bucket lifecycle
+	// policy, which must transition the objects after the same threshold.
+	ModeMarker = "marker"
+)
+
+var (
+	supportedModes = []string{ModeRewrite, ModeMarker}
+
+	// supportedStorageClasses are the colder storage classes of S3 and GCS whose objects can
+	// still be read without being restored first.
+	supportedStorageClasses = []string{"STANDARD_IA", "ONEZONE_IA", "GLACIER_IR", "NEARLINE", "COLDLINE"}
+
+	errInvalidInterval    = errors.New("the tiering interval must be greater than 0")
+	errInvalidConcurrency = errors.New("the tiering concurrency must be greater than 0")
+	errInvalidMode        = fmt.Errorf("unsupported tiering mode (supported values: %s)", strings.Join(supportedModes, ", "))
+	errInvalidClass       = fmt.Errorf("unsupported tiering storage class (supported values: %s)", strings.Join(supportedStorageClasses, ", "))
+)
+
+// Config holds the configuration of the blocks tiering.
+type Config struct {
+	Enabled      bool          `yaml:"enabled"`
+	Interval     time.Duration `yaml:"interval"`
+	Concurrency  int           `yaml:"concurrency"`
+	Mode         string        `yaml:"mode"`
+	StorageClass string        `yaml:"storage_class"`
+}
+
+// RegisterFlags registers the blocks tiering flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "tiering.enabled", false, "True to transition the blocks older than the per-tenant tiering threshold to a colder storage class. The blocks are transitioned by the leader only, and require the bucket index to be enabled.")
+	f.DurationVar(&cfg.Interval, "tiering.interval", time.Hour, "How frequently the blocks older than the threshold are transitioned.")
+	f.IntVar(&cfg.Concurrency, "tiering.concurrency", 10, "Number of tenants whose blocks are transitioned concurrently.")
+	f.StringVar(&cfg.Mode, "tiering.mode", ModeRewrite, fmt.Sprintf("How the blocks are transitioned. %s rewrites the block objects with the storage class, supported for the s3 and gcs backends. %s only marks the blocks, expecting the bucket lifecycle policy to transition the objects after the same threshold.", ModeRewrite, ModeMarker))
+	f.StringVar(&cfg.StorageClass, "tiering.storage-class", "STANDARD_IA", fmt.Sprintf("Storage class the blocks are transitioned to. Supported values: %s.", strings.Join(supportedStorageClasses, ", ")))
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Interval <= 0 {
+		return errInvalidInterval
+	}
+	if cfg.Concurrency <= 0 {
+		return errInvalidConcurrency
+	}
+	if !contains(supportedModes, cfg.Mode) {
+		return errInvalidMode
+	}
+	if !contains(supportedStorageClasses, cfg.StorageClass) {
+		return errInvalidClass
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Limits are the per-tenant tiering limits. It's implemented by overrides.Overrides.
+type Limits interface {
+	// TieringThreshold returns the age after which the tenant blocks are transitioned, 0 if
+	// they're never transitioned.
+	TieringThreshold(userID string) time.Duration
+}
+
+// LeaderRunner runs a job only on the elected leader. It's implemented by leaderelection.Elector.
+type LeaderRunner interface {
+	services.Service
+	RunIfLeader(f func(ctx context.Context) error) func(ctx context.Context) error
+}
+
+// Tierer periodically transitions the blocks of each tenant whose max time is older than the
+// tenant threshold, and marks them. The blocks are looked up in the bucket index, so the
+// tenants without an index yet are skipped, and the blocks marked for deletion aren't
+// transitioned.
+type Tierer struct {
+	services.Service
+
+	cfg         Config
+	bkt         objstore.Bucket
+	cfgProvider bucket.TenantConfigProvider
+	limits      Limits
+	rewriter    Rewriter
+	leader      LeaderRunner
+	logger      log.Logger
+
+	runs               *prometheus.CounterVec
+	transitionedBlocks prometheus.Counter
+	failedBlocks       prometheus.Counter
+	lastSuccessfulRun  prometheus.Gauge
+}
+
+// NewTierer makes a new Tierer. The rewriter must be nil in the marker mode, the blocks being
+// only marked. The leader may be nil, in which case the blocks are transitioned by every
+// instance.
+func NewTierer(cfg Config, bkt objstore.Bucket, cfgProvider bucket.TenantConfigProvider, limits Limits, rewriter Rewriter, leader LeaderRunner, logger log.Logger, reg prometheus.Registerer) *Tierer {
+	t := &Tierer{
+		cfg:         cfg,
+		bkt:         bkt,
+		cfgProvider: cfgProvider,
+		limits:      limits,
+		rewriter:    rewriter,
+		leader:      leader,
+		logger:      logger,
+		runs: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_tiering_runs_total",
+			Help: "Total number of runs transitioning the old blocks of all the tenants, by outcome.",
+		}, []string{"outcome"}),
+		transitionedBlocks: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_tiering_transitioned_blocks_total",
+			Help: "Total number of blocks transitioned to the colder storage class.",
+		}),
+		failedBlocks: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_tiering_failed_blocks_total",
+			Help: "Total number of blocks failed to be transitioned. They're transitioned again on the next run.",
+		}),
+		lastSuccessfulRun: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_tiering_last_successful_run_timestamp_seconds",
+			Help: "Unix timestamp of the last run having transitioned the old blocks of all the tenants.",
+		}),
+	}
+	t.Service = services.NewTimerService(cfg.Interval, t.starting, t.iteration, t.stopping)
+	return t
+}
+
+func (t *Tierer) starting(ctx context.Context) error {
+	if t.leader != nil {
+		if err := services.StartAndAwaitRunning(ctx, t.leader); err != nil {
+			return errors.Wrap(err, "start tiering leader election")
+		}
+	}
+	return nil
+}
+
+func (t *Tierer) stopping(_ error) error {
+	if t.leader != nil {
+		return services.StopAndAwaitTerminated(context.Background(), t.leader)
+	}
+	return nil
+}
+
+func (t *Tierer) iteration(ctx context.Context) error {
+	tier := t.tierAll
+	if t.leader != nil {
+		tier = t.leader.RunIfLeader(t.tierAll)
+	}
+	if err := tier(ctx); err != nil {
+		t.runs.WithLabelValues("failed").Inc()
+		level.Warn(t.logger).Log("msg", "failed to transition the blocks", "err", err)
+		return nil
+	}
+	t.runs.WithLabelValues("success").Inc()
+	t.lastSuccessfulRun.SetToCurrentTime()
+	return nil
+}
+
+// tierAll transitions the old blocks of all the tenants in the bucket. It fails if the blocks
+// of any tenant failed to be transitioned, the others being transitioned anyway.
+func (t *Tierer) tierAll(ctx context.Context) error {
+	users, _, err := bucket_tsdb.NewUsersScanner(t.bkt, bucket_tsdb.AllUsers, t.logger).ScanUsers(ctx)
+	if err != nil {
+		return errors.Wrap(err, "scan users")
+	}
+
+	return concurrency.ForEachUser(ctx, users, t.cfg.Concurrency, t.tierTenant)
+}
+
+// tierTenant transitions the blocks of the tenant older than its threshold, not marked for
+// deletion and not transitioned yet.
+func (t *Tierer) tierTenant(ctx context.Context, userID string) error {
+	threshold := t.limits.TieringThreshold(userID)
+	if threshold <= 0 {
+		return nil
+	}
+
+	idx, err := bucketindex.ReadIndex(ctx, t.bkt, userID, t.cfgProvider, t.logger)
+	if errors.Is(err, bucketindex.ErrIndexNotFound) {
+		level.Debug(t.logger).Log("msg", "bucket index not found, skipping the tiering of the tenant", "user", userID)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "read bucket index of tenant %s", userID)
+	}
+
+	skipped := make(map[ulid.ULID]struct{}, len(idx.BlockDeletionMarks)+len(idx.BlockTieringMarks))
+	for _, id := range idx.BlockDeletionMarks.GetULIDs() {
+		skipped[id] = struct{}{}
+	}
+	for _, id := range idx.BlockTieringMarks.GetULIDs() {
+		skipped[id] = struct{}{}
+	}
+
+	userBkt := bucket.NewUserBucketClient(userID, t.bkt, t.cfgProvider)
+	maxT := time.Now().Add(-threshold).UnixMilli()
+
+	var lastErr error
+	for _, b := range idx.Blocks {
+		if _, ok := skipped[b.ID]; ok || b.MaxTime > maxT {
+			continue
+		}
+
+		if err := t.tierBlock(ctx, userID, userBkt, b.ID); err != nil {
+			t.failedBlocks.Inc()
+			level.Warn(t.logger).Log("msg", "failed to transition the block", "user", userID, "block", b.ID.String(), "err", err)
+			lastErr = err
+		}
+	}
+	return errors.Wrapf(lastErr, "transition blocks of tenant %s", userID)
+}
+
+// tierBlock rewrites the block objects with the colder storage class, unless in the marker
+// mode, and marks the block. The meta and the marks, read often and tiny, are left in the
+// default storage class.
+func (t *Tierer) tierBlock(ctx context.Context, userID string, userBkt objstore.InstrumentedBucket, id ulid.ULID) error {
+	// The block may have been transitioned after the bucket index was last updated.
+	_, err := bucketindex.ReadTieringMark(ctx, userBkt, id)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, bucketindex.ErrTieringMarkNotFound) {
+		return err
+	}
+
+	if t.rewriter != nil {
+		var names []string
+		err := t.bkt.Iter(ctx, path.Join(userID, id.String()), func(name string) error {
+			switch path.Base(name) {
+			case metadata.MetaFilename, metadata.DeletionMarkFilename, metadata.NoCompactMarkFilename, bucketindex.TieringMarkFilename:
+			default:
+				names = append(names, name)
+			}
+			return nil
+		}, objstore.WithRecursiveIter)
+		if err != nil {
+			return errors.Wrap(err, "list block files")
+		}
+
+		for _, name := range names {
+			if err := t.rewriter.Rewrite(ctx, name, t.cfg.StorageClass); err != nil {
+				return errors.Wrapf(err, "rewrite %s", name)
+			}
+		}
+	}
+
+	mark := bucketindex.TieringMark{
+		ID:           id,
+		Version:      bucketindex.TieringMarkVersion1,
+		StorageClass: t.cfg.StorageClass,
+		TieringTime:  time.Now().Unix(),
+	}
+	if err := bucketindex.WriteTieringMark(ctx, userBkt, mark); err != nil {
+		return errors.Wrap(err, "write tiering mark")
+	}
+
+	t.transitionedBlocks.Inc()
+	return nil
+}
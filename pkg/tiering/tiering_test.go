@@ -0,0 +1,189 @@
+package tiering
+
+import (
+	"context"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	cortex_testutil "github.com/cortexproject/cortex/pkg/storage/tsdb/testutil"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+
+	"objectstorage/pkg/storage/tsdb/bucketindex"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *Config)
+		expected error
+	}{
+		"disabled": {
+			setup:    func(*Config) {},
+			expected: nil,
+		},
+		"enabled": {
+			setup:    func(cfg *Config) { cfg.Enabled = true },
+			expected: nil,
+		},
+		"zero interval": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.Interval = 0
+			},
+			expected: errInvalidInterval,
+		},
+		"zero concurrency": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.Concurrency = 0
+			},
+			expected: errInvalidConcurrency,
+		},
+		"unsupported mode": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.Mode = "unknown"
+			},
+			expected: errInvalidMode,
+		},
+		"unsupported storage class": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.StorageClass = "DEEP_ARCHIVE"
+			},
+			expected: errInvalidClass,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+			assert.Equal(t, tc.expected, cfg.Validate())
+		})
+	}
+}
+
+type limitsMock map[string]time.Duration
+
+func (m limitsMock) TieringThreshold(userID string) time.Duration { return m[userID] }
+
+// rewriterMock records the objects rewritten, failing the ones with the given suffix.
+type rewriterMock struct {
+	mtx       sync.Mutex
+	rewritten []string
+	fail      string
+}
+
+func (m *rewriterMock) Rewrite(_ context.Context, name, storageClass string) error {
+	if m.fail != "" && strings.HasSuffix(name, m.fail) {
+		return errors.New("failed")
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.rewritten = append(m.rewritten, name+":"+storageClass)
+	return nil
+}
+
+func writeIndex(t *testing.T, bkt objstore.Bucket, userID string) {
+	idx, _, _, err := bucketindex.NewUpdater(bkt, userID, nil, log.NewNopLogger()).UpdateIndex(context.Background(), nil)
+	require.NoError(t, err)
+	require.NoError(t, bucketindex.WriteIndex(context.Background(), bkt, userID, nil, idx))
+}
+
+func TestTierer(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+
+	now := time.Now().UnixMilli()
+	oldBlock := cortex_testutil.MockStorageBlock(t, bkt, "user-1", 10, 20)
+	require.NoError(t, bkt.Upload(ctx, path.Join("user-1", oldBlock.ULID.String(), "chunks", "000001"), strings.NewReader("chunks")))
+	deletedBlock := cortex_testutil.MockStorageBlock(t, bkt, "user-1", 20, 30)
+	cortex_testutil.MockStorageDeletionMark(t, bucketindex.BucketWithGlobalMarkers(bkt), "user-1", deletedBlock)
+	cortex_testutil.MockStorageBlock(t, bkt, "user-1", now-10, now)
+	writeIndex(t, bkt, "user-1")
+	// The tiering is disabled for the tenant.
+	cortex_testutil.MockStorageBlock(t, bkt, "user-2", 10, 20)
+	writeIndex(t, bkt, "user-2")
+	// The tenant has no bucket index yet.
+	cortex_testutil.MockStorageBlock(t, bkt, "user-3", 10, 20)
+
+	rewriter := &rewriterMock{}
+	cfg := Config{Enabled: true, Interval: time.Hour, Concurrency: 2, Mode: ModeRewrite, StorageClass: "GLACIER_IR"}
+	limits := limitsMock{"user-1": 24 * time.Hour, "user-3": 24 * time.Hour}
+	tr := NewTierer(cfg, bkt, nil, limits, rewriter, nil, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, tr.iteration(ctx))
+
+	// Only the data objects of the old block are rewritten.
+	assert.ElementsMatch(t, []string{
+		path.Join("user-1", oldBlock.ULID.String(), "index") + ":GLACIER_IR",
+		path.Join("user-1", oldBlock.ULID.String(), "chunks", "000001") + ":GLACIER_IR",
+	}, rewriter.rewritten)
+	assert.Equal(t, float64(1), testutil.ToFloat64(tr.transitionedBlocks))
+	assert.Equal(t, float64(1), testutil.ToFloat64(tr.runs.WithLabelValues("success")))
+
+	// The block is marked, and tracked in the bucket index once updated.
+	writeIndex(t, bkt, "user-1")
+	idx, err := bucketindex.ReadIndex(ctx, bkt, "user-1", nil, log.NewNopLogger())
+	require.NoError(t, err)
+	require.Len(t, idx.BlockTieringMarks, 1)
+	assert.Equal(t, oldBlock.ULID, idx.BlockTieringMarks[0].ID)
+	assert.Equal(t, "GLACIER_IR", idx.BlockTieringMarks[0].StorageClass)
+
+	// The transitioned blocks aren't rewritten again.
+	require.NoError(t, tr.iteration(ctx))
+	assert.Len(t, rewriter.rewritten, 2)
+	assert.Equal(t, float64(1), testutil.ToFloat64(tr.transitionedBlocks))
+}
+
+func TestTierer_MarkerMode(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+
+	block := cortex_testutil.MockStorageBlock(t, bkt, "user-1", 10, 20)
+	writeIndex(t, bkt, "user-1")
+
+	cfg := Config{Enabled: true, Interval: time.Hour, Concurrency: 1, Mode: ModeMarker, StorageClass: "NEARLINE"}
+	tr := NewTierer(cfg, bkt, nil, limitsMock{"user-1": time.Hour}, nil, nil, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, tr.iteration(ctx))
+
+	exists, err := bkt.Exists(ctx, path.Join("user-1", bucketindex.TieringMarkFilepath(block.ULID)))
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, float64(1), testutil.ToFloat64(tr.transitionedBlocks))
+}
+
+func TestTierer_RetriesTheBlocksFailedToBeTransitioned(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+
+	block := cortex_testutil.MockStorageBlock(t, bkt, "user-1", 10, 20)
+	writeIndex(t, bkt, "user-1")
+
+	rewriter := &rewriterMock{fail: "index"}
+	cfg := Config{Enabled: true, Interval: time.Hour, Concurrency: 1, Mode: ModeRewrite, StorageClass: "STANDARD_IA"}
+	tr := NewTierer(cfg, bkt, nil, limitsMock{"user-1": time.Hour}, rewriter, nil, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, tr.iteration(ctx))
+	assert.Equal(t, float64(1), testutil.ToFloat64(tr.runs.WithLabelValues("failed")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(tr.failedBlocks))
+
+	// The block isn't marked until all its objects have been rewritten.
+	exists, err := bkt.Exists(ctx, path.Join("user-1", bucketindex.TieringMarkFilepath(block.ULID)))
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	rewriter.fail = ""
+	require.NoError(t, tr.iteration(ctx))
+	assert.Equal(t, float64(1), testutil.ToFloat64(tr.transitionedBlocks))
+}
@@ -0,0 +1,332 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+const (
+	distributionUniform = "uniform"
+	distributionZipf    = "zipf"
+
+	// zipfExponent skews the zipf distribution of the label values: the first value is
+	// about twice as frequent as the second one.
+	zipfExponent = 1.1
+)
+
+var (
+	errInvalidTenants      = errors.New("the number of tenants must be greater than 0")
+	errInvalidSeries       = errors.New("the number of series per tenant must be greater than 0")
+	errInvalidLabelValues  = errors.New("the number of values per label must be greater than 0")
+	errInvalidDistribution = errors.New("the label values distribution must be uniform or zipf")
+	errInvalidChurn        = errors.New("the series churn must be between 0 and 1")
+	errInvalidRate         = errors.New("the samples per second and the batch size must be greater than 0")
+	errInvalidConcurrency  = errors.New("the concurrency must be greater than 0")
+)
+
+// PushCommand generates synthetic remote write traffic against an ingester, and reports the
+// latency and the errors of the requests.
+type PushCommand struct {
+	URL              string
+	TenantPrefix     string
+	Tenants          int
+	Series           int
+	Labels           int
+	LabelValues      int
+	Distribution     string
+	Churn            float64
+	SamplesPerSecond int
+	BatchSize        int
+	Concurrency      int
+	Duration         time.Duration
+	Timeout          time.Duration
+	Seed             int64
+}
+
+// RegisterFlags registers the flags of the command.
+func (c *PushCommand) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&c.URL, "url", "http://localhost:8080/api/v1/push", "Remote write endpoint of the target ingester.")
+	f.StringVar(&c.TenantPrefix, "tenant-prefix", "bench-", "Prefix of the tenant IDs, followed by the tenant number.")
+	f.IntVar(&c.Tenants, "tenants", 1, "Number of tenants pushing series.")
+	f.IntVar(&c.Series, "series", 1000, "Number of active series per tenant.")
+	f.IntVar(&c.Labels, "labels", 5, "Number of labels of each series, besides the metric name and the series ID.")
+	f.IntVar(&c.LabelValues, "label-values", 10, "Number of distinct values of each label.")
+	f.StringVar(&c.Distribution, "label-distribution", distributionUniform, "Distribution of the label values among the series: uniform or zipf.")
+	f.Float64Var(&c.Churn, "series-churn", 0, "Fraction of the series of each tenant replaced by new ones every minute.")
+	f.IntVar(&c.SamplesPerSecond, "samples-per-second", 1000, "Number of samples pushed per second, across all the tenants.")
+	f.IntVar(&c.BatchSize, "batch-size", 500, "Number of samples per request.")
+	f.IntVar(&c.Concurrency, "concurrency", 10, "Maximum number of requests in flight. The batches which can't be sent on time are skipped.")
+	f.DurationVar(&c.Duration, "duration", time.Minute, "How long to push for.")
+	f.DurationVar(&c.Timeout, "timeout", 10*time.Second, "Timeout of each request.")
+	f.Int64Var(&c.Seed, "seed", 1, "Seed of the label values, for the series to be the same across runs.")
+}
+
+func (c *PushCommand) validate() error {
+	switch {
+	case c.Tenants <= 0:
+		return errInvalidTenants
+	case c.Series <= 0:
+		return errInvalidSeries
+	case c.LabelValues <= 0:
+		return errInvalidLabelValues
+	case c.Distribution != distributionUniform && c.Distribution != distributionZipf:
+		return errInvalidDistribution
+	case c.Churn < 0 || c.Churn > 1:
+		return errInvalidChurn
+	case c.SamplesPerSecond <= 0 || c.BatchSize <= 0:
+		return errInvalidRate
+	case c.Concurrency <= 0:
+		return errInvalidConcurrency
+	}
+	return nil
+}
+
+// Run pushes the traffic for the configured duration, or until the context is canceled, and
+// prints the report.
+func (c *PushCommand) Run(ctx context.Context, _ []string, out io.Writer) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	tenants := make([]*tenantSeries, 0, c.Tenants)
+	for i := 0; i < c.Tenants; i++ {
+		tenants = append(tenants, c.newTenantSeries(c.TenantPrefix+strconv.Itoa(i), c.Seed+int64(i)))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Duration)
+	defer cancel()
+
+	var (
+		client  = &http.Client{Timeout: c.Timeout}
+		batches = make(chan batch)
+		stats   = newPushStats()
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < c.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range batches {
+				start := time.Now()
+				status, err := c.send(client, b)
+				stats.add(len(b.req.Timeseries), status, err, time.Since(start))
+			}
+		}()
+	}
+
+	// A batch is sent on each tick, to each tenant in turn, so that the samples are spread
+	// evenly over time.
+	interval := time.Duration(float64(time.Second) * float64(c.BatchSize) / float64(c.SamplesPerSecond))
+	ticker := time.NewTicker(interval)
+	start := time.Now()
+	for next := 0; ctx.Err() == nil; next = (next + 1) % len(tenants) {
+		for _, t := range tenants {
+			t.churn(c.Churn * float64(c.Series) * interval.Minutes())
+		}
+		select {
+		case batches <- tenants[next].batch(c.BatchSize, time.Now()):
+		default:
+			stats.skip()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+	}
+	ticker.Stop()
+	close(batches)
+	wg.Wait()
+
+	return stats.write(out, time.Since(start))
+}
+
+func (c *PushCommand) send(client *http.Client, b batch) (int, error) {
+	buf, err := b.req.Marshal()
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(snappy.Encode(nil, buf)))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("X-Scope-OrgID", b.tenant)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+type batch struct {
+	tenant string
+	req    *cortexpb.WriteRequest
+}
+
+// tenantSeries generates the series of a tenant. Each series is identified by a sequence
+// number, a churned series being replaced by the series with the next number.
+type tenantSeries struct {
+	tenant string
+	labels int
+	value  func() int
+	rnd    *rand.Rand
+
+	series   [][]cortexpb.LabelAdapter
+	nextID   int
+	cursor   int
+	toChurn  float64
+	churnPos int
+}
+
+func (c *PushCommand) newTenantSeries(tenant string, seed int64) *tenantSeries {
+	rnd := rand.New(rand.NewSource(seed))
+	value := func() int { return rnd.Intn(c.LabelValues) }
+	if c.Distribution == distributionZipf && c.LabelValues > 1 {
+		zipf := rand.NewZipf(rnd, zipfExponent, 1, uint64(c.LabelValues-1))
+		value = func() int { return int(zipf.Uint64()) }
+	}
+
+	t := &tenantSeries{tenant: tenant, labels: c.Labels, value: value, rnd: rnd}
+	for i := 0; i < c.Series; i++ {
+		t.series = append(t.series, t.newSeries())
+	}
+	return t
+}
+
+func (t *tenantSeries) newSeries() []cortexpb.LabelAdapter {
+	lbls := make([]cortexpb.LabelAdapter, 0, t.labels+2)
+	lbls = append(lbls, cortexpb.LabelAdapter{Name: "__name__", Value: "bench_series"})
+	for i := 0; i < t.labels; i++ {
+		lbls = append(lbls, cortexpb.LabelAdapter{Name: fmt.Sprintf("label_%d", i), Value: fmt.Sprintf("value_%d", t.value())})
+	}
+	lbls = append(lbls, cortexpb.LabelAdapter{Name: "series_id", Value: strconv.Itoa(t.nextID)})
+	t.nextID++
+	sort.Slice(lbls, func(i, j int) bool { return lbls[i].Name < lbls[j].Name })
+	return lbls
+}
+
+// churn replaces n series, the fractional part being carried over to the next call.
+func (t *tenantSeries) churn(n float64) {
+	t.toChurn += n
+	for ; t.toChurn >= 1; t.toChurn-- {
+		t.series[t.churnPos] = t.newSeries()
+		t.churnPos = (t.churnPos + 1) % len(t.series)
+	}
+}
+
+// batch returns a request with a sample for each of the next size series.
+func (t *tenantSeries) batch(size int, now time.Time) batch {
+	if size > len(t.series) {
+		size = len(t.series)
+	}
+	req := &cortexpb.WriteRequest{Source: cortexpb.API}
+	for i := 0; i < size; i++ {
+		req.Timeseries = append(req.Timeseries, cortexpb.PreallocTimeseries{TimeSeries: &cortexpb.TimeSeries{
+			Labels:  t.series[t.cursor],
+			Samples: []cortexpb.Sample{{TimestampMs: now.UnixMilli(), Value: t.rnd.Float64()}},
+		}})
+		t.cursor = (t.cursor + 1) % len(t.series)
+	}
+	return batch{tenant: t.tenant, req: req}
+}
+
+// pushStats are the outcomes of the requests.
+type pushStats struct {
+	mtx       sync.Mutex
+	latencies []time.Duration
+	samples   int
+	skipped   int
+	errors    int
+	statuses  map[string]int
+}
+
+func newPushStats() *pushStats {
+	return &pushStats{statuses: map[string]int{}}
+}
+
+func (s *pushStats) add(samples, status int, err error, latency time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.latencies = append(s.latencies, latency)
+	switch {
+	case err != nil:
+		s.errors++
+		s.statuses["error"]++
+	case status/100 != 2:
+		s.errors++
+		s.statuses[fmt.Sprintf("%dxx", status/100)]++
+	default:
+		s.samples += samples
+		s.statuses["2xx"]++
+	}
+}
+
+func (s *pushStats) skip() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.skipped++
+}
+
+func (s *pushStats) write(out io.Writer, elapsed time.Duration) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+
+	errorRate := 0.0
+	if len(s.latencies) > 0 {
+		errorRate = 100 * float64(s.errors) / float64(len(s.latencies))
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "duration\t%s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(tw, "requests\t%d\n", len(s.latencies))
+	fmt.Fprintf(tw, "skipped batches\t%d\n", s.skipped)
+	fmt.Fprintf(tw, "samples pushed\t%d (%.1f/s)\n", s.samples, float64(s.samples)/elapsed.Seconds())
+	fmt.Fprintf(tw, "errors\t%d (%.2f%%)\n", s.errors, errorRate)
+	for _, q := range []float64{0.5, 0.9, 0.99, 1} {
+		fmt.Fprintf(tw, "latency p%g\t%s\n", q*100, percentile(s.latencies, q))
+	}
+
+	statuses := make([]string, 0, len(s.statuses))
+	for status := range s.statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(tw, "status %s\t%d\n", status, s.statuses[status])
+	}
+	return tw.Flush()
+}
+
+// percentile returns the q-quantile of the sorted latencies, by the nearest rank.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
@@ -0,0 +1,126 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+func TestPushCommand(t *testing.T) {
+	var (
+		mtx     sync.Mutex
+		tenants = map[string]int{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		buf, err := snappy.Decode(nil, body)
+		require.NoError(t, err)
+		var req cortexpb.WriteRequest
+		require.NoError(t, req.Unmarshal(buf))
+
+		mtx.Lock()
+		defer mtx.Unlock()
+		tenants[r.Header.Get("X-Scope-OrgID")] += len(req.Timeseries)
+		// One tenant is rate limited.
+		if r.Header.Get("X-Scope-OrgID") == "bench-1" {
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	cmd := &PushCommand{
+		URL:              server.URL,
+		TenantPrefix:     "bench-",
+		Tenants:          2,
+		Series:           10,
+		Labels:           2,
+		LabelValues:      3,
+		Distribution:     distributionZipf,
+		SamplesPerSecond: 1000,
+		BatchSize:        10,
+		Concurrency:      2,
+		Duration:         200 * time.Millisecond,
+		Timeout:          time.Second,
+	}
+	out := &bytes.Buffer{}
+	require.NoError(t, cmd.Run(context.Background(), nil, out))
+
+	assert.Len(t, tenants, 2)
+	assert.Greater(t, tenants["bench-0"], 0)
+	assert.Greater(t, tenants["bench-1"], 0)
+	assert.Regexp(t, `(?m)^errors\s+\d+ \(\d+\.\d+%\)$`, out.String())
+	assert.Regexp(t, `(?m)^latency p99\s+\S+$`, out.String())
+	assert.Regexp(t, `(?m)^status 2xx\s+\d+$`, out.String())
+	assert.Regexp(t, `(?m)^status 4xx\s+\d+$`, out.String())
+}
+
+func TestPushCommand_Validate(t *testing.T) {
+	valid := PushCommand{Tenants: 1, Series: 1, LabelValues: 1, Distribution: distributionUniform, SamplesPerSecond: 1, BatchSize: 1, Concurrency: 1}
+	assert.NoError(t, valid.validate())
+
+	invalid := valid
+	invalid.Distribution = "normal"
+	assert.Equal(t, errInvalidDistribution, invalid.validate())
+
+	invalid = valid
+	invalid.Churn = 2
+	assert.Equal(t, errInvalidChurn, invalid.validate())
+}
+
+func TestTenantSeries(t *testing.T) {
+	cmd := &PushCommand{Series: 4, Labels: 11, LabelValues: 5, Distribution: distributionUniform}
+	series := cmd.newTenantSeries("tenant", 1)
+
+	// The batches go through the series in turn.
+	b := series.batch(3, time.UnixMilli(1000))
+	assert.Equal(t, "tenant", b.tenant)
+	require.Len(t, b.req.Timeseries, 3)
+	assert.Equal(t, int64(1000), b.req.Timeseries[0].Samples[0].TimestampMs)
+	assert.Equal(t, []string{"0", "1", "2"}, seriesIDs(b.req))
+	assert.Equal(t, []string{"3", "0"}, seriesIDs(series.batch(2, time.Now()).req))
+
+	// The labels are sorted.
+	lbls := b.req.Timeseries[0].Labels
+	require.Len(t, lbls, 13)
+	for i := 1; i < len(lbls); i++ {
+		assert.Less(t, lbls[i-1].Name, lbls[i].Name)
+	}
+
+	// The churned series are replaced by new ones, the fractions adding up across calls.
+	series.churn(0.5)
+	assert.Equal(t, []string{"1", "2", "3", "0"}, seriesIDs(series.batch(4, time.Now()).req))
+	series.churn(1.5)
+	assert.Equal(t, []string{"5", "2", "3", "4"}, seriesIDs(series.batch(4, time.Now()).req))
+}
+
+func TestPercentile(t *testing.T) {
+	latencies := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	assert.Equal(t, time.Duration(0), percentile(nil, 0.5))
+	assert.Equal(t, time.Duration(5), percentile(latencies, 0.5))
+	assert.Equal(t, time.Duration(9), percentile(latencies, 0.9))
+	assert.Equal(t, time.Duration(10), percentile(latencies, 1))
+}
+
+func seriesIDs(req *cortexpb.WriteRequest) []string {
+	var ids []string
+	for _, ts := range req.Timeseries {
+		for _, l := range ts.Labels {
+			if l.Name == "series_id" {
+				ids = append(ids, l.Value)
+			}
+		}
+	}
+	return ids
+}
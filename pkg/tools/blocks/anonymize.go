@@ -0,0 +1,274 @@
+package blocks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+
+	"objectstorage/pkg/ingester/flush"
+)
+
+// anonymizeCommitSeries is the number of series appended to the head between commits.
+const anonymizeCommitSeries = 1000
+
+var (
+	errMissingKey    = errors.New("the anonymization key is required")
+	errInvalidScale  = errors.New("the sample scale must be greater than 0")
+	errInvalidJitter = errors.New("the sample jitter must be within [0, 1)")
+)
+
+// AnonymizeCommand rewrites a block replacing the values of the configured labels, in the
+// series and the external labels, with a keyed hash, and scaling the sample values. The
+// output is deterministic for a given key, so that the series of several blocks anonymized
+// with the same key still match. The jitter scales each series by its own factor, which
+// hides the magnitudes while keeping the shape of the series, counters included.
+type AnonymizeCommand struct {
+	Dir       string
+	OutputDir string
+	Labels    flagext.StringSliceCSV
+	Key       string
+	Scale     float64
+	Jitter    float64
+	Logger    log.Logger
+}
+
+// RegisterFlags registers the flags of the command.
+func (c *AnonymizeCommand) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&c.Dir, "dir", "./data", "Directory containing the block.")
+	f.StringVar(&c.OutputDir, "output-dir", "", "Directory the anonymized block is written to. Defaults to the directory of the block.")
+	f.Var(&c.Labels, "labels", "Comma-separated names of the labels whose values are replaced. Defaults to all the labels but the metric name.")
+	f.StringVar(&c.Key, "key", "", "Secret key the label values and the sample jitter are derived from. Without it, the original values could be recovered by hashing the likely ones.")
+	f.Float64Var(&c.Scale, "scale", 1, "Factor the sample values are multiplied by.")
+	f.Float64Var(&c.Jitter, "jitter", 0, "Maximum relative deviation from the scale factor of each series, within [0, 1).")
+}
+
+// Run anonymizes the block whose ULID is the only argument.
+func (c *AnonymizeCommand) Run(ctx context.Context, args []string, out io.Writer) error {
+	dir, err := blockDir(c.Dir, args)
+	if err != nil {
+		return err
+	}
+	if c.Key == "" {
+		return errMissingKey
+	}
+	if c.Scale <= 0 {
+		return errInvalidScale
+	}
+	if c.Jitter < 0 || c.Jitter >= 1 {
+		return errInvalidJitter
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	outputDir := c.OutputDir
+	if outputDir == "" {
+		outputDir = c.Dir
+	}
+
+	b, err := tsdb.OpenBlock(logger, dir, nil)
+	if err != nil {
+		return errors.Wrap(err, "open block")
+	}
+	defer b.Close()
+	meta, err := metadata.ReadFromDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "read meta")
+	}
+
+	// The anonymized series are appended to a head, which sorts them, then written as a
+	// block like the ones compacted from the head of the ingester.
+	chunkDir, err := os.MkdirTemp("", "anonymize")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(chunkDir)
+	opts := tsdb.DefaultHeadOptions()
+	opts.ChunkDirRoot = chunkDir
+	opts.ChunkRange = meta.MaxTime - meta.MinTime
+	opts.EnableNativeHistograms.Store(true)
+	head, err := tsdb.NewHead(nil, logger, nil, nil, opts, nil)
+	if err != nil {
+		return errors.Wrap(err, "create head")
+	}
+	defer head.Close()
+	if err := head.Init(math.MinInt64); err != nil {
+		return errors.Wrap(err, "init head")
+	}
+
+	numSeries, err := c.anonymizeSeries(ctx, b, head)
+	if err != nil {
+		return err
+	}
+
+	extLabels := make(map[string]string, len(meta.Thanos.Labels))
+	for name, value := range meta.Thanos.Labels {
+		extLabels[name] = c.anonymizeValue(name, value)
+	}
+	id, err := flush.WriteBlock(ctx, tsdb.NewRangeHead(head, meta.MinTime, meta.MaxTime-1), meta.MinTime, meta.MaxTime, outputDir, extLabels, logger)
+	if err != nil {
+		return errors.Wrap(err, "write block")
+	}
+	fmt.Fprintf(out, "anonymized %s into %s: series=%d external labels=%s\n", args[0], id, numSeries, labels.FromMap(extLabels))
+	return nil
+}
+
+// anonymizeSeries appends the anonymized series of the block to the head, and returns the
+// number of series.
+func (c *AnonymizeCommand) anonymizeSeries(ctx context.Context, b *tsdb.Block, head *tsdb.Head) (int, error) {
+	ir, err := b.Index()
+	if err != nil {
+		return 0, errors.Wrap(err, "open index")
+	}
+	defer ir.Close()
+	cr, err := b.Chunks()
+	if err != nil {
+		return 0, errors.Wrap(err, "open chunks")
+	}
+	defer cr.Close()
+
+	k, v := index.AllPostingsKey()
+	p, err := ir.Postings(k, v)
+	if err != nil {
+		return 0, errors.Wrap(err, "read postings")
+	}
+
+	var (
+		builder labels.ScratchBuilder
+		chks    []chunks.Meta
+		it      chunkenc.Iterator
+		app     = head.Appender(ctx)
+		n       int
+	)
+	for p.Next() {
+		if err := ctx.Err(); err != nil {
+			_ = app.Rollback()
+			return 0, err
+		}
+		if err := ir.Series(p.At(), &builder, &chks); err != nil {
+			_ = app.Rollback()
+			return 0, errors.Wrap(err, "read series")
+		}
+		lset := builder.Labels()
+		factor := c.seriesFactor(lset)
+		lset = c.anonymizeLabels(lset)
+
+		var ref storage.SeriesRef
+		for _, meta := range chks {
+			chk, err := cr.Chunk(meta)
+			if err != nil {
+				_ = app.Rollback()
+				return 0, errors.Wrapf(err, "read chunk %d", meta.Ref)
+			}
+			it = chk.Iterator(it)
+			if ref, err = appendScaled(app, ref, lset, it, factor); err != nil {
+				_ = app.Rollback()
+				return 0, errors.Wrapf(err, "append series %s", lset)
+			}
+		}
+
+		n++
+		if n%anonymizeCommitSeries == 0 {
+			if err := app.Commit(); err != nil {
+				return 0, err
+			}
+			app = head.Appender(ctx)
+		}
+	}
+	if err := p.Err(); err != nil {
+		_ = app.Rollback()
+		return 0, err
+	}
+	return n, app.Commit()
+}
+
+// appendScaled appends the samples of the chunk, scaled by factor.
+func appendScaled(app storage.Appender, ref storage.SeriesRef, lset labels.Labels, it chunkenc.Iterator, factor float64) (storage.SeriesRef, error) {
+	var err error
+	for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+		switch vt {
+		case chunkenc.ValFloat:
+			t, v := it.At()
+			ref, err = app.Append(ref, lset, t, v*factor)
+		case chunkenc.ValHistogram:
+			t, h := it.AtHistogram()
+			ref, err = app.AppendHistogram(ref, lset, t, nil, h.ToFloat().Scale(factor))
+		case chunkenc.ValFloatHistogram:
+			t, fh := it.AtFloatHistogram()
+			ref, err = app.AppendHistogram(ref, lset, t, nil, fh.Copy().Scale(factor))
+		}
+		if err != nil {
+			return ref, err
+		}
+	}
+	return ref, it.Err()
+}
+
+// anonymizeLabels returns the labels with the values of the configured labels replaced.
+func (c *AnonymizeCommand) anonymizeLabels(lset labels.Labels) labels.Labels {
+	var builder labels.ScratchBuilder
+	lset.Range(func(l labels.Label) {
+		builder.Add(l.Name, c.anonymizeValue(l.Name, l.Value))
+	})
+	return builder.Labels()
+}
+
+// anonymizeValue returns the keyed hash of the value if the label is configured to be
+// anonymized, or the value otherwise.
+func (c *AnonymizeCommand) anonymizeValue(name, value string) string {
+	if !c.anonymized(name) {
+		return value
+	}
+	sum := c.hash(name, value)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (c *AnonymizeCommand) anonymized(name string) bool {
+	if len(c.Labels) == 0 {
+		return name != labels.MetricName
+	}
+	for _, l := range c.Labels {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+
+// seriesFactor returns the factor the samples of the series are multiplied by, within
+// [scale*(1-jitter), scale*(1+jitter)], derived from the original labels.
+func (c *AnonymizeCommand) seriesFactor(lset labels.Labels) float64 {
+	if c.Jitter == 0 {
+		return c.Scale
+	}
+	sum := c.hash("", lset.String())
+	u := float64(binary.BigEndian.Uint64(sum[:8]))/math.MaxUint64*2 - 1
+	return c.Scale * (1 + c.Jitter*u)
+}
+
+func (c *AnonymizeCommand) hash(name, value string) []byte {
+	mac := hmac.New(sha256.New, []byte(c.Key))
+	mac.Write([]byte(name))
+	mac.Write([]byte{0xff})
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,220 @@
+package blocks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+
+	"objectstorage/pkg/ingester/flush"
+)
+
+func TestInspectCommand(t *testing.T) {
+	dir := t.TempDir()
+	id := createBlock(t, dir, map[string]string{"tenant": "user-1"},
+		labels.FromStrings("__name__", "up", "job", "a", "instance", "1"),
+		labels.FromStrings("__name__", "up", "job", "a", "instance", "2"),
+		labels.FromStrings("__name__", "up", "job", "b", "instance", "3"),
+		labels.FromStrings("__name__", "down", "job", "b"),
+	)
+
+	stats, err := inspectIndex(dir + "/" + id.String() + "/" + indexFilename)
+	require.NoError(t, err)
+	assert.Equal(t, 4, stats.series)
+	assert.Equal(t, 4, stats.chunks)
+	require.Len(t, stats.labels, 3)
+	assert.Equal(t, "__name__", stats.labels[0].name)
+	assert.Equal(t, 2, stats.labels[0].values)
+	assert.Equal(t, 4, stats.labels[0].series)
+	assert.Equal(t, "instance", stats.labels[2].name)
+	assert.Equal(t, 3, stats.labels[2].values)
+	assert.Equal(t, 3, stats.labels[2].series)
+	for _, l := range stats.labels {
+		assert.Greater(t, l.postingsBytes, 0, l.name)
+	}
+
+	out := &bytes.Buffer{}
+	cmd := &InspectCommand{Dir: dir, TopN: 2}
+	require.NoError(t, cmd.Run(context.Background(), []string{id.String()}, out))
+	assert.Regexp(t, `External labels: +\{tenant="user-1"\}`, out.String())
+	assert.Regexp(t, `\nSeries: +4\n`, out.String())
+	assert.NotContains(t, out.String(), "instance", "only the top label names are printed")
+}
+
+func TestDumpCommand(t *testing.T) {
+	dir := t.TempDir()
+	id := createBlock(t, dir, nil,
+		labels.FromStrings("__name__", "up", "job", "a"),
+		labels.FromStrings("__name__", "up", "job", "b"),
+		labels.FromStrings("__name__", "down", "job", "a"),
+	)
+
+	tests := map[string]struct {
+		cmd      DumpCommand
+		expected string
+	}{
+		"series only": {
+			cmd: DumpCommand{Selector: `{job="a"}`},
+			expected: `{__name__="down", job="a"}
+{__name__="up", job="a"}
+`,
+		},
+		"with samples": {
+			cmd: DumpCommand{Selector: `up{job="b"}`, MinTime: 0, MaxTime: 1000, Samples: true},
+			expected: `{__name__="up", job="b"}
+  chunk ref=56 mint=0 maxt=200 encoding=XOR samples=3 bytes=18
+    0 0
+    100 1
+    200 2
+`,
+		},
+		"no chunk in the time range": {
+			cmd: DumpCommand{Selector: `up{job="b"}`, MinTime: 1000, MaxTime: 2000, Chunks: true},
+			expected: `{__name__="up", job="b"}
+`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			tc.cmd.Dir = dir
+			require.NoError(t, tc.cmd.Run(context.Background(), []string{id.String()}, out))
+			assert.Equal(t, tc.expected, out.String())
+		})
+	}
+}
+
+func TestBlockDir(t *testing.T) {
+	_, err := blockDir("data", nil)
+	assert.ErrorIs(t, err, errUsage)
+	_, err = blockDir("data", []string{"not-a-ulid"})
+	assert.Error(t, err)
+}
+
+func TestCompactCommand(t *testing.T) {
+	dir := t.TempDir()
+	user1 := map[string]string{"tenant": "user-1"}
+	first := createBlock(t, dir, user1, labels.FromStrings("__name__", "up", "job", "a"))
+	second := createBlock(t, dir, user1, labels.FromStrings("__name__", "up", "job", "a"), labels.FromStrings("__name__", "up", "job", "b"))
+	// Left out, marked for no compaction.
+	noCompact := createBlock(t, dir, user1, labels.FromStrings("__name__", "up", "job", "c"))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, noCompact.String(), metadata.NoCompactMarkFilename), []byte("{}"), 0o600))
+	// Left out, alone in its group.
+	other := createBlock(t, dir, map[string]string{"tenant": "user-2"}, labels.FromStrings("__name__", "up", "job", "a"))
+
+	out := &bytes.Buffer{}
+	cmd := &CompactCommand{Dir: dir, Range: 2 * time.Hour, DeleteSources: true}
+	require.NoError(t, cmd.Run(context.Background(), nil, out))
+	assert.Contains(t, out.String(), "skipped "+noCompact.String()+": marked for no compaction\n")
+	assert.Regexp(t, `compacted 2 blocks with external labels \{tenant="user-1"\} into \w+: series=2 samples=6 `, out.String())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var ids []string
+	for _, entry := range entries {
+		ids = append(ids, entry.Name())
+	}
+	assert.Len(t, ids, 3)
+	assert.NotContains(t, ids, first.String())
+	assert.NotContains(t, ids, second.String())
+	assert.Contains(t, ids, noCompact.String())
+	assert.Contains(t, ids, other.String())
+
+	for _, id := range ids {
+		if id == noCompact.String() || id == other.String() {
+			continue
+		}
+		meta, err := metadata.ReadFromDir(filepath.Join(dir, id))
+		require.NoError(t, err)
+		assert.Equal(t, user1, meta.Thanos.Labels)
+		assert.Equal(t, 2, meta.Compaction.Level)
+		assert.ElementsMatch(t, []ulid.ULID{first, second}, meta.Compaction.Sources)
+	}
+
+	cmd.Range = 0
+	assert.Equal(t, errInvalidRange, cmd.Run(context.Background(), nil, out))
+}
+
+func TestAnonymizeCommand(t *testing.T) {
+	dir := t.TempDir()
+	id := createBlock(t, dir, map[string]string{"tenant": "user-1", "replica": "a"},
+		labels.FromStrings("__name__", "up", "job", "api", "instance", "10.0.0.1"),
+		labels.FromStrings("__name__", "up", "job", "api", "instance", "10.0.0.2"),
+	)
+
+	cmd := &AnonymizeCommand{Dir: dir, OutputDir: t.TempDir(), Labels: []string{"instance", "tenant"}, Key: "secret", Scale: 10}
+	out := &bytes.Buffer{}
+	require.NoError(t, cmd.Run(context.Background(), []string{id.String()}, out))
+	require.Regexp(t, `^anonymized \w+ into \w+: series=2 `, out.String())
+	anonymized := strings.Fields(out.String())[3]
+	anonymized = strings.TrimSuffix(anonymized, ":")
+
+	meta, err := metadata.ReadFromDir(filepath.Join(cmd.OutputDir, anonymized))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"tenant": cmd.anonymizeValue("tenant", "user-1"), "replica": "a"}, meta.Thanos.Labels)
+	assert.NotEqual(t, "user-1", meta.Thanos.Labels["tenant"])
+	assert.Equal(t, int64(0), meta.MinTime)
+	assert.Equal(t, int64(201), meta.MaxTime)
+
+	dump := &bytes.Buffer{}
+	require.NoError(t, (&DumpCommand{Dir: cmd.OutputDir, Selector: `up`, MinTime: math.MinInt64, MaxTime: math.MaxInt64, Samples: true}).Run(context.Background(), []string{anonymized}, dump))
+	for _, instance := range []string{"10.0.0.1", "10.0.0.2"} {
+		assert.NotContains(t, dump.String(), instance)
+		assert.Contains(t, dump.String(), fmt.Sprintf(`{__name__="up", instance=%q, job="api"}`, cmd.anonymizeValue("instance", instance)))
+	}
+	assert.Contains(t, dump.String(), "    100 10\n    200 20\n")
+
+	// The same key gives the same values, and the jitter a distinct factor per series
+	// within the bounds.
+	assert.Equal(t, cmd.anonymizeValue("instance", "10.0.0.1"), (&AnonymizeCommand{Key: "secret"}).anonymizeValue("instance", "10.0.0.1"))
+	assert.NotEqual(t, cmd.anonymizeValue("instance", "10.0.0.1"), (&AnonymizeCommand{Key: "other"}).anonymizeValue("instance", "10.0.0.1"))
+	assert.Equal(t, "api", cmd.anonymizeValue("job", "api"))
+	assert.Equal(t, "up", (&AnonymizeCommand{}).anonymizeValue("__name__", "up"))
+	cmd.Jitter = 0.5
+	f1 := cmd.seriesFactor(labels.FromStrings("instance", "10.0.0.1"))
+	f2 := cmd.seriesFactor(labels.FromStrings("instance", "10.0.0.2"))
+	assert.NotEqual(t, f1, f2)
+	for _, f := range []float64{f1, f2} {
+		assert.GreaterOrEqual(t, f, 5.0)
+		assert.LessOrEqual(t, f, 15.0)
+	}
+
+	cmd.Key = ""
+	assert.Equal(t, errMissingKey, cmd.Run(context.Background(), []string{id.String()}, out))
+}
+
+// createBlock writes a block in dir with 3 samples, at 0, 100 and 200, for each series.
+func createBlock(t *testing.T, dir string, extLabels map[string]string, series ...labels.Labels) ulid.ULID {
+	opts := tsdb.DefaultOptions()
+	opts.RetentionDuration = 0
+	db, err := tsdb.Open(t.TempDir(), nil, nil, opts, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	app := db.Appender(context.Background())
+	for _, lset := range series {
+		for i := 0; i < 3; i++ {
+			_, err := app.Append(0, lset, int64(i)*100, float64(i))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, app.Commit())
+
+	id, err := flush.WriteBlock(context.Background(), tsdb.NewRangeHead(db.Head(), 0, 200), 0, 201, dir, extLabels, log.NewNopLogger())
+	require.NoError(t, err)
+	return id
+}
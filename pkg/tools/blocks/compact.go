@@ -0,0 +1,177 @@
+package blocks
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+var errInvalidRange = errors.New("the compaction range must be greater than 0")
+
+// CompactCommand compacts the blocks of a directory, such as the blocks of a tenant
+// downloaded from the bucket, without running the service. The blocks are compacted by group
+// of blocks with the same external labels and within the same range, the overlapping blocks
+// being merged. The blocks marked for no compaction are left out.
+type CompactCommand struct {
+	Dir           string
+	OutputDir     string
+	Range         time.Duration
+	DeleteSources bool
+	Logger        log.Logger
+}
+
+// RegisterFlags registers the flags of the command.
+func (c *CompactCommand) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&c.Dir, "dir", "./data", "Directory containing the blocks.")
+	f.StringVar(&c.OutputDir, "output-dir", "", "Directory the compacted blocks are written to. Defaults to the directory of the blocks.")
+	f.DurationVar(&c.Range, "range", 24*time.Hour, "Time range of the compacted blocks. The blocks are compacted with the other blocks of the same aligned range, and the blocks spanning multiple ranges are left out.")
+	f.BoolVar(&c.DeleteSources, "delete-sources", false, "Delete the source blocks once compacted.")
+}
+
+// Run compacts the blocks whose ULIDs are given as arguments, or all the blocks of the
+// directory if none is given.
+func (c *CompactCommand) Run(ctx context.Context, args []string, out io.Writer) error {
+	if c.Range <= 0 {
+		return errInvalidRange
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	outputDir := c.OutputDir
+	if outputDir == "" {
+		outputDir = c.Dir
+	}
+
+	metas, err := c.readMetas(args)
+	if err != nil {
+		return err
+	}
+
+	var (
+		rangeMs = c.Range.Milliseconds()
+		groups  = map[string][]*metadata.Meta{}
+	)
+	for _, meta := range metas {
+		if _, err := os.Stat(filepath.Join(c.Dir, meta.ULID.String(), metadata.NoCompactMarkFilename)); err == nil {
+			fmt.Fprintf(out, "skipped %s: marked for no compaction\n", meta.ULID)
+			continue
+		}
+		window := meta.MinTime / rangeMs
+		if meta.MinTime < 0 {
+			window = (meta.MinTime - rangeMs + 1) / rangeMs
+		}
+		if meta.MaxTime > (window+1)*rangeMs {
+			fmt.Fprintf(out, "skipped %s: spans multiple ranges\n", meta.ULID)
+			continue
+		}
+		key := fmt.Sprintf("%s/%d", meta.Thanos.GroupKey(), window)
+		groups[key] = append(groups[key], meta)
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	compactor, err := tsdb.NewLeveledCompactor(ctx, nil, logger, []int64{rangeMs}, nil, storage.NewCompactingChunkSeriesMerger(storage.ChainedSeriesMerge))
+	if err != nil {
+		return errors.Wrap(err, "create compactor")
+	}
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		group := groups[key]
+		if len(group) < 2 {
+			continue
+		}
+		if err := c.compactGroup(compactor, group, outputDir, out, logger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readMetas returns the metas of the blocks, oldest first.
+func (c *CompactCommand) readMetas(args []string) ([]*metadata.Meta, error) {
+	ids := args
+	if len(ids) == 0 {
+		entries, err := os.ReadDir(c.Dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if _, err := ulid.Parse(entry.Name()); err == nil && entry.IsDir() {
+				ids = append(ids, entry.Name())
+			}
+		}
+	}
+
+	metas := make([]*metadata.Meta, 0, len(ids))
+	for _, id := range ids {
+		if _, err := ulid.Parse(id); err != nil {
+			return nil, errors.Wrapf(err, "invalid block ULID %q", id)
+		}
+		meta, err := metadata.ReadFromDir(filepath.Join(c.Dir, id))
+		if err != nil {
+			return nil, errors.Wrapf(err, "read meta of block %s", id)
+		}
+		metas = append(metas, meta)
+	}
+	sort.Slice(metas, func(i, j int) bool { return metas[i].MinTime < metas[j].MinTime })
+	return metas, nil
+}
+
+// compactGroup compacts the blocks of the group, and gives the compacted block the external
+// labels of the group.
+func (c *CompactCommand) compactGroup(compactor *tsdb.LeveledCompactor, group []*metadata.Meta, outputDir string, out io.Writer, logger log.Logger) error {
+	dirs := make([]string, 0, len(group))
+	for _, meta := range group {
+		dirs = append(dirs, filepath.Join(c.Dir, meta.ULID.String()))
+	}
+
+	id, err := compactor.Compact(outputDir, dirs, nil)
+	if err != nil {
+		return errors.Wrapf(err, "compact blocks %v", dirs)
+	}
+	if id == (ulid.ULID{}) {
+		fmt.Fprintf(out, "compacted %d blocks with external labels %s: no samples left\n", len(group), labels.FromMap(group[0].Thanos.Labels))
+	} else {
+		thanos := metadata.Thanos{
+			Labels:     group[0].Thanos.Labels,
+			Downsample: group[0].Thanos.Downsample,
+			Source:     metadata.CompactorRepairSource,
+		}
+		meta, err := metadata.InjectThanos(logger, filepath.Join(outputDir, id.String()), thanos, nil)
+		if err != nil {
+			return errors.Wrapf(err, "write meta of block %s", id)
+		}
+		fmt.Fprintf(out, "compacted %d blocks with external labels %s into %s: series=%d samples=%d mint=%s maxt=%s\n",
+			len(group), labels.FromMap(meta.Thanos.Labels), id, meta.Stats.NumSeries, meta.Stats.NumSamples, formatTime(meta.MinTime), formatTime(meta.MaxTime))
+	}
+
+	if !c.DeleteSources {
+		return nil
+	}
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			return errors.Wrapf(err, "delete source block %s", dir)
+		}
+	}
+	return nil
+}
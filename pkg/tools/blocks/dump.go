@@ -0,0 +1,136 @@
+package blocks
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+)
+
+// DumpCommand prints the series of a block matching a selector and, optionally, their chunks
+// and samples.
+type DumpCommand struct {
+	Dir      string
+	Selector string
+	MinTime  int64
+	MaxTime  int64
+	Chunks   bool
+	Samples  bool
+	Logger   log.Logger
+}
+
+// RegisterFlags registers the flags of the command.
+func (c *DumpCommand) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&c.Dir, "dir", "./data", "Directory containing the block.")
+	f.StringVar(&c.Selector, "match", `{__name__=~".+"}`, "Series selector of the series dumped.")
+	f.Int64Var(&c.MinTime, "min-time", math.MinInt64, "Only the chunks overlapping with this time range, in milliseconds, are dumped.")
+	f.Int64Var(&c.MaxTime, "max-time", math.MaxInt64, "Only the chunks overlapping with this time range, in milliseconds, are dumped.")
+	f.BoolVar(&c.Chunks, "chunks", false, "Dump the chunks of each series.")
+	f.BoolVar(&c.Samples, "samples", false, "Dump the samples of each chunk. Implies -chunks.")
+}
+
+// Run dumps the block whose ULID is the only argument.
+func (c *DumpCommand) Run(ctx context.Context, args []string, out io.Writer) error {
+	dir, err := blockDir(c.Dir, args)
+	if err != nil {
+		return err
+	}
+	matchers, err := parser.ParseMetricSelector(c.Selector)
+	if err != nil {
+		return errors.Wrap(err, "parse selector")
+	}
+
+	logger := c.Logger
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	b, err := tsdb.OpenBlock(logger, dir, nil)
+	if err != nil {
+		return errors.Wrap(err, "open block")
+	}
+	defer b.Close()
+
+	ir, err := b.Index()
+	if err != nil {
+		return errors.Wrap(err, "open index")
+	}
+	defer ir.Close()
+
+	cr, err := b.Chunks()
+	if err != nil {
+		return errors.Wrap(err, "open chunks")
+	}
+	defer cr.Close()
+
+	p, err := tsdb.PostingsForMatchers(ir, matchers...)
+	if err != nil {
+		return errors.Wrap(err, "select series")
+	}
+
+	w := bufio.NewWriter(out)
+	var (
+		builder labels.ScratchBuilder
+		chks    []chunks.Meta
+		it      chunkenc.Iterator
+	)
+	for p.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := ir.Series(p.At(), &builder, &chks); err != nil {
+			return errors.Wrap(err, "read series")
+		}
+		fmt.Fprintln(w, builder.Labels())
+		if !c.Chunks && !c.Samples {
+			continue
+		}
+
+		for _, meta := range chks {
+			if !meta.OverlapsClosedInterval(c.MinTime, c.MaxTime) {
+				continue
+			}
+			chk, err := cr.Chunk(meta)
+			if err != nil {
+				return errors.Wrapf(err, "read chunk %d", meta.Ref)
+			}
+			fmt.Fprintf(w, "  chunk ref=%d mint=%d maxt=%d encoding=%s samples=%d bytes=%d\n", meta.Ref, meta.MinTime, meta.MaxTime, chk.Encoding(), chk.NumSamples(), len(chk.Bytes()))
+			if c.Samples {
+				it = chk.Iterator(it)
+				if err := dumpSamples(w, it); err != nil {
+					return errors.Wrapf(err, "read chunk %d", meta.Ref)
+				}
+			}
+		}
+	}
+	if err := p.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func dumpSamples(w io.Writer, it chunkenc.Iterator) error {
+	for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+		switch vt {
+		case chunkenc.ValFloat:
+			t, v := it.At()
+			fmt.Fprintf(w, "    %d %g\n", t, v)
+		case chunkenc.ValHistogram:
+			t, h := it.AtHistogram()
+			fmt.Fprintf(w, "    %d %s\n", t, h)
+		case chunkenc.ValFloatHistogram:
+			t, h := it.AtFloatHistogram()
+			fmt.Fprintf(w, "    %d %s\n", t, h)
+		}
+	}
+	return it.Err()
+}
@@ -0,0 +1,218 @@
+package blocks
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+const (
+	indexFilename = "index"
+
+	// indexTOCLen is the length of the TOC at the end of the index: 6 offsets and a CRC32.
+	indexTOCLen = 6*8 + 4
+)
+
+var errUsage = errors.New("expected the ULID of the block as only argument")
+
+// InspectCommand prints the statistics of the index of a block: its series, the cardinality
+// of its labels and the size of their postings.
+type InspectCommand struct {
+	Dir  string
+	TopN int
+}
+
+// RegisterFlags registers the flags of the command.
+func (c *InspectCommand) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&c.Dir, "dir", "./data", "Directory containing the block.")
+	f.IntVar(&c.TopN, "top", 20, "Number of label names printed, by descending number of series.")
+}
+
+// Run inspects the block whose ULID is the only argument.
+func (c *InspectCommand) Run(_ context.Context, args []string, out io.Writer) error {
+	dir, err := blockDir(c.Dir, args)
+	if err != nil {
+		return err
+	}
+
+	meta, err := metadata.ReadFromDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "read block meta")
+	}
+	stats, err := inspectIndex(filepath.Join(dir, indexFilename))
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "ULID:\t%s\n", meta.ULID)
+	fmt.Fprintf(tw, "Time range:\t%s - %s (%s)\n", formatTime(meta.MinTime), formatTime(meta.MaxTime), time.Duration(meta.MaxTime-meta.MinTime)*time.Millisecond)
+	fmt.Fprintf(tw, "Compaction level:\t%d\n", meta.Compaction.Level)
+	if len(meta.Thanos.Labels) > 0 {
+		fmt.Fprintf(tw, "External labels:\t%s\n", labels.FromMap(meta.Thanos.Labels))
+	}
+	fmt.Fprintf(tw, "Series:\t%d\n", stats.series)
+	fmt.Fprintf(tw, "Chunks:\t%d\n", stats.chunks)
+	fmt.Fprintf(tw, "Samples:\t%d\n", meta.Stats.NumSamples)
+	fmt.Fprintf(tw, "Label names:\t%d\n", len(stats.labels))
+	fmt.Fprintf(tw, "Index size:\t%d\n", stats.toc.size)
+	fmt.Fprintf(tw, "  Symbols:\t%d\n", stats.toc.symbols)
+	fmt.Fprintf(tw, "  Series:\t%d\n", stats.toc.series)
+	fmt.Fprintf(tw, "  Postings:\t%d\n", stats.toc.postings)
+	fmt.Fprintf(tw, "  Postings offset table:\t%d\n", stats.toc.postingsTable)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out)
+	tw = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LABEL NAME\tVALUES\tSERIES\tPOSTINGS BYTES")
+	for i, l := range stats.labels {
+		if c.TopN > 0 && i >= c.TopN {
+			break
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", l.name, l.values, l.series, l.postingsBytes)
+	}
+	return tw.Flush()
+}
+
+// indexStats are the statistics of the index of a block.
+type indexStats struct {
+	series int
+	chunks int
+	toc    tocStats
+	// labels are sorted by descending number of series.
+	labels []labelStats
+}
+
+// tocStats are the sizes of the sections of an index.
+type tocStats struct {
+	size          int
+	symbols       uint64
+	series        uint64
+	postings      uint64
+	postingsTable uint64
+}
+
+type labelStats struct {
+	name          string
+	values        int
+	series        int
+	postingsBytes int
+}
+
+func inspectIndex(indexPath string) (indexStats, error) {
+	stats := indexStats{}
+
+	f, err := fileutil.OpenMmapFile(indexPath)
+	if err != nil {
+		return stats, errors.Wrap(err, "open index")
+	}
+	defer f.Close()
+
+	toc, err := index.NewTOCFromByteSlice(byteSlice(f.Bytes()))
+	if err != nil {
+		return stats, errors.Wrap(err, "read index TOC")
+	}
+	stats.toc = tocStats{
+		size:          len(f.Bytes()),
+		symbols:       toc.Series - toc.Symbols,
+		series:        toc.LabelIndices - toc.Series,
+		postings:      toc.LabelIndicesTable - toc.Postings,
+		postingsTable: uint64(len(f.Bytes())-indexTOCLen) - toc.PostingsTable,
+	}
+
+	ir, err := index.NewReader(byteSlice(f.Bytes()))
+	if err != nil {
+		return stats, errors.Wrap(err, "open index")
+	}
+	defer ir.Close()
+
+	var (
+		builder labels.ScratchBuilder
+		chks    []chunks.Meta
+	)
+	all, err := ir.Postings(index.AllPostingsKey())
+	if err != nil {
+		return stats, err
+	}
+	for all.Next() {
+		if err := ir.Series(all.At(), &builder, &chks); err != nil {
+			return stats, errors.Wrap(err, "read series")
+		}
+		stats.series++
+		stats.chunks += len(chks)
+	}
+	if err := all.Err(); err != nil {
+		return stats, err
+	}
+
+	// The postings sizes are only known from the offsets of the postings lists.
+	ranges, err := ir.PostingsRanges()
+	if err != nil {
+		return stats, err
+	}
+
+	names, err := ir.LabelNames()
+	if err != nil {
+		return stats, err
+	}
+	for _, name := range names {
+		values, err := ir.LabelValues(name)
+		if err != nil {
+			return stats, err
+		}
+		l := labelStats{name: name, values: len(values)}
+		for _, value := range values {
+			p, err := ir.Postings(name, value)
+			if err != nil {
+				return stats, err
+			}
+			for p.Next() {
+				l.series++
+			}
+			if err := p.Err(); err != nil {
+				return stats, err
+			}
+			r := ranges[labels.Label{Name: name, Value: value}]
+			l.postingsBytes += int(r.End - r.Start)
+		}
+		stats.labels = append(stats.labels, l)
+	}
+	sort.SliceStable(stats.labels, func(i, j int) bool { return stats.labels[i].series > stats.labels[j].series })
+	return stats, nil
+}
+
+// blockDir returns the directory of the block whose ULID is the only argument.
+func blockDir(dir string, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errUsage
+	}
+	id, err := ulid.Parse(args[0])
+	if err != nil {
+		return "", errors.Wrapf(err, "parse block ULID %q", args[0])
+	}
+	return filepath.Join(dir, id.String()), nil
+}
+
+func formatTime(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339)
+}
+
+type byteSlice []byte
+
+func (b byteSlice) Len() int                    { return len(b) }
+func (b byteSlice) Range(start, end int) []byte { return b[start:end] }
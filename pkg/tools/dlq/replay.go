@@ -0,0 +1,340 @@
+package dlq
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/thanos-io/objstore"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+
+	"objectstorage/pkg/deadletter"
+	"objectstorage/pkg/storage/bucket"
+)
+
+const (
+	fromFile   = "file"
+	fromBucket = "bucket"
+)
+
+var (
+	errInvalidFrom      = errors.New("the records must be read from file or bucket")
+	errMissingFiles     = errors.New("at least one dead-letter file must be given when reading from files")
+	errInvalidBatchSize = errors.New("the batch size must be greater than 0")
+)
+
+// ReplayCommand reads the dead-letter records, optionally relabels them, and pushes them again
+// through the push endpoint, where they go through the validation and the limits again. It's
+// meant to recover the series rejected by limits which have since been raised.
+type ReplayCommand struct {
+	URL               string
+	From              string
+	BucketPrefix      string
+	Tenant            string
+	Reasons           flagext.StringSliceCSV
+	RelabelConfigFile string
+	BatchSize         int
+	Timeout           time.Duration
+	DryRun            bool
+	Bucket            bucket.Config
+	Logger            log.Logger
+}
+
+// RegisterFlags registers the flags of the command. The bucket flags are the ones of the
+// blocks storage of the service, used when reading from the bucket.
+func (c *ReplayCommand) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&c.URL, "url", "http://localhost:8080/api/v1/push", "Remote write endpoint the records are pushed to.")
+	f.StringVar(&c.From, "from", fromFile, "Where the records are read from: file, to read the files given as arguments, or bucket, to read the objects written by the bucket backend. The records of the kafka backend can be consumed to a file first.")
+	f.StringVar(&c.BucketPrefix, "bucket-prefix", "dead-letter", "Prefix of the dead-letter objects in the bucket, when reading from the bucket.")
+	f.StringVar(&c.Tenant, "tenant", "", "Only replay the records of this tenant. Empty to replay the records of all tenants.")
+	f.Var(&c.Reasons, "reasons", "Comma-separated list of rejection reasons to replay, like per_user_series_limit. Empty to replay the records of any reason.")
+	f.StringVar(&c.RelabelConfigFile, "relabel-config-file", "", "File with a YAML list of Prometheus relabel configs applied to the series before pushing them, to fix them. The series dropped by the relabeling aren't pushed.")
+	f.IntVar(&c.BatchSize, "batch-size", 500, "Maximum number of series per request.")
+	f.DurationVar(&c.Timeout, "timeout", 30*time.Second, "Timeout of each request.")
+	f.BoolVar(&c.DryRun, "dry-run", false, "True to print the series which would be pushed instead of pushing them.")
+	c.Bucket.RegisterFlagsWithPrefix("blocks-storage.", f)
+}
+
+func (c *ReplayCommand) validate(args []string) error {
+	switch {
+	case c.From != fromFile && c.From != fromBucket:
+		return errInvalidFrom
+	case c.From == fromFile && len(args) == 0:
+		return errMissingFiles
+	case c.BatchSize <= 0:
+		return errInvalidBatchSize
+	}
+	return nil
+}
+
+// Run replays the records and prints the report. It stops at the first request failing for
+// a reason other than the rejection of some of its series, since the following ones would
+// likely fail too. Replaying the same records again is safe, since the samples already
+// ingested are deduplicated.
+func (c *ReplayCommand) Run(ctx context.Context, args []string, out io.Writer) error {
+	if err := c.validate(args); err != nil {
+		return err
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+
+	var relabelConfigs []*relabel.Config
+	if c.RelabelConfigFile != "" {
+		data, err := os.ReadFile(c.RelabelConfigFile)
+		if err != nil {
+			return errors.Wrap(err, "read relabel config file")
+		}
+		if err := yaml.UnmarshalStrict(data, &relabelConfigs); err != nil {
+			return errors.Wrap(err, "parse relabel config file")
+		}
+	}
+
+	r := &replayer{
+		cmd:     c,
+		client:  &http.Client{Timeout: c.Timeout},
+		relabel: relabelConfigs,
+		out:     out,
+		batches: map[string][]cortexpb.PreallocTimeseries{},
+		stats:   &replayStats{},
+	}
+
+	var err error
+	if c.From == fromFile {
+		err = r.readFiles(ctx, args)
+	} else {
+		err = r.readBucket(ctx, logger)
+	}
+	if err == nil {
+		err = r.flushAll(ctx)
+	}
+
+	if writeErr := r.stats.write(out, c.DryRun); err == nil {
+		err = writeErr
+	}
+	return err
+}
+
+// replayer batches the series of the records by tenant, and pushes the full batches.
+type replayer struct {
+	cmd     *ReplayCommand
+	client  *http.Client
+	relabel []*relabel.Config
+	out     io.Writer
+
+	batches map[string][]cortexpb.PreallocTimeseries
+	stats   *replayStats
+}
+
+func (r *replayer) readFiles(ctx context.Context, files []string) error {
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		err = deadletter.ReadRecords(f, func(rec deadletter.Record) error { return r.add(ctx, rec) })
+		_ = f.Close()
+		if err != nil {
+			return errors.Wrap(err, file)
+		}
+	}
+	return nil
+}
+
+// readBucket reads the objects of the tenant, or of all tenants, in the order they've been
+// written.
+func (r *replayer) readBucket(ctx context.Context, logger log.Logger) error {
+	if err := r.cmd.Bucket.Validate(); err != nil {
+		return errors.Wrap(err, "invalid bucket config")
+	}
+	bkt, err := bucket.NewClient(ctx, r.cmd.Bucket, "dlq-replay", logger, nil)
+	if err != nil {
+		return errors.Wrap(err, "create bucket client")
+	}
+	defer bkt.Close()
+
+	prefix := r.cmd.BucketPrefix + "/"
+	if r.cmd.Tenant != "" {
+		prefix = path.Join(r.cmd.BucketPrefix, r.cmd.Tenant) + "/"
+	}
+
+	var names []string
+	if err := bkt.Iter(ctx, prefix, func(name string) error {
+		if strings.HasSuffix(name, deadletter.ObjectSuffix) {
+			names = append(names, name)
+		}
+		return nil
+	}, objstore.WithRecursiveIter); err != nil {
+		return errors.Wrap(err, "list dead-letter objects")
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		rc, err := bkt.Get(ctx, name)
+		if err != nil {
+			return errors.Wrapf(err, "get %s", name)
+		}
+		err = deadletter.ReadRecords(rc, func(rec deadletter.Record) error { return r.add(ctx, rec) })
+		_ = rc.Close()
+		if err != nil {
+			return errors.Wrap(err, name)
+		}
+	}
+	return nil
+}
+
+// add relabels the series of the record, if it passes the filters, and adds it to the batch
+// of its tenant.
+func (r *replayer) add(ctx context.Context, rec deadletter.Record) error {
+	r.stats.records++
+	if (r.cmd.Tenant != "" && rec.Tenant != r.cmd.Tenant) || (len(r.cmd.Reasons) > 0 && !contains(r.cmd.Reasons, rec.Reason)) {
+		r.stats.filtered++
+		return nil
+	}
+
+	ts, err := rec.Timeseries()
+	if err != nil {
+		return err
+	}
+	if len(r.relabel) > 0 {
+		lbls, keep := relabel.Process(rec.Labels, r.relabel...)
+		if !keep || len(lbls) == 0 {
+			r.stats.dropped++
+			return nil
+		}
+		ts.Labels = cortexpb.FromLabelsToLabelAdapters(lbls)
+	}
+
+	if r.cmd.DryRun {
+		fmt.Fprintf(r.out, "%s\t%s\t%d samples\n", rec.Tenant, cortexpb.FromLabelAdaptersToLabels(ts.Labels), len(ts.Samples))
+		r.stats.add(ts)
+		return nil
+	}
+
+	r.batches[rec.Tenant] = append(r.batches[rec.Tenant], ts)
+	if len(r.batches[rec.Tenant]) >= r.cmd.BatchSize {
+		return r.flush(ctx, rec.Tenant)
+	}
+	return nil
+}
+
+func (r *replayer) flushAll(ctx context.Context) error {
+	tenants := make([]string, 0, len(r.batches))
+	for tenantID := range r.batches {
+		tenants = append(tenants, tenantID)
+	}
+	sort.Strings(tenants)
+
+	for _, tenantID := range tenants {
+		if err := r.flush(ctx, tenantID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush pushes the batch of the tenant. The requests with some rejected series are reported,
+// since the limits keep ingesting the other series of the request.
+func (r *replayer) flush(ctx context.Context, tenantID string) error {
+	series := r.batches[tenantID]
+	delete(r.batches, tenantID)
+	if len(series) == 0 {
+		return nil
+	}
+
+	req := cortexpb.WriteRequest{Source: cortexpb.API, Timeseries: series}
+	buf, err := req.Marshal()
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cmd.URL, bytes.NewReader(snappy.Encode(nil, buf)))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	httpReq.Header.Set("X-Scope-OrgID", tenantID)
+
+	resp, err := r.client.Do(httpReq)
+	if err != nil {
+		return errors.Wrapf(err, "push series of tenant %s", tenantID)
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	_ = resp.Body.Close()
+
+	r.stats.requests++
+	for _, ts := range series {
+		r.stats.add(ts)
+	}
+	switch {
+	case resp.StatusCode/100 == 2:
+	case resp.StatusCode == http.StatusBadRequest:
+		r.stats.rejected++
+		fmt.Fprintf(r.out, "tenant %s: some series rejected again: %s\n", tenantID, bytes.TrimSpace(body))
+	default:
+		return errors.Errorf("push series of tenant %s: %s: %s", tenantID, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// replayStats are the counts reported by the replay.
+type replayStats struct {
+	records  int
+	filtered int
+	dropped  int
+	series   int
+	samples  int
+	requests int
+	rejected int
+}
+
+func (s *replayStats) add(ts cortexpb.PreallocTimeseries) {
+	s.series++
+	s.samples += len(ts.Samples)
+}
+
+func (s *replayStats) write(out io.Writer, dryRun bool) error {
+	verb := "pushed"
+	if dryRun {
+		verb = "to push"
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "records read:\t%d\n", s.records)
+	fmt.Fprintf(w, "records filtered out:\t%d\n", s.filtered)
+	fmt.Fprintf(w, "series dropped by relabeling:\t%d\n", s.dropped)
+	fmt.Fprintf(w, "series %s:\t%d\n", verb, s.series)
+	fmt.Fprintf(w, "samples %s:\t%d\n", verb, s.samples)
+	if !dryRun {
+		fmt.Fprintf(w, "requests:\t%d\n", s.requests)
+		fmt.Fprintf(w, "requests with rejected series:\t%d\n", s.rejected)
+	}
+	return w.Flush()
+}
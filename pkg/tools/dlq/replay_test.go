@@ -0,0 +1,198 @@
+package dlq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore/providers/filesystem"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/deadletter"
+	"objectstorage/pkg/storage/bucket"
+)
+
+// fakePush serves the push endpoint, recording the pushed series by tenant. The requests
+// with a series with the reject label fail with 400.
+func fakePush(t *testing.T) (*httptest.Server, func() map[string][]string) {
+	var (
+		mtx    sync.Mutex
+		pushed = map[string][]string{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		buf, err := snappy.Decode(nil, body)
+		require.NoError(t, err)
+		var req cortexpb.WriteRequest
+		require.NoError(t, req.Unmarshal(buf))
+
+		mtx.Lock()
+		defer mtx.Unlock()
+		tenant := r.Header.Get("X-Scope-OrgID")
+		rejected := false
+		for _, ts := range req.Timeseries {
+			lbls := cortexpb.FromLabelAdaptersToLabels(ts.Labels)
+			if lbls.Has("reject") {
+				rejected = true
+				continue
+			}
+			pushed[tenant] = append(pushed[tenant], lbls.String())
+		}
+		if rejected {
+			http.Error(w, "per-user series limit exceeded", http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() map[string][]string {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return pushed
+	}
+}
+
+func writeRecords(t *testing.T, records ...deadletter.Record) string {
+	file := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	f, err := os.Create(file)
+	require.NoError(t, err)
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, rec := range records {
+		require.NoError(t, enc.Encode(rec))
+	}
+	return file
+}
+
+func record(tenant, reason string, lbls ...string) deadletter.Record {
+	return deadletter.Record{
+		Time:    time.Now(),
+		Tenant:  tenant,
+		Reason:  reason,
+		Labels:  labels.FromStrings(lbls...),
+		Samples: []deadletter.Sample{{TimestampMs: 1, Value: "1"}},
+	}
+}
+
+func TestReplayCommand_File(t *testing.T) {
+	file := writeRecords(t,
+		record("tenant-a", "per_user_series_limit", "__name__", "up", "pod_uid", "1"),
+		record("tenant-a", "per_user_series_limit", "__name__", "noisy_metric"),
+		record("tenant-a", "label_value_too_long", "__name__", "up", "job", "too-long"),
+		record("tenant-b", "per_user_series_limit", "__name__", "down"),
+	)
+	relabelFile := filepath.Join(t.TempDir(), "relabel.yaml")
+	require.NoError(t, os.WriteFile(relabelFile, []byte(`
+- source_labels: [__name__]
+  regex: noisy_.*
+  action: drop
+- regex: pod_uid
+  action: labeldrop
+`), 0o600))
+
+	server, pushed := fakePush(t)
+	cmd := &ReplayCommand{URL: server.URL, From: fromFile, Reasons: []string{"per_user_series_limit"}, RelabelConfigFile: relabelFile, BatchSize: 10, Timeout: time.Second}
+
+	out := &bytes.Buffer{}
+	require.NoError(t, cmd.Run(context.Background(), []string{file}, out))
+
+	assert.Equal(t, map[string][]string{
+		"tenant-a": {`{__name__="up"}`},
+		"tenant-b": {`{__name__="down"}`},
+	}, pushed())
+	assert.Regexp(t, `records read:\s+4\n`, out.String())
+	assert.Regexp(t, `records filtered out:\s+1\n`, out.String())
+	assert.Regexp(t, `series dropped by relabeling:\s+1\n`, out.String())
+	assert.Regexp(t, `series pushed:\s+2\n`, out.String())
+	assert.Regexp(t, `requests:\s+2\n`, out.String())
+}
+
+func TestReplayCommand_RejectedAgain(t *testing.T) {
+	file := writeRecords(t,
+		record("tenant-a", "per_user_series_limit", "__name__", "up"),
+		record("tenant-a", "per_user_series_limit", "__name__", "up", "reject", "true"),
+		record("tenant-a", "per_user_series_limit", "__name__", "down"),
+	)
+
+	server, pushed := fakePush(t)
+	cmd := &ReplayCommand{URL: server.URL, From: fromFile, BatchSize: 2, Timeout: time.Second}
+
+	out := &bytes.Buffer{}
+	require.NoError(t, cmd.Run(context.Background(), []string{file}, out))
+
+	// The other series of the request with a rejected series are still ingested.
+	assert.Equal(t, map[string][]string{"tenant-a": {`{__name__="up"}`, `{__name__="down"}`}}, pushed())
+	assert.Contains(t, out.String(), "tenant tenant-a: some series rejected again: per-user series limit exceeded\n")
+	assert.Regexp(t, `requests with rejected series:\s+1\n`, out.String())
+}
+
+func TestReplayCommand_ServerError(t *testing.T) {
+	file := writeRecords(t, record("tenant-a", "per_user_series_limit", "__name__", "up"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	cmd := &ReplayCommand{URL: server.URL, From: fromFile, BatchSize: 10, Timeout: time.Second}
+	err := cmd.Run(context.Background(), []string{file}, io.Discard)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "429")
+}
+
+func TestReplayCommand_DryRun(t *testing.T) {
+	file := writeRecords(t, record("tenant-a", "per_user_series_limit", "__name__", "up"))
+	server, pushed := fakePush(t)
+	cmd := &ReplayCommand{URL: server.URL, From: fromFile, BatchSize: 10, DryRun: true, Timeout: time.Second}
+
+	out := &bytes.Buffer{}
+	require.NoError(t, cmd.Run(context.Background(), []string{file}, out))
+
+	assert.Empty(t, pushed())
+	assert.Contains(t, out.String(), "tenant-a\t{__name__=\"up\"}\t1 samples\n")
+	assert.Regexp(t, `series to push:\s+1\n`, out.String())
+	assert.NotContains(t, out.String(), "requests:")
+}
+
+func TestReplayCommand_Bucket(t *testing.T) {
+	bucketDir := t.TempDir()
+	bkt, err := filesystem.NewBucket(bucketDir)
+	require.NoError(t, err)
+
+	// The records are written by the bucket backend of the dead-letter sink.
+	w := deadletter.NewWriter(deadletter.Config{Backend: deadletter.BackendBucket, BucketPrefix: "dead-letter", QueueSize: 10, FlushPeriod: time.Hour}, bkt, log.NewNopLogger(), nil)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), w))
+	w.Add("tenant-a", "per_user_series_limit", nil, []cortexpb.LabelAdapter{{Name: "__name__", Value: "up"}}, []cortexpb.Sample{{TimestampMs: 1, Value: 1}})
+	w.Add("tenant-b", "per_user_series_limit", nil, []cortexpb.LabelAdapter{{Name: "__name__", Value: "down"}}, []cortexpb.Sample{{TimestampMs: 1, Value: 1}})
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), w))
+
+	server, pushed := fakePush(t)
+	cmd := &ReplayCommand{URL: server.URL, From: fromBucket, BucketPrefix: "dead-letter", Tenant: "tenant-b", BatchSize: 10, Timeout: time.Second}
+	cmd.Bucket = bucket.Config{Backend: bucket.Filesystem}
+	cmd.Bucket.Filesystem.Directory = bucketDir
+
+	require.NoError(t, cmd.Run(context.Background(), nil, io.Discard))
+	assert.Equal(t, map[string][]string{"tenant-b": {`{__name__="down"}`}}, pushed())
+}
+
+func TestReplayCommand_Validate(t *testing.T) {
+	assert.ErrorIs(t, (&ReplayCommand{From: "kafka", BatchSize: 1}).validate(nil), errInvalidFrom)
+	assert.ErrorIs(t, (&ReplayCommand{From: fromFile, BatchSize: 1}).validate(nil), errMissingFiles)
+	assert.ErrorIs(t, (&ReplayCommand{From: fromBucket}).validate(nil), errInvalidBatchSize)
+	assert.NoError(t, (&ReplayCommand{From: fromBucket, BatchSize: 1}).validate(nil))
+}
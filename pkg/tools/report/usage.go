@@ -0,0 +1,306 @@
+package report
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+
+	"github.com/cortexproject/cortex/pkg/util/concurrency"
+
+	"objectstorage/pkg/storage/bucket"
+)
+
+const (
+	formatTable = "table"
+	formatCSV   = "csv"
+)
+
+var (
+	errInvalidFormat      = errors.New("the report format must be table or csv")
+	errInvalidConcurrency = errors.New("the report concurrency must be greater than 0")
+)
+
+// UsageCommand prints the usage of the bucket by tenant: their blocks, the bytes they take,
+// the time range they cover and their compaction levels.
+type UsageCommand struct {
+	Bucket      bucket.Config
+	Format      string
+	Concurrency int
+	Logger      log.Logger
+}
+
+// RegisterFlags registers the flags of the command. The bucket flags are the ones of the
+// blocks storage of the service.
+func (c *UsageCommand) RegisterFlags(f *flag.FlagSet) {
+	c.Bucket.RegisterFlagsWithPrefix("blocks-storage.", f)
+	f.StringVar(&c.Format, "format", formatTable, "Format of the report: table or csv.")
+	f.IntVar(&c.Concurrency, "concurrency", 16, "Number of blocks whose meta is read concurrently.")
+}
+
+// Run reports the usage of the tenants given as arguments, or of all the tenants of the
+// bucket if none is given.
+func (c *UsageCommand) Run(ctx context.Context, args []string, out io.Writer) error {
+	if c.Format != formatTable && c.Format != formatCSV {
+		return errInvalidFormat
+	}
+	if c.Concurrency <= 0 {
+		return errInvalidConcurrency
+	}
+	if err := c.Bucket.Validate(); err != nil {
+		return errors.Wrap(err, "invalid bucket config")
+	}
+
+	logger := c.Logger
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	bkt, err := bucket.NewClient(ctx, c.Bucket, "report", logger, nil)
+	if err != nil {
+		return errors.Wrap(err, "create bucket client")
+	}
+
+	tenants := args
+	if len(tenants) == 0 {
+		if tenants, err = listTenants(ctx, bkt); err != nil {
+			return errors.Wrap(err, "list tenants")
+		}
+	}
+
+	usages := make([]tenantUsage, 0, len(tenants))
+	for _, tenant := range tenants {
+		u, err := scanTenant(ctx, bkt, tenant, c.Concurrency)
+		if err != nil {
+			return errors.Wrapf(err, "scan tenant %s", tenant)
+		}
+		usages = append(usages, u)
+	}
+
+	if c.Format == formatCSV {
+		return writeCSV(out, usages)
+	}
+	return writeTable(out, usages)
+}
+
+// tenantUsage is the usage of the bucket by a tenant. The partial blocks, whose meta is
+// missing, and the blocks marked for deletion are counted apart, and left out of the
+// other stats.
+type tenantUsage struct {
+	tenant            string
+	blocks            int
+	partialBlocks     int
+	markedForDeletion int
+	bytes             int64
+	minTime           int64
+	maxTime           int64
+	// coverage is the time covered by the blocks, the overlaps being counted once.
+	coverage time.Duration
+	// levels is the number of blocks by compaction level.
+	levels map[int]int
+}
+
+func listTenants(ctx context.Context, bkt objstore.Bucket) ([]string, error) {
+	var tenants []string
+	err := bkt.Iter(ctx, "", func(entry string) error {
+		tenants = append(tenants, strings.TrimSuffix(entry, "/"))
+		return nil
+	})
+	sort.Strings(tenants)
+	return tenants, err
+}
+
+func scanTenant(ctx context.Context, bkt objstore.Bucket, tenant string, concurrencyLimit int) (tenantUsage, error) {
+	u := tenantUsage{tenant: tenant, levels: map[int]int{}}
+
+	var ids []interface{}
+	err := bkt.Iter(ctx, tenant+"/", func(entry string) error {
+		if id, err := ulid.Parse(path.Base(entry)); err == nil {
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return u, err
+	}
+
+	var (
+		mtx    sync.Mutex
+		ranges [][2]int64
+	)
+	err = concurrency.ForEach(ctx, ids, concurrencyLimit, func(ctx context.Context, job interface{}) error {
+		dir := path.Join(tenant, job.(ulid.ULID).String())
+
+		marked, err := bkt.Exists(ctx, path.Join(dir, metadata.DeletionMarkFilename))
+		if err != nil {
+			return err
+		}
+		meta, err := readMeta(ctx, bkt, dir)
+		if err != nil {
+			return err
+		}
+		var size int64
+		if meta != nil && !marked {
+			if size, err = blockSize(ctx, bkt, dir, meta); err != nil {
+				return err
+			}
+		}
+
+		mtx.Lock()
+		defer mtx.Unlock()
+		switch {
+		case marked:
+			u.markedForDeletion++
+		case meta == nil:
+			u.partialBlocks++
+		default:
+			if u.blocks == 0 || meta.MinTime < u.minTime {
+				u.minTime = meta.MinTime
+			}
+			if u.blocks == 0 || meta.MaxTime > u.maxTime {
+				u.maxTime = meta.MaxTime
+			}
+			u.blocks++
+			u.bytes += size
+			u.levels[meta.Compaction.Level]++
+			ranges = append(ranges, [2]int64{meta.MinTime, meta.MaxTime})
+		}
+		return nil
+	})
+	u.coverage = coverage(ranges)
+	return u, err
+}
+
+// readMeta returns the meta of the block, or nil if the block is partial.
+func readMeta(ctx context.Context, bkt objstore.Bucket, dir string) (*metadata.Meta, error) {
+	r, err := bkt.Get(ctx, path.Join(dir, metadata.MetaFilename))
+	if bkt.IsObjNotFoundErr(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	meta, err := metadata.Read(r)
+	return meta, errors.Wrapf(err, "read meta of block %s", dir)
+}
+
+// blockSize returns the size of the files of the block, from its meta if they're listed
+// with their size, else from the attributes of the objects.
+func blockSize(ctx context.Context, bkt objstore.Bucket, dir string, meta *metadata.Meta) (int64, error) {
+	var size int64
+	for _, f := range meta.Thanos.Files {
+		if f.SizeBytes == 0 && f.RelPath != metadata.MetaFilename {
+			size = -1
+			break
+		}
+		size += f.SizeBytes
+	}
+	if len(meta.Thanos.Files) > 0 && size >= 0 {
+		return size, nil
+	}
+
+	size = 0
+	err := bkt.Iter(ctx, dir, func(name string) error {
+		attrs, err := bkt.Attributes(ctx, name)
+		if err != nil {
+			return err
+		}
+		size += attrs.Size
+		return nil
+	}, objstore.WithRecursiveIter)
+	return size, err
+}
+
+// coverage returns the time covered by the ranges, the overlaps being counted once.
+func coverage(ranges [][2]int64) time.Duration {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+
+	var covered, end int64
+	for i, r := range ranges {
+		switch {
+		case i == 0 || r[0] >= end:
+			covered += r[1] - r[0]
+			end = r[1]
+		case r[1] > end:
+			covered += r[1] - end
+			end = r[1]
+		}
+	}
+	return time.Duration(covered) * time.Millisecond
+}
+
+var header = []string{"TENANT", "BLOCKS", "PARTIAL", "MARKED FOR DELETION", "BYTES", "MIN TIME", "MAX TIME", "COVERAGE", "LEVELS"}
+
+func (u tenantUsage) row() []string {
+	levels := make([]int, 0, len(u.levels))
+	for level := range u.levels {
+		levels = append(levels, level)
+	}
+	sort.Ints(levels)
+	formatted := make([]string, 0, len(levels))
+	for _, level := range levels {
+		formatted = append(formatted, fmt.Sprintf("%d:%d", level, u.levels[level]))
+	}
+
+	minTime, maxTime := "", ""
+	if u.blocks > 0 {
+		minTime, maxTime = formatTime(u.minTime), formatTime(u.maxTime)
+	}
+	return []string{
+		u.tenant,
+		strconv.Itoa(u.blocks),
+		strconv.Itoa(u.partialBlocks),
+		strconv.Itoa(u.markedForDeletion),
+		strconv.FormatInt(u.bytes, 10),
+		minTime,
+		maxTime,
+		u.coverage.String(),
+		strings.Join(formatted, " "),
+	}
+}
+
+func writeTable(out io.Writer, usages []tenantUsage) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, u := range usages {
+		fmt.Fprintln(tw, strings.Join(u.row(), "\t"))
+	}
+	return tw.Flush()
+}
+
+func writeCSV(out io.Writer, usages []tenantUsage) error {
+	w := csv.NewWriter(out)
+	columns := make([]string, 0, len(header))
+	for _, h := range header {
+		columns = append(columns, strings.ReplaceAll(strings.ToLower(h), " ", "_"))
+	}
+	if err := w.Write(columns); err != nil {
+		return err
+	}
+	for _, u := range usages {
+		if err := w.Write(u.row()); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func formatTime(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339)
+}
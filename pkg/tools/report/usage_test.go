@@ -0,0 +1,149 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/providers/filesystem"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+
+	"objectstorage/pkg/storage/bucket"
+)
+
+func TestScanTenant(t *testing.T) {
+	bkt := objstore.NewInMemBucket()
+	hour := time.Hour.Milliseconds()
+
+	uploadBlock(t, bkt, "user-1", 1, 0, 2*hour, 1, nil)
+	uploadBlock(t, bkt, "user-1", 2, 2*hour, 4*hour, 1, nil)
+	// Overlaps with the first two blocks.
+	uploadBlock(t, bkt, "user-1", 3, 0, 4*hour, 2, []metadata.File{{RelPath: "index", SizeBytes: 1000}, {RelPath: "meta.json"}})
+	// Partial block, without meta.
+	require.NoError(t, bkt.Upload(context.Background(), path.Join("user-1", ulid.MustNew(4, nil).String(), "index"), bytes.NewReader([]byte("index"))))
+	// Block marked for deletion.
+	id := uploadBlock(t, bkt, "user-1", 5, 10*hour, 12*hour, 1, nil)
+	require.NoError(t, bkt.Upload(context.Background(), path.Join("user-1", id.String(), metadata.DeletionMarkFilename), bytes.NewReader([]byte("{}"))))
+
+	u, err := scanTenant(context.Background(), bkt, "user-1", 2)
+	require.NoError(t, err)
+	assert.Equal(t, 3, u.blocks)
+	assert.Equal(t, 1, u.partialBlocks)
+	assert.Equal(t, 1, u.markedForDeletion)
+	assert.Equal(t, int64(0), u.minTime)
+	assert.Equal(t, 4*hour, u.maxTime)
+	assert.Equal(t, 4*time.Hour, u.coverage)
+	assert.Equal(t, map[int]int{1: 2, 2: 1}, u.levels)
+
+	// The size of the first two blocks is the size of their objects, the size of the third
+	// one comes from its meta.
+	expected := int64(1000)
+	for seq := uint64(1); seq <= 2; seq++ {
+		size, err := blockSize(context.Background(), bkt, path.Join("user-1", ulid.MustNew(seq, nil).String()), &metadata.Meta{})
+		require.NoError(t, err)
+		expected += size
+	}
+	assert.Equal(t, expected, u.bytes)
+}
+
+func TestCoverage(t *testing.T) {
+	tests := map[string]struct {
+		ranges   [][2]int64
+		expected time.Duration
+	}{
+		"no range": {
+			expected: 0,
+		},
+		"contiguous ranges": {
+			ranges:   [][2]int64{{1000, 2000}, {0, 1000}},
+			expected: 2 * time.Second,
+		},
+		"gap between ranges": {
+			ranges:   [][2]int64{{0, 1000}, {3000, 4000}},
+			expected: 2 * time.Second,
+		},
+		"overlapping ranges": {
+			ranges:   [][2]int64{{0, 2000}, {1000, 3000}, {1500, 2500}},
+			expected: 3 * time.Second,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, coverage(tc.ranges))
+		})
+	}
+}
+
+func TestUsageCommand(t *testing.T) {
+	dir := t.TempDir()
+	bkt, err := filesystem.NewBucket(dir)
+	require.NoError(t, err)
+	uploadBlock(t, bkt, "user-1", 1, 0, 7200000, 1, []metadata.File{{RelPath: "index", SizeBytes: 1000}})
+	uploadBlock(t, bkt, "user-2", 2, 0, 3600000, 3, []metadata.File{{RelPath: "index", SizeBytes: 500}})
+
+	tests := map[string]struct {
+		format   string
+		args     []string
+		expected string
+	}{
+		"table": {
+			format: formatTable,
+			expected: `TENANT  BLOCKS  PARTIAL  MARKED FOR DELETION  BYTES  MIN TIME              MAX TIME              COVERAGE  LEVELS
+user-1  1       0        0                    1000   1970-01-01T00:00:00Z  1970-01-01T02:00:00Z  2h0m0s    1:1
+user-2  1       0        0                    500    1970-01-01T00:00:00Z  1970-01-01T01:00:00Z  1h0m0s    3:1
+`,
+		},
+		"csv of a single tenant": {
+			format: formatCSV,
+			args:   []string{"user-2"},
+			expected: `tenant,blocks,partial,marked_for_deletion,bytes,min_time,max_time,coverage,levels
+user-2,1,0,0,500,1970-01-01T00:00:00Z,1970-01-01T01:00:00Z,1h0m0s,3:1
+`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cmd := &UsageCommand{
+				Bucket:      bucket.Config{Backend: bucket.Filesystem},
+				Format:      tc.format,
+				Concurrency: 1,
+			}
+			cmd.Bucket.Filesystem.Directory = dir
+
+			out := &bytes.Buffer{}
+			require.NoError(t, cmd.Run(context.Background(), tc.args, out))
+			assert.Equal(t, tc.expected, out.String())
+		})
+	}
+}
+
+func uploadBlock(t *testing.T, bkt objstore.Bucket, tenant string, seq uint64, mint, maxt int64, level int, files []metadata.File) ulid.ULID {
+	id := ulid.MustNew(seq, nil)
+	meta := metadata.Meta{
+		BlockMeta: tsdb.BlockMeta{
+			ULID:       id,
+			MinTime:    mint,
+			MaxTime:    maxt,
+			Version:    metadata.TSDBVersion1,
+			Compaction: tsdb.BlockMetaCompaction{Level: level},
+		},
+		Thanos: metadata.Thanos{Version: metadata.ThanosVersion1, Files: files},
+	}
+	buf, err := json.Marshal(meta)
+	require.NoError(t, err)
+
+	dir := path.Join(tenant, id.String())
+	require.NoError(t, bkt.Upload(context.Background(), path.Join(dir, metadata.MetaFilename), bytes.NewReader(buf)))
+	require.NoError(t, bkt.Upload(context.Background(), path.Join(dir, "index"), bytes.NewReader([]byte("index"))))
+	return id
+}
@@ -0,0 +1,259 @@
+package smoketest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/golang/snappy"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+
+	"objectstorage/pkg/storage/bucket"
+)
+
+const (
+	waitForHead  = "head"
+	waitForBlock = "block"
+
+	metricName = "smoke_test"
+	runLabel   = "run"
+)
+
+var errInvalidWaitFor = errors.New("the smoke test must wait for the series in the head or a block")
+
+// SmokeTestCommand pushes a series unique to the run through the push path, then waits for
+// it to be queryable from the head or to be shipped to the bucket in a block. It fails if the
+// series doesn't show up before the timeout, which makes it usable as a post-deploy gate.
+type SmokeTestCommand struct {
+	URL          string
+	Tenant       string
+	WaitFor      string
+	Timeout      time.Duration
+	PollInterval time.Duration
+	Bucket       bucket.Config
+	Logger       log.Logger
+}
+
+// RegisterFlags registers the flags of the command. The bucket flags are the ones of the
+// blocks storage of the service, used when waiting for a block.
+func (c *SmokeTestCommand) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&c.URL, "url", "http://localhost:8080", "Base URL of the ingester, serving the push and head APIs.")
+	f.StringVar(&c.Tenant, "tenant", "smoke-test", "Tenant the series is pushed for.")
+	f.StringVar(&c.WaitFor, "wait-for", waitForHead, "Where the series must show up: head, to be queryable from the head API, or block, to be shipped to the bucket in a block.")
+	f.DurationVar(&c.Timeout, "timeout", 5*time.Minute, "How long to wait for the series to show up. Waiting for a block takes at least the TSDB block range.")
+	f.DurationVar(&c.PollInterval, "poll-interval", 5*time.Second, "How frequently to check whether the series showed up.")
+	c.Bucket.RegisterFlagsWithPrefix("blocks-storage.", f)
+}
+
+// Run runs the smoke test, printing its steps.
+func (c *SmokeTestCommand) Run(ctx context.Context, _ []string, out io.Writer) error {
+	if c.WaitFor != waitForHead && c.WaitFor != waitForBlock {
+		return errInvalidWaitFor
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+
+	var found func(ctx context.Context, run string, ts int64) (bool, error)
+	if c.WaitFor == waitForHead {
+		found = c.inHead
+	} else {
+		if err := c.Bucket.Validate(); err != nil {
+			return errors.Wrap(err, "invalid bucket config")
+		}
+		bkt, err := bucket.NewClient(ctx, c.Bucket, "smoke-test", logger, nil)
+		if err != nil {
+			return errors.Wrap(err, "create bucket client")
+		}
+		found = (&blockFinder{bkt: bkt, tenant: c.Tenant, logger: logger, checked: map[ulid.ULID]struct{}{}}).find
+	}
+
+	start := time.Now()
+	run := strconv.FormatInt(start.UnixNano(), 36)
+	ts := start.UnixMilli()
+	if err := c.push(ctx, run, ts); err != nil {
+		return errors.Wrap(err, "push series")
+	}
+	fmt.Fprintf(out, "pushed %s{%s=%q} for tenant %s\n", metricName, runLabel, run, c.Tenant)
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	ticker := time.NewTicker(c.PollInterval)
+	defer ticker.Stop()
+	for {
+		ok, err := found(ctx, run, ts)
+		if err != nil {
+			fmt.Fprintf(out, "check failed, retrying: %v\n", err)
+		}
+		if ok {
+			fmt.Fprintf(out, "series found in the %s after %s\n", c.WaitFor, time.Since(start).Round(time.Millisecond))
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return errors.Errorf("series not found in the %s after %s", c.WaitFor, time.Since(start).Round(time.Millisecond))
+		}
+	}
+}
+
+func (c *SmokeTestCommand) push(ctx context.Context, run string, ts int64) error {
+	req := cortexpb.WriteRequest{
+		Source: cortexpb.API,
+		Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+			Labels:  []cortexpb.LabelAdapter{{Name: "__name__", Value: metricName}, {Name: runLabel, Value: run}},
+			Samples: []cortexpb.Sample{{TimestampMs: ts, Value: 1}},
+		}}},
+	}
+	buf, err := req.Marshal()
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL+"/api/v1/push", bytes.NewReader(snappy.Encode(nil, buf)))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	_, err = c.do(httpReq)
+	return err
+}
+
+// inHead returns whether the series is returned by the series endpoint of the head API.
+func (c *SmokeTestCommand) inHead(ctx context.Context, run string, ts int64) (bool, error) {
+	params := url.Values{}
+	params.Set("match[]", fmt.Sprintf("%s{%s=%q}", metricName, runLabel, run))
+	params.Set("start", strconv.FormatFloat(float64(ts)/1000, 'f', 3, 64))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL+"/api/v1/series?"+params.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+	body, err := c.do(req)
+	if err != nil {
+		return false, err
+	}
+
+	var resp struct {
+		Data []map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return false, errors.Wrap(err, "decode series response")
+	}
+	return len(resp.Data) > 0, nil
+}
+
+func (c *SmokeTestCommand) do(req *http.Request) ([]byte, error) {
+	req.Header.Set("X-Scope-OrgID", c.Tenant)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, errors.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, bytes.TrimSpace(body))
+	}
+	return body, nil
+}
+
+// blockFinder looks up the series in the blocks of the tenant covering the sample, shipped
+// after the run started. The blocks are only checked once.
+type blockFinder struct {
+	bkt     objstore.Bucket
+	tenant  string
+	logger  log.Logger
+	checked map[ulid.ULID]struct{}
+}
+
+func (f *blockFinder) find(ctx context.Context, run string, ts int64) (bool, error) {
+	var ids []ulid.ULID
+	err := f.bkt.Iter(ctx, f.tenant+"/", func(entry string) error {
+		id, err := ulid.Parse(path.Base(entry))
+		if err != nil {
+			return nil
+		}
+		if _, ok := f.checked[id]; !ok && id.Time() >= uint64(ts) {
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "list blocks")
+	}
+
+	for _, id := range ids {
+		dir := path.Join(f.tenant, id.String())
+		r, err := f.bkt.Get(ctx, path.Join(dir, metadata.MetaFilename))
+		if f.bkt.IsObjNotFoundErr(err) {
+			// The block is still being uploaded.
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		meta, err := metadata.Read(r)
+		if err != nil {
+			return false, errors.Wrapf(err, "read meta of block %s", id)
+		}
+		f.checked[id] = struct{}{}
+		if ts < meta.MinTime || ts >= meta.MaxTime {
+			continue
+		}
+
+		ok, err := f.inBlock(ctx, dir, run)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// inBlock downloads the index of the block and looks up the series in its postings.
+func (f *blockFinder) inBlock(ctx context.Context, dir, run string) (bool, error) {
+	tmp, err := os.MkdirTemp("", "smoke-test")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(tmp)
+
+	indexPath := filepath.Join(tmp, "index")
+	if err := objstore.DownloadFile(ctx, f.logger, f.bkt, path.Join(dir, "index"), indexPath); err != nil {
+		return false, errors.Wrapf(err, "download index of block %s", dir)
+	}
+	r, err := index.NewFileReader(indexPath)
+	if err != nil {
+		return false, errors.Wrapf(err, "open index of block %s", dir)
+	}
+	defer r.Close()
+
+	p, err := r.Postings(runLabel, run)
+	if err != nil {
+		return false, err
+	}
+	return p.Next(), p.Err()
+}
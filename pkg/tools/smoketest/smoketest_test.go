@@ -0,0 +1,144 @@
+package smoketest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/providers/filesystem"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+
+	"objectstorage/pkg/ingester/flush"
+	"objectstorage/pkg/storage/bucket"
+)
+
+// fakeIngester serves the push and series endpoints, calling onPush with each pushed series.
+func fakeIngester(t *testing.T, onPush func(tenant string, ts cortexpb.PreallocTimeseries)) (*httptest.Server, func() []map[string]string) {
+	var (
+		mtx    sync.Mutex
+		series []map[string]string
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/push", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		buf, err := snappy.Decode(nil, body)
+		require.NoError(t, err)
+		var req cortexpb.WriteRequest
+		require.NoError(t, req.Unmarshal(buf))
+
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, ts := range req.Timeseries {
+			lbls := map[string]string{}
+			for _, l := range ts.Labels {
+				lbls[l.Name] = l.Value
+			}
+			series = append(series, lbls)
+			onPush(r.Header.Get("X-Scope-OrgID"), ts)
+		}
+	})
+	mux.HandleFunc("/api/v1/series", func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		assert.Equal(t, "smoke-test", r.Header.Get("X-Scope-OrgID"))
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "data": series}))
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, func() []map[string]string {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return series
+	}
+}
+
+func TestSmokeTestCommand_Head(t *testing.T) {
+	server, pushed := fakeIngester(t, func(string, cortexpb.PreallocTimeseries) {})
+
+	cmd := &SmokeTestCommand{URL: server.URL, Tenant: "smoke-test", WaitFor: waitForHead, Timeout: time.Second, PollInterval: 10 * time.Millisecond}
+	out := &bytes.Buffer{}
+	require.NoError(t, cmd.Run(context.Background(), nil, out))
+
+	require.Len(t, pushed(), 1)
+	assert.Equal(t, metricName, pushed()[0]["__name__"])
+	assert.Regexp(t, `^pushed smoke_test\{run="\w+"\} for tenant smoke-test\nseries found in the head after \S+\n$`, out.String())
+}
+
+func TestSmokeTestCommand_Block(t *testing.T) {
+	bucketDir := t.TempDir()
+	bkt, err := filesystem.NewBucket(bucketDir)
+	require.NoError(t, err)
+
+	// The series is shipped in a block as soon as it's pushed.
+	server, _ := fakeIngester(t, func(tenant string, ts cortexpb.PreallocTimeseries) {
+		db, err := tsdb.Open(t.TempDir(), nil, nil, tsdb.DefaultOptions(), nil)
+		require.NoError(t, err)
+		defer db.Close()
+
+		sample := ts.Samples[0]
+		app := db.Appender(context.Background())
+		_, err = app.Append(0, cortexpb.FromLabelAdaptersToLabels(ts.Labels), sample.TimestampMs, sample.Value)
+		require.NoError(t, err)
+		require.NoError(t, app.Commit())
+
+		dir := t.TempDir()
+		id, err := flush.WriteBlock(context.Background(), tsdb.NewRangeHead(db.Head(), sample.TimestampMs, sample.TimestampMs), sample.TimestampMs, sample.TimestampMs+1, dir, nil, log.NewNopLogger())
+		require.NoError(t, err)
+		require.NoError(t, objstore.UploadDir(context.Background(), log.NewNopLogger(), bkt, filepath.Join(dir, id.String()), path.Join(tenant, id.String())))
+	})
+
+	cmd := &SmokeTestCommand{URL: server.URL, Tenant: "smoke-test", WaitFor: waitForBlock, Timeout: 5 * time.Second, PollInterval: 10 * time.Millisecond}
+	cmd.Bucket = bucket.Config{Backend: bucket.Filesystem}
+	cmd.Bucket.Filesystem.Directory = bucketDir
+
+	out := &bytes.Buffer{}
+	require.NoError(t, cmd.Run(context.Background(), nil, out))
+	assert.Contains(t, out.String(), "series found in the block after")
+}
+
+func TestSmokeTestCommand_Timeout(t *testing.T) {
+	// The series pushed are never returned.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/series" {
+			_, _ = w.Write([]byte(`{"status": "success", "data": []}`))
+		}
+	}))
+	defer server.Close()
+
+	cmd := &SmokeTestCommand{URL: server.URL, Tenant: "smoke-test", WaitFor: waitForHead, Timeout: 50 * time.Millisecond, PollInterval: 10 * time.Millisecond}
+	err := cmd.Run(context.Background(), nil, io.Discard)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "series not found in the head")
+}
+
+func TestSmokeTestCommand_PushFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "ingester is read-only", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cmd := &SmokeTestCommand{URL: server.URL, Tenant: "smoke-test", WaitFor: waitForHead, Timeout: time.Second, PollInterval: 10 * time.Millisecond}
+	err := cmd.Run(context.Background(), nil, io.Discard)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ingester is read-only")
+
+	cmd.WaitFor = "query"
+	assert.Equal(t, errInvalidWaitFor, cmd.Run(context.Background(), nil, io.Discard))
+}
@@ -0,0 +1,153 @@
+package unixsocket
+
+import (
+	"context"
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+var errInvalidMode = errors.New("the unix socket mode must be an octal file mode, such as 0660")
+
+// Config holds the configuration of the unix socket listeners, served in addition to the TCP
+// listeners of the HTTP and gRPC servers.
+type Config struct {
+	HTTPPath string `yaml:"http_path"`
+	GRPCPath string `yaml:"grpc_path"`
+	Mode     string `yaml:"mode"`
+}
+
+// RegisterFlags registers the unix socket listeners flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.HTTPPath, "server.http-unix-socket-path", "", "Path of the unix socket the HTTP server listens on, in addition to the TCP listener, for the sidecars in the same pod. The requests over the socket are accepted whatever -server.http-allowed-cidrs, the socket mode restricting who can connect. Empty to disable.")
+	f.StringVar(&cfg.GRPCPath, "server.grpc-unix-socket-path", "", "Path of the unix socket the gRPC server listens on, in addition to the TCP listener, for the sidecars in the same pod. The requests over the socket are accepted whatever -server.grpc-allowed-cidrs, the socket mode restricting who can connect. Empty to disable.")
+	f.StringVar(&cfg.Mode, "server.unix-socket-mode", "0660", "File mode of the unix sockets, in octal.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled() {
+		return nil
+	}
+	if _, err := cfg.fileMode(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Enabled returns whether any unix socket listener is enabled.
+func (cfg *Config) Enabled() bool {
+	return cfg.HTTPPath != "" || cfg.GRPCPath != ""
+}
+
+func (cfg *Config) fileMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(cfg.Mode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, errInvalidMode
+	}
+	return os.FileMode(mode), nil
+}
+
+// Listeners serves the HTTP and gRPC servers on the unix sockets. The servers are shut down,
+// along with their connections over the sockets, by the owner of the servers.
+type Listeners struct {
+	services.Service
+
+	cfg    Config
+	http   *http.Server
+	grpc   *grpc.Server
+	logger log.Logger
+
+	httpListener net.Listener
+	grpcListener net.Listener
+}
+
+// NewListeners makes new Listeners. The config must be valid.
+func NewListeners(cfg Config, httpServer *http.Server, grpcServer *grpc.Server, logger log.Logger) *Listeners {
+	l := &Listeners{
+		cfg:    cfg,
+		http:   httpServer,
+		grpc:   grpcServer,
+		logger: logger,
+	}
+	l.Service = services.NewBasicService(l.starting, l.running, l.stopping)
+	return l
+}
+
+func (l *Listeners) starting(_ context.Context) error {
+	mode, err := l.cfg.fileMode()
+	if err != nil {
+		return err
+	}
+
+	if l.cfg.HTTPPath != "" {
+		if l.httpListener, err = listen(l.cfg.HTTPPath, mode); err != nil {
+			return errors.Wrap(err, "listen on the HTTP unix socket")
+		}
+		level.Info(l.logger).Log("msg", "HTTP server listening on unix socket", "path", l.cfg.HTTPPath)
+	}
+	if l.cfg.GRPCPath != "" {
+		if l.grpcListener, err = listen(l.cfg.GRPCPath, mode); err != nil {
+			return errors.Wrap(err, "listen on the gRPC unix socket")
+		}
+		level.Info(l.logger).Log("msg", "gRPC server listening on unix socket", "path", l.cfg.GRPCPath)
+	}
+	return nil
+}
+
+// listen listens on the unix socket, replacing the socket left by a previous process.
+func listen(path string, mode os.FileMode) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func (l *Listeners) running(ctx context.Context) error {
+	errs := make(chan error, 2)
+	if l.httpListener != nil {
+		go func() {
+			errs <- errors.Wrap(l.http.Serve(l.httpListener), "HTTP unix socket listener")
+		}()
+	}
+	if l.grpcListener != nil {
+		go func() {
+			errs <- errors.Wrap(l.grpc.Serve(l.grpcListener), "gRPC unix socket listener")
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errs:
+		return err
+	}
+}
+
+// stopping closes the listeners, which removes the sockets.
+func (l *Listeners) stopping(_ error) error {
+	for _, listener := range []net.Listener{l.httpListener, l.grpcListener} {
+		if listener != nil {
+			_ = listener.Close()
+		}
+	}
+	return nil
+}
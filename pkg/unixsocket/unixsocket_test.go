@@ -0,0 +1,102 @@
+package unixsocket
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		cfg      Config
+		expected error
+	}{
+		"disabled": {
+			cfg: Config{Mode: "invalid"},
+		},
+		"enabled": {
+			cfg: Config{HTTPPath: "/run/http.sock", Mode: "0660"},
+		},
+		"invalid mode": {
+			cfg:      Config{GRPCPath: "/run/grpc.sock", Mode: "rw-rw----"},
+			expected: errInvalidMode,
+		},
+		"mode out of range": {
+			cfg:      Config{GRPCPath: "/run/grpc.sock", Mode: "1777"},
+			expected: errInvalidMode,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.cfg.Validate())
+		})
+	}
+}
+
+func TestListeners(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		HTTPPath: filepath.Join(dir, "http.sock"),
+		GRPCPath: filepath.Join(dir, "grpc.sock"),
+		Mode:     "0600",
+	}
+	// A socket left by a previous process is replaced.
+	require.NoError(t, os.WriteFile(cfg.HTTPPath, nil, 0o600))
+
+	httpServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})}
+	grpcServer := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(grpcServer, health.NewServer())
+	defer grpcServer.Stop()
+
+	ctx := context.Background()
+	l := NewListeners(cfg, httpServer, grpcServer, log.NewNopLogger())
+	require.NoError(t, services.StartAndAwaitRunning(ctx, l))
+
+	for _, path := range []string{cfg.HTTPPath, cfg.GRPCPath} {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.ModeSocket|0o600, info.Mode())
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", cfg.HTTPPath)
+		},
+	}}
+	resp, err := client.Get("http://localhost/")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "ok", string(body))
+
+	conn, err := grpc.Dial("unix://"+cfg.GRPCPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	res, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, res.Status)
+
+	require.NoError(t, services.StopAndAwaitTerminated(ctx, l))
+	for _, path := range []string{cfg.HTTPPath, cfg.GRPCPath} {
+		_, err := os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+	}
+}
@@ -0,0 +1,256 @@
+package usagestats
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/version"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+// SeedFileName is the name of the file persisting the anonymous instance ID.
+const SeedFileName = "usage_stats_seed.json"
+
+var (
+	errMissingEndpoint = errors.New("the usage statistics endpoint is required when the reporting is enabled")
+	errInvalidInterval = errors.New("the usage statistics interval must be greater than 0")
+)
+
+// Config holds the configuration of the usage statistics reporter.
+type Config struct {
+	Enabled  bool          `yaml:"enabled"`
+	Endpoint string        `yaml:"endpoint"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// RegisterFlags registers the usage statistics flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "usage-stats.enabled", false, "True to periodically report anonymous usage statistics: the version, the enabled modules and the order of magnitude of the number of tenants and series. No tenant ID, label or address is ever reported. When false, nothing is collected nor sent.")
+	f.StringVar(&cfg.Endpoint, "usage-stats.endpoint", "", "URL the usage statistics are posted to.")
+	f.DurationVar(&cfg.Interval, "usage-stats.interval", 4*time.Hour, "How frequently the usage statistics are reported.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Endpoint == "" {
+		return errMissingEndpoint
+	}
+	if cfg.Interval <= 0 {
+		return errInvalidInterval
+	}
+	return nil
+}
+
+// ActiveSeries returns the number of series recently pushed by each tenant. It's implemented
+// by the limits.Enforcer.
+type ActiveSeries interface {
+	ActiveSeriesByUser() map[string]int
+}
+
+// Report is the anonymous usage report.
+type Report struct {
+	InstanceID       string    `json:"instance_id"`
+	Time             time.Time `json:"time"`
+	Version          string    `json:"version"`
+	GoVersion        string    `json:"go_version"`
+	OS               string    `json:"os"`
+	Arch             string    `json:"arch"`
+	Targets          []string  `json:"targets"`
+	Modules          []string  `json:"modules"`
+	TenantsMagnitude int       `json:"tenants_magnitude"`
+	SeriesMagnitude  int       `json:"series_magnitude"`
+}
+
+type seed struct {
+	InstanceID string    `json:"instance_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Reporter periodically posts the anonymous usage report. The instance ID is random and
+// persisted in the data directory, so that the reports of an instance can be correlated
+// without identifying the deployment.
+type Reporter struct {
+	services.Service
+
+	cfg          Config
+	seedPath     string
+	targets      []string
+	modules      func() []string
+	activeSeries ActiveSeries
+	client       *http.Client
+	logger       log.Logger
+
+	instanceID string
+
+	reports       prometheus.Counter
+	reportsFailed prometheus.Counter
+}
+
+// NewReporter makes a new Reporter. The modules function returns the modules running, and
+// the activeSeries may be nil.
+func NewReporter(cfg Config, dataDir string, targets []string, modules func() []string, activeSeries ActiveSeries, logger log.Logger, reg prometheus.Registerer) *Reporter {
+	r := &Reporter{
+		cfg:          cfg,
+		seedPath:     filepath.Join(dataDir, SeedFileName),
+		targets:      targets,
+		modules:      modules,
+		activeSeries: activeSeries,
+		client:       &http.Client{Timeout: 30 * time.Second},
+		logger:       logger,
+		reports: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_usage_stats_reports_total",
+			Help: "Total number of usage statistics reports sent.",
+		}),
+		reportsFailed: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_usage_stats_reports_failed_total",
+			Help: "Total number of usage statistics reports failed to be sent.",
+		}),
+	}
+
+	r.Service = services.NewTimerService(cfg.Interval, r.starting, r.iteration, nil)
+	return r
+}
+
+func (r *Reporter) starting(_ context.Context) error {
+	var err error
+	if r.instanceID, err = loadOrCreateSeed(r.seedPath); err != nil {
+		// Not fatal: the report is only sent with a persisted ID, to not inflate the
+		// number of instances on each restart.
+		level.Warn(r.logger).Log("msg", "failed to load the usage statistics seed", "path", r.seedPath, "err", err)
+	}
+	return nil
+}
+
+func (r *Reporter) iteration(ctx context.Context) error {
+	if r.instanceID == "" {
+		return nil
+	}
+
+	// A failed report is not retried before the next interval, and never fails the service.
+	if err := r.send(ctx, r.buildReport(time.Now())); err != nil {
+		r.reportsFailed.Inc()
+		level.Debug(r.logger).Log("msg", "failed to send the usage statistics report", "err", err)
+		return nil
+	}
+
+	r.reports.Inc()
+	return nil
+}
+
+func (r *Reporter) buildReport(now time.Time) Report {
+	report := Report{
+		InstanceID: r.instanceID,
+		Time:       now.UTC(),
+		Version:    version.Version,
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		Targets:    r.targets,
+	}
+
+	if r.modules != nil {
+		report.Modules = r.modules()
+		sort.Strings(report.Modules)
+	}
+
+	if r.activeSeries != nil {
+		series := 0
+		tenants := r.activeSeries.ActiveSeriesByUser()
+		for _, count := range tenants {
+			series += count
+		}
+		report.TenantsMagnitude = magnitude(len(tenants))
+		report.SeriesMagnitude = magnitude(series)
+	}
+	return report
+}
+
+func (r *Reporter) send(ctx context.Context, report Report) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// magnitude returns the order of magnitude of n, the largest power of 10 lower or equal to
+// it, so that the exact counts are not reported.
+func magnitude(n int) int {
+	if n <= 0 {
+		return 0
+	}
+
+	m := 1
+	for m <= n/10 {
+		m *= 10
+	}
+	return m
+}
+
+// loadOrCreateSeed returns the instance ID persisted at the input path, creating it if
+// it doesn't exist.
+func loadOrCreateSeed(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		var s seed
+		if err := json.Unmarshal(data, &s); err != nil || s.InstanceID == "" {
+			return "", errors.Errorf("malformed usage statistics seed file %s", path)
+		}
+		return s.InstanceID, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	s := seed{InstanceID: hex.EncodeToString(id), CreatedAt: time.Now().UTC()}
+	if data, err = json.Marshal(s); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+	return s.InstanceID, nil
+}
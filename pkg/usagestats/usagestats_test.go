@@ -0,0 +1,90 @@
+package usagestats
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type activeSeriesMock map[string]int
+
+func (m activeSeriesMock) ActiveSeriesByUser() map[string]int { return m }
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		cfg      Config
+		expected error
+	}{
+		"disabled": {
+			cfg:      Config{},
+			expected: nil,
+		},
+		"enabled": {
+			cfg:      Config{Enabled: true, Endpoint: "http://localhost/report", Interval: time.Hour},
+			expected: nil,
+		},
+		"enabled without endpoint": {
+			cfg:      Config{Enabled: true, Interval: time.Hour},
+			expected: errMissingEndpoint,
+		},
+		"enabled with invalid interval": {
+			cfg:      Config{Enabled: true, Endpoint: "http://localhost/report"},
+			expected: errInvalidInterval,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, tc.cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+func TestMagnitude(t *testing.T) {
+	for input, expected := range map[int]int{0: 0, 1: 1, 9: 1, 10: 10, 999: 100, 12345: 10000} {
+		assert.Equal(t, expected, magnitude(input), "input: %d", input)
+	}
+}
+
+func TestLoadOrCreateSeed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", SeedFileName)
+
+	created, err := loadOrCreateSeed(path)
+	require.NoError(t, err)
+	require.NotEmpty(t, created)
+
+	loaded, err := loadOrCreateSeed(path)
+	require.NoError(t, err)
+	assert.Equal(t, created, loaded)
+}
+
+func TestReporter_Iteration(t *testing.T) {
+	var received Report
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := Config{Enabled: true, Endpoint: srv.URL, Interval: time.Hour}
+	modules := func() []string { return []string{"server", "push"} }
+	series := activeSeriesMock{"user-1": 1500, "user-2": 20}
+
+	r := NewReporter(cfg, t.TempDir(), []string{"all"}, modules, series, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, r.starting(context.Background()))
+	require.NoError(t, r.iteration(context.Background()))
+
+	assert.Equal(t, r.instanceID, received.InstanceID)
+	assert.Equal(t, []string{"all"}, received.Targets)
+	assert.Equal(t, []string{"push", "server"}, received.Modules)
+	assert.Equal(t, 1, received.TenantsMagnitude)
+	assert.Equal(t, 1000, received.SeriesMagnitude)
+}
@@ -0,0 +1,183 @@
+package dnsaddr
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thanos-io/thanos/pkg/discovery/dns"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+// The prefix of the addresses resolved through an A/AAAA lookup.
+const prefixA = string(dns.A) + "+"
+
+// IsDynamic returns true if any of the addresses is prefixed with dns+, dnssrv+ or
+// dnssrvnoa+, and needs to be resolved.
+func IsDynamic(addrs []string) bool {
+	for _, addr := range addrs {
+		if dns.IsDynamicNode(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the addresses, with the ones prefixed with dns+, dnssrv+ or dnssrvnoa+
+// resolved through the respective DNS lookup. The addresses without a prefix are returned
+// as is.
+func Resolve(ctx context.Context, addrs []string, logger log.Logger) ([]string, error) {
+	return newResolveFunc(logger, nil)(ctx, addrs)
+}
+
+type resolveFunc func(ctx context.Context, addrs []string) ([]string, error)
+
+func newResolveFunc(logger log.Logger, reg prometheus.Registerer) resolveFunc {
+	provider := dns.NewProvider(logger, reg, dns.GolangResolverType)
+	return func(ctx context.Context, addrs []string) ([]string, error) {
+		// The provider keeps the previous addresses of the failed lookups.
+		err := provider.Resolve(ctx, addrs)
+		resolved := provider.Addresses()
+		sort.Strings(resolved)
+		if len(resolved) == 0 && err == nil {
+			err = errors.Errorf("no address resolved from %s", strings.Join(addrs, ","))
+		}
+		return resolved, err
+	}
+}
+
+// Watcher periodically re-resolves the addresses, and notifies the client of the changes,
+// so that the servers behind a DNS name can be replaced without restarting.
+type Watcher struct {
+	services.Service
+
+	addrs    []string
+	resolve  resolveFunc
+	onChange func([]string)
+	logger   log.Logger
+
+	mtx      sync.Mutex
+	resolved []string
+}
+
+// NewWatcher makes a new Watcher re-resolving the addresses every interval. Resolve is
+// expected to be called once before the Watcher is started, to set up the client.
+func NewWatcher(name string, addrs []string, interval time.Duration, onChange func([]string), logger log.Logger, reg prometheus.Registerer) *Watcher {
+	reg = prometheus.WrapRegistererWithPrefix("cortex_", prometheus.WrapRegistererWith(prometheus.Labels{"name": name}, reg))
+	logger = log.With(logger, "name", name)
+
+	w := &Watcher{
+		addrs:    addrs,
+		resolve:  newResolveFunc(logger, reg),
+		onChange: onChange,
+		logger:   logger,
+	}
+	w.Service = services.NewTimerService(interval, nil, w.iteration, nil)
+	return w
+}
+
+// Resolve resolves the addresses, and returns them.
+func (w *Watcher) Resolve(ctx context.Context) ([]string, error) {
+	resolved, err := w.resolve(ctx, w.addrs)
+	if err != nil && len(resolved) == 0 {
+		return nil, errors.Wrap(err, "resolve addresses")
+	}
+	if err != nil {
+		level.Warn(w.logger).Log("msg", "failed to resolve some addresses, keeping their previous addresses", "err", err)
+	}
+
+	w.mtx.Lock()
+	w.resolved = resolved
+	w.mtx.Unlock()
+	return resolved, nil
+}
+
+// Addresses returns the addresses as of the last resolution.
+func (w *Watcher) Addresses() []string {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.resolved
+}
+
+func (w *Watcher) iteration(ctx context.Context) error {
+	previous := w.Addresses()
+	resolved, err := w.Resolve(ctx)
+	if err != nil {
+		// Keep the previous addresses until the next resolution.
+		level.Warn(w.logger).Log("msg", "failed to re-resolve addresses", "err", err)
+		return nil
+	}
+	if equal(previous, resolved) {
+		return nil
+	}
+
+	level.Info(w.logger).Log("msg", "resolved addresses changed", "addresses", strings.Join(resolved, ","))
+	w.onChange(resolved)
+	return nil
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ResolveKV resolves the prefixed Consul and etcd addresses of the KV store config, once.
+// The dns+ prefix is removed rather than resolved, since both clients resolve the host name
+// again on each new connection, while the SRV lookups are only resolved at startup.
+func ResolveKV(ctx context.Context, cfg *kv.Config, logger log.Logger) error {
+	store := &cfg.StoreConfig
+	if dns.IsDynamicNode(store.Consul.Host) {
+		addrs, err := resolveKVAddrs(ctx, []string{store.Consul.Host}, logger)
+		if err != nil {
+			return errors.Wrap(err, "resolve the Consul host")
+		}
+		// The Consul client only supports a single host.
+		store.Consul.Host = addrs[0]
+	}
+	if IsDynamic(store.Etcd.Endpoints) {
+		addrs, err := resolveKVAddrs(ctx, store.Etcd.Endpoints, logger)
+		if err != nil {
+			return errors.Wrap(err, "resolve the etcd endpoints")
+		}
+		store.Etcd.Endpoints = addrs
+	}
+	return nil
+}
+
+func resolveKVAddrs(ctx context.Context, addrs []string, logger log.Logger) ([]string, error) {
+	var srv []string
+	resolved := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		switch {
+		case strings.HasPrefix(addr, prefixA):
+			resolved = append(resolved, strings.TrimPrefix(addr, prefixA))
+		case dns.IsDynamicNode(addr):
+			srv = append(srv, addr)
+		default:
+			resolved = append(resolved, addr)
+		}
+	}
+	if len(srv) > 0 {
+		addrs, err := Resolve(ctx, srv, logger)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, addrs...)
+	}
+	return resolved, nil
+}
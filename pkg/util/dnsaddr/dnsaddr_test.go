@@ -0,0 +1,78 @@
+package dnsaddr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+)
+
+func TestIsDynamic(t *testing.T) {
+	assert.False(t, IsDynamic(nil))
+	assert.False(t, IsDynamic([]string{"kafka-0:9092", "kafka-1:9092"}))
+	assert.True(t, IsDynamic([]string{"kafka-0:9092", "dns+kafka:9092"}))
+	assert.True(t, IsDynamic([]string{"dnssrv+_kafka._tcp.kafka"}))
+}
+
+func TestWatcher(t *testing.T) {
+	var (
+		results = [][]string{
+			{"10.0.0.1:9092", "10.0.0.2:9092"},
+			{"10.0.0.1:9092", "10.0.0.2:9092"},
+			nil,
+			{"10.0.0.1:9092", "10.0.0.3:9092"},
+		}
+		changes [][]string
+	)
+
+	w := NewWatcher("test", []string{"dns+kafka:9092"}, time.Minute, func(addrs []string) {
+		changes = append(changes, addrs)
+	}, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	w.resolve = func(_ context.Context, addrs []string) ([]string, error) {
+		assert.Equal(t, []string{"dns+kafka:9092"}, addrs)
+		res := results[0]
+		results = results[1:]
+		if res == nil {
+			return nil, errors.New("lookup failed")
+		}
+		return res, nil
+	}
+
+	ctx := context.Background()
+	addrs, err := w.Resolve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:9092", "10.0.0.2:9092"}, addrs)
+
+	// The unchanged addresses and the failed lookups aren't notified.
+	require.NoError(t, w.iteration(ctx))
+	require.NoError(t, w.iteration(ctx))
+	assert.Empty(t, changes)
+	assert.Equal(t, []string{"10.0.0.1:9092", "10.0.0.2:9092"}, w.Addresses())
+
+	require.NoError(t, w.iteration(ctx))
+	assert.Equal(t, [][]string{{"10.0.0.1:9092", "10.0.0.3:9092"}}, changes)
+	assert.Equal(t, []string{"10.0.0.1:9092", "10.0.0.3:9092"}, w.Addresses())
+}
+
+func TestResolveKV(t *testing.T) {
+	cfg := kv.Config{Store: "consul"}
+	cfg.Consul.Host = "dns+consul.service:8500"
+	cfg.Etcd.Endpoints = []string{"etcd-0:2379", "dns+etcd:2379"}
+
+	require.NoError(t, ResolveKV(context.Background(), &cfg, log.NewNopLogger()))
+	// The host names are resolved again by the clients on each connection.
+	assert.Equal(t, "consul.service:8500", cfg.Consul.Host)
+	assert.Equal(t, []string{"etcd-0:2379", "etcd:2379"}, cfg.Etcd.Endpoints)
+
+	// The static addresses are left as is.
+	cfg.Consul.Host = "localhost:8500"
+	require.NoError(t, ResolveKV(context.Background(), &cfg, log.NewNopLogger()))
+	assert.Equal(t, "localhost:8500", cfg.Consul.Host)
+}
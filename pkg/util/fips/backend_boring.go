@@ -0,0 +1,9 @@
+//go:build boringcrypto
+
+package fips
+
+import "crypto/boring"
+
+func backendEnabled() bool {
+	return boring.Enabled()
+}
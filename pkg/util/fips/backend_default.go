@@ -0,0 +1,7 @@
+//go:build !boringcrypto
+
+package fips
+
+func backendEnabled() bool {
+	return false
+}
@@ -0,0 +1,100 @@
+package fips
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/weaveworks/common/server"
+)
+
+var (
+	errBackendNotFIPS      = errors.New("FIPS-only mode requires a binary built with a FIPS 140 validated crypto backend (GOEXPERIMENT=boringcrypto)")
+	errMinTLSVersion       = errors.New("FIPS-only mode requires the server TLS min version to be VersionTLS12 or VersionTLS13")
+	errMissingCipherSuites = errors.New("FIPS-only mode requires the server TLS cipher suites to be explicitly configured")
+	errCipherSuite         = errors.New("cipher suite not approved in FIPS-only mode")
+	errPasswordHash        = errors.New("FIPS-only mode doesn't support htpasswd files, because bcrypt is not an approved hash: use API keys or JWT authentication instead")
+	errS3SignatureVersion  = errors.New("FIPS-only mode doesn't support the S3 v2 signature, because it's based on HMAC-SHA1: use the v4 signature instead")
+	errInsecureSkipVerify  = errors.New("FIPS-only mode doesn't allow skipping the TLS certificates verification")
+)
+
+// approvedCipherSuites are the TLS cipher suites approved by NIST SP 800-52 Rev. 2 and
+// supported by the FIPS crypto backend.
+var approvedCipherSuites = map[string]struct{}{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": {},
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": {},
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   {},
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   {},
+	"TLS_AES_128_GCM_SHA256":                  {},
+	"TLS_AES_256_GCM_SHA384":                  {},
+}
+
+// Config holds the configuration of the FIPS-only mode.
+type Config struct {
+	Only bool `yaml:"fips_only"`
+}
+
+// RegisterFlags registers the FIPS-only mode flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Only, "crypto.fips-only", false, "True to only allow FIPS 140 approved cryptography: the binary must be built with the boringcrypto backend, the server TLS must be restricted to approved versions and cipher suites, and settings relying on non-approved algorithms are refused.")
+}
+
+// Settings are the settings relying on cryptography which are checked in FIPS-only mode.
+type Settings struct {
+	Server             server.Config
+	HTPasswdFile       string
+	S3SignatureVersion string
+
+	// InsecureSkipVerify is whether the TLS certificates verification is skipped, by client.
+	InsecureSkipVerify map[string]bool
+}
+
+// Validate the settings when the FIPS-only mode is enabled.
+func (cfg *Config) Validate(s Settings) error {
+	if !cfg.Only {
+		return nil
+	}
+
+	if s.Server.MinVersion != "VersionTLS12" && s.Server.MinVersion != "VersionTLS13" {
+		return errMinTLSVersion
+	}
+	if strings.TrimSpace(s.Server.CipherSuites) == "" {
+		return errMissingCipherSuites
+	}
+	for _, name := range strings.Split(s.Server.CipherSuites, ",") {
+		if _, ok := approvedCipherSuites[strings.TrimSpace(name)]; !ok {
+			return errors.Wrap(errCipherSuite, strings.TrimSpace(name))
+		}
+	}
+
+	if s.HTPasswdFile != "" {
+		return errPasswordHash
+	}
+	if s.S3SignatureVersion == "v2" {
+		return errS3SignatureVersion
+	}
+	for client, skip := range s.InsecureSkipVerify {
+		if skip {
+			return errors.Wrap(errInsecureSkipVerify, client)
+		}
+	}
+
+	return nil
+}
+
+// VerifyBackend returns an error if the FIPS-only mode is enabled but the binary doesn't use
+// a FIPS 140 validated crypto backend.
+func (cfg *Config) VerifyBackend() error {
+	if cfg.Only && !backendEnabled() {
+		return errBackendNotFIPS
+	}
+	return nil
+}
+
+// Backend returns the name of the crypto backend the binary uses.
+func Backend() string {
+	if backendEnabled() {
+		return "boringcrypto"
+	}
+	return "go"
+}
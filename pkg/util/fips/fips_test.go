@@ -0,0 +1,77 @@
+package fips
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weaveworks/common/server"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	compliantServer := server.Config{
+		MinVersion:   "VersionTLS12",
+		CipherSuites: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	}
+
+	tests := map[string]struct {
+		cfg      Config
+		settings Settings
+		expected error
+	}{
+		"FIPS-only mode disabled": {
+			settings: Settings{HTPasswdFile: "htpasswd"},
+		},
+		"compliant settings": {
+			cfg:      Config{Only: true},
+			settings: Settings{Server: compliantServer, S3SignatureVersion: "v4", InsecureSkipVerify: map[string]bool{"memberlist": false}},
+		},
+		"default min TLS version": {
+			cfg:      Config{Only: true},
+			settings: Settings{Server: server.Config{CipherSuites: compliantServer.CipherSuites}},
+			expected: errMinTLSVersion,
+		},
+		"default cipher suites": {
+			cfg:      Config{Only: true},
+			settings: Settings{Server: server.Config{MinVersion: "VersionTLS13"}},
+			expected: errMissingCipherSuites,
+		},
+		"non-approved cipher suite": {
+			cfg:      Config{Only: true},
+			settings: Settings{Server: server.Config{MinVersion: "VersionTLS12", CipherSuites: "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"}},
+			expected: errCipherSuite,
+		},
+		"htpasswd file": {
+			cfg:      Config{Only: true},
+			settings: Settings{Server: compliantServer, HTPasswdFile: "htpasswd"},
+			expected: errPasswordHash,
+		},
+		"S3 v2 signature": {
+			cfg:      Config{Only: true},
+			settings: Settings{Server: compliantServer, S3SignatureVersion: "v2"},
+			expected: errS3SignatureVersion,
+		},
+		"insecure skip verify": {
+			cfg:      Config{Only: true},
+			settings: Settings{Server: compliantServer, InsecureSkipVerify: map[string]bool{"memberlist": true}},
+			expected: errInsecureSkipVerify,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, tc.cfg.Validate(tc.settings), tc.expected)
+		})
+	}
+}
+
+func TestConfig_VerifyBackend(t *testing.T) {
+	assert.NoError(t, (&Config{}).VerifyBackend())
+
+	if backendEnabled() {
+		assert.NoError(t, (&Config{Only: true}).VerifyBackend())
+		assert.Equal(t, "boringcrypto", Backend())
+	} else {
+		assert.ErrorIs(t, (&Config{Only: true}).VerifyBackend(), errBackendNotFIPS)
+		assert.Equal(t, "go", Backend())
+	}
+}
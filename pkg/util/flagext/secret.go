@@ -0,0 +1,65 @@
+package flagext
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SecretMask replaces the value of non-empty secrets wherever they're printed.
+const SecretMask = "********"
+
+// Secret is a flag.Value holding a sensitive value, like a password or an API key. The
+// value is masked when printed, logged, formatted into errors or marshalled, so that it
+// never leaks via the config or the logs. Use Value to read it.
+type Secret struct {
+	Value string
+}
+
+// String implements flag.Value and fmt.Stringer.
+func (v Secret) String() string {
+	if v.Value == "" {
+		return ""
+	}
+	return SecretMask
+}
+
+// GoString implements fmt.GoStringer, masking the value for the %#v verb too.
+func (v Secret) GoString() string {
+	return fmt.Sprintf("flagext.Secret{Value:%q}", v.String())
+}
+
+// Set implements flag.Value.
+func (v *Secret) Set(s string) error {
+	v.Value = s
+	return nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (v *Secret) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	return v.Set(s)
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (v Secret) MarshalYAML() (interface{}, error) {
+	return v.String(), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (v *Secret) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	return v.Set(s)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (v Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.String())
+}
@@ -0,0 +1,59 @@
+package flagext
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+type testConfig struct {
+	Username string `yaml:"username" json:"username"`
+	Password Secret `yaml:"password" json:"password"`
+}
+
+func TestSecret_ShouldNeverPrintTheValue(t *testing.T) {
+	cfg := testConfig{Username: "user", Password: Secret{Value: "p4ssw0rd"}}
+
+	out, err := yaml.Marshal(cfg)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), SecretMask)
+	assert.NotContains(t, string(out), "p4ssw0rd")
+
+	out, err = json.Marshal(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, `{"username":"user","password":"********"}`, string(out))
+
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		assert.NotContains(t, fmt.Sprintf(format, cfg), "p4ssw0rd", format)
+		assert.NotContains(t, fmt.Sprintf(format, cfg.Password), "p4ssw0rd", format)
+	}
+
+	assert.NotContains(t, fmt.Errorf("connect with %v: %w", cfg.Password, errors.New("failed")).Error(), "p4ssw0rd")
+}
+
+func TestSecret_ShouldReadTheValue(t *testing.T) {
+	cfg := testConfig{}
+	require.NoError(t, yaml.Unmarshal([]byte("password: from-yaml\n"), &cfg))
+	assert.Equal(t, "from-yaml", cfg.Password.Value)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"password":"from-json"}`), &cfg))
+	assert.Equal(t, "from-json", cfg.Password.Value)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&cfg.Password, "password", "")
+	require.NoError(t, fs.Parse([]string{"-password=from-flag"}))
+	assert.Equal(t, "from-flag", cfg.Password.Value)
+	assert.Equal(t, SecretMask, fs.Lookup("password").Value.String())
+}
+
+func TestSecret_EmptyValue(t *testing.T) {
+	out, err := yaml.Marshal(testConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, "username: \"\"\npassword: \"\"\n", string(out))
+}
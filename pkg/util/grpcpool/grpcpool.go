@@ -0,0 +1,281 @@
+package grpcpool
+
+import (
+	"context"
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/cortexproject/cortex/pkg/util/backoff"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+// maxRetryBackoffMultiple caps the delay between retries to a multiple of the first one.
+const maxRetryBackoffMultiple = 10
+
+var (
+	errInvalidSize              = errors.New("the gRPC connection pool size must be greater than 0")
+	errInvalidHealthCheckPeriod = errors.New("the gRPC connection pool health check interval and timeout must be greater than 0")
+	errInvalidCallTimeout       = errors.New("the gRPC call timeout must be 0 or greater")
+	errInvalidMaxRetries        = errors.New("the gRPC call max retries must be 0 or greater")
+	errNoHealthyConnection      = errors.New("no healthy gRPC connection")
+	errPoolStopped              = errors.New("gRPC connection pool stopped")
+)
+
+// Config holds the configuration of a pool of gRPC client connections and of the calls
+// made through them.
+type Config struct {
+	Size                int           `yaml:"size"`
+	HealthCheckInterval time.Duration `yaml:"health_check_interval"`
+	HealthCheckTimeout  time.Duration `yaml:"health_check_timeout"`
+	CallTimeout         time.Duration `yaml:"call_timeout"`
+	MaxRetries          int           `yaml:"max_retries"`
+	RetryBackoff        time.Duration `yaml:"retry_backoff"`
+}
+
+// RegisterFlagsWithPrefix registers the pool flags with the input prefix.
+func (cfg *Config) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.IntVar(&cfg.Size, prefix+".pool.size", 1, "Number of gRPC connections opened to each address, the calls being balanced round-robin across the healthy ones.")
+	f.DurationVar(&cfg.HealthCheckInterval, prefix+".pool.health-check-interval", 15*time.Second, "How frequently the pooled connections are health checked with the gRPC health service. The unhealthy connections are skipped until they're healthy again.")
+	f.DurationVar(&cfg.HealthCheckTimeout, prefix+".pool.health-check-timeout", time.Second, "Timeout of each health check.")
+	f.DurationVar(&cfg.CallTimeout, prefix+".call-timeout", 0, "Timeout of each attempt of a call. 0 to only apply the timeout of the caller.")
+	f.IntVar(&cfg.MaxRetries, prefix+".max-retries", 0, "Number of times a call failed because the server is unavailable is retried.")
+	f.DurationVar(&cfg.RetryBackoff, prefix+".retry-backoff", 100*time.Millisecond, "Delay before the first retry of a call, doubled on each retry.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if cfg.Size <= 0 {
+		return errInvalidSize
+	}
+	if cfg.HealthCheckInterval <= 0 || cfg.HealthCheckTimeout <= 0 {
+		return errInvalidHealthCheckPeriod
+	}
+	if cfg.CallTimeout < 0 {
+		return errInvalidCallTimeout
+	}
+	if cfg.MaxRetries < 0 {
+		return errInvalidMaxRetries
+	}
+	return nil
+}
+
+// Pool keeps a number of gRPC client connections open to each address it's asked for,
+// balancing the calls round-robin across the connections passing the health checks.
+type Pool struct {
+	services.Service
+
+	cfg      Config
+	dialOpts []grpc.DialOption
+	logger   log.Logger
+
+	mtx     sync.Mutex
+	addrs   map[string]*addrConns
+	stopped bool
+
+	connections  prometheus.Gauge
+	healthChecks *prometheus.CounterVec
+	retries      prometheus.Counter
+}
+
+// addrConns are the pooled connections to an address.
+type addrConns struct {
+	conns []*pooledConn
+	next  atomic.Uint32
+}
+
+type pooledConn struct {
+	*grpc.ClientConn
+	healthy atomic.Bool
+}
+
+// NewPool makes a new Pool dialing with the input options. The metrics are labelled with
+// the client name, so that several pools can share a registerer.
+func NewPool(client string, cfg Config, dialOpts []grpc.DialOption, logger log.Logger, reg prometheus.Registerer) *Pool {
+	reg = prometheus.WrapRegistererWith(prometheus.Labels{"client": client}, reg)
+
+	p := &Pool{
+		cfg:    cfg,
+		logger: log.With(logger, "client", client),
+		addrs:  map[string]*addrConns{},
+		connections: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_grpc_pool_connections",
+			Help: "Number of gRPC client connections in the pool.",
+		}),
+		healthChecks: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_grpc_pool_health_checks_total",
+			Help: "Total number of health checks of the pooled gRPC connections, by result.",
+		}, []string{"result"}),
+		retries: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_grpc_client_call_retries_total",
+			Help: "Total number of gRPC calls retried because the server was unavailable.",
+		}),
+	}
+	p.dialOpts = append(append([]grpc.DialOption{}, dialOpts...), grpc.WithChainUnaryInterceptor(p.unaryInterceptor))
+
+	p.Service = services.NewTimerService(cfg.HealthCheckInterval, nil, p.iteration, p.stopping)
+	return p
+}
+
+// Get returns the next healthy connection to the address, dialing the connections to it if
+// not done yet. The connection must not be closed by the caller.
+func (p *Pool) Get(addr string) (*grpc.ClientConn, error) {
+	a, err := p.addrConns(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(a.conns); i++ {
+		c := a.conns[int(a.next.Inc())%len(a.conns)]
+		if c.healthy.Load() && c.GetState() != connectivity.TransientFailure && c.GetState() != connectivity.Shutdown {
+			return c.ClientConn, nil
+		}
+	}
+	return nil, errors.Wrapf(errNoHealthyConnection, "address %s", addr)
+}
+
+// Remove closes the connections to the address, for instance once it's not part of the
+// ring anymore.
+func (p *Pool) Remove(addr string) {
+	p.mtx.Lock()
+	a := p.addrs[addr]
+	delete(p.addrs, addr)
+	p.mtx.Unlock()
+
+	if a != nil {
+		p.closeConns(addr, a.conns)
+	}
+}
+
+func (p *Pool) addrConns(addr string) (*addrConns, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.stopped {
+		return nil, errPoolStopped
+	}
+	if a, ok := p.addrs[addr]; ok {
+		return a, nil
+	}
+
+	// grpc.Dial doesn't block, the connections are established in the background.
+	a := &addrConns{}
+	for i := 0; i < p.cfg.Size; i++ {
+		conn, err := grpc.Dial(addr, p.dialOpts...)
+		if err != nil {
+			for _, c := range a.conns {
+				c.Close() //nolint:errcheck
+			}
+			return nil, errors.Wrapf(err, "dial %s", addr)
+		}
+		c := &pooledConn{ClientConn: conn}
+		// A new connection is healthy until a health check says otherwise.
+		c.healthy.Store(true)
+		a.conns = append(a.conns, c)
+	}
+	p.addrs[addr] = a
+	p.connections.Add(float64(len(a.conns)))
+	return a, nil
+}
+
+func (p *Pool) closeConns(addr string, conns []*pooledConn) {
+	for _, c := range conns {
+		if err := c.Close(); err != nil {
+			level.Warn(p.logger).Log("msg", "failed to close gRPC connection", "addr", addr, "err", err)
+		}
+	}
+	p.connections.Sub(float64(len(conns)))
+}
+
+// iteration health checks all the pooled connections.
+func (p *Pool) iteration(ctx context.Context) error {
+	p.mtx.Lock()
+	addrs := make(map[string]*addrConns, len(p.addrs))
+	for addr, a := range p.addrs {
+		addrs[addr] = a
+	}
+	p.mtx.Unlock()
+
+	for addr, a := range addrs {
+		for _, c := range a.conns {
+			healthy := p.healthCheck(ctx, c)
+			if c.healthy.Swap(healthy) != healthy {
+				level.Info(p.logger).Log("msg", "gRPC connection health changed", "addr", addr, "healthy", healthy)
+			}
+		}
+	}
+	return nil
+}
+
+// healthCheck returns whether the connection passes the health check. Servers not
+// implementing the health service are healthy as long as they answer.
+func (p *Pool) healthCheck(ctx context.Context, c *pooledConn) bool {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.HealthCheckTimeout)
+	defer cancel()
+
+	resp, err := grpc_health_v1.NewHealthClient(c).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	healthy := (err == nil && resp.Status == grpc_health_v1.HealthCheckResponse_SERVING) || status.Code(err) == codes.Unimplemented
+	if healthy {
+		p.healthChecks.WithLabelValues("healthy").Inc()
+	} else {
+		p.healthChecks.WithLabelValues("unhealthy").Inc()
+	}
+	return healthy
+}
+
+func (p *Pool) stopping(_ error) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	for addr, a := range p.addrs {
+		p.closeConns(addr, a.conns)
+	}
+	p.addrs = map[string]*addrConns{}
+	p.stopped = true
+	return nil
+}
+
+// unaryInterceptor applies the call timeout to each attempt of the calls, and retries the
+// ones failed because the server is unavailable.
+func (p *Pool) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	boff := backoff.New(ctx, backoff.Config{
+		MinBackoff: p.cfg.RetryBackoff,
+		MaxBackoff: p.cfg.RetryBackoff * maxRetryBackoffMultiple,
+	})
+
+	var err error
+	for boff.Ongoing() {
+		err = p.invoke(ctx, method, req, reply, cc, invoker, opts...)
+		if status.Code(err) != codes.Unavailable || boff.NumRetries() >= p.cfg.MaxRetries {
+			return err
+		}
+
+		p.retries.Inc()
+		boff.Wait()
+	}
+	if err == nil {
+		err = boff.Err()
+	}
+	return err
+}
+
+func (p *Pool) invoke(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if p.cfg.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.cfg.CallTimeout)
+		defer cancel()
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
@@ -0,0 +1,210 @@
+package grpcpool
+
+import (
+	"context"
+	"flag"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *Config)
+		expected error
+	}{
+		"default config": {
+			setup:    func(*Config) {},
+			expected: nil,
+		},
+		"zero size": {
+			setup:    func(cfg *Config) { cfg.Size = 0 },
+			expected: errInvalidSize,
+		},
+		"zero health check timeout": {
+			setup:    func(cfg *Config) { cfg.HealthCheckTimeout = 0 },
+			expected: errInvalidHealthCheckPeriod,
+		},
+		"negative call timeout": {
+			setup:    func(cfg *Config) { cfg.CallTimeout = -1 },
+			expected: errInvalidCallTimeout,
+		},
+		"negative max retries": {
+			setup:    func(cfg *Config) { cfg.MaxRetries = -1 },
+			expected: errInvalidMaxRetries,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := defaultConfig()
+			tc.setup(&cfg)
+			assert.ErrorIs(t, cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+func TestPool_Get(t *testing.T) {
+	healthServer := health.NewServer()
+	addr := startServer(t, healthServer)
+
+	cfg := defaultConfig()
+	cfg.Size = 3
+	reg := prometheus.NewPedanticRegistry()
+	pool := NewPool("test", cfg, []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, log.NewNopLogger(), reg)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), pool))
+	t.Cleanup(func() { assert.NoError(t, services.StopAndAwaitTerminated(context.Background(), pool)) })
+
+	// The calls are balanced across all the connections.
+	seen := map[*grpc.ClientConn]struct{}{}
+	for i := 0; i < 3; i++ {
+		conn, err := pool.Get(addr)
+		require.NoError(t, err)
+		seen[conn] = struct{}{}
+	}
+	assert.Len(t, seen, 3)
+	assert.Equal(t, float64(3), testutil.ToFloat64(pool.connections))
+
+	// The unhealthy connections are skipped.
+	healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	require.NoError(t, pool.iteration(context.Background()))
+	_, err := pool.Get(addr)
+	assert.ErrorIs(t, err, errNoHealthyConnection)
+
+	healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+	require.NoError(t, pool.iteration(context.Background()))
+	_, err = pool.Get(addr)
+	assert.NoError(t, err)
+
+	pool.Remove(addr)
+	assert.Equal(t, float64(0), testutil.ToFloat64(pool.connections))
+}
+
+func TestPool_Retries(t *testing.T) {
+	tests := map[string]struct {
+		failures      int
+		maxRetries    int
+		expectedCode  codes.Code
+		expectedCalls int
+	}{
+		"no failure": {
+			failures:      0,
+			maxRetries:    2,
+			expectedCode:  codes.OK,
+			expectedCalls: 1,
+		},
+		"succeeds on retry": {
+			failures:      2,
+			maxRetries:    2,
+			expectedCode:  codes.OK,
+			expectedCalls: 3,
+		},
+		"retries exhausted": {
+			failures:      3,
+			maxRetries:    2,
+			expectedCode:  codes.Unavailable,
+			expectedCalls: 3,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := &flakyHealthServer{failures: atomic.NewInt32(int32(tc.failures))}
+			addr := startServer(t, server)
+
+			cfg := defaultConfig()
+			cfg.MaxRetries = tc.maxRetries
+			cfg.RetryBackoff = time.Millisecond
+			pool := NewPool("test", cfg, []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, log.NewNopLogger(), nil)
+			t.Cleanup(func() { pool.Remove(addr) })
+
+			conn, err := pool.Get(addr)
+			require.NoError(t, err)
+
+			_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+			assert.Equal(t, tc.expectedCode, status.Code(err))
+			assert.Equal(t, int32(tc.expectedCalls), server.calls.Load())
+		})
+	}
+}
+
+func TestPool_CallTimeout(t *testing.T) {
+	addr := startServer(t, &flakyHealthServer{failures: atomic.NewInt32(0), delay: time.Second})
+
+	cfg := defaultConfig()
+	cfg.CallTimeout = 10 * time.Millisecond
+	pool := NewPool("test", cfg, []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, log.NewNopLogger(), nil)
+	t.Cleanup(func() { pool.Remove(addr) })
+
+	conn, err := pool.Get(addr)
+	require.NoError(t, err)
+
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func defaultConfig() Config {
+	cfg := Config{}
+	flagext.DefaultValues(&testConfig{&cfg})
+	return cfg
+}
+
+// testConfig registers the pool flags with a test prefix.
+type testConfig struct {
+	cfg *Config
+}
+
+func (c *testConfig) RegisterFlags(f *flag.FlagSet) {
+	c.cfg.RegisterFlagsWithPrefix("test", f)
+}
+
+func startServer(t *testing.T, healthServer grpc_health_v1.HealthServer) string {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	server := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+	go server.Serve(lis) //nolint:errcheck
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+// flakyHealthServer fails the first checks as unavailable.
+type flakyHealthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+
+	failures *atomic.Int32
+	calls    atomic.Int32
+	delay    time.Duration
+}
+
+func (s *flakyHealthServer) Check(ctx context.Context, _ *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	s.calls.Inc()
+	if s.delay > 0 {
+		select {
+		case <-time.After(s.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if s.failures.Dec() >= 0 {
+		return nil, status.Error(codes.Unavailable, "unavailable")
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
@@ -0,0 +1,73 @@
+package histogram
+
+import (
+	"flag"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+var (
+	errInvalidBucketFactor  = errors.New("the native histogram bucket factor must be greater than 1")
+	errInvalidClassicBucket = errors.New("the classic histogram buckets must be positive numbers")
+)
+
+// Config holds the configuration of the internal latency histograms. They're exposed both
+// as native histograms, for accurate high resolution percentiles, and with classic buckets
+// for the scrapers not supporting native histograms.
+type Config struct {
+	NativeBucketFactor   float64                `yaml:"native_bucket_factor"`
+	NativeMaxBuckets     uint                   `yaml:"native_max_buckets"`
+	NativeMinResetPeriod time.Duration          `yaml:"native_min_reset_period"`
+	ClassicBuckets       flagext.StringSliceCSV `yaml:"classic_buckets"`
+}
+
+// RegisterFlags registers the histograms flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	cfg.ClassicBuckets = []string{"0.005", "0.01", "0.025", "0.05", "0.1", "0.25", "0.5", "1", "2.5", "5", "10", "30", "60"}
+
+	f.Float64Var(&cfg.NativeBucketFactor, "histograms.native-bucket-factor", 1.1, "Growth factor between the buckets of the native latency histograms. The lower, the higher the resolution and the number of buckets.")
+	f.UintVar(&cfg.NativeMaxBuckets, "histograms.native-max-buckets", 160, "Maximum number of buckets of each native latency histogram. Once reached, the resolution is reduced. 0 for no limit.")
+	f.DurationVar(&cfg.NativeMinResetPeriod, "histograms.native-min-reset-period", time.Hour, "Minimum period between resets of a native latency histogram reaching the max buckets, instead of reducing its resolution.")
+	f.Var(&cfg.ClassicBuckets, "histograms.classic-buckets", "Comma separated upper bounds, in seconds, of the classic buckets of the latency histograms, exposed as fallback to the scrapers not supporting native histograms.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if cfg.NativeBucketFactor <= 1 {
+		return errInvalidBucketFactor
+	}
+	if _, err := cfg.classicBuckets(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Opts returns the input histogram options with the configured classic and native buckets.
+// The config must be validated first.
+func (cfg *Config) Opts(opts prometheus.HistogramOpts) prometheus.HistogramOpts {
+	opts.Buckets, _ = cfg.classicBuckets()
+	opts.NativeHistogramBucketFactor = cfg.NativeBucketFactor
+	opts.NativeHistogramMaxBucketNumber = uint32(cfg.NativeMaxBuckets)
+	opts.NativeHistogramMinResetDuration = cfg.NativeMinResetPeriod
+	return opts
+}
+
+func (cfg *Config) classicBuckets() ([]float64, error) {
+	buckets := make([]float64, 0, len(cfg.ClassicBuckets))
+	for _, b := range cfg.ClassicBuckets {
+		v, err := strconv.ParseFloat(b, 64)
+		if err != nil || v <= 0 {
+			return nil, errInvalidClassicBucket
+		}
+		buckets = append(buckets, v)
+	}
+
+	sort.Float64s(buckets)
+	return buckets, nil
+}
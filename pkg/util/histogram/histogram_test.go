@@ -0,0 +1,57 @@
+package histogram
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *Config)
+		expected error
+	}{
+		"default config": {
+			setup:    func(*Config) {},
+			expected: nil,
+		},
+		"invalid bucket factor": {
+			setup:    func(cfg *Config) { cfg.NativeBucketFactor = 1 },
+			expected: errInvalidBucketFactor,
+		},
+		"invalid classic bucket": {
+			setup:    func(cfg *Config) { cfg.ClassicBuckets = []string{"0.1", "abc"} },
+			expected: errInvalidClassicBucket,
+		},
+		"negative classic bucket": {
+			setup:    func(cfg *Config) { cfg.ClassicBuckets = []string{"-1"} },
+			expected: errInvalidClassicBucket,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+			assert.ErrorIs(t, cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+func TestConfig_Opts(t *testing.T) {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.ClassicBuckets = []string{"1", "0.1"}
+	require.NoError(t, cfg.Validate())
+
+	opts := cfg.Opts(prometheus.HistogramOpts{Name: "test_duration_seconds"})
+	assert.Equal(t, "test_duration_seconds", opts.Name)
+	assert.Equal(t, []float64{0.1, 1}, opts.Buckets)
+	assert.Equal(t, 1.1, opts.NativeHistogramBucketFactor)
+	assert.Equal(t, uint32(160), opts.NativeHistogramMaxBucketNumber)
+}
@@ -0,0 +1,245 @@
+package httpcompress
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Supported content encodings, by order of preference when the client accepts them
+// equally.
+const (
+	encodingZstd = "zstd"
+	encodingGzip = "gzip"
+)
+
+var errInvalidMinSize = errors.New("the response compression min size must be 0 or greater")
+
+// Config holds the configuration of the HTTP response compression.
+type Config struct {
+	Enabled      bool `yaml:"enabled"`
+	MinSizeBytes int  `yaml:"min_size_bytes"`
+}
+
+// RegisterFlags registers the response compression flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "server.response-compression-enabled", false, "True to compress the responses of the HTTP endpoints, like the admin, config, cardinality and query ones, with zstd or gzip, as negotiated with the Accept-Encoding header of the request.")
+	f.IntVar(&cfg.MinSizeBytes, "server.response-compression-min-size-bytes", 1024, "Responses smaller than this size in bytes are sent uncompressed.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if cfg.MinSizeBytes < 0 {
+		return errInvalidMinSize
+	}
+	return nil
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	}}
+	zstdWriters = sync.Pool{New: func() interface{} {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+		return w
+	}}
+)
+
+// Compressor compresses the HTTP responses.
+type Compressor struct {
+	minSize int
+
+	compressed *prometheus.CounterVec
+}
+
+// NewCompressor makes a new Compressor.
+func NewCompressor(cfg Config, reg prometheus.Registerer) *Compressor {
+	return &Compressor{
+		minSize: cfg.MinSizeBytes,
+		compressed: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_http_compressed_responses_total",
+			Help: "Total number of HTTP responses compressed, by content encoding.",
+		}, []string{"encoding"}),
+	}
+}
+
+// Wrap returns the handler compressing its responses with the encoding the request accepts.
+// The responses are buffered up to the min size to decide whether to compress them, unless
+// the handler flushes them before. The responses already encoded by the handler are left
+// as is.
+func (c *Compressor) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiate(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{c: c, w: w, encoding: encoding, code: http.StatusOK}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiate returns the preferred encoding among the supported ones accepted by the
+// Accept-Encoding header, or an empty string if none is.
+func negotiate(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		q := 1.0
+		if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(k) == "q" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+
+		// The wildcard accepts the most preferred encoding.
+		if name == "*" {
+			name = encodingZstd
+		}
+		if name != encodingZstd && name != encodingGzip {
+			continue
+		}
+		if q > bestQ || (q == bestQ && name == encodingZstd) {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressWriter is the response writer compressing the response once it's larger than the
+// min size.
+type compressWriter struct {
+	c        *Compressor
+	w        http.ResponseWriter
+	encoding string
+
+	code    int
+	buf     bytes.Buffer
+	started bool
+	// enc is nil if the response isn't compressed.
+	enc io.WriteCloser
+}
+
+func (cw *compressWriter) Header() http.Header {
+	return cw.w.Header()
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.started {
+		return
+	}
+	cw.code = code
+
+	// The responses without body, or already encoded, are sent as is.
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified || cw.Header().Get("Content-Encoding") != "" {
+		cw.start(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.started {
+		if cw.Header().Get("Content-Encoding") != "" {
+			cw.start(false)
+		} else if cw.buf.Len()+len(b) < cw.c.minSize {
+			return cw.buf.Write(b)
+		} else {
+			cw.start(true)
+		}
+	}
+
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.w.Write(b)
+}
+
+// Flush implements http.Flusher, for the handlers streaming their response. The response is
+// compressed from the first flush on, whatever its size.
+func (cw *compressWriter) Flush() {
+	if !cw.started {
+		cw.start(cw.Header().Get("Content-Encoding") == "")
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := cw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// start writes the headers and the buffered response, compressed or not.
+func (cw *compressWriter) start(compress bool) {
+	cw.started = true
+
+	if compress {
+		cw.Header().Set("Content-Encoding", cw.encoding)
+		cw.Header().Del("Content-Length")
+		cw.c.compressed.WithLabelValues(cw.encoding).Inc()
+
+		switch cw.encoding {
+		case encodingZstd:
+			enc := zstdWriters.Get().(*zstd.Encoder)
+			enc.Reset(cw.w)
+			cw.enc = enc
+		case encodingGzip:
+			enc := gzipWriters.Get().(*gzip.Writer)
+			enc.Reset(cw.w)
+			cw.enc = enc
+		}
+	}
+
+	cw.w.WriteHeader(cw.code)
+	if cw.buf.Len() > 0 {
+		if cw.enc != nil {
+			_, _ = cw.enc.Write(cw.buf.Bytes())
+		} else {
+			_, _ = cw.w.Write(cw.buf.Bytes())
+		}
+		cw.buf.Reset()
+	}
+}
+
+// close completes the response, and releases the encoder.
+func (cw *compressWriter) close() {
+	if !cw.started {
+		// The response is smaller than the min size.
+		cw.start(false)
+		return
+	}
+	if cw.enc == nil {
+		return
+	}
+
+	_ = cw.enc.Close()
+	switch enc := cw.enc.(type) {
+	case *zstd.Encoder:
+		enc.Reset(nil)
+		zstdWriters.Put(enc)
+	case *gzip.Writer:
+		enc.Reset(nil)
+		gzipWriters.Put(enc)
+	}
+	cw.enc = nil
+}
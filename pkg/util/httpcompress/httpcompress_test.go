@@ -0,0 +1,163 @@
+package httpcompress
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	for acceptEncoding, expected := range map[string]string{
+		"":                            "",
+		"identity":                    "",
+		"gzip":                        "gzip",
+		"gzip, deflate, br":           "gzip",
+		"gzip, zstd":                  "zstd",
+		"zstd;q=0.5, gzip;q=0.8":      "gzip",
+		"GZIP;q=1.0":                  "gzip",
+		"zstd;q=0, gzip":              "gzip",
+		"*":                           "zstd",
+		"gzip;q=invalid":              "",
+		"br;q=1.0, gzip;q=0.1, *;q=0": "gzip",
+	} {
+		assert.Equal(t, expected, negotiate(acceptEncoding), acceptEncoding)
+	}
+}
+
+func TestCompressor_Wrap(t *testing.T) {
+	large := strings.Repeat(`{"status":"success"}`, 100)
+
+	tests := map[string]struct {
+		acceptEncoding   string
+		contentEncoding  string
+		body             string
+		expectedEncoding string
+	}{
+		"zstd": {
+			acceptEncoding:   "gzip, zstd",
+			body:             large,
+			expectedEncoding: "zstd",
+		},
+		"gzip": {
+			acceptEncoding:   "gzip",
+			body:             large,
+			expectedEncoding: "gzip",
+		},
+		"compression not accepted": {
+			body: large,
+		},
+		"response smaller than the min size": {
+			acceptEncoding: "gzip",
+			body:           `{"status":"success"}`,
+		},
+		"response already encoded": {
+			acceptEncoding:   "gzip",
+			contentEncoding:  "snappy",
+			body:             large,
+			expectedEncoding: "snappy",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			reg := prometheus.NewPedanticRegistry()
+			c := NewCompressor(Config{Enabled: true, MinSizeBytes: 1024}, reg)
+			handler := c.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.contentEncoding != "" {
+					w.Header().Set("Content-Encoding", tc.contentEncoding)
+				}
+				// Written in chunks, to check the buffering up to the min size.
+				for i := 0; i < len(tc.body); i += 100 {
+					_, err := w.Write([]byte(tc.body[i:minInt(i+100, len(tc.body))]))
+					require.NoError(t, err)
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/cardinality/label_names", nil)
+			if tc.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+			assert.Equal(t, tc.expectedEncoding, rec.Header().Get("Content-Encoding"))
+
+			var body io.Reader = rec.Body
+			switch tc.expectedEncoding {
+			case "zstd":
+				dec, err := zstd.NewReader(body)
+				require.NoError(t, err)
+				defer dec.Close()
+				body = dec
+			case "gzip":
+				dec, err := gzip.NewReader(body)
+				require.NoError(t, err)
+				body = dec
+			}
+			decoded, err := io.ReadAll(body)
+			require.NoError(t, err)
+			assert.Equal(t, tc.body, string(decoded))
+
+			compressed := 0
+			if tc.expectedEncoding == "zstd" || tc.expectedEncoding == "gzip" {
+				compressed = 1
+			}
+			assert.Equal(t, compressed, testutil.CollectAndCount(reg, "cortex_http_compressed_responses_total"))
+		})
+	}
+}
+
+func TestCompressor_Wrap_Flush(t *testing.T) {
+	c := NewCompressor(Config{Enabled: true, MinSizeBytes: 1024}, nil)
+	handler := c.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("chunk"))
+		w.(http.Flusher).Flush()
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/read", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.True(t, rec.Flushed)
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	dec, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(dec)
+	require.NoError(t, err)
+	assert.Equal(t, "chunk", string(decoded))
+}
+
+func TestCompressor_Wrap_NoContent(t *testing.T) {
+	c := NewCompressor(Config{Enabled: true}, nil)
+	handler := c.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest(http.MethodDelete, "/ingester/read-only", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Empty(t, rec.Body.Bytes())
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
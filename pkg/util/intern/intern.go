@@ -0,0 +1,162 @@
+package intern
+
+import (
+	"flag"
+	"strings"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+// numShards is the number of shards of the table, to limit the lock contention between
+// the concurrent pushes.
+const numShards = 64
+
+var errInvalidMaxSize = errors.New("the label interning max size must be 0 or greater")
+
+// Config holds the configuration of the label interning table.
+type Config struct {
+	MaxSizeBytes int `yaml:"max_size_bytes"`
+}
+
+// RegisterFlags registers the label interning flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.IntVar(&cfg.MaxSizeBytes, "label-interning.max-size-bytes", 64<<20, "Max size in bytes of the label names and values interned in a table shared by the whole process, so that the identical strings of the series of all tenants share the same memory. 0 to disable.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if cfg.MaxSizeBytes < 0 {
+		return errInvalidMaxSize
+	}
+	return nil
+}
+
+// Table interns strings, up to a max size. A full shard of the table is cleared, so that the
+// strings not used anymore are eventually released: the strings interned before keep being
+// valid but are not shared anymore with the ones interned after.
+//
+// A nil Table doesn't intern anything.
+type Table struct {
+	shards       [numShards]shard
+	maxShardSize int
+
+	hits      prometheus.Counter
+	misses    prometheus.Counter
+	evictions prometheus.Counter
+}
+
+type shard struct {
+	mtx     sync.Mutex
+	strings map[string]string
+	size    int
+}
+
+// NewTable makes a new Table of the input max size.
+func NewTable(maxSize int, reg prometheus.Registerer) *Table {
+	lookups := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "cortex_label_interning_lookups_total",
+		Help: "Total number of strings looked up in the label interning table, by result.",
+	}, []string{"result"})
+
+	t := &Table{
+		maxShardSize: maxSize / numShards,
+		hits:         lookups.WithLabelValues("hit"),
+		misses:       lookups.WithLabelValues("miss"),
+		evictions: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_label_interning_evictions_total",
+			Help: "Total number of label interning table shards cleared because full.",
+		}),
+	}
+	for i := range t.shards {
+		t.shards[i].strings = map[string]string{}
+	}
+
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "cortex_label_interning_size_bytes",
+		Help: "Size in bytes of the strings interned in the label interning table.",
+	}, func() float64 { return float64(t.size()) })
+	return t
+}
+
+// Intern returns the interned string equal to s. The returned string never references the
+// memory of s, which can be an unsafe reference to a buffer about to be reused. A nil
+// Table returns s as is.
+func (t *Table) Intern(s string) string {
+	if t == nil || s == "" {
+		return s
+	}
+
+	sh := &t.shards[xxhash.Sum64String(s)%numShards]
+	sh.mtx.Lock()
+	defer sh.mtx.Unlock()
+
+	if interned, ok := sh.strings[s]; ok {
+		t.hits.Inc()
+		return interned
+	}
+	t.misses.Inc()
+
+	interned := clone(s)
+	if len(s) > t.maxShardSize {
+		return interned
+	}
+	if sh.size+len(s) > t.maxShardSize {
+		sh.strings = make(map[string]string, len(sh.strings))
+		sh.size = 0
+		t.evictions.Inc()
+	}
+	sh.strings[interned] = interned
+	sh.size += len(interned)
+	return interned
+}
+
+// Labels interns the names and values of the input labels, in place.
+func (t *Table) Labels(lbls []cortexpb.LabelAdapter) {
+	if t == nil {
+		return
+	}
+	for i := range lbls {
+		lbls[i].Name = t.Intern(lbls[i].Name)
+		lbls[i].Value = t.Intern(lbls[i].Value)
+	}
+}
+
+func (t *Table) size() int {
+	size := 0
+	for i := range t.shards {
+		t.shards[i].mtx.Lock()
+		size += t.shards[i].size
+		t.shards[i].mtx.Unlock()
+	}
+	return size
+}
+
+func clone(s string) string {
+	b := strings.Builder{}
+	b.Grow(len(s))
+	b.WriteString(s)
+	return b.String()
+}
+
+// global is the table shared by the whole process, set by Init.
+var global *Table
+
+// Init sets up the table shared by the whole process. It must be called before any
+// component using the table is created.
+func Init(cfg Config, reg prometheus.Registerer) {
+	if cfg.MaxSizeBytes > 0 {
+		global = NewTable(cfg.MaxSizeBytes, reg)
+	}
+}
+
+// Global returns the table shared by the whole process, or nil if label interning is
+// disabled.
+func Global() *Table {
+	return global
+}
@@ -0,0 +1,94 @@
+package intern
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *Config)
+		expected error
+	}{
+		"default config": {
+			setup:    func(*Config) {},
+			expected: nil,
+		},
+		"disabled": {
+			setup:    func(cfg *Config) { cfg.MaxSizeBytes = 0 },
+			expected: nil,
+		},
+		"negative max size": {
+			setup:    func(cfg *Config) { cfg.MaxSizeBytes = -1 },
+			expected: errInvalidMaxSize,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+			assert.ErrorIs(t, cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+func TestTable_Intern(t *testing.T) {
+	table := NewTable(1<<20, prometheus.NewPedanticRegistry())
+
+	first, second := []byte("node_exporter"), []byte("node_exporter")
+	interned := table.Intern(yoloString(first))
+
+	// The interned string doesn't reference the input, and is shared by the equal strings.
+	first[0] = 'x'
+	assert.Equal(t, "node_exporter", interned)
+	assert.Equal(t, dataOf(interned), dataOf(table.Intern(yoloString(second))))
+	assert.Equal(t, float64(1), testutil.ToFloat64(table.hits))
+	assert.Equal(t, float64(1), testutil.ToFloat64(table.misses))
+
+	lbls := []cortexpb.LabelAdapter{{Name: "job", Value: yoloString(second)}}
+	table.Labels(lbls)
+	assert.Equal(t, dataOf(interned), dataOf(lbls[0].Value))
+}
+
+func TestTable_MaxSize(t *testing.T) {
+	table := NewTable(numShards*16, prometheus.NewPedanticRegistry())
+
+	for i := 0; i < 10000; i++ {
+		s := fmt.Sprintf("value-%d", i)
+		assert.Equal(t, s, table.Intern(s))
+	}
+	assert.LessOrEqual(t, table.size(), numShards*16)
+	assert.Greater(t, testutil.ToFloat64(table.evictions), float64(0))
+
+	// The strings larger than a shard are copied, but not interned.
+	large := string(make([]byte, 32))
+	assert.Equal(t, large, table.Intern(large))
+	assert.LessOrEqual(t, table.size(), numShards*16)
+}
+
+func TestTable_Nil(t *testing.T) {
+	var table *Table
+
+	s := "up"
+	assert.Equal(t, dataOf(s), dataOf(table.Intern(s)))
+	table.Labels([]cortexpb.LabelAdapter{{Name: "__name__", Value: s}})
+}
+
+func yoloString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+func dataOf(s string) uintptr {
+	return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+}
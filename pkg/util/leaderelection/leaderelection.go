@@ -0,0 +1,214 @@
+package leaderelection
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/atomic"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+var (
+	errUnsupportedKVStore = errors.New("leader election requires a KV store supporting CAS (consul, etcd or inmemory), memberlist is not supported")
+	errInvalidLeaseTiming = errors.New("the leader election renew interval must be lower than the lease duration")
+)
+
+// Config holds the configuration of the leader election.
+type Config struct {
+	KVStore       kv.Config     `yaml:"kvstore" doc:"description=The key-value store used to elect the leader of singleton background jobs."`
+	LeaseDuration time.Duration `yaml:"lease_duration"`
+	RenewInterval time.Duration `yaml:"renew_interval"`
+}
+
+// RegisterFlags registers the leader election flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	cfg.KVStore.RegisterFlagsWithPrefix("leader-election.", "leader-election/", f)
+	f.DurationVar(&cfg.LeaseDuration, "leader-election.lease-duration", time.Minute, "How long a leader holds the lease without renewing it before another instance can take over.")
+	f.DurationVar(&cfg.RenewInterval, "leader-election.renew-interval", 15*time.Second, "How frequently the leader renews its lease, and followers try to acquire it.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if cfg.KVStore.Store == "memberlist" {
+		return errUnsupportedKVStore
+	}
+	if cfg.RenewInterval <= 0 || cfg.RenewInterval >= cfg.LeaseDuration {
+		return errInvalidLeaseTiming
+	}
+	return nil
+}
+
+// Lease is the value stored in the KV store for each elected job.
+type Lease struct {
+	Holder    string `json:"holder"`
+	RenewedAt int64  `json:"renewed_at"`
+}
+
+func (l *Lease) expired(now time.Time, duration time.Duration) bool {
+	return now.Sub(time.UnixMilli(l.RenewedAt)) > duration
+}
+
+// LeaseCodec is the codec used to store leases in the KV store.
+type LeaseCodec struct{}
+
+// CodecID implements codec.Codec.
+func (LeaseCodec) CodecID() string { return "leaderElectionLease" }
+
+// Decode implements codec.Codec.
+func (LeaseCodec) Decode(data []byte) (interface{}, error) {
+	lease := &Lease{}
+	if err := json.Unmarshal(data, lease); err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// Encode implements codec.Codec.
+func (LeaseCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// DecodeMultiKey implements codec.Codec. The leases are stored under a single key.
+func (LeaseCodec) DecodeMultiKey(map[string][]byte) (interface{}, error) {
+	return nil, errors.New("the lease codec doesn't support multi-key values")
+}
+
+// EncodeMultiKey implements codec.Codec.
+func (LeaseCodec) EncodeMultiKey(interface{}) (map[string][]byte, error) {
+	return nil, errors.New("the lease codec doesn't support multi-key values")
+}
+
+// Elector elects a single leader, across all instances sharing the same KV store, for
+// the given job. Jobs wrapped with RunIfLeader() run only on the elected leader.
+type Elector struct {
+	services.Service
+
+	cfg        Config
+	kv         kv.Client
+	job        string
+	instanceID string
+	logger     log.Logger
+
+	leader *atomic.Bool
+
+	isLeaderGauge prometheus.Gauge
+	transitions   prometheus.Counter
+}
+
+// NewElector makes a new Elector for the input job.
+func NewElector(cfg Config, kvClient kv.Client, job, instanceID string, logger log.Logger, reg prometheus.Registerer) *Elector {
+	reg = prometheus.WrapRegistererWith(prometheus.Labels{"election": job}, reg)
+
+	e := &Elector{
+		cfg:        cfg,
+		kv:         kvClient,
+		job:        job,
+		instanceID: instanceID,
+		logger:     log.With(logger, "election", job),
+		leader:     atomic.NewBool(false),
+		isLeaderGauge: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_leader_election_is_leader",
+			Help: "1 if this instance is the elected leader of the job, 0 otherwise.",
+		}),
+		transitions: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_leader_election_transitions_total",
+			Help: "Total number of times this instance gained or lost the leadership of the job.",
+		}),
+	}
+
+	e.Service = services.NewTimerService(cfg.RenewInterval, e.iteration, e.iteration, e.stopping)
+	return e
+}
+
+// NewKVClient makes the KV client used by leader electors.
+func NewKVClient(cfg Config, logger log.Logger, reg prometheus.Registerer) (kv.Client, error) {
+	return kv.NewClient(cfg.KVStore, LeaseCodec{}, kv.RegistererWithKVName(reg, "leader-election"), logger)
+}
+
+// IsLeader returns whether this instance is the current leader of the job.
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// RunIfLeader wraps the input function so that it runs only while this instance is the leader.
+func (e *Elector) RunIfLeader(f func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if !e.IsLeader() {
+			level.Debug(e.logger).Log("msg", "skipped job run because this instance is not the leader")
+			return nil
+		}
+		return f(ctx)
+	}
+}
+
+func (e *Elector) iteration(ctx context.Context) error {
+	holder := ""
+	err := e.kv.CAS(ctx, e.job, func(in interface{}) (out interface{}, retry bool, err error) {
+		now := time.Now()
+
+		lease, _ := in.(*Lease)
+		if lease != nil && lease.Holder != e.instanceID && !lease.expired(now, e.cfg.LeaseDuration) {
+			holder = lease.Holder
+			return nil, false, nil
+		}
+
+		holder = e.instanceID
+		return &Lease{Holder: e.instanceID, RenewedAt: now.UnixMilli()}, true, nil
+	})
+
+	if err != nil {
+		// Step down if the lease can't be renewed, because another instance may take over
+		// once it expires.
+		level.Warn(e.logger).Log("msg", "failed to acquire or renew the leader lease", "err", err)
+		e.setLeader(false)
+		return nil
+	}
+
+	e.setLeader(holder == e.instanceID)
+	return nil
+}
+
+func (e *Elector) stopping(_ error) error {
+	if !e.IsLeader() {
+		return nil
+	}
+
+	// Release the lease, so that another instance can take over immediately.
+	err := e.kv.CAS(context.Background(), e.job, func(in interface{}) (out interface{}, retry bool, err error) {
+		lease, _ := in.(*Lease)
+		if lease == nil || lease.Holder != e.instanceID {
+			return nil, false, nil
+		}
+		return &Lease{Holder: e.instanceID}, true, nil
+	})
+	if err != nil {
+		level.Warn(e.logger).Log("msg", "failed to release the leader lease", "err", err)
+	}
+
+	e.setLeader(false)
+	return nil
+}
+
+func (e *Elector) setLeader(leader bool) {
+	if e.leader.Swap(leader) == leader {
+		return
+	}
+
+	e.transitions.Inc()
+	if leader {
+		e.isLeaderGauge.Set(1)
+		level.Info(e.logger).Log("msg", "this instance has been elected leader")
+	} else {
+		e.isLeaderGauge.Set(0)
+		level.Info(e.logger).Log("msg", "this instance is not the leader anymore")
+	}
+}
@@ -0,0 +1,87 @@
+package leaderelection
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+)
+
+func TestElector_ShouldElectASingleLeader(t *testing.T) {
+	ctx := context.Background()
+	store, closer := consul.NewInMemoryClient(LeaseCodec{}, log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	cfg := Config{LeaseDuration: time.Minute, RenewInterval: time.Second}
+	first := NewElector(cfg, store, "retention", "instance-1", log.NewNopLogger(), nil)
+	second := NewElector(cfg, store, "retention", "instance-2", log.NewNopLogger(), nil)
+
+	require.NoError(t, first.iteration(ctx))
+	require.NoError(t, second.iteration(ctx))
+	assert.True(t, first.IsLeader())
+	assert.False(t, second.IsLeader())
+
+	// The leader keeps the lease while renewing it.
+	require.NoError(t, first.iteration(ctx))
+	require.NoError(t, second.iteration(ctx))
+	assert.True(t, first.IsLeader())
+	assert.False(t, second.IsLeader())
+
+	// Once the leader releases the lease, the other instance takes over.
+	require.NoError(t, first.stopping(nil))
+	require.NoError(t, second.iteration(ctx))
+	assert.False(t, first.IsLeader())
+	assert.True(t, second.IsLeader())
+}
+
+func TestElector_ShouldTakeOverExpiredLease(t *testing.T) {
+	ctx := context.Background()
+	store, closer := consul.NewInMemoryClient(LeaseCodec{}, log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	require.NoError(t, store.CAS(ctx, "cleanup", func(interface{}) (interface{}, bool, error) {
+		return &Lease{Holder: "dead-instance", RenewedAt: time.Now().Add(-time.Hour).UnixMilli()}, true, nil
+	}))
+
+	e := NewElector(Config{LeaseDuration: time.Minute, RenewInterval: time.Second}, store, "cleanup", "instance-1", log.NewNopLogger(), nil)
+	require.NoError(t, e.iteration(ctx))
+	assert.True(t, e.IsLeader())
+}
+
+func TestElector_RunIfLeader(t *testing.T) {
+	e := NewElector(Config{LeaseDuration: time.Minute, RenewInterval: time.Second}, nil, "job", "instance-1", log.NewNopLogger(), nil)
+
+	runs := 0
+	job := e.RunIfLeader(func(context.Context) error {
+		runs++
+		return nil
+	})
+
+	require.NoError(t, job(context.Background()))
+	assert.Equal(t, 0, runs)
+
+	e.setLeader(true)
+	require.NoError(t, job(context.Background()))
+	assert.Equal(t, 1, runs)
+}
+
+func TestElector_ShouldNotUseTheJobLabel(t *testing.T) {
+	// The "job" label is attached by Prometheus to every scraped series.
+	reg := prometheus.NewPedanticRegistry()
+	e := NewElector(Config{LeaseDuration: time.Minute, RenewInterval: time.Second}, nil, "retention", "instance-1", log.NewNopLogger(), reg)
+	e.setLeader(true)
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_leader_election_is_leader 1 if this instance is the elected leader of the job, 0 otherwise.
+		# TYPE cortex_leader_election_is_leader gauge
+		cortex_leader_election_is_leader{election="retention"} 1
+	`), "cortex_leader_election_is_leader"))
+}
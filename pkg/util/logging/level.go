@@ -0,0 +1,197 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+
+	util_log "github.com/cortexproject/cortex/pkg/util/log"
+)
+
+const (
+	// DefaultLevelDuration is how long a level change lasts if no duration is requested.
+	DefaultLevelDuration = 10 * time.Minute
+
+	// MaxLevelDuration bounds the duration of a level change, so that a forgotten debug
+	// level doesn't flood the logs for days.
+	MaxLevelDuration = 4 * time.Hour
+)
+
+var (
+	errUnsupportedLevel = errors.New("unsupported log level, expected one of debug, info, warn, error")
+	errInvalidDuration  = errors.New("the log level duration must be greater than 0 and at most 4h")
+)
+
+// LevelStatus is the log level status returned by the HTTP endpoint.
+type LevelStatus struct {
+	Level        string    `json:"level"`
+	DefaultLevel string    `json:"default_level"`
+	RevertAt     time.Time `json:"revert_at,omitempty"`
+}
+
+// LevelSwitch changes the level of the logger at runtime for a bounded duration, after
+// which the configured level is restored.
+type LevelSwitch struct {
+	out          log.Logger
+	defaultLevel string
+
+	// logger logs the level changes. It's the switch itself unless set before the switch
+	// is used, and never util_log.Logger, which InitLogger replaces.
+	logger log.Logger
+
+	mtx      sync.RWMutex
+	filtered log.Logger
+	current  string
+	revertAt time.Time
+	timer    *time.Timer
+}
+
+func newLevelSwitch(out log.Logger, defaultLevel string) *LevelSwitch {
+	s := &LevelSwitch{out: out, defaultLevel: defaultLevel}
+	s.logger = s
+	s.setLocked(defaultLevel)
+	return s
+}
+
+// Log implements log.Logger, filtering the lines by the current level.
+func (s *LevelSwitch) Log(kv ...interface{}) error {
+	s.mtx.RLock()
+	l := s.filtered
+	s.mtx.RUnlock()
+
+	return l.Log(kv...)
+}
+
+// Set changes the level for the input duration.
+func (s *LevelSwitch) Set(lvl string, d time.Duration) error {
+	if !isSupportedLevel(lvl) {
+		return errUnsupportedLevel
+	}
+	if d <= 0 || d > MaxLevelDuration {
+		return errInvalidDuration
+	}
+
+	s.mtx.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.setLocked(lvl)
+	s.revertAt = time.Now().Add(d)
+	s.timer = time.AfterFunc(d, s.Reset)
+	revertAt := s.revertAt
+	s.mtx.Unlock()
+
+	// Logged once unlocked, since the line goes through the switch.
+	level.Info(s.logger).Log("msg", "log level changed", "level", lvl, "revert_at", revertAt)
+	return nil
+}
+
+// Reset restores the configured level.
+func (s *LevelSwitch) Reset() {
+	s.mtx.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	changed := s.current != s.defaultLevel
+	s.setLocked(s.defaultLevel)
+	s.revertAt = time.Time{}
+	s.mtx.Unlock()
+
+	if changed {
+		level.Info(s.logger).Log("msg", "log level restored", "level", s.defaultLevel)
+	}
+}
+
+// Status returns the current level and when it's reverted.
+func (s *LevelSwitch) Status() LevelStatus {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return LevelStatus{Level: s.current, DefaultLevel: s.defaultLevel, RevertAt: s.revertAt}
+}
+
+func (s *LevelSwitch) setLocked(lvl string) {
+	s.current = lvl
+	s.filtered = level.NewFilter(s.out, util_log.LevelFilter(lvl))
+}
+
+// ServeHTTP serves the current level on GET. On POST, it changes the level to the level
+// form value, for the duration form value or DefaultLevelDuration. DELETE restores the
+// configured level.
+func (s *LevelSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		d := DefaultLevelDuration
+		if v := r.FormValue("duration"); v != "" {
+			var err error
+			if d, err = time.ParseDuration(v); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := s.Set(r.FormValue("level"), d); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		s.Reset()
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.Status()); err != nil {
+		level.Error(s.logger).Log("msg", "failed to encode log level status", "err", err)
+	}
+}
+
+// HandleSignals toggles the debug level on SIGUSR1, for DefaultLevelDuration, until stop
+// is closed. A signal received while the debug level is set restores the configured level.
+func (s *LevelSwitch) HandleSignals(stop <-chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-signals:
+			if s.Status().Level == "debug" {
+				s.Reset()
+				continue
+			}
+			if err := s.Set("debug", DefaultLevelDuration); err != nil {
+				level.Error(s.logger).Log("msg", "failed to change the log level", "err", err)
+			}
+		}
+	}
+}
+
+func isSupportedLevel(lvl string) bool {
+	switch lvl {
+	case "debug", "info", "warn", "error":
+		return true
+	}
+	return false
+}
+
+// levelSwitch is the switch of the logger built by InitLogger.
+var levelSwitch *LevelSwitch
+
+// Level returns the LevelSwitch of the logger built by InitLogger, or nil if the logger
+// has not been built by InitLogger.
+func Level() *LevelSwitch {
+	return levelSwitch
+}
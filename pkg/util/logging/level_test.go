@@ -0,0 +1,102 @@
+package logging
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLevelSwitch_Set(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s := newLevelSwitch(log.NewLogfmtLogger(buf), "info")
+
+	// The level changes are logged apart, so that the buffer is only written by the test.
+	restored := make(chan struct{})
+	s.logger = log.LoggerFunc(func(kv ...interface{}) error {
+		for i := 1; i < len(kv); i += 2 {
+			if kv[i] == "log level restored" {
+				close(restored)
+			}
+		}
+		return nil
+	})
+
+	level.Debug(s).Log("msg", "before")
+	require.NoError(t, s.Set("debug", 50*time.Millisecond))
+	level.Debug(s).Log("msg", "during")
+
+	// The configured level is restored once the duration elapsed.
+	select {
+	case <-restored:
+	case <-time.After(time.Second):
+		t.Fatal("the configured level has not been restored")
+	}
+	assert.Equal(t, "info", s.Status().Level)
+	level.Debug(s).Log("msg", "after")
+
+	assert.NotContains(t, buf.String(), "msg=before")
+	assert.Contains(t, buf.String(), "msg=during")
+	assert.NotContains(t, buf.String(), "msg=after")
+}
+
+func TestLevelSwitch_SetValidation(t *testing.T) {
+	s := newLevelSwitch(log.NewNopLogger(), "info")
+
+	tests := map[string]struct {
+		level    string
+		duration time.Duration
+		expected error
+	}{
+		"valid": {
+			level:    "debug",
+			duration: time.Minute,
+			expected: nil,
+		},
+		"unsupported level": {
+			level:    "trace",
+			duration: time.Minute,
+			expected: errUnsupportedLevel,
+		},
+		"duration too long": {
+			level:    "debug",
+			duration: MaxLevelDuration + time.Minute,
+			expected: errInvalidDuration,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, s.Set(tc.level, tc.duration), tc.expected)
+			s.Reset()
+		})
+	}
+}
+
+func TestLevelSwitch_ServeHTTP(t *testing.T) {
+	s := newLevelSwitch(log.NewNopLogger(), "info")
+	t.Cleanup(s.Reset)
+
+	form := url.Values{"level": {"debug"}, "duration": {"5m"}}
+	req := httptest.NewRequest(http.MethodPost, "/log_level", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "debug", s.Status().Level)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), s.Status().RevertAt, time.Minute)
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/log_level", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "info", s.Status().Level)
+}
@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"context"
+	"os"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/weaveworks/common/user"
+
+	"objectstorage/pkg/util/tracing"
+)
+
+// The keys of the values logged by all components, so that the log lines of a tenant or a
+// request can be correlated.
+const (
+	KeyTenant  = "tenant"
+	KeyMethod  = "method"
+	KeyTraceID = "traceID"
+)
+
+// NewBootstrapLogger returns the logger used until the -log.level and -log.format flags
+// are parsed: logfmt at info level, to stderr.
+func NewBootstrapLogger() log.Logger {
+	l := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	l = level.NewFilter(l, level.AllowInfo())
+	return log.With(l, "ts", log.DefaultTimestampUTC)
+}
+
+// WithTenant returns the logger with the tenant key.
+func WithTenant(logger log.Logger, tenantID string) log.Logger {
+	return log.With(logger, KeyTenant, tenantID)
+}
+
+// WithContext returns the logger with the tenant and trace ID of the context, if any.
+func WithContext(ctx context.Context, logger log.Logger) log.Logger {
+	if tenantID, err := user.ExtractOrgID(ctx); err == nil {
+		logger = WithTenant(logger, tenantID)
+	}
+	if traceID := tracing.TraceIDFromContext(ctx); traceID != "" {
+		logger = log.With(logger, KeyTraceID, traceID)
+	}
+	return logger
+}
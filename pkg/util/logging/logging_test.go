@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestWithContext(t *testing.T) {
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	require.NoError(t, err)
+
+	tracedCtx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+
+	tests := map[string]struct {
+		ctx      context.Context
+		expected string
+	}{
+		"empty context": {
+			ctx:      context.Background(),
+			expected: "msg=test\n",
+		},
+		"tenant": {
+			ctx:      user.InjectOrgID(context.Background(), "user-1"),
+			expected: "tenant=user-1 msg=test\n",
+		},
+		"tenant and trace": {
+			ctx:      user.InjectOrgID(tracedCtx, "user-1"),
+			expected: "tenant=user-1 traceID=4bf92f3577b34da6a3ce929d0e0e4736 msg=test\n",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			require.NoError(t, WithContext(tc.ctx, log.NewLogfmtLogger(buf)).Log("msg", "test"))
+			assert.Equal(t, tc.expected, buf.String())
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package logging
+
+import (
+	"flag"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	weaveworks_logging "github.com/weaveworks/common/logging"
+	"github.com/weaveworks/common/server"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	util_log "github.com/cortexproject/cortex/pkg/util/log"
+)
+
+var (
+	errInvalidMaxSize = errors.New("the log file max size must be greater than 0")
+	errInvalidMaxAge  = errors.New("the log file max age must be 0 or at least 1 day")
+)
+
+// FileConfig holds the configuration of the log file, written in addition to stderr.
+type FileConfig struct {
+	Path       string        `yaml:"path"`
+	MaxSizeMB  int           `yaml:"max_size_mb"`
+	MaxAge     time.Duration `yaml:"max_age"`
+	MaxBackups int           `yaml:"max_backups"`
+	Compress   bool          `yaml:"compress"`
+}
+
+// RegisterFlags registers the log file flags.
+func (cfg *FileConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.Path, "log.file.path", "", "Path of a file the logs are written to, in addition to stderr. Empty to only log to stderr.")
+	f.IntVar(&cfg.MaxSizeMB, "log.file.max-size-mb", 100, "Size in megabytes after which the log file is rotated.")
+	f.DurationVar(&cfg.MaxAge, "log.file.max-age", 7*24*time.Hour, "Rotated log files older than this are deleted. Rounded down to days. 0 to keep them regardless of their age.")
+	f.IntVar(&cfg.MaxBackups, "log.file.max-backups", 10, "Maximum number of rotated log files kept. 0 to keep all of them.")
+	f.BoolVar(&cfg.Compress, "log.file.compress", true, "True to gzip the rotated log files.")
+}
+
+// Validate the config.
+func (cfg *FileConfig) Validate() error {
+	if cfg.Path == "" {
+		return nil
+	}
+	if cfg.MaxSizeMB <= 0 {
+		return errInvalidMaxSize
+	}
+	if cfg.MaxAge != 0 && cfg.MaxAge < 24*time.Hour {
+		return errInvalidMaxAge
+	}
+	return nil
+}
+
+// logMessages is the counter of util_log.PrometheusLogger, reused since the logger is
+// replaced by the one built here.
+var logMessages = registerLogMessages()
+
+func registerLogMessages() *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_messages_total",
+		Help: "Total number of log messages.",
+	}, []string{"level"})
+
+	if err := prometheus.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector.(*prometheus.CounterVec)
+		}
+		panic(err)
+	}
+	return c
+}
+
+// InitLogger replaces util_log.InitLogger: it initialises the global util_log.Logger and the
+// server logger, writing to stderr and, if configured, to the rotated log file. The returned
+// closer closes the log file.
+func InitLogger(serverCfg *server.Config, fileCfg FileConfig) (io.Closer, error) {
+	if err := fileCfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	var (
+		w      io.Writer = os.Stderr
+		closer io.Closer = nopCloser{}
+	)
+	if fileCfg.Path != "" {
+		file := &lumberjack.Logger{
+			Filename:   fileCfg.Path,
+			MaxSize:    fileCfg.MaxSizeMB,
+			MaxAge:     int(fileCfg.MaxAge / (24 * time.Hour)),
+			MaxBackups: fileCfg.MaxBackups,
+			Compress:   fileCfg.Compress,
+		}
+		w = io.MultiWriter(os.Stderr, file)
+		closer = file
+	}
+
+	l := newLogger(log.NewSyncWriter(w), serverCfg.LogFormat, serverCfg.LogLevel)
+
+	// When using util_log.Logger, skip 3 stack frames, 4 when wrapped by the server logger.
+	util_log.Logger = log.With(l, "caller", log.Caller(3))
+	serverCfg.Log = weaveworks_logging.GoKit(log.With(l, "caller", log.Caller(4)))
+	return closer, nil
+}
+
+func newLogger(w io.Writer, format weaveworks_logging.Format, lvl weaveworks_logging.Level) log.Logger {
+	var out log.Logger
+	if format.String() == "json" {
+		out = log.NewJSONLogger(w)
+	} else {
+		out = log.NewLogfmtLogger(w)
+	}
+
+	// The level is filtered by a switch, so that it can be changed at runtime.
+	sw := newLevelSwitch(out, lvl.String())
+	counted := log.LoggerFunc(func(kv ...interface{}) error {
+		err := sw.Log(kv...)
+		logMessages.WithLabelValues(levelOf(kv)).Inc()
+		return err
+	})
+	l := log.With(counted, "ts", log.DefaultTimestampUTC)
+	sw.logger = l
+	levelSwitch = sw
+	return l
+}
+
+// levelOf returns the level of the log line, or "unknown" if not leveled.
+func levelOf(kv []interface{}) string {
+	for i := 1; i < len(kv); i += 2 {
+		if v, ok := kv[i].(level.Value); ok {
+			return v.String()
+		}
+	}
+	return "unknown"
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/server"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	util_log "github.com/cortexproject/cortex/pkg/util/log"
+)
+
+func TestFileConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *FileConfig)
+		expected error
+	}{
+		"default config": {
+			setup:    func(*FileConfig) {},
+			expected: nil,
+		},
+		"file with default rotation": {
+			setup:    func(cfg *FileConfig) { cfg.Path = "/var/log/ingester.log" },
+			expected: nil,
+		},
+		"invalid max size": {
+			setup: func(cfg *FileConfig) {
+				cfg.Path = "/var/log/ingester.log"
+				cfg.MaxSizeMB = 0
+			},
+			expected: errInvalidMaxSize,
+		},
+		"max age shorter than a day": {
+			setup: func(cfg *FileConfig) {
+				cfg.Path = "/var/log/ingester.log"
+				cfg.MaxAge = time.Hour
+			},
+			expected: errInvalidMaxAge,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := FileConfig{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+			assert.ErrorIs(t, cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+func TestInitLogger_File(t *testing.T) {
+	prev := util_log.Logger
+	t.Cleanup(func() { util_log.Logger = prev })
+
+	fileCfg := FileConfig{}
+	flagext.DefaultValues(&fileCfg)
+	fileCfg.Path = filepath.Join(t.TempDir(), "ingester.log")
+
+	serverCfg := server.Config{}
+	require.NoError(t, serverCfg.LogLevel.Set("info"))
+	require.NoError(t, serverCfg.LogFormat.Set("logfmt"))
+
+	closer, err := InitLogger(&serverCfg, fileCfg)
+	require.NoError(t, err)
+
+	level.Debug(util_log.Logger).Log("msg", "filtered")
+	level.Info(util_log.Logger).Log("msg", "written")
+	require.NoError(t, closer.Close())
+
+	content, err := os.ReadFile(fileCfg.Path)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "msg=written")
+	assert.NotContains(t, string(content), "msg=filtered")
+}
@@ -0,0 +1,184 @@
+package logging
+
+import (
+	"context"
+	"flag"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/weaveworks/common/middleware"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"objectstorage/pkg/util/tracing"
+)
+
+var (
+	errInvalidSampleRatio   = errors.New("the requests log success sample ratio must be between 0 and 1")
+	errInvalidSlowThreshold = errors.New("the slow request threshold must be greater than or equal to 0")
+)
+
+// RequestsConfig holds the configuration of the requests log.
+type RequestsConfig struct {
+	Enabled              bool          `yaml:"enabled"`
+	SuccessSampleRatio   float64       `yaml:"success_sample_ratio"`
+	SlowRequestThreshold time.Duration `yaml:"slow_request_threshold"`
+}
+
+// RegisterFlags registers the requests log flags.
+func (cfg *RequestsConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "log.requests.enabled", false, "True to log one line per HTTP and gRPC request, with its latency, status, tenant and size.")
+	f.Float64Var(&cfg.SuccessSampleRatio, "log.requests.success-sample-ratio", 0.01, "Fraction of the successful requests which are logged. Failed requests are always logged.")
+	f.DurationVar(&cfg.SlowRequestThreshold, "log.requests.slow-request-threshold", 0, "Requests slower than this are always logged, even if the requests log is disabled. Slow push requests are logged with their size, number of series and time spent in each stage. 0 to disable.")
+}
+
+// Validate the config.
+func (cfg *RequestsConfig) Validate() error {
+	if cfg.SuccessSampleRatio < 0 || cfg.SuccessSampleRatio > 1 {
+		return errInvalidSampleRatio
+	}
+	if cfg.SlowRequestThreshold < 0 {
+		return errInvalidSlowThreshold
+	}
+	return nil
+}
+
+// RequestLogger logs the HTTP and gRPC requests, sampling the successful ones. If the
+// requests log is disabled, only the slow requests are logged.
+type RequestLogger struct {
+	cfg    RequestsConfig
+	logger log.Logger
+
+	// sample returns a random number in [0, 1), replaced in tests.
+	sample func() float64
+}
+
+// NewRequestLogger makes a new RequestLogger.
+func NewRequestLogger(cfg RequestsConfig, logger log.Logger) *RequestLogger {
+	return &RequestLogger{
+		cfg:    cfg,
+		logger: logger,
+		sample: rand.Float64,
+	}
+}
+
+func (l *RequestLogger) shouldLog(failed, slow bool) bool {
+	if slow {
+		return true
+	}
+	if !l.cfg.Enabled {
+		return false
+	}
+	return failed || (l.cfg.SuccessSampleRatio > 0 && l.sample() < l.cfg.SuccessSampleRatio)
+}
+
+func (l *RequestLogger) isSlow(elapsed time.Duration) bool {
+	return l.cfg.SlowRequestThreshold > 0 && elapsed >= l.cfg.SlowRequestThreshold
+}
+
+// HTTPMiddleware returns the middleware logging the HTTP requests. The tenant is read from
+// the X-Scope-OrgID header once the request has been handled, so that the tenant resolved by
+// the route authentication is logged.
+func (l *RequestLogger) HTTPMiddleware() middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			elapsed := time.Since(start)
+			slow := l.isSlow(elapsed)
+			if !l.shouldLog(rec.status >= 400, slow) {
+				return
+			}
+
+			logger := log.With(l.logger, KeyMethod, r.Method, "path", r.URL.Path, "status", rec.status,
+				"duration", elapsed, "request_bytes", r.ContentLength, "response_bytes", rec.bytes)
+			if tenantID := r.Header.Get(user.OrgIDHeaderName); tenantID != "" {
+				logger = WithTenant(logger, tenantID)
+			}
+			if traceID := tracing.TraceID(r); traceID != "" {
+				logger = log.With(logger, KeyTraceID, traceID)
+			}
+
+			switch {
+			case rec.status >= 500:
+				level.Error(logger).Log("msg", "HTTP request failed", "slow", slow)
+			case rec.status >= 400:
+				level.Warn(logger).Log("msg", "HTTP request failed", "slow", slow)
+			case slow:
+				level.Warn(logger).Log("msg", "slow HTTP request")
+			default:
+				level.Info(logger).Log("msg", "HTTP request")
+			}
+		})
+	})
+}
+
+// UnaryServerInterceptor returns the gRPC interceptor logging the unary requests.
+func (l *RequestLogger) UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	elapsed := time.Since(start)
+	slow := l.isSlow(elapsed)
+	code := status.Code(err)
+	if !l.shouldLog(err != nil, slow) {
+		return resp, err
+	}
+
+	logger := log.With(l.logger, KeyMethod, info.FullMethod, "status", code.String(), "duration", elapsed, "slow", slow,
+		"request_bytes", messageSize(req), "response_bytes", messageSize(resp))
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(user.OrgIDHeaderName); len(values) > 0 {
+			logger = WithTenant(logger, values[0])
+		}
+	}
+	if traceID := tracing.TraceIDFromContext(ctx); traceID != "" {
+		logger = log.With(logger, KeyTraceID, traceID)
+	}
+
+	switch {
+	case code == codes.OK && slow:
+		level.Warn(logger).Log("msg", "slow gRPC request")
+	case code == codes.OK:
+		level.Info(logger).Log("msg", "gRPC request")
+	case code == codes.Internal, code == codes.Unavailable, code == codes.Unknown, code == codes.DataLoss:
+		level.Error(logger).Log("msg", "gRPC request failed", "err", err)
+	default:
+		level.Warn(logger).Log("msg", "gRPC request failed", "err", err)
+	}
+	return resp, err
+}
+
+// messageSize returns the size of the protobuf message, or 0 if unknown.
+func messageSize(m interface{}) int {
+	if sized, ok := m.(interface{ Size() int }); ok {
+		return sized.Size()
+	}
+	return 0
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
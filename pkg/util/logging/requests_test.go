@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestRequestLogger_HTTPMiddleware(t *testing.T) {
+	tests := map[string]struct {
+		status   int
+		sample   float64
+		expected []string
+	}{
+		"sampled success": {
+			status:   http.StatusOK,
+			sample:   0.05,
+			expected: []string{"level=info", "method=POST", "path=/api/v1/push", "status=200", "tenant=user-1", "request_bytes=4", "response_bytes=2"},
+		},
+		"not sampled success": {
+			status: http.StatusOK,
+			sample: 0.5,
+		},
+		"client error is always logged": {
+			status:   http.StatusBadRequest,
+			sample:   0.5,
+			expected: []string{"level=warn", "status=400", "tenant=user-1"},
+		},
+		"server error is always logged": {
+			status:   http.StatusInternalServerError,
+			sample:   0.5,
+			expected: []string{"level=error", "status=500"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			l := NewRequestLogger(RequestsConfig{Enabled: true, SuccessSampleRatio: 0.1}, log.NewLogfmtLogger(buf))
+			l.sample = func() float64 { return tc.sample }
+
+			handler := l.HTTPMiddleware().Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// The tenant is resolved by the route authentication.
+				r.Header.Set(user.OrgIDHeaderName, "user-1")
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte("ok"))
+			}))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/push", strings.NewReader("data")))
+
+			if len(tc.expected) == 0 {
+				assert.Empty(t, buf.String())
+			}
+			for _, expected := range tc.expected {
+				assert.Contains(t, buf.String(), expected)
+			}
+		})
+	}
+}
+
+func TestRequestLogger_UnaryServerInterceptor(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewRequestLogger(RequestsConfig{Enabled: true, SuccessSampleRatio: 0}, log.NewLogfmtLogger(buf))
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("X-Scope-OrgID", "user-1"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/cortex.Ingester/Push"}
+
+	_, err := l.UnaryServerInterceptor(ctx, nil, info, func(context.Context, interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Empty(t, buf.String())
+
+	_, err = l.UnaryServerInterceptor(ctx, nil, info, func(context.Context, interface{}) (interface{}, error) {
+		return nil, status.Error(codes.Unavailable, "unavailable")
+	})
+	require.Error(t, err)
+	for _, expected := range []string{"level=error", "method=/cortex.Ingester/Push", "status=Unavailable", "tenant=user-1"} {
+		assert.Contains(t, buf.String(), expected)
+	}
+}
+
+func TestRequestLogger_SlowRequests(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewRequestLogger(RequestsConfig{Enabled: false, SlowRequestThreshold: 10 * time.Millisecond}, log.NewLogfmtLogger(buf))
+
+	fast := l.HTTPMiddleware().Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	fast.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/ingester/read-only", nil))
+	assert.Empty(t, buf.String())
+
+	slow := l.HTTPMiddleware().Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	slow.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/ingester/read-only", nil))
+	assert.Contains(t, buf.String(), "level=warn")
+	assert.Contains(t, buf.String(), `msg="slow HTTP request"`)
+	assert.Contains(t, buf.String(), "path=/ingester/read-only")
+}
@@ -0,0 +1,138 @@
+package memlimit
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// cgroupRoot is where the cgroup filesystem of the container is mounted.
+	cgroupRoot = "/sys/fs/cgroup"
+
+	// cgroupV1Unlimited is the lowest cgroup v1 limit considered as no limit: the kernel
+	// reports the max int64 rounded down to the page size when no limit is set.
+	cgroupV1Unlimited = int64(1) << 62
+)
+
+var (
+	errInvalidAutoRatio = errors.New("the memory limit auto ratio must be between 0 and 1")
+	errInvalidBytes     = errors.New("the memory limit bytes must be 0 or greater")
+)
+
+// Config holds the configuration of the Go runtime soft memory limit (GOMEMLIMIT).
+type Config struct {
+	AutoRatio float64 `yaml:"auto_ratio"`
+	Bytes     int64   `yaml:"bytes"`
+}
+
+// RegisterFlags registers the memory limit flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.Float64Var(&cfg.AutoRatio, "memory-limit.auto-ratio", 0.9, "Fraction of the container memory limit, detected from the cgroup, the Go runtime soft memory limit is set to at startup. Ignored if the GOMEMLIMIT environment variable or -memory-limit.bytes is set. 0 to disable.")
+	f.Int64Var(&cfg.Bytes, "memory-limit.bytes", 0, "Go runtime soft memory limit in bytes, overriding both the GOMEMLIMIT environment variable and the limit detected from the container. 0 to disable.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if cfg.AutoRatio < 0 || cfg.AutoRatio > 1 {
+		return errInvalidAutoRatio
+	}
+	if cfg.Bytes < 0 {
+		return errInvalidBytes
+	}
+	return nil
+}
+
+// Apply sets the Go runtime soft memory limit according to the config, and exposes the
+// container memory limit and the chosen Go limit as metrics. It returns the Go limit, or
+// math.MaxInt64 if none is set.
+func Apply(cfg Config, logger log.Logger, reg prometheus.Registerer) (int64, error) {
+	return apply(cfg, cgroupRoot, logger, reg)
+}
+
+func apply(cfg Config, root string, logger log.Logger, reg prometheus.Registerer) (int64, error) {
+	containerLimit, err := ContainerLimit(root)
+	if err != nil {
+		return 0, errors.Wrap(err, "detect the container memory limit")
+	}
+
+	var source string
+	switch {
+	case cfg.Bytes > 0:
+		debug.SetMemoryLimit(cfg.Bytes)
+		source = "flag"
+	case os.Getenv("GOMEMLIMIT") != "":
+		// Already applied by the Go runtime.
+		source = "env"
+	case cfg.AutoRatio > 0 && containerLimit > 0:
+		debug.SetMemoryLimit(int64(float64(containerLimit) * cfg.AutoRatio))
+		source = "container"
+	default:
+		source = "none"
+	}
+
+	// A negative input only reads the current limit.
+	limit := debug.SetMemoryLimit(-1)
+
+	promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "cortex_memory_limit_container_bytes",
+		Help: "Memory limit of the container, detected from the cgroup. 0 if not limited.",
+	}).Set(float64(containerLimit))
+	promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cortex_memory_limit_go_bytes",
+		Help: "Soft memory limit of the Go runtime (GOMEMLIMIT), by where it has been chosen from.",
+	}, []string{"source"}).WithLabelValues(source).Set(float64(limit))
+
+	if source != "none" {
+		level.Info(logger).Log("msg", "Go runtime memory limit set", "limit_bytes", limit, "source", source, "container_limit_bytes", containerLimit)
+	}
+	return limit, nil
+}
+
+// ContainerLimit returns the memory limit of the cgroup mounted at root, either v2 or v1,
+// or 0 if there's no limit or no cgroup.
+func ContainerLimit(root string) (int64, error) {
+	// cgroup v2, unified hierarchy.
+	if v, err := readCgroupFile(filepath.Join(root, "memory.max")); err != nil || v != "" {
+		if err != nil || v == "max" {
+			return 0, err
+		}
+		return strconv.ParseInt(v, 10, 64)
+	}
+
+	// cgroup v1, memory controller hierarchy.
+	v, err := readCgroupFile(filepath.Join(root, "memory", "memory.limit_in_bytes"))
+	if err != nil || v == "" {
+		return 0, err
+	}
+	limit, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if limit >= cgroupV1Unlimited {
+		return 0, nil
+	}
+	return limit, nil
+}
+
+// readCgroupFile returns the trimmed content of the file, or an empty string if it
+// doesn't exist.
+func readCgroupFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
@@ -0,0 +1,156 @@
+package memlimit
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *Config)
+		expected error
+	}{
+		"default config": {
+			setup:    func(*Config) {},
+			expected: nil,
+		},
+		"disabled": {
+			setup:    func(cfg *Config) { cfg.AutoRatio = 0 },
+			expected: nil,
+		},
+		"auto ratio greater than 1": {
+			setup:    func(cfg *Config) { cfg.AutoRatio = 1.1 },
+			expected: errInvalidAutoRatio,
+		},
+		"negative bytes": {
+			setup:    func(cfg *Config) { cfg.Bytes = -1 },
+			expected: errInvalidBytes,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+			assert.ErrorIs(t, cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+func TestContainerLimit(t *testing.T) {
+	tests := map[string]struct {
+		files    map[string]string
+		expected int64
+	}{
+		"no cgroup": {
+			expected: 0,
+		},
+		"cgroup v2": {
+			files:    map[string]string{"memory.max": "1073741824\n"},
+			expected: 1 << 30,
+		},
+		"cgroup v2 without limit": {
+			files:    map[string]string{"memory.max": "max\n"},
+			expected: 0,
+		},
+		"cgroup v1": {
+			files:    map[string]string{"memory/memory.limit_in_bytes": "536870912\n"},
+			expected: 1 << 29,
+		},
+		"cgroup v1 without limit": {
+			files:    map[string]string{"memory/memory.limit_in_bytes": "9223372036854771712\n"},
+			expected: 0,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, tc.files)
+
+			limit, err := ContainerLimit(root)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, limit)
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	prev := debug.SetMemoryLimit(-1)
+	t.Cleanup(func() { debug.SetMemoryLimit(prev) })
+
+	tests := map[string]struct {
+		setup          func(cfg *Config)
+		env            string
+		expectedLimit  int64
+		expectedSource string
+	}{
+		"from the container": {
+			setup:          func(*Config) {},
+			expectedLimit:  900 << 20,
+			expectedSource: "container",
+		},
+		"flag override": {
+			setup:          func(cfg *Config) { cfg.Bytes = 512 << 20 },
+			env:            "256MiB",
+			expectedLimit:  512 << 20,
+			expectedSource: "flag",
+		},
+		"disabled": {
+			setup:          func(cfg *Config) { cfg.AutoRatio = 0 },
+			expectedLimit:  math.MaxInt64,
+			expectedSource: "none",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("GOMEMLIMIT", tc.env)
+			debug.SetMemoryLimit(math.MaxInt64)
+
+			root := t.TempDir()
+			writeFiles(t, root, map[string]string{"memory.max": "1048576000"})
+
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+
+			reg := prometheus.NewPedanticRegistry()
+			limit, err := apply(cfg, root, log.NewNopLogger(), reg)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedLimit, limit)
+			assert.Equal(t, tc.expectedLimit, debug.SetMemoryLimit(-1))
+
+			assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+				# HELP cortex_memory_limit_container_bytes Memory limit of the container, detected from the cgroup. 0 if not limited.
+				# TYPE cortex_memory_limit_container_bytes gauge
+				cortex_memory_limit_container_bytes 1.048576e+09
+				# HELP cortex_memory_limit_go_bytes Soft memory limit of the Go runtime (GOMEMLIMIT), by where it has been chosen from.
+				# TYPE cortex_memory_limit_go_bytes gauge
+				cortex_memory_limit_go_bytes{source="`+tc.expectedSource+`"} `+strconv.FormatFloat(float64(tc.expectedLimit), 'g', -1, 64)+`
+			`), "cortex_memory_limit_container_bytes", "cortex_memory_limit_go_bytes"))
+		})
+	}
+}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+}
@@ -0,0 +1,154 @@
+package promapi
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"objectstorage/pkg/util/utf8names"
+)
+
+// Error types of the Prometheus HTTP API.
+const (
+	ErrorBadData     = "bad_data"
+	ErrorExecution   = "execution"
+	ErrorTimeout     = "timeout"
+	ErrorCanceled    = "canceled"
+	ErrorInternal    = "internal"
+	ErrorUnavailable = "unavailable"
+)
+
+// Bounds of the time range when the start or end parameter is missing, matching the
+// Prometheus HTTP API.
+var (
+	MinTime = time.Unix(math.MinInt64/1000+62135596801, 0).UTC()
+	MaxTime = time.Unix(math.MaxInt64/1000-62135596801, 999999999).UTC()
+)
+
+// Response is the envelope of the Prometheus HTTP API responses.
+type Response struct {
+	Status    string      `json:"status"`
+	Data      interface{} `json:"data,omitempty"`
+	ErrorType string      `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Warnings  []string    `json:"warnings,omitempty"`
+}
+
+// WriteSuccess writes the data in a successful response.
+func WriteSuccess(w http.ResponseWriter, data interface{}, warnings []string, logger log.Logger) {
+	write(w, http.StatusOK, Response{Status: "success", Data: data, Warnings: warnings}, logger)
+}
+
+// WriteError writes the error in a failed response, with the status code of its type.
+func WriteError(w http.ResponseWriter, errorType string, err error, logger log.Logger) {
+	write(w, statusCode(errorType), Response{Status: "error", ErrorType: errorType, Error: err.Error()}, logger)
+}
+
+func write(w http.ResponseWriter, code int, resp Response, logger log.Logger) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		level.Error(logger).Log("msg", "failed to encode the API response", "err", err)
+	}
+}
+
+func statusCode(errorType string) int {
+	switch errorType {
+	case ErrorBadData:
+		return http.StatusBadRequest
+	case ErrorExecution:
+		return http.StatusUnprocessableEntity
+	case ErrorCanceled:
+		return 499
+	case ErrorTimeout, ErrorUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ParseTime parses the value of a time parameter, either a Unix timestamp in seconds or an
+// RFC 3339 date. The default is returned if the value is empty.
+func ParseTime(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if t, err := strconv.ParseFloat(value, 64); err == nil {
+		s, ns := math.Modf(t)
+		ns = math.Round(ns*1000) / 1000
+		return time.Unix(int64(s), int64(ns*float64(time.Second))).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.Errorf("cannot parse %q to a valid timestamp", value)
+}
+
+// ParseDuration parses the value of a duration parameter, either a number of seconds or a
+// Prometheus duration.
+func ParseDuration(value string) (time.Duration, error) {
+	if d, err := strconv.ParseFloat(value, 64); err == nil {
+		ts := d * float64(time.Second)
+		if ts > float64(math.MaxInt64) || ts < float64(math.MinInt64) {
+			return 0, errors.Errorf("cannot parse %q to a valid duration, it overflows int64", value)
+		}
+		return time.Duration(ts), nil
+	}
+	if d, err := model.ParseDuration(value); err == nil {
+		return time.Duration(d), nil
+	}
+	return 0, errors.Errorf("cannot parse %q to a valid duration", value)
+}
+
+// ParseTimeRange parses the start and end parameters of the request, defaulting to the
+// whole time range.
+func ParseTimeRange(r *http.Request) (time.Time, time.Time, error) {
+	start, err := ParseTime(r.FormValue("start"), MinTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.Wrap(err, "invalid start")
+	}
+	end, err := ParseTime(r.FormValue("end"), MaxTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.Wrap(err, "invalid end")
+	}
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, errors.New("end timestamp must not be before start time")
+	}
+	return start, end, nil
+}
+
+// ParseMatchers parses the series selectors of the match[] parameter. The quoted and the
+// escaped UTF-8 names are accepted if the tenant has the UTF-8 names enabled.
+func ParseMatchers(r *http.Request) ([][]*labels.Matcher, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	_, utf8Names := utf8names.FromContext(r.Context())
+
+	var sets [][]*labels.Matcher
+	for _, s := range r.Form["match[]"] {
+		if utf8Names {
+			s = utf8names.RewriteQuotedNames(s)
+		}
+		matchers, err := parser.ParseMetricSelector(s)
+		if err != nil {
+			return nil, err
+		}
+		if utf8Names {
+			if matchers, err = utf8names.UnescapeMatchers(matchers); err != nil {
+				return nil, err
+			}
+		}
+		sets = append(sets, matchers)
+	}
+	return sets, nil
+}
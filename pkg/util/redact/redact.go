@@ -0,0 +1,103 @@
+package redact
+
+import (
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"objectstorage/pkg/util/flagext"
+)
+
+// sensitiveWords are the words of the config keys whose values are masked. Keys are
+// split on underscores, so that e.g. num_tokens isn't considered sensitive.
+var sensitiveWords = map[string]struct{}{
+	"password":    {},
+	"passwd":      {},
+	"secret":      {},
+	"token":       {},
+	"credentials": {},
+}
+
+// sensitiveKeys are the config keys whose values are masked, in addition to the ones
+// containing a sensitive word.
+var sensitiveKeys = map[string]struct{}{
+	"account_key":     {},
+	"api_key":         {},
+	"private_key":     {},
+	"service_account": {},
+}
+
+// YAML marshals the input config to YAML, masking the values of sensitive keys. Secrets
+// of type flagext.Secret are always masked; this also covers the secrets held in plain
+// strings by the upstream configs.
+func YAML(cfg interface{}) ([]byte, error) {
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree yaml.MapSlice
+	if err := yaml.Unmarshal(out, &tree); err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(redactMap(tree))
+}
+
+// ConfigHandler returns an HTTP handler serving the input config as YAML, with the
+// sensitive values masked.
+func ConfigHandler(cfg interface{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		out, err := YAML(cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/yaml")
+		_, _ = w.Write(out)
+	})
+}
+
+func redactMap(m yaml.MapSlice) yaml.MapSlice {
+	for i, item := range m {
+		key, _ := item.Key.(string)
+		if isSensitive(key) {
+			if s, ok := item.Value.(string); ok && s != "" {
+				m[i].Value = flagext.SecretMask
+				continue
+			}
+		}
+		m[i].Value = redactValue(item.Value)
+	}
+	return m
+}
+
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		return redactMap(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = redactValue(v[i])
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	if _, ok := sensitiveKeys[key]; ok {
+		return true
+	}
+
+	for _, word := range strings.Split(key, "_") {
+		if _, ok := sensitiveWords[word]; ok {
+			return true
+		}
+	}
+	return false
+}
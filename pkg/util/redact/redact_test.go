@@ -0,0 +1,68 @@
+package redact
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	"objectstorage/pkg/util/flagext"
+)
+
+type etcdConfig struct {
+	Endpoints []string `yaml:"endpoints"`
+	Username  string   `yaml:"username"`
+	Password  string   `yaml:"password"`
+}
+
+type testConfig struct {
+	Etcd            etcdConfig     `yaml:"etcd"`
+	SecretAccessKey flagext.Secret `yaml:"secret_access_key"`
+	AccessKeyID     string         `yaml:"access_key_id"`
+	NumTokens       int            `yaml:"num_tokens"`
+	TokensFilePath  string         `yaml:"tokens_file_path"`
+	ACLToken        string         `yaml:"acl_token"`
+	Clients         []etcdConfig   `yaml:"clients"`
+}
+
+func TestYAML(t *testing.T) {
+	cfg := testConfig{
+		Etcd:            etcdConfig{Endpoints: []string{"etcd:2379"}, Username: "user", Password: "etcd-password"},
+		SecretAccessKey: flagext.Secret{Value: "s3-secret"},
+		AccessKeyID:     "AKIA",
+		NumTokens:       128,
+		TokensFilePath:  "/data/tokens",
+		ACLToken:        "consul-token",
+		Clients:         []etcdConfig{{Password: "nested-password"}, {}},
+	}
+
+	out, err := YAML(cfg)
+	require.NoError(t, err)
+
+	for _, secret := range []string{"etcd-password", "s3-secret", "consul-token", "nested-password"} {
+		assert.NotContains(t, string(out), secret)
+	}
+
+	actual := testConfig{}
+	require.NoError(t, yaml.Unmarshal(out, &actual))
+	assert.Equal(t, flagext.SecretMask, actual.Etcd.Password)
+	assert.Equal(t, flagext.SecretMask, actual.ACLToken)
+	assert.Equal(t, flagext.SecretMask, actual.Clients[0].Password)
+	assert.Equal(t, "", actual.Clients[1].Password)
+	assert.Equal(t, "user", actual.Etcd.Username)
+	assert.Equal(t, "AKIA", actual.AccessKeyID)
+	assert.Equal(t, 128, actual.NumTokens)
+	assert.Equal(t, "/data/tokens", actual.TokensFilePath)
+}
+
+func TestConfigHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ConfigHandler(&testConfig{ACLToken: "consul-token"}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/yaml", rec.Header().Get("Content-Type"))
+	assert.NotContains(t, rec.Body.String(), "consul-token")
+}
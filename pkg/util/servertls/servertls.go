@@ -0,0 +1,215 @@
+package servertls
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/server"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+var (
+	errHTTPTLSRequired  = errors.New("TLS is required but the HTTP server cert and key paths are not set")
+	errGRPCTLSRequired  = errors.New("TLS is required but the gRPC server cert and key paths are not set")
+	errInvalidMinTLS    = errors.New("invalid TLS min version")
+	errInvalidCipher    = errors.New("invalid TLS cipher suite")
+	errInvalidWatchTime = errors.New("the TLS watch period must be greater than 0")
+)
+
+// Config holds the configuration of the TLS enforcement and certificate watching of the
+// HTTP and gRPC servers. The certificates themselves are configured in the server config.
+type Config struct {
+	Required    bool          `yaml:"required"`
+	WatchPeriod time.Duration `yaml:"watch_period"`
+}
+
+// RegisterFlags registers the server TLS flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Required, "server.tls-required", false, "True to refuse to start if the HTTP or gRPC server doesn't have TLS enabled. Plaintext listeners should only be used in development.")
+	f.DurationVar(&cfg.WatchPeriod, "server.tls-watch-period", time.Minute, "How frequently the server certificate files are checked for changes. The servers load the new certificate on the next handshake; the watcher validates it and exports its expiry.")
+}
+
+// Validate the config against the server config.
+func (cfg *Config) Validate(serverCfg server.Config) error {
+	if cfg.WatchPeriod <= 0 {
+		return errInvalidWatchTime
+	}
+
+	if cfg.Required {
+		if !isEnabled(serverCfg.HTTPTLSConfig) {
+			return errHTTPTLSRequired
+		}
+		if !isEnabled(serverCfg.GRPCTLSConfig) {
+			return errGRPCTLSRequired
+		}
+	}
+
+	if serverCfg.MinVersion != "" {
+		if _, ok := tlsVersions[serverCfg.MinVersion]; !ok {
+			return errors.Wrap(errInvalidMinTLS, serverCfg.MinVersion)
+		}
+	}
+
+	if serverCfg.CipherSuites != "" {
+		if err := validateCipherSuites(serverCfg.CipherSuites); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var tlsVersions = map[string]uint16{
+	"VersionTLS10": tls.VersionTLS10,
+	"VersionTLS11": tls.VersionTLS11,
+	"VersionTLS12": tls.VersionTLS12,
+	"VersionTLS13": tls.VersionTLS13,
+}
+
+func validateCipherSuites(names string) error {
+	allowed := map[string]struct{}{}
+	for _, s := range tls.CipherSuites() {
+		allowed[s.Name] = struct{}{}
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		if _, ok := allowed[strings.TrimSpace(name)]; !ok {
+			return errors.Wrap(errInvalidCipher, name)
+		}
+	}
+	return nil
+}
+
+func isEnabled(cfg server.TLSConfig) bool {
+	return cfg.TLSCertPath != "" && cfg.TLSKeyPath != ""
+}
+
+// listener is a TLS-enabled server listener whose certificate files are watched.
+type listener struct {
+	name string
+	cfg  server.TLSConfig
+
+	// The checksums of the certificate files when last checked.
+	certSum [sha256.Size]byte
+	keySum  [sha256.Size]byte
+	caSum   [sha256.Size]byte
+}
+
+// Watcher periodically checks the certificate files of the TLS-enabled server listeners.
+// When the files change, the new certificate is validated and its expiry exported, so that
+// a broken rotation is noticed before the next handshakes start failing.
+type Watcher struct {
+	services.Service
+
+	logger log.Logger
+
+	mtx       sync.Mutex
+	listeners []*listener
+
+	expiry  *prometheus.GaugeVec
+	reloads *prometheus.CounterVec
+}
+
+// NewWatcher makes a new Watcher of the server listeners having TLS enabled.
+func NewWatcher(cfg Config, serverCfg server.Config, logger log.Logger, reg prometheus.Registerer) *Watcher {
+	w := &Watcher{
+		logger: logger,
+		expiry: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_server_tls_certificate_expiry_timestamp_seconds",
+			Help: "Unix timestamp at which the server certificate expires, by listener.",
+		}, []string{"listener"}),
+		reloads: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_server_tls_certificate_reloads_total",
+			Help: "Total number of server certificate changes detected, by listener and outcome.",
+		}, []string{"listener", "outcome"}),
+	}
+
+	if isEnabled(serverCfg.HTTPTLSConfig) {
+		w.listeners = append(w.listeners, &listener{name: "http", cfg: serverCfg.HTTPTLSConfig})
+	}
+	if isEnabled(serverCfg.GRPCTLSConfig) {
+		w.listeners = append(w.listeners, &listener{name: "grpc", cfg: serverCfg.GRPCTLSConfig})
+	}
+
+	w.Service = services.NewTimerService(cfg.WatchPeriod, w.starting, w.check, nil)
+	return w
+}
+
+func (w *Watcher) starting(ctx context.Context) error {
+	// The servers already failed to start if the certificates are invalid.
+	return w.check(ctx)
+}
+
+func (w *Watcher) check(_ context.Context) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	for _, l := range w.listeners {
+		if err := w.checkListener(l); err != nil {
+			w.reloads.WithLabelValues(l.name, "failed").Inc()
+			level.Error(w.logger).Log("msg", "invalid server certificate, TLS handshakes will fail until it's fixed", "listener", l.name, "err", err)
+		}
+	}
+	return nil
+}
+
+func (w *Watcher) checkListener(l *listener) error {
+	certPEM, err := os.ReadFile(l.cfg.TLSCertPath)
+	if err != nil {
+		return errors.Wrap(err, "read certificate")
+	}
+	keyPEM, err := os.ReadFile(l.cfg.TLSKeyPath)
+	if err != nil {
+		return errors.Wrap(err, "read key")
+	}
+
+	var caPEM []byte
+	if l.cfg.ClientCAs != "" {
+		if caPEM, err = os.ReadFile(l.cfg.ClientCAs); err != nil {
+			return errors.Wrap(err, "read client CA")
+		}
+	}
+
+	certSum, keySum, caSum := sha256.Sum256(certPEM), sha256.Sum256(keyPEM), sha256.Sum256(caPEM)
+	if certSum == l.certSum && keySum == l.keySum && caSum == l.caSum {
+		return nil
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return errors.Wrap(err, "load key pair")
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return errors.Wrap(err, "parse certificate")
+	}
+	if caPEM != nil && !x509.NewCertPool().AppendCertsFromPEM(caPEM) {
+		return errors.New("no valid certificate found in client CA file")
+	}
+
+	initial := l.certSum == [sha256.Size]byte{}
+	if !initial {
+		w.reloads.WithLabelValues(l.name, "success").Inc()
+		level.Info(w.logger).Log("msg", "server certificate changed", "listener", l.name, "not_after", leaf.NotAfter)
+	}
+	if !initial && caSum != l.caSum {
+		level.Warn(w.logger).Log("msg", "server client CA changed, a restart is required to trust the new CA", "listener", l.name)
+	}
+
+	l.certSum, l.keySum, l.caSum = certSum, keySum, caSum
+	w.expiry.WithLabelValues(l.name).Set(float64(leaf.NotAfter.Unix()))
+	return nil
+}
@@ -0,0 +1,117 @@
+package servertls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/server"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tlsCfg := server.TLSConfig{TLSCertPath: "cert.pem", TLSKeyPath: "key.pem"}
+
+	tests := map[string]struct {
+		cfg       Config
+		serverCfg server.Config
+		expected  error
+	}{
+		"plaintext allowed": {
+			cfg: Config{WatchPeriod: time.Minute},
+		},
+		"plaintext HTTP refused": {
+			cfg:       Config{Required: true, WatchPeriod: time.Minute},
+			serverCfg: server.Config{GRPCTLSConfig: tlsCfg},
+			expected:  errHTTPTLSRequired,
+		},
+		"plaintext gRPC refused": {
+			cfg:       Config{Required: true, WatchPeriod: time.Minute},
+			serverCfg: server.Config{HTTPTLSConfig: tlsCfg},
+			expected:  errGRPCTLSRequired,
+		},
+		"TLS required and enabled": {
+			cfg:       Config{Required: true, WatchPeriod: time.Minute},
+			serverCfg: server.Config{HTTPTLSConfig: tlsCfg, GRPCTLSConfig: tlsCfg, MinVersion: "VersionTLS12", CipherSuites: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_AES_128_GCM_SHA256"},
+		},
+		"invalid min version": {
+			cfg:       Config{WatchPeriod: time.Minute},
+			serverCfg: server.Config{MinVersion: "VersionSSL30"},
+			expected:  errInvalidMinTLS,
+		},
+		"invalid cipher suite": {
+			cfg:       Config{WatchPeriod: time.Minute},
+			serverCfg: server.Config{CipherSuites: "TLS_FOO"},
+			expected:  errInvalidCipher,
+		},
+		"invalid watch period": {
+			expected: errInvalidWatchTime,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, tc.cfg.Validate(tc.serverCfg), tc.expected)
+		})
+	}
+}
+
+func TestWatcher_ShouldTrackCertificateRotation(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	firstExpiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	writeCertificate(t, certPath, keyPath, firstExpiry)
+
+	serverCfg := server.Config{HTTPTLSConfig: server.TLSConfig{TLSCertPath: certPath, TLSKeyPath: keyPath}}
+	w := NewWatcher(Config{WatchPeriod: time.Minute}, serverCfg, log.NewNopLogger(), nil)
+
+	require.NoError(t, w.check(context.Background()))
+	assert.Equal(t, float64(firstExpiry.Unix()), testutil.ToFloat64(w.expiry.WithLabelValues("http")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(w.reloads.WithLabelValues("http", "success")))
+
+	secondExpiry := firstExpiry.Add(24 * time.Hour)
+	writeCertificate(t, certPath, keyPath, secondExpiry)
+
+	require.NoError(t, w.check(context.Background()))
+	assert.Equal(t, float64(secondExpiry.Unix()), testutil.ToFloat64(w.expiry.WithLabelValues("http")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(w.reloads.WithLabelValues("http", "success")))
+
+	// A key not matching the certificate is reported, and the previous expiry kept.
+	require.NoError(t, os.WriteFile(keyPath, []byte("invalid"), 0o600))
+	require.NoError(t, w.check(context.Background()))
+	assert.Equal(t, float64(secondExpiry.Unix()), testutil.ToFloat64(w.expiry.WithLabelValues("http")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(w.reloads.WithLabelValues("http", "failed")))
+}
+
+func writeCertificate(t *testing.T, certPath, keyPath string, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
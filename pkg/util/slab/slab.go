@@ -0,0 +1,64 @@
+package slab
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// Pool pools byte slabs in power of two size classes, from minSize to maxSize, so that the
+// buffers of the requests are reused across requests instead of being allocated for each of
+// them. The slabs larger than maxSize are allocated and left to the GC.
+type Pool struct {
+	minShift int
+	classes  []sync.Pool
+}
+
+// NewPool makes a new Pool. The sizes are rounded up to powers of two.
+func NewPool(minSize, maxSize int) *Pool {
+	minShift, maxShift := shift(minSize), shift(maxSize)
+	return &Pool{minShift: minShift, classes: make([]sync.Pool, maxShift-minShift+1)}
+}
+
+// Get returns a slab of length size. Its content is undefined.
+func (p *Pool) Get(size int) []byte {
+	c := p.class(size)
+	if c < 0 {
+		return make([]byte, size)
+	}
+	if b, ok := p.classes[c].Get().(*[]byte); ok {
+		return (*b)[:size]
+	}
+	return make([]byte, size, 1<<(p.minShift+c))
+}
+
+// Put releases the slab, which must not be used anymore. The slabs not returned by Get are
+// ignored.
+func (p *Pool) Put(b []byte) {
+	c := p.class(cap(b))
+	if c < 0 || cap(b) != 1<<(p.minShift+c) {
+		return
+	}
+	b = b[:0]
+	p.classes[c].Put(&b)
+}
+
+// class returns the index of the smallest class fitting size, or -1 if it's larger than
+// the largest class.
+func (p *Pool) class(size int) int {
+	c := shift(size) - p.minShift
+	if c < 0 {
+		c = 0
+	}
+	if c >= len(p.classes) {
+		return -1
+	}
+	return c
+}
+
+// shift returns the exponent of the smallest power of two greater than or equal to n.
+func shift(n int) int {
+	if n <= 1 {
+		return 0
+	}
+	return bits.Len(uint(n - 1))
+}
@@ -0,0 +1,45 @@
+package slab
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPool_Get(t *testing.T) {
+	p := NewPool(1024, 1<<20)
+
+	tests := map[string]struct {
+		size        int
+		expectedCap int
+	}{
+		"smaller than the smallest class": {size: 10, expectedCap: 1024},
+		"class size":                      {size: 4096, expectedCap: 4096},
+		"between classes":                 {size: 4097, expectedCap: 8192},
+		"largest class":                   {size: 1 << 20, expectedCap: 1 << 20},
+		"larger than the largest class":   {size: 1<<20 + 1, expectedCap: 1<<20 + 1},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := p.Get(tc.size)
+			assert.Len(t, b, tc.size)
+			assert.Equal(t, tc.expectedCap, cap(b))
+			p.Put(b)
+		})
+	}
+}
+
+func TestPool_Put(t *testing.T) {
+	p := NewPool(1024, 1<<20)
+
+	// A released slab is reused for the sizes of its class, with its length reset.
+	p.Put(p.Get(2000))
+	b := p.Get(1500)
+	assert.Len(t, b, 1500)
+	assert.Equal(t, 2048, cap(b))
+
+	// The slices not taken from the pool are ignored.
+	p.Put(make([]byte, 3000))
+	assert.Equal(t, 4096, cap(p.Get(3000)))
+}
@@ -0,0 +1,85 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/weaveworks/common/middleware"
+	weaveworks_tracing "github.com/weaveworks/common/tracing"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceIDHeader is the response header carrying the trace ID of failed requests, so that
+// users can quote it when reporting the failure.
+const TraceIDHeader = "X-Trace-Id"
+
+// Propagator returns the propagator of the W3C trace context and baggage, and of the B3
+// headers. B3 is injected with multiple headers, the encoding understood by most clients.
+func Propagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+		b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)),
+	)
+}
+
+// HTTPMiddleware returns a middleware continuing the trace of the incoming W3C or B3 trace
+// context, if not already continued by the server, so that the spans of the requests to the
+// object storage and KV store are part of the caller trace. Failed requests get the trace ID
+// in the TraceIDHeader response header.
+func HTTPMiddleware() middleware.Interface {
+	propagator := Propagator()
+
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			remote := trace.SpanContextFromContext(propagator.Extract(ctx, propagation.HeaderCarrier(r.Header)))
+			current := trace.SpanContextFromContext(ctx)
+			if remote.IsValid() && remote.TraceID() != current.TraceID() {
+				var span trace.Span
+				ctx, span = StartSpan(trace.ContextWithRemoteSpanContext(ctx, remote), "http.request",
+					attribute.String("http.method", r.Method), attribute.String("http.target", r.URL.Path))
+				defer span.End()
+				r = r.WithContext(ctx)
+			}
+
+			next.ServeHTTP(&traceIDResponseWriter{ResponseWriter: w, r: r}, r)
+		})
+	})
+}
+
+// TraceID returns the ID of the trace of the request, either an OpenTelemetry or a Jaeger
+// one depending on the tracing type, or an empty string if the request is not traced.
+func TraceID(r *http.Request) string {
+	return TraceIDFromContext(r.Context())
+}
+
+// TraceIDFromContext returns the ID of the trace in the context, or an empty string if none.
+func TraceIDFromContext(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	if traceID, ok := weaveworks_tracing.ExtractTraceID(ctx); ok {
+		return traceID
+	}
+	return ""
+}
+
+// traceIDResponseWriter sets the TraceIDHeader on error responses.
+type traceIDResponseWriter struct {
+	http.ResponseWriter
+	r *http.Request
+}
+
+func (w *traceIDResponseWriter) WriteHeader(status int) {
+	if status >= 400 {
+		if traceID := TraceID(w.r); traceID != "" {
+			w.Header().Set(TraceIDHeader, traceID)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
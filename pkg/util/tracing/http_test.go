@@ -0,0 +1,59 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	tests := map[string]struct {
+		headers  map[string]string
+		status   int
+		expected string
+	}{
+		"W3C trace context on error": {
+			headers:  map[string]string{"traceparent": "00-" + traceID + "-00f067aa0ba902b7-01"},
+			status:   http.StatusBadRequest,
+			expected: traceID,
+		},
+		"B3 headers on error": {
+			headers:  map[string]string{"X-B3-TraceId": traceID, "X-B3-SpanId": "00f067aa0ba902b7", "X-B3-Sampled": "1"},
+			status:   http.StatusInternalServerError,
+			expected: traceID,
+		},
+		"no trace ID on success": {
+			headers: map[string]string{"traceparent": "00-" + traceID + "-00f067aa0ba902b7-01"},
+			status:  http.StatusOK,
+		},
+		"untraced request": {
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var handlerTraceID string
+			handler := HTTPMiddleware().Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerTraceID = TraceID(r)
+				w.WriteHeader(tc.status)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/push", nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.expected, rec.Header().Get(TraceIDHeader))
+			if len(tc.headers) > 0 {
+				assert.Equal(t, traceID, handlerTraceID)
+			}
+		})
+	}
+}
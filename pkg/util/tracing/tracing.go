@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	cortex_tracing "github.com/cortexproject/cortex/pkg/tracing"
+)
+
+const (
+	tracerName = "objectstorage"
+
+	// jaegerOTLPPort is the port the Jaeger agent and collector receive OTLP over gRPC on.
+	jaegerOTLPPort = "4317"
+)
+
+// StartSpan starts a span with the global OpenTelemetry tracer, which exports the spans via
+// OTLP when the tracing type is otel, or is a no-op otherwise.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan records the error, if any, and ends the span.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// ApplyEnv fills the OTLP exporter settings not set in the config from the standard
+// OpenTelemetry environment variables and, for compatibility with existing deployments,
+// from the Jaeger client ones.
+func ApplyEnv(cfg *cortex_tracing.Config) {
+	applyEnv(cfg, os.Getenv)
+}
+
+func applyEnv(cfg *cortex_tracing.Config, getenv func(string) string) {
+	if !strings.EqualFold(cfg.Type, cortex_tracing.OtelType) {
+		return
+	}
+
+	if cfg.Otel.OtlpEndpoint == "" && cfg.Otel.OltpEndpoint == "" {
+		switch {
+		case getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "":
+			cfg.Otel.OtlpEndpoint = trimScheme(getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"))
+		case getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "":
+			cfg.Otel.OtlpEndpoint = trimScheme(getenv("OTEL_EXPORTER_OTLP_ENDPOINT"))
+		case getenv("JAEGER_AGENT_HOST") != "":
+			cfg.Otel.OtlpEndpoint = net.JoinHostPort(getenv("JAEGER_AGENT_HOST"), jaegerOTLPPort)
+		}
+	}
+
+	// The Jaeger sampler settings only map to a ratio for the const and probabilistic samplers.
+	param, err := strconv.ParseFloat(getenv("JAEGER_SAMPLER_PARAM"), 64)
+	if err != nil {
+		return
+	}
+	switch getenv("JAEGER_SAMPLER_TYPE") {
+	case "const":
+		if param > 0 {
+			cfg.Otel.SampleRatio = 1
+		} else {
+			cfg.Otel.SampleRatio = 0
+		}
+	case "probabilistic":
+		cfg.Otel.SampleRatio = param
+	}
+}
+
+// trimScheme returns the endpoint without the URL scheme, as expected by the OTLP gRPC exporter.
+func trimScheme(endpoint string) string {
+	if i := strings.Index(endpoint, "://"); i >= 0 {
+		return endpoint[i+3:]
+	}
+	return endpoint
+}
@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	cortex_tracing "github.com/cortexproject/cortex/pkg/tracing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	tests := map[string]struct {
+		cfg      cortex_tracing.Config
+		env      map[string]string
+		expected cortex_tracing.Otel
+	}{
+		"jaeger type is left untouched": {
+			cfg:      cortex_tracing.Config{Type: cortex_tracing.JaegerType},
+			env:      map[string]string{"JAEGER_AGENT_HOST": "jaeger"},
+			expected: cortex_tracing.Otel{},
+		},
+		"configured endpoint takes precedence": {
+			cfg:      cortex_tracing.Config{Type: cortex_tracing.OtelType, Otel: cortex_tracing.Otel{OtlpEndpoint: "collector:4317"}},
+			env:      map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://other:4317"},
+			expected: cortex_tracing.Otel{OtlpEndpoint: "collector:4317"},
+		},
+		"OpenTelemetry endpoint": {
+			cfg:      cortex_tracing.Config{Type: cortex_tracing.OtelType},
+			env:      map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4317", "JAEGER_AGENT_HOST": "jaeger"},
+			expected: cortex_tracing.Otel{OtlpEndpoint: "collector:4317"},
+		},
+		"Jaeger agent and probabilistic sampler": {
+			cfg:      cortex_tracing.Config{Type: cortex_tracing.OtelType},
+			env:      map[string]string{"JAEGER_AGENT_HOST": "jaeger", "JAEGER_SAMPLER_TYPE": "probabilistic", "JAEGER_SAMPLER_PARAM": "0.1"},
+			expected: cortex_tracing.Otel{OtlpEndpoint: "jaeger:4317", SampleRatio: 0.1},
+		},
+		"Jaeger const sampler": {
+			cfg:      cortex_tracing.Config{Type: cortex_tracing.OtelType, Otel: cortex_tracing.Otel{OtlpEndpoint: "collector:4317"}},
+			env:      map[string]string{"JAEGER_SAMPLER_TYPE": "const", "JAEGER_SAMPLER_PARAM": "1"},
+			expected: cortex_tracing.Otel{OtlpEndpoint: "collector:4317", SampleRatio: 1},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := tc.cfg
+			applyEnv(&cfg, func(key string) string { return tc.env[key] })
+			assert.Equal(t, tc.expected, cfg.Otel)
+		})
+	}
+}
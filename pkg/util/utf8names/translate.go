@@ -0,0 +1,232 @@
+package utf8names
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"objectstorage/pkg/tenant"
+)
+
+type contextKey int
+
+const escapingKey contextKey = 0
+
+// FromContext returns the escaping of the client, if the tenant has the UTF-8 names enabled.
+func FromContext(ctx context.Context) (Escaping, bool) {
+	e, ok := ctx.Value(escapingKey).(Escaping)
+	return e, ok
+}
+
+// NewContext returns a context carrying the escaping of the client.
+func NewContext(ctx context.Context, e Escaping) context.Context {
+	return context.WithValue(ctx, escapingKey, e)
+}
+
+// Middleware stores the escaping negotiated by the client in the request context, if the
+// tenant has the UTF-8 names enabled. The requests of the other tenants are unchanged.
+func Middleware(enabled func(userID string) bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userID, err := tenant.TenantID(r.Context()); err == nil && enabled(userID) {
+			r = r.WithContext(NewContext(r.Context(), ParseEscaping(r.Header.Get("Accept"))))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UnescapeMatchers reverts the Values escaping of the label names of the matchers, and of
+// the metric names of the __name__ equality matchers, so that they select the UTF-8 names.
+func UnescapeMatchers(matchers []*labels.Matcher) ([]*labels.Matcher, error) {
+	out := matchers[:0:0]
+	for _, m := range matchers {
+		name, value := UnescapeName(m.Name), m.Value
+		if name == labels.MetricName && (m.Type == labels.MatchEqual || m.Type == labels.MatchNotEqual) {
+			value = UnescapeName(value)
+		}
+		if name == m.Name && value == m.Value {
+			out = append(out, m)
+			continue
+		}
+
+		unescaped, err := labels.NewMatcher(m.Type, name, value)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, unescaped)
+	}
+	return out, nil
+}
+
+// EscapeLabels escapes the label names and the metric name of the series. The labels are
+// returned unchanged if they're all valid in the legacy charset.
+func EscapeLabels(lbls labels.Labels, e Escaping) labels.Labels {
+	if e == AllowUTF8 {
+		return lbls
+	}
+
+	changed := false
+	lbls.Range(func(l labels.Label) {
+		if EscapeLabelName(l.Name, e) != l.Name || (l.Name == labels.MetricName && EscapeMetricName(l.Value, e) != l.Value) {
+			changed = true
+		}
+	})
+	if !changed {
+		return lbls
+	}
+
+	b := labels.NewScratchBuilder(lbls.Len())
+	lbls.Range(func(l labels.Label) {
+		if l.Name == labels.MetricName {
+			b.Add(l.Name, EscapeMetricName(l.Value, e))
+			return
+		}
+		b.Add(EscapeLabelName(l.Name, e), l.Value)
+	})
+	b.Sort()
+	return b.Labels()
+}
+
+// Queryable returns the queryable translating the names for the client in the context of
+// the queriers: the escaped names of the matchers are unescaped, and the names of the
+// selected series are escaped.
+func Queryable(queryable storage.Queryable) storage.Queryable {
+	return storage.QueryableFunc(func(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+		q, err := queryable.Querier(ctx, mint, maxt)
+		if err != nil {
+			return nil, err
+		}
+		if e, ok := FromContext(ctx); ok {
+			return querier{Querier: q, escaping: e}, nil
+		}
+		return q, nil
+	})
+}
+
+type querier struct {
+	storage.Querier
+
+	escaping Escaping
+}
+
+func (q querier) Select(sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	matchers, err := UnescapeMatchers(matchers)
+	if err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+
+	set := q.Querier.Select(sortSeries, hints, matchers...)
+	if q.escaping == AllowUTF8 {
+		return set
+	}
+	if !sortSeries {
+		return escapedSeriesSet{SeriesSet: set, escaping: q.escaping}
+	}
+
+	// The escaping changes the order of the series, so they're sorted again.
+	var series []storage.Series
+	for set.Next() {
+		series = append(series, newEscapedSeries(set.At(), q.escaping))
+	}
+	if err := set.Err(); err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+	sort.Slice(series, func(i, j int) bool { return labels.Compare(series[i].Labels(), series[j].Labels()) < 0 })
+	return &sliceSeriesSet{series: series, warnings: set.Warnings(), i: -1}
+}
+
+func (q querier) LabelValues(name string, matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	matchers, err := UnescapeMatchers(matchers)
+	if err != nil {
+		return nil, nil, err
+	}
+	name = UnescapeName(name)
+	values, warnings, err := q.Querier.LabelValues(name, matchers...)
+	if err != nil || name != labels.MetricName {
+		return values, warnings, err
+	}
+	return EscapeValues(values, q.escaping), warnings, nil
+}
+
+func (q querier) LabelNames(matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	matchers, err := UnescapeMatchers(matchers)
+	if err != nil {
+		return nil, nil, err
+	}
+	names, warnings, err := q.Querier.LabelNames(matchers...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return EscapeNames(names, q.escaping), warnings, nil
+}
+
+// EscapeNames escapes the label names, keeping them sorted and unique.
+func EscapeNames(names []string, e Escaping) []string {
+	return escapeAll(names, e, EscapeLabelName)
+}
+
+// EscapeValues escapes the metric names, keeping them sorted and unique.
+func EscapeValues(values []string, e Escaping) []string {
+	return escapeAll(values, e, EscapeMetricName)
+}
+
+func escapeAll(names []string, e Escaping, escape func(string, Escaping) string) []string {
+	if e == AllowUTF8 {
+		return names
+	}
+
+	out := make([]string, 0, len(names))
+	seen := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		escaped := escape(name, e)
+		if _, ok := seen[escaped]; ok {
+			continue
+		}
+		seen[escaped] = struct{}{}
+		out = append(out, escaped)
+	}
+	sort.Strings(out)
+	return out
+}
+
+type escapedSeriesSet struct {
+	storage.SeriesSet
+
+	escaping Escaping
+}
+
+func (s escapedSeriesSet) At() storage.Series {
+	return newEscapedSeries(s.SeriesSet.At(), s.escaping)
+}
+
+// escapedSeries is a series with the escaped labels, computed once.
+type escapedSeries struct {
+	storage.Series
+
+	lbls labels.Labels
+}
+
+func newEscapedSeries(series storage.Series, e Escaping) escapedSeries {
+	return escapedSeries{Series: series, lbls: EscapeLabels(series.Labels(), e)}
+}
+
+func (s escapedSeries) Labels() labels.Labels {
+	return s.lbls
+}
+
+type sliceSeriesSet struct {
+	series   []storage.Series
+	warnings storage.Warnings
+	i        int
+}
+
+func (s *sliceSeriesSet) Next() bool {
+	s.i++
+	return s.i < len(s.series)
+}
+
+func (s *sliceSeriesSet) At() storage.Series         { return s.series[s.i] }
+func (s *sliceSeriesSet) Err() error                 { return nil }
+func (s *sliceSeriesSet) Warnings() storage.Warnings { return s.warnings }
@@ -0,0 +1,282 @@
+// Package utf8names implements the Prometheus UTF-8 metric and label names proposal: the
+// validation of the UTF-8 names, the quoted names syntax of the selectors, and the escaping
+// of the names for the clients only supporting the legacy charset.
+package utf8names
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Escaping is the translation of the UTF-8 names for a client, as negotiated via the
+// escaping parameter of the Accept header.
+type Escaping string
+
+// Escaping schemes of the proposal.
+const (
+	// AllowUTF8 leaves the names unchanged, for the clients supporting UTF-8 names.
+	AllowUTF8 Escaping = "allow-utf-8"
+	// Underscores replaces the invalid characters with underscores. It isn't reversible.
+	Underscores Escaping = "underscores"
+	// Dots replaces the dots with _dot_ and the other invalid characters with __. It isn't
+	// reversible.
+	Dots Escaping = "dots"
+	// Values prefixes the name with U__ and replaces the invalid characters with their code
+	// point in hex between underscores. It's reversible, so it's the default.
+	Values Escaping = "values"
+)
+
+// escapedPrefix is the prefix of the names escaped with Values.
+const escapedPrefix = "U__"
+
+// IsValidMetricName returns whether the metric name is valid, in the legacy charset or as
+// UTF-8 if utf8Names is true.
+func IsValidMetricName(name string, utf8Names bool) bool {
+	if utf8Names {
+		return name != "" && utf8.ValidString(name)
+	}
+	return isLegacy(name, isLegacyMetricRune)
+}
+
+// IsValidLabelName returns whether the label name is valid, in the legacy charset or as
+// UTF-8 if utf8Names is true.
+func IsValidLabelName(name string, utf8Names bool) bool {
+	if utf8Names {
+		return name != "" && utf8.ValidString(name)
+	}
+	return isLegacy(name, isLegacyLabelRune)
+}
+
+func isLegacyMetricRune(r rune, i int) bool {
+	return r == ':' || isLegacyLabelRune(r, i)
+}
+
+func isLegacyLabelRune(r rune, i int) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_' || (r >= '0' && r <= '9' && i > 0)
+}
+
+func isLegacy(name string, valid func(rune, int) bool) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !valid(r, i) {
+			return false
+		}
+	}
+	return true
+}
+
+// EscapeMetricName escapes the metric name, unless valid in the legacy charset.
+func EscapeMetricName(name string, e Escaping) string {
+	return escape(name, e, isLegacyMetricRune)
+}
+
+// EscapeLabelName escapes the label name, unless valid in the legacy charset.
+func EscapeLabelName(name string, e Escaping) string {
+	return escape(name, e, isLegacyLabelRune)
+}
+
+func escape(name string, e Escaping, valid func(rune, int) bool) string {
+	if name == "" || e == AllowUTF8 || isLegacy(name, valid) {
+		return name
+	}
+
+	var b strings.Builder
+	switch e {
+	case Underscores:
+		for i, r := range name {
+			if valid(r, i) {
+				b.WriteRune(r)
+			} else {
+				b.WriteByte('_')
+			}
+		}
+	case Dots:
+		for i, r := range name {
+			switch {
+			case r == '_':
+				b.WriteString("__")
+			case r == '.':
+				b.WriteString("_dot_")
+			case valid(r, i):
+				b.WriteRune(r)
+			default:
+				b.WriteString("__")
+			}
+		}
+	default:
+		b.WriteString(escapedPrefix)
+		for i, r := range name {
+			switch {
+			case r == '_':
+				b.WriteString("__")
+			case valid(r, i):
+				b.WriteRune(r)
+			default:
+				fmt.Fprintf(&b, "_%x_", r)
+			}
+		}
+	}
+	return b.String()
+}
+
+// UnescapeName reverts the Values escaping of the name. The names not escaped, or not
+// escaped correctly, are returned unchanged.
+func UnescapeName(name string) string {
+	if !strings.HasPrefix(name, escapedPrefix) {
+		return name
+	}
+
+	escaped := name[len(escapedPrefix):]
+	var b strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] != '_' {
+			b.WriteByte(escaped[i])
+			continue
+		}
+		if i+1 < len(escaped) && escaped[i+1] == '_' {
+			b.WriteByte('_')
+			i++
+			continue
+		}
+
+		end := strings.IndexByte(escaped[i+1:], '_')
+		if end <= 0 {
+			return name
+		}
+		code, err := strconv.ParseUint(escaped[i+1:i+1+end], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return name
+		}
+		b.WriteRune(rune(code))
+		i += end + 1
+	}
+
+	if unescaped := b.String(); unescaped != "" && utf8.ValidString(unescaped) {
+		return unescaped
+	}
+	return name
+}
+
+// ParseEscaping returns the escaping requested by the escaping parameter of the Accept
+// header, or Values if none is.
+func ParseEscaping(accept string) Escaping {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		for _, param := range strings.Split(mediaRange, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || strings.TrimSpace(key) != "escaping" {
+				continue
+			}
+			switch e := Escaping(strings.Trim(strings.TrimSpace(value), `"`)); e {
+			case AllowUTF8, Underscores, Dots, Values:
+				return e
+			}
+		}
+	}
+	return Values
+}
+
+// RewriteQuotedNames rewrites the quoted names of the selectors of the query in the legacy
+// syntax, so that the query can be parsed: {"http.server.duration", "k8s.pod"="a"} becomes
+// {__name__="http.server.duration", U__k8s_2e_pod="a"}. The label names are escaped with
+// Values, to be unescaped from the parsed matchers.
+func RewriteQuotedNames(query string) string {
+	var (
+		b       strings.Builder
+		depth   int
+		prev    byte
+		changed bool
+	)
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '#':
+			// Comments run to the end of the line.
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			b.WriteString(query[i : i+end])
+			i += end - 1
+			continue
+		case c == '"' || c == '\'' || c == '`':
+			end := stringEnd(query, i)
+			literal := query[i:end]
+			i = end - 1
+
+			if depth > 0 && (prev == '{' || prev == ',') {
+				next := nextSignificant(query, end)
+				switch {
+				case next == ',' || next == '}':
+					b.WriteString("__name__=")
+					b.WriteString(literal)
+					changed = true
+					prev = '"'
+					continue
+				case next == '=' || next == '!':
+					if name, ok := unquote(literal); ok {
+						b.WriteString(EscapeLabelName(name, Values))
+						changed = true
+						prev = '"'
+						continue
+					}
+				}
+			}
+			b.WriteString(literal)
+			prev = '"'
+			continue
+		case c == '{':
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+		}
+
+		b.WriteByte(c)
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			prev = c
+		}
+	}
+
+	if !changed {
+		return query
+	}
+	return b.String()
+}
+
+// stringEnd returns the index after the end of the string literal starting at start, or the
+// length of the query if unterminated.
+func stringEnd(query string, start int) int {
+	quote := query[start]
+	for i := start + 1; i < len(query); i++ {
+		switch {
+		case query[i] == '\\' && quote != '`':
+			i++
+		case query[i] == quote:
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+func nextSignificant(query string, from int) byte {
+	for i := from; i < len(query); i++ {
+		if c := query[i]; c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			return c
+		}
+	}
+	return 0
+}
+
+// unquote returns the value of the string literal, in any of the PromQL quotes.
+func unquote(literal string) (string, bool) {
+	if literal[0] == '\'' && len(literal) >= 2 {
+		inner := strings.ReplaceAll(literal[1:len(literal)-1], `\'`, `'`)
+		literal = `"` + strings.ReplaceAll(inner, `"`, `\"`) + `"`
+	}
+	s, err := strconv.Unquote(literal)
+	return s, err == nil
+}
@@ -0,0 +1,198 @@
+package utf8names
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+)
+
+func TestIsValidName(t *testing.T) {
+	assert.True(t, IsValidMetricName("http_requests:rate5m", false))
+	assert.False(t, IsValidMetricName("http.server.duration", false))
+	assert.True(t, IsValidMetricName("http.server.duration", true))
+	assert.False(t, IsValidMetricName("", true))
+	assert.False(t, IsValidMetricName("up\xff", true))
+
+	assert.True(t, IsValidLabelName("job", false))
+	assert.False(t, IsValidLabelName("job:name", false))
+	assert.False(t, IsValidLabelName("1job", false))
+	assert.True(t, IsValidLabelName("k8s.pod", true))
+}
+
+func TestEscapeName(t *testing.T) {
+	tests := map[string]struct {
+		name     string
+		escaping Escaping
+		expected string
+	}{
+		"legacy name":  {name: "http_requests_total", escaping: Values, expected: "http_requests_total"},
+		"allow utf-8":  {name: "http.server.duration", escaping: AllowUTF8, expected: "http.server.duration"},
+		"underscores":  {name: "http.server.duration", escaping: Underscores, expected: "http_server_duration"},
+		"dots":         {name: "http.server_duration", escaping: Dots, expected: "http_dot_server__duration"},
+		"values":       {name: "http.server_duration", escaping: Values, expected: "U__http_2e_server__duration"},
+		"values emoji": {name: "up🔥", escaping: Values, expected: "U__up_1f525_"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, EscapeMetricName(tc.name, tc.escaping))
+			if tc.escaping == Values {
+				assert.Equal(t, tc.name, UnescapeName(tc.expected))
+			}
+		})
+	}
+
+	// The colon is only valid in the metric names.
+	assert.Equal(t, "U__job_3a_name", EscapeLabelName("job:name", Values))
+	assert.Equal(t, "job:name", EscapeMetricName("job:name", Values))
+}
+
+func TestUnescapeName_Invalid(t *testing.T) {
+	for _, name := range []string{"up", "U__", "U__http_2e", "U__http_zz_", "U__http_110000_"} {
+		assert.Equal(t, name, UnescapeName(name), name)
+	}
+}
+
+func TestParseEscaping(t *testing.T) {
+	assert.Equal(t, Values, ParseEscaping(""))
+	assert.Equal(t, Values, ParseEscaping("application/json"))
+	assert.Equal(t, AllowUTF8, ParseEscaping("application/json; escaping=allow-utf-8"))
+	assert.Equal(t, Underscores, ParseEscaping(`text/plain, application/json;q=0.9;escaping="underscores"`))
+	assert.Equal(t, Values, ParseEscaping("application/json; escaping=unknown"))
+}
+
+func TestRewriteQuotedNames(t *testing.T) {
+	tests := map[string]struct {
+		query    string
+		expected string
+	}{
+		"legacy query": {
+			query:    `sum by (job) (rate(http_requests_total{job="api"}[5m]))`,
+			expected: `sum by (job) (rate(http_requests_total{job="api"}[5m]))`,
+		},
+		"quoted metric name": {
+			query:    `{"http.server.duration"}`,
+			expected: `{__name__="http.server.duration"}`,
+		},
+		"quoted label names": {
+			query:    `rate({"http.server.duration", "k8s.pod"=~'a.*', job!="b"}[5m])`,
+			expected: `rate({__name__="http.server.duration", U__k8s_2e_pod=~'a.*', job!="b"}[5m])`,
+		},
+		"strings outside the selectors": {
+			query:    `label_replace({"k8s.pod"="a"}, "dst", "$1", "src", "(.*)")`,
+			expected: `label_replace({U__k8s_2e_pod="a"}, "dst", "$1", "src", "(.*)")`,
+		},
+		"comments": {
+			query:    "up # {\"a.b\"}\n",
+			expected: "up # {\"a.b\"}\n",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rewritten := RewriteQuotedNames(tc.query)
+			assert.Equal(t, tc.expected, rewritten)
+
+			_, err := parser.ParseExpr(rewritten)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestUnescapeMatchers(t *testing.T) {
+	matchers, err := parser.ParseMetricSelector(RewriteQuotedNames(`U__http_2e_server{"k8s.pod"="a", job=~"U__b_2e_c"}`))
+	require.NoError(t, err)
+
+	unescaped, err := UnescapeMatchers(matchers)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{`__name__="http.server"`, `k8s.pod="a"`, `job=~"U__b_2e_c"`}, matcherStrings(unescaped))
+
+	// The input matchers are left unchanged.
+	assert.Contains(t, matcherStrings(matchers), `__name__="U__http_2e_server"`)
+}
+
+func matcherStrings(matchers []*labels.Matcher) []string {
+	out := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		out = append(out, m.String())
+	}
+	return out
+}
+
+func TestEscapeLabels(t *testing.T) {
+	lbls := labels.FromStrings("__name__", "http.server.duration", "a.z", "1", "b", "2")
+	assert.Equal(t, lbls, EscapeLabels(lbls, AllowUTF8))
+	assert.Equal(t, labels.FromStrings("__name__", "http_server_duration", "a_z", "1", "b", "2"), EscapeLabels(lbls, Underscores))
+
+	legacy := labels.FromStrings("__name__", "up", "job", "a")
+	assert.Equal(t, legacy, EscapeLabels(legacy, Values))
+}
+
+func TestMiddleware(t *testing.T) {
+	var (
+		escaping Escaping
+		ok       bool
+	)
+	handler := Middleware(func(userID string) bool { return userID == "enabled" }, http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		escaping, ok = FromContext(r.Context())
+	}))
+
+	for userID, expected := range map[string]bool{"enabled": true, "disabled": false} {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/labels", nil)
+		r.Header.Set("Accept", "application/json; escaping=dots")
+		handler.ServeHTTP(httptest.NewRecorder(), r.WithContext(user.InjectOrgID(r.Context(), userID)))
+
+		assert.Equal(t, expected, ok, userID)
+		if expected {
+			assert.Equal(t, Dots, escaping)
+		}
+	}
+}
+
+func TestQueryable(t *testing.T) {
+	series := []storage.Series{
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "job", "a"), nil),
+		storage.NewListSeries(labels.FromStrings("__name__", "http.server.duration", "k8s.pod", "a"), nil),
+	}
+	var selected []*labels.Matcher
+	queryable := Queryable(storage.QueryableFunc(func(context.Context, int64, int64) (storage.Querier, error) {
+		return querierMock{series: series, selected: &selected}, nil
+	}))
+
+	q, err := queryable.Querier(NewContext(context.Background(), Values), 0, 1)
+	require.NoError(t, err)
+	set := q.Select(true, nil, labels.MustNewMatcher(labels.MatchEqual, "U__k8s_2e_pod", "a"))
+
+	var got []string
+	for set.Next() {
+		got = append(got, set.At().Labels().String())
+	}
+	require.NoError(t, set.Err())
+	assert.Equal(t, []string{`{U__k8s_2e_pod="a", __name__="U__http_2e_server_2e_duration"}`, `{__name__="up", job="a"}`}, got)
+	assert.Equal(t, []string{`k8s.pod="a"`}, matcherStrings(selected))
+
+	// The queriers of the tenants without UTF-8 names aren't wrapped.
+	q, err = queryable.Querier(context.Background(), 0, 1)
+	require.NoError(t, err)
+	assert.IsType(t, querierMock{}, q)
+}
+
+type querierMock struct {
+	storage.Querier
+
+	series   []storage.Series
+	selected *[]*labels.Matcher
+}
+
+func (q querierMock) Select(_ bool, _ *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	*q.selected = matchers
+	return &sliceSeriesSet{series: q.series, i: -1}
+}
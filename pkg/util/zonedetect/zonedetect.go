@@ -0,0 +1,206 @@
+package zonedetect
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+// The providers the zone can be detected from.
+const (
+	ProviderEnv   = "env"
+	ProviderEC2   = "ec2"
+	ProviderGCE   = "gce"
+	ProviderAzure = "azure"
+)
+
+// The instance metadata endpoints, only reachable from the instances.
+const (
+	ec2MetadataURL   = "http://169.254.169.254"
+	gceMetadataURL   = "http://metadata.google.internal"
+	azureMetadataURL = "http://169.254.169.254"
+)
+
+var (
+	errInvalidTimeout = errors.New("the zone detection timeout must be greater than 0")
+	errNoProvider     = errors.New("the zone detection requires at least one provider")
+	errZoneNotFound   = errors.New("zone not found")
+)
+
+// Config holds the configuration of the zone detection.
+type Config struct {
+	Enabled   bool                   `yaml:"enabled"`
+	Providers flagext.StringSliceCSV `yaml:"providers"`
+	EnvVar    string                 `yaml:"env_var"`
+	Timeout   time.Duration          `yaml:"timeout"`
+}
+
+// RegisterFlags registers the zone detection flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	cfg.Providers = []string{ProviderEnv, ProviderEC2, ProviderGCE, ProviderAzure}
+	f.BoolVar(&cfg.Enabled, "zone-detection.enabled", false, "True to detect the availability zone of the ingester, used in the ring, when -ingester.availability-zone isn't set. The startup fails if no zone is detected.")
+	f.Var(&cfg.Providers, "zone-detection.providers", "Comma-separated providers the zone is detected from, tried in order: env, the environment variable set with -zone-detection.env-var, such as from the Kubernetes downward API, ec2, gce and azure, the instance metadata of the cloud provider.")
+	f.StringVar(&cfg.EnvVar, "zone-detection.env-var", "AVAILABILITY_ZONE", "Environment variable the zone is read from by the env provider.")
+	f.DurationVar(&cfg.Timeout, "zone-detection.timeout", 2*time.Second, "Timeout of the requests to the instance metadata of each provider.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if len(cfg.Providers) == 0 {
+		return errNoProvider
+	}
+	for _, p := range cfg.Providers {
+		switch p {
+		case ProviderEnv, ProviderEC2, ProviderGCE, ProviderAzure:
+		default:
+			return fmt.Errorf("unknown zone detection provider %q", p)
+		}
+	}
+	if cfg.Timeout <= 0 {
+		return errInvalidTimeout
+	}
+	return nil
+}
+
+// Detect returns the zone of the instance, from the first provider it's found from.
+func Detect(ctx context.Context, cfg Config, logger log.Logger) (string, error) {
+	d := detector{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		getenv:   os.Getenv,
+		ec2URL:   ec2MetadataURL,
+		gceURL:   gceMetadataURL,
+		azureURL: azureMetadataURL,
+	}
+	return d.detect(ctx, logger)
+}
+
+type detector struct {
+	cfg    Config
+	client *http.Client
+	getenv func(string) string
+
+	ec2URL, gceURL, azureURL string
+}
+
+func (d detector) detect(ctx context.Context, logger log.Logger) (string, error) {
+	var errs []string
+	for _, p := range d.cfg.Providers {
+		var (
+			zone string
+			err  error
+		)
+		switch p {
+		case ProviderEnv:
+			zone = d.getenv(d.cfg.EnvVar)
+		case ProviderEC2:
+			zone, err = d.ec2(ctx)
+		case ProviderGCE:
+			zone, err = d.gce(ctx)
+		case ProviderAzure:
+			zone, err = d.azure(ctx)
+		}
+		if err == nil && zone == "" {
+			err = errZoneNotFound
+		}
+		if err != nil {
+			level.Debug(logger).Log("msg", "zone not detected", "provider", p, "err", err)
+			errs = append(errs, fmt.Sprintf("%s: %v", p, err))
+			continue
+		}
+
+		level.Info(logger).Log("msg", "zone detected", "provider", p, "zone", zone)
+		return zone, nil
+	}
+	return "", errors.Errorf("zone not detected from any provider: %s", strings.Join(errs, "; "))
+}
+
+// ec2 returns the zone from the EC2 instance metadata, through IMDSv2.
+func (d detector) ec2(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, d.ec2URL+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	token, err := d.do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "get metadata token")
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, d.ec2URL+"/latest/meta-data/placement/availability-zone", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	return d.do(req)
+}
+
+// gce returns the zone from the GCE instance metadata, which is the full path of the zone.
+func (d detector) gce(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.gceURL+"/computeMetadata/v1/instance/zone", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	zone, err := d.do(req)
+	if err != nil {
+		return "", err
+	}
+	return path.Base(zone), nil
+}
+
+// azure returns the zone from the Azure instance metadata. The Azure zones are numbers
+// within the region, so the zone is prefixed with the region.
+func (d detector) azure(ctx context.Context) (string, error) {
+	get := func(field string) (string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.azureURL+"/metadata/instance/compute/"+field+"?api-version=2021-02-01&format=text", nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata", "true")
+		return d.do(req)
+	}
+
+	zone, err := get("zone")
+	if err != nil || zone == "" {
+		// The instance isn't in an availability zone.
+		return "", err
+	}
+	location, err := get("location")
+	if err != nil {
+		return "", err
+	}
+	return location + "-" + zone, nil
+}
+
+func (d detector) do(req *http.Request) (string, error) {
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
@@ -0,0 +1,162 @@
+package zonedetect
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		cfg      Config
+		expected string
+	}{
+		"disabled": {
+			cfg: Config{},
+		},
+		"enabled": {
+			cfg: Config{Enabled: true, Providers: []string{ProviderEnv, ProviderEC2}, Timeout: time.Second},
+		},
+		"no provider": {
+			cfg:      Config{Enabled: true, Timeout: time.Second},
+			expected: errNoProvider.Error(),
+		},
+		"unknown provider": {
+			cfg:      Config{Enabled: true, Providers: []string{"openstack"}, Timeout: time.Second},
+			expected: `unknown zone detection provider "openstack"`,
+		},
+		"invalid timeout": {
+			cfg:      Config{Enabled: true, Providers: []string{ProviderEnv}},
+			expected: errInvalidTimeout.Error(),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if tc.expected == "" {
+				assert.NoError(t, tc.cfg.Validate())
+			} else {
+				assert.EqualError(t, tc.cfg.Validate(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	ec2 := http.NewServeMux()
+	ec2.HandleFunc("/latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		_, _ = w.Write([]byte("token"))
+	})
+	ec2.HandleFunc("/latest/meta-data/placement/availability-zone", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-aws-ec2-metadata-token") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("eu-west-1b"))
+	})
+	gce := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Path != "/computeMetadata/v1/instance/zone" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte("projects/123456/zones/us-central1-a\n"))
+	})
+	azure := func(zone string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Metadata") != "true" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			switch r.URL.Path {
+			case "/metadata/instance/compute/zone":
+				_, _ = w.Write([]byte(zone))
+			case "/metadata/instance/compute/location":
+				_, _ = w.Write([]byte("westeurope"))
+			}
+		}
+	}
+	notFound := http.NotFoundHandler()
+
+	tests := map[string]struct {
+		providers []string
+		env       string
+		ec2, gce  http.Handler
+		azure     http.Handler
+		expected  string
+		err       string
+	}{
+		"env": {
+			providers: []string{ProviderEnv, ProviderEC2},
+			env:       "zone-a",
+			ec2:       ec2,
+			expected:  "zone-a",
+		},
+		"env not set, falls back to ec2": {
+			providers: []string{ProviderEnv, ProviderEC2},
+			ec2:       ec2,
+			expected:  "eu-west-1b",
+		},
+		"gce": {
+			providers: []string{ProviderEC2, ProviderGCE},
+			ec2:       notFound,
+			gce:       gce,
+			expected:  "us-central1-a",
+		},
+		"azure": {
+			providers: []string{ProviderAzure},
+			azure:     azure("2"),
+			expected:  "westeurope-2",
+		},
+		"azure instance not in a zone": {
+			providers: []string{ProviderAzure},
+			azure:     azure(""),
+			err:       "zone not detected from any provider: azure: zone not found",
+		},
+		"not detected": {
+			providers: []string{ProviderEnv, ProviderEC2},
+			ec2:       notFound,
+			err:       "zone not detected from any provider: env: zone not found; ec2: get metadata token: PUT /latest/api/token: 404 Not Found",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := detector{
+				cfg:    Config{Enabled: true, Providers: tc.providers, EnvVar: "AVAILABILITY_ZONE"},
+				client: &http.Client{Timeout: time.Second},
+				getenv: func(name string) string {
+					if name == "AVAILABILITY_ZONE" {
+						return tc.env
+					}
+					return ""
+				},
+			}
+			for _, s := range []struct {
+				handler http.Handler
+				url     *string
+			}{{tc.ec2, &d.ec2URL}, {tc.gce, &d.gceURL}, {tc.azure, &d.azureURL}} {
+				if s.handler == nil {
+					continue
+				}
+				server := httptest.NewServer(s.handler)
+				t.Cleanup(server.Close)
+				*s.url = server.URL
+			}
+
+			zone, err := d.detect(context.Background(), log.NewNopLogger())
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, zone)
+		})
+	}
+}
@@ -0,0 +1,260 @@
+// Package webhook notifies the configured webhooks of the block lifecycle events, so that
+// the downstream jobs can be triggered by the fresh blocks instead of polling the bucket.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/cortexproject/cortex/pkg/util/backoff"
+	cortex_flagext "github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/services"
+
+	"objectstorage/pkg/util/flagext"
+)
+
+// Types of the block lifecycle events.
+const (
+	EventBlockCut               = "block_cut"
+	EventBlockShipped           = "block_shipped"
+	EventBlockMarkedForDeletion = "block_marked_for_deletion"
+)
+
+// Headers of the webhook requests.
+const (
+	EventHeader     = "X-Webhook-Event"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+var (
+	errMissingSecret     = errors.New("the webhook secret is required to sign the notifications")
+	errInvalidTimeout    = errors.New("the webhook timeout must be greater than 0")
+	errInvalidQueueSize  = errors.New("the webhook queue size must be greater than 0")
+	errInvalidMaxRetries = errors.New("the webhook max retries must be 0 or greater")
+)
+
+// Config holds the configuration of the webhook notifications.
+type Config struct {
+	URLs       cortex_flagext.StringSliceCSV `yaml:"urls"`
+	Secret     flagext.Secret                `yaml:"secret"`
+	Timeout    time.Duration                 `yaml:"timeout"`
+	QueueSize  int                           `yaml:"queue_size"`
+	MaxRetries int                           `yaml:"max_retries"`
+}
+
+// RegisterFlags registers the webhook flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.Var(&cfg.URLs, "webhook.urls", "Comma-separated list of URLs the block lifecycle events are posted to, as JSON: the blocks cut and shipped by the shipper, and marked for deletion as found by the bucket index. Empty to disable.")
+	f.Var(&cfg.Secret, "webhook.secret", "Secret the notifications are signed with, as the hex HMAC-SHA256 of the body in the X-Webhook-Signature header, prefixed by sha256=.")
+	f.DurationVar(&cfg.Timeout, "webhook.timeout", 10*time.Second, "Timeout of each webhook request.")
+	f.IntVar(&cfg.QueueSize, "webhook.queue-size", 1000, "Maximum number of events waiting to be posted. The events notified while the queue is full are dropped, so that a slow webhook doesn't slow down the shipping.")
+	f.IntVar(&cfg.MaxRetries, "webhook.max-retries", 3, "Maximum number of retries of a failed notification, with an exponential backoff. The notification is dropped afterwards.")
+}
+
+// Enabled returns true if the webhook notifications are enabled.
+func (cfg *Config) Enabled() bool {
+	return len(cfg.URLs) > 0
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled() {
+		return nil
+	}
+	if cfg.Secret.Value == "" {
+		return errMissingSecret
+	}
+	if cfg.Timeout <= 0 {
+		return errInvalidTimeout
+	}
+	if cfg.QueueSize <= 0 {
+		return errInvalidQueueSize
+	}
+	if cfg.MaxRetries < 0 {
+		return errInvalidMaxRetries
+	}
+	return nil
+}
+
+// Event is a block lifecycle event. The size is 0 if unknown.
+type Event struct {
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	Tenant    string    `json:"tenant"`
+	ULID      ulid.ULID `json:"ulid"`
+	MinTime   int64     `json:"min_time"`
+	MaxTime   int64     `json:"max_time"`
+	SizeBytes int64     `json:"size_bytes"`
+}
+
+// Sign returns the signature of the body with the secret, as set in the SignatureHeader, so
+// that the receivers can authenticate the notifications.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notifier queues the events and posts them to the webhooks in background, in order. The
+// events are delivered at least once: a failed request is retried, and the receivers
+// should dedupe the events by type and ULID.
+type Notifier struct {
+	services.Service
+
+	cfg    Config
+	client *http.Client
+	logger log.Logger
+	queue  chan Event
+
+	// backoff of the retries. The first attempt counts as a retry.
+	backoff backoff.Config
+
+	events        *prometheus.CounterVec
+	dropped       prometheus.Counter
+	notifications *prometheus.CounterVec
+}
+
+// NewNotifier makes a new Notifier.
+func NewNotifier(cfg Config, logger log.Logger, reg prometheus.Registerer) *Notifier {
+	n := &Notifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		logger: logger,
+		queue:  make(chan Event, cfg.QueueSize),
+		backoff: backoff.Config{
+			MinBackoff: time.Second,
+			MaxBackoff: 30 * time.Second,
+			MaxRetries: cfg.MaxRetries + 1,
+		},
+		events: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_webhook_events_total",
+			Help: "Total number of block lifecycle events queued to be posted to the webhooks, by event.",
+		}, []string{"event"}),
+		dropped: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_webhook_dropped_events_total",
+			Help: "Total number of block lifecycle events dropped because the queue was full.",
+		}),
+		notifications: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_webhook_notifications_total",
+			Help: "Total number of notifications posted to the webhooks, by outcome.",
+		}, []string{"outcome"}),
+	}
+
+	n.Service = services.NewBasicService(nil, n.running, nil)
+	return n
+}
+
+// Notify queues the event. The event is dropped if the queue is full.
+func (n *Notifier) Notify(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	n.events.WithLabelValues(ev.Event).Inc()
+
+	select {
+	case n.queue <- ev:
+	default:
+		n.dropped.Inc()
+	}
+}
+
+func (n *Notifier) running(ctx context.Context) error {
+	for {
+		select {
+		case ev := <-n.queue:
+			n.post(ctx, ev)
+		case <-ctx.Done():
+			n.drain()
+			return nil
+		}
+	}
+}
+
+// drain posts the queued events before stopping, without retries, with a fresh context
+// since the service one is canceled.
+func (n *Notifier) drain() {
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.Timeout)
+	defer cancel()
+
+	for {
+		select {
+		case ev := <-n.queue:
+			body, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			for _, url := range n.cfg.URLs {
+				n.observe(ev, url, n.send(ctx, url, ev.Event, body))
+			}
+		default:
+			return
+		}
+	}
+}
+
+// post posts the event to every webhook, retrying the failed requests.
+func (n *Notifier) post(ctx context.Context, ev Event) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		level.Warn(n.logger).Log("msg", "failed to encode the webhook event", "event", ev.Event, "err", err)
+		return
+	}
+
+	for _, url := range n.cfg.URLs {
+		retries := backoff.New(ctx, n.backoff)
+		for {
+			if err = n.send(ctx, url, ev.Event, body); err == nil {
+				break
+			}
+			retries.Wait()
+			if !retries.Ongoing() {
+				break
+			}
+		}
+		n.observe(ev, url, err)
+	}
+}
+
+func (n *Notifier) observe(ev Event, url string, err error) {
+	if err != nil {
+		n.notifications.WithLabelValues("failed").Inc()
+		level.Warn(n.logger).Log("msg", "failed to post the event to the webhook", "url", url, "event", ev.Event, "tenant", ev.Tenant, "block", ev.ULID.String(), "err", err)
+		return
+	}
+	n.notifications.WithLabelValues("success").Inc()
+}
+
+func (n *Notifier) send(ctx context.Context, url, event string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(SignatureHeader, Sign(n.cfg.Secret.Value, body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
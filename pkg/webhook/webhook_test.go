@@ -0,0 +1,190 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup    func(cfg *Config)
+		expected error
+	}{
+		"disabled": {
+			setup:    func(*Config) {},
+			expected: nil,
+		},
+		"enabled": {
+			setup: func(cfg *Config) {
+				cfg.URLs = []string{"http://etl/hook"}
+				cfg.Secret.Value = "secret"
+			},
+			expected: nil,
+		},
+		"missing secret": {
+			setup:    func(cfg *Config) { cfg.URLs = []string{"http://etl/hook"} },
+			expected: errMissingSecret,
+		},
+		"invalid timeout": {
+			setup: func(cfg *Config) {
+				cfg.URLs = []string{"http://etl/hook"}
+				cfg.Secret.Value = "secret"
+				cfg.Timeout = 0
+			},
+			expected: errInvalidTimeout,
+		},
+		"invalid queue size": {
+			setup: func(cfg *Config) {
+				cfg.URLs = []string{"http://etl/hook"}
+				cfg.Secret.Value = "secret"
+				cfg.QueueSize = 0
+			},
+			expected: errInvalidQueueSize,
+		},
+		"invalid max retries": {
+			setup: func(cfg *Config) {
+				cfg.URLs = []string{"http://etl/hook"}
+				cfg.Secret.Value = "secret"
+				cfg.MaxRetries = -1
+			},
+			expected: errInvalidMaxRetries,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+			assert.ErrorIs(t, cfg.Validate(), tc.expected)
+		})
+	}
+}
+
+// webhookMock records the events received, failing the first requests.
+type webhookMock struct {
+	mtx      sync.Mutex
+	failures int
+	events   []Event
+	requests int
+}
+
+func (m *webhookMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.requests++
+	if m.failures > 0 {
+		m.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	if r.Header.Get(SignatureHeader) != Sign("secret", body) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var ev Event
+	if err := json.Unmarshal(body, &ev); err != nil || r.Header.Get(EventHeader) != ev.Event {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	m.events = append(m.events, ev)
+}
+
+func (m *webhookMock) received() []Event {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return append([]Event(nil), m.events...)
+}
+
+func newTestNotifier(urls ...string) *Notifier {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.URLs = urls
+	cfg.Secret.Value = "secret"
+
+	n := NewNotifier(cfg, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	n.backoff.MinBackoff = time.Millisecond
+	n.backoff.MaxBackoff = time.Millisecond
+	return n
+}
+
+func TestNotifier(t *testing.T) {
+	mock1, mock2 := &webhookMock{}, &webhookMock{failures: 2}
+	srv1, srv2 := httptest.NewServer(mock1), httptest.NewServer(mock2)
+	defer srv1.Close()
+	defer srv2.Close()
+
+	n := newTestNotifier(srv1.URL, srv2.URL)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), n))
+
+	id := ulid.MustNew(1, nil)
+	n.Notify(Event{Event: EventBlockCut, Tenant: "user-1", ULID: id, MinTime: 10, MaxTime: 20, SizeBytes: 100})
+	n.Notify(Event{Event: EventBlockShipped, Tenant: "user-1", ULID: id, MinTime: 10, MaxTime: 20, SizeBytes: 100})
+
+	require.Eventually(t, func() bool { return len(mock2.received()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), n))
+
+	// Both webhooks receive the events in order, the failed requests being retried.
+	for _, mock := range []*webhookMock{mock1, mock2} {
+		events := mock.received()
+		require.Len(t, events, 2)
+		assert.Equal(t, EventBlockCut, events[0].Event)
+		assert.Equal(t, EventBlockShipped, events[1].Event)
+		assert.Equal(t, "user-1", events[0].Tenant)
+		assert.Equal(t, id, events[0].ULID)
+		assert.Equal(t, int64(10), events[0].MinTime)
+		assert.Equal(t, int64(20), events[0].MaxTime)
+		assert.Equal(t, int64(100), events[0].SizeBytes)
+		assert.False(t, events[0].Time.IsZero())
+	}
+	assert.Equal(t, 4, mock2.requests)
+	assert.Equal(t, float64(4), testutil.ToFloat64(n.notifications.WithLabelValues("success")))
+}
+
+func TestNotifier_GivesUpAfterMaxRetries(t *testing.T) {
+	mock := &webhookMock{failures: 10}
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+
+	n := newTestNotifier(srv.URL)
+	n.post(context.Background(), Event{Event: EventBlockCut, Tenant: "user-1"})
+
+	// The first attempt, followed by the retries.
+	assert.Equal(t, 1+n.cfg.MaxRetries, mock.requests)
+	assert.Equal(t, float64(1), testutil.ToFloat64(n.notifications.WithLabelValues("failed")))
+}
+
+func TestNotifier_DropsTheEventsWhenTheQueueIsFull(t *testing.T) {
+	n := newTestNotifier("http://etl/hook")
+	for i := 0; i < n.cfg.QueueSize+2; i++ {
+		n.Notify(Event{Event: EventBlockCut})
+	}
+
+	assert.Equal(t, float64(n.cfg.QueueSize+2), testutil.ToFloat64(n.events.WithLabelValues(EventBlockCut)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(n.dropped))
+}
+
+func TestSign(t *testing.T) {
+	// Computed with: printf '{}' | openssl dgst -sha256 -hmac secret
+	assert.Equal(t, "sha256=77325902caca812dc259733aacd046b73817372c777b8d95b402647474516e13", Sign("secret", []byte("{}")))
+}